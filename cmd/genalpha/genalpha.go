@@ -1,21 +1,145 @@
+// Command genalpha writes letters for testing the reverser's edge
+// cases: no lines at all, very long lines, bad bytes, and sizes on
+// chunk boundaries.
+//
+// Usage:
+//
+//	genalpha [flags] [COUNT]
+//
+// Without flags, it writes COUNT (default 20) letters to standard
+// output, with no newlines, as it always has: runs of 50 of each
+// letter, a through z, then A through Z.  Flags add lines and the
+// other edge cases; the same -seed gives the same bytes.
 package main
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"math/rand"
 	"os"
 	"strconv"
 )
 
+// Line length distributions
+const (
+	distExponential = "exp"     // Exponential about the mean
+	distFixed       = "fixed"   // Every line the mean length
+	distUniform     = "uniform" // Uniform from 1 to twice the mean
+)
+
+// options describe the bytes to write.
+type options struct {
+	size           int64  // Bytes in all
+	lineLen        int    // Mean line length, newline included; 0 for no lines
+	lineDist       string // Distribution of line lengths
+	longEvery      int    // Every so many lines is long, 0 for none
+	longLen        int    // Length of long lines
+	noFinalNewline bool   // Leave the last line unterminated
+	nulEvery       int    // A NUL byte every so many bytes, 0 for none
+	invalidEvery   int    // An invalid UTF-8 byte every so many bytes, 0 for none
+	seed           int64
+}
+
+func (o *options) check() error {
+	switch o.lineDist {
+	case distExponential, distFixed, distUniform:
+	default:
+		return errors.New(fmt.Sprintf("unknown line distribution %q, expected %s, %s, or %s",
+			o.lineDist, distFixed, distUniform, distExponential))
+	}
+	if o.lineLen < 0 || o.longEvery < 0 || o.longLen < 0 || o.nulEvery < 0 || o.invalidEvery < 0 {
+		return errors.New("lengths and intervals cannot be negative")
+	}
+	if o.longEvery > 0 && o.longLen == 0 {
+		return errors.New("-long-every needs -long-len")
+	}
+	return nil
+}
+
+// generate writes the bytes.
+func generate(w io.Writer, o options) error {
+	out := bufio.NewWriter(w)
+	random := rand.New(rand.NewSource(o.seed))
+	lines := 0
+	lineEnd := int64(-1) // Offset of the current line's newline
+	nextLine := func(start int64) {
+		n := o.lineLen
+		switch {
+		case o.longEvery > 0 && lines%o.longEvery == o.longEvery-1:
+			n = o.longLen
+		case o.lineDist == distUniform:
+			n = 1 + random.Intn(2*o.lineLen)
+		case o.lineDist == distExponential:
+			n = 1 + int(random.ExpFloat64()*float64(o.lineLen-1))
+		}
+		lines++
+		lineEnd = start + int64(n) - 1
+	}
+	if o.lineLen > 0 {
+		nextLine(0)
+	}
+
+	var letter = byte('a')
+	for j := int64(0); j < o.size; j++ {
+		final := j == o.size-1
+		switch {
+		case o.lineLen > 0 && (j == lineEnd || (final && !o.noFinalNewline)):
+			out.WriteByte('\n')
+			nextLine(j + 1)
+		case o.nulEvery > 0 && j%int64(o.nulEvery) == int64(o.nulEvery)-1:
+			out.WriteByte(0)
+		case o.invalidEvery > 0 && j%int64(o.invalidEvery) == int64(o.invalidEvery)-1:
+			out.WriteByte(0xff)
+		default:
+			out.WriteByte(letter)
+		}
+		if (j+1)%50 == 0 {
+			switch letter {
+			case 'z':
+				letter = 'A'
+			case 'Z':
+				letter = 'a'
+			default:
+				letter++
+			}
+		}
+	}
+	return out.Flush()
+}
+
 func main() {
-	var count int = 20
-	var err error
-	var w = bufio.NewWriter(os.Stdout)
+	var o options
+	flag.IntVar(&o.lineLen, "line-len", 0,
+		"Mean line length in bytes, with the newline.  Zero writes no newlines.")
+	flag.StringVar(&o.lineDist, "line-dist", distFixed,
+		"Line length distribution: fixed, uniform (1 to twice -line-len), or exp (exponential).")
+	flag.IntVar(&o.longEvery, "long-every", 0,
+		"Make every so many lines -long-len bytes, as beyond bufio.MaxScanTokenSize (65536).")
+	flag.IntVar(&o.longLen, "long-len", 100000,
+		"Length of the -long-every lines.")
+	flag.BoolVar(&o.noFinalNewline, "no-final-newline", false,
+		"Leave the last line without a newline.")
+	flag.IntVar(&o.nulEvery, "nul-every", 0,
+		"Write a NUL byte every so many bytes.")
+	flag.IntVar(&o.invalidEvery, "invalid-utf8-every", 0,
+		"Write an invalid UTF-8 byte (0xff) every so many bytes.")
+	flag.Int64Var(&o.seed, "seed", 1,
+		"Seed for line lengths.  The same seed gives the same bytes.")
+	align := flag.Int64("align", 0,
+		"Round COUNT up to a multiple of this, such as a chunk size, for sizes on chunk boundaries.")
+	adjust := flag.Int64("adjust", 0,
+		"Bytes to add to the size after -align, such as -1 or 1 to straddle a boundary.")
+	flag.Parse()
 
-	if len(os.Args) > 1 {
-		if count, err = strconv.Atoi(os.Args[1]); err != nil {
+	var count int64 = 20
+	var err error
+	if flag.NArg() > 0 {
+		if count, err = strconv.ParseInt(flag.Arg(0), 10, 64); err != nil {
 			fmt.Fprintf(os.Stderr, "*** Expected argument (%s) to be a number\n",
-				os.Args[1])
+				flag.Arg(0))
 			os.Exit(1)
 		}
 		if count <= 0 {
@@ -23,18 +147,24 @@ func main() {
 			os.Exit(1)
 		}
 	}
-
-	var letter = byte('a')
-	for j := 0; j < count; {
-		w.WriteByte(letter)
-		if j++; j % 50 == 0 {
-			switch letter {
-			case 'z': letter = 'A'
-			case 'Z': letter = 'a'
-			default: letter++
-			}
-		}
+	if *align < 0 {
+		fmt.Fprintf(os.Stderr, "*** -align (%d) cannot be negative\n", *align)
+		os.Exit(1)
+	}
+	if *align > 0 {
+		count = (count + *align - 1) / *align * *align
+	}
+	if o.size = count + *adjust; o.size <= 0 {
+		fmt.Fprintf(os.Stderr, "*** Size (%d) after -adjust must be positive\n", o.size)
+		os.Exit(1)
+	}
+	if err = o.check(); err != nil {
+		fmt.Fprintf(os.Stderr, "*** %s\n", err)
+		os.Exit(1)
+	}
+	if err = generate(os.Stdout, o); err != nil {
+		fmt.Fprintf(os.Stderr, "*** %s\n", err)
+		os.Exit(1)
 	}
-	w.Flush()
 	os.Exit(0)
 }
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		o        options
+		expected string
+	}{
+		{options{size: 5, lineDist: distFixed}, "aaaaa"},
+		{options{size: 12, lineLen: 4, lineDist: distFixed}, "aaa\naaa\naaa\n"},
+		{options{size: 10, lineLen: 4, lineDist: distFixed}, "aaa\naaa\na\n"},
+		{options{size: 10, lineLen: 4, lineDist: distFixed, noFinalNewline: true}, "aaa\naaa\naa"},
+		{options{size: 12, lineLen: 4, lineDist: distFixed, longEvery: 2, longLen: 6}, "aaa\naaaaa\na\n"},
+		{options{size: 8, lineLen: 4, lineDist: distFixed, nulEvery: 3, invalidEvery: 5}, "aa\x00\n\xff\x00a\n"},
+	}
+	for _, test := range tests {
+		var b bytes.Buffer
+		if err := generate(&b, test.o); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != test.expected {
+			t.Errorf("%+v: expected %q, got %q", test.o, test.expected, b.String())
+		}
+	}
+
+	// Runs of 50 letters, a long line past the scanner's limit.
+	var b bytes.Buffer
+	o := options{size: 200000, lineLen: 80, lineDist: distExponential, longEvery: 100, longLen: 70000, seed: 3}
+	if err := generate(&b, o); err != nil {
+		t.Fatal(err)
+	}
+	longest := 0
+	for _, line := range strings.Split(b.String(), "\n") {
+		if len(line) > longest {
+			longest = len(line)
+		}
+	}
+	if b.Len() != 200000 || longest != 69999 || !strings.HasPrefix(b.String(), strings.Repeat("a", 50)+"b") {
+		t.Errorf("expected 200000 bytes with a 69999-byte line, got %d and %d", b.Len(), longest)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Rotation modes, as logrotate does it
+const (
+	rotateRename   = "rename"   // Rename to FILE.1 and start a new file
+	rotateTruncate = "truncate" // Copy to FILE.1 and truncate in place (copytruncate)
+)
+
+// An appender writes lines to a file in real time, as a live service
+// would, for testing tail and follow features.
+type appender struct {
+	g          *generator
+	name       string
+	rate       float64       // Lines per second
+	duration   time.Duration // Run time, 0 until interrupted
+	count      int           // Lines to write, 0 for no limit
+	rotate     time.Duration // Time between rotations, 0 for none
+	rotateMode string
+	file       *os.File
+}
+
+// Longest wait between writes, so high rates write in batches.
+const appendTick = 10 * time.Millisecond
+
+// run appends lines until the duration passes, the count is written,
+// or the process is interrupted.
+func (a *appender) run() error {
+	switch a.rotateMode {
+	case rotateRename, rotateTruncate:
+	default:
+		return errors.New(fmt.Sprintf("unknown rotation mode %q, expected %s or %s",
+			a.rotateMode, rotateRename, rotateTruncate))
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+	defer func() { a.file.Close() }()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	interval := time.Duration(float64(time.Second) / a.rate)
+	if interval > appendTick {
+		interval = appendTick
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	start := time.Now()
+	lastRotation := start
+	written := 0
+	for {
+		select {
+		case <-interrupt:
+			return nil
+		case now := <-ticker.C:
+			elapsed := now.Sub(start)
+			if a.duration > 0 && elapsed >= a.duration {
+				elapsed = a.duration
+			}
+			if a.rotate > 0 && now.Sub(lastRotation) >= a.rotate {
+				if err := a.rotateFile(); err != nil {
+					return err
+				}
+				lastRotation = now
+			}
+			due := int(elapsed.Seconds() * a.rate)
+			if a.count > 0 && due > a.count {
+				due = a.count
+			}
+			if err := a.write(written, due); err != nil {
+				return err
+			}
+			written = due
+			if (a.duration > 0 && elapsed >= a.duration) || (a.count > 0 && written >= a.count) {
+				return nil
+			}
+		}
+	}
+}
+
+// write writes lines from through to (exclusive) in one write, so a
+// reader sees whole lines.
+func (a *appender) write(from int, to int) error {
+	var b []byte
+	for j := from; j < to; j++ {
+		for _, line := range a.g.lines(j) {
+			b = append(b, line...)
+			b = append(b, '\n')
+		}
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	_, err := a.file.Write(b)
+	return err
+}
+
+func (a *appender) open() (err error) {
+	a.file, err = os.OpenFile(a.name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	return err
+}
+
+// rotateFile moves the lines so far to FILE.1, replacing any earlier
+// one, and continues with an empty file.
+func (a *appender) rotateFile() error {
+	if a.rotateMode == rotateRename {
+		if err := a.file.Close(); err != nil {
+			return err
+		}
+		if err := os.Rename(a.name, a.name+".1"); err != nil {
+			return err
+		}
+		return a.open()
+	}
+	src, err := os.Open(a.name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(a.name + ".1")
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+	// Appends continue at the new end, the start of the file.
+	return a.file.Truncate(0)
+}
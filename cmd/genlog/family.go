@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"strconv"
+)
+
+// A family is a log file with its rotated predecessors, as logrotate
+// leaves them: app.log, app.log.1, app.log.2.gz, and so on, oldest
+// last.  Timestamps run on across the files, so reading the family
+// newest first gives one continuous log.
+type family struct {
+	g            *generator
+	name         string // The current file
+	files        int    // Files, including the current one
+	count        int    // Lines per file
+	compressFrom int    // First rotated file compressed, 0 for none
+}
+
+// compressed reports whether the file k rotations old is compressed.
+func (f *family) compressed(k int) bool {
+	return f.compressFrom > 0 && k >= f.compressFrom
+}
+
+// fileName gives the name of the file k rotations old.
+func (f *family) fileName(k int) string {
+	if k == 0 {
+		return f.name
+	}
+	name := f.name + "." + strconv.Itoa(k)
+	if f.compressed(k) {
+		name += ".gz"
+	}
+	return name
+}
+
+// write writes the files, oldest first, so line numbers and times
+// increase from the oldest file to the current one.
+func (f *family) write() error {
+	j := 0
+	for k := f.files - 1; k >= 0; k-- {
+		if err := f.writeFile(f.fileName(k), j, f.compressed(k)); err != nil {
+			return err
+		}
+		j += f.count
+	}
+	return nil
+}
+
+// writeFile writes the file's lines, starting with line first.
+func (f *family) writeFile(name string, first int, compressed bool) (err error) {
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := file.Close(); err == nil {
+			err = e
+		}
+	}()
+	var out io.Writer = file
+	var z *gzip.Writer
+	if compressed {
+		z = gzip.NewWriter(file)
+		out = z
+	}
+	w := bufio.NewWriter(out)
+	for j := first; j < first+f.count; j++ {
+		for _, line := range f.g.lines(j) {
+			w.WriteString(line + "\n")
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if z != nil {
+		return z.Close()
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+	"varlog/service/app"
+)
+
+// Output formats
+const (
+	formatApache = "apache" // Apache combined access log
+	formatJSON   = "json"   // JSON lines, as structured loggers write
+	formatPlain  = "plain"  // Go's log package, the original format
+	formatSyslog = "syslog" // Traditional syslog, as in /var/log/syslog
+)
+
+// Message size distributions
+const (
+	distExponential = "exp"     // Exponential about the mean: mostly short, some long
+	distFixed       = "fixed"   // Every message the mean size
+	distUniform     = "uniform" // Uniform from 1 to twice the mean
+)
+
+var apps []string = []string{
+	"aaaaa",
+	"bbbbb",
+	"ccccc",
+	"ddddd",
+	"eeeee",
+	"fffff",
+	"ggggg",
+	"hhhhh",
+	"iiiii",
+	"jjjjj",
+}
+
+var levels []string = []string{
+	app.LogDebug,
+	app.LogInfo,
+	app.LogWarning,
+	app.LogError,
+}
+
+// Apache status for each level.
+var statuses = map[string]int{
+	app.LogDebug:   200,
+	app.LogInfo:    200,
+	app.LogWarning: 404,
+	app.LogError:   500,
+}
+
+// Messages are prefixes of this, repeated, so a fixed size of 29
+// gives the original "abcde fghij klmno pqrst uvwxy".
+const messageText = "abcde fghij klmno pqrst uvwxy zabcd efghi jklmn opqrs tuvwx yzabc defgh ijklm nopqr stuvw xyzab cdefg hijkl mnopq rstuv wxyza bcdef ghijk lmnop qrstu vwxyz "
+
+// A generator makes log lines.  Apps and levels cycle through their
+// lists, so line j is predictable; message sizes and stack traces
+// come from a seeded source, so a seed gives the same file each run.
+type generator struct {
+	format    string
+	start     time.Time
+	step      time.Duration // Between lines, 0 for real time
+	msgSize   int           // Mean message size in bytes
+	msgDist   string
+	traceRate float64 // Fraction of ERROR lines with a stack trace
+	rand      *rand.Rand
+}
+
+func newGenerator(format string, start time.Time, step time.Duration, msgSize int, msgDist string, traceRate float64, seed int64) (*generator, error) {
+	switch format {
+	case formatApache, formatJSON, formatPlain, formatSyslog:
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown format %q, expected %s, %s, %s, or %s",
+			format, formatPlain, formatJSON, formatApache, formatSyslog))
+	}
+	switch msgDist {
+	case distExponential, distFixed, distUniform:
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown size distribution %q, expected %s, %s, or %s",
+			msgDist, distFixed, distUniform, distExponential))
+	}
+	if msgSize < 1 {
+		return nil, errors.New(fmt.Sprintf("message size (%d) must be positive", msgSize))
+	}
+	if traceRate < 0 || traceRate > 1 {
+		return nil, errors.New(fmt.Sprintf("trace rate (%g) must be from 0 to 1", traceRate))
+	}
+	return &generator{
+		format:    format,
+		start:     start,
+		step:      step,
+		msgSize:   msgSize,
+		msgDist:   msgDist,
+		traceRate: traceRate,
+		rand:      rand.New(rand.NewSource(seed)),
+	}, nil
+}
+
+// time gives line j's time.
+func (g *generator) time(j int) time.Time {
+	if g.step == 0 {
+		return time.Now()
+	}
+	return g.start.Add(time.Duration(j) * g.step)
+}
+
+// message gives a message of a size from the distribution.
+func (g *generator) message() string {
+	n := g.msgSize
+	switch g.msgDist {
+	case distUniform:
+		n = 1 + g.rand.Intn(2*g.msgSize)
+	case distExponential:
+		n = 1 + int(g.rand.ExpFloat64()*float64(g.msgSize-1))
+	}
+	var b strings.Builder
+	for b.Len() < n {
+		b.WriteString(messageText[:min(len(messageText), n-b.Len())])
+	}
+	return strings.TrimRight(b.String(), " ")
+}
+
+// lines gives line j, followed by any stack trace lines.
+func (g *generator) lines(j int) []string {
+	t := g.time(j)
+	name := apps[j%len(apps)]
+	level := levels[j%len(levels)]
+	msg := g.message()
+
+	var line string
+	switch g.format {
+	case formatApache:
+		return []string{fmt.Sprintf(`10.0.%d.%d - - [%s] "GET /%s/%d HTTP/1.1" %d %d "-" "genlog/1.0"`,
+			j/256%256, j%256, t.Format("02/Jan/2006:15:04:05 -0700"), name, j,
+			statuses[level], len(msg))}
+	case formatJSON:
+		b, _ := json.Marshal(struct {
+			Time  string `json:"time"`
+			Level string `json:"level"`
+			App   string `json:"app"`
+			Seq   int    `json:"seq"`
+			Msg   string `json:"msg"`
+		}{t.Format(time.RFC3339Nano), strings.ToLower(level), name, j, msg})
+		return []string{string(b)}
+	case formatSyslog:
+		line = fmt.Sprintf("%s genhost %s[%d]: %s %s", t.Format(time.Stamp), name, 1000+j%len(apps), level, msg)
+	default:
+		line = fmt.Sprintf("%s %s %10d %7s %s", t.Format("2006/01/02 15:04:05"), name, j, level, msg)
+	}
+	result := []string{line}
+	if level == app.LogError && g.traceRate > 0 && g.rand.Float64() < g.traceRate {
+		depth := 1 + g.rand.Intn(5)
+		for k := 0; k < depth; k++ {
+			result = append(result, fmt.Sprintf("\tat %s.handler%d(%s.go:%d)", name, k, name, 10*k+j%10))
+		}
+	}
+	return result
+}
+
+func min(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
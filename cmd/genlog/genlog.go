@@ -1,41 +1,70 @@
+// Command genlog writes generated log lines for testing the service.
+//
+// Usage:
+//
+//	genlog [flags] [COUNT]
+//
+// Without flags, it writes COUNT (default 20) lines in the format of
+// Go's log package to standard error, as it always has.  Flags select
+// other formats, synthetic time ranges, message sizes, and stack
+// traces; the same -seed gives the same lines.  With -rate, it
+// appends to a file in real time, as a live service would, optionally
+// rotating it, for testing tail and follow features.  With -files, it
+// writes a rotated family of files, some compressed.
 package main
 
 import (
+	"bufio"
+	"flag"
 	"fmt"
-	"log"
+	"io"
 	"os"
 	"strconv"
-	"varlog/service/app"
+	"time"
 )
 
-var apps []string = []string{
-	"aaaaa",
-	"bbbbb",
-	"ccccc",
-	"ddddd",
-	"eeeee",
-	"fffff",
-	"ggggg",
-	"hhhhh",
-	"iiiii",
-	"jjjjj",
-}
-
-var levels []string = []string{
-	app.LogDebug,
-	app.LogInfo,
-	app.LogWarning,
-	app.LogError,
-}
-
 func main() {
+	format := flag.String("format", formatPlain,
+		"Line format: plain (Go's log package), json (JSON lines), apache (combined access log), or syslog.")
+	start := flag.String("start", "",
+		"Time of the first line, RFC 3339, as in 2023-02-16T07:00:00Z.  Default now.")
+	end := flag.String("end", "",
+		"Time of the last line, RFC 3339.  Lines are spread evenly from -start.")
+	step := flag.Duration("step", 0,
+		"Time between lines.  Default 1s with -start or -end, otherwise each line is stamped as written.")
+	msgSize := flag.Int("msg-size", len("abcde fghij klmno pqrst uvwxy"),
+		"Mean message size in bytes.")
+	msgDist := flag.String("msg-dist", distFixed,
+		"Message size distribution: fixed, uniform (1 to twice -msg-size), or exp (exponential).")
+	traceRate := flag.Float64("trace-rate", 0,
+		"Fraction of ERROR lines followed by stack trace lines, from 0 to 1.  Not for json or apache.")
+	seed := flag.Int64("seed", 1,
+		"Seed for message sizes and traces.  The same seed gives the same lines.")
+	output := flag.String("o", "",
+		"Output file, or - for standard output.  Default standard error.")
+	rate := flag.Float64("rate", 0,
+		"Append to the -o file at this many lines per second, stamped as written, "+
+			"until -duration passes, COUNT lines are written, or interrupted.")
+	duration := flag.Duration("duration", 0,
+		"With -rate, how long to run.  Default until interrupted.")
+	rotate := flag.Duration("rotate", 0,
+		"With -rate, rotate the file this often, keeping one old file, FILE.1.")
+	rotateMode := flag.String("rotate-mode", rotateRename,
+		"How to rotate: rename (move to FILE.1, start a new file) or truncate (copy to FILE.1, truncate in place).")
+	files := flag.Int("files", 1,
+		"Write a rotated family of this many files, FILE, FILE.1, FILE.2.gz, and so on, "+
+			"each of COUNT lines, with times running on from the oldest to FILE.  Needs -o.")
+	compressFrom := flag.Int("compress-from", 2,
+		"With -files, gzip rotated files from this number on, as logrotate's delaycompress does.  "+
+			"Zero compresses none.")
+	flag.Parse()
+
 	var count int = 20
 	var err error
-
-	if len(os.Args) > 1 {
-		if count, err = strconv.Atoi(os.Args[1]); err != nil {
+	if flag.NArg() > 0 {
+		if count, err = strconv.Atoi(flag.Arg(0)); err != nil {
 			fmt.Fprintf(os.Stderr, "*** Expected argument (%s) to be a number\n",
-				os.Args[1])
+				flag.Arg(0))
 			os.Exit(1)
 		}
 		if count <= 0 {
@@ -44,9 +73,125 @@ func main() {
 		}
 	}
 
+	if *rate < 0 || *duration < 0 || *rotate < 0 {
+		fmt.Fprintf(os.Stderr, "*** -rate, -duration, and -rotate cannot be negative\n")
+		os.Exit(1)
+	}
+	if *rate > 0 {
+		if *output == "" || *output == "-" {
+			fmt.Fprintf(os.Stderr, "*** -rate needs an -o file to append to\n")
+			os.Exit(1)
+		}
+		if *start != "" || *end != "" || *step != 0 {
+			fmt.Fprintf(os.Stderr, "*** -rate stamps lines as written, without -start, -end, or -step\n")
+			os.Exit(1)
+		}
+		if flag.NArg() == 0 {
+			count = 0
+		}
+	} else if *duration != 0 || *rotate != 0 {
+		fmt.Fprintf(os.Stderr, "*** -duration and -rotate need -rate\n")
+		os.Exit(1)
+	}
+
+	if *files < 1 || *compressFrom < 0 {
+		fmt.Fprintf(os.Stderr, "*** -files must be positive, and -compress-from not negative\n")
+		os.Exit(1)
+	}
+	if *files > 1 {
+		if *output == "" || *output == "-" || *rate > 0 {
+			fmt.Fprintf(os.Stderr, "*** -files needs an -o file, without -rate\n")
+			os.Exit(1)
+		}
+		if *start == "" && *end == "" && *step == 0 {
+			// Times for the whole family, ending now.
+			*step = time.Second
+			*start = time.Now().Add(-time.Duration(*files*count-1) * *step).Format(time.RFC3339)
+		}
+	}
+
+	t0, t1 := time.Now(), time.Time{}
+	if *start != "" {
+		if t0, err = time.Parse(time.RFC3339, *start); err != nil {
+			fmt.Fprintf(os.Stderr, "*** -start: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if *end != "" {
+		if t1, err = time.Parse(time.RFC3339, *end); err != nil {
+			fmt.Fprintf(os.Stderr, "*** -end: %s\n", err)
+			os.Exit(1)
+		}
+		if *start == "" && *step != 0 {
+			t0 = t1.Add(-time.Duration(*files*count-1) * *step)
+		}
+	}
+	switch {
+	case *step < 0:
+		fmt.Fprintf(os.Stderr, "*** -step (%s) cannot be negative\n", *step)
+		os.Exit(1)
+	case *step == 0 && *end != "":
+		if t1.Before(t0) {
+			fmt.Fprintf(os.Stderr, "*** -end (%s) is before -start (%s)\n", *end, t0.Format(time.RFC3339))
+			os.Exit(1)
+		}
+		if total := *files * count; total > 1 {
+			*step = t1.Sub(t0) / time.Duration(total-1)
+		}
+		if *step == 0 {
+			*step = time.Nanosecond
+		}
+	case *step == 0 && *start != "":
+		*step = time.Second
+	}
+
+	g, err := newGenerator(*format, t0, *step, *msgSize, *msgDist, *traceRate, *seed)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "*** %s\n", err)
+		os.Exit(1)
+	}
+
+	if *rate > 0 {
+		a := appender{g: g, name: *output, rate: *rate, duration: *duration, count: count,
+			rotate: *rotate, rotateMode: *rotateMode}
+		if err = a.run(); err != nil {
+			fmt.Fprintf(os.Stderr, "*** %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *files > 1 {
+		f := family{g: g, name: *output, files: *files, count: count, compressFrom: *compressFrom}
+		if err = f.write(); err != nil {
+			fmt.Fprintf(os.Stderr, "*** %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	var out io.Writer = os.Stderr
+	switch *output {
+	case "":
+	case "-":
+		out = os.Stdout
+	default:
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "*** %s\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		out = f
+	}
+	w := bufio.NewWriter(out)
 	for j := 0; j < count; j++ {
-		log.Printf("%s %10d %7s abcde fghij klmno pqrst uvwxy\n",
-			apps[j%len(apps)], j, levels[j%len(levels)])
+		for _, line := range g.lines(j) {
+			w.WriteString(line + "\n")
+		}
+	}
+	if err = w.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "*** %s\n", err)
+		os.Exit(1)
 	}
-	os.Exit(0)
 }
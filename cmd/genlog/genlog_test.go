@@ -0,0 +1,146 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerator(t *testing.T) {
+	start := time.Date(2023, 2, 16, 7, 0, 0, 0, time.UTC)
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{formatPlain, "2023/02/16 07:00:03 ddddd          3   ERROR abcde fghij klmno pqrst uvwxy"},
+		{formatJSON, `{"time":"2023-02-16T07:00:03Z","level":"error","app":"ddddd","seq":3,"msg":"abcde fghij klmno pqrst uvwxy"}`},
+		{formatApache, `10.0.0.3 - - [16/Feb/2023:07:00:03 +0000] "GET /ddddd/3 HTTP/1.1" 500 29 "-" "genlog/1.0"`},
+		{formatSyslog, "Feb 16 07:00:03 genhost ddddd[1003]: ERROR abcde fghij klmno pqrst uvwxy"},
+	}
+	for _, test := range tests {
+		g, err := newGenerator(test.format, start, time.Second, 29, distFixed, 0, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lines := g.lines(3); len(lines) != 1 || lines[0] != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.format, test.expected, lines)
+		}
+	}
+
+	// The same seed gives the same lines.
+	generate := func(seed int64) []string {
+		g, err := newGenerator(formatPlain, start, time.Second, 40, distExponential, 0.5, seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lines []string
+		for j := 0; j < 100; j++ {
+			lines = append(lines, g.lines(j)...)
+		}
+		return lines
+	}
+	first := generate(7)
+	if !reflect.DeepEqual(first, generate(7)) {
+		t.Errorf("expected the same lines for the same seed")
+	}
+	if reflect.DeepEqual(first, generate(8)) {
+		t.Errorf("expected different lines for different seeds")
+	}
+	traces := 0
+	for _, line := range first {
+		if strings.HasPrefix(line, "\tat ") {
+			traces++
+		}
+	}
+	if traces == 0 || len(first)-traces != 100 {
+		t.Errorf("expected 100 lines with some trace lines, got %d and %d", len(first)-traces, traces)
+	}
+
+	for _, bad := range []struct {
+		format, dist string
+		size         int
+		rate         float64
+	}{
+		{"csv", distFixed, 29, 0},
+		{formatPlain, "normal", 29, 0},
+		{formatPlain, distFixed, 0, 0},
+		{formatPlain, distFixed, 29, 1.5},
+	} {
+		if _, err := newGenerator(bad.format, start, 0, bad.size, bad.dist, bad.rate, 1); err == nil {
+			t.Errorf("%+v: expected error", bad)
+		}
+	}
+}
+
+func TestAppender(t *testing.T) {
+	for _, mode := range []string{rotateRename, rotateTruncate} {
+		g, err := newGenerator(formatPlain, time.Time{}, 0, 29, distFixed, 0, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := filepath.Join(t.TempDir(), "live.log")
+		a := appender{g: g, name: name, rate: 5000, count: 1000, rotate: 20 * time.Millisecond, rotateMode: mode}
+		if err := a.run(); err != nil {
+			t.Fatalf("%s: %s", mode, err)
+		}
+		current, _ := os.ReadFile(name)
+		old, _ := os.ReadFile(name + ".1")
+		lines := strings.Count(string(current), "\n")
+		if lines == 1000 || lines+strings.Count(string(old), "\n") > 1000 || len(old) == 0 {
+			t.Errorf("%s: expected 1000 lines split by rotation, got %d and %d", mode, lines, strings.Count(string(old), "\n"))
+		}
+		if !strings.Contains(string(current), "        999 ") {
+			t.Errorf("%s: expected the last line in the current file", mode)
+		}
+	}
+}
+
+func TestFamily(t *testing.T) {
+	g, err := newGenerator(formatPlain, time.Date(2023, 2, 16, 7, 0, 0, 0, time.UTC), time.Second, 29, distFixed, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), "app.log")
+	f := family{g: g, name: name, files: 4, count: 3, compressFrom: 2}
+	if err := f.write(); err != nil {
+		t.Fatal(err)
+	}
+	// Newest file first, each holding the 3 lines before the next.
+	var lines []string
+	for _, suffix := range []string{"", ".1", ".2.gz", ".3.gz"} {
+		file, err := os.Open(name + suffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r io.Reader = file
+		if strings.HasSuffix(suffix, ".gz") {
+			if r, err = gzip.NewReader(file); err != nil {
+				t.Fatalf("%s: %s", suffix, err)
+			}
+		}
+		b, err := io.ReadAll(r)
+		file.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", suffix, err)
+		}
+		fileLines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+		for i := len(fileLines) - 1; i >= 0; i-- {
+			lines = append(lines, fileLines[i])
+		}
+	}
+	for i, line := range lines {
+		j := 11 - i
+		expected := g.lines(j)[0]
+		if line != expected {
+			t.Errorf("line %d: expected %q, got %q", j, expected, line)
+		}
+	}
+	if len(lines) != 12 || !strings.HasPrefix(lines[0], "2023/02/16 07:00:11 ") {
+		t.Errorf("expected 12 lines ending at 07:00:11, got %d: %q", len(lines), lines)
+	}
+}
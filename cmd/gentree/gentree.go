@@ -0,0 +1,58 @@
+// Command gentree creates a directory tree for testing /list:
+// recursion, large directories, and performance.
+//
+// Usage:
+//
+//	gentree [flags] ROOT
+//
+// Under ROOT, which must not exist or be empty, each directory to
+// -depth holds -dirs subdirectories, -files files of about -size
+// bytes, and optionally symlinks, broken symlinks, symlink cycles,
+// named pipes, and sockets.  The same flags and -seed give the same
+// tree.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+func main() {
+	t := &tree{}
+	flag.IntVar(&t.depth, "depth", 2,
+		"Levels of subdirectories below ROOT.")
+	flag.IntVar(&t.dirs, "dirs", 3,
+		"Subdirectories per directory, dir-00, dir-01, and so on.")
+	flag.IntVar(&t.files, "files", 10,
+		"Files per directory, file-000.log, file-001.log, and so on.")
+	flag.Int64Var(&t.size, "size", 4096,
+		"Mean file size in bytes.")
+	flag.StringVar(&t.sizeDist, "size-dist", distFixed,
+		"File size distribution: fixed, uniform (0 to twice -size), or exp (exponential).")
+	flag.IntVar(&t.symlinks, "symlinks", 0,
+		"Symlinks per directory to files elsewhere in the tree, link-00 and so on.")
+	flag.IntVar(&t.broken, "broken-symlinks", 0,
+		"Symlinks per directory to missing files, broken-00 and so on.")
+	flag.IntVar(&t.dirSymlinks, "dir-symlinks", 0,
+		"Symlinks per subdirectory to its ancestors, up-00 and so on, making cycles.")
+	flag.IntVar(&t.fifos, "fifos", 0,
+		"Named pipes per directory, fifo-00 and so on.")
+	flag.IntVar(&t.sockets, "sockets", 0,
+		"Unix domain sockets per directory, socket-00 and so on.")
+	flag.Int64Var(&t.seed, "seed", 1,
+		"Seed for file sizes and symlink targets.  The same seed gives the same tree.")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "*** Expected one argument, the ROOT directory\n")
+		os.Exit(1)
+	}
+	t.modTimeStart = time.Now()
+	if err := t.create(flag.Arg(0)); err != nil {
+		fmt.Fprintf(os.Stderr, "*** %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "%s\n", t.counts)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestTree(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "root")
+	tr := &tree{depth: 2, dirs: 2, files: 3, size: 1000, sizeDist: distUniform,
+		symlinks: 1, broken: 1, dirSymlinks: 1, seed: 1}
+	if runtime.GOOS != "windows" {
+		tr.fifos, tr.sockets = 1, 1
+	}
+	if err := tr.create(root); err != nil {
+		t.Fatal(err)
+	}
+	// 1 + 2 + 4 directories.
+	expected := counts{dirs: 7, files: 21, symlinks: 7 + 7 + 6, specials: 2 * 7 * (tr.fifos)}
+	got := tr.counts
+	got.bytes = 0
+	if got != expected {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	var files, links, specials int
+	var bytes int64
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			links++
+		case d.Type().IsRegular():
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			files++
+			bytes += info.Size()
+		case !d.IsDir():
+			specials++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files != expected.files || links != expected.symlinks || specials != expected.specials || bytes != tr.counts.bytes {
+		t.Errorf("expected %v on disk, got %d files (%d bytes), %d symlinks, %d special files",
+			tr.counts, files, bytes, links, specials)
+	}
+
+	link := filepath.Join(root, "dir-01", "dir-00", "link-00")
+	if _, err := os.Stat(link); err != nil {
+		t.Errorf("%s: expected a working symlink, got %s", link, err)
+	}
+	broken := filepath.Join(root, "dir-01", "broken-00")
+	if _, err := os.Stat(broken); !os.IsNotExist(err) {
+		t.Errorf("%s: expected a broken symlink, got %v", broken, err)
+	}
+	up := filepath.Join(root, "dir-01", "dir-00", "up-00", "dir-01", "file-000.log")
+	if _, err := os.Stat(up); err != nil {
+		t.Errorf("%s: expected a cycle to the parent, got %s", up, err)
+	}
+
+	if err := tr.create(root); err == nil {
+		t.Errorf("expected an error creating over a tree")
+	}
+}
+
+func TestTree_sizes(t *testing.T) {
+	for _, size := range []int64{0, 1, 2, 100, 5000} {
+		root := t.TempDir()
+		tr := &tree{files: 1, size: size, sizeDist: distFixed}
+		if err := tr.create(root); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filepath.Join(root, "file-000.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(len(data)) != size || (size > 0 && data[len(data)-1] != '\n') {
+			t.Errorf("size %d: expected that many bytes, ending in a newline, got %d", size, len(data))
+		}
+	}
+}
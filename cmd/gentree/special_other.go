@@ -0,0 +1,14 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package main
+
+import "errors"
+
+// Without named pipes or Unix domain sockets, -fifos and -sockets fail.
+func mkfifo(name string) error {
+	return errors.New("named pipes not supported")
+}
+
+func mksocket(name string) error {
+	return errors.New("Unix domain sockets not supported")
+}
@@ -0,0 +1,25 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// mkfifo creates a named pipe.  Nothing writes to it, so opening it
+// to read blocks, as it would for a service that did not check.
+func mkfifo(name string) error {
+	return syscall.Mkfifo(name, 0644)
+}
+
+// mksocket creates a Unix domain socket, left behind with nothing
+// listening, as a crashed daemon leaves one.
+func mksocket(name string) error {
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: name, Net: "unix"})
+	if err != nil {
+		return err
+	}
+	l.SetUnlinkOnClose(false)
+	return l.Close()
+}
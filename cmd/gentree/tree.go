@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// File size distributions
+const (
+	distExponential = "exp"     // Exponential about the mean
+	distFixed       = "fixed"   // Every file the mean size
+	distUniform     = "uniform" // Uniform from 0 to twice the mean
+)
+
+// A tree describes the hierarchy to create.  Each directory, to the
+// given depth, holds the same numbers of subdirectories, files,
+// symlinks, and special files.
+type tree struct {
+	depth        int    // Levels of subdirectories below the root
+	dirs         int    // Subdirectories per directory
+	files        int    // Regular files per directory
+	size         int64  // Mean file size in bytes
+	sizeDist     string // Distribution of file sizes
+	symlinks     int    // Symlinks to files elsewhere in the tree, per directory
+	broken       int    // Symlinks to missing files, per directory
+	dirSymlinks  int    // Symlinks to ancestor directories, making cycles, per directory
+	fifos        int    // Named pipes per directory
+	sockets      int    // Unix domain sockets per directory
+	seed         int64
+	random       *rand.Rand
+	created      []string // Files created so far, for symlink targets
+	counts       counts
+	modTimeStart time.Time // Modification time of the first file
+}
+
+// counts are what a tree created.
+type counts struct {
+	dirs     int
+	files    int
+	bytes    int64
+	symlinks int
+	specials int
+}
+
+func (c counts) String() string {
+	return fmt.Sprintf("%d directories, %d files (%d bytes), %d symlinks, %d special files",
+		c.dirs, c.files, c.bytes, c.symlinks, c.specials)
+}
+
+func (t *tree) check() error {
+	switch t.sizeDist {
+	case distExponential, distFixed, distUniform:
+	default:
+		return errors.New(fmt.Sprintf("unknown size distribution %q, expected %s, %s, or %s",
+			t.sizeDist, distFixed, distUniform, distExponential))
+	}
+	if t.depth < 0 || t.dirs < 0 || t.files < 0 || t.size < 0 || t.symlinks < 0 ||
+		t.broken < 0 || t.dirSymlinks < 0 || t.fifos < 0 || t.sockets < 0 {
+		return errors.New("counts and sizes cannot be negative")
+	}
+	return nil
+}
+
+// create creates the tree under root, which must not exist or be empty.
+func (t *tree) create(root string) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	if entries, err := os.ReadDir(root); err == nil && len(entries) > 0 {
+		return errors.New(fmt.Sprintf("%s is not empty", root))
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+	t.random = rand.New(rand.NewSource(t.seed))
+	t.created = nil
+	t.counts = counts{}
+	return t.createDir(root, 0)
+}
+
+// createDir fills the directory at the given level, then its
+// subdirectories.  Symlinks point only to files created before them,
+// so the same seed gives the same tree.
+func (t *tree) createDir(dir string, level int) error {
+	for j := 0; j < t.files; j++ {
+		if err := t.createFile(filepath.Join(dir, fmt.Sprintf("file-%03d.log", j))); err != nil {
+			return err
+		}
+	}
+	for j := 0; j < t.symlinks && len(t.created) > 0; j++ {
+		target := t.created[t.random.Intn(len(t.created))]
+		if err := t.symlink(target, filepath.Join(dir, fmt.Sprintf("link-%02d", j))); err != nil {
+			return err
+		}
+	}
+	for j := 0; j < t.broken; j++ {
+		target := filepath.Join(dir, fmt.Sprintf("missing-%02d.log", j))
+		if err := t.symlink(target, filepath.Join(dir, fmt.Sprintf("broken-%02d", j))); err != nil {
+			return err
+		}
+	}
+	for j := 0; j < t.dirSymlinks && level > 0; j++ {
+		// Up 1 to level directories, so listing recursively never ends.
+		up := 1 + j%level
+		target := strings.Repeat(".."+string(filepath.Separator), up)
+		name := filepath.Join(dir, fmt.Sprintf("up-%02d", j))
+		if err := os.Symlink(target, name); err != nil {
+			return err
+		}
+		t.counts.symlinks++
+	}
+	for j := 0; j < t.fifos; j++ {
+		if err := mkfifo(filepath.Join(dir, fmt.Sprintf("fifo-%02d", j))); err != nil {
+			return err
+		}
+		t.counts.specials++
+	}
+	for j := 0; j < t.sockets; j++ {
+		if err := mksocket(filepath.Join(dir, fmt.Sprintf("socket-%02d", j))); err != nil {
+			return err
+		}
+		t.counts.specials++
+	}
+	t.counts.dirs++
+	if level == t.depth {
+		return nil
+	}
+	for j := 0; j < t.dirs; j++ {
+		sub := filepath.Join(dir, fmt.Sprintf("dir-%02d", j))
+		if err := os.Mkdir(sub, 0755); err != nil {
+			return err
+		}
+		if err := t.createDir(sub, level+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// symlink creates a symlink to the target, relative to its directory,
+// so the tree can be moved.
+func (t *tree) symlink(target string, name string) error {
+	rel, err := filepath.Rel(filepath.Dir(name), target)
+	if err != nil {
+		return err
+	}
+	if err = os.Symlink(rel, name); err != nil {
+		return err
+	}
+	t.counts.symlinks++
+	return nil
+}
+
+// fileSize chooses a file size.
+func (t *tree) fileSize() int64 {
+	switch t.sizeDist {
+	case distUniform:
+		return t.random.Int63n(2*t.size + 1)
+	case distExponential:
+		return int64(t.random.ExpFloat64() * float64(t.size))
+	}
+	return t.size
+}
+
+// createFile writes a file of numbered lines, most of it letters, with
+// a newline at the end if there is room.  Modification times step
+// back a minute per file, so sorting by time differs from by name.
+func (t *tree) createFile(name string) (err error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := f.Close(); err == nil {
+			err = e
+		}
+	}()
+	size := t.fileSize()
+	w := bufio.NewWriter(f)
+	const filler = "abcde fghij klmno pqrst uvwxy abcde fghij klmno pqrst uvwxy abcde fghij klmno"
+	for written, j := int64(0), 1; written < size; j++ {
+		line := fmt.Sprintf("%s line %d %s", filepath.Base(name), j, filler)
+		if rest := size - written; int64(len(line)+1) > rest {
+			line = line[:rest-1]
+		}
+		w.WriteString(line + "\n")
+		written += int64(len(line) + 1)
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if !t.modTimeStart.IsZero() {
+		mtime := t.modTimeStart.Add(-time.Duration(t.counts.files) * time.Minute)
+		if err = os.Chtimes(name, mtime, mtime); err != nil {
+			return err
+		}
+	}
+	t.created = append(t.created, name)
+	t.counts.files++
+	t.counts.bytes += size
+	return nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"varlog/service/app"
+)
+
+// A request the benchmark sends: an endpoint and its parameters.
+type request struct {
+	endpoint string // "/list" or "/read"
+	query    url.Values
+}
+
+// A mix chooses requests: /list and /read in proportion to their
+// weights, with the names, filters, and counts chosen at random.
+type mix struct {
+	listWeight int
+	readWeight int
+	names      []string // Files to read
+	dirs       []string // Directories to list, "" for the top
+	filters    []string // Read filters, "" for none
+	counts     []int    // Read counts, 0 for all
+}
+
+// parseMix parses weights such as "read=80,list=20".
+func parseMix(value string) (listWeight int, readWeight int, err error) {
+	for _, item := range strings.Split(value, ",") {
+		endpoint, weight, _ := strings.Cut(item, "=")
+		n, err := strconv.Atoi(weight)
+		if err != nil || n < 0 {
+			return 0, 0, errors.New(fmt.Sprintf("invalid weight in %q", item))
+		}
+		switch endpoint {
+		case "list":
+			listWeight = n
+		case "read":
+			readWeight = n
+		default:
+			return 0, 0, errors.New(fmt.Sprintf("unknown endpoint %q in %q, expected list or read", endpoint, item))
+		}
+	}
+	if listWeight+readWeight == 0 {
+		return 0, 0, errors.New("weights cannot all be zero")
+	}
+	return listWeight, readWeight, nil
+}
+
+// next chooses a request.
+func (m *mix) next(random *rand.Rand) request {
+	choose := func(values []string) string { return values[random.Intn(len(values))] }
+	if random.Intn(m.listWeight+m.readWeight) < m.listWeight {
+		q := url.Values{}
+		if dir := choose(m.dirs); dir != "" {
+			q.Set(app.ParamName, dir)
+		}
+		return request{"/list", q}
+	}
+	q := url.Values{app.ParamName: {choose(m.names)}}
+	if filter := choose(m.filters); filter != "" {
+		q.Set(app.ParamFilter, filter)
+	}
+	if count := m.counts[random.Intn(len(m.counts))]; count > 0 {
+		q.Set(app.ParamCount, strconv.Itoa(count))
+	}
+	return request{"/read", q}
+}
+
+// A result of one request.
+type result struct {
+	endpoint string
+	latency  time.Duration // To the end of the body
+	bytes    int64         // Of the body
+	err      error         // Transport error or error status
+}
+
+// A bench sends requests from concurrent workers.
+type bench struct {
+	server      string
+	token       string
+	client      *http.Client
+	mix         *mix
+	concurrency int
+	requests    int64         // Requests to send, 0 for no limit
+	duration    time.Duration // Time to run, 0 for no limit
+	seed        int64
+}
+
+// run sends the requests, giving their results.  Canceling the
+// context stops it early, with the results so far.
+func (b *bench) run(ctx context.Context) []result {
+	if b.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.duration)
+		defer cancel()
+	}
+	var sent int64
+	results := make([][]result, b.concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < b.concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			random := rand.New(rand.NewSource(b.seed + int64(i)))
+			for ctx.Err() == nil {
+				if b.requests > 0 && atomic.AddInt64(&sent, 1) > b.requests {
+					return
+				}
+				r := b.send(ctx, b.mix.next(random))
+				if ctx.Err() != nil && r.err != nil {
+					return // Cut off by the end of the run
+				}
+				results[i] = append(results[i], r)
+			}
+		}(i)
+	}
+	wg.Wait()
+	var all []result
+	for _, r := range results {
+		all = append(all, r...)
+	}
+	return all
+}
+
+// newRequest builds the HTTP request, with any token.
+func (b *bench) newRequest(ctx context.Context, r request) (*http.Request, error) {
+	u := b.server + r.endpoint
+	if len(r.query) > 0 {
+		u += "?" + r.query.Encode()
+	}
+	hr, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if b.token != "" {
+		hr.Header.Set("Authorization", "Bearer "+b.token)
+	}
+	return hr, nil
+}
+
+// send sends a request, reading the whole body.
+func (b *bench) send(ctx context.Context, r request) result {
+	res := result{endpoint: r.endpoint}
+	hr, err := b.newRequest(ctx, r)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	start := time.Now()
+	response, err := b.client.Do(hr)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	res.bytes, err = io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+	res.latency = time.Since(start)
+	switch {
+	case err != nil:
+		res.err = err
+	case response.StatusCode != http.StatusOK:
+		res.err = errors.New(response.Status)
+	}
+	return res
+}
+
+// discover finds the files and directories at the top of the tree,
+// for a mix without names.
+func (b *bench) discover(ctx context.Context) (names []string, dirs []string, err error) {
+	hr, err := b.newRequest(ctx, request{endpoint: "/list"})
+	if err != nil {
+		return nil, nil, err
+	}
+	response, err := b.client.Do(hr)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, nil, errors.New(fmt.Sprintf("/list: %s", response.Status))
+	}
+	var entries []struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+	if err = json.NewDecoder(response.Body).Decode(&entries); err != nil {
+		return nil, nil, err
+	}
+	for _, e := range entries {
+		switch e.Type {
+		case app.TypeFile:
+			names = append(names, e.Name)
+		case app.TypeDir:
+			dirs = append(dirs, e.Name)
+		}
+	}
+	return names, dirs, nil
+}
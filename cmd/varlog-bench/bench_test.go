@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMix(t *testing.T) {
+	tests := []struct {
+		value string
+		list  int
+		read  int
+		ok    bool
+	}{
+		{"read=80,list=20", 20, 80, true},
+		{"list=1", 1, 0, true},
+		{"read=0,list=0", 0, 0, false},
+		{"read=-1", 0, 0, false},
+		{"write=5", 0, 0, false},
+		{"read", 0, 0, false},
+	}
+	for _, test := range tests {
+		list, read, err := parseMix(test.value)
+		if (err == nil) != test.ok || list != test.list || read != test.read {
+			t.Errorf("%q: expected %d, %d, %v, got %d, %d, %v", test.value, test.list, test.read, test.ok, list, read, err)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for j := 1; j <= 100; j++ {
+		sorted = append(sorted, time.Duration(j))
+	}
+	tests := []struct {
+		p        float64
+		expected time.Duration
+	}{
+		{0, 1},
+		{0.5, 50},
+		{0.9, 90},
+		{0.99, 99},
+		{1, 100},
+	}
+	for _, test := range tests {
+		if got := percentile(sorted, test.p); got != test.expected {
+			t.Errorf("p%v: expected %v, got %v", test.p, test.expected, got)
+		}
+	}
+	if got := percentile(nil, 0.5); got != 0 {
+		t.Errorf("empty: expected 0, got %v", got)
+	}
+}
+
+func TestBench_run(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		switch {
+		case request.Header.Get("Authorization") != "Bearer secret":
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		case request.URL.Path == "/list":
+			w.Write([]byte(`[{"name":"syslog","type":"file"},{"name":"nginx","type":"dir"}]`))
+		case request.URL.Path == "/read" && request.URL.Query().Get("name") == "missing":
+			http.Error(w, "not found", http.StatusNotFound)
+		default:
+			w.Write([]byte("line 2\nline 1\n"))
+		}
+	}))
+	defer server.Close()
+
+	b := &bench{
+		server:      server.URL,
+		token:       "secret",
+		client:      server.Client(),
+		mix:         &mix{listWeight: 1, readWeight: 3, filters: []string{"", "ERROR"}, counts: []int{10, 0}},
+		concurrency: 3,
+		requests:    40,
+		seed:        1,
+	}
+	names, dirs, err := b.discover(context.Background())
+	if err != nil || strings.Join(names, ",") != "syslog" || strings.Join(dirs, ",") != "nginx" {
+		t.Fatalf("discover: expected syslog, nginx, got %v, %v, %v", names, dirs, err)
+	}
+	b.mix.names = append(names, "missing")
+	b.mix.dirs = append([]string{""}, dirs...)
+
+	results := b.run(context.Background())
+	if len(results) != 40 {
+		t.Fatalf("expected 40 results, got %d", len(results))
+	}
+	summaries := summarize(results)
+	if len(summaries) != 3 || summaries[0].label != "/list" || summaries[1].label != "/read" || summaries[2].label != "all" {
+		t.Fatalf("expected /list, /read, and all summaries, got %+v", summaries)
+	}
+	list, read := summaries[0], summaries[1]
+	if list.requests == 0 || list.errors != 0 {
+		t.Errorf("expected /list requests without errors, got %+v", list)
+	}
+	if read.requests == 0 || read.errors == 0 || read.errors == read.requests {
+		t.Errorf("expected /read requests, some missing, got %+v", read)
+	}
+	if list.requests+read.requests != summaries[2].requests {
+		t.Errorf("expected all to total the endpoints, got %+v", summaries)
+	}
+	var out strings.Builder
+	report(&out, summaries, time.Second)
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 4 {
+		t.Errorf("expected a heading and 3 rows, got %q", out.String())
+	}
+}
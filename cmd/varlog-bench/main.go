@@ -0,0 +1,132 @@
+// Command varlog-bench sends a mix of /list and /read requests to a
+// running varlog service from concurrent workers, then reports
+// throughput and latency percentiles, for capacity planning.
+//
+// Usage:
+//
+//	varlog-bench [flags] [NAME ...]
+//
+// The NAMEs are the files to read; without them, the files at the top
+// of the tree.  For example, 8 workers for 30 seconds, mostly reading
+// the last 100 or 1000 lines, some filtered:
+//
+//	varlog-bench -c 8 -duration 30s -mix read=90,list=10 \
+//		-filter ,ERROR -counts 100,1000 syslog auth.log
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func main() {
+	server := flag.String("server", envDefault("VARLOG_SERVER", "http://localhost:8000"),
+		"Service URL, including any -base-path.  Defaults to $VARLOG_SERVER.")
+	token := flag.String("token", os.Getenv("VARLOG_TOKEN"),
+		"Bearer token for services with -auth-token.  Defaults to $VARLOG_TOKEN.")
+	concurrency := flag.Int("c", 4, "Concurrent workers, each sending one request at a time.")
+	requests := flag.Int64("n", 0, "Requests to send in all.  0 runs for -duration.")
+	duration := flag.Duration("duration", 10*time.Second, "Time to run, unless -n is given.")
+	mixFlag := flag.String("mix", "read=80,list=20", "Relative weights of /read and /list requests.")
+	filters := flag.String("filter", "",
+		"Comma-separated /read filters, chosen at random.  An empty item, as in \",ERROR\", means no filter.")
+	counts := flag.String("counts", "100",
+		"Comma-separated /read counts, chosen at random.  0 reads whole files.")
+	dirs := flag.String("dirs", "",
+		"Comma-separated directories for /list, chosen at random.  Default the top and its directories.")
+	timeout := flag.Duration("timeout", time.Minute, "Limit on each request.")
+	seed := flag.Int64("seed", 1, "Seed for choosing requests.")
+	flag.Parse()
+
+	if *concurrency < 1 || *requests < 0 || *duration < 0 {
+		fmt.Fprintf(os.Stderr, "*** -c must be positive, -n and -duration not negative\n")
+		os.Exit(2)
+	}
+	m := &mix{filters: strings.Split(*filters, ",")}
+	var err error
+	if m.listWeight, m.readWeight, err = parseMix(*mixFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "*** -mix: %s\n", err)
+		os.Exit(2)
+	}
+	for _, item := range strings.Split(*counts, ",") {
+		n, err := strconv.Atoi(item)
+		if err != nil || n < 0 {
+			fmt.Fprintf(os.Stderr, "*** -counts: invalid count %q\n", item)
+			os.Exit(2)
+		}
+		m.counts = append(m.counts, n)
+	}
+
+	b := &bench{
+		server:      strings.TrimSuffix(*server, "/"),
+		token:       *token,
+		client:      &http.Client{Timeout: *timeout},
+		mix:         m,
+		concurrency: *concurrency,
+		requests:    *requests,
+		seed:        *seed,
+	}
+	if *requests == 0 {
+		b.duration = *duration
+	}
+	b.client.Transport = &http.Transport{MaxIdleConnsPerHost: *concurrency}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	m.names = flag.Args()
+	if *dirs != "" {
+		m.dirs = strings.Split(*dirs, ",")
+	}
+	if len(m.names) == 0 || m.dirs == nil {
+		names, found, err := b.discover(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "*** Cannot list %s: %s\n", b.server, err)
+			os.Exit(1)
+		}
+		if len(m.names) == 0 {
+			m.names = names
+		}
+		if m.dirs == nil {
+			m.dirs = append([]string{""}, found...)
+		}
+	}
+	if len(m.names) == 0 && m.readWeight > 0 {
+		fmt.Fprintf(os.Stderr, "*** No files to read; name some\n")
+		os.Exit(2)
+	}
+
+	fmt.Fprintf(os.Stderr, "%d workers, %s, reading %d files\n", b.concurrency, describeLimit(b), len(m.names))
+	start := time.Now()
+	results := b.run(ctx)
+	elapsed := time.Since(start)
+	report(os.Stdout, summarize(results), elapsed)
+	for _, r := range results {
+		if r.err != nil {
+			fmt.Fprintf(os.Stderr, "*** First error: %s %s\n", r.endpoint, r.err)
+			os.Exit(1)
+		}
+	}
+}
+
+func describeLimit(b *bench) string {
+	if b.requests > 0 {
+		return fmt.Sprintf("%d requests", b.requests)
+	}
+	return b.duration.String()
+}
+
+// envDefault gives the environment variable's value, or the default.
+func envDefault(name string, value string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return value
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// A summary of the results for an endpoint, or all of them.
+type summary struct {
+	label    string
+	requests int
+	errors   int
+	bytes    int64
+	p50      time.Duration // Latency percentiles of successful requests
+	p90      time.Duration
+	p99      time.Duration
+	max      time.Duration
+}
+
+// percentile gives the latency below which the fraction p of the
+// sorted latencies fall, by the nearest rank.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// summarize gives a summary for each endpoint, then for all.
+func summarize(results []result) []summary {
+	byEndpoint := map[string][]result{}
+	var endpoints []string
+	for _, r := range results {
+		if _, ok := byEndpoint[r.endpoint]; !ok {
+			endpoints = append(endpoints, r.endpoint)
+		}
+		byEndpoint[r.endpoint] = append(byEndpoint[r.endpoint], r)
+	}
+	sort.Strings(endpoints)
+	var summaries []summary
+	for _, endpoint := range endpoints {
+		summaries = append(summaries, summarizeOne(endpoint, byEndpoint[endpoint]))
+	}
+	if len(endpoints) > 1 {
+		summaries = append(summaries, summarizeOne("all", results))
+	}
+	return summaries
+}
+
+func summarizeOne(label string, results []result) summary {
+	s := summary{label: label, requests: len(results)}
+	var latencies []time.Duration
+	for _, r := range results {
+		if r.err != nil {
+			s.errors++
+			continue
+		}
+		s.bytes += r.bytes
+		latencies = append(latencies, r.latency)
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	s.p50 = percentile(latencies, 0.50)
+	s.p90 = percentile(latencies, 0.90)
+	s.p99 = percentile(latencies, 0.99)
+	if len(latencies) > 0 {
+		s.max = latencies[len(latencies)-1]
+	}
+	return s
+}
+
+// report writes the summaries as a table, with rates over the elapsed time.
+func report(w io.Writer, summaries []summary, elapsed time.Duration) {
+	fmt.Fprintf(w, "%-8s %9s %7s %10s %10s %10s %10s %10s %10s\n",
+		"endpoint", "requests", "errors", "req/s", "MB/s", "p50", "p90", "p99", "max")
+	seconds := elapsed.Seconds()
+	for _, s := range summaries {
+		fmt.Fprintf(w, "%-8s %9d %7d %10.1f %10.2f %10s %10s %10s %10s\n",
+			s.label, s.requests, s.errors,
+			float64(s.requests)/seconds, float64(s.bytes)/1e6/seconds,
+			round(s.p50), round(s.p90), round(s.p99), round(s.max))
+	}
+}
+
+// round shortens a latency for the table.
+func round(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	}
+	return d.Round(time.Microsecond)
+}
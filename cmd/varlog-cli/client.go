@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"varlog/service/app"
+)
+
+// Longest line read from the service.
+const maxLineBytes = 1024 * 1024
+
+// client sends requests to the service.
+type client struct {
+	server string // Base URL, without a trailing slash
+	token  string
+	http   *http.Client
+}
+
+// An entry of /list.
+type entry struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// readQuery gives the /read parameters.  Zero values are omitted,
+// leaving the service's defaults.
+func readQuery(name string, filter string, count int, multiline bool) url.Values {
+	q := url.Values{app.ParamName: {name}}
+	if filter != "" {
+		q.Set(app.ParamFilter, filter)
+	}
+	if count > 0 {
+		q.Set(app.ParamCount, strconv.Itoa(count))
+	}
+	if multiline {
+		q.Set(app.ParamMultiline, "true")
+	}
+	return q
+}
+
+// get sends a GET for the endpoint, giving the response of a
+// successful request.  Failures give the message of the service's
+// error envelope.  The caller closes the body.
+func (c *client) get(ctx context.Context, endpoint string, q url.Values) (*http.Response, error) {
+	u := c.server + endpoint
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	response, err := c.http.Do(request)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	if response.StatusCode == http.StatusOK {
+		return response, nil
+	}
+	defer response.Body.Close()
+	var envelope struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	b, _ := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+	if json.Unmarshal(b, &envelope) == nil && envelope.Error.Message != "" {
+		return nil, errors.New(fmt.Sprintf("%s (%s)", envelope.Error.Message, response.Status))
+	}
+	return nil, errors.New(fmt.Sprintf("%s: %s", endpoint, response.Status))
+}
+
+// list gives the entries of /list.
+func (c *client) list(ctx context.Context, name string, filter string) ([]entry, error) {
+	q := url.Values{}
+	if name != "" {
+		q.Set(app.ParamName, name)
+	}
+	if filter != "" {
+		q.Set(app.ParamFilter, filter)
+	}
+	response, err := c.get(ctx, "/list", q)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	var entries []entry
+	if err := json.NewDecoder(response.Body).Decode(&entries); err != nil {
+		return nil, errors.New(fmt.Sprintf("/list response: %s", err))
+	}
+	return entries, nil
+}
+
+// read passes the lines of /read to fn, most recent first, until fn
+// returns false.  It gives the number of lines passed.  A response
+// cut short by the service's limits is reported on standard error.
+func (c *client) read(ctx context.Context, q url.Values, fn func(string) bool) (int, error) {
+	response, err := c.get(ctx, "/read", q)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	scanner := bufio.NewScanner(response.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxLineBytes)
+	var lines int
+	for scanner.Scan() {
+		lines++
+		if !fn(scanner.Text()) {
+			return lines, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			return lines, ctx.Err()
+		}
+		return lines, err
+	}
+	if reason := response.Trailer.Get(app.HdrTruncated); reason != "" {
+		fmt.Fprintf(os.Stderr, "*** Response truncated by the service: %s\n", reason)
+	}
+	return lines, nil
+}
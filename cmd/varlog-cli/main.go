@@ -0,0 +1,212 @@
+// Command varlog-cli reads logs from a running varlog service, for
+// operators on jump hosts who would rather not assemble curl commands
+// and page through JSON.
+//
+// Usage:
+//
+//	varlog-cli [-server URL] [-token TOKEN] [-color WHEN] COMMAND [flags] ARGS
+//
+// Commands:
+//
+//	list [-filter TEXT] [NAME]
+//	read [-filter TEXT] [-count N] [-multiline] NAME
+//	grep [-v] [-count N] [-multiline] TEXT NAME
+//	tail [-n N] [-f] [-interval D] [-filter TEXT] NAME
+//
+// The flags mirror the service's query parameters.  list and read
+// print what the service gives, most recent lines first; tail prints
+// the latest lines in file order and, with -f, follows the file.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Exit codes: as for grep, 1 also means no lines matched.
+const (
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+const usage = `Usage: varlog-cli [flags] COMMAND [command flags] ARGS
+
+Commands:
+  list [-filter TEXT] [NAME]
+        List a directory, or the top, one entry per line.
+  read [-filter TEXT] [-count N] [-multiline] NAME
+        Print a file's lines, most recent first.
+  grep [-v] [-count N] [-multiline] TEXT NAME
+        Print lines containing TEXT (with -v, lines without it),
+        most recent first.  TEXT is plain text, not a pattern.
+  tail [-n N] [-f] [-interval D] [-filter TEXT] NAME
+        Print the last N lines in file order; with -f, follow the file.
+
+Run "varlog-cli COMMAND -help" for a command's flags.
+
+Flags:
+`
+
+func main() {
+	server := flag.String("server", envDefault("VARLOG_SERVER", "http://localhost:8000"),
+		"Service URL, including any -base-path, as in https://host/varlog.\n"+
+			"Defaults to $VARLOG_SERVER.")
+	token := flag.String("token", os.Getenv("VARLOG_TOKEN"),
+		"Bearer token for services with -auth-token.  Defaults to $VARLOG_TOKEN.")
+	color := flag.String("color", colorAuto,
+		"Color lines by level: auto (for terminals), always, or never.")
+	timeout := flag.Duration("timeout", 30*time.Second,
+		"Limit on each request, other than tail -f's waiting.")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	p, err := newPainter(*color)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "*** %s\n", err)
+		os.Exit(exitUsage)
+	}
+
+	c := &client{
+		server: strings.TrimSuffix(*server, "/"),
+		token:  *token,
+		http:   &http.Client{Timeout: *timeout},
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	commands := map[string]func(context.Context, *client, painter, []string) int{
+		"list": listCommand,
+		"read": readCommand,
+		"grep": grepCommand,
+		"tail": tailCommand,
+	}
+	command, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "*** Unknown command %q\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	os.Exit(command(ctx, c, p, flag.Args()[1:]))
+}
+
+// envDefault gives the environment variable's value, or the default.
+func envDefault(name string, value string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return value
+}
+
+// commandFlags gives a flag set for the command, reporting its usage.
+func commandFlags(name string, args string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: varlog-cli %s [flags] %s\n\nFlags:\n", name, args)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseArgs parses the command's flags and checks the count of
+// remaining arguments, from min to max.
+func parseArgs(flags *flag.FlagSet, args []string, min int, max int) bool {
+	flags.Parse(args)
+	if flags.NArg() < min || flags.NArg() > max {
+		flags.Usage()
+		return false
+	}
+	return true
+}
+
+// fail reports an error, giving the exit code.
+func fail(err error) int {
+	if err == context.Canceled {
+		return exitFailure
+	}
+	fmt.Fprintf(os.Stderr, "*** %s\n", err)
+	return exitFailure
+}
+
+func listCommand(ctx context.Context, c *client, p painter, args []string) int {
+	flags := commandFlags("list", "[NAME]")
+	filter := flags.String("filter", "", "Only names containing the text, or with a leading -, not containing it.")
+	if !parseArgs(flags, args, 0, 1) {
+		return exitUsage
+	}
+	entries, err := c.list(ctx, flags.Arg(0), *filter)
+	if err != nil {
+		return fail(err)
+	}
+	for _, e := range entries {
+		fmt.Println(p.entry(e))
+	}
+	return 0
+}
+
+// readFlags adds the flags of /read's parameters common to read and grep.
+func readFlags(flags *flag.FlagSet) (count *int, multiline *bool) {
+	count = flags.Int("count", 0, "Most lines to print.  0 prints all.")
+	multiline = flags.Bool("multiline", false,
+		"Treat indented continuation lines, such as stack traces, as part of a record.")
+	return count, multiline
+}
+
+func readCommand(ctx context.Context, c *client, p painter, args []string) int {
+	flags := commandFlags("read", "NAME")
+	filter := flags.String("filter", "", "Only lines containing the text, or with a leading -, not containing it.")
+	count, multiline := readFlags(flags)
+	if !parseArgs(flags, args, 1, 1) {
+		return exitUsage
+	}
+	q := readQuery(flags.Arg(0), *filter, *count, *multiline)
+	_, err := c.read(ctx, q, func(line string) bool {
+		fmt.Println(p.line(line, ""))
+		return true
+	})
+	if err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+func grepCommand(ctx context.Context, c *client, p painter, args []string) int {
+	flags := commandFlags("grep", "TEXT NAME")
+	invert := flags.Bool("v", false, "Print lines not containing the text.")
+	count, multiline := readFlags(flags)
+	if !parseArgs(flags, args, 2, 2) {
+		return exitUsage
+	}
+	text, highlight := flags.Arg(0), flags.Arg(0)
+	if text == "" {
+		fmt.Fprintf(os.Stderr, "*** Empty text matches every line; use read\n")
+		return exitUsage
+	}
+	if *invert {
+		text, highlight = "-"+text, ""
+	}
+	q := readQuery(flags.Arg(1), text, *count, *multiline)
+	lines, err := c.read(ctx, q, func(line string) bool {
+		fmt.Println(p.line(line, highlight))
+		return true
+	})
+	if err != nil {
+		return fail(err)
+	}
+	if lines == 0 {
+		return exitFailure
+	}
+	return 0
+}
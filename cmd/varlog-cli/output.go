@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"varlog/service/app"
+)
+
+// Values of -color.
+const (
+	colorAuto   = "auto"
+	colorAlways = "always"
+	colorNever  = "never"
+)
+
+// ANSI escape sequences.
+const (
+	ansiRed    = "\x1b[31m"
+	ansiYellow = "\x1b[33m"
+	ansiBlue   = "\x1b[34m"
+	ansiDim    = "\x1b[2m"
+	ansiBold   = "\x1b[1m"
+	ansiReset  = "\x1b[0m"
+)
+
+// Colors by level words.  Words match whole and ignoring case, so
+// "error" colors a line but "errors" does not.
+var levelColors = map[string]string{
+	"emerg":    ansiRed,
+	"alert":    ansiRed,
+	"crit":     ansiRed,
+	"critical": ansiRed,
+	"fatal":    ansiRed,
+	"panic":    ansiRed,
+	"err":      ansiRed,
+	"error":    ansiRed,
+	"warn":     ansiYellow,
+	"warning":  ansiYellow,
+	"debug":    ansiDim,
+	"trace":    ansiDim,
+}
+
+// painter colors output, or leaves it plain.
+type painter struct {
+	enabled bool
+}
+
+// newPainter gives the painter for the -color value.  With auto,
+// output is colored for terminals, unless NO_COLOR is set.
+func newPainter(when string) (painter, error) {
+	switch when {
+	case colorAlways:
+		return painter{enabled: true}, nil
+	case colorNever:
+		return painter{}, nil
+	case colorAuto:
+		info, err := os.Stdout.Stat()
+		terminal := err == nil && info.Mode()&os.ModeCharDevice != 0
+		return painter{enabled: terminal && os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb"}, nil
+	}
+	return painter{}, errors.New(fmt.Sprintf("-color %q not auto, always, or never", when))
+}
+
+// levelColor gives the color for the first level word in the line,
+// or "" if it has none.
+func levelColor(line string) string {
+	words := strings.FieldsFunc(line, func(r rune) bool { return !unicode.IsLetter(r) })
+	for _, w := range words {
+		if c, ok := levelColors[strings.ToLower(w)]; ok {
+			return c
+		}
+	}
+	return ""
+}
+
+// line colors a log line by its level, with each occurrence of the
+// highlight text in bold.
+func (p painter) line(s string, highlight string) string {
+	if !p.enabled {
+		return s
+	}
+	color := levelColor(s)
+	if highlight != "" {
+		s = strings.ReplaceAll(s, highlight, ansiBold+highlight+ansiReset+color)
+	}
+	if color == "" && highlight == "" {
+		return s
+	}
+	return color + s + ansiReset
+}
+
+// entry formats a /list entry, marking directories with a trailing slash.
+func (p painter) entry(e entry) string {
+	if e.Type != app.TypeDir {
+		return e.Name
+	}
+	if !p.enabled {
+		return e.Name + "/"
+	}
+	return ansiBlue + e.Name + "/" + ansiReset
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// Lines each poll of tail -f reads.  More new lines than this
+	// between polls lose the position.
+	tailWindow = 1000
+
+	// Printed lines remembered to find the position again.
+	tailMemory = 10
+)
+
+// tailCommand prints a file's last lines in file order and, with -f,
+// polls for new ones.  /read has no offsets, so each poll reads the
+// latest lines and finds where the remembered lines end among them.
+func tailCommand(ctx context.Context, c *client, p painter, args []string) int {
+	flags := commandFlags("tail", "NAME")
+	n := flags.Int("n", 10, "Lines to print first.")
+	follow := flags.Bool("f", false, "Follow the file, printing new lines as they arrive.")
+	interval := flags.Duration("interval", 2*time.Second, "Time between polls, with -f.")
+	filter := flags.String("filter", "", "Only lines containing the text, or with a leading -, not containing it.")
+	if !parseArgs(flags, args, 1, 1) {
+		return exitUsage
+	}
+	if *n < 0 || *interval <= 0 {
+		flags.Usage()
+		return exitUsage
+	}
+	name := flags.Arg(0)
+
+	count := *n
+	if *follow && count < tailMemory {
+		count = tailMemory
+	}
+	seen, err := latestLines(ctx, c, name, *filter, count)
+	if err != nil {
+		return fail(err)
+	}
+	printLines(p, seen[max(len(seen)-*n, 0):])
+	if !*follow {
+		return 0
+	}
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-ticker.C:
+		}
+		batch, err := latestLines(ctx, c, name, *filter, tailWindow)
+		if ctx.Err() != nil {
+			return 0
+		}
+		if err != nil {
+			// Keep following through restarts and rotations.
+			fmt.Fprintf(os.Stderr, "*** %s\n", err)
+			continue
+		}
+		fresh, ok := newLines(seen[max(len(seen)-tailMemory, 0):], batch)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "*** Position lost in %s (rotated, or more than %d new lines)\n",
+				name, tailWindow)
+		}
+		printLines(p, fresh)
+		seen = batch
+	}
+}
+
+// latestLines reads up to count of the file's latest lines, in file order.
+func latestLines(ctx context.Context, c *client, name string, filter string, count int) ([]string, error) {
+	var lines []string
+	_, err := c.read(ctx, readQuery(name, filter, count, false), func(line string) bool {
+		lines = append(lines, line)
+		return true
+	})
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines, err
+}
+
+func printLines(p painter, lines []string) {
+	for _, line := range lines {
+		fmt.Println(p.line(line, ""))
+	}
+}
+
+// newLines gives the lines of batch, the file's latest lines in file
+// order, that follow the previously seen lines.  The position is the
+// last place the seen lines occur, so a poll with no new lines gives
+// none; new lines ending exactly as the seen ones did are missed.
+// Without a match, as after a rotation, all of batch is new and ok is
+// false.
+func newLines(seen []string, batch []string) (fresh []string, ok bool) {
+	if len(seen) == 0 {
+		return batch, true
+	}
+	for end := len(batch); end >= len(seen); end-- {
+		if equal(batch[end-len(seen):end], seen) {
+			return batch[end:], true
+		}
+	}
+	return batch, false
+}
+
+func equal(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func max(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
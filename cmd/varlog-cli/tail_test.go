@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewLines(t *testing.T) {
+	tests := []struct {
+		seen  []string
+		batch []string
+		fresh []string
+		ok    bool
+	}{
+		{nil, []string{"a", "b"}, []string{"a", "b"}, true},
+		{[]string{"a", "b"}, []string{"a", "b"}, []string{}, true},
+		{[]string{"a", "b"}, []string{"x", "a", "b", "c", "d"}, []string{"c", "d"}, true},
+		{[]string{"a", "b"}, []string{"a", "b", "c", "a", "b", "d"}, []string{"d"}, true},
+		{[]string{"a", "b"}, []string{"c", "d"}, []string{"c", "d"}, false},
+		{[]string{"a", "b"}, []string{}, []string{}, false},
+	}
+	for _, test := range tests {
+		fresh, ok := newLines(test.seen, test.batch)
+		if ok != test.ok || !reflect.DeepEqual(fresh, test.fresh) {
+			t.Errorf("%v, %v: expected %v, %v, got %v, %v", test.seen, test.batch, test.fresh, test.ok, fresh, ok)
+		}
+	}
+}
+
+func TestLevelColor(t *testing.T) {
+	tests := []struct {
+		line  string
+		color string
+	}{
+		{"2023/02/17 10:28:24 aaaaa 1 ERROR abcde", ansiRed},
+		{"level=warn msg=slow", ansiYellow},
+		{"[DEBUG] cache miss", ansiDim},
+		{"no errors found", ""},
+		{"INFO then ERROR", ansiRed},
+	}
+	for _, test := range tests {
+		if got := levelColor(test.line); got != test.color {
+			t.Errorf("%q: expected %q, got %q", test.line, test.color, got)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Package revread reads a file's lines backwards, newest first, as
+// the service's /read does, for Go programs that want "tail a file
+// backwards" without running the service:
+//
+//	file, err := os.Open("/var/log/syslog")
+//	...
+//	info, err := file.Stat()
+//	...
+//	r := revread.New(file, info.Size())
+//	for line, ok := r.Next(); ok; line, ok = r.Next() {
+//		fmt.Println(line)
+//	}
+//	if err := r.Err(); err != nil {
+//		...
+//	}
+//
+// A file is any io.ReaderAt with its size: an *os.File, a
+// bytes.Reader, or a section of either.  Lines are given without
+// their newlines, or a "\r" before one.  The file is read in chunks
+// from its end, so memory stays bounded by the chunk and the longest
+// line however large the file, and the first lines arrive without
+// reading the rest.
+//
+// The package wraps the service's scan.Reverser, which the service's
+// own reads use, so both split lines the same way.
+package revread
+
+import (
+	"context"
+	"io"
+	"varlog/service/scan"
+)
+
+// Bytes a read by default, as the service's -chunk-size.
+const DefaultChunkSize = 64 * 1024
+
+// A ChangedError reports that the file held fewer bytes than its
+// size when the read began, as after a truncation.  Lines already
+// given are sound, but no more can be trusted.  Test for it with
+// errors.As.
+type ChangedError = scan.ChangedError
+
+// Options tune a Reader.  The zero Options are the defaults.
+type Options struct {
+	ChunkSize int // Bytes a read, DefaultChunkSize if 0
+	MaxLine   int // Bytes kept of a line, 0 for whole lines; see below
+}
+
+// A Reader gives a file's lines, last first.  It is not safe for
+// concurrent use.
+type Reader struct {
+	r     *scan.Reverser
+	lines []string // Lines of the chunk read last, newest first
+	next  int      // Index in lines of the line Next gives
+}
+
+// New gives a Reader of the file, whose length is size bytes.  The
+// caller remains responsible for closing the file.
+func New(file io.ReaderAt, size int64) *Reader {
+	return NewOptions(context.Background(), file, size, Options{})
+}
+
+// NewOptions gives a Reader of the file with the options.  Canceling
+// the context stops the read: Next gives false, and Err the context's
+// error.  With MaxLine, a line longer than MaxLine bytes is cut to
+// MaxLine+1, so the caller can tell it was long, rather than held
+// whole; a file without newlines then cannot fill memory.
+func NewOptions(ctx context.Context, file io.ReaderAt, size int64, options Options) *Reader {
+	if options.ChunkSize <= 0 {
+		options.ChunkSize = DefaultChunkSize
+	}
+	r := scan.NewReverser(ctx, file, size, options.ChunkSize)
+	r.SetMaxLine(options.MaxLine)
+	return &Reader{r: r}
+}
+
+// Next gives the line before the last one given, starting from the
+// file's last line.  At the start of the file, or on an error, it
+// gives false; Err then tells which.
+func (r *Reader) Next() (string, bool) {
+	for r.next >= len(r.lines) {
+		if !r.r.Scan() {
+			r.lines, r.next = nil, 0
+			return "", false
+		}
+		r.lines, r.next = r.r.Lines(), 0
+	}
+	line := r.lines[r.next]
+	r.next++
+	return line, true
+}
+
+// Err gives the error that ended the read, or nil if it reached the
+// start of the file.
+func (r *Reader) Err() error {
+	return r.r.Err()
+}
+
+// BytesRead gives the bytes of the file read so far.
+func (r *Reader) BytesRead() int64 {
+	return r.r.BytesRead()
+}
@@ -0,0 +1,62 @@
+package revread
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readAll gives the lines a Reader gives, and its error.
+func readAll(r *Reader) ([]string, error) {
+	var lines []string
+	for line, ok := r.Next(); ok; line, ok = r.Next() {
+		lines = append(lines, line)
+	}
+	return lines, r.Err()
+}
+
+func TestReader(t *testing.T) {
+	tests := []struct {
+		data  string
+		lines []string
+	}{
+		{"", nil},
+		{"one", []string{"one"}},
+		{"one\ntwo\n", []string{"two", "one"}},
+		{"one\r\n\ntwo", []string{"two", "", "one"}},
+	}
+	for _, test := range tests {
+		for _, chunkSize := range []int{1, 2, 3, DefaultChunkSize} {
+			r := NewOptions(context.Background(), strings.NewReader(test.data), int64(len(test.data)), Options{ChunkSize: chunkSize})
+			lines, err := readAll(r)
+			if err != nil || !reflect.DeepEqual(lines, test.lines) {
+				t.Errorf("%q, chunk %d: expected %q, got %q %v", test.data, chunkSize, test.lines, lines, err)
+			}
+			if _, ok := r.Next(); ok {
+				t.Errorf("%q, chunk %d: Next after the end gave a line", test.data, chunkSize)
+			}
+		}
+	}
+	data := strings.Repeat("x", 100) + "\nshort\n"
+	lines, _ := readAll(NewOptions(context.Background(), strings.NewReader(data), int64(len(data)), Options{MaxLine: 10}))
+	if !reflect.DeepEqual(lines, []string{"short", strings.Repeat("x", 11)}) {
+		t.Errorf("MaxLine: got %q", lines)
+	}
+}
+
+func TestReader_errors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if lines, err := readAll(NewOptions(ctx, strings.NewReader("a\nb\n"), 4, Options{})); len(lines) != 0 || err != context.Canceled {
+		t.Errorf("canceled: got %q %v", lines, err)
+	}
+	// The file is shorter than its size, as after a truncation.
+	_, err := readAll(New(bytes.NewReader([]byte("a\nb\n")), 8))
+	var changed *ChangedError
+	if !errors.As(err, &changed) {
+		t.Errorf("truncated: expected a ChangedError, got %v", err)
+	}
+}
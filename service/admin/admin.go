@@ -0,0 +1,128 @@
+// Package admin provides endpoints for operating the service,
+// as opposed to reading logs:
+//
+//   - /health reports whether the service should receive traffic.
+//     It is not authenticated, so load balancers and kubernetes
+//     probes can use it.
+//   - /admin/maintenance reports (GET) or changes (POST) maintenance
+//     mode.  Parameter 'enable=true|false' selects the mode.
+//     See app.Maintenance.
+//   - /admin/log-level reports (GET) or changes (POST) the minimum
+//     level logged.  Parameter 'level=DEBUG|INFO|WARNING|ERROR'.
+//   - /admin/cache reports (GET) the caches' hit rates, or flushes
+//     (POST) them, so results reflect logs changed by hand.
+//
+// The /admin endpoints are refused unless credentials or client
+// certificates are configured; the server package enforces this.
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"varlog/service/app"
+)
+
+const (
+	paramEnable = "enable" // Name of the 'enable' parameter
+	paramLevel  = "level"  // Name of the 'level' parameter
+
+	statusDraining = "draining"
+	statusOK       = "ok"
+)
+
+// The /health and /admin/maintenance response body.
+type status struct {
+	Status      string `json:"status"`      // "ok" or "draining"
+	Maintenance bool   `json:"maintenance"` // True in maintenance mode
+}
+
+// HealthHandler reports the service's health.  In maintenance mode the
+// status is 503, so probes take the instance out of rotation.
+func HealthHandler(writer http.ResponseWriter, request *http.Request) {
+	if app.Maintenance() {
+		writer.Header().Set(app.HdrRetryAfter, app.RetryAfterSeconds())
+		writeStatus(writer, http.StatusServiceUnavailable)
+		return
+	}
+	writeStatus(writer, http.StatusOK)
+}
+
+// MaintenanceHandler reports or changes maintenance mode.
+// Changes require POST, so a stray browser visit cannot drain the server.
+func MaintenanceHandler(writer http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case http.MethodGet, http.MethodHead:
+		writeStatus(writer, http.StatusOK)
+
+	case http.MethodPost:
+		enable, err := parseEnable(request)
+		if err != nil {
+			app.Log(app.LogWarning, "%s", err)
+			app.WriteError(writer, request, err)
+			return
+		}
+		app.SetMaintenance(enable)
+		writeStatus(writer, http.StatusOK)
+
+	default:
+		writer.Header().Set("Allow", "GET, HEAD, POST")
+		app.Error(writer, request, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// The /admin/log-level response body.
+type logLevel struct {
+	Level string `json:"level"`
+}
+
+// LogLevelHandler reports or changes the log level.
+// As with maintenance mode, changes require POST.
+func LogLevelHandler(writer http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case http.MethodGet, http.MethodHead:
+
+	case http.MethodPost:
+		if err := request.ParseForm(); err != nil {
+			app.Error(writer, request, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := app.SetLogLevel(request.Form.Get(paramLevel)); err != nil {
+			app.Log(app.LogWarning, "%s", err)
+			app.WriteError(writer, request, app.ParamError(paramLevel, err.Error()))
+			return
+		}
+
+	default:
+		writer.Header().Set("Allow", "GET, HEAD, POST")
+		app.Error(writer, request, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(logLevel{Level: app.LogLevel()})
+}
+
+// parseEnable extracts the required 'enable' parameter.
+func parseEnable(request *http.Request) (bool, error) {
+	if err := request.ParseForm(); err != nil {
+		return false, app.NewHTTPError(http.StatusBadRequest, app.CodeInvalidParam, err.Error())
+	}
+	value := request.Form.Get(paramEnable)
+	enable, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, app.ParamError(paramEnable, fmt.Sprintf("Invalid value %s=%q", paramEnable, value))
+	}
+	return enable, nil
+}
+
+// writeStatus writes the current status as JSON.
+func writeStatus(writer http.ResponseWriter, code int) {
+	s := status{Status: statusOK, Maintenance: app.Maintenance()}
+	if s.Maintenance {
+		s.Status = statusDraining
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(code)
+	json.NewEncoder(writer).Encode(s)
+}
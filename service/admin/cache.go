@@ -0,0 +1,116 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"varlog/service/app"
+	"varlog/service/index"
+	"varlog/service/list"
+)
+
+const (
+	paramCache = "cache" // Name of the /admin/cache 'cache' parameter
+	paramPath  = "path"  // Name of the /admin/cache 'path' parameter
+
+	cacheIndex = "index" // Line-offset indexes, see package index
+	cacheList  = "list"  // Directory listings, see list.CacheUsage
+)
+
+// One cache in the /admin/cache response body.
+type cacheStatus struct {
+	Entries int     `json:"entries"`
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	HitRate float64 `json:"hitRate"`
+	Flushed *int    `json:"flushed,omitempty"` // Entries dropped, after POST
+}
+
+// CacheHandler reports the caches' use (GET), or flushes them (POST).
+// Parameter 'cache=list|index' selects one cache, otherwise all, and
+// 'path' the entries for a file or directory on the server and those
+// below it, otherwise all entries.
+func CacheHandler(writer http.ResponseWriter, request *http.Request) {
+	flushed := map[string]int{}
+	switch request.Method {
+	case http.MethodGet, http.MethodHead:
+
+	case http.MethodPost:
+		if err := flushCaches(request, flushed); err != nil {
+			app.Log(app.LogWarning, "%s", err)
+			app.WriteError(writer, request, err)
+			return
+		}
+
+	default:
+		writer.Header().Set("Allow", "GET, HEAD, POST")
+		app.Error(writer, request, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caches := map[string]cacheStatus{}
+	entries, hits, misses := list.CacheUsage()
+	caches[cacheList] = newCacheStatus(entries, hits, misses)
+	entries, hits, misses = index.Usage()
+	caches[cacheIndex] = newCacheStatus(entries, hits, misses)
+	for name, n := range flushed {
+		n := n
+		status := caches[name]
+		status.Flushed = &n
+		caches[name] = status
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(map[string]interface{}{"caches": caches})
+}
+
+// flushCaches flushes the caches the request selects, recording how
+// many entries each dropped.
+func flushCaches(request *http.Request, flushed map[string]int) error {
+	if err := request.ParseForm(); err != nil {
+		return app.NewHTTPError(http.StatusBadRequest, app.CodeInvalidParam, err.Error())
+	}
+	cache := request.Form.Get(paramCache)
+	switch cache {
+	case "", cacheList, cacheIndex:
+	default:
+		return app.ParamError(paramCache, fmt.Sprintf("Invalid value %s=%q", paramCache, cache))
+	}
+	path := request.Form.Get(paramPath)
+	if path != "" && !strings.HasPrefix(path, "/") {
+		return app.ParamError(paramPath, fmt.Sprintf("Invalid value %s=%q, must be absolute", paramPath, path))
+	}
+	if cache == "" || cache == cacheList {
+		if path == "" {
+			entries, _, _ := list.CacheUsage()
+			list.InvalidateAll()
+			flushed[cacheList] = entries
+		} else {
+			flushed[cacheList] = list.InvalidateTree(path)
+		}
+	}
+	if cache == "" || cache == cacheIndex {
+		dir := app.RequestProperties(request).IndexDir()
+		if path == "" {
+			path = "/"
+		}
+		n := 0
+		if dir != "" {
+			var err error
+			if n, err = index.Invalidate(dir, path); err != nil {
+				return err
+			}
+		}
+		flushed[cacheIndex] = n
+	}
+	app.Log(app.LogInfo, "Flushed caches %v", flushed)
+	return nil
+}
+
+// newCacheStatus describes a cache's use.
+func newCacheStatus(entries int, hits int64, misses int64) cacheStatus {
+	status := cacheStatus{Entries: entries, Hits: hits, Misses: misses}
+	if lookups := hits + misses; lookups > 0 {
+		status.HitRate = float64(hits) / float64(lookups)
+	}
+	return status
+}
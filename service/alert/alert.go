@@ -0,0 +1,217 @@
+// Package alert runs saved queries on a schedule and posts a webhook
+// when one's matches reach a threshold: an alerting loop on the host
+// itself, for the time before logs reach a central system.  Rules come
+// from an -alert-file:
+//
+//	# query: setting ...
+//	nginx-5xx: threshold=10 every=5m webhook=https://hooks.slack.com/services/T0/B0/X format=slack
+//	disk-full: threshold=1 webhook=https://alerts.example.com/varlog
+//
+// Each rule names a saved query (see app.Query) of /read, /aggregate,
+// or /top.  Every interval (every=, default 5m), the query counts its
+// matches among the lines of that interval, as with since=5m.  When the
+// count reaches the threshold after being below it, the webhook gets a
+// POST: a JSON object, or with format=slack, a Slack message.  A spike
+// lasting several intervals notifies once; the next notification
+// waits for the count to fall below the threshold and rise again.
+package alert
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"varlog/service/app"
+)
+
+// Webhook body formats.
+const (
+	FormatJSON  = "json"  // A notification object
+	FormatSlack = "slack" // A Slack incoming webhook message
+)
+
+const (
+	// Interval between runs of a rule, without every=, and the
+	// shortest allowed.
+	defaultEvery = 5 * time.Minute
+	minEvery     = 10 * time.Second
+
+	// Time allowed for a webhook to respond.
+	webhookTimeout = 10 * time.Second
+)
+
+var client = &http.Client{Timeout: webhookTimeout}
+
+// A Rule runs a saved query every interval, notifying the webhook when
+// its matches reach the threshold.
+type Rule struct {
+	Query     string        // Name of the saved query
+	Threshold int           // Matches that notify
+	Every     time.Duration // Interval between runs, and the lines counted
+	Webhook   string        // URL posted to
+	Format    string        // FormatJSON or FormatSlack
+}
+
+// A Counter counts the matches of the named query among the lines
+// since the time given, ago.
+type Counter func(ctx context.Context, query string, since time.Duration) (int, error)
+
+// The JSON webhook body.
+type notification struct {
+	Alert     string `json:"alert"`     // The query's name
+	Matches   int    `json:"matches"`   // Matches in the interval
+	Threshold int    `json:"threshold"` // The rule's threshold
+	Interval  string `json:"interval"`  // The interval, as a Go duration
+	Host      string `json:"host"`      // The server's host name
+	Time      string `json:"time"`      // When the query ran, RFC 3339
+}
+
+// Load reads an alert file.
+func Load(name string) ([]Rule, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var rules []Rule
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		query, settings, found := strings.Cut(line, ":")
+		query = strings.TrimSpace(query)
+		if !found || query == "" {
+			return nil, errors.New(fmt.Sprintf("%s:%d: expected query: settings", name, lineNumber))
+		}
+		rule := Rule{Query: query, Every: defaultEvery, Format: FormatJSON}
+		for _, setting := range strings.Fields(settings) {
+			if err := rule.parse(setting); err != nil {
+				return nil, errors.New(fmt.Sprintf("%s:%d: %s", name, lineNumber, err))
+			}
+		}
+		if rule.Threshold == 0 || rule.Webhook == "" {
+			return nil, errors.New(fmt.Sprintf("%s:%d: threshold= and webhook= are required", name, lineNumber))
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// parse sets one setting from its key=value form.
+func (rule *Rule) parse(setting string) error {
+	key, value, _ := strings.Cut(setting, "=")
+	switch key {
+	case "threshold":
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return errors.New(fmt.Sprintf("bad setting %q, expected a positive number", setting))
+		}
+		rule.Threshold = n
+	case "every":
+		d, err := time.ParseDuration(value)
+		if err != nil || d < minEvery {
+			return errors.New(fmt.Sprintf("bad setting %q, expected a duration of at least %s", setting, minEvery))
+		}
+		rule.Every = d
+	case "webhook":
+		u, err := url.Parse(value)
+		if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return errors.New(fmt.Sprintf("bad setting %q, expected an http or https URL", setting))
+		}
+		rule.Webhook = value
+	case "format":
+		if value != FormatJSON && value != FormatSlack {
+			return errors.New(fmt.Sprintf("bad setting %q, expected %s or %s", setting, FormatJSON, FormatSlack))
+		}
+		rule.Format = value
+	default:
+		return errors.New(fmt.Sprintf("unknown setting %q, expected threshold=, every=, webhook=, or format=", setting))
+	}
+	return nil
+}
+
+// Watch runs the rule every interval until the context ends.
+func (rule Rule) Watch(ctx context.Context, count Counter) error {
+	ticker := time.NewTicker(rule.Every)
+	defer ticker.Stop()
+	above := false
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			above = rule.check(ctx, count, above)
+		}
+	}
+}
+
+// check runs the query once, notifying if its matches reach the
+// threshold and were below it before.  Gives whether they are at or
+// above it now.  Errors are logged; a query that fails is left as
+// it was.
+func (rule Rule) check(ctx context.Context, count Counter, above bool) bool {
+	matches, err := count(ctx, rule.Query, rule.Every)
+	if err != nil {
+		if ctx.Err() == nil {
+			app.Log(app.LogWarning, "Alert %q query failed, %s", rule.Query, err)
+		}
+		return above
+	}
+	app.Log(app.LogDebug, "Alert %q: %d matches, threshold %d", rule.Query, matches, rule.Threshold)
+	if matches < rule.Threshold {
+		return false
+	}
+	if !above {
+		app.Log(app.LogInfo, "Alert %q: %d matches in %s, threshold %d", rule.Query, matches, rule.Every, rule.Threshold)
+		if err := rule.notify(ctx, matches); err != nil {
+			app.Log(app.LogWarning, "Alert %q webhook failed, %s", rule.Query, err)
+		}
+	}
+	return true
+}
+
+// notify posts the notification to the webhook.
+func (rule Rule) notify(ctx context.Context, matches int) error {
+	host, _ := os.Hostname()
+	n := notification{
+		Alert:     rule.Query,
+		Matches:   matches,
+		Threshold: rule.Threshold,
+		Interval:  rule.Every.String(),
+		Host:      host,
+		Time:      time.Now().UTC().Format(time.RFC3339),
+	}
+	var body any = n
+	if rule.Format == FormatSlack {
+		body = map[string]string{"text": fmt.Sprintf("%s on %s: query %q matched %d lines in the last %s (threshold %d)",
+			app.Application, n.Host, n.Alert, n.Matches, n.Interval, n.Threshold)}
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, rule.Webhook, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return errors.New(fmt.Sprintf("%s gave status %s", rule.Webhook, response.Status))
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, content string) string {
+	name := filepath.Join(t.TempDir(), "alerts")
+	if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestLoad(t *testing.T) {
+	rules, err := Load(writeFile(t, `# query: settings
+nginx-5xx: threshold=10 every=1m webhook=https://hooks.slack.com/services/x format=slack
+
+disk-full: threshold=1 webhook=http://alerts.example.com/hook
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Rule{
+		{Query: "nginx-5xx", Threshold: 10, Every: time.Minute, Webhook: "https://hooks.slack.com/services/x", Format: FormatSlack},
+		{Query: "disk-full", Threshold: 1, Every: defaultEvery, Webhook: "http://alerts.example.com/hook", Format: FormatJSON},
+	}
+	if len(rules) != len(expected) || rules[0] != expected[0] || rules[1] != expected[1] {
+		t.Errorf("expected %v, got %v", expected, rules)
+	}
+	for _, line := range []string{
+		"no settings",
+		": threshold=1 webhook=http://x/",
+		"q: webhook=http://x/",
+		"q: threshold=1",
+		"q: threshold=0 webhook=http://x/",
+		"q: threshold=1 webhook=ftp://x/",
+		"q: threshold=1 webhook=http://x/ every=1s",
+		"q: threshold=1 webhook=http://x/ format=xml",
+		"q: threshold=1 webhook=http://x/ color=red",
+	} {
+		if _, err := Load(writeFile(t, line+"\n")); err == nil {
+			t.Errorf("%q: expected an error", line)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	var bodies []string
+	hook := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		b, _ := io.ReadAll(request.Body)
+		bodies = append(bodies, string(b))
+	}))
+	defer hook.Close()
+
+	counts := []int{3, 12, 15, 2, 11}
+	count := func(ctx context.Context, query string, since time.Duration) (int, error) {
+		n := counts[0]
+		counts = counts[1:]
+		return n, nil
+	}
+	rule := Rule{Query: "errors", Threshold: 10, Every: time.Minute, Webhook: hook.URL, Format: FormatJSON}
+	above := false
+	for range counts {
+		above = rule.check(context.Background(), count, above)
+	}
+	if len(bodies) != 2 {
+		t.Fatalf("expected 2 notifications, got %d: %q", len(bodies), bodies)
+	}
+	var n notification
+	if err := json.Unmarshal([]byte(bodies[1]), &n); err != nil {
+		t.Fatal(err)
+	}
+	if n.Alert != "errors" || n.Matches != 11 || n.Threshold != 10 || n.Interval != "1m0s" {
+		t.Errorf("unexpected notification %q", bodies[1])
+	}
+
+	rule.Format = FormatSlack
+	rule.check(context.Background(), func(context.Context, string, time.Duration) (int, error) { return 10, nil }, false)
+	if len(bodies) != 3 || !strings.HasPrefix(bodies[2], `{"text":"varlog on `) || !strings.Contains(bodies[2], "matched 10 lines") {
+		t.Errorf("expected a Slack message, got %q", bodies[len(bodies)-1])
+	}
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Content negotiation.  The 'format' parameter chooses a response's
+// format; without it, the Accept header does, among the formats the
+// endpoint offers.  A request accepting none of them, or without an
+// Accept header, gets the endpoint's default, its first offer, rather
+// than a 406: curl and browsers send Accept headers of their own, and
+// should still get a response.
+
+// formatMediaTypes gives the media type of each format the Accept
+// header can choose.
+var formatMediaTypes = map[string]string{
+	FormatJSON:   "application/json",
+	FormatNDJSON: "application/x-ndjson",
+	FormatText:   "text/plain",
+}
+
+// MediaType gives the Content-Type of a response in the format.
+func MediaType(format string) string {
+	if format == FormatText || format == FormatHexdump {
+		return "text/plain; charset=utf-8"
+	}
+	return formatMediaTypes[format]
+}
+
+// ResponseFormat gives the format of the response: the 'format'
+// parameter, if given, or else the offer the Accept header prefers.
+// A response chosen by the Accept header varies with it, which the
+// Vary header tells caches.
+func (p *Properties) ResponseFormat(writer http.ResponseWriter, request *http.Request, offers ...string) string {
+	if p.paramFormat != "" {
+		return p.paramFormat
+	}
+	writer.Header().Add("Vary", "Accept")
+	return negotiate(request.Header.Values("Accept"), offers)
+}
+
+// negotiate gives the offer the Accept header values rate highest,
+// the earlier offer on ties, or the first offer if none is acceptable.
+// A media range's most specific match gives an offer its quality, so
+// "text/*;q=0.5, text/plain" rates text/plain 1.
+func negotiate(accept []string, offers []string) string {
+	best, bestQ := offers[0], 0.0
+	for _, offer := range offers {
+		mediaType := formatMediaTypes[offer]
+		q, specificity := 0.0, -1
+		for _, value := range accept {
+			for _, part := range strings.Split(value, ",") {
+				r, rq, ok := parseMediaRange(part)
+				if !ok {
+					continue
+				}
+				s := matchMediaRange(r, mediaType)
+				if s > specificity {
+					q, specificity = rq, s
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// parseMediaRange gives a media range of an Accept header, lowered,
+// and its quality, 1 by default.
+func parseMediaRange(part string) (string, float64, bool) {
+	fields := strings.Split(part, ";")
+	r := strings.ToLower(strings.TrimSpace(fields[0]))
+	if r == "" {
+		return "", 0, false
+	}
+	q := 1.0
+	for _, param := range fields[1:] {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if strings.EqualFold(strings.TrimSpace(name), "q") {
+			f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || f < 0 || f > 1 {
+				return "", 0, false
+			}
+			q = f
+		}
+	}
+	return r, q, true
+}
+
+// matchMediaRange tells how closely the range matches the media type:
+// 2 exactly, 1 for type/*, 0 for */*, or -1 not at all.
+func matchMediaRange(r string, mediaType string) int {
+	switch {
+	case r == mediaType:
+		return 2
+	case r == "*/*":
+		return 0
+	case strings.HasSuffix(r, "/*") && strings.HasPrefix(mediaType, r[:len(r)-1]):
+		return 1
+	}
+	return -1
+}
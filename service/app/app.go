@@ -8,16 +8,29 @@
 // Each HTTP request gets its own copy of the properties before
 // merging URL-specific values.  Thus the Properties object combines
 // application-wide "constants" and request-specific parameters.
+//
+// A Properties value shared between requests is a snapshot: once
+// requests can see it, nothing changes it.  Runtime changes copy the
+// current snapshot, change the copy, and publish it for the requests
+// that start afterwards (see server.Server.UpdateProperties).  The
+// process-wide properties are guarded by a lock instead.
 package app
 
 import (
 	"errors"
 	"fmt"
-	"log"
+	"io/fs"
+	"net"
 	"net/http"
 	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"varlog/service/rotate"
+	"varlog/service/scan"
+	"varlog/service/sshfs"
 )
 
 const (
@@ -32,27 +45,154 @@ const (
 	// a production system.
 	defaultChunkSize = 64 * 1024
 
+	// Chunks /read reads and parses ahead of writing the response.
+	// Two keep the disk busy while the previous chunk is written,
+	// without holding much memory per request.
+	defaultReadAhead = 2
+
+	// Host on which service listens for HTTP connections, used
+	// when no explicit listen address is given.
+	defaultHost = "localhost"
+
 	// Port on which service listens for HTTP connections.
 	defaultPort = 8000
 
-	// Root of the file tree to be served by the application.
-	defaultPathRoot = "/var/log" // Standard root of file tree
+	// Bytes kept of one line; longer lines are handled as the
+	// 'truncate' parameter says.  A bound keeps a file that is not
+	// text, or has no newlines, from filling memory.
+	defaultMaxLineBytes = 1024 * 1024
+
+	// Width of the time buckets /aggregate counts in, without a
+	// 'bucket' parameter, and the narrowest allowed.
+	defaultBucket = time.Minute
+	minBucket     = time.Second
+
+	// Limits on one /read of a named pipe, which has no end.
+	defaultFIFOMaxBytes = 1024 * 1024
+	defaultFIFOTimeout  = 10 * time.Second
+
+	// Most lines either side of an 'around-line'.
+	maxContext = 10000
+
+	// Claims of OIDC tokens naming the client and its groups.
+	defaultOIDCGroupsClaim    = "groups"
+	defaultOIDCPrincipalClaim = "sub"
+
+	// Lifetime of cached directory listings.
+	defaultListCacheTTL = 2 * time.Second
+
+	// Interval between peer discoveries and health checks.
+	defaultPeerRefresh = 30 * time.Second
+
+	// Rotated audit and service log files kept.
+	defaultRotateKeep = 5
+
+	// Time the old process of an upgrade serves requests in progress.
+	defaultUpgradeGrace = 15 * time.Minute
+
+	// Fraction of requests without a sampled parent that are traced.
+	defaultTraceRatio = 1.0
+
+	// Values for the 'filter-anchor' parameter.  The empty
+	// string (the default) matches the filter anywhere in the line.
+	AnchorEnd   = scan.AnchorEnd   // Filter text must end the line
+	AnchorStart = scan.AnchorStart // Filter text must start the line
+	AnchorWhole = scan.AnchorWhole // Filter text must be the whole line
+
+	// Other names for the anchors, as grep users know them.
+	AnchorExact  = "exact"  // AnchorWhole
+	AnchorPrefix = "prefix" // AnchorStart
+	AnchorSuffix = "suffix" // AnchorEnd
+
+	// Values for the 'ts' parameter.  The empty string (the
+	// default) leaves timestamps as they are.
+	TimestampLocal = scan.TimestampLocal // RFC 3339 in the server's zone
+	TimestampUnix  = scan.TimestampUnix  // Seconds since 1970
+	TimestampUTC   = scan.TimestampUTC   // RFC 3339 in UTC
+
+	// Values for the 'count-unit' parameter
+	CountUnitLine   = "line"   // The count caps physical lines
+	CountUnitRecord = "record" // The count caps multi-line records
+
+	// Values for the /read 'encoding' parameter.  The empty string
+	// (the default) detects the encoding from the file.
+	EncodingLatin1      = scan.EncodingLatin1      // ISO 8859-1
+	EncodingUTF16BE     = scan.EncodingUTF16BE     // UTF-16, big-endian
+	EncodingUTF16LE     = scan.EncodingUTF16LE     // UTF-16, little-endian
+	EncodingUTF8        = scan.EncodingUTF8        // UTF-8, as lines are written
+	EncodingWindows1252 = scan.EncodingWindows1252 // Windows' Western European
+
+	// Values for the 'format' parameter.  The empty string (the
+	// default) lets the Accept header choose; see ResponseFormat.
+	FormatHexdump = "hexdump" // Offsets, hex, and ASCII, as xxd writes; /read only
+	FormatJSON    = "json"    // A JSON document
+	FormatNDJSON  = "ndjson"  // A JSON object a line
+	FormatText    = "text"    // Plain text, a line an item
+
+	// Values for the 'truncate' parameter: what happens to a line
+	// longer than -max-line-bytes.
+	TruncateDrop   = "drop"   // Omit the line
+	TruncateError  = "error"  // End the response with an error
+	TruncateMarker = "marker" // Cut the line, ending it with a marker (the default)
+
+	// Values for the /read 'mode' parameter
+	ModeChecksum = "checksum" // Hash the whole file, without content
+	ModeCount    = "count"    // Count the matching lines, without content
+	ModeLines    = "lines"    // Write the matching lines (the default)
 
 	// Strings for HTTP response headers
 	HdrAttachment         = "attachment"
 	HdrContentDisposition = "Content-Disposition"
 	HdrFilename           = "filename"
+	HdrFirstLine          = "X-Varlog-First-Line"
 	HdrInline             = "inline"
+	HdrLongLines          = "X-Varlog-Long-Lines"
+	HdrPeerError          = "X-Varlog-Peer-Error"
+	HdrRetryAfter         = "Retry-After"
+	HdrTrailer            = "Trailer"
+	HdrTruncated          = "X-Varlog-Truncated"
 
 	LogDebug   = "DEBUG"   // log level: DEBUG
 	LogError   = "ERROR"   // log level: ERROR
 	LogInfo    = "INFO"    // log level: INFO
 	LogWarning = "WARNING" // log level: WARNING
 
+	ParamAroundLine         = "around-line"         // Name of the /read 'around-line' parameter
+	ParamBoot               = "boot"                // Name of the /journal 'boot' parameter
+	ParamBucket             = "bucket"              // Name of the /aggregate 'bucket' parameter
 	ParamContentDisposition = "content-disposition" // name of 'content-disposition' parameter
+	ParamContext            = "context"             // Name of the /read 'context' parameter
 	ParamCount              = "count"               // Name of the 'count' parameter
+	ParamCountUnit          = "count-unit"          // Name of the 'count-unit' parameter
+	ParamDedupe             = "dedupe"              // Name of the /read 'dedupe' parameter
+	ParamDest               = "dest"                // Name of the /export 'dest' parameter
+	ParamDryRun             = "dry-run"             // Name of the /file and /truncate 'dry-run' parameter
+	ParamDedupeIgnoreTime   = "dedupe-ignore-time"  // Name of the /read 'dedupe-ignore-time' parameter
+	ParamEncoding           = "encoding"            // Name of the /read 'encoding' parameter
+	ParamExtract            = "extract"             // Name of the /read 'extract' parameter
+	ParamFilename           = "filename"            // Name of the /read 'filename' parameter
 	ParamFilter             = "filter"              // Name of the 'filter' parameter
+	ParamFilterAnchor       = "filter-anchor"       // Name of the 'filter-anchor' parameter
+	ParamFollow             = "follow"              // Name of the /read 'follow' parameter
+	ParamFormat             = "format"              // Name of the 'format' parameter
+	ParamLength             = "length"              // Name of the /read 'length' parameter
+	ParamMode               = "mode"                // Name of the /read 'mode' parameter
+	ParamMultiline          = "multiline"           // Name of the 'multiline' parameter
 	ParamName               = "name"                // Name of the 'name' parameter
+	ParamOffset             = "offset"              // Name of the /read 'offset' parameter
+	ParamPage               = "page"                // Name of the 'page' parameter
+	ParamPageSize           = "page-size"           // Name of the 'page-size' parameter
+	ParamPretty             = "pretty"              // Name of the /list 'pretty' parameter
+	ParamPriority           = "priority"            // Name of the /journal 'priority' parameter
+	ParamRange              = "range"               // Name of the /diff 'range' parameter
+	ParamSanitize           = "sanitize"            // Name of the 'sanitize' parameter
+	ParamSince              = "since"               // Name of the /read 'since' parameter
+	ParamTimestamp          = "ts"                  // Name of the 'ts' parameter
+	ParamTruncate           = "truncate"            // Name of the 'truncate' parameter
+	ParamUnit               = "unit"                // Name of the /journal 'unit' parameter
+	ParamUntil              = "until"               // Name of the /read 'until' parameter
+	ParamWith               = "with"                // Name of the /diff 'with' parameter
+	ParamWithRange          = "with-range"          // Name of the /diff 'with-range' parameter
 
 	// Values for the 'list' metadata
 	TypeDir  = "dir"
@@ -62,31 +202,334 @@ const (
 // Application properties as aggregated from internal constants,
 // command line arguments, and request-specific parameters.
 type Properties struct {
-	chunkSize               int    // Chunk size to read from log file
-	filterOmit              bool   // True if filter text originally had '-'
-	filterText              string // Filter parameter from request, '-' stripped
-	paramContentDisposition string // Desired "Content-Disposition" value
-	paramCount              int    // Maximum lines to return to client
-	paramName               string // Name parameter from request
-	port                    int    // Listen port for server
-	root                    string // Log directory root.  No trailing slash.
-	rootedPath              string // full path, e.g., /var/log/dir
+	addr                    string         // Listen address for server, host:port
+	alertFile               string         // File of alert rules, empty for none
+	allowNets               []string       // Networks allowed to connect, empty for all
+	auditLog                string         // File of JSON audit entries, empty if none
+	authCommand             string         // External credential validator
+	authHtpasswd            string         // htpasswd file for basic authentication
+	authSocket              string         // Unix socket credential validator
+	authTokenFile           string         // File of name:token bearer tokens
+	authTokens              []string       // Bearer tokens from flags, name:token
+	baseURLPath             string         // URL prefix for all routes, empty for none
+	captureDir              string         // Directory for failure bundles, empty if none
+	chunkSize               int            // Chunk size to read from log file
+	coalesceReads           bool           // Share one scan among identical /read requests
+	denyNets                []string       // Networks refused
+	exportDests             []string       // /export destinations, name=URL
+	extract                 scan.Extractor // Fields /read selects from each line
+	fifoMaxBytes            int64          // Most bytes one /read takes from a named pipe
+	fifoTimeout             time.Duration  // Longest one /read reads a named pipe
+	fileSystem              fs.FS          // Storage the endpoints read, see fs.go
+	filterAnchor            string         // Where filters match, 'filter-anchor'
+	filters                 scan.Filters   // Filter parameters from request, one per value
+	format                  string         // Line format of the selected file, see Mount
+	groups                  []string       // Authenticated client's groups, from OIDC
+	indexDir                string         // Directory for line-offset indexes, empty if none
+	journal                 bool           // Serve the systemd journal at /journal
+	listCacheTTL            time.Duration  // Lifetime of cached /list directories, 0 for none
+	logFile                 string         // File the service log is appended to, empty for stderr
+	maxLineBytes            int            // Bytes kept of one line, 0 for no limit
+	maxResponseBytes        int64          // Cap on /read response bytes, 0 if none
+	maxResponseLines        int            // Cap on /read response lines, 0 if none
+	mmapThreshold           int64          // Smallest file /read maps, 0 for none
+	mount                   string         // Selected mount name, empty if none
+	mounts                  []Mount        // Named roots, nil for a single root
+	overlays                []Mount        // Mounts at the top of a single root
+	oidcAudience            string         // Required OIDC token audience
+	oidcGroupsClaim         string         // OIDC claim listing the client's groups
+	oidcIssuer              string         // OIDC issuer URL, empty for none
+	oidcJWKSURL             string         // OIDC key set URL, empty to discover
+	oidcPrincipalClaim      string         // OIDC claim naming the client
+	otlpEndpoint            string         // OTLP/HTTP collector for traces, empty for none
+	otlpHeaders             []string       // Headers for the collector, name=value
+	paramAroundLine         int64          // Line /read centers on, from 1; 0 for none
+	paramBoot               string         // Journal boot: offset or boot ID, empty for all
+	paramBucket             time.Duration  // Width of /aggregate buckets, 0 for the default
+	paramContentDisposition string         // Desired "Content-Disposition" value
+	paramContext            int            // Lines either side of the around-line
+	paramCount              int            // Maximum lines to return to client
+	paramCountUnit          string         // What the count caps: line or record
+	paramDest               string         // /export destination name
+	paramDryRun             bool           // Report a deletion or truncation without making it
+	paramDedupe             bool           // Collapse runs of identical lines
+	paramDedupeIgnoreTime   bool           // Dedupe ignoring leading timestamps
+	paramEncoding           string         // File encoding, empty to detect
+	paramFilename           string         // Name for saving the response, empty for the file's
+	paramFollow             bool           // Stream lines as they are appended
+	paramFormat             string         // Format of the response, empty to negotiate
+	paramLength             int64          // Bytes of a hexdump, 0 for the default
+	paramMode               string         // What /read writes: lines or count
+	paramMultiline          bool           // Group continuation lines into records
+	paramName               string         // Name parameter from request
+	paramOffset             int64          // Start of a hexdump, negative from the end
+	paramPage               Page           // Page from the 'page' token, see page.go
+	paramPageSize           int            // Items a page, 0 for the token's or no pages
+	paramPretty             bool           // Indent /list's JSON
+	paramPriority           string         // Journal priority: name or number, empty for all
+	paramRange              ByteRange      // Bytes of the file /diff compares, zero for all
+	paramRaw                bool           // Skip sanitizing lines, 'sanitize=false'
+	paramSince              time.Time      // Earliest line time, zero for none
+	paramTimestamp          string         // Form for leading timestamps, empty for none
+	paramTruncate           string         // Handling of long lines, empty for the default
+	paramUnit               string         // Journal systemd unit, empty for all
+	paramUntil              time.Time      // Latest line time, zero for none
+	paramWith               string         // File /diff compares the named one with, empty for the same
+	paramWithRange          ByteRange      // Bytes of the 'with' file /diff compares, zero for all
+	peerFile                string         // File of name=URL peers, reread on reload, empty for none
+	peerRefresh             time.Duration  // Interval between peer discoveries and health checks
+	peerSRV                 string         // DNS SRV name of peers, empty for none
+	peers                   []string       // Servers /fleet fans out to, name=URL
+	port                    int            // Listen port for server
+	principal               string         // Authenticated client, empty if none
+	queries                 []Query        // Saved queries, by name
+	quotaFile               string         // File of per-client quotas, empty for none
+	readAhead               int            // Chunks /read reads ahead, 0 for none
+	readFIFOs               bool           // Allow /read of named pipes with follow
+	retention               retentionRules // How long files are kept, by pattern, see retention.go
+	retentionSweep          time.Duration  // Interval between removals of expired files, 0 for none
+	redactRules             []redactRule   // Line processors by path prefix, see redact.go
+	root                    string         // Log directory root.  No trailing slash.
+	rootedPath              string         // full path, e.g., /var/log/dir
+	rotatePolicy            rotate.Policy  // When the audit and service logs are rotated
+	s3                      bool           // Root is /bucket/prefix in S3 storage
+	s3Endpoint              string         // S3-compatible service URL, empty for AWS
+	s3Region                string         // Region for signing S3 requests
+	sshHosts                []sshfs.Host   // Remote hosts served over ssh, also mounts
+	tlsCert                 string         // TLS certificate file, empty for HTTP
+	traceRatio              float64        // Fraction of new traces sampled
+	tlsClientCA             string         // CAs for client certificates (mTLS)
+	tlsKey                  string         // TLS private key file
+	tlsReload               bool           // Reload TLS files when they change
+	ui                      bool           // Serve the web interface at /
+	upgradeGrace            time.Duration  // Time for requests to finish after an upgrade
 }
 
-var properties = Properties{
-	chunkSize: defaultChunkSize,
-	port:      defaultPort,
-	root:      defaultPathRoot,
+// The process-wide properties from the command line.
+// Deprecated for new code: give a server explicit Properties
+// (see DefaultProperties and server.New) rather than changing these.
+var properties = *DefaultProperties()
+
+// Guards properties.  Copies are taken under the read lock.
+var propertiesMutex sync.RWMutex
+
+// DefaultProperties allocates Properties with the built-in defaults,
+// independent of the command line.  Programs embedding the service
+// start here and configure the result with the Set methods.
+func DefaultProperties() *Properties {
+	return &Properties{
+		addr:               net.JoinHostPort(defaultHost, strconv.Itoa(defaultPort)),
+		chunkSize:          defaultChunkSize,
+		fifoMaxBytes:       defaultFIFOMaxBytes,
+		fifoTimeout:        defaultFIFOTimeout,
+		fileSystem:         OSFileSystem,
+		listCacheTTL:       defaultListCacheTTL,
+		peerRefresh:        defaultPeerRefresh,
+		maxLineBytes:       defaultMaxLineBytes,
+		oidcGroupsClaim:    defaultOIDCGroupsClaim,
+		oidcPrincipalClaim: defaultOIDCPrincipalClaim,
+		port:               defaultPort,
+		readAhead:          defaultReadAhead,
+		root:               SlashPath(defaultPathRoot),
+		rotatePolicy:       rotate.Policy{Keep: defaultRotateKeep},
+		traceRatio:         defaultTraceRatio,
+		upgradeGrace:       defaultUpgradeGrace,
+	}
 }
 
 // NewProperties allocates a new Properties object and
-// initializes it to the global application properties.
+// initializes it to a snapshot of the global application properties.
 func NewProperties() (p *Properties) {
-	p = new(Properties)
-	*p = properties
+	propertiesMutex.RLock()
+	defer propertiesMutex.RUnlock()
+	return properties.Copy()
+}
+
+// Copy allocates a copy of the properties, as for one request.
+// Copying only reads p, so any number of goroutines may copy
+// a snapshot at once; changes to the copy do not affect p.
+func (p *Properties) Copy() *Properties {
+	c := new(Properties)
+	*c = *p
+	// Appending to the copy's lists must not write into p's.
+	c.mounts = c.mounts[:len(c.mounts):len(c.mounts)]
+	c.overlays = c.overlays[:len(c.overlays):len(c.overlays)]
+	c.queries = c.queries[:len(c.queries):len(c.queries)]
+	// The filters change in place, see SetFilterAnchor.
+	c.filters = append(scan.Filters(nil), p.filters...)
 	// Force computation of the rooted path with active root
-	p.SetParamName(p.paramName)
-	return p
+	c.SetParamName(c.paramName)
+	return c
+}
+
+// Addr gives the listen address for the HTTP listener, host:port.
+// The host can be a name, an IPv4 address, or a bracketed IPv6 address.
+// An empty host listens on all interfaces.
+func (p *Properties) Addr() string {
+	return p.addr
+}
+
+// AlertFile gives the file of alert rules, which run saved queries
+// on a schedule, or empty for none.  See package alert.
+func (p *Properties) AlertFile() string {
+	return p.alertFile
+}
+
+// SetAlertFile sets the file of alert rules.
+func (p *Properties) SetAlertFile(name string) {
+	p.alertFile = name
+}
+
+// AllowNets gives the networks allowed to connect, in CIDR notation,
+// comma separated.  Empty allows all but DenyNets.
+func (p *Properties) AllowNets() []string {
+	return p.allowNets
+}
+
+// ExportDests gives the destinations /export uploads to, as name=URL.
+// See package export.
+func (p *Properties) ExportDests() []string {
+	return p.exportDests
+}
+
+// SetExportDests sets the destinations /export uploads to.
+func (p *Properties) SetExportDests(dests []string) {
+	p.exportDests = dests
+}
+
+// Peers gives the servers /fleet requests fan out to, as name=URL.
+// See package federate.
+func (p *Properties) Peers() []string {
+	return p.peers
+}
+
+// SetPeers sets the servers /fleet requests fan out to.
+func (p *Properties) SetPeers(peers []string) {
+	p.peers = peers
+}
+
+// PeerFile gives the file of name=URL peers, one per line, or empty
+// for none.  It is reread on reload and at each PeerRefresh.
+func (p *Properties) PeerFile() string {
+	return p.peerFile
+}
+
+// SetPeerFile sets the file of peers.
+func (p *Properties) SetPeerFile(name string) {
+	p.peerFile = name
+}
+
+// PeerSRV gives the DNS SRV name whose records list peers, as
+// _varlog._tcp.example.com, optionally after a scheme, as https://.
+func (p *Properties) PeerSRV() string {
+	return p.peerSRV
+}
+
+// SetPeerSRV sets the DNS SRV name of peers.
+func (p *Properties) SetPeerSRV(name string) {
+	p.peerSRV = name
+}
+
+// PeerRefresh gives the interval between peer discoveries and
+// health checks.
+func (p *Properties) PeerRefresh() time.Duration {
+	return p.peerRefresh
+}
+
+// SetPeerRefresh sets the interval between peer discoveries and
+// health checks.
+func (p *Properties) SetPeerRefresh(d time.Duration) {
+	p.peerRefresh = d
+}
+
+// DenyNets gives the networks refused, in CIDR notation, comma separated.
+func (p *Properties) DenyNets() []string {
+	return p.denyNets
+}
+
+// SetNetACL sets the networks allowed to connect, and those refused.
+// See ParseNets.
+func (p *Properties) SetNetACL(allow []string, deny []string) {
+	p.allowNets = allow
+	p.denyNets = deny
+}
+
+// AuditLog gives the file the audit trail is appended to, as JSON
+// lines, or empty to keep only recent entries in memory.
+func (p *Properties) AuditLog() string {
+	return p.auditLog
+}
+
+// SetAuditLog sets the file the audit trail is appended to.
+func (p *Properties) SetAuditLog(name string) {
+	p.auditLog = name
+}
+
+// LogFile gives the file the service log, including access lines, is
+// appended to, or empty for stderr.
+func (p *Properties) LogFile() string {
+	return p.logFile
+}
+
+// SetLogFile sets the file the service log is appended to.
+func (p *Properties) SetLogFile(name string) {
+	p.logFile = name
+}
+
+// RotatePolicy gives when the audit log and the service log file are
+// rotated, and how many rotated files are kept.
+func (p *Properties) RotatePolicy() rotate.Policy {
+	return p.rotatePolicy
+}
+
+// SetRotatePolicy sets when the audit and service logs are rotated.
+func (p *Properties) SetRotatePolicy(policy rotate.Policy) {
+	p.rotatePolicy = policy
+}
+
+// AuthCommand gives the executable that validates credentials,
+// empty if none.
+func (p *Properties) AuthCommand() string {
+	return p.authCommand
+}
+
+// AuthSocket gives the unix socket that validates credentials,
+// empty if none.
+func (p *Properties) AuthSocket() string {
+	return p.authSocket
+}
+
+// AuthHtpasswd gives the htpasswd-style file of users for
+// basic authentication, empty if none.
+func (p *Properties) AuthHtpasswd() string {
+	return p.authHtpasswd
+}
+
+// AuthTokenFile gives the file of name:token bearer tokens, empty if none.
+func (p *Properties) AuthTokenFile() string {
+	return p.authTokenFile
+}
+
+// AuthTokens gives the name:token bearer tokens from the command line.
+func (p *Properties) AuthTokens() []string {
+	return p.authTokens
+}
+
+// SetAuthTokens sets the name:token bearer tokens.
+func (p *Properties) SetAuthTokens(tokens []string) {
+	p.authTokens = tokens
+}
+
+// BaseURLPath gives the URL prefix under which all routes are served,
+// such as /varlog behind a reverse proxy.  Empty means no prefix.
+func (p *Properties) BaseURLPath() string {
+	return p.baseURLPath
+}
+
+// SetBaseURLPath sets the URL prefix for all routes.
+// It should start with a slash and not end with one.
+func (p *Properties) SetBaseURLPath(prefix string) {
+	p.baseURLPath = prefix
 }
 
 // BasePath returns the last component (base name) of the
@@ -95,119 +538,256 @@ func (p *Properties) BasePath() string {
 	return path.Base(p.rootedPath)
 }
 
+// CaptureDir gives the directory for diagnostic bundles of failing
+// requests.  Empty means capture is disabled.
+func (p *Properties) CaptureDir() string {
+	return p.captureDir
+}
+
+// SetCaptureDir sets the directory for diagnostic bundles.
+func (p *Properties) SetCaptureDir(dir string) {
+	p.captureDir = dir
+}
+
 // ChunkSize provides the chunk size to read from log files.
 func (p *Properties) ChunkSize() int {
 	return p.chunkSize
 }
 
+// CoalesceReads reports whether identical /read requests in progress
+// at once share one scan.  See -coalesce-reads.
+func (p *Properties) CoalesceReads() bool {
+	return p.coalesceReads
+}
+
+// SetCoalesceReads shares scans among identical /read requests, or
+// gives each its own.
+func (p *Properties) SetCoalesceReads(enable bool) {
+	p.coalesceReads = enable
+}
+
 // Retrieve client parameters from the http request.  Extracts
 // the values and updates the properties object that will be used
-// for the remainder of this request's processing.
+// for the remainder of this request's processing.  Every invalid
+// parameter is reported, in one error.
 func (props *Properties) ExtractParams(request *http.Request) (err error) {
+	props.principal = PrincipalFrom(request.Context())
+	props.groups = GroupsFrom(request.Context())
 	if err = request.ParseForm(); err != nil {
-		Log(LogError, "%s", err)
-		return err
+		Log(LogWarning, "%s", err)
+		return NewHTTPError(http.StatusBadRequest, CodeInvalidParam, err.Error())
 	}
 
 	// ParseForm above generates url.Values, which is a map from
 	// a string key to an array of strings.  A given key is allowed
-	// to have multiple values, represented in the map's array entries.
-	// Note the code below to check the length of map values and select
-	// only the first array entry.  As an example:
+	// to have multiple values; only the first is used, except for
+	// repeated parameters such as 'filter'.  As an example:
 	//
 	// 		url...?a=v1&a=v2
 	//
 	// generates map["a"] == [ "v1", "v2" ]
-	for key, value := range request.Form {
-		switch key {
-		case ParamContentDisposition:
-			if len(value) == 0 {
-				break
-			}
-			switch value[0] {
-			case "", HdrInline, HdrAttachment:
-				props.paramContentDisposition = value[0]
-
-			default:
-				err = errors.New(
-					fmt.Sprintf("Invalid value %s=%q", ParamContentDisposition, value[0]))
-				Log(LogWarning, "%s", err.Error())
-				return err
-			}
-
-		case ParamCount:
-			if len(value) == 0 {
-				break
-			}
-			if value[0] == "" {
-				props.paramCount = 0
-				break
-			}
-			if props.paramCount, err = strconv.Atoi(value[0]); err != nil {
-				err = errors.New(
-					fmt.Sprintf("Invalid conversion of param %s=%q, %s",
-						ParamCount, value[0], err.Error()))
-				Log(LogWarning, "%s", err.Error())
-				return err
-			}
-
-		case ParamFilter:
-			if len(value) == 0 {
-				break
-			}
-			props.filterText = value[0]
-			if len(props.filterText) > 0 && props.filterText[0] == '-' {
-				props.filterOmit = true
-				props.filterText = props.filterText[1:]
-			}
-
-		case ParamName:
-			if len(value) == 0 {
-				break
-			}
-			err = props.SetParamName(value[0])
-			if err != nil {
-				err = errors.New(
-					fmt.Sprintf("Invalid conversion of param %s=%q, %s",
-						ParamName, value[0], err.Error()))
-				Log(LogWarning, "%s", err.Error())
-				return err
-			}
-
-		default:
-			// Treat unknown keys as a client error.
-			err = errors.New(fmt.Sprintf("Parameter %q invalid", key))
-			Log(LogWarning, "%s", err)
-			return err
-		}
-	}
-	return nil
+	//
+	// The parameters are declared in params.go.
+	return props.extractParams(request)
 }
 
+// FilterAllowsEntry applies the filters to a line or entry name.
+// See scan.Filters.Allows.
 func (props *Properties) FilterAllowsEntry(name string) bool {
-	// An empty filter allows all entries
-	if props.filterText == "" {
-		return true
+	return props.filters.Allows(name)
+}
+
+// FilterAllowsRecord applies the filters to a multi-line record
+// as a whole.  See scan.Filters.AllowsRecord.
+func (props *Properties) FilterAllowsRecord(lines []string) bool {
+	return props.filters.AllowsRecord(lines)
+}
+
+// Extract applies the 'extract' parameter to a line, giving the
+// selected fields, or false when the line has none of them.
+// Without the parameter, the line passes whole.
+func (props *Properties) Extract(s string) (string, bool) {
+	return props.extract.Extract(s)
+}
+
+// Extracting reports whether the request has an 'extract' parameter.
+func (props *Properties) Extracting() bool {
+	return props.extract.Active()
+}
+
+// Filter gives the request's first filter, the zero Filter if none.
+//
+// Deprecated: use Filters, which gives them all.
+func (p *Properties) Filter() scan.Filter {
+	if len(p.filters) == 0 {
+		return scan.Filter{Anchor: p.filterAnchor}
 	}
-	if strings.Contains(name, props.filterText) {
-		return !props.filterOmit
+	return p.filters[0]
+}
+
+// Filters gives the request's filters, one per 'filter' parameter,
+// each with the 'filter-anchor' parameter's anchor.
+func (p *Properties) Filters() scan.Filters {
+	return p.filters
+}
+
+// AddFilter adds a filter from a 'filter' parameter value, as
+// scan.ParseFilter reads it.  An empty value adds nothing.
+func (p *Properties) AddFilter(value string) {
+	text, omit := scan.ParseFilter(value)
+	if text != "" {
+		p.filters = append(p.filters, scan.Filter{Text: text, Omit: omit, Anchor: p.filterAnchor})
 	}
-	// Filter text is non-empty and did not match.
-	return props.filterOmit
 }
 
+// SetFilterAnchor sets where the filters match, added or to be added.
+func (p *Properties) SetFilterAnchor(s string) {
+	p.filterAnchor = s
+	for j := range p.filters {
+		p.filters[j].Anchor = s
+	}
+}
+
+// SetFilterOmit sets whether the first filter omits lines.
 func (p *Properties) SetFilterOmit(b bool) {
-	p.filterOmit = b
+	p.firstFilter().Omit = b
 }
 
+// SetFilterText sets the first filter's text.
 func (p *Properties) SetFilterText(s string) {
-	p.filterText = s
+	p.firstFilter().Text = s
+}
+
+// firstFilter gives the first filter, adding one if there are none.
+func (p *Properties) firstFilter() *scan.Filter {
+	if len(p.filters) == 0 {
+		p.filters = scan.Filters{{Anchor: p.filterAnchor}}
+	}
+	return &p.filters[0]
 }
 
-// FilterText provides the value for the 'filter' parameter.
-// The value is the empty string if the parameter was not present.
-// For matching purposes, a nil/empty value means no filtering
-// happens, and all entries qualify for inclusion in results.
+// FIFOMaxBytes gives the most bytes one /read takes from a named pipe.
+func (p *Properties) FIFOMaxBytes() int64 {
+	return p.fifoMaxBytes
+}
+
+// FIFOTimeout gives the longest one /read reads a named pipe.
+func (p *Properties) FIFOTimeout() time.Duration {
+	return p.fifoTimeout
+}
+
+// SetFIFOLimits sets the limits on one /read of a named pipe.
+func (p *Properties) SetFIFOLimits(maxBytes int64, timeout time.Duration) {
+	p.fifoMaxBytes = maxBytes
+	p.fifoTimeout = timeout
+}
+
+// OIDCAudience gives the audience OIDC tokens must name.
+func (p *Properties) OIDCAudience() string {
+	return p.oidcAudience
+}
+
+// OIDCGroupsClaim gives the OIDC claim listing the client's groups.
+func (p *Properties) OIDCGroupsClaim() string {
+	return p.oidcGroupsClaim
+}
+
+// OIDCIssuer gives the OIDC issuer whose tokens are accepted as
+// bearer tokens.  Empty means none.
+func (p *Properties) OIDCIssuer() string {
+	return p.oidcIssuer
+}
+
+// OIDCJWKSURL gives the location of the issuer's signing keys.
+// Empty means the issuer's discovery document gives it.
+func (p *Properties) OIDCJWKSURL() string {
+	return p.oidcJWKSURL
+}
+
+// OIDCPrincipalClaim gives the OIDC claim naming the client.
+func (p *Properties) OIDCPrincipalClaim() string {
+	return p.oidcPrincipalClaim
+}
+
+// OTLPEndpoint gives the base URL of the OpenTelemetry collector
+// that receives traces over OTLP/HTTP.  Empty means tracing is off.
+func (p *Properties) OTLPEndpoint() string {
+	return p.otlpEndpoint
+}
+
+// OTLPHeaders gives the headers sent to the collector, as name=value.
+func (p *Properties) OTLPHeaders() []string {
+	return p.otlpHeaders
+}
+
+// SetOTLP sets the collector that receives traces and its headers.
+func (p *Properties) SetOTLP(endpoint string, headers []string) {
+	p.otlpEndpoint = endpoint
+	p.otlpHeaders = headers
+}
+
+// SetOIDC sets the OIDC issuer and audience of accepted tokens, with
+// the default claims.
+func (p *Properties) SetOIDC(issuer string, audience string) {
+	p.oidcIssuer = issuer
+	p.oidcAudience = audience
+	p.oidcGroupsClaim = defaultOIDCGroupsClaim
+	p.oidcPrincipalClaim = defaultOIDCPrincipalClaim
+}
+
+// IndexDir gives the directory for line-offset indexes of log files.
+// Empty means indexing is disabled.
+func (p *Properties) IndexDir() string {
+	return p.indexDir
+}
+
+// SetIndexDir sets the directory for line-offset indexes.
+func (p *Properties) SetIndexDir(dir string) {
+	p.indexDir = dir
+}
+
+// ListCacheTTL gives how long /list may reuse a directory's entries
+// while its modification time is unchanged.  Zero disables the cache.
+func (p *Properties) ListCacheTTL() time.Duration {
+	return p.listCacheTTL
+}
+
+// MaxLineBytes gives the bytes kept of one line.  Longer lines are
+// handled as ParamTruncate says.  Zero means no limit.
+func (p *Properties) MaxLineBytes() int {
+	return p.maxLineBytes
+}
+
+// SetMaxLineBytes sets the bytes kept of one line, zero for no limit.
+func (p *Properties) SetMaxLineBytes(n int) {
+	p.maxLineBytes = n
+}
+
+// MaxResponseBytes gives the server's cap on the bytes in a /read
+// or /journal response, regardless of the client's count.
+// Zero means no cap.
+func (p *Properties) MaxResponseBytes() int64 {
+	return p.maxResponseBytes
+}
+
+// SetMaxResponseBytes sets the cap on the bytes in a response,
+// zero for no cap.
+func (p *Properties) SetMaxResponseBytes(n int64) {
+	p.maxResponseBytes = n
+}
+
+// MaxResponseLines gives the server's cap on the lines in a /read
+// or /journal response, regardless of the client's count.
+// Zero means no cap.
+func (p *Properties) MaxResponseLines() int {
+	return p.maxResponseLines
+}
+
+// SetMaxResponseLines sets the cap on the lines in a response,
+// zero for no cap.
+func (p *Properties) SetMaxResponseLines(n int) {
+	p.maxResponseLines = n
+}
 
 // ParamContentDisposition gives the value for any "Content-Disposition"
 // header.  The default, empty string, leaves the value up to the server.
@@ -216,6 +796,18 @@ func (p *Properties) ParamContentDisposition() string {
 	return p.paramContentDisposition
 }
 
+// ParamAroundLine gives the number of the line, counted from 1, that
+// /read writes with its context, or 0 to read the file from its end.
+func (p *Properties) ParamAroundLine() int64 {
+	return p.paramAroundLine
+}
+
+// ParamContext gives the lines written either side of the
+// around-line, 0 for the line alone.
+func (p *Properties) ParamContext() int {
+	return p.paramContext
+}
+
 // ParamCount provides the 'count' parameter's value.  If the
 // request did not have the parameter, the value is zero.
 // For the /read request, the count caps the line count
@@ -224,6 +816,140 @@ func (p *Properties) ParamCount() int {
 	return p.paramCount
 }
 
+// ParamCountUnit tells what the 'count' parameter caps when
+// multiline grouping is enabled: CountUnitRecord (the default)
+// or CountUnitLine.  Without grouping, every record is one line.
+func (p *Properties) ParamCountUnit() string {
+	if p.paramCountUnit == "" {
+		return CountUnitRecord
+	}
+	return p.paramCountUnit
+}
+
+// ParamEncoding gives the encoding of the file, converted to UTF-8,
+// as named by the 'encoding' parameter or detected from the file.
+// The empty string means UTF-8, or not yet detected.
+func (p *Properties) ParamEncoding() string {
+	return p.paramEncoding
+}
+
+// SetParamEncoding records the encoding detected for the file,
+// when the request names none.
+func (p *Properties) SetParamEncoding(encoding string) {
+	p.paramEncoding = encoding
+}
+
+// ParamTruncate tells what happens to a line longer than
+// MaxLineBytes: TruncateMarker (the default), TruncateDrop,
+// or TruncateError.
+func (p *Properties) ParamTruncate() string {
+	if p.paramTruncate == "" {
+		return TruncateMarker
+	}
+	return p.paramTruncate
+}
+
+// ParamDedupe reports whether /read collapses runs of identical
+// lines (records, with multiline) into one, prefixed with the count.
+// Ignoring timestamps implies it.
+func (p *Properties) ParamDedupe() bool {
+	return p.paramDedupe || p.paramDedupeIgnoreTime
+}
+
+// ParamDryRun reports whether DELETE /file or POST /truncate only
+// reports what it would do.
+func (p *Properties) ParamDryRun() bool {
+	return p.paramDryRun
+}
+
+// ParamDedupeIgnoreTime reports whether deduplication ignores a
+// leading timestamp when comparing lines.
+func (p *Properties) ParamDedupeIgnoreTime() bool {
+	return p.paramDedupeIgnoreTime
+}
+
+// ParamFilename gives the name for clients to save the response as:
+// the 'filename' parameter, or by default the file's base name.
+func (p *Properties) ParamFilename() string {
+	if p.paramFilename == "" {
+		return p.BasePath()
+	}
+	return p.paramFilename
+}
+
+// ParamFilenameSet reports whether the client chose the name to save
+// the response as, which asks for an attachment.
+func (p *Properties) ParamFilenameSet() bool {
+	return p.paramFilename != ""
+}
+
+// ParamFollow reports whether /read follows the file, writing lines
+// in file order as they are appended, as tail -F does.
+func (p *Properties) ParamFollow() bool {
+	return p.paramFollow
+}
+
+// ParamFormat gives the format of the response, or empty to
+// negotiate it; see ResponseFormat.
+func (p *Properties) ParamFormat() string {
+	return p.paramFormat
+}
+
+// SetParamFormat records the format negotiated for the response,
+// when the request names none.
+func (p *Properties) SetParamFormat(format string) {
+	p.paramFormat = format
+}
+
+// ParamLength gives the bytes of a hexdump, or 0 for the default.
+func (p *Properties) ParamLength() int64 {
+	return p.paramLength
+}
+
+// ParamOffset gives the start of a hexdump: bytes from the start of
+// the file, or if negative, from the end.
+func (p *Properties) ParamOffset() int64 {
+	return p.paramOffset
+}
+
+// ParamMode tells what /read writes: ModeLines (the default), the
+// matching lines themselves, ModeCount, only their number, or
+// ModeChecksum, a digest of the whole file.
+func (p *Properties) ParamMode() string {
+	if p.paramMode == "" {
+		return ModeLines
+	}
+	return p.paramMode
+}
+
+// ParamMultiline reports whether the request groups continuation
+// lines (those starting with a space or tab) with the line before,
+// forming multi-line records such as stack traces.
+func (p *Properties) ParamMultiline() bool {
+	return p.paramMultiline
+}
+
+// ParamBoot provides the /journal 'boot' parameter's value:
+// a boot offset such as 0 or -1, a boot ID, or empty for all boots.
+func (p *Properties) ParamBoot() string {
+	return p.paramBoot
+}
+
+// ParamBucket gives the width of the time buckets /aggregate counts
+// matching lines in, from the 'bucket' parameter, default one minute.
+func (p *Properties) ParamBucket() time.Duration {
+	if p.paramBucket == 0 {
+		return defaultBucket
+	}
+	return p.paramBucket
+}
+
+// ParamDest provides the /export 'dest' parameter's value: the name
+// of the destination to upload to.
+func (p *Properties) ParamDest() string {
+	return p.paramDest
+}
+
 // ParamName provides the 'name' parameter's value.  If the
 // request did not have the parameter, the string is empty.
 func (p *Properties) ParamName() string {
@@ -231,7 +957,20 @@ func (p *Properties) ParamName() string {
 }
 
 func (props *Properties) SetParamName(name string) error {
+	if filepath.Separator == '\\' {
+		// Windows clients may send their own separators.
+		name = strings.ReplaceAll(name, `\`, "/")
+	}
 	props.paramName = name
+	if len(props.mounts) > 0 {
+		if err := props.setMountedName(name); err != nil {
+			return err
+		}
+		return props.CheckContained()
+	}
+	if props.setOverlayName(name) {
+		return props.CheckContained()
+	}
 
 	/* Join the root and the user's path.  The result is cleaned:
 	* suppress multiple slashes, process . and .., etc.
@@ -247,7 +986,139 @@ func (props *Properties) SetParamName(name string) error {
 		return err
 	}
 	props.rootedPath = p
-	return nil
+	return props.CheckContained()
+}
+
+// ParamPriority provides the /journal 'priority' parameter's value,
+// a syslog level name or number, or empty for all priorities.
+func (p *Properties) ParamPriority() string {
+	return p.paramPriority
+}
+
+// ParamSanitize reports whether text responses strip ANSI escapes and
+// replace invalid UTF-8 in lines, as by default.  See scan.Sanitize.
+func (p *Properties) ParamSanitize() bool {
+	return !p.paramRaw
+}
+
+// NormalizeTimestamp rewrites the timestamp starting a line as the
+// 'ts' parameter asks, or gives the line unchanged without one.
+// Timestamps without a zone are in the server's.
+func (p *Properties) NormalizeTimestamp(s string) string {
+	if p.paramTimestamp == "" {
+		return s
+	}
+	n := scan.TimestampNormalizer{Format: p.paramTimestamp, Location: time.Local}
+	return n.Normalize(s)
+}
+
+// ParamSince gives the time of the earliest line /read writes, from
+// the 'since' parameter, or the zero time for no limit.
+func (p *Properties) ParamSince() time.Time {
+	return p.paramSince
+}
+
+// ParamUntil gives the time of the latest line /read writes, from
+// the 'until' parameter, or the zero time for no limit.
+func (p *Properties) ParamUntil() time.Time {
+	return p.paramUntil
+}
+
+// ParamPretty reports whether /list indents its JSON for people to
+// read, rather than writing it compact.
+func (p *Properties) ParamPretty() bool {
+	return p.paramPretty
+}
+
+// ParamRange gives the bytes of the named file /diff compares, from
+// the 'range' parameter; the zero range is the whole file.
+func (p *Properties) ParamRange() ByteRange {
+	return p.paramRange
+}
+
+// ParamWith gives the file /diff compares the named one with, from the
+// 'with' parameter, or empty to compare two ranges of the named file.
+func (p *Properties) ParamWith() string {
+	return p.paramWith
+}
+
+// ParamWithRange gives the bytes of the 'with' file /diff compares,
+// from the 'with-range' parameter; the zero range is the whole file.
+func (p *Properties) ParamWithRange() ByteRange {
+	return p.paramWithRange
+}
+
+// A ByteRange selects bytes of a file, from Start up to End, or to the
+// end of the file when End is zero.
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+// parseByteRange parses START-END, or START- for the rest of the file.
+func parseByteRange(value string) (ByteRange, bool) {
+	start, end, ok := strings.Cut(value, "-")
+	if !ok {
+		return ByteRange{}, false
+	}
+	var r ByteRange
+	var err error
+	if r.Start, err = strconv.ParseInt(start, 10, 64); err != nil || r.Start < 0 {
+		return ByteRange{}, false
+	}
+	if end != "" {
+		if r.End, err = strconv.ParseInt(end, 10, 64); err != nil || r.End <= r.Start {
+			return ByteRange{}, false
+		}
+	}
+	return r, true
+}
+
+// LineTime gives the time of a line's leading timestamp, if it has
+// one.  Timestamps without a zone are in the server's.
+func (p *Properties) LineTime(s string) (time.Time, bool) {
+	n := scan.TimestampNormalizer{Location: time.Local}
+	return n.Time(s)
+}
+
+// ParseTime parses a 'since' or 'until' value, as /read and /audit
+// take them: an RFC 3339 time, or a duration before now, such as "15m".
+func ParseTime(value string, now time.Time) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), true
+	}
+	return time.Time{}, false
+}
+
+// ParamUnit provides the /journal 'unit' parameter's value,
+// or empty for all units.
+func (p *Properties) ParamUnit() string {
+	return p.paramUnit
+}
+
+// Principal gives the authenticated client's name, or the empty
+// string if authentication is not enabled.
+func (p *Properties) Principal() string {
+	return p.principal
+}
+
+// SetPrincipal sets the authenticated client's name.
+func (p *Properties) SetPrincipal(name string) {
+	p.principal = name
+}
+
+// Groups gives the authenticated client's groups, from an OIDC token,
+// or nil.
+func (p *Properties) Groups() []string {
+	return p.groups
+}
+
+// SetGroups sets the authenticated client's groups.
+func (p *Properties) SetGroups(groups []string) {
+	p.groups = groups
 }
 
 // Port gives the port number for the HTTP listener.
@@ -255,12 +1126,57 @@ func (p *Properties) Port() int {
 	return p.port
 }
 
+// QuotaFile gives the file of per-client request and byte quotas,
+// empty for none.
+func (p *Properties) QuotaFile() string {
+	return p.quotaFile
+}
+
+// SetQuotaFile sets the file of per-client quotas.
+func (p *Properties) SetQuotaFile(name string) {
+	p.quotaFile = name
+}
+
+// ReadAhead gives the number of chunks /read reads ahead of writing,
+// 0 to read each chunk as it is needed.
+func (p *Properties) ReadAhead() int {
+	return p.readAhead
+}
+
+// ReadFIFOs reports whether /read may follow named pipes.
+// See -read-fifos.
+func (p *Properties) ReadFIFOs() bool {
+	return p.readFIFOs
+}
+
+// SetReadFIFOs allows or refuses /read of named pipes.
+func (p *Properties) SetReadFIFOs(enable bool) {
+	p.readFIFOs = enable
+}
+
+// SetReadAhead sets the number of chunks /read reads ahead.
+func (p *Properties) SetReadAhead(n int) {
+	p.readAhead = n
+}
+
 // Root gives the base directory for all file system operations,
 // default is /var/log.  This can be changed for testing.
 func (p *Properties) Root() string {
 	return p.root
 }
 
+// RelativePath gives the cleaned name of the request's path, relative
+// to the root.  The root itself is the empty string.
+// For example, name=/abc//def/ gives "abc/def".
+// With mounts, the path starts with the mount name.
+func (p *Properties) RelativePath() string {
+	relative := strings.TrimPrefix(strings.TrimPrefix(p.rootedPath, p.root), "/")
+	if p.mount != "" {
+		return path.Join(p.mount, relative)
+	}
+	return relative
+}
+
 // RootedPath gives the full path for an endpoint.
 // After an endpoint processes the 'name' parameter relative
 // to the Root path, this value provides the result.  For
@@ -276,19 +1192,119 @@ func (p *Properties) SetRootedPath(s string) {
 	p.rootedPath = s
 }
 
-// Produces a log entry containing the application name (implicit),
-// the log level, and arguments supplied by the caller.
-func Log(level string, format string, args ...interface{}) {
-	s := fmt.Sprintf(format, args...)
-	log.Printf("%s %s %s\n",
-		Application, level, s)
+// S3 indicates the root is /bucket/prefix in S3-compatible storage,
+// rather than a local directory.
+func (p *Properties) S3() bool {
+	return p.s3
+}
+
+// S3Endpoint gives the S3-compatible service URL, empty for AWS.
+func (p *Properties) S3Endpoint() string {
+	return p.s3Endpoint
+}
+
+// S3Region gives the region for signing S3 requests.
+func (p *Properties) S3Region() string {
+	return p.s3Region
+}
+
+// TraceRatio gives the fraction of requests traced when the caller's
+// traceparent does not decide: 1 traces all, 0 none.
+func (p *Properties) TraceRatio() float64 {
+	return p.traceRatio
+}
+
+// SetTraceRatio sets the fraction of requests traced.
+func (p *Properties) SetTraceRatio(ratio float64) {
+	p.traceRatio = ratio
+}
+
+// TLSCert gives the PEM certificate file for HTTPS.
+// An empty value means the service uses plain HTTP.
+func (p *Properties) TLSCert() string {
+	return p.tlsCert
+}
+
+// SetTLSCert sets the PEM certificate file for HTTPS.
+func (p *Properties) SetTLSCert(name string) {
+	p.tlsCert = name
+}
+
+// TLSClientCA gives the PEM file of CA certificates used to verify
+// client certificates.  An empty value means clients need no certificate.
+func (p *Properties) TLSClientCA() string {
+	return p.tlsClientCA
+}
+
+// SetTLSClientCA sets the PEM file of CA certificates verifying clients.
+func (p *Properties) SetTLSClientCA(name string) {
+	p.tlsClientCA = name
+}
+
+// TLSKey gives the PEM private key file matching TLSCert.
+func (p *Properties) TLSKey() string {
+	return p.tlsKey
+}
+
+// SetTLSKey sets the PEM private key file matching TLSCert.
+func (p *Properties) SetTLSKey(name string) {
+	p.tlsKey = name
+}
+
+// TLSReload indicates whether the service watches the TLS
+// certificate and key files, reloading them when they change.
+func (p *Properties) TLSReload() bool {
+	return p.tlsReload
+}
+
+// UI indicates whether the service serves its web interface at /.
+func (p *Properties) UI() bool {
+	return p.ui
+}
+
+// UpgradeGrace gives how long the old process of an upgrade serves
+// the requests in progress, such as follow=true reads, before
+// canceling them.
+func (p *Properties) UpgradeGrace() time.Duration {
+	return p.upgradeGrace
+}
+
+// SetUpgradeGrace sets how long the old process of an upgrade serves
+// requests in progress.
+func (p *Properties) SetUpgradeGrace(grace time.Duration) {
+	p.upgradeGrace = grace
+}
+
+// SetUI enables or disables the web interface.
+func (p *Properties) SetUI(enable bool) {
+	p.ui = enable
+}
+
+// DefaultChunkSize gives the built-in chunk size, used when
+// neither the -chunk flag nor calibration supplies a value.
+func DefaultChunkSize() int {
+	return defaultChunkSize
+}
+
+// Sets the active chunk size for reading log files.
+// This applies to all subsequent requests.
+//
+// Deprecated: use Properties.SetChunkSize on a server's properties.
+func SetChunkSize(n int) {
+	propertiesMutex.Lock()
+	defer propertiesMutex.Unlock()
+	properties.chunkSize = n
 }
 
 // Provides the active rooted path for the endpoints.
 // The default is /var/log, but this can be updated for
 // testing and local execution.
 // See also: app.SetRoot.
+//
+// Deprecated: use Properties.Root.
 func Root() string {
+	propertiesMutex.RLock()
+	defer propertiesMutex.RUnlock()
 	return properties.root
 }
 
@@ -297,6 +1313,28 @@ func Root() string {
 // the active root directory.  This property applies to all
 // requests, and thus applies to app, not Properties.
 // See also: app.Root.
+//
+// Deprecated: use Properties.SetRoot on a server's properties.
+// Tests that change the global root race each other.
 func SetRoot(root string) {
-	properties.root = root
+	propertiesMutex.Lock()
+	defer propertiesMutex.Unlock()
+	properties.root = SlashPath(root)
+}
+
+// SetAddr sets the listen address, host:port.
+func (p *Properties) SetAddr(addr string) {
+	p.addr = addr
+}
+
+// SetChunkSize sets the chunk size for reading log files.
+func (p *Properties) SetChunkSize(n int) {
+	p.chunkSize = n
+}
+
+// SetRoot sets the root directory, a host path, as a rooted path
+// (see SlashPath), and recomputes the rooted path.  Client names are interpreted relative to the root.
+func (p *Properties) SetRoot(root string) {
+	p.root = SlashPath(root)
+	p.SetParamName(p.paramName)
 }
@@ -0,0 +1,788 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	"varlog/service/scan"
+)
+
+func TestExtractParams_escapedFilter(t *testing.T) {
+	props := NewProperties()
+	request := httptest.NewRequest("GET", `/read?name=x&filter=\-abc`, nil)
+	if err := props.ExtractParams(request); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if !props.FilterAllowsEntry("x -abc y") {
+		t.Errorf("expected filter to allow line containing '-abc'")
+	}
+	if props.FilterAllowsEntry("x abc y") {
+		t.Errorf("expected filter to drop line without '-abc'")
+	}
+}
+
+func TestFilterAnchor(t *testing.T) {
+	tests := []struct {
+		anchor string
+		line   string
+		allow  bool
+	}{
+		{"", "abc def", true},
+		{"", "xyz", false},
+		{AnchorStart, "abc def", true},
+		{AnchorStart, "def abc", false},
+		{AnchorEnd, "def abc", true},
+		{AnchorEnd, "abc def", false},
+		{AnchorWhole, "abc", true},
+		{AnchorWhole, "abc def", false},
+	}
+	props := NewProperties()
+	props.SetFilterText("abc")
+	for _, test := range tests {
+		props.SetFilterAnchor(test.anchor)
+		props.SetFilterOmit(false)
+		if allow := props.FilterAllowsEntry(test.line); allow != test.allow {
+			t.Errorf("anchor %q, line %q: expected %v, got %v",
+				test.anchor, test.line, test.allow, allow)
+		}
+		props.SetFilterOmit(true)
+		if allow := props.FilterAllowsEntry(test.line); allow == test.allow {
+			t.Errorf("anchor %q, line %q, omit: expected %v, got %v",
+				test.anchor, test.line, !test.allow, allow)
+		}
+	}
+}
+
+func TestExtractParams_filters(t *testing.T) {
+	props := NewProperties()
+	request := httptest.NewRequest("GET", "/read?name=x&filter=ERROR&filter=-healthcheck&filter=&filter-anchor=start", nil)
+	if err := props.ExtractParams(request); err != nil {
+		t.Fatal(err)
+	}
+	expected := scan.Filters{
+		{Text: "ERROR", Anchor: AnchorStart},
+		{Text: "healthcheck", Omit: true, Anchor: AnchorStart},
+	}
+	if !reflect.DeepEqual(props.Filters(), expected) {
+		t.Errorf("expected %+v, got %+v", expected, props.Filters())
+	}
+	if props.Filter() != expected[0] {
+		t.Errorf("expected the first filter %+v, got %+v", expected[0], props.Filter())
+	}
+	// Copies have their own filters.
+	c := props.Copy()
+	c.SetFilterAnchor(AnchorEnd)
+	if props.Filters()[0].Anchor != AnchorStart {
+		t.Errorf("expected the original's anchor unchanged, got %+v", props.Filters())
+	}
+}
+
+func TestExtractParams_filterAnchor(t *testing.T) {
+	props := NewProperties()
+	request := httptest.NewRequest("GET", "/read?name=x&filter-anchor=middle", nil)
+	if err := props.ExtractParams(request); err == nil {
+		t.Errorf("expected error for invalid filter-anchor")
+	}
+}
+
+func TestExtractParams_anchorSynonyms(t *testing.T) {
+	for value, expected := range map[string]string{
+		"prefix": AnchorStart, "suffix": AnchorEnd, "exact": AnchorWhole, "start": AnchorStart, "": "",
+	} {
+		props := NewProperties()
+		request := httptest.NewRequest("GET", "/read?name=x&filter=sshd&filter-anchor="+value, nil)
+		if err := props.ExtractParams(request); err != nil {
+			t.Errorf("%q: %s", value, err)
+			continue
+		}
+		if anchor := props.Filter().Anchor; anchor != expected {
+			t.Errorf("%q: expected anchor %q, got %q", value, expected, anchor)
+		}
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"app.log", `attachment; filename="app.log"`},
+		{"my app.log", `attachment; filename="my app.log"`},
+		{`say "hi".log`, `attachment; filename="say \"hi\".log"`},
+		{"café.log", `attachment; filename="caf_.log"; filename*=UTF-8''caf%C3%A9.log`},
+		{"日志 1.log", `attachment; filename="__ 1.log"; filename*=UTF-8''%E6%97%A5%E5%BF%97%201.log`},
+	}
+	for _, test := range tests {
+		if got := ContentDisposition(HdrAttachment, test.name); got != test.expected {
+			t.Errorf("%q: expected %s, got %s", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestExtractParams_filename(t *testing.T) {
+	tests := []struct {
+		value    string
+		ok       bool
+		expected string
+	}{
+		{"", true, "x.log"},
+		{"saved.txt", true, "saved.txt"},
+		{"café log.txt", true, "café log.txt"},
+		{"../x", false, ""},
+		{"a/b", false, ""},
+		{`a\b`, false, ""},
+		{"..", false, ""},
+		{"a\nb", false, ""},
+		{"a\xffb", false, ""},
+	}
+	for _, test := range tests {
+		props := NewProperties()
+		request := httptest.NewRequest("GET", "/read?name=x.log&filename="+url.QueryEscape(test.value), nil)
+		err := props.ExtractParams(request)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: expected ok %v, got %v", test.value, test.ok, err)
+			continue
+		}
+		if test.ok && props.ParamFilename() != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.value, test.expected, props.ParamFilename())
+		}
+	}
+}
+
+func TestAuthorized(t *testing.T) {
+	authzRules = map[string][]string{
+		"web":  {"nginx/*", "apache2"},
+		"logs": {"*.log"},
+		"*":    {"public"},
+	}
+	defer func() { authzRules = nil }()
+	tests := []struct {
+		principal string
+		name      string
+		allowed   bool
+		traverse  bool
+	}{
+		{"web", "", false, true},
+		{"web", "nginx", false, true},
+		{"web", "nginx/access.log", true, true},
+		{"web", "nginx/old/error.log", true, true},
+		{"web", "apache2/error.log", true, true},
+		{"web", "auth.log", false, false},
+		{"web", "public/x", true, true},
+		{"logs", "auth.log", true, true},
+		{"logs", "nginx/access.log", false, false},
+		{"", "public", true, true},
+		{"", "nginx", false, false},
+		{"stranger", "auth.log", false, false},
+	}
+	props := NewProperties()
+	for _, test := range tests {
+		props.SetPrincipal(test.principal)
+		if allowed := props.Authorized(test.name); allowed != test.allowed {
+			t.Errorf("%q on %q: expected authorized %v, got %v",
+				test.principal, test.name, test.allowed, allowed)
+		}
+		if traverse := props.AuthorizedToTraverse(test.name); traverse != test.traverse {
+			t.Errorf("%q on %q: expected traverse %v, got %v",
+				test.principal, test.name, test.traverse, traverse)
+		}
+	}
+}
+
+func TestRelativePath(t *testing.T) {
+	props := NewProperties()
+	for name, expected := range map[string]string{
+		"":           "",
+		"/":          "",
+		"abc//def/":  "abc/def",
+		"./x/../y/z": "y/z",
+	} {
+		if err := props.SetParamName(name); err != nil {
+			t.Fatalf("SetParamName(%q): %v", name, err)
+		}
+		if props.RelativePath() != expected {
+			t.Errorf("name %q: expected %q, got %q", name, expected, props.RelativePath())
+		}
+	}
+}
+
+func TestDenied(t *testing.T) {
+	patterns, err := loadDenyPatterns([]string{"auth.log,secure*", "*.key", "private/*"}, "")
+	if err != nil {
+		t.Fatalf("loadDenyPatterns: %v", err)
+	}
+	denyPatterns = patterns
+	defer func() { denyPatterns = nil }()
+	for name, expected := range map[string]bool{
+		"":                 false,
+		"syslog":           false,
+		"auth.log":         true,
+		"old/auth.log":     true,
+		"auth.log.1":       false,
+		"secure":           true,
+		"secure/x":         true,
+		"tls/server.key":   true,
+		"private":          false,
+		"private/a":        true,
+		"private/a/b":      true,
+		"other/private/a":  false,
+		"nginx/access.log": false,
+	} {
+		if denied := Denied(name); denied != expected {
+			t.Errorf("Denied(%q): expected %v, got %v", name, expected, denied)
+		}
+	}
+}
+
+func TestNewRequestID(t *testing.T) {
+	tests := []struct {
+		incoming string
+		honored  bool
+	}{
+		{"", false},
+		{"abc-123", true},
+		{"has space", false},
+		{"line\nbreak", false},
+		{string(make([]byte, maxRequestIDLength+1)), false},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest("GET", "/read", nil)
+		if test.incoming != "" {
+			request.Header.Set(HdrRequestID, test.incoming)
+		}
+		id := NewRequestID(request)
+		if test.honored && id != test.incoming {
+			t.Errorf("NewRequestID(%q): expected incoming ID, got %q", test.incoming, id)
+		}
+		if !test.honored && (id == test.incoming || len(id) != 16) {
+			t.Errorf("NewRequestID(%q): expected new ID, got %q", test.incoming, id)
+		}
+	}
+}
+
+func TestFormatTextFields(t *testing.T) {
+	tests := []struct {
+		fields   []interface{}
+		expected string
+	}{
+		{nil, ""},
+		{[]interface{}{"status", 200}, " status=200"},
+		{[]interface{}{"path", "/read"}, " path=/read"},
+		{[]interface{}{"msg", "two words"}, ` msg="two words"`},
+		{[]interface{}{"empty", ""}, ` empty=""`},
+		{[]interface{}{"err", errors.New("bad")}, " err=bad"},
+		{[]interface{}{"d", 2 * time.Millisecond}, " d=2ms"},
+		{[]interface{}{"a", 1, "lonely"}, " a=1 !BADKEY=lonely"},
+	}
+	for _, test := range tests {
+		got := formatTextFields(test.fields)
+		if got != test.expected {
+			t.Errorf("formatTextFields(%v): expected %q, got %q", test.fields, test.expected, got)
+		}
+	}
+}
+
+func TestFormatJSON(t *testing.T) {
+	when := time.Date(2023, 2, 17, 10, 28, 24, 0, time.UTC)
+	got := formatJSON(when, LogInfo, "access", []interface{}{"status", 200, "path", "/read"})
+	expected := `{"time":"2023-02-17T10:28:24Z","level":"INFO","app":"varlog","msg":"access","status":200,"path":"/read"}`
+	if got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
+
+func TestSetLogLevel(t *testing.T) {
+	defer SetLogLevel(LogInfo)
+	tests := []struct {
+		level    string
+		expected string
+		valid    bool
+	}{
+		{"debug", LogDebug, true},
+		{"WARN", LogWarning, true},
+		{"Error", LogError, true},
+		{"verbose", LogError, false},
+	}
+	for _, test := range tests {
+		err := SetLogLevel(test.level)
+		if (err == nil) != test.valid || LogLevel() != test.expected {
+			t.Errorf("SetLogLevel(%q): expected %s (valid %v), got %s (%v)",
+				test.level, test.expected, test.valid, LogLevel(), err)
+		}
+	}
+	if LogEnabled(LogWarning) || !LogEnabled(LogError) {
+		t.Errorf("expected only ERROR enabled at level ERROR")
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "config.yaml")
+	text := `# varlog settings
+root: /srv/logs   # trailing comment
+addr: "0.0.0.0:8000"
+log_format: 'json'
+deny: [auth.log, "*.key"]
+auth-token:
+  - ops:abc#123
+  - "ci:x y"
+`
+	if err := os.WriteFile(name, []byte(text), 0600); err != nil {
+		t.Fatal(err)
+	}
+	values, err := loadConfigFile(name)
+	if err != nil {
+		t.Fatalf("loadConfigFile: %s", err)
+	}
+	expected := map[string][]string{
+		"root":       {"/srv/logs"},
+		"addr":       {"0.0.0.0:8000"},
+		"log-format": {"json"},
+		"deny":       {"auth.log", "*.key"},
+		"auth-token": {"ops:abc#123", "ci:x y"},
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected %v, got %v", expected, values)
+	}
+
+	for _, bad := range []string{"no-such-flag: 1\n", "root\n", "  - orphan\n", "help: true\n"} {
+		if err := os.WriteFile(name, []byte(bad), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadConfigFile(name); err == nil {
+			t.Errorf("loadConfigFile(%q): expected error", bad)
+		}
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	if got := envName("max-concurrent-reads"); got != "VARLOG_MAX_CONCURRENT_READS" {
+		t.Errorf("expected VARLOG_MAX_CONCURRENT_READS, got %s", got)
+	}
+}
+
+func TestSetParamName_mounts(t *testing.T) {
+	props := NewProperties()
+	props.mounts = []Mount{{Name: "system", Path: "/var/log"}, {Name: "app", Path: "/srv/app/logs"}}
+	tests := []struct {
+		name     string
+		valid    bool
+		rooted   string
+		relative string
+	}{
+		{"", true, "", ""},
+		{"/", true, "", ""},
+		{"app", true, "/srv/app/logs", "app"},
+		{"app/service.log", true, "/srv/app/logs/service.log", "app/service.log"},
+		{"/system//syslog", true, "/var/log/syslog", "system/syslog"},
+		{"app:/service.log", true, "/srv/app/logs/service.log", "app/service.log"},
+		{"other/x", false, "", ""},
+		{"app/../../etc", false, "", ""},
+	}
+	for _, test := range tests {
+		err := props.SetParamName(test.name)
+		if (err == nil) != test.valid {
+			t.Errorf("name %q: expected valid %v, got %v", test.name, test.valid, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if props.RootedPath() != test.rooted || props.RelativePath() != test.relative {
+			t.Errorf("name %q: expected (%q, %q), got (%q, %q)", test.name,
+				test.rooted, test.relative, props.RootedPath(), props.RelativePath())
+		}
+		if props.MountTop() != (test.relative == "") {
+			t.Errorf("name %q: expected MountTop %v", test.name, test.relative == "")
+		}
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+		code   string
+		param  string
+	}{
+		{ParamError(ParamCount, "bad count"), http.StatusBadRequest, CodeInvalidParam, ParamCount},
+		{NewHTTPError(http.StatusNotFound, CodeNotFound, "gone"), http.StatusNotFound, CodeNotFound, ""},
+		{errors.New("boom"), http.StatusInternalServerError, CodeInternal, ""},
+	}
+	for _, c := range cases {
+		request := httptest.NewRequest("GET", "/read", nil)
+		request = request.WithContext(WithRequestID(request.Context(), "abc"))
+		recorder := httptest.NewRecorder()
+		WriteError(recorder, request, c.err)
+		if recorder.Code != c.status {
+			t.Errorf("expected status %d, got %d", c.status, recorder.Code)
+		}
+		var body errorEnvelope
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+			t.Fatalf("expected JSON body, got %q", recorder.Body.String())
+		}
+		want := errorBody{Code: c.code, Message: c.err.Error(), Param: c.param, RequestID: "abc"}
+		if !reflect.DeepEqual(body.Error, want) {
+			t.Errorf("expected %+v, got %+v", want, body.Error)
+		}
+	}
+}
+
+func TestExtractParams_paramError(t *testing.T) {
+	props := NewProperties()
+	request := httptest.NewRequest("GET", "/read?count=x", nil)
+	err := props.ExtractParams(request)
+	var e *HTTPError
+	if !errors.As(err, &e) {
+		t.Fatalf("expected HTTPError, got %v", err)
+	}
+	if e.Param != ParamCount || e.Status != http.StatusBadRequest {
+		t.Errorf("expected param %q status 400, got %q %d", ParamCount, e.Param, e.Status)
+	}
+}
+
+// Every invalid parameter is reported, in name order.
+func TestExtractParams_errors(t *testing.T) {
+	props := NewProperties()
+	request := httptest.NewRequest("GET", "/read?mode=lines&since=tomorrow&count=x&colour=red&length=-1&follow=", nil)
+	err := props.ExtractParams(request)
+	var e *HTTPError
+	if !errors.As(err, &e) {
+		t.Fatalf("expected HTTPError, got %v", err)
+	}
+	var params []string
+	for _, cause := range e.Causes {
+		params = append(params, cause.Param)
+	}
+	if expected := []string{"colour", ParamCount, ParamLength, ParamSince}; !reflect.DeepEqual(params, expected) {
+		t.Errorf("expected errors for %v, got %v", expected, params)
+	}
+	if e.Param != "colour" || !strings.HasPrefix(e.Message, "4 invalid parameters: ") {
+		t.Errorf("expected the first param and a summary, got %q %q", e.Param, e.Message)
+	}
+
+	recorder := httptest.NewRecorder()
+	WriteError(recorder, request, err)
+	var body errorEnvelope
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil || len(body.Error.Errors) != 4 ||
+		body.Error.Errors[1].Param != ParamCount || body.Error.Errors[1].Code != CodeInvalidParam {
+		t.Errorf("expected 4 errors in the envelope, got %s", recorder.Body)
+	}
+}
+
+func TestParams(t *testing.T) {
+	params := Params()
+	for j, p := range params {
+		if p.Name == "" || p.Description == "" || p.parse == nil {
+			t.Errorf("%q: expected a name, description, and parser", p.Name)
+		}
+		if j > 0 && params[j-1].Name >= p.Name {
+			t.Errorf("expected params sorted by name, got %q before %q", params[j-1].Name, p.Name)
+		}
+	}
+}
+
+func TestFileError(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+	}{
+		{os.ErrNotExist, http.StatusNotFound},
+		{&os.PathError{Op: "stat", Path: "x", Err: syscall.ENOTDIR}, http.StatusNotFound},
+		{&os.PathError{Op: "open", Path: "x", Err: syscall.EACCES}, http.StatusForbidden},
+		{&os.PathError{Op: "read", Path: "x", Err: syscall.EIO}, http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		e := FileError("x", c.err)
+		if e.Status != c.status {
+			t.Errorf("%v: expected status %d, got %d", c.err, c.status, e.Status)
+		}
+	}
+}
+
+func TestMap(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "big.log")
+	content := strings.Repeat("0123456789abcde\n", 1024)
+	os.WriteFile(p, []byte(content), 0644)
+
+	open := func() File {
+		file, err := Open(OSFileSystem, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	for _, minSize := range []int64{0, int64(len(content)) + 1} {
+		file := Map(open(), minSize)
+		if _, ok := file.(*os.File); !ok {
+			t.Errorf("threshold %d: expected *os.File, got %T", minSize, file)
+		}
+		file.Close()
+	}
+
+	file := Map(open(), 1)
+	defer file.Close()
+	if runtime.GOOS == "windows" {
+		return
+	}
+	slicer, ok := file.(interface {
+		Slice(offset int64, n int) ([]byte, error)
+	})
+	if !ok {
+		t.Fatalf("expected a mapped file, got %T", file)
+	}
+	if b, err := slicer.Slice(16, 16); err != nil || string(b) != "0123456789abcde\n" {
+		t.Errorf("expected second line, got %q, %v", b, err)
+	}
+	b := make([]byte, 10)
+	if n, err := file.ReadAt(b, int64(len(content))-5); n != 5 || err != io.EOF {
+		t.Errorf("expected 5 bytes and EOF at the end, got %d, %v", n, err)
+	}
+	if _, err := slicer.Slice(int64(len(content)), 1); err == nil {
+		t.Errorf("expected error slicing past the end")
+	}
+}
+
+func TestMap_truncated(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("relies on Linux SIGBUS behavior")
+	}
+	p := filepath.Join(t.TempDir(), "big.log")
+	os.WriteFile(p, bytes.Repeat([]byte("x\n"), 64*1024), 0644)
+	file, err := Open(OSFileSystem, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file = Map(file, 1)
+	defer file.Close()
+	os.Truncate(p, 0)
+
+	// As /read does: the fault becomes a panic to recover.
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	faulted := func() (fault bool) {
+		defer func() { fault = recover() != nil }()
+		b := make([]byte, 16)
+		file.ReadAt(b, 100000)
+		return false
+	}()
+	if !faulted {
+		t.Errorf("expected a fault reading a truncated mapping")
+	}
+}
+
+func TestRestat(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no mmap")
+	}
+	p := filepath.Join(t.TempDir(), "big.log")
+	os.WriteFile(p, bytes.Repeat([]byte("x\n"), 1024), 0644)
+	file, err := Open(OSFileSystem, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file = Map(file, 1)
+	defer file.Close()
+	os.Truncate(p, 10)
+
+	if info, err := file.Stat(); err != nil || info.Size() != 2048 {
+		t.Errorf("Stat: expected the mapped size 2048, got %v, %v", info, err)
+	}
+	if info, err := Restat(file); err != nil || info.Size() != 10 {
+		t.Errorf("Restat: expected the current size 10, got %v, %v", info, err)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2023, 2, 16, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value    string
+		ok       bool
+		expected time.Time
+	}{
+		{"2023-02-16T07:00:00Z", true, time.Date(2023, 2, 16, 7, 0, 0, 0, time.UTC)},
+		{"2023-02-16T08:00:00+01:00", true, time.Date(2023, 2, 16, 7, 0, 0, 0, time.UTC)},
+		{"90m", true, time.Date(2023, 2, 16, 10, 30, 0, 0, time.UTC)},
+		{"0s", true, now},
+		{"-1h", false, time.Time{}},
+		{"yesterday", false, time.Time{}},
+		{"2023-02-16", false, time.Time{}},
+	}
+	for _, test := range tests {
+		got, ok := ParseTime(test.value, now)
+		if ok != test.ok || !got.Equal(test.expected) {
+			t.Errorf("%q: expected %v %v, got %v %v", test.value, test.expected, test.ok, got, ok)
+		}
+	}
+}
+
+func TestAuthorized_groups(t *testing.T) {
+	authzRules = map[string][]string{
+		"alice": {"auth.log"},
+		"@web":  {"nginx/*"},
+		"@sre":  {"*"},
+	}
+	defer func() { authzRules = nil }()
+	tests := []struct {
+		groups  []string
+		name    string
+		allowed bool
+	}{
+		{nil, "auth.log", true},
+		{nil, "nginx/access.log", false},
+		{[]string{"web"}, "nginx/access.log", true},
+		{[]string{"web"}, "syslog", false},
+		{[]string{"web", "sre"}, "syslog", true},
+		{[]string{"alice"}, "syslog", false},
+	}
+	props := NewProperties()
+	props.SetPrincipal("alice")
+	for _, test := range tests {
+		props.SetGroups(test.groups)
+		if allowed := props.Authorized(test.name); allowed != test.allowed {
+			t.Errorf("%v on %q: expected authorized %v, got %v", test.groups, test.name, test.allowed, allowed)
+		}
+	}
+}
+
+// Copies are snapshots: changing one, or the global properties,
+// while others copy them must not race (go test -race).
+func TestProperties_concurrent(t *testing.T) {
+	props := DefaultProperties()
+	for _, name := range []string{"a", "b", "c"} {
+		props.AddMount(Mount{Name: name, Path: "/" + name})
+	}
+	var wg sync.WaitGroup
+	for j := 0; j < 4; j++ {
+		wg.Add(1)
+		go func(j int) {
+			defer wg.Done()
+			for k := 0; k < 100; k++ {
+				c := props.Copy()
+				c.AddMount(Mount{Name: "d", Path: "/d"})
+				c.SetChunkSize(j)
+				_ = NewProperties().ChunkSize()
+				SetChunkSize(DefaultChunkSize())
+			}
+		}(j)
+	}
+	wg.Wait()
+	if n := len(props.Overlays()); n != 3 {
+		t.Errorf("expected the original's 3 overlays, got %d", n)
+	}
+}
+
+func TestParseQueries(t *testing.T) {
+	queries, err := parseQueries([]string{"b=/top?name=x&extract=fields:1", "a=/read?name=app.log&filter=%20500"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 2 || queries[0].Name != "a" || queries[1].Endpoint() != "/top" {
+		t.Errorf("expected queries a and b, got %v", queries)
+	}
+	params := queries[0].Params(url.Values{"count": {"5"}, "filter": {"404"}})
+	if params.Get("name") != "app.log" || params.Get("filter") != "404" || params.Get("count") != "5" {
+		t.Errorf("expected the request's parameters to replace the query's, got %v", params)
+	}
+	for _, values := range [][]string{
+		{"a"},
+		{"a=/read", "a=/list"},
+		{"a=/admin/cache"},
+		{"a b=/read"},
+		{"a=/read?%zz"},
+	} {
+		if _, err := parseQueries(values); err == nil {
+			t.Errorf("%q: expected an error", values)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{FormatText, FormatJSON, FormatNDJSON}
+	tests := []struct {
+		accept []string
+		format string
+	}{
+		{nil, FormatText},
+		{[]string{"*/*"}, FormatText},
+		{[]string{"application/json"}, FormatJSON},
+		{[]string{"Application/JSON; charset=utf-8"}, FormatJSON},
+		{[]string{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"}, FormatText},
+		{[]string{"application/x-ndjson, application/json;q=0.9"}, FormatNDJSON},
+		{[]string{"application/*;q=0.5, application/x-ndjson;q=0.1, text/plain;q=0.2"}, FormatJSON},
+		{[]string{"text/*;q=0.1", "application/json;q=0.2"}, FormatJSON},
+		{[]string{"text/plain;q=0, */*"}, FormatJSON},
+		{[]string{"image/png"}, FormatText},
+		{[]string{"application/json;q=bogus"}, FormatText},
+	}
+	for _, test := range tests {
+		if format := negotiate(test.accept, offers); format != test.format {
+			t.Errorf("Accept %q: expected %s, got %s", test.accept, test.format, format)
+		}
+	}
+}
+
+func TestParseRetention(t *testing.T) {
+	policies, err := parseRetention([]string{"nginx/*=30d", "*.gz = 2w", "tmp=36h", "/audit/=1y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []RetentionPolicy{
+		{"nginx/*", 30 * 24 * time.Hour, "30d"},
+		{"*.gz", 14 * 24 * time.Hour, "2w"},
+		{"tmp", 36 * time.Hour, "36h"},
+		{"audit", 365 * 24 * time.Hour, "1y"},
+	}
+	if !reflect.DeepEqual([]RetentionPolicy(policies), expected) {
+		t.Errorf("expected %v, got %v", expected, policies)
+	}
+	for _, value := range []string{"nginx", "nginx=", "=30d", "nginx=0d", "nginx=-1h", "nginx=30x", "[=1d", "nginx=99999999999y"} {
+		if _, err := parseRetention([]string{value}); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+	props := DefaultProperties()
+	props.retention = policies
+	for name, pattern := range map[string]string{
+		"nginx/access.log":   "nginx/*",
+		"nginx/old/a.log.gz": "nginx/*",
+		"app/b.gz":           "*.gz",
+		"tmp/x":              "tmp",
+		"audit/audit.log":    "audit",
+		"app.log":            "",
+	} {
+		if policy, _ := props.Retention(name); policy.Pattern != pattern {
+			t.Errorf("%s: expected %q, got %q", name, pattern, policy.Pattern)
+		}
+	}
+}
+
+func TestResponseRecorder(t *testing.T) {
+	underlying := httptest.NewRecorder()
+	recorder := NewResponseRecorder(underlying)
+	written := 0
+	recorder.OnWrite = func(n int) { written += n }
+	recorder.WriteHeader(http.StatusNotFound)
+	recorder.WriteHeader(http.StatusOK) // Ignored, as by net/http
+	recorder.Write([]byte("hello"))
+	recorder.Flush()
+	if recorder.Status != http.StatusNotFound || recorder.Bytes != 5 || written != 5 || !underlying.Flushed {
+		t.Errorf("expected 404, 5 bytes, flushed; got %d, %d bytes, %d counted, flushed %v",
+			recorder.Status, recorder.Bytes, written, underlying.Flushed)
+	}
+	if NewResponseRecorder(httptest.NewRecorder()).Status != http.StatusOK {
+		t.Errorf("expected 200 without WriteHeader")
+	}
+}
@@ -0,0 +1,258 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Path-based authorization.  An -authz file maps principals (token
+// names, user names, client certificate common names) to the paths
+// they may access under the root:
+//
+//	# principal: pattern ...
+//	ops:         *
+//	web-team:    nginx/* apache2
+//	auditor:     audit/*.log
+//
+// A pattern allows a name if it matches the name, or any directory
+// containing the name, using path.Match syntax.  Thus "nginx/*" and
+// "nginx" both allow everything under nginx, and "*" allows everything.
+// A principal of "*" applies to every principal, including anonymous
+// requests when authentication is disabled.  A principal starting with
+// "@" names a group, applying to the members, as OIDC tokens list them:
+//
+//	@sre:        *
+//	@web:        nginx/*
+// Without an -authz file, all authenticated requests may access all paths.
+
+const (
+	principalKey contextKey = 0
+	groupsKey    contextKey = 1
+)
+
+// The loaded rules: principal => patterns.  Nil means no authorization.
+var authzRules map[string][]string
+
+// Write authorization.  DELETE /file and POST /truncate change log
+// files, so they are served only with a -write-authz file, in the
+// same format, naming the paths each principal may delete or
+// truncate.  The -authz rules must allow the path as well.
+
+// The authorization name of the whole audit trail.  A principal whose
+// -authz rules name it reads every principal's entries from /audit;
+// others read only their own.  It is not a path under the root, so
+// allowing the audit directory of /var/log does not allow the trail.
+const AuditTrail = "audit-trail"
+
+// The loaded write rules.  Nil disables the endpoints.
+var writeAuthzRules map[string][]string
+
+// LoadAuthz loads the -authz file.  An empty name removes
+// authorization: all authenticated requests may access all paths.
+func LoadAuthz(name string) error {
+	if name == "" {
+		authzRules = nil
+		return nil
+	}
+	rules, err := loadAuthz(name)
+	if err != nil {
+		return err
+	}
+	authzRules = rules
+	return nil
+}
+
+// LoadWriteAuthz loads the -write-authz file.  An empty name leaves
+// changes disabled.
+func LoadWriteAuthz(name string) error {
+	if name == "" {
+		writeAuthzRules = nil
+		return nil
+	}
+	rules, err := loadAuthz(name)
+	if err != nil {
+		return err
+	}
+	writeAuthzRules = rules
+	return nil
+}
+
+// WritesEnabled reports whether a -write-authz file was loaded.
+func WritesEnabled() bool {
+	return writeAuthzRules != nil
+}
+
+// WithPrincipal records the authenticated principal in a context.
+func WithPrincipal(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, principalKey, name)
+}
+
+// PrincipalFrom gives the principal recorded in a context, or
+// the empty string if the request was not authenticated.
+func PrincipalFrom(ctx context.Context) string {
+	name, _ := ctx.Value(principalKey).(string)
+	return name
+}
+
+// WithGroups records the authenticated principal's groups in a context.
+func WithGroups(ctx context.Context, groups []string) context.Context {
+	return context.WithValue(ctx, groupsKey, groups)
+}
+
+// GroupsFrom gives the groups recorded in a context, or nil.
+func GroupsFrom(ctx context.Context) []string {
+	groups, _ := ctx.Value(groupsKey).([]string)
+	return groups
+}
+
+// loadAuthz reads the authorization rules file.
+func loadAuthz(name string) (map[string][]string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	rules := make(map[string][]string)
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		principal, patterns, found := strings.Cut(line, ":")
+		principal = strings.TrimSpace(principal)
+		if !found || principal == "" {
+			return nil, errors.New(fmt.Sprintf("%s:%d: expected principal: patterns", name, lineNumber))
+		}
+		for _, pattern := range strings.Fields(patterns) {
+			pattern = strings.Trim(path.Clean(pattern), "/")
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, errors.New(fmt.Sprintf("%s:%d: bad pattern %q", name, lineNumber, pattern))
+			}
+			rules[principal] = append(rules[principal], pattern)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+// Authorized reports whether the request's principal may access the
+// given name, relative to the root ("" is the root itself).
+func (p *Properties) Authorized(name string) bool {
+	if authzRules == nil {
+		return true
+	}
+	for _, pattern := range p.authzPatterns() {
+		if patternAllows(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizedToTraverse reports whether the principal may list the given
+// directory: either it is authorized, or it leads to an authorized path.
+// For example, a principal allowed "nginx/*" may list the root (and see
+// only nginx there) to navigate to nginx.
+func (p *Properties) AuthorizedToTraverse(name string) bool {
+	if p.Authorized(name) {
+		return true
+	}
+	for _, pattern := range p.authzPatterns() {
+		if patternLeadsTo(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// authzPatterns gives the patterns that apply to the request's
+// principal and its groups.
+func (p *Properties) authzPatterns() []string {
+	return p.patternsIn(authzRules)
+}
+
+// patternsIn gives the patterns the rules grant the principal,
+// directly or through its groups.
+func (p *Properties) patternsIn(rules map[string][]string) []string {
+	patterns := rules["*"]
+	if p.principal != "" {
+		patterns = append(patterns[:len(patterns):len(patterns)], rules[p.principal]...)
+	}
+	for _, group := range p.groups {
+		patterns = append(patterns[:len(patterns):len(patterns)], rules["@"+group]...)
+	}
+	return patterns
+}
+
+// AuthorizedToWrite reports whether the request's principal may delete
+// or truncate the given name: both the -authz and the -write-authz
+// rules must allow it.  An anonymous request may not, even where
+// a rule for "*" would allow it.
+func (p *Properties) AuthorizedToWrite(name string) bool {
+	if writeAuthzRules == nil || p.principal == "" || !p.Authorized(name) {
+		return false
+	}
+	for _, pattern := range p.patternsIn(writeAuthzRules) {
+		if patternAllows(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizedToAudit reports whether the request's principal may read
+// the whole audit trail: an -authz rule must name AuditTrail for it,
+// exactly, so a "*" pattern allowing every path does not allow the
+// trail.  Without an -authz file, no one may, and anonymous requests
+// never may.
+func (p *Properties) AuthorizedToAudit() bool {
+	if p.principal == "" {
+		return false
+	}
+	for _, pattern := range p.authzPatterns() {
+		if pattern == AuditTrail {
+			return true
+		}
+	}
+	return false
+}
+
+// patternAllows reports whether the pattern matches the name or one
+// of its directories.
+func patternAllows(pattern string, name string) bool {
+	if pattern == "*" {
+		return true
+	}
+	for name != "" && name != "." {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+		name = path.Dir(name)
+	}
+	return false
+}
+
+// patternLeadsTo reports whether the directory name is an ancestor of
+// paths the pattern matches.  Compares components: "nginx" leads to
+// "nginx/*", and "" (the root) leads to everything.
+func patternLeadsTo(pattern string, name string) bool {
+	if name == "" || name == "." {
+		return true
+	}
+	patternParts := strings.Split(pattern, "/")
+	nameParts := strings.Split(name, "/")
+	if len(nameParts) >= len(patternParts) {
+		return false
+	}
+	for i, part := range nameParts {
+		if matched, _ := path.Match(patternParts[i], part); !matched {
+			return false
+		}
+	}
+	return true
+}
@@ -1,33 +1,314 @@
 package app
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"varlog/service/dockerfs"
+	"varlog/service/rotate"
+	"varlog/service/s3fs"
 )
 
 type CliFlags struct {
-	help  bool
-	Chunk int
-	Port  int
-	Root  string
+	help           bool
+	Addr           string
+	AlertFile      string
+	AllowNets      stringList
+	AuditLog       string
+	AuthCommand    string
+	AuthHtpasswd   string
+	AuthSocket     string
+	AuthTokenFile  string
+	AuthTokens     stringList
+	Authz          string
+	BasePath       string
+	BenchIO        bool
+	CaptureDir     string
+	Chunk          int
+	ChunkAuto      bool
+	CoalesceReads  bool
+	Config         string
+	Deny           stringList
+	DenyFile       string
+	DenyNets       stringList
+	Docker         bool
+	DockerDir      string
+	ExportDests    stringList
+	FIFOMaxBytes   int64
+	FIFOTimeout    time.Duration
+	IndexDir       string
+	Journal        bool
+	ListCacheTTL   time.Duration
+	LogFile        string
+	LogFormat      string
+	LogLevel       string
+	MaxBytes       int64
+	MaxLineBytes   int
+	MaxLines       int
+	MaxReads       int
+	MmapThreshold  int64
+	Mounts         stringList
+	OIDCAudience   string
+	OIDCGroups     string
+	OIDCIssuer     string
+	OIDCJWKSURL    string
+	OIDCPrincipal  string
+	OTLPEndpoint   string
+	OTLPHeaders    stringList
+	PeerFile       string
+	PeerRefresh    time.Duration
+	PeerSRV        string
+	Peers          stringList
+	Port           int
+	Queries        stringList
+	QuotaFile      string
+	ReadAhead      int
+	ReadFIFOs      bool
+	Redact         stringList
+	Retention      stringList
+	RetentionSweep time.Duration
+	Root           string
+	RotateAge      time.Duration
+	RotateCompress bool
+	RotateKeep     int
+	RotateSize     int64
+	S3             string
+	S3Endpoint     string
+	S3Region       string
+	SSHHosts       stringList
+	TLSCert        string
+	TLSClientCA    string
+	TLSKey         string
+	TLSReload      bool
+	TraceRatio     float64
+	UI             bool
+	UpgradeGrace   time.Duration
+	WriteAuthz     string
 }
 
 var Cli CliFlags
 
+// A flag value that may be repeated, collecting each occurrence.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
 func init() {
 	helpUsage := "Request a usage message"
 	flag.BoolVar(&Cli.help, "help", false, helpUsage)
 	flag.BoolVar(&Cli.help, "?", false, helpUsage)
+	flag.StringVar(&Cli.Addr, "addr", "",
+		"Listen address as host:port, e.g., 0.0.0.0:8000 or [::1]:8000. "+
+			"A host without a port uses -port. Empty listens on localhost with -port.")
+	flag.StringVar(&Cli.AlertFile, "alert-file", "",
+		"File of 'query: threshold=N every=5m webhook=URL' lines, running saved "+
+			"queries on a schedule and posting to the webhook when matches reach the threshold.")
+	flag.Var(&Cli.AllowNets, "allow-net",
+		"Comma-separated networks (CIDR) or addresses allowed to connect, "+
+			"e.g., 10.0.0.0/8,127.0.0.1. May be repeated. Default allows all.")
+	flag.StringVar(&Cli.AuditLog, "audit-log", "",
+		"File to append the audit trail of authenticated requests to, "+
+			"as JSON lines, for /audit. Empty keeps only recent entries in memory.")
+	flag.StringVar(&Cli.AuthCommand, "auth-command", "",
+		"Executable that validates credentials: JSON on stdin, "+
+			"exit status zero (or {\"allow\":true}) to accept.")
+	flag.StringVar(&Cli.AuthSocket, "auth-socket", "",
+		"Unix socket that validates credentials: one JSON line each way.")
+	flag.StringVar(&Cli.AuthHtpasswd, "auth-htpasswd", "",
+		"File of user:hash lines with SHA-crypt hashes (openssl passwd -6) for basic authentication.")
+	flag.Var(&Cli.AuthTokens, "auth-token",
+		"Bearer token as name:token. May be repeated. "+
+			"Prefer -auth-token-file, since flags are visible to other users.")
+	flag.StringVar(&Cli.AuthTokenFile, "auth-token-file", "",
+		"File of name:token lines for bearer token authentication.")
+	flag.StringVar(&Cli.Authz, "authz", "",
+		"File of 'principal: pattern ...' lines limiting the paths "+
+			"each client may access. Default allows all paths.")
+	flag.StringVar(&Cli.BasePath, "base-path", "",
+		"URL prefix for all routes, e.g., /varlog behind a reverse proxy "+
+			"that forwards /varlog/... unchanged. Empty serves from /.")
+	flag.BoolVar(&Cli.BenchIO, "bench-io", false,
+		"Measure read throughput at several chunk sizes on the root volume, "+
+			"report a suggested -chunk value, and exit.")
+	flag.StringVar(&Cli.CaptureDir, "capture-dir", "",
+		"Directory for diagnostic bundles of failing /read requests. "+
+			"Empty disables capture.")
 	flag.IntVar(&Cli.Chunk, "chunk", defaultChunkSize,
 		"The byte count for reading file system chunks. "+
 			"Zero keeps the default. Otherwise must be positive.")
+	flag.BoolVar(&Cli.ChunkAuto, "chunk-auto", false,
+		"Measure read throughput at startup and use the best chunk size. "+
+			"Ignored if -chunk is given explicitly.")
+	flag.BoolVar(&Cli.CoalesceReads, "coalesce-reads", false,
+		"Share one scan among identical /read requests in progress at once, "+
+			"sending its response to each client.")
+	flag.StringVar(&Cli.Config, "config", "",
+		"Configuration file of 'flag-name: value' lines (a YAML subset). "+
+			"Also VARLOG_CONFIG. Flags and VARLOG_* variables take precedence.")
+	flag.Int64Var(&Cli.FIFOMaxBytes, "fifo-max-bytes", defaultFIFOMaxBytes,
+		"Most bytes one /read takes from a named pipe, with -read-fifos.")
+	flag.DurationVar(&Cli.FIFOTimeout, "fifo-timeout", defaultFIFOTimeout,
+		"Longest one /read reads a named pipe, with -read-fifos, e.g., 30s.")
+	flag.StringVar(&Cli.IndexDir, "index-dir", "",
+		"Directory for line-offset indexes of log files, kept up to date "+
+			"in the background. Empty disables indexing.")
+	flag.BoolVar(&Cli.Journal, "journal", false,
+		"Serve the systemd journal at /journal, newest entries first, "+
+			"using journalctl.")
+	flag.DurationVar(&Cli.ListCacheTTL, "list-cache-ttl", defaultListCacheTTL,
+		"How long /list reuses a directory's entries while its modification "+
+			"time is unchanged, e.g., 2s. Zero disables the cache.")
+	flag.StringVar(&Cli.LogFile, "log-file", "",
+		"File to append the service log, including access lines, to, "+
+			"rotated by the -rotate-... options. Empty logs to stderr.")
+	flag.StringVar(&Cli.LogFormat, "log-format", LogFormatText,
+		"Log entry format: text (plain lines) or json (one object per line).")
+	flag.StringVar(&Cli.LogLevel, "log-level", LogInfo,
+		"Minimum level logged: DEBUG, INFO, WARNING, or ERROR.")
+	flag.Int64Var(&Cli.MaxBytes, "max-response-bytes", 0,
+		"Maximum bytes in a /read or /journal response. Longer responses are "+
+			"truncated with an X-Varlog-Truncated trailer. Zero means no limit.")
+	flag.IntVar(&Cli.MaxLineBytes, "max-line-bytes", defaultMaxLineBytes,
+		"Bytes kept of one line. Longer lines are cut with a marker, "+
+			"dropped, or refused, as the truncate parameter says. Zero means no limit.")
+	flag.IntVar(&Cli.MaxLines, "max-response-lines", 0,
+		"Maximum lines in a /read or /journal response. Longer responses are "+
+			"truncated with an X-Varlog-Truncated trailer. Zero means no limit.")
+	flag.IntVar(&Cli.MaxReads, "max-concurrent-reads", 0,
+		"Maximum simultaneous /read operations. Further requests get "+
+			"429 Too Many Requests. Zero means no limit.")
+	flag.Int64Var(&Cli.MmapThreshold, "mmap-threshold", 0,
+		"Map /read files of at least this many bytes into memory, "+
+			"rather than reading them. Zero never maps.")
+	flag.Var(&Cli.Mounts, "mount",
+		"Named root as name=path, e.g., app=/srv/myapp/logs. May be repeated. "+
+			"Requests then name files as name/file. Replaces -root.")
+	flag.StringVar(&Cli.OIDCAudience, "oidc-audience", "",
+		"Audience OIDC tokens must name, such as the service's client ID. "+
+			"Required with -oidc-issuer.")
+	flag.StringVar(&Cli.OIDCGroups, "oidc-groups-claim", defaultOIDCGroupsClaim,
+		"OIDC token claim listing the client's groups, for -authz @group rules.")
+	flag.StringVar(&Cli.OIDCIssuer, "oidc-issuer", "",
+		"Accept bearer tokens that are JWTs signed by this OpenID Connect issuer, "+
+			"e.g., https://login.example.com.")
+	flag.StringVar(&Cli.OIDCJWKSURL, "oidc-jwks-url", "",
+		"Location of the issuer's signing keys. Empty uses the issuer's discovery document.")
+	flag.StringVar(&Cli.OIDCPrincipal, "oidc-principal-claim", defaultOIDCPrincipalClaim,
+		"OIDC token claim naming the client, e.g., email.")
+	flag.StringVar(&Cli.OTLPEndpoint, "otlp-endpoint", "",
+		"OpenTelemetry collector receiving traces over OTLP/HTTP, e.g., http://collector:4318. "+
+			"Also OTEL_EXPORTER_OTLP_ENDPOINT. Empty disables tracing.")
+	flag.Var(&Cli.OTLPHeaders, "otlp-header",
+		"Header sent to the OTLP collector as name=value, e.g., for its API key. May be repeated.")
 	flag.IntVar(&Cli.Port, "port", defaultPort,
 		"Port on which the service listens for incoming connections. "+
 			"Zero keeps the default; otherwise must be positive.")
+	flag.Var(&Cli.Queries, "query",
+		"Saved query, as name=target, e.g., "+
+			"'nginx-5xx=/read?name=nginx/access.log&filter=%20500', "+
+			"served as /query/NAME. May be repeated.")
+	flag.StringVar(&Cli.QuotaFile, "quota-file", "",
+		"File of 'principal: limit ...' lines capping the requests and bytes "+
+			"each client is served per hour or day. Default has no quotas.")
+	flag.IntVar(&Cli.ReadAhead, "read-ahead", defaultReadAhead,
+		"Chunks /read reads and parses in the background, ahead of "+
+			"writing the response. Zero reads each chunk when needed.")
+	flag.BoolVar(&Cli.ReadFIFOs, "read-fifos", false,
+		"Allow /read with follow=true on named pipes under the root, "+
+			"within -fifo-timeout and -fifo-max-bytes.")
+	flag.Var(&Cli.Redact, "redact",
+		"Line processor for the files under a path prefix, as prefix=processor, e.g., "+
+			"payments=card or '*=bearer': card, email, bearer, or regex:PATTERN. May be repeated.")
+	flag.Var(&Cli.Retention, "retention",
+		"How long files matching a pattern are kept, as pattern=age, e.g., "+
+			"'nginx/*=30d'; the first match applies. Shown by /list. May be repeated.")
+	flag.DurationVar(&Cli.RetentionSweep, "retention-sweep", 0,
+		"Interval between removals of files past their -retention age. "+
+			"Zero (the default) removes nothing.")
 	flag.StringVar(&Cli.Root, "root", defaultPathRoot,
 		"Root directory for all file operations.")
+	flag.DurationVar(&Cli.RotateAge, "rotate-age", 0,
+		"Rotate the audit log and -log-file once open this long, e.g., 24h. "+
+			"Zero rotates by size only.")
+	flag.BoolVar(&Cli.RotateCompress, "rotate-compress", false,
+		"Gzip rotated audit and service log files.")
+	flag.IntVar(&Cli.RotateKeep, "rotate-keep", defaultRotateKeep,
+		"Rotated audit and service log files kept; older ones are removed.")
+	flag.Int64Var(&Cli.RotateSize, "rotate-size", 0,
+		"Rotate the audit log and -log-file before they pass this many bytes. "+
+			"Zero rotates by age only; with neither, files are not rotated.")
+	flag.StringVar(&Cli.S3, "s3", "",
+		"Serve logs from an S3-compatible bucket, as s3://bucket/prefix, "+
+			"instead of local files. Replaces -root.")
+	flag.StringVar(&Cli.S3Endpoint, "s3-endpoint", "",
+		"Base URL of an S3-compatible service, e.g., http://minio:9000. "+
+			"Empty uses AWS.")
+	flag.StringVar(&Cli.S3Region, "s3-region", "us-east-1",
+		"Region for signing S3 requests.")
+	flag.Var(&Cli.SSHHosts, "ssh-host",
+		"Remote host served over ssh, as name=destination:/root, e.g., "+
+			"web1=admin@web1.example.com:/var/log. May be repeated. Replaces -root.")
+	flag.Var(&Cli.Deny, "deny",
+		"Comma-separated path patterns hidden from all clients, "+
+			"e.g., auth.log,secure*,*.key. May be repeated.")
+	flag.StringVar(&Cli.DenyFile, "deny-file", "",
+		"File of path patterns hidden from all clients, one per line.")
+	flag.Var(&Cli.DenyNets, "deny-net",
+		"Comma-separated networks (CIDR) or addresses refused, "+
+			"even if -allow-net allows them. May be repeated.")
+	flag.BoolVar(&Cli.Docker, "docker", false,
+		"Serve Docker container logs as containers/NAME, "+
+			"decoding the json-file log driver's lines.")
+	flag.StringVar(&Cli.DockerDir, "docker-dir", dockerfs.DefaultDir,
+		"Docker's containers directory, for -docker.")
+	flag.Var(&Cli.ExportDests, "export-dest",
+		"Destination /export uploads to, as name=URL: s3://bucket/prefix, "+
+			"sftp://user@host/dir, or an http(s) URL taking PUT. May be repeated.")
+	flag.Var(&Cli.Peers, "peer",
+		"Server /fleet/list and /fleet/read fan out to, as name=URL, "+
+			"e.g., web1=http://web1:8000. May be repeated.")
+	flag.StringVar(&Cli.PeerFile, "peer-file", "",
+		"File of name=URL peer lines, reread on SIGHUP and every -peer-refresh.")
+	flag.DurationVar(&Cli.PeerRefresh, "peer-refresh", defaultPeerRefresh,
+		"Interval between peer discoveries and health checks.")
+	flag.StringVar(&Cli.PeerSRV, "peer-srv", "",
+		"DNS SRV name listing peers, e.g., _varlog._tcp.example.com, "+
+			"which must serve HTTPS.")
+	flag.StringVar(&Cli.TLSCert, "tls-cert", "",
+		"PEM certificate file.  With -tls-key, the service uses HTTPS.")
+	flag.StringVar(&Cli.TLSClientCA, "tls-client-ca", "",
+		"PEM file of CA certificates.  With TLS, clients must present "+
+			"a certificate signed by one of these CAs.")
+	flag.StringVar(&Cli.TLSKey, "tls-key", "",
+		"PEM private key file for -tls-cert.")
+	flag.BoolVar(&Cli.TLSReload, "tls-reload", false,
+		"Reload the TLS certificate and key when the files change.")
+	flag.Float64Var(&Cli.TraceRatio, "trace-ratio", defaultTraceRatio,
+		"Fraction of requests traced, from 0 to 1, when the caller's traceparent "+
+			"does not decide. Applies with -otlp-endpoint.")
+	flag.BoolVar(&Cli.UI, "ui", true,
+		"Serve the web interface at /.  Use -ui=false to serve only the API.")
+	flag.DurationVar(&Cli.UpgradeGrace, "upgrade-grace", defaultUpgradeGrace,
+		"After SIGUSR2 hands the socket to a new process, how long the old one "+
+			"serves requests in progress, such as follow=true reads.")
+	flag.StringVar(&Cli.WriteAuthz, "write-authz", "",
+		"File of 'principal: pattern ...' lines enabling DELETE /file and POST /truncate "+
+			"for the paths each client may change. Default disables them.")
 	flag.Usage = usage
 }
 
@@ -35,6 +316,8 @@ func init() {
 // The program currently accepts one optional argument, giving
 // an alternative rooted path instead of /var/args.
 func DoCli() {
+	propertiesMutex.Lock()
+	defer propertiesMutex.Unlock()
 	parseFlags()
 	setProperties()
 }
@@ -47,6 +330,14 @@ func parseFlags() {
 		os.Exit(0)
 	}
 
+	if Cli.Config == "" {
+		Cli.Config = os.Getenv(envName("config"))
+	}
+	if err := applyConfig(Cli.Config); err != nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** Invalid configuration: %s\n", err)
+		os.Exit(1)
+	}
+
 	switch {
 	case Cli.Chunk < 0:
 		fmt.Fprintf(flag.CommandLine.Output(), "*** Chunk size (%d) cannot be negative.\n", Cli.Chunk)
@@ -64,26 +355,374 @@ func parseFlags() {
 		Cli.Port = defaultPort
 	}
 
-	if Cli.Root == "" {
-		Cli.Root = defaultPathRoot
+	if Cli.AuthCommand != "" && Cli.AuthSocket != "" {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** Use only one of -auth-command and -auth-socket.\n")
+		os.Exit(1)
+	}
+
+	if err := LoadAuthz(Cli.Authz); err != nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** Invalid -authz file: %s\n", err)
+		os.Exit(1)
+	}
+
+	if err := LoadWriteAuthz(Cli.WriteAuthz); err != nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** Invalid -write-authz file: %s\n", err)
+		os.Exit(1)
+	}
+
+	if Cli.BasePath != "" {
+		if !strings.HasPrefix(Cli.BasePath, "/") {
+			fmt.Fprintf(flag.CommandLine.Output(), "*** Base path (%s) must start with /.\n", Cli.BasePath)
+			os.Exit(1)
+		}
+		Cli.BasePath = strings.TrimSuffix(path.Clean(Cli.BasePath), "/")
+	}
+
+	if Cli.CaptureDir != "" {
+		fileInfo, err := os.Stat(Cli.CaptureDir)
+		if err != nil || !fileInfo.Mode().IsDir() {
+			fmt.Fprintf(flag.CommandLine.Output(), "*** Capture directory (%s) is not a directory.\n", Cli.CaptureDir)
+			os.Exit(1)
+		}
+	}
+
+	if Cli.IndexDir != "" {
+		fileInfo, err := os.Stat(Cli.IndexDir)
+		if err != nil || !fileInfo.Mode().IsDir() {
+			fmt.Fprintf(flag.CommandLine.Output(), "*** Index directory (%s) is not a directory.\n", Cli.IndexDir)
+			os.Exit(1)
+		}
+	}
+
+	patterns, err := loadDenyPatterns(Cli.Deny, Cli.DenyFile)
+	if err != nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** Invalid deny-list: %s\n", err)
+		os.Exit(1)
+	}
+	denyPatterns = patterns
+
+	queries, err := parseQueries(Cli.Queries)
+	if err != nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** Invalid -query: %s\n", err)
+		os.Exit(1)
+	}
+	properties.queries = queries
+
+	redactRules, err := parseRedactRules(Cli.Redact)
+	if err != nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** Invalid -redact: %s\n", err)
+		os.Exit(1)
+	}
+	properties.redactRules = redactRules
+
+	retention, err := parseRetention(Cli.Retention)
+	if err != nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** Invalid -retention: %s\n", err)
+		os.Exit(1)
+	}
+	properties.retention = retention
+	if Cli.RetentionSweep < 0 {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** Retention sweep interval (%v) must not be negative.\n", Cli.RetentionSweep)
+		os.Exit(1)
+	}
+	properties.retentionSweep = Cli.RetentionSweep
+
+	if _, err := ParseNets(Cli.AllowNets); err != nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** Invalid -allow-net: %s\n", err)
+		os.Exit(1)
+	}
+	if _, err := ParseNets(Cli.DenyNets); err != nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** Invalid -deny-net: %s\n", err)
+		os.Exit(1)
+	}
+
+	switch Cli.LogFormat {
+	case LogFormatText, LogFormatJSON:
+	default:
+		fmt.Fprintf(flag.CommandLine.Output(), "*** Log format (%s) must be text or json.\n", Cli.LogFormat)
+		os.Exit(1)
 	}
-	Cli.Root = path.Clean(Cli.Root)
-	switch Cli.Root {
-	case ".", "..", "/":
-		fmt.Fprintf(flag.CommandLine.Output(), "*** Invalid root directory (%s)\n", Cli.Root)
+
+	if severity(Cli.LogLevel) < 0 {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** Log level (%s) must be DEBUG, INFO, WARNING, or ERROR.\n", Cli.LogLevel)
 		os.Exit(1)
 	}
-	fileInfo, err := os.Stat(Cli.Root)
-	if err != nil || !fileInfo.Mode().IsDir() {
-		fmt.Fprintf(flag.CommandLine.Output(), "*** Root (%s) is not a directory.\n", Cli.Root)
+
+	if Cli.RotateSize < 0 || Cli.RotateAge < 0 || Cli.RotateKeep < 1 {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"*** Rotation needs -rotate-size and -rotate-age of zero or more, and -rotate-keep of at least 1.\n")
 		os.Exit(1)
 	}
+	if Cli.PeerRefresh < time.Second {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** Peer refresh (%s) must be at least 1s.\n", Cli.PeerRefresh)
+		os.Exit(1)
+	}
+
+	if Cli.ListCacheTTL < 0 {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** List cache TTL (%s) cannot be negative.\n", Cli.ListCacheTTL)
+		os.Exit(1)
+	}
+
+	if Cli.MaxLineBytes < 0 {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** -max-line-bytes (%d) cannot be negative.\n", Cli.MaxLineBytes)
+		os.Exit(1)
+	}
+	if Cli.MaxBytes < 0 || Cli.MaxLines < 0 {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** Response limits cannot be negative.\n")
+		os.Exit(1)
+	}
+	if Cli.ReadAhead < 0 {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** -read-ahead (%d) cannot be negative.\n", Cli.ReadAhead)
+		os.Exit(1)
+	}
+	if Cli.OIDCIssuer != "" && Cli.OIDCAudience == "" {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** -oidc-issuer requires -oidc-audience.\n")
+		os.Exit(1)
+	}
+	if Cli.OTLPEndpoint == "" {
+		Cli.OTLPEndpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	if Cli.OTLPEndpoint != "" {
+		u, err := url.Parse(Cli.OTLPEndpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fmt.Fprintf(flag.CommandLine.Output(), "*** -otlp-endpoint (%s) is not an http or https URL.\n", Cli.OTLPEndpoint)
+			os.Exit(1)
+		}
+	}
+	for _, header := range Cli.OTLPHeaders {
+		if name, _, ok := strings.Cut(header, "="); !ok || strings.TrimSpace(name) == "" {
+			fmt.Fprintf(flag.CommandLine.Output(), "*** -otlp-header (%s) is not name=value.\n", header)
+			os.Exit(1)
+		}
+	}
+	if Cli.TraceRatio < 0 || Cli.TraceRatio > 1 {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** -trace-ratio (%g) must be from 0 to 1.\n", Cli.TraceRatio)
+		os.Exit(1)
+	}
+	if Cli.UpgradeGrace < 0 {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** -upgrade-grace (%s) cannot be negative.\n", Cli.UpgradeGrace)
+		os.Exit(1)
+	}
+	if Cli.FIFOMaxBytes <= 0 || Cli.FIFOTimeout <= 0 {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** -fifo-max-bytes and -fifo-timeout must be positive.\n")
+		os.Exit(1)
+	}
+	if Cli.MmapThreshold < 0 {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** -mmap-threshold (%d) cannot be negative.\n", Cli.MmapThreshold)
+		os.Exit(1)
+	}
+
+	if Cli.MaxReads < 0 {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** Maximum concurrent reads (%d) cannot be negative.\n", Cli.MaxReads)
+		os.Exit(1)
+	}
+
+	addr, err := listenAddr(Cli.Addr, Cli.Port)
+	if err != nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** Invalid listen address (%s): %s\n", Cli.Addr, err)
+		os.Exit(1)
+	}
+	Cli.Addr = addr
+
+	if Cli.S3 != "" {
+		if len(Cli.Mounts) > 0 || (Cli.Root != "" && Cli.Root != defaultPathRoot) {
+			fmt.Fprintf(flag.CommandLine.Output(), "*** Use only one of -root, -mount, and -s3.\n")
+			os.Exit(1)
+		}
+		if Cli.BenchIO || Cli.ChunkAuto {
+			fmt.Fprintf(flag.CommandLine.Output(), "*** -bench-io and -chunk-auto measure local disks, not -s3.\n")
+			os.Exit(1)
+		}
+		bucket, prefix, err := s3fs.ParseURL(Cli.S3)
+		if err != nil {
+			fmt.Fprintf(flag.CommandLine.Output(), "*** Invalid -s3: %s\n", err)
+			os.Exit(1)
+		}
+		// The file system's top-level directories are buckets.
+		Cli.Root = path.Join("/", bucket, prefix)
+	}
+
+	if len(Cli.SSHHosts) > 0 {
+		if len(Cli.Mounts) > 0 || Cli.S3 != "" || (Cli.Root != "" && Cli.Root != defaultPathRoot) {
+			fmt.Fprintf(flag.CommandLine.Output(), "*** Use only one of -root, -mount, -s3, and -ssh-host.\n")
+			os.Exit(1)
+		}
+		if Cli.BenchIO || Cli.ChunkAuto {
+			fmt.Fprintf(flag.CommandLine.Output(), "*** -bench-io and -chunk-auto measure local disks, not -ssh-host.\n")
+			os.Exit(1)
+		}
+		hosts, err := parseSSHHosts(Cli.SSHHosts)
+		if err != nil {
+			fmt.Fprintf(flag.CommandLine.Output(), "*** Invalid -ssh-host: %s\n", err)
+			os.Exit(1)
+		}
+		// Each host is a mount, at /name/root in the hosts' file system.
+		for _, h := range hosts {
+			properties.mounts = append(properties.mounts, Mount{Name: h.Name, Path: path.Join("/", h.Name, h.Root)})
+		}
+		properties.sshHosts = hosts
+		Cli.Root = ""
+	}
+
+	mounts, err := parseMounts(Cli.Mounts)
+	if err != nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** Invalid -mount: %s\n", err)
+		os.Exit(1)
+	}
+	switch {
+	case len(Cli.SSHHosts) > 0:
+		// The hosts are the mounts, as set above.
+	case len(mounts) > 0:
+		// The mounts replace the single root.
+		if Cli.Root != "" && Cli.Root != defaultPathRoot {
+			fmt.Fprintf(flag.CommandLine.Output(), "*** Use only one of -root and -mount.\n")
+			os.Exit(1)
+		}
+		properties.mounts = mounts
+		Cli.Root = ""
+	default:
+		if Cli.Root == "" {
+			Cli.Root = defaultPathRoot
+		}
+		Cli.Root = filepath.Clean(Cli.Root)
+		switch SlashPath(Cli.Root) {
+		case ".", "..", "/":
+			fmt.Fprintf(flag.CommandLine.Output(), "*** Invalid root directory (%s)\n", Cli.Root)
+			os.Exit(1)
+		}
+		if Cli.S3 == "" {
+			fileInfo, err := os.Stat(Cli.Root)
+			if err != nil || !fileInfo.Mode().IsDir() {
+				fmt.Fprintf(flag.CommandLine.Output(), "*** Root (%s) is not a directory.\n", Cli.Root)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if Cli.Docker {
+		fileInfo, err := os.Stat(Cli.DockerDir)
+		if err != nil || !fileInfo.Mode().IsDir() {
+			fmt.Fprintf(flag.CommandLine.Output(), "*** Docker directory (%s) is not a directory.\n", Cli.DockerDir)
+			os.Exit(1)
+		}
+		for _, m := range properties.mounts {
+			if m.Name == ContainersMount {
+				fmt.Fprintf(flag.CommandLine.Output(), "*** -docker uses the mount name %q.\n", ContainersMount)
+				os.Exit(1)
+			}
+		}
+		properties.AddMount(Mount{
+			Name:   ContainersMount,
+			Path:   "/",
+			FS:     dockerfs.New(Cli.DockerDir),
+			Format: dockerfs.Format,
+		})
+	}
+
+	if (Cli.TLSCert == "") != (Cli.TLSKey == "") {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** TLS requires both -tls-cert and -tls-key.\n")
+		os.Exit(1)
+	}
+	if Cli.TLSClientCA != "" && Cli.TLSCert == "" {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** -tls-client-ca requires -tls-cert and -tls-key.\n")
+		os.Exit(1)
+	}
+	if Cli.TLSReload && Cli.TLSCert == "" {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** -tls-reload requires -tls-cert and -tls-key.\n")
+		os.Exit(1)
+	}
+}
+
+// Combines the -addr and -port flags into a listen address.
+// An empty address listens on localhost.  An address without a port
+// uses the given port.  IPv6 addresses must be bracketed when a port
+// is present, as in [::1]:8000.
+func listenAddr(addr string, port int) (string, error) {
+	if addr == "" {
+		return net.JoinHostPort(defaultHost, strconv.Itoa(port)), nil
+	}
+	host, portText, err := net.SplitHostPort(addr)
+	if err != nil {
+		// Allow a bare host (or bracketed IPv6 address) without a port.
+		host = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+		if strings.ContainsAny(host, "[]") ||
+			(strings.Contains(host, ":") && net.ParseIP(host) == nil) {
+			return "", err
+		}
+		return net.JoinHostPort(host, strconv.Itoa(port)), nil
+	}
+	n, err := strconv.Atoi(portText)
+	if err != nil || n <= 0 || n > 65535 {
+		return "", errors.New(fmt.Sprintf("port %q not a positive number", portText))
+	}
+	return net.JoinHostPort(host, portText), nil
 }
 
 func setProperties() {
+	properties.addr = Cli.Addr
+	properties.alertFile = Cli.AlertFile
+	properties.allowNets = Cli.AllowNets
+	properties.auditLog = Cli.AuditLog
+	properties.authCommand = Cli.AuthCommand
+	properties.authHtpasswd = Cli.AuthHtpasswd
+	properties.authSocket = Cli.AuthSocket
+	properties.authTokenFile = Cli.AuthTokenFile
+	properties.authTokens = Cli.AuthTokens
+	properties.baseURLPath = Cli.BasePath
+	properties.captureDir = Cli.CaptureDir
 	properties.chunkSize = Cli.Chunk
+	properties.coalesceReads = Cli.CoalesceReads
+	properties.denyNets = Cli.DenyNets
+	properties.exportDests = Cli.ExportDests
+	properties.peerFile = Cli.PeerFile
+	properties.peerRefresh = Cli.PeerRefresh
+	properties.peerSRV = Cli.PeerSRV
+	properties.peers = Cli.Peers
+	properties.fifoMaxBytes = Cli.FIFOMaxBytes
+	properties.fifoTimeout = Cli.FIFOTimeout
+	properties.indexDir = Cli.IndexDir
+	properties.journal = Cli.Journal
+	properties.listCacheTTL = Cli.ListCacheTTL
+	properties.logFile = Cli.LogFile
+	setLogFormat(Cli.LogFormat)
+	SetLogLevel(Cli.LogLevel)
+	setMaxConcurrentReads(Cli.MaxReads)
+	properties.maxLineBytes = Cli.MaxLineBytes
+	properties.maxResponseBytes = Cli.MaxBytes
+	properties.maxResponseLines = Cli.MaxLines
+	properties.mmapThreshold = Cli.MmapThreshold
+	properties.oidcAudience = Cli.OIDCAudience
+	properties.oidcGroupsClaim = Cli.OIDCGroups
+	properties.oidcIssuer = Cli.OIDCIssuer
+	properties.oidcJWKSURL = Cli.OIDCJWKSURL
+	properties.oidcPrincipalClaim = Cli.OIDCPrincipal
+	properties.otlpEndpoint = Cli.OTLPEndpoint
+	properties.otlpHeaders = Cli.OTLPHeaders
 	properties.port = Cli.Port
-	properties.root = Cli.Root
+	properties.quotaFile = Cli.QuotaFile
+	properties.readAhead = Cli.ReadAhead
+	properties.readFIFOs = Cli.ReadFIFOs
+	properties.root = SlashPath(Cli.Root)
+	properties.rotatePolicy = rotate.Policy{
+		Size:     Cli.RotateSize,
+		Age:      Cli.RotateAge,
+		Keep:     Cli.RotateKeep,
+		Compress: Cli.RotateCompress,
+	}
+	properties.s3 = Cli.S3 != ""
+	properties.s3Endpoint = Cli.S3Endpoint
+	properties.s3Region = Cli.S3Region
+	properties.tlsCert = Cli.TLSCert
+	properties.traceRatio = Cli.TraceRatio
+	properties.tlsClientCA = Cli.TLSClientCA
+	properties.tlsKey = Cli.TLSKey
+	properties.tlsReload = Cli.TLSReload
+	properties.ui = Cli.UI
+	properties.upgradeGrace = Cli.UpgradeGrace
+	if err := OpenLogFile(properties.logFile, properties.rotatePolicy); err != nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "*** Log file (%s) cannot be opened: %s\n", Cli.LogFile, err)
+		os.Exit(1)
+	}
 }
 
 func usage() {
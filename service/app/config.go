@@ -0,0 +1,176 @@
+package app
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Configuration from a file and the environment.  Every command line
+// flag (other than -help and -config) can also be given:
+//
+//   - In the environment as VARLOG_ and the flag name in upper case,
+//     with dashes as underscores: -max-concurrent-reads is
+//     VARLOG_MAX_CONCURRENT_READS.
+//   - In the -config file, as "flag-name: value".
+//
+// Precedence is flags, then environment, then file, then defaults.
+// The file is a small subset of YAML: one "key: value" per line,
+// '#' comments, optionally quoted values, and for repeatable flags
+// (such as -deny) either a [a, b] list or a block list of "- value"
+// lines following "key:".  For example:
+//
+//	root: /var/log
+//	addr: 0.0.0.0:8000
+//	log-format: json
+//	deny:
+//	  - auth.log
+//	  - "*.key"
+
+const (
+	envPrefix = "VARLOG_"
+)
+
+// Flags that configuration files and the environment cannot set.
+var configExcluded = map[string]bool{"help": true, "?": true, "config": true}
+
+// applyConfig sets the flags not given on the command line from the
+// environment and then from the configuration file.
+func applyConfig(fileName string) error {
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	var fileValues map[string][]string
+	if fileName != "" {
+		var err error
+		if fileValues, err = loadConfigFile(fileName); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if err != nil || explicit[f.Name] || configExcluded[f.Name] {
+			return
+		}
+		values, ok := []string(nil), false
+		if value, found := os.LookupEnv(envName(f.Name)); found {
+			values, ok = []string{value}, true
+		} else {
+			values, ok = fileValues[f.Name]
+		}
+		if !ok {
+			return
+		}
+		for _, value := range values {
+			if e := f.Value.Set(value); e != nil {
+				err = errors.New(fmt.Sprintf("%s: invalid value %q, %s", f.Name, value, e))
+				return
+			}
+		}
+	})
+	return err
+}
+
+// envName gives the environment variable for a flag.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// loadConfigFile reads the configuration file: flag name => values.
+// Keys may use underscores for dashes.  Unknown keys are errors,
+// so a misspelled setting is not silently ignored.
+func loadConfigFile(fileName string) (map[string][]string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := map[string][]string{}
+	key := "" // The key awaiting a block list
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := stripComment(scanner.Text())
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			if key == "" || line[0] != ' ' && line[0] != '\t' && line[0] != '-' {
+				return nil, errors.New(fmt.Sprintf("%s:%d: list item without a key", fileName, lineNumber))
+			}
+			values[key] = append(values[key], unquote(strings.TrimSpace(trimmed[1:])))
+			continue
+		}
+		name, value, found := strings.Cut(trimmed, ":")
+		if !found {
+			return nil, errors.New(fmt.Sprintf("%s:%d: expected 'key: value'", fileName, lineNumber))
+		}
+		name = strings.ReplaceAll(strings.TrimSpace(name), "_", "-")
+		if flag.Lookup(name) == nil || configExcluded[name] {
+			return nil, errors.New(fmt.Sprintf("%s:%d: unknown setting %q", fileName, lineNumber, name))
+		}
+		value = strings.TrimSpace(value)
+		key = ""
+		switch {
+		case value == "":
+			key = name // A block list may follow
+			values[name] = []string{}
+		case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+			for _, item := range strings.Split(value[1:len(value)-1], ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					values[name] = append(values[name], unquote(item))
+				}
+			}
+		default:
+			values[name] = []string{unquote(value)}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// stripComment removes a '#' comment outside quotes.  A '#' starts
+// a comment only at the beginning of the line or after a space,
+// as in YAML, so values such as a#b are kept.
+func stripComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// unquote removes YAML-style quotes from a value, if present.
+func unquote(value string) string {
+	if len(value) >= 2 {
+		switch {
+		case value[0] == '"' && value[len(value)-1] == '"':
+			if s, err := strconv.Unquote(value); err == nil {
+				return s
+			}
+		case value[0] == '\'' && value[len(value)-1] == '\'':
+			return strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+		}
+	}
+	return value
+}
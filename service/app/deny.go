@@ -0,0 +1,113 @@
+package app
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Path deny-list.  Some files under /var/log must never be exposed
+// through an HTTP API, whatever the client's permissions.  Patterns
+// come from -deny flags and a -deny-file (one pattern per line),
+// using path.Match syntax:
+//
+//   - A pattern without a slash matches any path component, at any
+//     depth: "auth.log", "secure*", and "*.key" hide those files
+//     wherever they are, and a matching directory hides its contents.
+//   - A pattern with a slash matches the path relative to the root,
+//     or a directory containing it: "private/*" hides everything
+//     under the top-level private directory.
+//
+// Denied entries are omitted from /list, and /read reports them as
+// not found, so clients cannot learn they exist.
+
+// The loaded deny patterns.  Written once at startup.
+var denyPatterns []string
+
+// loadDenyPatterns validates the patterns from the flags and file.
+func loadDenyPatterns(patterns []string, fileName string) ([]string, error) {
+	var result []string
+	add := func(pattern string, source string) error {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" || pattern[0] == '#' {
+			return nil
+		}
+		pattern, err := cleanPattern(pattern)
+		if err != nil {
+			return errors.New(fmt.Sprintf("%s: %s", source, err))
+		}
+		result = append(result, pattern)
+		return nil
+	}
+	for _, pattern := range patterns {
+		for _, p := range strings.Split(pattern, ",") {
+			if err := add(p, "-deny"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if fileName == "" {
+		return result, nil
+	}
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if err := add(scanner.Text(), fmt.Sprintf("%s:%d", fileName, lineNumber)); err != nil {
+			return nil, err
+		}
+	}
+	return result, scanner.Err()
+}
+
+// cleanPattern checks a pattern, cleaning one with a slash to a path
+// relative to the root.
+func cleanPattern(pattern string) (string, error) {
+	if strings.Contains(pattern, "/") {
+		pattern = strings.Trim(path.Clean(pattern), "/")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return "", errors.New(fmt.Sprintf("bad pattern %q", pattern))
+	}
+	return pattern, nil
+}
+
+// matchPattern reports whether a pattern matches the name, relative
+// to the root: a pattern without a slash, any component of it; one
+// with a slash, it or a directory containing it.
+func matchPattern(pattern string, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		for _, component := range strings.Split(name, "/") {
+			if matched, _ := path.Match(pattern, component); matched {
+				return true
+			}
+		}
+		return false
+	}
+	for prefix := name; prefix != "." && prefix != ""; prefix = path.Dir(prefix) {
+		if matched, _ := path.Match(pattern, prefix); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Denied reports whether the deny-list hides the given name,
+// relative to the root.
+func Denied(name string) bool {
+	if len(denyPatterns) == 0 || name == "" {
+		return false
+	}
+	for _, pattern := range denyPatterns {
+		if matchPattern(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ContentDisposition gives a "Content-Disposition" header value, such
+// as "attachment", naming the file to save.  The name is quoted for
+// the filename parameter, with any characters outside printable ASCII
+// replaced, for older clients.  A name needing replacement also gets
+// a filename* parameter, UTF-8 and percent-encoded per RFC 5987,
+// which clients prefer when they understand it:
+//
+//	attachment; filename="caf_.log"; filename*=UTF-8''caf%C3%A9.log
+func ContentDisposition(disposition string, name string) string {
+	var ascii strings.Builder
+	plain := true
+	for _, c := range name {
+		switch {
+		case c == '"' || c == '\\':
+			ascii.WriteByte('\\')
+			ascii.WriteRune(c)
+		case c < ' ' || c > '~':
+			ascii.WriteByte('_')
+			plain = false
+		default:
+			ascii.WriteRune(c)
+		}
+	}
+	s := fmt.Sprintf("%s; %s=\"%s\"", disposition, HdrFilename, ascii.String())
+	if !plain {
+		s += fmt.Sprintf("; %s*=UTF-8''%s", HdrFilename, encodeRFC5987(name))
+	}
+	return s
+}
+
+// encodeRFC5987 percent-encodes the UTF-8 bytes of s outside the
+// RFC 5987 attr-char set.
+func encodeRFC5987(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			strings.IndexByte("!#$&+-.^_`|~", c) >= 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// validFilename checks a download name from a client: valid UTF-8,
+// printable, and one path element, so clients cannot be steered
+// to save outside their download directory.
+func validFilename(name string) bool {
+	if name == "" {
+		return true
+	}
+	if !utf8.ValidString(name) || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return false
+	}
+	for _, c := range name {
+		if !unicode.IsPrint(c) {
+			return false
+		}
+	}
+	return true
+}
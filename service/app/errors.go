@@ -0,0 +1,181 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"syscall"
+)
+
+// Error codes, reported to clients in the JSON error envelope.
+// Codes are stable identifiers for programs; messages are for people.
+const (
+	CodeInvalidParam     = "invalid_param"
+	CodeNotFound         = "not_found"
+	CodeAccessDenied     = "access_denied"
+	CodeUnauthenticated  = "unauthenticated"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeTooManyRequests  = "too_many_requests"
+	CodeUnavailable      = "unavailable"
+	CodeFileChanged      = "file_changed"
+	CodeRangeUnsatisfied = "range_not_satisfiable"
+	CodeLineTooLong      = "line_too_long"
+	CodeUnsupported      = "unsupported"
+	CodeInternal         = "internal"
+)
+
+// HTTPError is an error carrying the HTTP status and error code
+// to report to the client.  Handlers return or pass these to
+// WriteError, rather than choosing a status at each call site.
+type HTTPError struct {
+	Status  int    // HTTP status code
+	Code    string // One of the Code constants
+	Message string // Human-readable description
+	Param   string // The offending query parameter, if any
+
+	// For 416 Range Not Satisfiable, the size of the file, given
+	// to the client in Content-Range.
+	Size int64
+
+	// Each error, when a request has several invalid parameters.
+	// Param and Message then describe them together.
+	Causes []*HTTPError
+}
+
+func (e *HTTPError) Error() string {
+	return e.Message
+}
+
+// NewHTTPError creates an error with the given status and code.
+func NewHTTPError(status int, code string, message string) *HTTPError {
+	return &HTTPError{Status: status, Code: code, Message: message}
+}
+
+// ParamError creates an error for an invalid query parameter.
+func ParamError(param string, message string) *HTTPError {
+	return &HTTPError{
+		Status:  http.StatusBadRequest,
+		Code:    CodeInvalidParam,
+		Message: message,
+		Param:   param,
+	}
+}
+
+// RangeError creates an error for a parameter selecting a range
+// beyond the end of a file of the given size: 416 Range Not
+// Satisfiable, with Content-Range giving the size.
+func RangeError(param string, message string, size int64) *HTTPError {
+	return &HTTPError{
+		Status:  http.StatusRequestedRangeNotSatisfiable,
+		Code:    CodeRangeUnsatisfied,
+		Message: message,
+		Param:   param,
+		Size:    size,
+	}
+}
+
+// FileError maps a file system error for the named path to the
+// status the client should see: 404 for a missing file, 403 for
+// a permission problem, and 500 for anything else.
+// The name is the client's name for the path, not the rooted path,
+// so responses do not reveal the server's directories.
+func FileError(name string, err error) *HTTPError {
+	switch {
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, syscall.ENOTDIR):
+		return NewHTTPError(http.StatusNotFound, CodeNotFound,
+			fmt.Sprintf("Path %q not found", name))
+	case errors.Is(err, fs.ErrPermission):
+		return NewHTTPError(http.StatusForbidden, CodeAccessDenied,
+			fmt.Sprintf("Path %q access denied", name))
+	}
+	return NewHTTPError(http.StatusInternalServerError, CodeInternal,
+		fmt.Sprintf("Path %q unavailable", name))
+}
+
+// codeForStatus gives the default error code for an HTTP status.
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalidParam
+	case http.StatusUnauthorized:
+		return CodeUnauthenticated
+	case http.StatusForbidden:
+		return CodeAccessDenied
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusRequestedRangeNotSatisfiable:
+		return CodeRangeUnsatisfied
+	case http.StatusTooManyRequests:
+		return CodeTooManyRequests
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	}
+	return CodeInternal
+}
+
+// errorEnvelope is the JSON body of every error response:
+//
+//	{"error": {"code": "invalid_param", "message": "...", "param": "count"}}
+type errorEnvelope struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	Param     string       `json:"param,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []errorCause `json:"errors,omitempty"`
+}
+
+// One of several errors, such as invalid parameters:
+//
+//	{"error": {"code": "invalid_param", "message": "2 invalid parameters: ...",
+//	  "param": "count", "errors": [{"code": ..., "message": ..., "param": "count"}, ...]}}
+type errorCause struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Param   string `json:"param,omitempty"`
+}
+
+// WriteError replies to the request with the JSON error envelope.
+// An HTTPError supplies its own status and code; any other error
+// is reported as an internal error.  The envelope includes the
+// request ID, if any, so clients can quote it when reporting problems.
+func WriteError(writer http.ResponseWriter, request *http.Request, err error) {
+	var e *HTTPError
+	if !errors.As(err, &e) {
+		e = NewHTTPError(http.StatusInternalServerError, CodeInternal, err.Error())
+	}
+	body := errorBody{
+		Code:      e.Code,
+		Message:   e.Message,
+		Param:     e.Param,
+		RequestID: RequestID(request.Context()),
+	}
+	for _, cause := range e.Causes {
+		body.Errors = append(body.Errors, errorCause{Code: cause.Code, Message: cause.Message, Param: cause.Param})
+	}
+	b, _ := json.Marshal(errorEnvelope{body})
+	header := writer.Header()
+	// As http.Error does, drop headers meant for a successful body.
+	header.Del("Content-Length")
+	header.Del(HdrContentDisposition)
+	header.Set("Content-Type", "application/json; charset=utf-8")
+	header.Set("X-Content-Type-Options", "nosniff")
+	if e.Status == http.StatusRequestedRangeNotSatisfiable {
+		header.Set("Content-Range", fmt.Sprintf("bytes */%d", e.Size))
+	}
+	writer.WriteHeader(e.Status)
+	writer.Write(append(b, '\n'))
+}
+
+// Error replies to the request with a message and status,
+// in the JSON error envelope with the status's default code.
+func Error(writer http.ResponseWriter, request *http.Request, message string, status int) {
+	WriteError(writer, request, NewHTTPError(status, codeForStatus(status), message))
+}
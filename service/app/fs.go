@@ -0,0 +1,101 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// The endpoints read files through an fs.FS held in the properties,
+// rather than calling os functions directly.  Tests can serve from
+// memory (testing/fstest.MapFS), and embedders can substitute other
+// storage, without changing endpoint logic.
+//
+// Endpoints work with rooted paths, as from RootedPath.  The helpers
+// here convert those to fs.FS names, which have no leading slash.
+//
+// Rooted paths always use forward slashes, as do names on the wire.
+// On Windows, a rooted path starts with the volume: C:\inetpub\logs is
+// /C:/inetpub/logs.  SlashPath and OSPath convert host paths, such as
+// -root and -mount, to rooted paths and back.
+
+// OSFileSystem is the default file system: the host's own.
+var OSFileSystem fs.FS = osRootFS()
+
+// SlashPath converts a host path to a rooted path: cleaned, with
+// forward slashes, and on Windows with the volume after a leading slash.
+// Paths already in that form, as in other file systems, are cleaned.
+func SlashPath(name string) string {
+	if filepath.VolumeName(name) != "" {
+		name = "/" + name
+	}
+	return path.Clean(filepath.ToSlash(name))
+}
+
+// OSPath converts a rooted path to a host path, for the few
+// operations that bypass the file system, such as named pipes.
+func OSPath(rooted string) string {
+	if len(rooted) > 1 && rooted[0] == '/' && filepath.VolumeName(filepath.FromSlash(rooted[1:])) != "" {
+		rooted = rooted[1:]
+	}
+	return filepath.FromSlash(rooted)
+}
+
+// File is an open file supporting random access, as the reverser needs.
+type File interface {
+	fs.File
+	io.ReaderAt
+}
+
+// FileSystem gives the file system the endpoints read.
+func (p *Properties) FileSystem() fs.FS {
+	return p.fileSystem
+}
+
+// SetFileSystem sets the file system the endpoints read.
+// Roots and mounts are paths within it.
+func (p *Properties) SetFileSystem(fsys fs.FS) {
+	p.fileSystem = fsys
+}
+
+// fsName converts a rooted path to an fs.FS name.
+func fsName(rooted string) string {
+	name := strings.Trim(rooted, "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// Stat describes the file at a rooted path.
+func Stat(fsys fs.FS, rooted string) (fs.FileInfo, error) {
+	return fs.Stat(fsys, fsName(rooted))
+}
+
+// ReadDir reads the directory at a rooted path, sorted by name.
+func ReadDir(fsys fs.FS, rooted string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(fsys, fsName(rooted))
+}
+
+// Open opens the file at a rooted path for random access.
+// File systems whose files lack ReadAt cannot serve /read.
+func Open(fsys fs.FS, rooted string) (File, error) {
+	file, err := fsys.Open(fsName(rooted))
+	if err != nil {
+		return nil, err
+	}
+	f, ok := file.(File)
+	if !ok {
+		file.Close()
+		return nil, &fs.PathError{
+			Op:   "open",
+			Path: rooted,
+			Err:  errors.New(fmt.Sprintf("%T does not support ReadAt", file)),
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package app
+
+import (
+	"io/fs"
+	"os"
+)
+
+const (
+	// Root of the file tree to be served by the application.
+	defaultPathRoot = "/var/log" // Standard root of file tree
+)
+
+// osRootFS gives the host's file system, rooted at "/".
+func osRootFS() fs.FS {
+	return os.DirFS("/")
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+
+package app
+
+import "testing"
+
+func TestSlashPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		rooted string
+	}{
+		{"/var/log", "/var/log"},
+		{"/var//log/", "/var/log"},
+		{"/var/log/../tmp", "/var/tmp"},
+		{`/srv/a\b`, `/srv/a\b`},
+	}
+	for _, test := range tests {
+		if rooted := SlashPath(test.name); rooted != test.rooted {
+			t.Errorf("SlashPath(%q): expected %q, got %q", test.name, test.rooted, rooted)
+		}
+		if name := OSPath(test.rooted); name != test.rooted {
+			t.Errorf("OSPath(%q): expected %q, got %q", test.rooted, test.rooted, name)
+		}
+	}
+}
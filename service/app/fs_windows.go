@@ -0,0 +1,37 @@
+package app
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// Root of the file tree to be served by the application:
+	// IIS and many Windows services write their logs under it.
+	defaultPathRoot = `C:\inetpub\logs`
+)
+
+// osRootFS gives the host's file systems, with each volume as the
+// first name component: "C:/inetpub/logs" is C:\inetpub\logs.
+func osRootFS() fs.FS {
+	return volumeFS{}
+}
+
+// The file systems of all volumes, by drive letter.
+type volumeFS struct{}
+
+func (volumeFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	osName := filepath.FromSlash(name)
+	if filepath.VolumeName(osName) == "" {
+		// There is no directory of volumes.
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if len(osName) == 2 {
+		osName += `\` // The volume's root, not its current directory
+	}
+	return os.Open(osName)
+}
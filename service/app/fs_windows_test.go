@@ -0,0 +1,42 @@
+package app
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestSlashPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		rooted string
+	}{
+		{`C:\inetpub\logs`, "/C:/inetpub/logs"},
+		{`C:\inetpub\logs\`, "/C:/inetpub/logs"},
+		{`D:/app\logs\..\data`, "/D:/app/data"},
+		{"/bucket/prefix", "/bucket/prefix"},
+	}
+	for _, test := range tests {
+		if rooted := SlashPath(test.name); rooted != test.rooted {
+			t.Errorf("SlashPath(%q): expected %q, got %q", test.name, test.rooted, rooted)
+		}
+	}
+	if name := OSPath("/C:/inetpub/logs/W3SVC1"); name != `C:\inetpub\logs\W3SVC1` {
+		t.Errorf("OSPath: expected a volume path, got %q", name)
+	}
+}
+
+// Clients may name files with either separator.
+func TestSetParamName_backslash(t *testing.T) {
+	props := DefaultProperties()
+	props.SetFileSystem(fstest.MapFS{})
+	props.SetRoot(`C:\inetpub\logs`)
+	if err := props.SetParamName(`LogFiles\W3SVC1\u_ex.log`); err != nil {
+		t.Fatal(err)
+	}
+	if props.RootedPath() != "/C:/inetpub/logs/LogFiles/W3SVC1/u_ex.log" || props.RelativePath() != "LogFiles/W3SVC1/u_ex.log" {
+		t.Errorf("expected forward slashes, got %q %q", props.RootedPath(), props.RelativePath())
+	}
+	if err := props.SetParamName(`..\..\Windows\win.ini`); err == nil {
+		t.Errorf("expected an error for a name outside the root")
+	}
+}
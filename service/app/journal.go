@@ -0,0 +1,70 @@
+package app
+
+import (
+	"strconv"
+)
+
+// Validation of the /journal parameters.  Values are passed to
+// journalctl, so each is limited to what journalctl accepts for it,
+// and none can be taken as an option.
+
+// Journal indicates whether the service serves the systemd journal.
+func (p *Properties) Journal() bool {
+	return p.journal
+}
+
+// SetJournal enables or disables the /journal endpoint.
+func (p *Properties) SetJournal(enable bool) {
+	p.journal = enable
+}
+
+// Syslog priorities by name, as journalctl accepts them.
+var journalPriorities = map[string]bool{
+	"emerg": true, "alert": true, "crit": true, "err": true,
+	"warning": true, "notice": true, "info": true, "debug": true,
+}
+
+// validBoot accepts a boot offset (0 for the current boot,
+// -1 for the previous) or a 32-digit hex boot ID.
+func validBoot(s string) bool {
+	if _, err := strconv.Atoi(s); err == nil {
+		return true
+	}
+	if len(s) != 32 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// validPriority accepts a priority name or number, 0 (emerg)
+// through 7 (debug).
+func validPriority(s string) bool {
+	if n, err := strconv.Atoi(s); err == nil {
+		return 0 <= n && n <= 7
+	}
+	return journalPriorities[s]
+}
+
+// validUnit accepts systemd unit names: letters, digits, and
+// ":-_.\@", not starting with "-".
+func validUnit(s string) bool {
+	if s == "" || s[0] == '-' || len(s) > 256 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == ':', c == '-', c == '_', c == '.', c == '\\', c == '@':
+		default:
+			return false
+		}
+	}
+	return true
+}
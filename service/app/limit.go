@@ -0,0 +1,74 @@
+package app
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+const (
+	// Suggested client delay when the service is saturated.
+	// Sent in the Retry-After header of 429 responses.
+	RetryAfter = 2 * time.Second
+)
+
+// Slots for expensive operations, such as /read, that scan file contents.
+// A nil channel means no limit.  Parallel multi-gigabyte reads can
+// exhaust disk bandwidth and memory, so a server-wide cap protects
+// the host (and the other requests).
+var readSlots chan struct{}
+
+// setMaxConcurrentReads establishes the server-wide limit on expensive
+// reads.  Zero or negative means no limit.
+func setMaxConcurrentReads(n int) {
+	if n <= 0 {
+		readSlots = nil
+		return
+	}
+	readSlots = make(chan struct{}, n)
+}
+
+// AcquireRead reserves a slot for an expensive read operation without
+// waiting.  Returns false if all slots are busy; the caller should reject
+// the request (429 Too Many Requests).  On true, the caller must call
+// ReleaseRead when the operation finishes.
+func AcquireRead() bool {
+	if readSlots == nil {
+		return true
+	}
+	select {
+	case readSlots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// WaitRead reserves a slot for an expensive read operation, waiting
+// for one, as background jobs do rather than being rejected.  Returns
+// the context's error if it ends first.  On nil, the caller must call
+// ReleaseRead when the operation finishes.
+func WaitRead(ctx context.Context) error {
+	if readSlots == nil {
+		return nil
+	}
+	select {
+	case readSlots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ReleaseRead returns a slot reserved by AcquireRead or WaitRead.
+func ReleaseRead() {
+	if readSlots == nil {
+		return
+	}
+	<-readSlots
+}
+
+// RetryAfterSeconds gives the Retry-After header value.
+func RetryAfterSeconds() string {
+	return strconv.Itoa(int(RetryAfter / time.Second))
+}
@@ -0,0 +1,258 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"varlog/service/rotate"
+)
+
+// Log formats, selected by -log-format.
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+var (
+	logFormat = LogFormatText
+	logMutex  sync.Mutex     // Serializes JSON output lines
+	logFile   *rotate.Writer // From -log-file, nil for stderr
+
+	// The minimum severity logged, as an index in logLevels.
+	// Changed at runtime by SetLogLevel, so atomic.
+	logThreshold atomic.Int32
+)
+
+// Log levels in increasing severity.
+var logLevels = []string{LogDebug, LogInfo, LogWarning, LogError}
+
+func init() {
+	logThreshold.Store(int32(severity(LogInfo)))
+}
+
+// severity gives the index of the level in logLevels, or -1.
+// Matching ignores case, and "WARN" is accepted for "WARNING".
+func severity(level string) int {
+	level = strings.ToUpper(level)
+	if level == "WARN" {
+		level = LogWarning
+	}
+	for i, l := range logLevels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// LogLevel gives the minimum level logged: DEBUG, INFO, WARNING, or ERROR.
+func LogLevel() string {
+	return logLevels[logThreshold.Load()]
+}
+
+// SetLogLevel changes the minimum level logged.  Entries at lower
+// levels are discarded.  Returns an error for an unknown level.
+func SetLogLevel(level string) error {
+	i := severity(level)
+	if i < 0 {
+		return errors.New(fmt.Sprintf("Invalid log level %q", level))
+	}
+	if int(logThreshold.Swap(int32(i))) != i {
+		logEntry(LogInfo, "log level "+logLevels[i], nil)
+	}
+	return nil
+}
+
+// LogEnabled reports whether entries at the level are logged, so callers
+// can skip preparing expensive debug detail.
+func LogEnabled(level string) bool {
+	return severity(level) >= int(logThreshold.Load())
+}
+
+// Produces a log entry containing the application name (implicit),
+// the log level, and arguments supplied by the caller.
+func Log(level string, format string, args ...interface{}) {
+	if LogEnabled(level) {
+		logEntry(level, fmt.Sprintf(format, args...), nil)
+	}
+}
+
+// Logf produces a log entry with a fixed message and key/value fields,
+// given as alternating keys and values:
+//
+//	app.Logf(app.LogInfo, "access", "status", 200, "bytes", 5120)
+//
+// In text format the fields follow the message as key=value pairs,
+// quoted where needed.  In JSON format they are members of the entry.
+// A trailing key without a value is logged under the key "!BADKEY".
+func Logf(level string, msg string, fields ...interface{}) {
+	if LogEnabled(level) {
+		logEntry(level, msg, fields)
+	}
+}
+
+// OpenLogFile sends log entries to the file, rotated by the policy,
+// instead of stderr, closing any file opened before.  Empty sends
+// them to stderr again.
+func OpenLogFile(name string, policy rotate.Policy) error {
+	var w *rotate.Writer
+	if name != "" {
+		var err error
+		if w, err = rotate.Open(name, 0644, policy); err != nil {
+			return err
+		}
+	}
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	if logFile != nil {
+		logFile.Close()
+	}
+	logFile = w
+	if w == nil {
+		log.SetOutput(os.Stderr)
+	} else {
+		log.SetOutput(w)
+	}
+	return nil
+}
+
+// ReopenLogFile closes and reopens the log file, if any, so
+// logrotate can move it.
+func ReopenLogFile() error {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	if logFile == nil {
+		return nil
+	}
+	return logFile.Reopen()
+}
+
+// setLogFormat selects the output format for log entries.
+func setLogFormat(format string) {
+	logFormat = format
+}
+
+func logEntry(level string, msg string, fields []interface{}) {
+	now := time.Now()
+	text := msg + formatTextFields(fields)
+	if logFormat == LogFormatJSON {
+		line := formatJSON(now, level, msg, fields)
+		logMutex.Lock()
+		log.Writer().Write([]byte(line + "\n"))
+		logMutex.Unlock()
+	} else {
+		log.Printf("%s %s %s\n", Application, level, text)
+	}
+	id := ""
+	fieldPairs(fields, func(key string, value interface{}) {
+		if key == "id" {
+			id = fmt.Sprint(value)
+		}
+	})
+	if id != "" {
+		recentLogs.add(id, fmt.Sprintf("%s %s %s", now.Format(time.RFC3339Nano), level, text))
+	}
+}
+
+// RecentLogs gives the most recent log entries for a request, oldest
+// first, for diagnostic bundles.  Entries concern a request when they
+// carry its ID in an "id" field, as the access log does.  Other
+// requests' entries are left out, since they may name other clients
+// and paths.
+func RecentLogs(id string) []string {
+	if id == "" {
+		return nil
+	}
+	return recentLogs.lines(id)
+}
+
+// fieldPairs walks the alternating keys and values.
+func fieldPairs(fields []interface{}, visit func(key string, value interface{})) {
+	for i := 0; i < len(fields); i += 2 {
+		if i+1 == len(fields) {
+			visit("!BADKEY", fields[i])
+			break
+		}
+		key, ok := fields[i].(string)
+		if !ok {
+			key = fmt.Sprint(fields[i])
+		}
+		visit(key, fields[i+1])
+	}
+}
+
+// fieldValue converts errors and other Stringers to their text,
+// which is more useful in a log than their JSON encoding.
+func fieldValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return value
+}
+
+// formatTextFields gives " key=value ..." for the text format.
+func formatTextFields(fields []interface{}) string {
+	var b strings.Builder
+	fieldPairs(fields, func(key string, value interface{}) {
+		b.WriteString(" ")
+		b.WriteString(key)
+		b.WriteString("=")
+		b.WriteString(quoteIfNeeded(fmt.Sprint(fieldValue(value))))
+	})
+	return b.String()
+}
+
+// quoteIfNeeded quotes text that is empty or would be ambiguous
+// unquoted: spaces, quotes, equal signs, or unprintable characters.
+func quoteIfNeeded(s string) string {
+	if s == "" {
+		return `""`
+	}
+	for _, r := range s {
+		if r == ' ' || r == '"' || r == '=' || !unicode.IsPrint(r) {
+			return fmt.Sprintf("%q", s)
+		}
+	}
+	return s
+}
+
+// formatJSON gives one JSON log entry, with the fixed members first:
+//
+//	{"time":"...","level":"INFO","app":"varlog","msg":"access","status":200}
+func formatJSON(t time.Time, level string, msg string, fields []interface{}) string {
+	var b strings.Builder
+	member := func(key string, value interface{}) {
+		if b.Len() == 0 {
+			b.WriteString("{")
+		} else {
+			b.WriteString(",")
+		}
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(value)
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(value))
+		}
+		b.Write(k)
+		b.WriteString(":")
+		b.Write(v)
+	}
+	member("time", t.Format(time.RFC3339Nano))
+	member("level", level)
+	member("app", Application)
+	member("msg", msg)
+	fieldPairs(fields, func(key string, value interface{}) {
+		member(key, fieldValue(value))
+	})
+	b.WriteString("}")
+	return b.String()
+}
@@ -0,0 +1,25 @@
+package app
+
+import (
+	"sync/atomic"
+)
+
+// Maintenance mode, set through the admin endpoint.  In maintenance
+// mode, health checks report "draining" and new expensive requests
+// are refused with 503 Service Unavailable, while requests already in
+// progress complete normally.  This eases restarts and node drains:
+// the load balancer stops sending traffic, the in-flight streams
+// finish, and the service can then stop cleanly.
+var maintenance atomic.Bool
+
+// Maintenance reports whether the server is in maintenance mode.
+func Maintenance() bool {
+	return maintenance.Load()
+}
+
+// SetMaintenance enters (true) or leaves (false) maintenance mode.
+func SetMaintenance(b bool) {
+	if maintenance.Swap(b) != b {
+		Log(LogInfo, "Maintenance mode %v", b)
+	}
+}
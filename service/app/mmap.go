@@ -0,0 +1,112 @@
+package app
+
+import (
+	"io"
+	"io/fs"
+	"os"
+)
+
+// Memory-mapped files, for -mmap-threshold.  The reverser slices
+// chunks of a mapped file (scan.Slicer) rather than copying them with
+// ReadAt, which speeds repeated reads of very large files, whose pages
+// stay in the page cache.
+//
+// A mapped file that is truncated while being read, as by logrotate's
+// copytruncate, faults on the missing pages.  Readers of mapped files
+// enable debug.SetPanicOnFault and recover; see read.go.
+
+// MmapThreshold gives the smallest file /read maps into memory,
+// 0 if files are never mapped.
+func (p *Properties) MmapThreshold() int64 {
+	return p.mmapThreshold
+}
+
+// SetMmapThreshold sets the smallest file /read maps, 0 for none.
+func (p *Properties) SetMmapThreshold(n int64) {
+	p.mmapThreshold = n
+}
+
+// Map gives a memory-mapped copy of the file if it is a host file of
+// at least minSize bytes and the platform supports mapping.  Otherwise,
+// including when minSize is 0, it gives the file unchanged.  The mapping
+// reflects the file's size when mapped; lines appended later are not
+// seen, and Stat gives the size mapped.  See Restat.
+func Map(file File, minSize int64) File {
+	f, ok := file.(*os.File)
+	if !ok || minSize <= 0 {
+		return file
+	}
+	info, err := f.Stat()
+	if err != nil || info.Size() < minSize || !info.Mode().IsRegular() {
+		return file
+	}
+	data, err := mmap(f, info.Size())
+	if err != nil {
+		Log(LogDebug, "Cannot map %s, reading instead: %s", f.Name(), err)
+		return file
+	}
+	return &mappedFile{data: data, info: info, file: f}
+}
+
+// Restat gives the file's current information.  Stat on a mapped file
+// gives the information when it was mapped, which matches the mapped
+// bytes; Restat asks the underlying file, so it sees the file truncated
+// since, as by logrotate's copytruncate.
+func Restat(file File) (fs.FileInfo, error) {
+	if m, ok := file.(*mappedFile); ok {
+		return m.file.Stat()
+	}
+	return file.Stat()
+}
+
+// mappedFile is a file mapped into memory.
+type mappedFile struct {
+	data   []byte
+	info   fs.FileInfo
+	file   *os.File // Kept open for Restat
+	offset int64    // For Read
+}
+
+func (m *mappedFile) Stat() (fs.FileInfo, error) {
+	return m.info, nil
+}
+
+func (m *mappedFile) Read(p []byte) (int, error) {
+	n, err := m.ReadAt(p, m.offset)
+	m.offset += int64(n)
+	return n, err
+}
+
+func (m *mappedFile) ReadAt(p []byte, offset int64) (int, error) {
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "read", Path: m.info.Name(), Err: fs.ErrInvalid}
+	}
+	if offset >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[offset:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Slice gives the mapped bytes, for the reverser.
+func (m *mappedFile) Slice(offset int64, n int) ([]byte, error) {
+	if offset < 0 || offset+int64(n) > int64(len(m.data)) {
+		return nil, &fs.PathError{Op: "read", Path: m.info.Name(), Err: fs.ErrInvalid}
+	}
+	return m.data[offset : offset+int64(n)], nil
+}
+
+func (m *mappedFile) Close() error {
+	if m.data == nil {
+		return nil
+	}
+	err := munmap(m.data)
+	m.data = nil
+	if e := m.file.Close(); err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,17 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package app
+
+import (
+	"errors"
+	"os"
+)
+
+// Without mmap, files are read with ReadAt.
+func mmap(f *os.File, size int64) ([]byte, error) {
+	return nil, errors.New("mmap not supported")
+}
+
+func munmap(data []byte) error {
+	return nil
+}
@@ -0,0 +1,180 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"varlog/service/sshfs"
+)
+
+// Named roots.  With -mount name=path options, the service presents
+// a virtual tree: the top level lists the mount names, and the first
+// component of a request's name selects the mount.  For example,
+// with -mount system=/var/log -mount app=/srv/myapp/logs,
+// name=app/service.log reads /srv/myapp/logs/service.log.
+// Without mounts, the single -root is the top level.
+
+// The mount name of Docker container logs, with -docker.
+const ContainersMount = "containers"
+
+// A named root directory.  Backends such as Docker add mounts with
+// their own file system and line format.
+type Mount struct {
+	Name   string // Top-level name, without slashes
+	Path   string // Directory, cleaned.  No trailing slash.
+	FS     fs.FS  // File system of Path, nil for the properties' own
+	Format string // Line format of the files, empty for plain text
+}
+
+// parseMounts converts name=path values into mounts, in order.
+func parseMounts(values []string) ([]Mount, error) {
+	var mounts []Mount
+	seen := map[string]bool{}
+	for _, value := range values {
+		name, dir, found := strings.Cut(value, "=")
+		if !found || name == "" || dir == "" {
+			return nil, errors.New(fmt.Sprintf("mount %q not name=path", value))
+		}
+		if strings.Contains(name, "/") || name == "." || name == ".." {
+			return nil, errors.New(fmt.Sprintf("mount name %q invalid", name))
+		}
+		if seen[name] {
+			return nil, errors.New(fmt.Sprintf("mount name %q repeated", name))
+		}
+		seen[name] = true
+		dir = filepath.Clean(dir)
+		if SlashPath(dir) == "/" {
+			return nil, errors.New(fmt.Sprintf("mount %q cannot be /", name))
+		}
+		fileInfo, err := os.Stat(dir)
+		if err != nil || !fileInfo.Mode().IsDir() {
+			return nil, errors.New(fmt.Sprintf("mount %q path (%s) is not a directory", name, dir))
+		}
+		mounts = append(mounts, Mount{Name: name, Path: SlashPath(dir)})
+	}
+	return mounts, nil
+}
+
+// parseSSHHosts converts name=destination:/root values into hosts,
+// in order.  See sshfs.ParseHost.
+func parseSSHHosts(values []string) ([]sshfs.Host, error) {
+	var hosts []sshfs.Host
+	seen := map[string]bool{}
+	for _, value := range values {
+		h, err := sshfs.ParseHost(value)
+		if err != nil {
+			return nil, err
+		}
+		if seen[h.Name] {
+			return nil, errors.New(fmt.Sprintf("host name %q repeated", h.Name))
+		}
+		seen[h.Name] = true
+		hosts = append(hosts, h)
+	}
+	return hosts, nil
+}
+
+// AddMount adds a mount.  With -mount, it is listed among the
+// others.  With a single root, it appears at the top of the root,
+// hiding any entry of the same name there.
+func (p *Properties) AddMount(m Mount) {
+	if len(p.mounts) > 0 {
+		p.mounts = append(p.mounts, m)
+	} else {
+		p.overlays = append(p.overlays, m)
+	}
+}
+
+// Overlays gives the mounts added at the top of a single root.
+func (p *Properties) Overlays() []Mount {
+	return p.overlays
+}
+
+// Format gives the line format of the selected file, empty for
+// plain text.
+func (p *Properties) Format() string {
+	return p.format
+}
+
+// selectMount sets the root to the mount, and the rooted path to
+// the rest of the name within it.
+func (p *Properties) selectMount(m Mount, rest string) {
+	p.mount = m.Name
+	p.root = m.Path
+	p.rootedPath = path.Join(m.Path, rest)
+	p.format = m.Format
+	if m.FS != nil {
+		p.fileSystem = m.FS
+	}
+}
+
+// setOverlayName resolves a name in one of the overlays, returning
+// false if the name is not in any.
+func (p *Properties) setOverlayName(name string) bool {
+	first, rest, _ := strings.Cut(strings.TrimPrefix(path.Clean("/"+name), "/"), "/")
+	for _, m := range p.overlays {
+		if m.Name == first {
+			p.selectMount(m, rest)
+			return true
+		}
+	}
+	return false
+}
+
+// SSHHosts gives the remote hosts served over ssh, or nil.
+// Each host is also a mount of the same name.
+func (p *Properties) SSHHosts() []sshfs.Host {
+	return p.sshHosts
+}
+
+// Mounts gives the named roots, or nil when serving a single root.
+func (p *Properties) Mounts() []Mount {
+	return p.mounts
+}
+
+// Mount gives the name of the mount selected by the request's name,
+// or the empty string without mounts or at the top level.
+func (p *Properties) Mount() string {
+	return p.mount
+}
+
+// MountTop indicates the request names the virtual top level,
+// which lists the mounts rather than a directory.
+func (p *Properties) MountTop() bool {
+	return len(p.mounts) > 0 && p.mount == ""
+}
+
+// setMountedName resolves a name in the virtual tree of mounts,
+// setting the root to the selected mount's directory.
+func (props *Properties) setMountedName(name string) error {
+	// As for a single root, a name must not climb above the top.
+	const top = "/mounts"
+	// Accept mount:/path, as in web1:/nginx/access.log, for mount/path.
+	if mountName, rest, found := strings.Cut(name, ":"); found && !strings.Contains(mountName, "/") {
+		name = mountName + "/" + rest
+	}
+	p := path.Join(top, name)
+	if p != top && !strings.HasPrefix(p, top+"/") {
+		err := errors.New(fmt.Sprintf("Invalid name parameter (%q)", name))
+		Log(LogWarning, "%s", err.Error())
+		return err
+	}
+	if p == top {
+		props.mount, props.root, props.rootedPath = "", "", ""
+		return nil
+	}
+	mountName, rest, _ := strings.Cut(strings.TrimPrefix(p, top+"/"), "/")
+	for _, m := range props.mounts {
+		if m.Name == mountName {
+			props.selectMount(m, rest)
+			return nil
+		}
+	}
+	err := errors.New(fmt.Sprintf("Invalid name parameter (%q), no mount %q", name, mountName))
+	Log(LogWarning, "%s", err.Error())
+	return err
+}
@@ -0,0 +1,46 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Network access lists.  -allow-net and -deny-net list networks in
+// CIDR notation (10.0.0.0/8, fd00::/8) or single addresses, comma
+// separated, and may be repeated.  The server checks each connection's
+// address against them before authentication; see the server package.
+
+// ParseNets parses network access list entries, each a comma-separated
+// list of CIDR networks or addresses.  An address is a network of one.
+func ParseNets(entries []string) ([]netip.Prefix, error) {
+	var nets []netip.Prefix
+	for _, entry := range entries {
+		for _, value := range strings.Split(entry, ",") {
+			value = strings.TrimSpace(value)
+			if value == "" {
+				continue
+			}
+			var prefix netip.Prefix
+			var err error
+			if strings.Contains(value, "/") {
+				prefix, err = netip.ParsePrefix(value)
+			} else {
+				var addr netip.Addr
+				if addr, err = netip.ParseAddr(value); err == nil {
+					prefix = netip.PrefixFrom(addr, addr.BitLen())
+				}
+			}
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("invalid network %q, expected CIDR notation or an address", value))
+			}
+			// Compare IPv4 clients by their IPv4 address.
+			if addr := prefix.Addr(); addr.Is4In6() && prefix.Bits() >= 96 {
+				prefix = netip.PrefixFrom(addr.Unmap(), prefix.Bits()-96)
+			}
+			nets = append(nets, prefix.Masked())
+		}
+	}
+	return nets, nil
+}
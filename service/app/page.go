@@ -0,0 +1,141 @@
+package app
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Pagination, shared by the endpoints giving lists: /list's entries
+// and /read's lines.  Parameter 'page-size=N' asks for pages of N
+// items; the response links the neighboring pages with a Link header
+// (RFC 5988), giving the request's URL with 'page=token':
+//
+//	Link: </list?name=nginx&page=MS4yLjAuMi4w&page-size=2>; rel="next", </list?name=nginx&page-size=2>; rel="first"
+//
+// A token is opaque to clients.  It records where its page starts and
+// where the earlier pages started, so each page links the one before
+// for the last maxPageBack pages.  JSON responses also carry a
+// PageInfo, in an envelope of the endpoint's; see WritePageLinks.
+// Tokens are not signed: a changed token selects other items of the
+// same request, which the client could ask for anyway.
+
+const (
+	// Most items a page.
+	maxPageSize = 10000
+
+	// Earlier pages a token remembers, for its prev link.
+	maxPageBack = 50
+
+	// Version of the token layout.
+	pageTokenVersion = "1"
+)
+
+// A Page selects the items of one page of a list.
+type Page struct {
+	Size  int   // Items a page, 0 when the request is not paginated
+	Bound int64 // Endpoint's bound, fixed by the first page, 0 for none
+	Skip  int64 // Items passed over before the page
+	back  []int64
+}
+
+// PageInfo describes a page in a JSON response.
+type PageInfo struct {
+	Size int    `json:"size"`
+	Next string `json:"next,omitempty"` // Token of the next page, empty for the last
+	Prev string `json:"prev,omitempty"` // Token of the page before, empty for the first, or if forgotten
+}
+
+// Paginated reports whether the request asked for pages.
+func (pg Page) Paginated() bool {
+	return pg.Size > 0
+}
+
+// Next gives the page after this one, which passes over skip items
+// and keeps the bound.
+func (pg Page) Next(skip int64, bound int64) Page {
+	back := append([]int64{pg.Skip}, pg.back...)
+	if len(back) > maxPageBack {
+		back = back[:maxPageBack]
+	}
+	return Page{Size: pg.Size, Bound: bound, Skip: skip, back: back}
+}
+
+// Prev gives the page before this one, if known.
+func (pg Page) Prev() (Page, bool) {
+	if len(pg.back) == 0 {
+		return Page{}, false
+	}
+	return Page{Size: pg.Size, Bound: pg.Bound, Skip: pg.back[0], back: pg.back[1:]}, true
+}
+
+// Token gives the 'page' value selecting the page.
+func (pg Page) Token() string {
+	fields := []string{pageTokenVersion, strconv.Itoa(pg.Size),
+		strconv.FormatInt(pg.Bound, 10), strconv.FormatInt(pg.Skip, 10)}
+	for _, n := range pg.back {
+		fields = append(fields, strconv.FormatInt(n, 10))
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(strings.Join(fields, ".")))
+}
+
+// parsePageToken reverses Token.
+func parsePageToken(token string) (Page, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return Page{}, false
+	}
+	fields := strings.Split(string(b), ".")
+	if len(fields) < 4 || len(fields) > 4+maxPageBack || fields[0] != pageTokenVersion {
+		return Page{}, false
+	}
+	numbers := make([]int64, len(fields)-1)
+	for j, field := range fields[1:] {
+		if numbers[j], err = strconv.ParseInt(field, 10, 64); err != nil || numbers[j] < 0 {
+			return Page{}, false
+		}
+	}
+	if numbers[0] < 1 || numbers[0] > maxPageSize {
+		return Page{}, false
+	}
+	return Page{Size: int(numbers[0]), Bound: numbers[1], Skip: numbers[2], back: numbers[3:]}, true
+}
+
+// ParamPage gives the page requested by the 'page-size' and 'page'
+// parameters.  Without 'page-size', a token keeps its own size.
+func (p *Properties) ParamPage() Page {
+	pg := p.paramPage
+	if p.paramPageSize > 0 {
+		pg.Size = p.paramPageSize
+	}
+	return pg
+}
+
+// WritePageLinks sets the Link header for the page, linking the next
+// one if given, the page before if known, and the first, and gives
+// the same as PageInfo.  Call it before writing the response body.
+func WritePageLinks(writer http.ResponseWriter, request *http.Request, props *Properties, page Page, next *Page) PageInfo {
+	info := PageInfo{Size: page.Size}
+	var links []string
+	link := func(pg *Page, rel string) {
+		query := request.URL.Query()
+		query.Del(ParamPage)
+		if pg != nil {
+			query.Set(ParamPage, pg.Token())
+		}
+		links = append(links, fmt.Sprintf("<%s?%s>; rel=%q", props.BaseURLPath()+request.URL.Path, query.Encode(), rel))
+	}
+	if next != nil {
+		info.Next = next.Token()
+		link(next, "next")
+	}
+	if prev, ok := page.Prev(); ok {
+		info.Prev = prev.Token()
+		link(&prev, "prev")
+	}
+	link(nil, "first")
+	writer.Header().Set("Link", strings.Join(links, ", "))
+	return info
+}
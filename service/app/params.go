@@ -0,0 +1,365 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"varlog/service/scan"
+)
+
+// The request parameters, declared once.  Each Param gives its name,
+// type, the values allowed, and how a valid value updates the request's
+// properties.  ExtractParams checks every parameter of a request
+// against the registry, reporting all the invalid ones together, and
+// Params lists the registry, so documentation and API descriptions
+// come from the same declarations as the checks.
+//
+// Adding a parameter is one entry in paramRegistry, usually made with
+// one of the constructors below: boolParam, intParam, enumParam,
+// stringParam, or timeParam.
+
+// Param types, as in JSON Schema.
+const (
+	TypeBoolean = "boolean"
+	TypeInteger = "integer"
+	TypeString  = "string"
+)
+
+// A Param declares one request parameter.
+type Param struct {
+	Name        string
+	Type        string   // TypeBoolean, TypeInteger, or TypeString
+	Format      string   // Refines the type, as "date-time"; may be empty
+	Values      []string // The values allowed, if only some are
+	Description string
+	Repeated    bool // Every value counts, not just the first
+
+	// parse checks the value and records it in the properties,
+	// or gives the reason it is invalid.  Values outside Values
+	// are refused before parse is called.  For types other than
+	// strings, an empty value leaves the default.
+	parse func(p *Properties, value string) (reason string)
+}
+
+// The registry, by name.
+var paramRegistry = map[string]*Param{}
+
+func registerParams(params ...Param) {
+	for j := range params {
+		paramRegistry[params[j].Name] = &params[j]
+	}
+}
+
+// Params gives the declared parameters, sorted by name.
+func Params() []Param {
+	result := make([]Param, 0, len(paramRegistry))
+	for _, p := range paramRegistry {
+		result = append(result, *p)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// check validates one value of the parameter, updating the properties.
+// Returns nil, or the error to report.
+func (param *Param) check(p *Properties, value string) *HTTPError {
+	if value == "" && param.Type != TypeString {
+		return nil
+	}
+	if len(param.Values) > 0 && !contains(param.Values, value) {
+		return ParamError(param.Name, fmt.Sprintf("Invalid value %s=%q", param.Name, value))
+	}
+	if reason := param.parse(p, value); reason != "" {
+		return ParamError(param.Name, fmt.Sprintf("Invalid value %s=%q, %s", param.Name, value, reason))
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// boolParam declares a parameter taking true or false, and the like.
+func boolParam(name string, description string, field func(p *Properties) *bool) Param {
+	return Param{Name: name, Type: TypeBoolean, Description: description,
+		parse: func(p *Properties, value string) string {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return "expected true or false"
+			}
+			*field(p) = b
+			return ""
+		}}
+}
+
+// intParam declares a parameter taking an integer of at least min.
+func intParam(name string, description string, min int64, set func(p *Properties, n int64)) Param {
+	return Param{Name: name, Type: TypeInteger, Description: description,
+		parse: func(p *Properties, value string) string {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return "expected an integer"
+			}
+			if n < min {
+				return fmt.Sprintf("expected at least %d", min)
+			}
+			set(p, n)
+			return ""
+		}}
+}
+
+// enumParam declares a parameter taking one of the values.
+// The empty string, the default, is always allowed.
+func enumParam(name string, description string, values []string, field func(p *Properties) *string) Param {
+	return Param{Name: name, Type: TypeString, Values: append([]string{""}, values...), Description: description,
+		parse: func(p *Properties, value string) string {
+			*field(p) = value
+			return ""
+		}}
+}
+
+// stringParam declares a parameter taking any string the validator
+// accepts.
+func stringParam(name string, description string, valid func(string) bool, field func(p *Properties) *string) Param {
+	return Param{Name: name, Type: TypeString, Description: description,
+		parse: func(p *Properties, value string) string {
+			if !valid(value) {
+				return "not allowed"
+			}
+			*field(p) = value
+			return ""
+		}}
+}
+
+// timeParam declares a parameter taking a time, as ParseTime does.
+func timeParam(name string, description string, field func(p *Properties) *time.Time) Param {
+	return Param{Name: name, Type: TypeString, Format: "date-time", Description: description,
+		parse: func(p *Properties, value string) string {
+			t, ok := ParseTime(value, time.Now())
+			if !ok {
+				return "expected RFC 3339 or a duration ago"
+			}
+			*field(p) = t
+			return ""
+		}}
+}
+
+// byteRangeParam declares a parameter taking a ByteRange.
+func byteRangeParam(name string, description string, field func(p *Properties) *ByteRange) Param {
+	return Param{Name: name, Type: TypeString, Description: description,
+		parse: func(p *Properties, value string) string {
+			if value == "" {
+				return ""
+			}
+			r, ok := parseByteRange(value)
+			if !ok {
+				return "expected START-END or START-, with END after START"
+			}
+			*field(p) = r
+			return ""
+		}}
+}
+
+// The anchors by each of their names.
+var anchorSynonyms = map[string]string{
+	"":           "",
+	AnchorStart:  AnchorStart,
+	AnchorEnd:    AnchorEnd,
+	AnchorWhole:  AnchorWhole,
+	AnchorPrefix: AnchorStart,
+	AnchorSuffix: AnchorEnd,
+	AnchorExact:  AnchorWhole,
+}
+
+func init() {
+	registerParams(
+		intParam(ParamAroundLine, "Line, from 1, to read with its context instead of the file's end.", 1,
+			func(p *Properties, n int64) { p.paramAroundLine = n }),
+		stringParam(ParamBoot, "Journal boot: an offset such as 0 or -1, or a boot ID.",
+			validBoot, func(p *Properties) *string { return &p.paramBoot }),
+		Param{Name: ParamBucket, Type: TypeString, Description: "Width of /aggregate time buckets, as 1m or 1h.",
+			parse: func(p *Properties, value string) string {
+				if value == "" {
+					return ""
+				}
+				d, err := time.ParseDuration(value)
+				if err != nil {
+					return "expected a duration"
+				}
+				if d < minBucket {
+					return fmt.Sprintf("expected at least %s", minBucket)
+				}
+				p.paramBucket = d
+				return ""
+			}},
+		enumParam(ParamContentDisposition, "Content-Disposition of the response.",
+			[]string{HdrInline, HdrAttachment}, func(p *Properties) *string { return &p.paramContentDisposition }),
+		intParam(ParamContext, fmt.Sprintf("Lines either side of the around-line, up to %d.", maxContext), 0,
+			func(p *Properties, n int64) {
+				if n > maxContext {
+					n = maxContext
+				}
+				p.paramContext = int(n)
+			}),
+		intParam(ParamCount, "Most lines (or records) returned.", -1<<31,
+			func(p *Properties, n int64) { p.paramCount = int(n) }),
+		enumParam(ParamCountUnit, "What count caps, with multiline.",
+			[]string{CountUnitLine, CountUnitRecord}, func(p *Properties) *string { return &p.paramCountUnit }),
+		stringParam(ParamDest, "Destination /export uploads to, by name.",
+			validDest, func(p *Properties) *string { return &p.paramDest }),
+		boolParam(ParamDryRun, "Report what a deletion or truncation would do, without it.",
+			func(p *Properties) *bool { return &p.paramDryRun }),
+		boolParam(ParamDedupe, "Collapse runs of identical lines.",
+			func(p *Properties) *bool { return &p.paramDedupe }),
+		boolParam(ParamDedupeIgnoreTime, "Collapse runs of lines differing only in leading timestamps.",
+			func(p *Properties) *bool { return &p.paramDedupeIgnoreTime }),
+		enumParam(ParamEncoding, "Encoding of the file, converted to UTF-8; detected by default.",
+			[]string{EncodingUTF8, EncodingUTF16LE, EncodingUTF16BE, EncodingLatin1, EncodingWindows1252},
+			func(p *Properties) *string { return &p.paramEncoding }),
+		Param{Name: ParamExtract, Type: TypeString, Description: "Fields selected from each line.",
+			parse: func(p *Properties, value string) string {
+				extract, err := scan.ParseExtract(value)
+				if err != nil {
+					return err.Error()
+				}
+				p.extract = extract
+				return ""
+			}},
+		stringParam(ParamFilename, "Name for saving the response.",
+			validFilename, func(p *Properties) *string { return &p.paramFilename }),
+		Param{Name: ParamFilter, Type: TypeString, Repeated: true,
+			Description: "Text lines must contain; a leading - omits them.  Lines must pass every filter given.",
+			parse: func(p *Properties, value string) string {
+				p.AddFilter(value)
+				return ""
+			}},
+		Param{Name: ParamFilterAnchor, Type: TypeString,
+			Values:      []string{"", AnchorStart, AnchorEnd, AnchorWhole, AnchorPrefix, AnchorSuffix, AnchorExact},
+			Description: "Where the filters must match; prefix, suffix, and exact name start, end, and whole.",
+			parse: func(p *Properties, value string) string {
+				p.SetFilterAnchor(anchorSynonyms[value])
+				return ""
+			}},
+		boolParam(ParamFollow, "Stream lines as they are appended.",
+			func(p *Properties) *bool { return &p.paramFollow }),
+		enumParam(ParamFormat, "Format of the response; by default the Accept header chooses.",
+			[]string{FormatHexdump, FormatJSON, FormatNDJSON, FormatText}, func(p *Properties) *string { return &p.paramFormat }),
+		intParam(ParamLength, "Bytes of a hexdump.", 0,
+			func(p *Properties, n int64) { p.paramLength = n }),
+		enumParam(ParamMode, "What /read writes.",
+			[]string{ModeChecksum, ModeCount, ModeLines}, func(p *Properties) *string { return &p.paramMode }),
+		boolParam(ParamMultiline, "Group continuation lines into records.",
+			func(p *Properties) *bool { return &p.paramMultiline }),
+		Param{Name: ParamName, Type: TypeString, Description: "Path of a file or directory under the root.",
+			parse: func(p *Properties, value string) string {
+				if err := p.SetParamName(value); err != nil {
+					return err.Error()
+				}
+				return ""
+			}},
+		intParam(ParamOffset, "Start of a hexdump; negative from the end.", -1<<63,
+			func(p *Properties, n int64) { p.paramOffset = n }),
+		Param{Name: ParamPage, Type: TypeString, Description: "Token of a page, from a Link header or page info.",
+			parse: func(p *Properties, value string) string {
+				if value == "" {
+					return ""
+				}
+				pg, ok := parsePageToken(value)
+				if !ok {
+					return "not a page token"
+				}
+				p.paramPage = pg
+				return ""
+			}},
+		intParam(ParamPageSize, fmt.Sprintf("Items a page, up to %d; links the pages.", maxPageSize), 1,
+			func(p *Properties, n int64) {
+				if n > maxPageSize {
+					n = maxPageSize
+				}
+				p.paramPageSize = int(n)
+			}),
+		boolParam(ParamPretty, "Indent /list's JSON.",
+			func(p *Properties) *bool { return &p.paramPretty }),
+		stringParam(ParamPriority, "Journal priority: a syslog level name or number.",
+			validPriority, func(p *Properties) *string { return &p.paramPriority }),
+		byteRangeParam(ParamRange, "Bytes of the file /diff compares, as START-END or START-.",
+			func(p *Properties) *ByteRange { return &p.paramRange }),
+		Param{Name: ParamSanitize, Type: TypeBoolean, Description: "Escape control characters; false sends lines as they are.",
+			parse: func(p *Properties, value string) string {
+				sanitize, err := strconv.ParseBool(value)
+				if err != nil {
+					return "expected true or false"
+				}
+				p.paramRaw = !sanitize
+				return ""
+			}},
+		timeParam(ParamSince, "Earliest line time.",
+			func(p *Properties) *time.Time { return &p.paramSince }),
+		enumParam(ParamTimestamp, "Form for leading timestamps.",
+			[]string{TimestampLocal, TimestampUnix, TimestampUTC}, func(p *Properties) *string { return &p.paramTimestamp }),
+		enumParam(ParamTruncate, "What happens to lines longer than -max-line-bytes.",
+			[]string{TruncateMarker, TruncateDrop, TruncateError}, func(p *Properties) *string { return &p.paramTruncate }),
+		stringParam(ParamUnit, "Journal systemd unit.",
+			validUnit, func(p *Properties) *string { return &p.paramUnit }),
+		timeParam(ParamUntil, "Latest line time.",
+			func(p *Properties) *time.Time { return &p.paramUntil }),
+		stringParam(ParamWith, "File /diff compares the named one with; by default the same file.",
+			func(string) bool { return true }, func(p *Properties) *string { return &p.paramWith }),
+		byteRangeParam(ParamWithRange, "Bytes of the 'with' file /diff compares, as START-END or START-.",
+			func(p *Properties) *ByteRange { return &p.paramWithRange }),
+	)
+}
+
+// extractParams checks the request's parameters against the registry.
+// The first value of each is used, or every value, for those repeated.
+// Returns nil, the one error, or an error listing all of them.
+func (p *Properties) extractParams(request *http.Request) error {
+	keys := make([]string, 0, len(request.Form))
+	for key := range request.Form {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var errs []*HTTPError
+	for _, key := range keys {
+		values := request.Form[key]
+		param, ok := paramRegistry[key]
+		switch {
+		case !ok:
+			// Treat unknown keys as a client error.
+			errs = append(errs, ParamError(key, fmt.Sprintf("Parameter %q invalid", key)))
+		case len(values) > 0:
+			if !param.Repeated {
+				values = values[:1]
+			}
+			for _, value := range values {
+				if err := param.check(p, value); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+	for _, err := range errs {
+		Log(LogWarning, "%s", err.Message)
+	}
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	messages := make([]string, len(errs))
+	for j, err := range errs {
+		messages[j] = err.Message
+	}
+	err := ParamError(errs[0].Param, fmt.Sprintf("%d invalid parameters: %s", len(errs), strings.Join(messages, "; ")))
+	err.Causes = errs
+	return err
+}
@@ -0,0 +1,142 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Saved queries.  A query names a request to a reading endpoint, so
+// teams can share one such as "show nginx 5xx" by name rather than by
+// a long URL.  -query options define them as name=target:
+//
+//	-query 'nginx-5xx=/read?name=nginx/access.log&filter=%20500'
+//
+// and clients can define more through /admin/queries.  GET /query/NAME
+// serves the target, with the request's own parameters replacing the
+// query's of the same name, so a client can change the count.
+
+// The endpoints a query may name.
+var queryEndpoints = map[string]bool{
+	"/aggregate": true,
+	"/journal":   true,
+	"/list":      true,
+	"/read":      true,
+	"/top":       true,
+}
+
+// Names of queries and /export destinations: letters, digits, '.',
+// '_', and '-', as in URL paths.
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// A saved query.
+type Query struct {
+	Name   string `json:"name"`
+	Target string `json:"target"` // Endpoint and parameters, as /read?name=app.log
+}
+
+// NewQuery checks a query's name and target.
+func NewQuery(name string, target string) (Query, error) {
+	if !namePattern.MatchString(name) {
+		return Query{}, errors.New(fmt.Sprintf("query name %q invalid", name))
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.IsAbs() || !queryEndpoints[u.Path] {
+		return Query{}, errors.New(fmt.Sprintf("query %q target %q not a reading endpoint, such as /read?name=app.log", name, target))
+	}
+	if _, err := url.ParseQuery(u.RawQuery); err != nil {
+		return Query{}, errors.New(fmt.Sprintf("query %q parameters invalid, %s", name, err))
+	}
+	return Query{Name: name, Target: target}, nil
+}
+
+// validDest accepts /export destination names, or empty for none.
+func validDest(name string) bool {
+	return name == "" || namePattern.MatchString(name)
+}
+
+// Endpoint gives the path of the query's endpoint.
+func (q Query) Endpoint() string {
+	path, _, _ := strings.Cut(q.Target, "?")
+	return path
+}
+
+// Params gives the query's parameters, with the request parameters
+// replacing those of the same name.
+func (q Query) Params(request url.Values) url.Values {
+	_, query, _ := strings.Cut(q.Target, "?")
+	params, _ := url.ParseQuery(query)
+	for key, values := range request {
+		params[key] = values
+	}
+	return params
+}
+
+// parseQueries converts name=target values into queries, sorted by name.
+func parseQueries(values []string) ([]Query, error) {
+	var queries []Query
+	seen := map[string]bool{}
+	for _, value := range values {
+		name, target, found := strings.Cut(value, "=")
+		if !found {
+			return nil, errors.New(fmt.Sprintf("query %q not name=target", value))
+		}
+		q, err := NewQuery(name, target)
+		if err != nil {
+			return nil, err
+		}
+		if seen[name] {
+			return nil, errors.New(fmt.Sprintf("query name %q repeated", name))
+		}
+		seen[name] = true
+		queries = append(queries, q)
+	}
+	sort.Slice(queries, func(i, j int) bool { return queries[i].Name < queries[j].Name })
+	return queries, nil
+}
+
+// Queries gives the saved queries, sorted by name.
+func (p *Properties) Queries() []Query {
+	return p.queries
+}
+
+// Query gives the saved query of the name.
+func (p *Properties) Query(name string) (Query, bool) {
+	j := sort.Search(len(p.queries), func(j int) bool { return p.queries[j].Name >= name })
+	if j < len(p.queries) && p.queries[j].Name == name {
+		return p.queries[j], true
+	}
+	return Query{}, false
+}
+
+// SetQuery saves a query, replacing any of the same name.
+func (p *Properties) SetQuery(q Query) {
+	queries := make([]Query, 0, len(p.queries)+1)
+	for _, saved := range p.queries {
+		if saved.Name != q.Name {
+			queries = append(queries, saved)
+		}
+	}
+	queries = append(queries, q)
+	sort.Slice(queries, func(i, j int) bool { return queries[i].Name < queries[j].Name })
+	p.queries = queries
+}
+
+// DeleteQuery removes the saved query of the name, reporting whether
+// there was one.
+func (p *Properties) DeleteQuery(name string) bool {
+	if _, ok := p.Query(name); !ok {
+		return false
+	}
+	queries := make([]Query, 0, len(p.queries)-1)
+	for _, saved := range p.queries {
+		if saved.Name != name {
+			queries = append(queries, saved)
+		}
+	}
+	p.queries = queries
+	return true
+}
@@ -0,0 +1,55 @@
+package app
+
+import (
+	"net/http"
+)
+
+// ResponseRecorder wraps a ResponseWriter for middleware that reports
+// on the response: the access log, statistics, spans, the audit trail,
+// and quotas.  It records the status and the body bytes written, and
+// passes Flush through, so streamed responses still stream.
+type ResponseRecorder struct {
+	http.ResponseWriter
+	Status int   // The status sent, http.StatusOK if not set
+	Bytes  int64 // Body bytes written
+
+	// Called after each write with the bytes written, if not nil,
+	// for counting a long streamed response as it goes.
+	OnWrite func(n int)
+
+	wroteHeader bool
+}
+
+// NewResponseRecorder wraps the writer.
+func NewResponseRecorder(writer http.ResponseWriter) *ResponseRecorder {
+	return &ResponseRecorder{ResponseWriter: writer, Status: http.StatusOK}
+}
+
+func (r *ResponseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.Status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *ResponseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.Bytes += int64(n)
+	if r.OnWrite != nil {
+		r.OnWrite(n)
+	}
+	return n, err
+}
+
+func (r *ResponseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap gives the wrapped writer, for http.ResponseController.
+func (r *ResponseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"varlog/service/redact"
+)
+
+// Redaction.  -redact options apply line processors, such as the
+// built-in card, email, and bearer redactors, to the files under a
+// path prefix, as prefix=processor:
+//
+//	-redact payments=card -redact payments=email -redact '*=bearer'
+//
+// A prefix matches the path relative to the root, or a directory
+// containing it, so "payments" covers payments/api.log; "*" covers
+// every file, and the journal.  A file gets the processors of every
+// rule matching it, in the order given.  See package redact.
+
+// A redaction rule.
+type redactRule struct {
+	prefix    string // Path prefix, or "*" for all
+	processor redact.Processor
+}
+
+// parseRedactRules converts prefix=processor values into rules.
+func parseRedactRules(values []string) ([]redactRule, error) {
+	var rules []redactRule
+	for _, value := range values {
+		prefix, spec, found := strings.Cut(value, "=")
+		if !found || spec == "" {
+			return nil, errors.New(fmt.Sprintf("redact %q not prefix=processor", value))
+		}
+		p, err := redact.Lookup(spec)
+		if err != nil {
+			return nil, err
+		}
+		if prefix = strings.TrimSpace(prefix); prefix != "*" {
+			prefix = strings.Trim(path.Clean("/"+prefix), "/")
+		}
+		rules = append(rules, redactRule{prefix: prefix, processor: p})
+	}
+	return rules, nil
+}
+
+// matches reports whether the rule applies to the name.
+func (r redactRule) matches(name string) bool {
+	return r.prefix == "*" || r.prefix == "" || name == r.prefix || strings.HasPrefix(name, r.prefix+"/")
+}
+
+// Redacted reports whether any rule applies to the request's file, so
+// its bytes may not be sent as they are, as by a hexdump.
+func (p *Properties) Redacted() bool {
+	name := p.RelativePath()
+	for _, r := range p.redactRules {
+		if r.matches(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact applies the processors of the rules for the request's file
+// to a line.
+func (p *Properties) Redact(s string) string {
+	if len(p.redactRules) == 0 {
+		return s
+	}
+	name := p.RelativePath()
+	for _, r := range p.redactRules {
+		if r.matches(name) {
+			s = r.processor.Process(s)
+		}
+	}
+	return s
+}
+
+// SetRedact sets the rules from prefix=processor values, as -redact
+// options do.
+func (p *Properties) SetRedact(values []string) error {
+	rules, err := parseRedactRules(values)
+	if err != nil {
+		return err
+	}
+	p.redactRules = rules
+	return nil
+}
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+const (
+	// Header carrying the request ID, both in requests (from a proxy
+	// or client that assigned one) and in responses.
+	HdrRequestID = "X-Request-ID"
+
+	// Longest incoming request ID honored.  Longer IDs are replaced.
+	maxRequestIDLength = 128
+)
+
+// Keys of the values this package records in request contexts.
+// The type is unexported, so other packages' keys cannot collide.
+type contextKey int
+
+const (
+	requestIDKey  contextKey = 1
+	propertiesKey contextKey = 2
+)
+
+// WithProperties records the server's properties in a context,
+// so handlers serve with their own server's configuration.
+func WithProperties(ctx context.Context, p *Properties) context.Context {
+	return context.WithValue(ctx, propertiesKey, p)
+}
+
+// RequestProperties allocates the properties for handling a request:
+// a copy of the server's properties recorded in the request context,
+// or of the global properties if there are none.
+func RequestProperties(request *http.Request) *Properties {
+	if p, ok := request.Context().Value(propertiesKey).(*Properties); ok {
+		return p.Copy()
+	}
+	return NewProperties()
+}
+
+// WithRequestID records the request ID in a context.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID gives the request ID recorded in the context,
+// or the empty string if there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// NewRequestID gives the ID for a request: the incoming X-Request-ID
+// if it is present and sane, otherwise a new random ID.
+// Sane means printable ASCII without spaces, so IDs cannot forge
+// log entries.
+func NewRequestID(request *http.Request) string {
+	id := request.Header.Get(HdrRequestID)
+	if id != "" && len(id) <= maxRequestIDLength {
+		sane := true
+		for i := 0; i < len(id); i++ {
+			if id[i] <= ' ' || id[i] > '~' {
+				sane = false
+				break
+			}
+		}
+		if sane {
+			return id
+		}
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
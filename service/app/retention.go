@@ -0,0 +1,114 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Retention policies.  -retention options say how long the files
+// matching a pattern are kept, as pattern=age:
+//
+//	-retention 'nginx/*=30d' -retention '*.gz=90d' -retention '*=1y'
+//
+// Patterns are as for -deny: without a slash, one matches any path
+// component; with one, it matches the path relative to the root, or a
+// directory containing it.  The first pattern matching a file gives its
+// policy.  An age is a Go duration, as 36h, or a number of days (d),
+// weeks (w), or years (y, of 365 days).  A file expires its age after
+// it was last modified, so a file still written never does.
+//
+// /list gives each file's policy and expiry.  The policies are only
+// information unless -retention-sweep is set; then a background task
+// removes expired files on that interval.  See reclaim.Sweep.
+
+// A RetentionPolicy says how long matching files are kept.
+type RetentionPolicy struct {
+	Pattern string
+	Age     time.Duration
+	Text    string // The age as given, as 30d
+}
+
+// Expires gives when a file last modified at the time expires.
+func (r RetentionPolicy) Expires(modTime time.Time) time.Time {
+	return modTime.Add(r.Age)
+}
+
+// Policies, in the order given.
+type retentionRules []RetentionPolicy
+
+// Units of ages beyond Go's durations.
+var retentionUnits = map[byte]time.Duration{
+	'd': 24 * time.Hour,
+	'w': 7 * 24 * time.Hour,
+	'y': 365 * 24 * time.Hour,
+}
+
+// parseAge converts an age, a Go duration or a whole number of days,
+// weeks, or years, to a duration.
+func parseAge(s string) (time.Duration, error) {
+	if unit, found := retentionUnits[s[len(s)-1]]; found {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil || n <= 0 || time.Duration(n) > time.Duration(1<<62)/unit {
+			return 0, errors.New(fmt.Sprintf("age %q invalid", s))
+		}
+		return time.Duration(n) * unit, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, errors.New(fmt.Sprintf("age %q invalid", s))
+	}
+	return d, nil
+}
+
+// parseRetention converts pattern=age values into policies, in order.
+func parseRetention(values []string) (retentionRules, error) {
+	var policies retentionRules
+	for _, value := range values {
+		pattern, age, found := strings.Cut(value, "=")
+		pattern, age = strings.TrimSpace(pattern), strings.TrimSpace(age)
+		if !found || pattern == "" || age == "" {
+			return nil, errors.New(fmt.Sprintf("retention %q not pattern=age", value))
+		}
+		pattern, err := cleanPattern(pattern)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("retention %q: %s", value, err))
+		}
+		d, err := parseAge(age)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("retention %q: %s", value, err))
+		}
+		policies = append(policies, RetentionPolicy{Pattern: pattern, Age: d, Text: age})
+	}
+	return policies, nil
+}
+
+// Retention gives the policy for a file, named relative to the root,
+// if any.
+func (p *Properties) Retention(name string) (RetentionPolicy, bool) {
+	for _, r := range p.retention {
+		if matchPattern(r.Pattern, name) {
+			return r, true
+		}
+	}
+	return RetentionPolicy{}, false
+}
+
+// RetentionSweep gives the interval between removals of expired
+// files, or 0 if they are not removed.
+func (p *Properties) RetentionSweep() time.Duration {
+	return p.retentionSweep
+}
+
+// SetRetention sets the policies from pattern=age values, as
+// -retention options do.
+func (p *Properties) SetRetention(values []string) error {
+	policies, err := parseRetention(values)
+	if err != nil {
+		return err
+	}
+	p.retention = policies
+	return nil
+}
@@ -0,0 +1,51 @@
+package app
+
+import (
+	"sync"
+)
+
+const (
+	// Number of recent log entries kept for diagnostic bundles.
+	recentLogSize = 200
+)
+
+// A recent log entry, with the ID of the request it concerns, if any.
+type logRecord struct {
+	id   string
+	text string
+}
+
+// A fixed-size ring of recent log entries.
+type logRing struct {
+	mutex   sync.Mutex
+	entries []logRecord
+	next    int // Index for the next entry once the ring is full
+}
+
+var recentLogs logRing
+
+// add records an entry, dropping the oldest when full.
+func (r *logRing) add(id string, s string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.entries) < recentLogSize {
+		r.entries = append(r.entries, logRecord{id, s})
+		return
+	}
+	r.entries[r.next] = logRecord{id, s}
+	r.next = (r.next + 1) % recentLogSize
+}
+
+// lines gives a copy of the entries for the request ID, oldest first.
+func (r *logRing) lines(id string) []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var result []string
+	for i := range r.entries {
+		e := r.entries[(r.next+i)%len(r.entries)]
+		if e.id == id {
+			result = append(result, e.text)
+		}
+	}
+	return result
+}
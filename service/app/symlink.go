@@ -0,0 +1,69 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Symbolic links.  Cleaning the name keeps the rooted path lexically
+// under the root, but a link inside the root, say /var/log/x pointing
+// at /etc, would still lead the service outside it.  So on the host's
+// file system, the rooted path is resolved, following links, and must
+// stay under the resolved root (itself perhaps a link, as /var/log to
+// /data/log).  Links within the root work as before.
+//
+// A link changed between the check and the open can still escape;
+// the check closes the standing hole, not the race.
+
+// CheckContained verifies the rooted path, with symbolic links
+// resolved, stays under the root.  Other file systems have no links.
+// SetParamName checks its path; endpoints that set the rooted path of
+// a directory's entries themselves, with SetRootedPath, check each.
+func (p *Properties) CheckContained() error {
+	if p.root == "" || p.rootedPath == p.root || p.fileSystem != OSFileSystem {
+		return nil
+	}
+	realRoot, err := filepath.EvalSymlinks(OSPath(p.root))
+	if err != nil {
+		// Nothing under a missing root opens, so nothing escapes.
+		return nil
+	}
+	real, err := evalExisting(OSPath(p.rootedPath))
+	prefix := strings.TrimSuffix(realRoot, string(filepath.Separator)) + string(filepath.Separator)
+	if err == nil && real != realRoot && !strings.HasPrefix(real, prefix) {
+		err = errors.New("leads outside the root")
+	}
+	if err != nil {
+		err = errors.New(fmt.Sprintf("Invalid name parameter (%q), %s", p.paramName, err))
+		Log(LogWarning, "%s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// evalExisting resolves the symbolic links in a path whose last
+// components may not exist: those are kept as named.  A link that
+// cannot be resolved, as one to a missing target, is an error, since
+// the target could appear anywhere.
+func evalExisting(name string) (string, error) {
+	dir, rest := name, ""
+	for {
+		real, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			return filepath.Join(real, rest), nil
+		}
+		if info, err := os.Lstat(dir); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+			return "", errors.New("unresolvable symbolic link")
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return name, nil
+		}
+		rest = filepath.Join(filepath.Base(dir), rest)
+		dir = parent
+	}
+}
@@ -0,0 +1,65 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetParamName_symlinks(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "log")
+	outside := filepath.Join(dir, "etc")
+	for _, d := range []string{filepath.Join(root, "nginx"), outside} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{filepath.Join(root, "nginx", "access.log"), filepath.Join(outside, "passwd")} {
+		if err := os.WriteFile(f, []byte("x\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		filepath.Join(root, "etc"):      outside,
+		filepath.Join(root, "passwd"):   filepath.Join(outside, "passwd"),
+		filepath.Join(root, "dangling"): filepath.Join(outside, "shadow"),
+		filepath.Join(root, "web"):      "nginx",
+		filepath.Join(root, "up"):       "..",
+		filepath.Join(dir, "varlog"):    root,
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"", true},
+		{"nginx/access.log", true},
+		{"web/access.log", true},
+		{"missing.log", true},
+		{"nginx/missing/deeper.log", true},
+		{"etc", false},
+		{"etc/passwd", false},
+		{"etc/missing.log", false},
+		{"passwd", false},
+		{"dangling", false},
+		{"up/etc/passwd", false},
+	}
+	// The root itself may be a link.
+	for _, r := range []string{root, filepath.Join(dir, "varlog")} {
+		props := DefaultProperties()
+		props.SetRoot(r)
+		for _, test := range tests {
+			if err := props.SetParamName(test.name); (err == nil) != test.ok {
+				t.Errorf("root %s, name %q: expected ok %v, got %v", r, test.name, test.ok, err)
+			}
+		}
+	}
+}
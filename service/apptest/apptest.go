@@ -0,0 +1,244 @@
+// Package apptest builds fixtures for endpoint tests: log trees in
+// memory (testing/fstest.MapFS) or in temporary directories, and
+// requests with parameter sets and properties attached.  Tests use
+// them in place of the real /var/log.
+//
+//	tree := apptest.NewTree().Log("app.log", 100).File("sub/a.log", "one", "two")
+//	props := apptest.Properties(tree.MapFS("/var/log"), "/var/log")
+//	recorder := apptest.Serve(read.Handler, props, apptest.Request("/read", "name", "app.log"))
+//
+// Package app's own tests cannot use this package, which imports app.
+package apptest
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+	"varlog/service/app"
+)
+
+// Time of the first generated log line.
+var logStart = time.Date(2023, 2, 17, 10, 0, 0, 0, time.UTC)
+
+// Tree describes a log directory tree: files with their contents,
+// empty directories, and special files.
+type Tree struct {
+	files map[string]*fstest.MapFile // By slash-separated relative name
+}
+
+// NewTree creates an empty tree.
+func NewTree() *Tree {
+	return &Tree{files: map[string]*fstest.MapFile{}}
+}
+
+// File adds a file holding the lines, each ending in a newline.
+// A file with no lines is empty.
+func (tree *Tree) File(name string, lines ...string) *Tree {
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(line + "\n")
+	}
+	return tree.add(name, &fstest.MapFile{Data: []byte(b.String()), Mode: 0644})
+}
+
+// Log adds a file of count generated log lines, oldest first.
+func (tree *Tree) Log(name string, count int) *Tree {
+	lines := make([]string, count)
+	for j := range lines {
+		lines[j] = LogLine(j)
+	}
+	return tree.File(name, lines...)
+}
+
+// Dir adds an empty directory.
+func (tree *Tree) Dir(name string) *Tree {
+	return tree.add(name, &fstest.MapFile{Mode: fs.ModeDir | 0755})
+}
+
+// Special adds a named pipe, which /list omits and /read refuses.
+// Special files exist only in MapFS trees.
+func (tree *Tree) Special(name string) *Tree {
+	return tree.add(name, &fstest.MapFile{Mode: fs.ModeNamedPipe | 0644})
+}
+
+func (tree *Tree) add(name string, file *fstest.MapFile) *Tree {
+	tree.files[path.Clean(name)] = file
+	return tree
+}
+
+// names gives the entry names in order, so parents come first.
+func (tree *Tree) names() []string {
+	var names []string
+	for name := range tree.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MapFS gives the tree as an in-memory file system, placed at the
+// rooted path, such as /var/log, as app.Properties roots name it.
+func (tree *Tree) MapFS(root string) fstest.MapFS {
+	prefix := strings.Trim(root, "/")
+	fsys := fstest.MapFS{}
+	for _, name := range tree.names() {
+		file := *tree.files[name]
+		fsys[path.Join(prefix, name)] = &file
+	}
+	return fsys
+}
+
+// WriteDir writes the tree to a new temporary directory, removed
+// when the test ends, and gives the directory for use as a root.
+func (tree *Tree) WriteDir(tb testing.TB) string {
+	tb.Helper()
+	dir := tb.TempDir()
+	for _, name := range tree.names() {
+		file := tree.files[name]
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		var err error
+		switch {
+		case file.Mode.IsDir():
+			err = os.MkdirAll(p, 0755)
+		case file.Mode.IsRegular():
+			if err = os.MkdirAll(filepath.Dir(p), 0755); err == nil {
+				err = os.WriteFile(p, file.Data, 0644)
+			}
+		default:
+			tb.Fatalf("apptest: %s: special files only in MapFS trees", name)
+		}
+		if err != nil {
+			tb.Fatalf("apptest: %s", err)
+		}
+	}
+	return dir
+}
+
+// LogLine gives generated log line j, in the style of cmd/genlog:
+// a time, an application name, the line number, and a level.
+func LogLine(j int) string {
+	apps := []string{"aaaaa", "bbbbb", "ccccc", "ddddd", "eeeee"}
+	levels := []string{app.LogDebug, app.LogInfo, app.LogWarning, app.LogError}
+	return fmt.Sprintf("%s %s %10d %7s abcde fghij klmno pqrst uvwxy",
+		logStart.Add(time.Duration(j)*time.Second).Format("2006/01/02 15:04:05"),
+		apps[j%len(apps)], j, levels[j%len(levels)])
+}
+
+// Properties gives default properties reading the file system,
+// with the root selected.
+func Properties(fsys fs.FS, root string) *app.Properties {
+	props := app.DefaultProperties()
+	props.SetFileSystem(fsys)
+	props.SetRoot(root)
+	return props
+}
+
+// Request builds a GET request for the endpoint, with the query
+// parameters given as name, value pairs:
+//
+//	apptest.Request("/read", "name", "app.log", "count", "5")
+func Request(endpoint string, params ...string) *http.Request {
+	if len(params)%2 != 0 {
+		panic("apptest: Request parameters must be name, value pairs")
+	}
+	q := url.Values{}
+	for i := 0; i < len(params); i += 2 {
+		q.Add(params[i], params[i+1])
+	}
+	target := endpoint
+	if len(q) > 0 {
+		target += "?" + q.Encode()
+	}
+	return httptest.NewRequest(http.MethodGet, target, nil)
+}
+
+// WithProperties attaches the properties to the request, as the
+// server does, for handlers calling app.RequestProperties.
+func WithProperties(request *http.Request, props *app.Properties) *http.Request {
+	return request.WithContext(app.WithProperties(request.Context(), props))
+}
+
+// Serve runs the handler for the request with the properties,
+// giving the recorded response.
+func Serve(handler http.HandlerFunc, props *app.Properties, request *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler(recorder, WithProperties(request, props))
+	return recorder
+}
+
+// LogFS gives a file system holding one generated log file of the
+// size in bytes, at the rooted name, such as /var/log/big.log.
+// Contents are computed as they are read, so files may be larger
+// than memory, as for benchmarks.  Only the file itself exists.
+func LogFS(name string, size int64) fs.FS {
+	var b strings.Builder
+	for j := 0; j < 100; j++ {
+		b.WriteString(LogLine(j) + "\n")
+	}
+	return &logFS{name: strings.Trim(name, "/"), size: size, pattern: []byte(b.String())}
+}
+
+type logFS struct {
+	name    string
+	size    int64
+	pattern []byte // Contents repeat this
+}
+
+func (fsys *logFS) Open(name string) (fs.File, error) {
+	if name != fsys.name {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &logFile{fsys: fsys}, nil
+}
+
+// logFile is an open LogFS file.
+type logFile struct {
+	fsys   *logFS
+	offset int64 // For Read
+}
+
+func (f *logFile) Stat() (fs.FileInfo, error) { return f, nil }
+func (f *logFile) Close() error               { return nil }
+
+func (f *logFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *logFile) ReadAt(p []byte, offset int64) (int, error) {
+	if offset >= f.fsys.size {
+		return 0, io.EOF
+	}
+	if remaining := f.fsys.size - offset; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	pattern := f.fsys.pattern
+	n := 0
+	for n < len(p) {
+		n += copy(p[n:], pattern[(offset+int64(n))%int64(len(pattern)):])
+	}
+	if offset+int64(n) == f.fsys.size {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// The file is its own fs.FileInfo.
+func (f *logFile) Name() string       { return path.Base(f.fsys.name) }
+func (f *logFile) Size() int64        { return f.fsys.size }
+func (f *logFile) Mode() fs.FileMode  { return 0444 }
+func (f *logFile) ModTime() time.Time { return logStart }
+func (f *logFile) IsDir() bool        { return false }
+func (f *logFile) Sys() any           { return nil }
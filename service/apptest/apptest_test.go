@@ -0,0 +1,49 @@
+package apptest
+
+import (
+	"io/fs"
+	"reflect"
+	"testing"
+	"varlog/service/app"
+)
+
+func TestTree(t *testing.T) {
+	tree := NewTree().Log("app.log", 3).File("sub/a.log", "one", "two").Dir("empty")
+	mapFS := tree.MapFS("/var/log")
+	dirFS := app.OSFileSystem
+	dir := tree.WriteDir(t)
+	for _, name := range []string{"app.log", "sub/a.log"} {
+		b1, err1 := fs.ReadFile(mapFS, "var/log/"+name)
+		b2, err2 := fs.ReadFile(dirFS, dir[1:]+"/"+name)
+		if err1 != nil || err2 != nil || !reflect.DeepEqual(b1, b2) {
+			t.Errorf("%s: expected equal contents, got %q, %v and %q, %v", name, b1, err1, b2, err2)
+		}
+	}
+	if info, err := fs.Stat(mapFS, "var/log/empty"); err != nil || !info.IsDir() {
+		t.Errorf("expected directory empty, got %v, %v", info, err)
+	}
+}
+
+func TestRequest(t *testing.T) {
+	request := Request("/read", "name", "a b.log", "count", "5")
+	if request.URL.Path != "/read" || request.URL.Query().Get("name") != "a b.log" ||
+		request.URL.Query().Get("count") != "5" {
+		t.Errorf("expected /read with name and count, got %s", request.URL)
+	}
+	props := app.DefaultProperties()
+	if got := app.RequestProperties(WithProperties(request, props)); got.Root() != props.Root() {
+		t.Errorf("expected attached properties, got root %q", got.Root())
+	}
+}
+
+func TestLogFS(t *testing.T) {
+	fsys := LogFS("/var/log/big.log", 10000)
+	b, err := fs.ReadFile(fsys, "var/log/big.log")
+	expected, _ := fs.ReadFile(NewTree().Log("big.log", 200).MapFS("/"), "big.log")
+	if err != nil || len(b) != 10000 || string(b[:len(expected)/2]) != string(expected[:len(expected)/2]) {
+		t.Errorf("expected 10000 generated bytes, got %d, %v", len(b), err)
+	}
+	if _, err := fs.Stat(fsys, "var/log/other.log"); err == nil {
+		t.Errorf("expected only big.log, got other.log")
+	}
+}
@@ -0,0 +1,353 @@
+// Package audit keeps the trail of requests to the authenticated
+// endpoints: who asked for which log, when, from where, and with what
+// result, including requests that failed authentication.  With
+// -audit-log, entries are appended to a file as JSON lines, rotated
+// by the -rotate-... options; recent entries are also kept in memory.
+// /audit queries the trail, so a security review need not log in to
+// the host:
+//
+//	GET /audit?since=24h&user=alice&path=nginx
+//
+// Parameters, all optional:
+//
+//   - since, until: an RFC 3339 time, or a duration before now.
+//   - user: the authenticated name, exactly.
+//   - path: a log file or directory; entries for it and for the
+//     files under it match.
+//   - limit: most entries returned, newest first; default 1000.
+//
+// Only principals the -authz rules name app.AuditTrail for read the
+// whole trail; others read only their own entries, as if they gave
+// their own name as the user.
+//
+// Handlers do not record entries themselves; see Wrap.
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"varlog/service/app"
+	"varlog/service/rotate"
+)
+
+const (
+	// Number of recent entries kept in memory.
+	recentSize = 10000
+
+	// Entries returned by /audit without a 'limit' parameter, and at most.
+	defaultLimit = 1000
+	maxLimit     = 10000
+
+	// Longest audit log line read back, in case of a damaged file.
+	maxLineBytes = 64 * 1024
+
+	paramLimit = "limit" // Name of the 'limit' parameter
+	paramPath  = "path"  // Name of the 'path' parameter
+	paramSince = "since" // Name of the 'since' parameter
+	paramUntil = "until" // Name of the 'until' parameter
+	paramUser  = "user"  // Name of the 'user' parameter
+)
+
+// Entry records one request.
+type Entry struct {
+	Time     time.Time `json:"time"`           // When the request arrived
+	ID       string    `json:"id,omitempty"`   // Request ID, as in the access log
+	User     string    `json:"user,omitempty"` // Authenticated name, empty if none
+	Remote   string    `json:"remote"`         // Client address
+	Method   string    `json:"method"`
+	Endpoint string    `json:"endpoint"`       // URL path, such as /read
+	Path     string    `json:"path,omitempty"` // The 'name' parameter: a log file or directory
+	Status   int       `json:"status"`
+}
+
+type contextKey int
+
+// The context key of the request's *requestUser, filled in by
+// authentication once Wrap has started the entry.
+const requestKey contextKey = 0
+
+// The user of a request, set by authentication after Wrap starts.
+type requestUser struct {
+	mutex sync.Mutex
+	name  string
+}
+
+var (
+	mutex   sync.Mutex
+	file    *rotate.Writer // Audit log, nil if none
+	name    string         // Audit log name, empty if none
+	policy  rotate.Policy  // When the audit log is rotated
+	entries []Entry        // Ring of recent entries
+	next    int            // Next ring index once full
+)
+
+// Setup opens the audit log named by the properties, if any,
+// closing one opened before.  The log is rotated by the properties'
+// RotatePolicy.
+func Setup(props *app.Properties) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	name = props.AuditLog()
+	policy = props.RotatePolicy()
+	return reopen()
+}
+
+// Reopen closes and reopens the audit log, so logrotate can move it.
+func Reopen() error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return reopen()
+}
+
+// reopen opens the audit log.  The mutex must be held.
+func reopen() error {
+	if file != nil {
+		file.Close()
+		file = nil
+	}
+	if name == "" {
+		return nil
+	}
+	f, err := rotate.Open(name, 0600, policy)
+	if err != nil {
+		return err
+	}
+	file = f
+	return nil
+}
+
+// Wrap returns a handler that records an entry for each request
+// after the given handler returns.  Authentication inside the handler
+// reports the user with SetUser.
+func Wrap(handler http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		t0 := time.Now()
+		user := new(requestUser)
+		recorder := app.NewResponseRecorder(writer)
+		ctx := context.WithValue(request.Context(), requestKey, user)
+		handler(recorder, request.WithContext(ctx))
+
+		user.mutex.Lock()
+		userName := user.name
+		user.mutex.Unlock()
+		record(Entry{
+			Time:     t0.UTC(),
+			ID:       app.RequestID(request.Context()),
+			User:     userName,
+			Remote:   request.RemoteAddr,
+			Method:   request.Method,
+			Endpoint: request.URL.Path,
+			Path:     request.URL.Query().Get(app.ParamName),
+			Status:   recorder.Status,
+		})
+	}
+}
+
+// SetUser records the authenticated name for the request.
+func SetUser(ctx context.Context, name string) {
+	if user, ok := ctx.Value(requestKey).(*requestUser); ok {
+		user.mutex.Lock()
+		user.name = name
+		user.mutex.Unlock()
+	}
+}
+
+// record keeps an entry in memory and appends it to the audit log.
+// A failed write is logged, but does not fail the request.
+func record(e Entry) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		app.Log(app.LogError, "Audit entry marshal failed: %s", err.Error())
+		return
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(entries) < recentSize {
+		entries = append(entries, e)
+	} else {
+		entries[next] = e
+		next = (next + 1) % recentSize
+	}
+	if file != nil {
+		if _, err := file.Write(append(b, '\n')); err != nil {
+			app.Log(app.LogError, "Audit log write to %s failed: %s", name, err.Error())
+		}
+	}
+}
+
+// A query of the trail, from the /audit parameters.
+type query struct {
+	since time.Time
+	until time.Time
+	user  string
+	path  string // Without leading or trailing slashes
+	limit int
+}
+
+// matches reports whether an entry satisfies the query.
+func (q *query) matches(e *Entry) bool {
+	if !q.since.IsZero() && e.Time.Before(q.since) || !q.until.IsZero() && !e.Time.Before(q.until) {
+		return false
+	}
+	if q.user != "" && e.User != q.user {
+		return false
+	}
+	if q.path != "" {
+		p := strings.Trim(e.Path, "/")
+		if p != q.path && !strings.HasPrefix(p, q.path+"/") {
+			return false
+		}
+	}
+	return true
+}
+
+// parseQuery extracts the /audit parameters.
+func parseQuery(request *http.Request) (*query, error) {
+	values := request.URL.Query()
+	q := &query{
+		user:  values.Get(paramUser),
+		path:  strings.Trim(values.Get(paramPath), "/"),
+		limit: defaultLimit,
+	}
+	now := time.Now()
+	for _, param := range []string{paramSince, paramUntil} {
+		value := values.Get(param)
+		if value == "" {
+			continue
+		}
+		t, ok := app.ParseTime(value, now)
+		if !ok {
+			return nil, app.ParamError(param, fmt.Sprintf("Invalid value %s=%q", param, value))
+		}
+		if param == paramSince {
+			q.since = t
+		} else {
+			q.until = t
+		}
+	}
+	if value := values.Get(paramLimit); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > maxLimit {
+			return nil, app.ParamError(paramLimit,
+				fmt.Sprintf("Invalid value %s=%q, expected 1 to %d", paramLimit, value, maxLimit))
+		}
+		q.limit = n
+	}
+	return q, nil
+}
+
+// The /audit response body.
+type response struct {
+	Entries   []Entry `json:"entries"`   // Newest first
+	Truncated bool    `json:"truncated"` // More entries matched than the limit
+}
+
+// Handler serves /audit: the entries matching the parameters, newest
+// first.  With an audit log, the whole current file is searched;
+// otherwise only the entries in memory.
+func Handler(writer http.ResponseWriter, request *http.Request) {
+	q, err := parseQuery(request)
+	if err != nil {
+		app.Log(app.LogWarning, "%s", err)
+		app.WriteError(writer, request, err)
+		return
+	}
+	props := app.RequestProperties(request)
+	props.SetPrincipal(app.PrincipalFrom(request.Context()))
+	props.SetGroups(app.GroupsFrom(request.Context()))
+	if !props.AuthorizedToAudit() {
+		principal := props.Principal()
+		if principal == "" || q.user != "" && q.user != principal {
+			app.Log(app.LogWarning, "Principal %q not authorized for the audit trail", principal)
+			app.Error(writer, request, "Access denied", http.StatusForbidden)
+			return
+		}
+		q.user = principal
+	}
+	matched, truncated, err := search(request.Context(), q)
+	if err != nil {
+		app.Log(app.LogError, "Audit search failed: %s", err.Error())
+		app.Error(writer, request, "Audit search failed", http.StatusInternalServerError)
+		return
+	}
+	// Reverse, for newest first.
+	for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+		matched[i], matched[j] = matched[j], matched[i]
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(response{Entries: matched, Truncated: truncated})
+}
+
+// search finds the last q.limit entries matching the query, oldest
+// first, and whether any earlier ones matched.
+func search(ctx context.Context, q *query) (matched []Entry, truncated bool, err error) {
+	ring := make([]Entry, 0, q.limit)
+	first := 0 // Index of the oldest entry once the ring is full
+	keep := func(e *Entry) {
+		switch {
+		case !q.matches(e):
+		case len(ring) < q.limit:
+			ring = append(ring, *e)
+		default:
+			ring[first] = *e
+			first = (first + 1) % q.limit
+			truncated = true
+		}
+	}
+	defer func() {
+		if err == nil {
+			matched = append(ring[first:], ring[:first]...)
+		}
+	}()
+
+	mutex.Lock()
+	logName := name
+	if logName == "" {
+		for i := range entries {
+			keep(&entries[(next+i)%len(entries)])
+		}
+	}
+	mutex.Unlock()
+	if logName == "" {
+		return nil, truncated, nil
+	}
+
+	f, err := os.Open(logName)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	reader := bufio.NewReaderSize(f, maxLineBytes)
+	for lineNumber := 1; ; lineNumber++ {
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
+		line, err := reader.ReadSlice('\n')
+		if err == io.EOF {
+			// A partial last line is an entry still being written.
+			return nil, truncated, nil
+		}
+		if err == bufio.ErrBufferFull {
+			return nil, false, errors.New(fmt.Sprintf("%s:%d: line too long", logName, lineNumber))
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			app.Log(app.LogWarning, "Skipping bad audit entry %s:%d: %s", logName, lineNumber, err.Error())
+			continue
+		}
+		keep(&e)
+	}
+}
@@ -0,0 +1,180 @@
+package audit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"varlog/service/app"
+)
+
+// reset clears the trail and opens the audit log, if any.
+func reset(t *testing.T, logName string) {
+	mutex.Lock()
+	entries, next = nil, 0
+	mutex.Unlock()
+	props := app.DefaultProperties()
+	props.SetAuditLog(logName)
+	if err := Setup(props); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Setup(app.DefaultProperties()) })
+}
+
+// serve sends a request through Wrap, as the user, with the status.
+func serve(user string, target string, status int) {
+	handler := Wrap(func(writer http.ResponseWriter, request *http.Request) {
+		if user != "" {
+			SetUser(request.Context(), user)
+		}
+		writer.WriteHeader(status)
+	})
+	handler(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+}
+
+// authorize loads -authz rules allowing "auditor" the whole trail.
+func authorize(t *testing.T) {
+	rules := filepath.Join(t.TempDir(), "authz")
+	if err := os.WriteFile(rules, []byte("auditor: "+app.AuditTrail+"\n*: *\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.LoadAuthz(rules); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { app.LoadAuthz("") })
+}
+
+// fetch serves /audit to the principal.
+func fetch(principal string, target string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest("GET", target, nil)
+	request = request.WithContext(app.WithPrincipal(request.Context(), principal))
+	recorder := httptest.NewRecorder()
+	Handler(recorder, request)
+	return recorder
+}
+
+// fetchPaths gives the user:path of the entries /audit returns
+// to the auditor for the target.
+func fetchPaths(t *testing.T, target string) (paths []string, truncated bool) {
+	recorder := fetch("auditor", target)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("%s: expected status 200, got %d: %s", target, recorder.Code, recorder.Body)
+	}
+	var r response
+	if err := json.Unmarshal(recorder.Body.Bytes(), &r); err != nil {
+		t.Fatalf("%s: %s", target, err)
+	}
+	paths = []string{}
+	for _, e := range r.Entries {
+		paths = append(paths, e.User+":"+e.Path)
+	}
+	return paths, r.Truncated
+}
+
+func TestHandler(t *testing.T) {
+	authorize(t)
+	for _, logName := range []string{"", filepath.Join(t.TempDir(), "audit.log")} {
+		reset(t, logName)
+		serve("alice", "/read?name=nginx/access.log", http.StatusOK)
+		serve("bob", "/read?name=syslog", http.StatusForbidden)
+		serve("", "/list?name=nginx", http.StatusUnauthorized)
+		serve("alice", "/read?name=nginx2.log", http.StatusOK)
+
+		tests := []struct {
+			target    string
+			paths     []string
+			truncated bool
+		}{
+			{"/audit", []string{"alice:nginx2.log", ":nginx", "bob:syslog", "alice:nginx/access.log"}, false},
+			{"/audit?user=alice", []string{"alice:nginx2.log", "alice:nginx/access.log"}, false},
+			{"/audit?path=/nginx/", []string{":nginx", "alice:nginx/access.log"}, false},
+			{"/audit?user=alice&path=nginx", []string{"alice:nginx/access.log"}, false},
+			{"/audit?limit=2", []string{"alice:nginx2.log", ":nginx"}, true},
+			{"/audit?since=1h", []string{"alice:nginx2.log", ":nginx", "bob:syslog", "alice:nginx/access.log"}, false},
+			{"/audit?until=1h", []string{}, false},
+		}
+		for _, test := range tests {
+			paths, truncated := fetchPaths(t, test.target)
+			if !reflect.DeepEqual(paths, test.paths) || truncated != test.truncated {
+				t.Errorf("%q %s: expected %v %v, got %v %v", logName, test.target, test.paths, test.truncated, paths, truncated)
+			}
+		}
+	}
+}
+
+func TestHandler_auditLog(t *testing.T) {
+	authorize(t)
+	logName := filepath.Join(t.TempDir(), "audit.log")
+	reset(t, logName)
+	serve("alice", "/read?name=app.log", http.StatusOK)
+
+	// After a restart, the entries come from the file.
+	reset(t, logName)
+	serve("bob", "/read?name=app.log", http.StatusOK)
+	if paths, _ := fetchPaths(t, "/audit"); !reflect.DeepEqual(paths, []string{"bob:app.log", "alice:app.log"}) {
+		t.Errorf("expected both entries, got %v", paths)
+	}
+
+	b, err := os.ReadFile(logName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(b), "\n"); n != 2 {
+		t.Errorf("expected 2 lines in the audit log, got %d", n)
+	}
+	info, _ := os.Stat(logName)
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %v", info.Mode().Perm())
+	}
+
+	// Entries keep the request's details.
+	recorder := fetch("auditor", "/audit?user=bob")
+	var r response
+	json.Unmarshal(recorder.Body.Bytes(), &r)
+	if len(r.Entries) != 1 || r.Entries[0].Endpoint != "/read" || r.Entries[0].Method != "GET" ||
+		r.Entries[0].Status != http.StatusOK || time.Since(r.Entries[0].Time) > time.Minute {
+		t.Errorf("expected bob's /read entry, got %+v", r.Entries)
+	}
+}
+
+func TestHandler_errors(t *testing.T) {
+	reset(t, "")
+	for _, target := range []string{"/audit?since=yesterday", "/audit?until=-5m", "/audit?limit=0", "/audit?limit=100000"} {
+		if recorder := fetch("auditor", target); recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", target, recorder.Code)
+		}
+	}
+}
+
+func TestHandler_ownEntries(t *testing.T) {
+	reset(t, "")
+	serve("alice", "/read?name=app.log", http.StatusOK)
+	serve("bob", "/read?name=syslog", http.StatusOK)
+
+	// Without an -authz rule, principals read only their own entries.
+	for _, authz := range []bool{false, true} {
+		if authz {
+			authorize(t)
+		}
+		recorder := fetch("alice", "/audit")
+		var r response
+		json.Unmarshal(recorder.Body.Bytes(), &r)
+		if recorder.Code != http.StatusOK || len(r.Entries) != 1 || r.Entries[0].User != "alice" {
+			t.Errorf("authz %v: expected only alice's entry, got %d %s", authz, recorder.Code, recorder.Body)
+		}
+		for _, test := range []struct{ principal, target string }{
+			{"alice", "/audit?user=bob"},
+			{"", "/audit"},
+			{"auditor", "/audit?user=bob"},
+		} {
+			if recorder := fetch(test.principal, test.target); (recorder.Code == http.StatusForbidden) != (!authz || test.principal != "auditor") {
+				t.Errorf("authz %v: %q %s: got %d", authz, test.principal, test.target, recorder.Code)
+			}
+		}
+	}
+}
@@ -0,0 +1,257 @@
+// Package auth authenticates requests before they reach the endpoints.
+// The service exposes potentially sensitive log content, so when any
+// credentials are configured, every request must present one:
+//
+//   - A bearer token: "Authorization: Bearer TOKEN".  Tokens come from
+//     -auth-token flags or an -auth-token-file, one "name:token" per line.
+//   - Basic authentication: "Authorization: Basic ...".  Users come from
+//     an htpasswd-style -auth-htpasswd file, one "user:hash" per line,
+//     with salted SHA-crypt hashes.  See shacrypt.go.
+//
+// Bearer tokens may also be JSON Web Tokens from an OpenID Connect
+// issuer, carrying the client's groups.  See oidc.go.
+//
+// Sites with their own authentication systems can instead delegate
+// validation to an external helper: an executable (-auth-command) or a
+// unix socket (-auth-socket).  See external.go for the protocol.
+// Static credentials are checked first, then the helper.
+//
+// With no credentials configured, requests pass through unchanged,
+// relying on the service listening only on localhost.
+//
+// With mutual TLS (-tls-client-ca), the TLS layer verifies client
+// certificates before any request arrives.  The certificate's common
+// name is available through ClientCN, and it serves as the principal
+// when no other credentials are configured.
+//
+// The authenticated principal (token name, user name, or certificate
+// common name) is recorded in the request context and logged for
+// auditing.  See Principal.
+package auth
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"varlog/service/app"
+	"varlog/service/audit"
+)
+
+const (
+	realm = app.Application
+)
+
+// The configured credentials.  Written once at startup by Setup.
+var (
+	tokens map[string]string // Bearer token => principal name
+	users  map[string]string // User name => htpasswd hash
+)
+
+// Setup loads the credentials named by the command line flags.
+// Returns an error if a file cannot be read or has malformed lines.
+func Setup(props *app.Properties) error {
+	tokens = make(map[string]string)
+	users = make(map[string]string)
+	for _, entry := range props.AuthTokens() {
+		if err := addToken(entry, "-auth-token"); err != nil {
+			return err
+		}
+	}
+	if name := props.AuthTokenFile(); name != "" {
+		if err := readEntries(name, addToken); err != nil {
+			return err
+		}
+	}
+	if name := props.AuthHtpasswd(); name != "" {
+		if err := readEntries(name, addUser); err != nil {
+			return err
+		}
+	}
+	setupExternal(props)
+	setupOIDC(props)
+	if Enabled() {
+		app.Log(app.LogInfo, "Authentication enabled: %d tokens, %d users, external %q, OIDC issuer %q",
+			len(tokens), len(users), authCommand+authSocket, oidcIssuer)
+	}
+	return nil
+}
+
+// Enabled reports whether any credentials or helpers are configured.
+func Enabled() bool {
+	return len(tokens) > 0 || len(users) > 0 || externalEnabled() || oidcEnabled()
+}
+
+// Principal gives the authenticated name for the request,
+// or the empty string if authentication is not enabled.
+func Principal(request *http.Request) string {
+	return app.PrincipalFrom(request.Context())
+}
+
+// Wrap returns a handler that authenticates the request before calling
+// the given handler.  Unauthenticated requests get 401 Unauthorized with
+// a WWW-Authenticate challenge.
+func Wrap(handler http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		cn := ClientCN(request)
+		if !Enabled() {
+			if cn != "" {
+				app.Log(app.LogInfo, "Access by client CN %q: %s %q", cn, request.Method, request.URL)
+				ctx := app.WithPrincipal(request.Context(), cn)
+				request = request.WithContext(ctx)
+				audit.SetUser(ctx, cn)
+			}
+			handler(writer, request)
+			return
+		}
+		name, groups, ok := authenticate(request)
+		if !ok {
+			app.Log(app.LogWarning, "Authentication failed for %s (client CN %q) %q",
+				request.RemoteAddr, cn, request.URL)
+			challenge(writer)
+			app.Error(writer, request, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+		app.Log(app.LogInfo, "Access by %q (client CN %q): %s %q", name, cn, request.Method, request.URL)
+		ctx := app.WithPrincipal(request.Context(), name)
+		audit.SetUser(ctx, name)
+		if len(groups) > 0 {
+			ctx = app.WithGroups(ctx, groups)
+		}
+		handler(writer, request.WithContext(ctx))
+	}
+}
+
+// ClientCN gives the common name from the request's verified client
+// certificate, or the empty string without mutual TLS.
+func ClientCN(request *http.Request) string {
+	if request.TLS == nil || len(request.TLS.VerifiedChains) == 0 {
+		return ""
+	}
+	return request.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+// authenticate checks the request's credentials, returning the
+// principal name, any groups, and true on success.
+func authenticate(request *http.Request) (name string, groups []string, ok bool) {
+	header := request.Header.Get("Authorization")
+	if token, found := cutPrefixFold(header, "Bearer "); found {
+		token = strings.TrimSpace(token)
+		if name, ok = checkToken(token); ok {
+			return name, nil, ok
+		}
+		if oidcEnabled() && looksLikeJWT(token) {
+			name, groups, err := checkJWT(request.Context(), token)
+			if err == nil {
+				return name, groups, true
+			}
+			app.Log(app.LogWarning, "Token rejected: %s", err)
+		}
+	} else if user, password, found := request.BasicAuth(); found {
+		if name, ok = checkUser(user, password); ok {
+			return name, nil, ok
+		}
+	}
+	if externalEnabled() {
+		name, ok = checkExternal(request)
+		return name, nil, ok
+	}
+	return "", nil, false
+}
+
+// checkToken compares the token to each configured token in constant
+// time, so timing does not reveal how much of a token matched.
+func checkToken(token string) (name string, ok bool) {
+	for t, n := range tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			name, ok = n, true
+		}
+	}
+	return name, ok
+}
+
+// checkUser verifies a basic authentication user and password.
+func checkUser(user string, password string) (name string, ok bool) {
+	hash, found := users[user]
+	if !found {
+		return "", false
+	}
+	computed, _ := shaCrypt(password, hash)
+	if subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) != 1 {
+		return "", false
+	}
+	return user, true
+}
+
+// challenge adds the WWW-Authenticate headers for the configured schemes.
+func challenge(writer http.ResponseWriter) {
+	header := writer.Header()
+	if len(tokens) > 0 || externalEnabled() || oidcEnabled() {
+		header.Add("WWW-Authenticate", fmt.Sprintf("Bearer realm=%q", realm))
+	}
+	if len(users) > 0 || externalEnabled() {
+		header.Add("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", realm))
+	}
+}
+
+// addToken records a "name:token" entry.
+func addToken(entry string, source string) error {
+	name, token, found := strings.Cut(entry, ":")
+	if !found || name == "" || token == "" {
+		return errors.New(fmt.Sprintf("Invalid token entry in %s, expected name:token", source))
+	}
+	tokens[token] = name
+	return nil
+}
+
+// addUser records a "user:$6$salt$hash" (or "$5$") SHA-crypt entry.
+// Unsalted formats ({SHA}, plain text) and the weak crypt and MD5-crypt
+// hashes are refused, as is bcrypt, which would need a library outside
+// the standard library, rather than ignored.
+func addUser(entry string, source string) error {
+	user, hash, found := strings.Cut(entry, ":")
+	if !found || user == "" {
+		return errors.New(fmt.Sprintf("Invalid htpasswd entry in %s, expected user:hash", source))
+	}
+	if !isSHACrypt(hash) {
+		return errors.New(fmt.Sprintf(
+			"Unsupported or weak hash for user %q in %s, use a salted SHA-crypt hash ($6$) "+
+				"from openssl passwd -6 or mkpasswd -m sha-512", user, source))
+	}
+	if _, ok := shaCrypt("", hash); !ok {
+		return errors.New(fmt.Sprintf("Invalid SHA-crypt hash for user %q in %s", user, source))
+	}
+	users[user] = hash
+	return nil
+}
+
+// readEntries calls add for each non-blank, non-comment line in a file.
+func readEntries(name string, add func(entry string, source string) error) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if err = add(line, fmt.Sprintf("%s:%d", name, lineNumber)); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// cutPrefixFold removes a prefix, ignoring ASCII case.
+func cutPrefixFold(s string, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
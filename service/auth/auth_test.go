@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func setupCredentials(t *testing.T) {
+	tokens = map[string]string{}
+	users = map[string]string{}
+	if err := addToken("ops:s3cret", "test"); err != nil {
+		t.Fatalf("addToken: %v", err)
+	}
+	// openssl passwd -6 -salt 8kZxQp2r pw
+	if err := addUser("alice:$6$8kZxQp2r$Z.xZ1BQKcM1O4r9ow3jEJqugxDGKoX5gWG3cGXCPE4vaiHlM7JtTgqya2sQamVprxwTC46etiC0jONZ2vw/OS/", "test"); err != nil {
+		t.Fatalf("addUser: %v", err)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	setupCredentials(t)
+	var principal string
+	handler := Wrap(func(writer http.ResponseWriter, request *http.Request) {
+		principal = Principal(request)
+	})
+	tests := []struct {
+		user, password, token string
+		status                int
+		principal             string
+	}{
+		{"", "", "", http.StatusUnauthorized, ""},
+		{"", "", "s3cret", http.StatusOK, "ops"},
+		{"", "", "wrong", http.StatusUnauthorized, ""},
+		{"alice", "pw", "", http.StatusOK, "alice"},
+		{"alice", "bad", "", http.StatusUnauthorized, ""},
+		{"bob", "pw", "", http.StatusUnauthorized, ""},
+	}
+	for _, test := range tests {
+		principal = ""
+		request := httptest.NewRequest("GET", "/list", nil)
+		if test.user != "" {
+			request.SetBasicAuth(test.user, test.password)
+		}
+		if test.token != "" {
+			request.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		if recorder.Code != test.status {
+			t.Errorf("%+v: expected status %d, got %d", test, test.status, recorder.Code)
+		}
+		if principal != test.principal {
+			t.Errorf("%+v: expected principal %q, got %q", test, test.principal, principal)
+		}
+	}
+}
+
+func TestAddUser_unsupportedHash(t *testing.T) {
+	users = map[string]string{}
+	for _, entry := range []string{
+		"bob:$2y$05$abcdefghijklmnopqrstuv",         // bcrypt
+		"bob:{SHA}GpHWL3ymc5liWkNopqtdSjuqYHM=",     // Unsalted SHA-1
+		"bob:$apr1$abcdefgh$0123456789abcdefghijkl", // MD5-crypt
+		"bob:pw", // Plain text
+		"bob:$6$rounds=many$salt$hash",
+	} {
+		if err := addUser(entry, "test"); err == nil {
+			t.Errorf("%s: expected error", entry)
+		}
+	}
+}
+
+func TestSHACrypt(t *testing.T) {
+	// Expected values from openssl passwd -5/-6 and the specification.
+	tests := []struct {
+		password, setting, expected string
+	}{
+		{"Hello world!", "$5$saltstring",
+			"$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5"},
+		{"Hello world!", "$6$saltstring",
+			"$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"},
+		{"Hello world!", "$6$rounds=10000$saltstringsaltstring",
+			"$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v."},
+		{"the minimum number is still observed", "$5$rounds=10$roundstoolow",
+			"$5$rounds=1000$roundstoolow$yfvwcWrQ8l/K0DAWyuPMDNHpIVlTQebY9l/gL972bIC"},
+		{"a very much longer text to encrypt.  This one even stretches over morethan one line.",
+			"$5$saltstringsaltstringlonger$ignored",
+			"$5$saltstringsaltst$gLl2ijR5xacVyGj.s7X9fnVkkwHUdYIj7sOOeOzeXW2"},
+	}
+	for _, test := range tests {
+		if computed, ok := shaCrypt(test.password, test.setting); !ok || computed != test.expected {
+			t.Errorf("%q %s: expected %s, got %s %v", test.password, test.setting, test.expected, computed, ok)
+		}
+	}
+	if _, ok := shaCrypt("pw", "{SHA}GpHWL3ymc5liWkNopqtdSjuqYHM="); ok {
+		t.Errorf("{SHA}: expected not SHA-crypt")
+	}
+}
+
+func TestExternalCommand(t *testing.T) {
+	tokens, users = map[string]string{}, map[string]string{}
+	authCommand = filepath.Join(t.TempDir(), "helper")
+	authSocket = ""
+	defer func() { authCommand = "" }()
+	// Accepts token "good" (naming the principal) and user "bob" (exit status).
+	// Also allows token "anon" and password "open", naming no one.
+	script := `#!/bin/sh
+input=$(cat)
+case "$input" in
+*'"token":"good"'*) echo '{"allow":true,"principal":"sso-user"}' ;;
+*'"token":"anon"'*) echo '{"allow":true}' ;;
+*'"user":"bob"'*) exit 0 ;;
+*'"password":"open"'*) exit 0 ;;
+*) exit 1 ;;
+esac
+`
+	if err := os.WriteFile(authCommand, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		user, password, token string
+		ok                    bool
+		principal             string
+	}{
+		{"", "", "good", true, "sso-user"},
+		{"", "", " good ", true, "sso-user"}, // Trimmed, as for static tokens
+		{"", "", "bad", false, ""},
+		{"bob", "pw", "", true, "bob"},
+		{"carol", "pw", "", false, ""},
+		{"", "", "", false, ""},
+		// Allowed, but without a principal, so refused.
+		{"", "", "anon", false, ""},
+		{"", "open", "", false, ""},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest("GET", "/read", nil)
+		if test.user != "" || test.password != "" {
+			request.SetBasicAuth(test.user, test.password)
+		}
+		if test.token != "" {
+			request.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		name, _, ok := authenticate(request)
+		if ok != test.ok || name != test.principal {
+			t.Errorf("%+v: expected (%q, %v), got (%q, %v)", test, test.principal, test.ok, name, ok)
+		}
+	}
+}
+
+func TestExternalSocket(t *testing.T) {
+	tokens, users = map[string]string{}, map[string]string{}
+	authCommand = ""
+	authSocket = filepath.Join(t.TempDir(), "auth.sock")
+	defer func() { authSocket = "" }()
+	listener, err := net.Listen("unix", authSocket)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			var req externalRequest
+			json.NewDecoder(conn).Decode(&req)
+			response := externalResponse{Allow: req.Token == "good", Principal: "svc"}
+			json.NewEncoder(conn).Encode(response)
+			conn.Close()
+		}
+	}()
+	for _, token := range []string{"good", "bad"} {
+		request := httptest.NewRequest("GET", "/read", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		name, _, ok := authenticate(request)
+		if ok != (token == "good") || (ok && name != "svc") {
+			t.Errorf("token %q: got (%q, %v)", token, name, ok)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+	"varlog/service/app"
+)
+
+const (
+	// Maximum time to wait for an external helper's decision.
+	externalTimeout = 5 * time.Second
+
+	// Values for externalRequest.Scheme
+	schemeBasic  = "basic"
+	schemeBearer = "bearer"
+)
+
+// The credentials sent to an external helper, as one JSON object.
+// A command receives it on standard input; a socket receives it
+// as a single line.
+type externalRequest struct {
+	Scheme     string `json:"scheme"`             // "basic" or "bearer"
+	User       string `json:"user,omitempty"`     // Basic authentication user
+	Password   string `json:"password,omitempty"` // Basic authentication password
+	Token      string `json:"token,omitempty"`    // Bearer token
+	RemoteAddr string `json:"remote_addr"`        // Client address, host:port
+	Method     string `json:"method"`             // HTTP method
+	Path       string `json:"path"`               // URL path, e.g., /read
+}
+
+// The helper's decision, as one JSON object.  A command may instead
+// write nothing; its exit status then decides (zero allows).
+// A socket must reply with a single line.
+type externalResponse struct {
+	Allow     bool   `json:"allow"`     // True to accept the credentials
+	Principal string `json:"principal"` // Name for the client, optional
+}
+
+// The configured helpers.  Written once at startup by Setup.
+var (
+	authCommand string // Executable validating credentials
+	authSocket  string // Unix socket validating credentials
+)
+
+// setupExternal records the external helpers named by the flags.
+func setupExternal(props *app.Properties) {
+	authCommand = props.AuthCommand()
+	authSocket = props.AuthSocket()
+}
+
+// externalEnabled reports whether an external helper is configured.
+func externalEnabled() bool {
+	return authCommand != "" || authSocket != ""
+}
+
+// checkExternal asks the external helper to validate the request's
+// credentials.  Requests without credentials are rejected here, so the
+// helper sees only genuine attempts.
+func checkExternal(request *http.Request) (name string, ok bool) {
+	req := externalRequest{
+		RemoteAddr: request.RemoteAddr,
+		Method:     request.Method,
+		Path:       request.URL.Path,
+	}
+	if token, found := cutPrefixFold(request.Header.Get("Authorization"), "Bearer "); found {
+		// Trimmed as for the static tokens, so the helper sees the same token.
+		req.Scheme = schemeBearer
+		req.Token = strings.TrimSpace(token)
+		if req.Token == "" {
+			return "", false
+		}
+	} else if user, password, found := request.BasicAuth(); found {
+		req.Scheme = schemeBasic
+		req.User = user
+		req.Password = password
+	} else {
+		return "", false
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		app.Log(app.LogError, "Auth request marshal failed: %s", err)
+		return "", false
+	}
+
+	ctx, cancel := context.WithTimeout(request.Context(), externalTimeout)
+	defer cancel()
+	var response externalResponse
+	if authCommand != "" {
+		response, err = runCommand(ctx, body)
+	} else {
+		response, err = askSocket(ctx, body)
+	}
+	if err != nil {
+		app.Log(app.LogError, "External authentication failed: %s", err)
+		return "", false
+	}
+	if !response.Allow {
+		return "", false
+	}
+	if response.Principal == "" {
+		response.Principal = req.User
+	}
+	if response.Principal == "" {
+		// An empty principal would be anonymous, as if unauthenticated.
+		app.Log(app.LogWarning, "External authentication allowed %s credentials without a principal, refusing", req.Scheme)
+		return "", false
+	}
+	return response.Principal, true
+}
+
+// runCommand executes the helper with the request on standard input.
+func runCommand(ctx context.Context, body []byte) (response externalResponse, err error) {
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, authCommand)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout = &stdout
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// A non-zero exit is a refusal, not a failure.
+		return externalResponse{Allow: false}, nil
+	}
+	if err != nil {
+		return response, err
+	}
+	if len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+		return externalResponse{Allow: true}, nil
+	}
+	if err = json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		return response, errors.New(fmt.Sprintf("Invalid response from %s: %s", authCommand, err))
+	}
+	return response, nil
+}
+
+// askSocket sends the request as one line to the helper's unix socket
+// and reads one line of response.
+func askSocket(ctx context.Context, body []byte) (response externalResponse, err error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", authSocket)
+	if err != nil {
+		return response, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err = conn.Write(append(body, '\n')); err != nil {
+		return response, err
+	}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return response, err
+	}
+	if err = json.Unmarshal(line, &response); err != nil {
+		return response, errors.New(fmt.Sprintf("Invalid response from %s: %s", authSocket, err))
+	}
+	return response, nil
+}
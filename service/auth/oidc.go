@@ -0,0 +1,427 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"varlog/service/app"
+)
+
+// Bearer tokens may also be JSON Web Tokens from an OpenID Connect
+// issuer (-oidc-issuer), so sites can reuse their single sign-on
+// rather than hand out static tokens.  A token is accepted when:
+//
+//   - It is signed (RS256, RS384, RS512, ES256, ES384, or ES512) by a
+//     key in the issuer's JWKS, found through the issuer's discovery
+//     document, or given with -oidc-jwks-url.
+//   - Its "iss" is the issuer, and its "aud" includes -oidc-audience.
+//   - It has not expired ("exp"), and is in effect ("nbf").
+//
+// The principal is the -oidc-principal-claim claim ("sub" by default).
+// The -oidc-groups-claim claim ("groups") lists the client's groups,
+// which -authz rules name with a leading "@".  See app.Authorized.
+
+const (
+	// Maximum time to wait for the discovery document or JWKS.
+	oidcFetchTimeout = 10 * time.Second
+
+	// Least time between fetches of the JWKS, so tokens with unknown
+	// key IDs cannot make the service hammer the issuer.
+	jwksMinRefresh = time.Minute
+
+	// Least time between attempts after a failed fetch.  Shorter than
+	// jwksMinRefresh, so an issuer's outage does not lock out clients
+	// whose tokens use a newly rotated key for long after it ends.
+	jwksRetry = 5 * time.Second
+
+	// Most time the JWKS is used before fetching it again, so rotated
+	// keys are dropped.
+	jwksMaxAge = time.Hour
+
+	// Allowance for clock differences with the issuer.
+	oidcLeeway = time.Minute
+)
+
+// The OIDC configuration.  Written once at startup by Setup.
+var (
+	oidcIssuer         string // Expected "iss", empty to disable
+	oidcAudience       string // Required member of "aud"
+	oidcJWKSURL        string // Key set location, empty to discover
+	oidcPrincipalClaim string
+	oidcGroupsClaim    string
+	oidcClient         = &http.Client{Timeout: oidcFetchTimeout}
+)
+
+// The issuer's signing keys, fetched as needed.
+var (
+	jwksMutex   sync.Mutex
+	jwksKeys    map[string]crypto.PublicKey // Key ID => key
+	jwksFetched time.Time                   // Last successful fetch
+	jwksFailed  time.Time                   // Last failed fetch
+	jwksFetch   chan struct{}               // Closed when the fetch in progress ends, nil if none
+)
+
+// setupOIDC records the OIDC configuration named by the flags.
+// Keys are fetched when the first token arrives, so the service
+// starts while the issuer is unreachable.
+func setupOIDC(props *app.Properties) {
+	oidcIssuer = strings.TrimSuffix(props.OIDCIssuer(), "/")
+	oidcAudience = props.OIDCAudience()
+	oidcJWKSURL = props.OIDCJWKSURL()
+	oidcPrincipalClaim = props.OIDCPrincipalClaim()
+	oidcGroupsClaim = props.OIDCGroupsClaim()
+	jwksMutex.Lock()
+	jwksKeys, jwksFetched, jwksFailed = nil, time.Time{}, time.Time{}
+	jwksMutex.Unlock()
+}
+
+// oidcEnabled reports whether an OIDC issuer is configured.
+func oidcEnabled() bool {
+	return oidcIssuer != ""
+}
+
+// looksLikeJWT reports whether the token has a JWT's three parts.
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// The JOSE header of a JWT.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// checkJWT validates a JWT from the issuer, giving the principal and
+// groups from its claims.
+func checkJWT(ctx context.Context, token string) (name string, groups []string, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", nil, errors.New("malformed token")
+	}
+	var header jwtHeader
+	if err = decodeSegment(parts[0], &header); err != nil {
+		return "", nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, errors.New("malformed signature")
+	}
+	key, err := signingKey(ctx, header.Kid)
+	if err != nil {
+		return "", nil, err
+	}
+	if err = verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return "", nil, err
+	}
+	var claims map[string]interface{}
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return "", nil, err
+	}
+	if err = checkClaims(claims, time.Now()); err != nil {
+		return "", nil, err
+	}
+	name, _ = claims[oidcPrincipalClaim].(string)
+	if name == "" {
+		return "", nil, errors.New(fmt.Sprintf("no %q claim", oidcPrincipalClaim))
+	}
+	return name, stringsClaim(claims[oidcGroupsClaim]), nil
+}
+
+// checkClaims checks the issuer, audience, and validity period.
+func checkClaims(claims map[string]interface{}, now time.Time) error {
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != oidcIssuer {
+		return errors.New(fmt.Sprintf("issuer %q not accepted", iss))
+	}
+	audienceFound := false
+	for _, aud := range stringsClaim(claims["aud"]) {
+		audienceFound = audienceFound || aud == oidcAudience
+	}
+	if !audienceFound {
+		return errors.New(fmt.Sprintf("audience %v not accepted", claims["aud"]))
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("no expiration")
+	}
+	if now.Add(-oidcLeeway).After(time.Unix(int64(exp), 0)) {
+		return errors.New("expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(oidcLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("not yet valid")
+	}
+	return nil
+}
+
+// stringsClaim gives a claim that is a string or a list of strings.
+// Some issuers give groups as one space-separated string.
+func stringsClaim(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		var values []string
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// decodeSegment decodes a base64url JSON segment of a JWT.
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errors.New("malformed token")
+	}
+	if err = json.Unmarshal(b, v); err != nil {
+		return errors.New("malformed token")
+	}
+	return nil
+}
+
+// The curve size each ES algorithm requires.
+var ecdsaCurveBits = map[string]int{"ES256": 256, "ES384": 384, "ES512": 521}
+
+// verifySignature checks a signature with the algorithm the header
+// names.  The key's type must suit the algorithm, so a token cannot
+// choose a weaker check, and "none" is never accepted.
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	var h hash.Hash
+	var hashID crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		h, hashID = sha256.New(), crypto.SHA256
+	case "RS384", "ES384":
+		h, hashID = sha512.New384(), crypto.SHA384
+	case "RS512", "ES512":
+		h, hashID = sha512.New(), crypto.SHA512
+	default:
+		return errors.New(fmt.Sprintf("algorithm %q not accepted", alg))
+	}
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if alg[0] != 'R' {
+			break
+		}
+		if rsa.VerifyPKCS1v15(k, hashID, digest, signature) != nil {
+			return errors.New("bad signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		// Each ES algorithm names its curve: ES256 P-256, ES384 P-384,
+		// and ES512 P-521.
+		bits := k.Curve.Params().BitSize
+		size := (bits + 7) / 8
+		if alg[0] != 'E' || ecdsaCurveBits[alg] != bits || len(signature) != 2*size {
+			break
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("bad signature")
+		}
+		return nil
+	}
+	return errors.New(fmt.Sprintf("algorithm %q does not suit the key", alg))
+}
+
+// signingKey gives the issuer's key with the ID.  An unknown key
+// waits for a fetch of the JWKS, unless one was made recently; a known
+// key in an old set is given at once while the set is fetched again.
+// The mutex is not held during a fetch, and concurrent requests share
+// one.  The fetch is not bound to the request, so a client giving up
+// on the wait does not end it.
+func signingKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	jwksMutex.Lock()
+	key, ok := jwksKeys[kid]
+	age := time.Since(jwksFetched)
+	retry := time.Since(jwksFailed) >= jwksRetry
+	var done chan struct{}
+	if retry && ((!ok && age >= jwksMinRefresh) || age >= jwksMaxAge) {
+		done = startFetch()
+	} else if !ok {
+		done = jwksFetch // Another request's fetch may bring the key
+	}
+	jwksMutex.Unlock()
+	if ok {
+		return key, nil
+	}
+	if done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		jwksMutex.Lock()
+		key, ok = jwksKeys[kid]
+		jwksMutex.Unlock()
+	}
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("unknown key %q", kid))
+	}
+	return key, nil
+}
+
+// startFetch fetches the JWKS in the background, unless a fetch is
+// in progress, giving a channel closed when the fetch ends.  Only a
+// successful fetch counts toward jwksMinRefresh; a failed one waits
+// jwksRetry, so an unreachable issuer is not hammered.
+// The caller holds the mutex.
+func startFetch() chan struct{} {
+	if jwksFetch != nil {
+		return jwksFetch
+	}
+	done := make(chan struct{})
+	jwksFetch = done
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), oidcFetchTimeout)
+		defer cancel()
+		keys, err := fetchJWKS(ctx)
+		jwksMutex.Lock()
+		if err != nil {
+			app.Log(app.LogError, "Cannot fetch keys for %s: %s", oidcIssuer, err)
+			jwksFailed = time.Now()
+		} else {
+			jwksKeys, jwksFetched = keys, time.Now()
+		}
+		jwksFetch = nil
+		jwksMutex.Unlock()
+		close(done)
+	}()
+	return done
+}
+
+// A key in a JWKS (RFC 7517), with the members for RSA and EC keys.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchJWKS fetches the issuer's signing keys, by way of its discovery
+// document unless -oidc-jwks-url is given.  Keys of other types, or
+// for encryption, are skipped.
+func fetchJWKS(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	url := oidcJWKSURL
+	if url == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := fetchJSON(ctx, oidcIssuer+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, err
+		}
+		if discovery.JWKSURI == "" {
+			return nil, errors.New("no jwks_uri in discovery document")
+		}
+		url = discovery.JWKSURI
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := fetchJSON(ctx, url, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			app.Log(app.LogWarning, "Skipping key %q from %s: %s", k.Kid, url, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	app.Log(app.LogInfo, "Fetched %d signing keys from %s", len(keys), url)
+	return keys, nil
+}
+
+// publicKey decodes an RSA or EC public key.
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil, errors.New("bad key parameter")
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("bad RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New(fmt.Sprintf("unsupported curve %q", k.Crv))
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("unsupported key type %q", k.Kty))
+}
+
+// fetchJSON gets a JSON document.
+func fetchJSON(ctx context.Context, url string, v interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := oidcClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprintf("%s: %s", url, response.Status))
+	}
+	return json.NewDecoder(response.Body).Decode(v)
+}
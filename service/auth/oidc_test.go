@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"varlog/service/app"
+)
+
+// testIssuer serves a discovery document and a JWKS with one RSA and
+// one EC key, and signs tokens with them.
+type testIssuer struct {
+	server  *httptest.Server
+	rsaKey  *rsa.PrivateKey
+	ecKey   *ecdsa.PrivateKey
+	fetches atomic.Int32
+	delay   time.Duration // Before answering a JWKS fetch
+	fail    atomic.Bool   // Answer JWKS fetches with 500
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	issuer := &testIssuer{}
+	var err error
+	if issuer.rsaKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		t.Fatal(err)
+	}
+	if issuer.ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	encode := func(n *big.Int) string { return base64.RawURLEncoding.EncodeToString(n.Bytes()) }
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(writer http.ResponseWriter, request *http.Request) {
+		json.NewEncoder(writer).Encode(map[string]string{"jwks_uri": issuer.server.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(writer http.ResponseWriter, request *http.Request) {
+		issuer.fetches.Add(1)
+		time.Sleep(issuer.delay)
+		if issuer.fail.Load() {
+			http.Error(writer, "unavailable", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(writer).Encode(map[string]interface{}{"keys": []jwk{
+			{Kty: "RSA", Kid: "r1", Use: "sig", N: encode(issuer.rsaKey.N), E: encode(big.NewInt(int64(issuer.rsaKey.E)))},
+			{Kty: "EC", Kid: "e1", Crv: "P-256", X: encode(issuer.ecKey.X), Y: encode(issuer.ecKey.Y)},
+		}})
+	})
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+// sign makes a token with the claims, signed by the key with the ID.
+func (issuer *testIssuer) sign(t *testing.T, alg string, kid string, claims map[string]interface{}) string {
+	segment := func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := segment(jwtHeader{Alg: alg, Kid: kid}) + "." + segment(claims)
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	switch alg {
+	case "RS256":
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, issuer.rsaKey, crypto.SHA256, digest[:])
+	case "ES256", "ES384":
+		// ES384 with the P-256 key pairs the algorithm with the wrong curve.
+		hashed := digest[:]
+		if alg == "ES384" {
+			sum := sha512.Sum384([]byte(signed))
+			hashed = sum[:]
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, issuer.ecKey, hashed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestWrap_oidc(t *testing.T) {
+	issuer := newTestIssuer(t)
+	tokens, users = map[string]string{}, map[string]string{}
+	props := app.DefaultProperties()
+	props.SetOIDC(issuer.server.URL, "varlog")
+	setupOIDC(props)
+	defer setupOIDC(app.DefaultProperties())
+
+	now := time.Now().Unix()
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss": issuer.server.URL, "aud": []string{"other", "varlog"}, "sub": "alice",
+			"exp": now + 300, "groups": []string{"sre", "web"},
+		}
+	}
+	with := func(key string, value interface{}) map[string]interface{} {
+		claims := valid()
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+	good := issuer.sign(t, "RS256", "r1", valid())
+	tests := []struct {
+		name   string
+		token  string
+		status int
+		groups []string
+	}{
+		{"RS256", good, http.StatusOK, []string{"sre", "web"}},
+		{"ES256", issuer.sign(t, "ES256", "e1", with("groups", "sre ops")), http.StatusOK, []string{"sre", "ops"}},
+		{"expired", issuer.sign(t, "RS256", "r1", with("exp", now-3600)), http.StatusUnauthorized, nil},
+		{"no expiration", issuer.sign(t, "RS256", "r1", with("exp", nil)), http.StatusUnauthorized, nil},
+		{"not yet valid", issuer.sign(t, "RS256", "r1", with("nbf", now+3600)), http.StatusUnauthorized, nil},
+		{"audience", issuer.sign(t, "RS256", "r1", with("aud", "other")), http.StatusUnauthorized, nil},
+		{"issuer", issuer.sign(t, "RS256", "r1", with("iss", "https://evil.example.com")), http.StatusUnauthorized, nil},
+		{"no subject", issuer.sign(t, "RS256", "r1", with("sub", nil)), http.StatusUnauthorized, nil},
+		{"wrong key type", issuer.sign(t, "ES256", "r1", valid()), http.StatusUnauthorized, nil},
+		{"wrong curve", issuer.sign(t, "ES384", "e1", valid()), http.StatusUnauthorized, nil},
+		{"unknown key", issuer.sign(t, "RS256", "r2", valid()), http.StatusUnauthorized, nil},
+		{"none", strings.Join(append(strings.Split(good, ".")[:2], ""), "."), http.StatusUnauthorized, nil},
+		{"altered", good[:len(good)-4] + "AAAA", http.StatusUnauthorized, nil},
+		{"not a JWT", "s3cret", http.StatusUnauthorized, nil},
+	}
+	for _, test := range tests {
+		var principal string
+		var groups []string
+		handler := Wrap(func(writer http.ResponseWriter, request *http.Request) {
+			principal, groups = Principal(request), app.GroupsFrom(request.Context())
+		})
+		request := httptest.NewRequest("GET", "/list", nil)
+		request.Header.Set("Authorization", "Bearer "+test.token)
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		if recorder.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.status, recorder.Code)
+		}
+		if test.status == http.StatusOK && (principal != "alice" || !reflect.DeepEqual(groups, test.groups)) {
+			t.Errorf("%s: expected alice %v, got %q %v", test.name, test.groups, principal, groups)
+		}
+	}
+	// An unknown key soon after a fetch does not fetch again.
+	if n := issuer.fetches.Load(); n != 1 {
+		t.Errorf("expected 1 JWKS fetch, got %d", n)
+	}
+}
+
+// Concurrent requests share one fetch, which a canceled request does
+// not end, and only a successful fetch delays the next.
+func TestSigningKey_fetch(t *testing.T) {
+	issuer := newTestIssuer(t)
+	props := app.DefaultProperties()
+	props.SetOIDC(issuer.server.URL, "varlog")
+	setupOIDC(props)
+	defer setupOIDC(app.DefaultProperties())
+
+	issuer.fail.Store(true)
+	if _, err := signingKey(context.Background(), "r1"); err == nil {
+		t.Fatalf("expected an error while the issuer fails")
+	}
+	if !jwksFetched.IsZero() {
+		t.Errorf("expected a failed fetch not to count as fetched")
+	}
+	issuer.fail.Store(false)
+	jwksFailed = time.Time{} // As if jwksRetry had passed
+
+	issuer.delay = 100 * time.Millisecond
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := signingKey(canceled, "r1"); err != context.Canceled {
+		t.Errorf("canceled: expected context.Canceled, got %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := signingKey(context.Background(), "e1"); err != nil {
+				t.Errorf("concurrent: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := issuer.fetches.Load(); n != 2 {
+		t.Errorf("expected the failed fetch and one shared fetch, got %d", n)
+	}
+}
@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// SHA-crypt, the salted and iterated "$5$" (SHA-256) and "$6$" (SHA-512)
+// password hashes of glibc crypt(3), as written by openssl passwd -5/-6
+// or mkpasswd.  Apache accepts them in htpasswd files on Linux.
+// The algorithm is specified at https://www.akkadia.org/drepper/SHA-crypt.txt.
+
+const (
+	shaCrypt256Prefix = "$5$"
+	shaCrypt512Prefix = "$6$"
+
+	shaCryptRoundsPrefix  = "rounds="
+	shaCryptDefaultRounds = 5000
+	shaCryptMinRounds     = 1000
+	shaCryptMaxRounds     = 999999999
+	shaCryptMaxSalt       = 16
+	shaCryptAlphabet      = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+// The order in which the final digest's bytes are encoded, three at a time.
+var (
+	shaCrypt256Order = []int{
+		0, 10, 20, 21, 1, 11, 12, 22, 2, 3, 13, 23, 24, 4, 14,
+		15, 25, 5, 6, 16, 26, 27, 7, 17, 18, 28, 8, 9, 19, 29,
+	}
+	shaCrypt512Order = []int{
+		0, 21, 42, 22, 43, 1, 44, 2, 23, 3, 24, 45, 25, 46, 4,
+		47, 5, 26, 6, 27, 48, 28, 49, 7, 50, 8, 29, 9, 30, 51,
+		31, 52, 10, 53, 11, 32, 12, 33, 54, 34, 55, 13, 56, 14, 35,
+		15, 36, 57, 37, 58, 16, 59, 17, 38, 18, 39, 60, 40, 61, 19,
+		62, 20, 41,
+	}
+)
+
+// isSHACrypt reports whether a stored hash is in a SHA-crypt format.
+func isSHACrypt(stored string) bool {
+	return strings.HasPrefix(stored, shaCrypt256Prefix) || strings.HasPrefix(stored, shaCrypt512Prefix)
+}
+
+// shaCrypt hashes the password with the algorithm, rounds, and salt of
+// the stored hash, giving the result in the same form, for comparison.
+// Returns false if the stored hash is not a SHA-crypt hash.
+func shaCrypt(password string, stored string) (string, bool) {
+	var newHash func() hash.Hash
+	var order []int
+	var prefix string
+	switch {
+	case strings.HasPrefix(stored, shaCrypt256Prefix):
+		newHash, order, prefix = sha256.New, shaCrypt256Order, shaCrypt256Prefix
+	case strings.HasPrefix(stored, shaCrypt512Prefix):
+		newHash, order, prefix = sha512.New, shaCrypt512Order, shaCrypt512Prefix
+	default:
+		return "", false
+	}
+	setting := stored[len(prefix):]
+	rounds, explicit := shaCryptDefaultRounds, false
+	if strings.HasPrefix(setting, shaCryptRoundsPrefix) {
+		digits, rest, found := strings.Cut(setting[len(shaCryptRoundsPrefix):], "$")
+		n, err := strconv.Atoi(digits)
+		if !found || err != nil {
+			return "", false
+		}
+		switch {
+		case n < shaCryptMinRounds:
+			n = shaCryptMinRounds
+		case n > shaCryptMaxRounds:
+			n = shaCryptMaxRounds
+		}
+		rounds, explicit, setting = n, true, rest
+	}
+	salt, _, _ := strings.Cut(setting, "$")
+	if len(salt) > shaCryptMaxSalt {
+		salt = salt[:shaCryptMaxSalt]
+	}
+	pw, s := []byte(password), []byte(salt)
+
+	h := newHash()
+	h.Write(pw)
+	h.Write(s)
+	h.Write(pw)
+	alternate := h.Sum(nil)
+
+	h.Reset()
+	h.Write(pw)
+	h.Write(s)
+	h.Write(repeatBytes(alternate, len(pw)))
+	for n := len(pw); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			h.Write(alternate)
+		} else {
+			h.Write(pw)
+		}
+	}
+	digest := h.Sum(nil)
+
+	h.Reset()
+	for i := 0; i < len(pw); i++ {
+		h.Write(pw)
+	}
+	p := repeatBytes(h.Sum(nil), len(pw))
+
+	h.Reset()
+	for i := 0; i < 16+int(digest[0]); i++ {
+		h.Write(s)
+	}
+	sp := repeatBytes(h.Sum(nil), len(s))
+
+	for i := 0; i < rounds; i++ {
+		h.Reset()
+		if i&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(digest)
+		}
+		if i%3 != 0 {
+			h.Write(sp)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i&1 != 0 {
+			h.Write(digest)
+		} else {
+			h.Write(p)
+		}
+		digest = h.Sum(digest[:0])
+	}
+
+	var b strings.Builder
+	b.WriteString(prefix)
+	if explicit {
+		b.WriteString(shaCryptRoundsPrefix + strconv.Itoa(rounds) + "$")
+	}
+	b.WriteString(salt)
+	b.WriteByte('$')
+	for i := 0; i+2 < len(order); i += 3 {
+		encodeCrypt64(&b, uint(digest[order[i]])<<16|uint(digest[order[i+1]])<<8|uint(digest[order[i+2]]), 4)
+	}
+	if len(digest) == sha256.Size {
+		encodeCrypt64(&b, uint(digest[31])<<8|uint(digest[30]), 3)
+	} else {
+		encodeCrypt64(&b, uint(digest[63]), 2)
+	}
+	return b.String(), true
+}
+
+// repeatBytes repeats b, whole or in part, to fill n bytes.
+func repeatBytes(b []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out)+len(b) <= n {
+		out = append(out, b...)
+	}
+	return append(out, b[:n-len(out)]...)
+}
+
+// encodeCrypt64 writes the low 6*n bits of w in crypt's base 64,
+// least significant first.
+func encodeCrypt64(b *strings.Builder, w uint, n int) {
+	for ; n > 0; n-- {
+		b.WriteByte(shaCryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+}
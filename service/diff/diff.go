@@ -0,0 +1,318 @@
+// Package diff provides code for the /diff service endpoint, which
+// compares two files line by line, as for configuration dumps or
+// consecutive runs of a job:
+//
+//	GET /diff?name=app/config.dump&with=app/config.dump.1
+//	GET /diff?name=jobs/nightly.log&range=0-81920&with-range=81920-
+//
+// Parameter 'name=path' selects the first file, and 'with=path' the
+// second, by default the same file.  Parameters 'range' and
+// 'with-range' select bytes of each, as START-END or START- for the
+// rest of the file, so two runs logged to one file can be compared.
+// A range starting inside a line starts at the next line.  Both files
+// are checked as /read checks its file.
+//
+// The response is a unified diff without context lines, in file order:
+//
+//	--- app/config.dump
+//	+++ app/config.dump.1
+//	@@ -12,2 +12 @@
+//	-workers=8
+//	-queue=disk
+//	+workers=16
+//
+// Files the same give an empty body.  The comparison streams, holding
+// at most a window of lines from each file, so memory stays bounded
+// however large the files.  Within the window the diff finds the
+// nearest lines in common; files differing by more than a window at
+// once are reported as that window's lines removed and added, and the
+// comparison continues, so the diff is correct but may not be the
+// shortest.
+package diff
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"varlog/service/app"
+	"varlog/service/stats"
+)
+
+const (
+	// Most lines, and bytes of lines, held from each file while
+	// looking for lines in common.
+	windowLines = 10000
+	windowBytes = 4 * 1024 * 1024
+)
+
+// Handler serves /diff.
+func Handler(writer http.ResponseWriter, request *http.Request) {
+	props := app.RequestProperties(request)
+	if err := props.ExtractParams(request); err != nil {
+		app.WriteError(writer, request, err)
+		return
+	}
+	with := props.Copy()
+	if props.ParamWith() != "" {
+		if err := with.SetParamName(props.ParamWith()); err != nil {
+			app.WriteError(writer, request, app.ParamError(app.ParamWith,
+				fmt.Sprintf("Invalid value %s=%q, %s", app.ParamWith, props.ParamWith(), err)))
+			return
+		}
+	}
+	a, err := open(props, props.ParamRange())
+	if err != nil {
+		app.WriteError(writer, request, err)
+		return
+	}
+	defer a.close()
+	b, err := open(with, props.ParamWithRange())
+	if err != nil {
+		app.WriteError(writer, request, err)
+		return
+	}
+	defer b.close()
+
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	out := bufio.NewWriter(writer)
+	d := &differ{a: a, b: b, out: out}
+	err = d.run(request)
+	stats.AddBytesScanned(request.Context(), a.scanned+b.scanned)
+	if err != nil {
+		// The status is sent; the client sees a short diff.
+		app.Log(app.LogWarning, "/diff of %q and %q failed, %s", a.name, b.name, err)
+	}
+	out.Flush()
+}
+
+// A side of the comparison: a file's lines, read forward, with a
+// window of those not yet matched.
+type side struct {
+	name    string
+	file    app.File
+	reader  *bufio.Reader
+	maxLine int                 // Bytes kept of one line, 0 for no limit
+	redact  func(string) string // Redacts a line for the file
+	window  []string            // Lines read, not yet reported or matched
+	bytes   int                 // Bytes in the window
+	line    int                 // Line number of the first line in the window, from 1
+	eof     bool
+	err     error // Read error other than the end
+	scanned int64
+}
+
+// open checks the file of the properties may be read, and opens the
+// range of it.
+func open(props *app.Properties, r app.ByteRange) (*side, error) {
+	name := props.RelativePath()
+	if app.Denied(name) {
+		app.Log(app.LogWarning, "Denied path %q requested", name)
+		return nil, app.NewHTTPError(http.StatusNotFound, app.CodeNotFound, "Not found")
+	}
+	if !props.Authorized(name) {
+		app.Log(app.LogWarning, "Principal %q not authorized for %q", props.Principal(), name)
+		return nil, app.NewHTTPError(http.StatusForbidden, app.CodeAccessDenied, "Access denied")
+	}
+	info, err := app.Stat(props.FileSystem(), props.RootedPath())
+	if err != nil {
+		return nil, app.FileError(name, err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, app.ParamError(app.ParamName, fmt.Sprintf("Diff of %q, not a regular file, not allowed", name))
+	}
+	file, err := app.Open(props.FileSystem(), props.RootedPath())
+	if err != nil {
+		return nil, app.FileError(name, err)
+	}
+	end := info.Size()
+	if r.End > 0 && r.End < end {
+		end = r.End
+	}
+	start := r.Start
+	if start > end {
+		start = end
+	}
+	s := &side{
+		name:    name,
+		file:    file,
+		reader:  bufio.NewReaderSize(io.NewSectionReader(file, start, end-start), props.ChunkSize()),
+		maxLine: props.MaxLineBytes(),
+		redact:  props.Redact,
+		line:    1,
+	}
+	if start > 0 {
+		// Skip the partial line, unless the range starts a line.
+		previous := make([]byte, 1)
+		if _, err := file.ReadAt(previous, start-1); err == nil && previous[0] != '\n' {
+			s.readLine()
+		}
+	}
+	return s, nil
+}
+
+func (s *side) close() {
+	s.file.Close()
+}
+
+// readLine reads the next line, without its newline, truncated to
+// maxLine bytes and redacted.  At the end it gives false.
+func (s *side) readLine() (string, bool) {
+	if s.eof {
+		return "", false
+	}
+	var b []byte
+	for {
+		chunk, err := s.reader.ReadSlice('\n')
+		s.scanned += int64(len(chunk))
+		if s.maxLine <= 0 || len(b) < s.maxLine {
+			b = append(b, chunk...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			s.eof = true
+			if err != io.EOF {
+				s.err = err
+			}
+			if len(b) == 0 {
+				return "", false
+			}
+		}
+		break
+	}
+	line := strings.TrimSuffix(string(b), "\n")
+	if s.maxLine > 0 && len(line) > s.maxLine {
+		line = line[:s.maxLine]
+	}
+	return s.redact(line), true
+}
+
+// fill reads lines into the window up to its limits.
+func (s *side) fill() {
+	for len(s.window) < windowLines && s.bytes < windowBytes {
+		line, ok := s.readLine()
+		if !ok {
+			return
+		}
+		s.window = append(s.window, line)
+		s.bytes += len(line)
+	}
+}
+
+// drop removes the first n lines of the window.
+func (s *side) drop(n int) {
+	for _, line := range s.window[:n] {
+		s.bytes -= len(line)
+	}
+	s.window = s.window[n:]
+	s.line += n
+}
+
+// A differ compares two sides, writing the hunks.
+type differ struct {
+	a, b    *side
+	out     *bufio.Writer
+	started bool // The --- and +++ lines are written
+}
+
+// run compares the sides to their ends, or until the request is
+// canceled.
+func (d *differ) run(request *http.Request) error {
+	ctx := request.Context()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		d.a.fill()
+		d.b.fill()
+		for _, s := range []*side{d.a, d.b} {
+			if s.err != nil {
+				return errors.New(fmt.Sprintf("%s: %s", s.name, s.err))
+			}
+		}
+		if len(d.a.window) == 0 && len(d.b.window) == 0 {
+			return nil
+		}
+		// Skip the lines in common.
+		same := 0
+		for same < len(d.a.window) && same < len(d.b.window) && d.a.window[same] == d.b.window[same] {
+			same++
+		}
+		if same > 0 {
+			d.a.drop(same)
+			d.b.drop(same)
+			continue
+		}
+		i, j := resync(d.a.window, d.b.window, d.a.eof, d.b.eof)
+		if err := d.hunk(i, j); err != nil {
+			return err
+		}
+	}
+}
+
+// resync gives the lines of each window before the nearest pair in
+// common, the pair with the fewest lines before it.  Without a pair,
+// it gives the whole windows, or, when a window has not reached its
+// file's end, half of it, keeping lines that may match past the other
+// window.
+func resync(a []string, b []string, aEOF bool, bEOF bool) (int, int) {
+	first := make(map[string]int, len(b))
+	for j := len(b) - 1; j >= 0; j-- {
+		first[b[j]] = j
+	}
+	best, bestI, bestJ := -1, len(a), len(b)
+	for i, line := range a {
+		if best >= 0 && i >= best {
+			break
+		}
+		if j, ok := first[line]; ok && (best < 0 || i+j < best) {
+			best, bestI, bestJ = i+j, i, j
+		}
+	}
+	if best >= 0 {
+		return bestI, bestJ
+	}
+	if !aEOF && bestI > 1 {
+		bestI /= 2
+	}
+	if !bEOF && bestJ > 1 {
+		bestJ /= 2
+	}
+	return bestI, bestJ
+}
+
+// hunk writes the first i lines of a as removed, and the first j of b
+// as added, and drops them.
+func (d *differ) hunk(i int, j int) error {
+	if !d.started {
+		d.started = true
+		fmt.Fprintf(d.out, "--- %s\n+++ %s\n", d.a.name, d.b.name)
+	}
+	fmt.Fprintf(d.out, "@@ -%s +%s @@\n", hunkRange(d.a.line, i), hunkRange(d.b.line, j))
+	for _, line := range d.a.window[:i] {
+		d.out.WriteString("-" + line + "\n")
+	}
+	for _, line := range d.b.window[:j] {
+		d.out.WriteString("+" + line + "\n")
+	}
+	d.a.drop(i)
+	d.b.drop(j)
+	return d.out.Flush()
+}
+
+// hunkRange gives a unified diff range: the first line and count, the
+// count left out when 1, and the line before when the count is 0.
+func hunkRange(line int, count int) string {
+	switch count {
+	case 0:
+		return strconv.Itoa(line-1) + ",0"
+	case 1:
+		return strconv.Itoa(line)
+	}
+	return strconv.Itoa(line) + "," + strconv.Itoa(count)
+}
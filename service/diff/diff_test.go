@@ -0,0 +1,74 @@
+package diff
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"varlog/service/apptest"
+)
+
+func TestHandler(t *testing.T) {
+	fsys := apptest.NewTree().
+		File("config.dump", "a", "b", "workers=8", "queue=disk", "c", "d").
+		File("config.dump.1", "a", "b", "workers=16", "c", "d", "e").
+		File("job.log", "run 1", "step ok", "step ok", "run 2", "step ok", "step failed").
+		Dir("old").
+		MapFS("/var/log")
+	props := apptest.Properties(fsys, "/var/log")
+	get := func(params ...string) (int, string) {
+		recorder := apptest.Serve(Handler, props, apptest.Request("/diff", params...))
+		return recorder.Code, recorder.Body.String()
+	}
+
+	code, body := get("name", "config.dump", "with", "config.dump.1")
+	want := "--- config.dump\n+++ config.dump.1\n" +
+		"@@ -3,2 +3 @@\n-workers=8\n-queue=disk\n+workers=16\n" +
+		"@@ -6,0 +6 @@\n+e\n"
+	if code != http.StatusOK || body != want {
+		t.Errorf("expected\n%s\ngot %d\n%s", want, code, body)
+	}
+	if code, body := get("name", "config.dump", "with", "config.dump"); code != http.StatusOK || body != "" {
+		t.Errorf("same file: expected an empty diff, got %d %q", code, body)
+	}
+
+	// Two runs in one file; the second range starts mid-line, so at "run 2".
+	code, body = get("name", "job.log", "range", "0-22", "with-range", "20-")
+	want = "--- job.log\n+++ job.log\n@@ -1 +1 @@\n-run 1\n+run 2\n@@ -3 +3 @@\n-step ok\n+step failed\n"
+	if code != http.StatusOK || body != want {
+		t.Errorf("ranges: expected\n%s\ngot %d\n%s", want, code, body)
+	}
+
+	for _, test := range []struct {
+		params []string
+		code   int
+	}{
+		{[]string{"name", "config.dump", "with", "missing"}, http.StatusNotFound},
+		{[]string{"name", "old", "with", "config.dump"}, http.StatusBadRequest},
+		{[]string{"name", "config.dump", "range", "9-3"}, http.StatusBadRequest},
+		{[]string{"name", "config.dump", "with", "../etc/passwd"}, http.StatusBadRequest},
+	} {
+		if code, body := get(test.params...); code != test.code {
+			t.Errorf("%q: expected %d, got %d %s", test.params, test.code, code, body)
+		}
+	}
+}
+
+func TestResync(t *testing.T) {
+	// Windows with nothing in common report half of each window not at
+	// its end, so later lines can still match.
+	var a, b []string
+	for j := 0; j < 10; j++ {
+		a = append(a, fmt.Sprint("a", j))
+		b = append(b, fmt.Sprint("b", j))
+	}
+	if i, j := resync(a, b, false, true); i != 5 || j != 10 {
+		t.Errorf("expected 5 and 10, got %d and %d", i, j)
+	}
+	if i, j := resync(a, append([]string{"x"}, a[3:]...), true, true); i != 3 || j != 1 {
+		t.Errorf("expected the nearest pair, 3 and 1, got %d and %d", i, j)
+	}
+	if got := strings.Join([]string{hunkRange(4, 0), hunkRange(4, 1), hunkRange(4, 2)}, " "); got != "3,0 4 4,2" {
+		t.Errorf("unexpected hunk ranges %q", got)
+	}
+}
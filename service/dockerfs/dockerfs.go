@@ -0,0 +1,185 @@
+// Package dockerfs serves the logs of Docker containers as an io/fs
+// file system, one file per container named for the container.
+// Hosts running containers keep their interesting logs outside
+// /var/log, in the json-file log driver's files:
+//
+//	/var/lib/docker/containers/ID/ID-json.log
+//
+// Containers are discovered from the same directory: each container's
+// config.v2.json gives its name.  Lines of the log files are JSON
+// objects; DecodeLine converts them to text for /read.
+package dockerfs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// Where Docker keeps container state, by default.
+	DefaultDir = "/var/lib/docker/containers"
+
+	// Line format of the log files, for Mount.Format.
+	Format = "docker-json"
+)
+
+// FS is the file system of container logs.
+type FS struct {
+	dir string
+}
+
+// New creates the file system for Docker's containers directory.
+func New(dir string) *FS {
+	return &FS{dir: dir}
+}
+
+// A container found in the containers directory.
+type container struct {
+	id   string
+	name string // Without Docker's leading "/"
+}
+
+// logFile gives the path of the container's json-file log.
+func (fsys *FS) logFile(c container) string {
+	return filepath.Join(fsys.dir, c.id, c.id+"-json.log")
+}
+
+// containers lists the containers with logs, sorted by name.
+// Containers using other log drivers have no log file and are omitted.
+func (fsys *FS) containers() ([]container, error) {
+	entries, err := os.ReadDir(fsys.dir)
+	if err != nil {
+		return nil, err
+	}
+	var found []container
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(fsys.dir, e.Name(), "config.v2.json"))
+		if err != nil {
+			continue
+		}
+		var config struct{ Name string }
+		if json.Unmarshal(b, &config) != nil {
+			continue
+		}
+		c := container{id: e.Name(), name: strings.TrimPrefix(config.Name, "/")}
+		if c.name == "" || strings.Contains(c.name, "/") {
+			c.name = c.id
+		}
+		if _, err := os.Stat(fsys.logFile(c)); err != nil {
+			continue
+		}
+		found = append(found, c)
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].name < found[j].name })
+	return found, nil
+}
+
+// find gives the container with the name, or with the ID or an ID
+// prefix of at least 12 digits, as docker commands accept.
+func (fsys *FS) find(op string, name string) (container, error) {
+	if !fs.ValidPath(name) {
+		return container{}, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	found, err := fsys.containers()
+	if err != nil {
+		return container{}, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	for _, c := range found {
+		if c.name == name || len(name) >= 12 && strings.HasPrefix(c.id, name) {
+			return c, nil
+		}
+	}
+	return container{}, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+// Open opens a container's log, or the top directory.
+// Log files are *os.File, supporting ReadAt.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	if name == "." {
+		return os.Open(fsys.dir)
+	}
+	c, err := fsys.find("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(fsys.logFile(c))
+}
+
+// Stat describes a container's log, named for the container.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	if name == "." {
+		return os.Stat(fsys.dir)
+	}
+	c, err := fsys.find("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(fsys.logFile(c))
+	if err != nil {
+		return nil, err
+	}
+	return renamed{info, c.name}, nil
+}
+
+// ReadDir lists the containers' logs at the top.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if name != "." {
+		// Containers are files; there are no subdirectories.
+		if _, err := fsys.find("readdir", name); err != nil {
+			return nil, err
+		}
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	found, err := fsys.containers()
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	var entries []fs.DirEntry
+	for _, c := range found {
+		info, err := os.Stat(fsys.logFile(c))
+		if err != nil {
+			continue
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(renamed{info, c.name}))
+	}
+	return entries, nil
+}
+
+// renamed gives a log file's information under the container's name.
+type renamed struct {
+	fs.FileInfo
+	name string
+}
+
+func (r renamed) Name() string {
+	return r.name
+}
+
+// DecodeLine converts a json-file log line to text: the time,
+// the stream, and the logged line, as in
+//
+//	2023-02-17T10:28:24.123456789Z stdout GET /index.html 200
+//
+// Lines that do not decode are returned unchanged.
+func DecodeLine(line string) string {
+	var entry struct {
+		Log    string    `json:"log"`
+		Stream string    `json:"stream"`
+		Time   time.Time `json:"time"`
+	}
+	if json.Unmarshal([]byte(line), &entry) != nil {
+		return line
+	}
+	return fmt.Sprintf("%s %s %s", entry.Time.UTC().Format(time.RFC3339Nano), entry.Stream,
+		strings.TrimSuffix(entry.Log, "\n"))
+}
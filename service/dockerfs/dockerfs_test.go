@@ -0,0 +1,85 @@
+package dockerfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testID = "4f2a9c1e7b3d5a6f8e0c2b4d6a8f0e1c3b5d7f9a1c3e5b7d9f1a3c5e7b9d1f3a"
+
+// containersDir gives a containers directory with one container,
+// "web", plus a container using another log driver.
+func containersDir(t *testing.T) string {
+	dir := t.TempDir()
+	write := func(name string, content string) {
+		p := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(p), 0o755)
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(testID+"/config.v2.json", `{"ID":"`+testID+`","Name":"/web"}`)
+	write(testID+"/"+testID+"-json.log",
+		`{"log":"started\n","stream":"stdout","time":"2023-02-17T10:28:24.5Z"}`+"\n"+
+			`{"log":"GET / 200\n","stream":"stdout","time":"2023-02-17T10:28:25Z"}`+"\n")
+	write("0123456789ab/config.v2.json", `{"Name":"/syslogged"}`)
+	return dir
+}
+
+func TestFS(t *testing.T) {
+	fsys := New(containersDir(t))
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil || len(entries) != 1 || entries[0].Name() != "web" || entries[0].IsDir() {
+		t.Fatalf("expected entry web, got %v, %v", entries, err)
+	}
+	for _, name := range []string{"web", testID[:12]} {
+		info, err := fs.Stat(fsys, name)
+		if err != nil || info.Name() != "web" || !info.Mode().IsRegular() {
+			t.Errorf("%s: expected regular file web, got %v, %v", name, info, err)
+		}
+	}
+	for _, name := range []string{"syslogged", testID[:11], "web/x", "../web"} {
+		if _, err := fs.Stat(fsys, name); err == nil {
+			t.Errorf("%s: expected error, got none", name)
+		}
+	}
+	if _, err := fs.Stat(fsys, "db"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected not exist, got %v", err)
+	}
+
+	f, err := fsys.Open("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, ok := f.(io.ReaderAt); !ok {
+		t.Errorf("expected io.ReaderAt, got %T", f)
+	}
+	b, _ := io.ReadAll(f)
+	if len(b) == 0 || b[0] != '{' {
+		t.Errorf("expected JSON lines, got %q", b)
+	}
+}
+
+func TestDecodeLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{`{"log":"GET / 200\n","stream":"stdout","time":"2023-02-17T10:28:24.123456789Z"}`,
+			"2023-02-17T10:28:24.123456789Z stdout GET / 200"},
+		{`{"log":"panic\n","stream":"stderr","time":"2023-02-17T11:28:24+01:00"}`,
+			"2023-02-17T10:28:24Z stderr panic"},
+		{"not json", "not json"},
+	}
+	for _, test := range tests {
+		if got := DecodeLine(test.line); got != test.expected {
+			t.Errorf("expected %q, got %q", test.expected, got)
+		}
+	}
+}
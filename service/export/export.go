@@ -0,0 +1,251 @@
+// Package export implements /export, which reads a file as /read does
+// and uploads the response to a configured destination, rather than
+// streaming it to the client: "send this log to the case bucket",
+// without routing it through a laptop.  Destinations are named by
+// -export-dest options:
+//
+//	-export-dest cases=s3://support-cases/varlog
+//	-export-dest archive=sftp://backup@archive.example.com/srv/logs
+//	-export-dest intake=https://intake.example.com/upload
+//
+// An S3 destination uses the -s3-endpoint and -s3-region settings and
+// the standard AWS credential variables; SFTP runs the sftp command,
+// so keys come from the service user's ssh configuration; an HTTP
+// destination gets a PUT.  The upload is named
+// PREFIX/JOB/FILENAME: the destination's path, the job ID, and the
+// 'filename' parameter or the file's base name.
+//
+// POST /export takes the /read parameters and 'dest=name'.  The read
+// runs as a job (see package jobs), so the response, 202 Accepted,
+// gives the job at once, with a Location of /jobs/ID; the job's result
+// is the uploaded object's URL.
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"varlog/service/app"
+	"varlog/service/jobs"
+	"varlog/service/read"
+	"varlog/service/s3fs"
+)
+
+// The job kind of exports.
+const kind = "export"
+
+// SFTPCommand runs sftp.  Tests substitute a script.
+var SFTPCommand = "sftp"
+
+var (
+	mutex        sync.Mutex
+	destinations map[string]*url.URL // Name => destination
+)
+
+// Setup loads the destinations named by the properties, replacing
+// any loaded before.
+func Setup(props *app.Properties) error {
+	loaded := map[string]*url.URL{}
+	for _, value := range props.ExportDests() {
+		name, dest, found := strings.Cut(value, "=")
+		if !found || !strings.Contains(value, "://") {
+			return errors.New(fmt.Sprintf("destination %q not name=URL", value))
+		}
+		if _, ok := loaded[name]; ok {
+			return errors.New(fmt.Sprintf("destination name %q repeated", name))
+		}
+		u, err := url.Parse(dest)
+		if err != nil || u.Host == "" {
+			return errors.New(fmt.Sprintf("destination %q URL %q invalid", name, dest))
+		}
+		switch u.Scheme {
+		case "s3", "sftp", "http", "https":
+		default:
+			return errors.New(fmt.Sprintf("destination %q scheme %q not s3, sftp, http, or https", name, u.Scheme))
+		}
+		loaded[name] = u
+	}
+	mutex.Lock()
+	destinations = loaded
+	mutex.Unlock()
+	return nil
+}
+
+// lookup gives the destination of the name.
+func lookup(name string) (*url.URL, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	u, ok := destinations[name]
+	return u, ok
+}
+
+// Handler serves /export.  Parameters are checked, and the file's
+// access, before the job starts, so those errors are reported at once.
+func Handler(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		writer.Header().Set("Allow", "POST")
+		app.Error(writer, request, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	base := app.RequestProperties(request)
+	props := base.Copy()
+	if err := props.ExtractParams(request); err != nil {
+		app.WriteError(writer, request, err)
+		return
+	}
+	dest, ok := lookup(props.ParamDest())
+	if !ok {
+		app.WriteError(writer, request, app.ParamError(app.ParamDest,
+			fmt.Sprintf("Destination %s=%q not configured", app.ParamDest, props.ParamDest())))
+		return
+	}
+	if app.Denied(props.RelativePath()) {
+		app.Log(app.LogWarning, "Denied path %q requested", props.RelativePath())
+		app.Error(writer, request, "Not found", http.StatusNotFound)
+		return
+	}
+	if !props.Authorized(props.RelativePath()) {
+		app.Log(app.LogWarning, "Principal %q not authorized for %q", props.Principal(), props.RelativePath())
+		app.Error(writer, request, "Access denied", http.StatusForbidden)
+		return
+	}
+	info, err := app.Stat(props.FileSystem(), props.RootedPath())
+	if err != nil {
+		app.WriteError(writer, request, app.FileError(props.RelativePath(), err))
+		return
+	}
+	if !info.Mode().IsRegular() {
+		app.WriteError(writer, request, app.ParamError(app.ParamName,
+			fmt.Sprintf("Export of %q, not a regular file, not allowed", props.RelativePath())))
+		return
+	}
+
+	// The read gets the request's parameters, less the destination.
+	params := url.Values{}
+	for key, values := range request.Form {
+		if key != app.ParamDest {
+			params[key] = values
+		}
+	}
+	filename := props.ParamFilename()
+	if filename == "" {
+		filename = path.Base(props.RelativePath())
+	}
+	job, err := jobs.Start(kind, props.Principal(), func(ctx context.Context, id string) (string, error) {
+		read, err := jobs.Request(ctx, request, "/read?"+params.Encode())
+		if err != nil {
+			return "", err
+		}
+		return export(id, read, dest, path.Join(id, filename))
+	})
+	if err != nil {
+		writer.Header().Set(app.HdrRetryAfter, app.RetryAfterSeconds())
+		app.WriteError(writer, request, err)
+		return
+	}
+	writer.Header().Set("Location", base.BaseURLPath()+"/jobs/"+job.ID)
+	jobs.Write(writer, http.StatusAccepted, job)
+}
+
+// export serves the read request, job id, into a temporary file, then
+// uploads it to the destination as the name, giving the upload's URL.
+func export(id string, request *http.Request, dest *url.URL, name string) (string, error) {
+	file, err := os.CreateTemp("", "varlog-export-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	// The read scans as a /read would, so it holds a read slot; the
+	// upload, which does not touch the logs, does not.
+	if err := jobs.AcquireRead(request.Context()); err != nil {
+		return "", err
+	}
+	output := jobs.NewOutput(id, file)
+	read.Handler(output, request)
+	app.ReleaseRead()
+	if err := output.Check(); err != nil {
+		return "", errors.New("read failed, " + err.Error())
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	// The upload's progress is the bytes sent, of the file's size.
+	body := &progressReader{id: id, reader: file, total: output.Size()}
+	return upload(request.Context(), app.RequestProperties(request), body, dest, name)
+}
+
+// upload sends the body to the destination as the name, giving the
+// upload's URL.  The properties are the request's.
+func upload(ctx context.Context, props *app.Properties, body *progressReader, dest *url.URL, name string) (string, error) {
+	file, size := body.reader, body.total
+	target := *dest
+	target.Path = path.Join("/", dest.Path, name)
+	switch dest.Scheme {
+	case "s3":
+		fsys, err := s3fs.New(s3fs.Config{Endpoint: props.S3Endpoint(), Region: props.S3Region()})
+		if err != nil {
+			return "", err
+		}
+		return target.Redacted(), fsys.Put(ctx, target.Host+target.Path, body, size)
+
+	case "sftp":
+		host := target.Host
+		if target.User != nil {
+			host = target.User.Username() + "@" + target.Hostname()
+		}
+		args := []string{"-b", "-", "-o", "BatchMode=yes"}
+		if target.Port() != "" {
+			args = append(args, "-P", target.Port())
+		}
+		// A leading '-' lets the batch go on if the directory exists.
+		batch := fmt.Sprintf("-mkdir %q\nput %q %q\n", path.Dir(target.Path), file.Name(), target.Path)
+		cmd := exec.CommandContext(ctx, SFTPCommand, append(args, host)...)
+		cmd.Stdin = strings.NewReader(batch)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return "", errors.New(fmt.Sprintf("sftp to %s failed, %s: %s", host, err, strings.TrimSpace(string(output))))
+		}
+		return target.Redacted(), nil
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPut, target.String(), body)
+	if err != nil {
+		return "", err
+	}
+	request.ContentLength = size
+	request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return "", errors.New(fmt.Sprintf("PUT %s: %s", target.Redacted(), response.Status))
+	}
+	return target.Redacted(), nil
+}
+
+// progressReader reads a job's upload, recording the bytes read as
+// its progress.
+type progressReader struct {
+	id     string
+	reader *os.File
+	total  int64
+	done   int64
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.reader.Read(b)
+	r.done += int64(n)
+	jobs.Progress(r.id, r.done, r.total)
+	return n, err
+}
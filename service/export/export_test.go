@@ -0,0 +1,155 @@
+package export
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"varlog/service/app"
+	"varlog/service/apptest"
+	"varlog/service/jobs"
+)
+
+// post serves a POST /export with the form parameters.
+func post(props *app.Properties, params url.Values) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/export", strings.NewReader(params.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return apptest.Serve(Handler, props, request)
+}
+
+// await polls the job until it ends.
+func await(t *testing.T, id string) jobs.Job {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		job, ok := jobs.Get(id)
+		if !ok {
+			t.Fatalf("job %s not found", id)
+		}
+		if job.Status != jobs.StatusRunning {
+			return job
+		}
+	}
+	t.Fatalf("job %s still running", id)
+	return jobs.Job{}
+}
+
+func TestExport(t *testing.T) {
+	var mutex sync.Mutex
+	uploads := map[string]string{}
+	remote := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPut {
+			http.Error(writer, "PUT only", http.StatusMethodNotAllowed)
+			return
+		}
+		body, _ := io.ReadAll(request.Body)
+		mutex.Lock()
+		uploads[request.URL.Path] = string(body)
+		mutex.Unlock()
+		writer.WriteHeader(http.StatusCreated)
+	}))
+	defer remote.Close()
+
+	// The sftp script records its arguments and batch.
+	dir := t.TempDir()
+	script := filepath.Join(dir, "sftp")
+	err := os.WriteFile(script, []byte("#!/bin/sh\necho \"$@\" > "+dir+"/args\ncat > "+dir+"/batch\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := SFTPCommand
+	SFTPCommand = script
+	defer func() { SFTPCommand = saved }()
+
+	tree := apptest.NewTree().File("app.log", "one", "two ERROR", "three ERROR").Dir("nginx")
+	props := apptest.Properties(tree.MapFS("/var/log"), "/var/log")
+	props.SetExportDests([]string{
+		"intake=" + remote.URL + "/upload",
+		"archive=sftp://backup@archive.example.com:2222/srv/logs",
+	})
+	if err := Setup(props); err != nil {
+		t.Fatalf("Setup: %s", err)
+	}
+
+	recorder := post(props, url.Values{"name": {"app.log"}, "filter": {"ERROR"}, "dest": {"intake"}})
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d %s", recorder.Code, recorder.Body)
+	}
+	job := await(t, strings.TrimPrefix(recorder.Header().Get("Location"), "/jobs/"))
+	expected := remote.URL + "/upload/" + job.ID + "/app.log"
+	if job.Status != jobs.StatusDone || job.Result != expected {
+		t.Fatalf("expected done with %s, got %+v", expected, job)
+	}
+	if got := uploads["/upload/"+job.ID+"/app.log"]; got != "three ERROR\ntwo ERROR\n" {
+		t.Errorf("expected the matching lines uploaded, newest first, got %q", got)
+	}
+
+	recorder = post(props, url.Values{"name": {"app.log"}, "dest": {"archive"}, "filename": {"case.log"}})
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d %s", recorder.Code, recorder.Body)
+	}
+	job = await(t, strings.TrimPrefix(recorder.Header().Get("Location"), "/jobs/"))
+	if job.Status != jobs.StatusDone || job.Result != "sftp://backup@archive.example.com:2222/srv/logs/"+job.ID+"/case.log" {
+		t.Fatalf("unexpected sftp job %+v", job)
+	}
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	if got := strings.TrimSpace(string(args)); got != "-b - -o BatchMode=yes -P 2222 backup@archive.example.com" {
+		t.Errorf("unexpected sftp arguments %q", got)
+	}
+	batch, _ := os.ReadFile(filepath.Join(dir, "batch"))
+	if !strings.Contains(string(batch), `put "`) || !strings.Contains(string(batch), `"/srv/logs/`+job.ID+`/case.log"`) {
+		t.Errorf("unexpected sftp batch %q", batch)
+	}
+
+	// The job reads as /read would, so it fails in maintenance mode,
+	// even when started beforehand.
+	app.SetMaintenance(true)
+	recorder = post(props, url.Values{"name": {"app.log"}, "dest": {"intake"}})
+	if recorder.Code != http.StatusAccepted {
+		app.SetMaintenance(false)
+		t.Fatalf("expected 202, got %d %s", recorder.Code, recorder.Body)
+	}
+	job = await(t, strings.TrimPrefix(recorder.Header().Get("Location"), "/jobs/"))
+	app.SetMaintenance(false)
+	if job.Status != jobs.StatusFailed || !strings.Contains(job.Error, "maintenance") {
+		t.Errorf("maintenance mode: expected failed, got %+v", job)
+	}
+
+	for _, test := range []struct {
+		params url.Values
+		code   int
+	}{
+		{url.Values{"name": {"app.log"}, "dest": {"missing"}}, http.StatusBadRequest},
+		{url.Values{"name": {"app.log"}}, http.StatusBadRequest},
+		{url.Values{"name": {"nginx"}, "dest": {"intake"}}, http.StatusBadRequest},
+		{url.Values{"name": {"gone.log"}, "dest": {"intake"}}, http.StatusNotFound},
+	} {
+		if recorder := post(props, test.params); recorder.Code != test.code {
+			t.Errorf("%v: expected %d, got %d %s", test.params, test.code, recorder.Code, recorder.Body)
+		}
+	}
+	recorder = apptest.Serve(Handler, props, apptest.Request("/export", "name", "app.log", "dest", "intake"))
+	if recorder.Code != http.StatusMethodNotAllowed || recorder.Header().Get("Allow") != "POST" {
+		t.Errorf("GET: expected 405 allowing POST, got %d", recorder.Code)
+	}
+}
+
+func TestSetup(t *testing.T) {
+	for _, dests := range [][]string{
+		{"intake"},
+		{"intake=/upload"},
+		{"intake=ftp://example.com/"},
+		{"a=https://example.com/", "a=https://example.org/"},
+	} {
+		props := app.DefaultProperties()
+		props.SetExportDests(dests)
+		if err := Setup(props); err == nil {
+			t.Errorf("%q: expected an error", dests)
+		}
+	}
+}
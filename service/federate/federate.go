@@ -0,0 +1,527 @@
+// Package federate implements /fleet/list and /fleet/read, which fan a
+// /list or /read out to peer servers and merge the responses, each
+// entry or line tagged with its host: one pane over a small fleet,
+// without a log aggregation stack.  Peers are named by -peer options:
+//
+//	-peer web1=http://web1:8000 -peer web2=https://web2:8443/varlog
+//
+// A peer's URL includes any -base-path.  Requests carry the client's
+// Authorization header, so peers sharing credentials authorize the
+// client as they would directly; a fleet server listing itself as a
+// peer includes its own logs.  The parameters are passed on as given.
+//
+// /fleet/read merges the peers' lines newest first, by their leading
+// timestamps; lines without one keep their place after the line before
+// them, and a peer's lines without any follow the others.  With
+// 'count', each peer gives that many lines, and the merge keeps that
+// many.  A peer that fails or times out is left out, its error given in
+// an X-Varlog-Peer-Error header, or for /fleet/list, in the response.
+package federate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"varlog/service/app"
+	"varlog/service/scan"
+)
+
+const (
+	// Longest a peer may take to respond.
+	peerTimeout = 30 * time.Second
+
+	// Longest a peer may take to answer a health check, or DNS a lookup.
+	checkTimeout = 5 * time.Second
+
+	// Most bytes read from one peer's response.
+	maxPeerBytes = 64 << 20
+)
+
+// A peer server.
+type peer struct {
+	name string
+	url  *url.URL
+	down error // Why the last health check failed, nil if it passed or none ran
+}
+
+var (
+	mutex    sync.Mutex
+	static   []peer        // From -peer options, in order
+	peerFile string        // -peer-file, empty for none
+	peerSRV  string        // -peer-srv, empty for none
+	interval time.Duration // -peer-refresh
+	filed    []peer        // From the peer file, as last read
+	found    []peer        // From DNS, as last looked up
+	peers    []peer        // All of them: static, filed, then found
+)
+
+// Looks up DNS SRV records.  Tests substitute their own.
+var lookupSRV = net.DefaultResolver.LookupSRV
+
+// Sends requests to peers.  Tests substitute one trusting their servers.
+var client = http.DefaultClient
+
+// Setup loads the peers named by the properties, replacing any loaded
+// before, and discovers those of the -peer-file and -peer-srv.  An
+// invalid peer file is an error; a failed DNS lookup is retried by
+// Watch.  Peers count as healthy until checked.
+func Setup(props *app.Properties) error {
+	var loaded []peer
+	for _, value := range props.Peers() {
+		p, err := parsePeer(value)
+		if err != nil {
+			return err
+		}
+		loaded = append(loaded, p)
+	}
+	if err := checkNames(loaded); err != nil {
+		return err
+	}
+	if srv := props.PeerSRV(); srv != "" {
+		if _, _, err := splitSRV(srv); err != nil {
+			return err
+		}
+	}
+	mutex.Lock()
+	static, peerFile, peerSRV, interval = loaded, props.PeerFile(), props.PeerSRV(), props.PeerRefresh()
+	filed, found, peers = nil, nil, loaded
+	mutex.Unlock()
+	if peerFile == "" && peerSRV == "" {
+		return nil
+	}
+	return discover(context.Background(), false)
+}
+
+// parsePeer converts a name=URL value into a peer.
+func parsePeer(value string) (peer, error) {
+	name, target, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return peer{}, errors.New(fmt.Sprintf("peer %q not name=URL", value))
+	}
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return peer{}, errors.New(fmt.Sprintf("peer %q URL %q not http or https", name, target))
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return peer{name: name, url: u}, nil
+}
+
+// checkNames reports a name given twice.
+func checkNames(list []peer) error {
+	seen := map[string]bool{}
+	for _, p := range list {
+		if seen[p.name] {
+			return errors.New(fmt.Sprintf("peer name %q repeated", p.name))
+		}
+		seen[p.name] = true
+	}
+	return nil
+}
+
+// Enabled reports whether peers are configured, by any means.
+func Enabled() bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return len(static) > 0 || peerFile != "" || peerSRV != ""
+}
+
+// Watch rediscovers the peers and checks their health every
+// -peer-refresh, until the context is canceled.  For the server's
+// background tasks.
+func Watch(ctx context.Context) error {
+	mutex.Lock()
+	every := interval
+	mutex.Unlock()
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		if err := discover(ctx, true); err != nil {
+			app.Log(app.LogWarning, "%s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reload rereads the peer file and checks the peers, reporting an
+// invalid file, for the server's reload hooks.
+func Reload(ctx context.Context) error {
+	return discover(ctx, true)
+}
+
+// discover rereads the peer file and looks up the SRV records, then,
+// when checking, checks each peer's /health.  A source that fails
+// keeps the peers it gave last; the error reports the failure.
+func discover(ctx context.Context, check bool) error {
+	mutex.Lock()
+	fileName, srvName := peerFile, peerSRV
+	mutex.Unlock()
+
+	var err error
+	var fromFile, fromDNS []peer
+	fileOK, dnsOK := fileName != "", srvName != ""
+	if fileOK {
+		if fromFile, err = readPeerFile(fileName); err != nil {
+			fileOK = false
+			err = errors.New(fmt.Sprintf("peer file %s invalid, %s", fileName, err))
+		}
+	}
+	if dnsOK {
+		var dnsErr error
+		if fromDNS, dnsErr = lookupPeers(ctx, srvName); dnsErr != nil {
+			dnsOK = false
+			app.Log(app.LogWarning, "Peer lookup of %s failed, %s", srvName, dnsErr)
+		}
+	}
+
+	mutex.Lock()
+	if fileOK {
+		filed = fromFile
+	}
+	if dnsOK {
+		found = fromDNS
+	}
+	list := combine(static, filed, found)
+	mutex.Unlock()
+
+	if check {
+		var wait sync.WaitGroup
+		for j := range list {
+			wait.Add(1)
+			go func(p *peer) {
+				defer wait.Done()
+				p.down = checkHealth(ctx, p)
+			}(&list[j])
+		}
+		wait.Wait()
+	}
+	mutex.Lock()
+	for _, p := range list {
+		was := lookupPeer(p.name)
+		switch {
+		case p.down != nil && (was == nil || was.down == nil):
+			app.Log(app.LogWarning, "Peer %s excluded, %s", p.name, p.down)
+		case p.down == nil && was != nil && was.down != nil:
+			app.Log(app.LogInfo, "Peer %s healthy again", p.name)
+		}
+	}
+	peers = list
+	mutex.Unlock()
+	return err
+}
+
+// combine joins the peer lists, leaving out later peers of a name
+// already given, into a new list.
+func combine(lists ...[]peer) []peer {
+	var all []peer
+	seen := map[string]bool{}
+	for _, list := range lists {
+		for _, p := range list {
+			if !seen[p.name] {
+				seen[p.name] = true
+				p.down = nil
+				all = append(all, p)
+			}
+		}
+	}
+	return all
+}
+
+// lookupPeer gives the current peer of the name, or nil.  The mutex
+// must be held.
+func lookupPeer(name string) *peer {
+	for j := range peers {
+		if peers[j].name == name {
+			return &peers[j]
+		}
+	}
+	return nil
+}
+
+// readPeerFile reads name=URL lines, skipping blank lines and
+// comments.
+func readPeerFile(name string) ([]peer, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var list []peer
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		p, err := parsePeer(line)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("line %d: %s", lineNumber, err))
+		}
+		list = append(list, p)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return list, checkNames(list)
+}
+
+// splitSRV gives the scheme and name of the -peer-srv value, the name
+// optionally after https://.  Peers are sent the clients' credentials,
+// and whoever answers for the name in DNS picks them, so only https,
+// verifying their certificates, is allowed.
+func splitSRV(srv string) (scheme string, name string, err error) {
+	scheme, name, ok := strings.Cut(srv, "://")
+	if !ok {
+		return "https", srv, nil
+	}
+	if scheme != "https" {
+		return "", "", errors.New(fmt.Sprintf("peer SRV %q scheme %q not https", srv, scheme))
+	}
+	return scheme, name, nil
+}
+
+// lookupPeers gives the peers of the SRV name, optionally after a
+// scheme, as https://_varlog._tcp.example.com.  Each is named by its
+// host, with the port when a host has several, and sorted by name.
+func lookupPeers(ctx context.Context, srv string) ([]peer, error) {
+	scheme, name, err := splitSRV(srv)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	_, records, err := lookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, err
+	}
+	hosts := map[string]int{}
+	for _, r := range records {
+		hosts[strings.TrimSuffix(r.Target, ".")]++
+	}
+	var list []peer
+	for _, r := range records {
+		host := strings.TrimSuffix(r.Target, ".")
+		address := net.JoinHostPort(host, strconv.Itoa(int(r.Port)))
+		name := host
+		if hosts[host] > 1 {
+			name = address
+		}
+		list = append(list, peer{name: name, url: &url.URL{Scheme: scheme, Host: address}})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	return list, nil
+}
+
+// checkHealth gets the peer's /health, giving why it failed, or nil.
+func checkHealth(ctx context.Context, p *peer) error {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url.String()+"/health", nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return errors.New("health check failed, " + err.Error())
+	}
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return errors.New("health check gave " + response.Status)
+	}
+	return nil
+}
+
+// A peer's response, or its error.
+type response struct {
+	peer string
+	body []byte
+	err  error
+}
+
+// fanOut sends the request's endpoint and parameters to every peer,
+// giving the responses in peer order.
+func fanOut(request *http.Request, endpoint string) []response {
+	mutex.Lock()
+	targets := peers
+	mutex.Unlock()
+	responses := make([]response, len(targets))
+	var wait sync.WaitGroup
+	for j, p := range targets {
+		if p.down != nil {
+			responses[j] = response{peer: p.name, err: errors.New("excluded, " + p.down.Error())}
+			continue
+		}
+		wait.Add(1)
+		go func(j int, p peer) {
+			defer wait.Done()
+			body, err := fetch(request, p, endpoint)
+			responses[j] = response{peer: p.name, body: body, err: err}
+		}(j, p)
+	}
+	wait.Wait()
+	return responses
+}
+
+// fetch gets the endpoint from the peer, with the request's parameters.
+func fetch(request *http.Request, p peer, endpoint string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(request.Context(), peerTimeout)
+	defer cancel()
+	target := *p.url
+	target.Path += endpoint
+	target.RawQuery = request.URL.RawQuery
+	out, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if auth := request.Header.Get("Authorization"); auth != "" {
+		out.Header.Set("Authorization", auth)
+	}
+	if id := app.RequestID(request.Context()); id != "" {
+		out.Header.Set(app.HdrRequestID, id)
+	}
+	reply, err := client.Do(out)
+	if err != nil {
+		return nil, err
+	}
+	defer reply.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(reply.Body, maxPeerBytes))
+	if err != nil {
+		return nil, err
+	}
+	if reply.StatusCode != http.StatusOK {
+		var envelope struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(body, &envelope) == nil && envelope.Error.Message != "" {
+			return nil, errors.New(fmt.Sprintf("%s, %s", reply.Status, envelope.Error.Message))
+		}
+		return nil, errors.New(reply.Status)
+	}
+	return body, nil
+}
+
+// A listed entry, tagged with its host.
+type entry struct {
+	Host string `json:"host"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// ListHandler serves /fleet/list.
+func ListHandler(writer http.ResponseWriter, request *http.Request) {
+	result := struct {
+		Entries []entry           `json:"entries"` // By name, then host
+		Errors  map[string]string `json:"errors"`  // Peer => error, for peers left out
+	}{Entries: []entry{}, Errors: map[string]string{}}
+	for _, r := range fanOut(request, "/list") {
+		var entries []entry
+		err := r.err
+		if err == nil {
+			err = json.Unmarshal(r.body, &entries)
+		}
+		if err != nil {
+			app.Log(app.LogWarning, "Peer %s /list failed, %s", r.peer, err)
+			result.Errors[r.peer] = err.Error()
+			continue
+		}
+		for _, e := range entries {
+			e.Host = r.peer
+			result.Entries = append(result.Entries, e)
+		}
+	}
+	sort.SliceStable(result.Entries, func(i, j int) bool { return result.Entries[i].Name < result.Entries[j].Name })
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(result)
+}
+
+// A peer's lines, newest first, for merging.
+type stream struct {
+	peer  string
+	lines []string
+	times []time.Time // Each line's time, that of the line before if none
+}
+
+// ReadHandler serves /fleet/read.
+func ReadHandler(writer http.ResponseWriter, request *http.Request) {
+	count := 0 // No cap
+	if value := request.URL.Query().Get(app.ParamCount); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			app.WriteError(writer, request, app.ParamError(app.ParamCount, "expected an integer"))
+			return
+		}
+		if n > 0 {
+			count = n
+		}
+	}
+	if follow, _ := strconv.ParseBool(request.URL.Query().Get(app.ParamFollow)); follow {
+		app.WriteError(writer, request, app.ParamError(app.ParamFollow, "not supported across peers"))
+		return
+	}
+	normalizer := &scan.TimestampNormalizer{Location: time.Local}
+	var streams []*stream
+	for _, r := range fanOut(request, "/read") {
+		if r.err != nil {
+			app.Log(app.LogWarning, "Peer %s /read failed, %s", r.peer, r.err)
+			writer.Header().Add(app.HdrPeerError, r.peer+": "+r.err.Error())
+			continue
+		}
+		s := &stream{peer: r.peer}
+		var last time.Time
+		scanner := bufio.NewScanner(bytes.NewReader(r.body))
+		scanner.Buffer(nil, maxPeerBytes)
+		for scanner.Scan() {
+			if t, ok := normalizer.Time(scanner.Text()); ok {
+				last = t
+			}
+			s.lines = append(s.lines, scanner.Text())
+			s.times = append(s.times, last)
+		}
+		// Lines before the first timestamp take its time.
+		first := 0
+		for first < len(s.times) && s.times[first].IsZero() {
+			first++
+		}
+		for j := 0; j < first && first < len(s.times); j++ {
+			s.times[j] = s.times[first]
+		}
+		streams = append(streams, s)
+	}
+
+	// Merge: take the newest head, the first stream on ties.
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	out := bufio.NewWriter(writer)
+	defer out.Flush()
+	for written := 0; count == 0 || written < count; written++ {
+		var next *stream
+		for _, s := range streams {
+			if len(s.lines) > 0 && (next == nil || s.times[0].After(next.times[0])) {
+				next = s
+			}
+		}
+		if next == nil {
+			break
+		}
+		fmt.Fprintf(out, "%s: %s\n", next.peer, next.lines[0])
+		next.lines, next.times = next.lines[1:], next.times[1:]
+	}
+}
@@ -0,0 +1,214 @@
+package federate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"varlog/service/app"
+	"varlog/service/apptest"
+)
+
+// newPeer serves /list and /read with the entries and lines, checking
+// the client's credentials are passed on, and /health.
+func newPeer(t *testing.T, names []string, lines ...string) *httptest.Server {
+	ts := httptest.NewServer(peerHandler(names, lines...))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// peerHandler serves a peer for newPeer.
+func peerHandler(names []string, lines ...string) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/health" {
+			fmt.Fprintln(writer, "OK")
+			return
+		}
+		if request.Header.Get("Authorization") != "Bearer secret" {
+			app.Error(writer, request, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+		switch request.URL.Path {
+		case "/list":
+			var entries []string
+			for _, name := range names {
+				entries = append(entries, fmt.Sprintf(`{"name":%q,"type":"file"}`, name))
+			}
+			fmt.Fprintf(writer, "[%s]", strings.Join(entries, ","))
+		case "/read":
+			if request.URL.Query().Get("name") != "app.log" {
+				app.Error(writer, request, "Not found", http.StatusNotFound)
+				return
+			}
+			for _, line := range lines {
+				fmt.Fprintln(writer, line)
+			}
+		}
+	})
+}
+
+func TestFederate(t *testing.T) {
+	web1 := newPeer(t, []string{"app.log", "syslog"},
+		"2026-10-15T10:05:00Z web1 late", "  continued", "2026-10-15T10:01:00Z web1 early")
+	web2 := newPeer(t, []string{"app.log"},
+		"2026-10-15T10:03:00Z web2 middle", "2026-10-15T10:00:00Z web2 first")
+	props := app.DefaultProperties()
+	props.SetPeers([]string{"web1=" + web1.URL, "web2=" + web2.URL + "/", "down=http://127.0.0.1:1"})
+	if err := Setup(props); err != nil {
+		t.Fatalf("Setup: %s", err)
+	}
+	defer func() {
+		props.SetPeers(nil)
+		Setup(props)
+	}()
+	serve := func(handler http.HandlerFunc, target string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		request.Header.Set("Authorization", "Bearer secret")
+		return apptest.Serve(handler, props, request)
+	}
+
+	recorder := serve(ListHandler, "/fleet/list")
+	var listed struct {
+		Entries []entry
+		Errors  map[string]string
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("%s: %s", recorder.Body, err)
+	}
+	expected := []entry{{"web1", "app.log", "file"}, {"web2", "app.log", "file"}, {"web1", "syslog", "file"}}
+	if fmt.Sprint(listed.Entries) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, listed.Entries)
+	}
+	if len(listed.Errors) != 1 || listed.Errors["down"] == "" {
+		t.Errorf("expected an error for down, got %v", listed.Errors)
+	}
+
+	recorder = serve(ReadHandler, "/fleet/read?name=app.log")
+	want := "web1: 2026-10-15T10:05:00Z web1 late\n" +
+		"web1:   continued\n" +
+		"web2: 2026-10-15T10:03:00Z web2 middle\n" +
+		"web1: 2026-10-15T10:01:00Z web1 early\n" +
+		"web2: 2026-10-15T10:00:00Z web2 first\n"
+	if recorder.Code != http.StatusOK || recorder.Body.String() != want {
+		t.Errorf("expected merged lines\n%s\ngot %d\n%s", want, recorder.Code, recorder.Body)
+	}
+	if got := recorder.Header().Values(app.HdrPeerError); len(got) != 1 || !strings.HasPrefix(got[0], "down: ") {
+		t.Errorf("expected an error header for down, got %q", got)
+	}
+	recorder = serve(ReadHandler, "/fleet/read?name=app.log&count=2")
+	if lines := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n"); len(lines) != 2 {
+		t.Errorf("count=2: expected 2 lines, got %q", lines)
+	}
+	recorder = serve(ReadHandler, "/fleet/read?name=other.log")
+	if got := recorder.Header().Values(app.HdrPeerError); len(got) != 3 || !strings.Contains(got[0], "404") {
+		t.Errorf("expected every peer's error, got %q", got)
+	}
+	if recorder := serve(ReadHandler, "/fleet/read?name=app.log&follow=true"); recorder.Code != http.StatusBadRequest {
+		t.Errorf("follow: expected 400, got %d", recorder.Code)
+	}
+
+	for _, peers := range [][]string{{"web1"}, {"web1=ftp://web1/"}, {"a=http://a", "a=http://b"}} {
+		props.SetPeers(peers)
+		if err := Setup(props); err == nil {
+			t.Errorf("%q: expected an error", peers)
+		}
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	healthy := newPeer(t, []string{"app.log"})
+	sick := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		http.Error(writer, "draining", http.StatusServiceUnavailable)
+	}))
+	defer sick.Close()
+	file := filepath.Join(t.TempDir(), "peers")
+	if err := os.WriteFile(file, []byte("# Web tier\nweb1="+healthy.URL+"\n\nweb2="+sick.URL+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Peers found through DNS must use https.
+	secure := httptest.NewTLSServer(peerHandler([]string{"app.log"}))
+	defer secure.Close()
+	defer func(saved *http.Client) { client = saved }(client)
+	client = secure.Client()
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(secure.URL, "https://"))
+	portNumber, _ := strconv.Atoi(port)
+	defer func(saved func(context.Context, string, string, string) (string, []*net.SRV, error)) {
+		lookupSRV = saved
+	}(lookupSRV)
+	lookupSRV = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		if name != "_varlog._tcp.example.com" {
+			return "", nil, fmt.Errorf("no such host %s", name)
+		}
+		return "", []*net.SRV{{Target: host + ".", Port: uint16(portNumber)}}, nil
+	}
+
+	props := app.DefaultProperties()
+	props.SetPeerFile(file)
+	props.SetPeerSRV("_varlog._tcp.example.com")
+	props.SetPeerRefresh(time.Hour)
+	if err := Setup(props); err != nil {
+		t.Fatalf("Setup: %s", err)
+	}
+	defer func() {
+		props.SetPeerFile("")
+		props.SetPeerSRV("")
+		Setup(props)
+	}()
+	names := func() string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		var list []string
+		for _, p := range peers {
+			if p.down != nil {
+				list = append(list, p.name+"(down)")
+			} else {
+				list = append(list, p.name)
+			}
+		}
+		return strings.Join(list, " ")
+	}
+	if got := names(); !Enabled() || got != "web1 web2 "+host {
+		t.Errorf("expected the file's then DNS's peers, got %q", got)
+	}
+
+	if err := Reload(context.Background()); err != nil {
+		t.Fatalf("Reload: %s", err)
+	}
+	if got := names(); got != "web1 web2(down) "+host {
+		t.Errorf("expected web2 excluded, got %q", got)
+	}
+	request := httptest.NewRequest(http.MethodGet, "/fleet/list", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	recorder := apptest.Serve(ListHandler, props, request)
+	if got := recorder.Body.String(); !strings.Contains(got, `"web2":"excluded, health check gave 503`) {
+		t.Errorf("expected web2 reported excluded, got %s", got)
+	}
+
+	// A broken file keeps the peers read before.
+	os.WriteFile(file, []byte("web1\n"), 0600)
+	if err := Reload(context.Background()); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("expected the bad line reported, got %v", err)
+	}
+	if got := names(); got != "web1 web2(down) "+host {
+		t.Errorf("expected the peers kept, got %q", got)
+	}
+	if err := Setup(props); err == nil {
+		t.Errorf("Setup: expected the bad file reported")
+	}
+
+	props.SetPeerFile("")
+	for _, srv := range []string{"http://_varlog._tcp.example.com", "ftp://_varlog._tcp.example.com"} {
+		props.SetPeerSRV(srv)
+		if err := Setup(props); err == nil || !strings.Contains(err.Error(), "not https") {
+			t.Errorf("%s: expected the scheme refused, got %v", srv, err)
+		}
+	}
+}
@@ -0,0 +1,379 @@
+// Package index keeps line-offset indexes of log files, so /read can
+// answer some questions without scanning: how many lines a file has,
+// and roughly where in it a time falls.  Each index is a sidecar file
+// in a cache directory, named for the hash of the file's path, holding:
+//
+//   - The file's size, modification time, and a digest of its first
+//     bytes, to tell when the index is stale.
+//   - The line count, and a checkpoint of the offset of every
+//     checkpointLines-th line.
+//   - Samples of the first timestamp after every sampleBytes bytes,
+//     bracketing the binary search for since and until.
+//
+// Indexes are refreshed lazily: a lookup of a stale or missing index
+// starts a refresh in the background and reports no index, so the
+// request scans as it would without one.  A file that only grew, as
+// logs do, is indexed from where the last refresh ended.
+package index
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"varlog/service/app"
+)
+
+const (
+	// Version of the sidecar format.  Sidecars of other versions are
+	// rebuilt.
+	version = 1
+
+	// Lines between checkpoints.
+	checkpointLines = 10000
+
+	// Bytes between timestamp samples.
+	sampleBytes = 1024 * 1024
+
+	// Bytes at the start of a file whose digest identifies it, so a
+	// file replaced by rotation is not taken to have grown.
+	headBytes = 4096
+
+	// Most indexes held in memory.  When full, the oldest is dropped;
+	// the sidecar remains.
+	memorySize = 256
+
+	// Refreshes running at once.
+	workers = 2
+)
+
+// A Checkpoint gives the offset of the start of a line.
+type Checkpoint struct {
+	Line   int64 `json:"line"` // Zero for the first line
+	Offset int64 `json:"offset"`
+}
+
+// A Sample gives the offset of a line and its timestamp.
+type Sample struct {
+	Time   time.Time `json:"time"`
+	Offset int64     `json:"offset"`
+}
+
+// An Index describes a file's lines as of its size and modification
+// time.
+type Index struct {
+	Version     int          `json:"version"`
+	Path        string       `json:"path"`
+	Size        int64        `json:"size"`
+	ModTime     time.Time    `json:"modTime"`
+	Head        string       `json:"head"`     // Digest of the first headBytes
+	Lines       int64        `json:"lines"`    // Lines, counting an unterminated last line
+	Newlines    int64        `json:"newlines"` // Newlines, to resume counting
+	Checkpoints []Checkpoint `json:"checkpoints"`
+	Samples     []Sample     `json:"samples"`
+	NextSample  int64        `json:"nextSample"` // Offset after which to sample next
+}
+
+// Fresh reports whether the index describes the file as it is.
+func (x *Index) Fresh(info fs.FileInfo) bool {
+	return x.Size == info.Size() && x.ModTime.Equal(info.ModTime())
+}
+
+// Checkpoint gives the last checkpoint at or before the line.
+func (x *Index) Checkpoint(line int64) Checkpoint {
+	i := sort.Search(len(x.Checkpoints), func(i int) bool { return x.Checkpoints[i].Line > line })
+	if i == 0 {
+		return Checkpoint{}
+	}
+	return x.Checkpoints[i-1]
+}
+
+// Bracket gives a byte range holding the first line whose timestamp
+// is not before the target, as the before function tells, by the
+// samples: from the last sample before the target to the first not.
+// As with scan.SeekTime, timestamps must not decrease.
+func (x *Index) Bracket(before func(time.Time) bool) (lo int64, hi int64) {
+	i := sort.Search(len(x.Samples), func(i int) bool { return !before(x.Samples[i].Time) })
+	hi = x.Size
+	if i > 0 {
+		lo = x.Samples[i-1].Offset
+	}
+	if i < len(x.Samples) {
+		hi = x.Samples[i].Offset
+	}
+	return lo, hi
+}
+
+var (
+	mutex    sync.Mutex
+	memory   = map[string]*Index{} // By sidecar path
+	building = map[string]bool{}   // Sidecar paths being refreshed
+	slots    = make(chan struct{}, workers)
+	hits     int64 // Lookups since start, for /admin/cache
+	misses   int64
+)
+
+// Lookup gives the index of the file at the path, on the local disk,
+// if fresh.  Otherwise it starts a refresh in the background, unless
+// one is running, and gives nil.  Parse finds a line's timestamp.
+func Lookup(dir string, path string, info fs.FileInfo, parse func(string) (time.Time, bool)) *Index {
+	sidecar := sidecarPath(dir, path)
+	mutex.Lock()
+	x, ok := memory[sidecar]
+	mutex.Unlock()
+	if !ok {
+		if x, _ = load(sidecar); x != nil && x.Path == path {
+			remember(sidecar, x)
+		}
+	}
+	fresh := x != nil && x.Path == path && x.Fresh(info)
+	mutex.Lock()
+	defer mutex.Unlock()
+	if fresh {
+		hits++
+		return x
+	}
+	misses++
+	if !building[sidecar] {
+		building[sidecar] = true
+		go func() {
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			if _, err := Refresh(context.Background(), dir, path, parse); err != nil {
+				app.Log(app.LogWarning, "Cannot index %s: %s", path, err)
+			}
+			mutex.Lock()
+			delete(building, sidecar)
+			mutex.Unlock()
+		}()
+	}
+	return nil
+}
+
+// Invalidate drops the indexes of the file at the path, and of the
+// files below it if it is a directory, from memory and the directory
+// of sidecars, giving how many were dropped.  A refresh in progress
+// may write its sidecar again.  The path "/" drops every index.
+func Invalidate(dir string, path string) (int, error) {
+	within := func(p string) bool {
+		return p == path || strings.HasPrefix(p, strings.TrimSuffix(path, "/")+"/")
+	}
+	mutex.Lock()
+	for sidecar, x := range memory {
+		if within(x.Path) {
+			delete(memory, sidecar)
+		}
+	}
+	mutex.Unlock()
+	sidecars, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, sidecar := range sidecars {
+		if x, _ := load(sidecar); x != nil && !within(x.Path) {
+			continue
+		}
+		// Unreadable sidecars go too; they would be rebuilt anyway.
+		if err := os.Remove(sidecar); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Usage gives the number of indexes in memory, and the lookups that
+// found an index fresh (hits) or not (misses) since the service
+// started.
+func Usage() (entries int, hitCount int64, missCount int64) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return len(memory), hits, misses
+}
+
+// Refresh brings the index of the file at the path up to date and
+// writes its sidecar.
+func Refresh(ctx context.Context, dir string, path string, parse func(string) (time.Time, bool)) (*Index, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	sidecar := sidecarPath(dir, path)
+	old, _ := load(sidecar)
+	if old != nil && (old.Path != path || old.Size > info.Size()) {
+		old = nil
+	}
+	x, err := Build(ctx, file, info.Size(), parse, old)
+	if err != nil {
+		return nil, err
+	}
+	x.Path, x.ModTime = path, info.ModTime()
+	if err = save(sidecar, x); err != nil {
+		return nil, err
+	}
+	remember(sidecar, x)
+	app.Log(app.LogDebug, "Indexed %s: %d lines, %d bytes", path, x.Lines, x.Size)
+	return x, nil
+}
+
+// Build indexes the file's first size bytes.  Given the index of an
+// earlier, shorter version of the file with the same first bytes, it
+// indexes only what was appended.
+func Build(ctx context.Context, file io.ReaderAt, size int64,
+	parse func(string) (time.Time, bool), from *Index) (*Index, error) {
+	head, err := digestHead(file, size)
+	if err != nil {
+		return nil, err
+	}
+	x := &Index{Version: version, Head: head}
+	if from != nil && from.Version == version && from.Head == head && from.Size <= size {
+		resumed := *from
+		resumed.Checkpoints = append([]Checkpoint(nil), from.Checkpoints...)
+		resumed.Samples = append([]Sample(nil), from.Samples...)
+		x = &resumed
+	}
+	if x.Size == 0 {
+		x.Checkpoints = []Checkpoint{{Line: 0, Offset: 0}}
+	}
+
+	reader := bufio.NewReaderSize(io.NewSectionReader(file, x.Size, size-x.Size), 64*1024)
+	offset := x.Size
+	atLineStart := offset == 0
+	if offset > 0 {
+		// Resume at the start of the next line, if the last one ended.
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, offset-1); err != nil {
+			return nil, err
+		}
+		atLineStart = last[0] == '\n'
+	}
+	for offset < size {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		line, err := reader.ReadSlice('\n')
+		if err != nil && err != bufio.ErrBufferFull && err != io.EOF {
+			return nil, err
+		}
+		if len(line) == 0 {
+			break
+		}
+		if atLineStart {
+			if x.Newlines%checkpointLines == 0 && x.Newlines > 0 {
+				x.Checkpoints = append(x.Checkpoints, Checkpoint{Line: x.Newlines, Offset: offset})
+			}
+			if offset >= x.NextSample {
+				if t, ok := parse(string(line)); ok {
+					x.Samples = append(x.Samples, Sample{Time: t, Offset: offset})
+					x.NextSample = offset + sampleBytes
+				}
+			}
+		}
+		offset += int64(len(line))
+		atLineStart = line[len(line)-1] == '\n'
+		if atLineStart {
+			x.Newlines++
+		}
+	}
+	if offset < size {
+		return nil, errors.New(fmt.Sprintf("file ended at %d, expected %d", offset, size))
+	}
+	x.Size = size
+	x.Lines = x.Newlines
+	if !atLineStart {
+		x.Lines++
+	}
+	return x, nil
+}
+
+// digestHead gives the hex SHA-256 of the file's first headBytes.
+func digestHead(file io.ReaderAt, size int64) (string, error) {
+	n := int64(headBytes)
+	if size < n {
+		n = size
+	}
+	b := make([]byte, n)
+	if _, err := file.ReadAt(b, 0); err != nil && err != io.EOF {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// sidecarPath names the sidecar of the file at the path.
+func sidecarPath(dir string, path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return filepath.Join(dir, hex.EncodeToString(sum[:16])+".json")
+}
+
+// load reads a sidecar, giving nil if it is missing or of another
+// version.
+func load(sidecar string) (*Index, error) {
+	b, err := os.ReadFile(sidecar)
+	if err != nil {
+		return nil, err
+	}
+	x := new(Index)
+	if err = json.Unmarshal(b, x); err != nil {
+		return nil, err
+	}
+	if x.Version != version {
+		return nil, nil
+	}
+	return x, nil
+}
+
+// save writes a sidecar, by way of a temporary file, so readers never
+// see a partial index.
+func save(sidecar string, x *Index) error {
+	b, err := json.Marshal(x)
+	if err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(sidecar), ".index-")
+	if err != nil {
+		return err
+	}
+	if _, err = temp.Write(b); err == nil {
+		err = temp.Close()
+	} else {
+		temp.Close()
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), sidecar)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+	return err
+}
+
+// remember keeps an index in memory.
+func remember(sidecar string, x *Index) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, ok := memory[sidecar]; !ok && len(memory) >= memorySize {
+		for k := range memory {
+			delete(memory, k) // An arbitrary one; the sidecar remains.
+			break
+		}
+	}
+	memory[sidecar] = x
+}
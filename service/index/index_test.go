@@ -0,0 +1,168 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"varlog/service/scan"
+)
+
+// Parses timestamps as the service does.
+var parse = (&scan.TimestampNormalizer{Location: time.UTC}).Time
+
+func TestBuild(t *testing.T) {
+	tests := []struct {
+		data  string
+		lines int64
+	}{
+		{"", 0},
+		{"\n", 1},
+		{"one", 1},
+		{"one\n", 1},
+		{"one\ntwo", 2},
+		{"one\n\n\nfour\n", 4},
+	}
+	for _, test := range tests {
+		x, err := Build(context.Background(), strings.NewReader(test.data), int64(len(test.data)), parse, nil)
+		if err != nil {
+			t.Fatalf("%q: %s", test.data, err)
+		}
+		if x.Lines != test.lines || x.Size != int64(len(test.data)) {
+			t.Errorf("%q: expected %d lines, got %d", test.data, test.lines, x.Lines)
+		}
+	}
+}
+
+// logData gives count lines a second apart, each about 60 bytes.
+func logData(start int, count int) string {
+	t0 := time.Date(2023, 2, 16, 7, 0, 0, 0, time.UTC)
+	var b strings.Builder
+	for j := start; j < start+count; j++ {
+		fmt.Fprintf(&b, "%s INFO line %d of the log\n", t0.Add(time.Duration(j)*time.Second).Format(time.RFC3339), j)
+	}
+	return b.String()
+}
+
+func TestBuild_checkpoints(t *testing.T) {
+	data := logData(0, 50000)
+	x, err := Build(context.Background(), strings.NewReader(data), int64(len(data)), parse, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.Lines != 50000 || len(x.Checkpoints) != 5 {
+		t.Fatalf("expected 50000 lines and 5 checkpoints, got %d and %d", x.Lines, len(x.Checkpoints))
+	}
+	for _, line := range []int64{0, 9999, 10000, 25000, 49999} {
+		c := x.Checkpoint(line)
+		if c.Line > line || line-c.Line >= checkpointLines || !strings.HasPrefix(data[c.Offset:], logData(int(c.Line), 1)) {
+			t.Errorf("line %d: bad checkpoint %+v", line, c)
+		}
+	}
+	if len(x.Samples) < 2 {
+		t.Fatalf("expected samples, got %d", len(x.Samples))
+	}
+	target := time.Date(2023, 2, 16, 10, 0, 0, 0, time.UTC)
+	lo, hi := x.Bracket(func(t time.Time) bool { return t.Before(target) })
+	offset := int64(strings.Index(data, target.Format(time.RFC3339)))
+	if offset < lo || offset > hi || hi-lo > 2*sampleBytes {
+		t.Errorf("bracket: expected %d in [%d, %d]", offset, lo, hi)
+	}
+
+	// Appended lines are indexed from where the index ended.
+	more := data + logData(50000, 20000) + "partial"
+	y, err := Build(context.Background(), strings.NewReader(more), int64(len(more)), parse, x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, _ := Build(context.Background(), strings.NewReader(more), int64(len(more)), parse, nil)
+	if y.Lines != 70001 || len(y.Checkpoints) != len(z.Checkpoints) || len(y.Samples) != len(z.Samples) {
+		t.Errorf("resumed: expected %d lines, %d checkpoints, %d samples, got %d, %d, %d",
+			z.Lines, len(z.Checkpoints), len(z.Samples), y.Lines, len(y.Checkpoints), len(y.Samples))
+	}
+	if len(x.Checkpoints) != 5 {
+		t.Errorf("resumed: the earlier index changed")
+	}
+
+	// A different file is indexed afresh.
+	other := "replaced\n" + data
+	y, _ = Build(context.Background(), strings.NewReader(other), int64(len(other)), parse, x)
+	if y.Lines != 50001 {
+		t.Errorf("replaced: expected 50001 lines, got %d", y.Lines)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte(logData(0, 100)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(path)
+	if x := Lookup(dir, path, info, parse); x != nil {
+		t.Errorf("lookup before refresh: expected nil")
+	}
+	x, err := Refresh(context.Background(), dir, path, parse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.Lines != 100 {
+		t.Errorf("expected 100 lines, got %d", x.Lines)
+	}
+	if x = Lookup(dir, path, info, parse); x == nil || x.Lines != 100 {
+		t.Errorf("lookup after refresh: expected 100 lines, got %+v", x)
+	}
+
+	// From the sidecar, as after a restart.
+	mutex.Lock()
+	memory = map[string]*Index{}
+	mutex.Unlock()
+	if x = Lookup(dir, path, info, parse); x == nil || x.Lines != 100 {
+		t.Errorf("lookup from sidecar: expected 100 lines, got %+v", x)
+	}
+
+	// A changed file is stale until refreshed again.
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	file.WriteString(logData(100, 10))
+	file.Close()
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	info, _ = os.Stat(path)
+	if x = Lookup(dir, path, info, parse); x != nil {
+		t.Errorf("lookup of changed file: expected nil")
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if x = Lookup(dir, path, info, parse); x != nil {
+			break
+		}
+	}
+	if x == nil || x.Lines != 110 {
+		t.Errorf("background refresh: expected 110 lines, got %+v", x)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	dir := t.TempDir()
+	logs := t.TempDir()
+	for _, name := range []string{"a.log", "sub/b.log", "sub/c.log"} {
+		path := filepath.Join(logs, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(logData(0, 10)), 0644)
+		if _, err := Refresh(context.Background(), dir, path, parse); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := Invalidate(dir, filepath.Join(logs, "sub")); err != nil || n != 2 {
+		t.Errorf("sub: expected 2, got %d %v", n, err)
+	}
+	info, _ := os.Stat(filepath.Join(logs, "a.log"))
+	if x := Lookup(dir, filepath.Join(logs, "a.log"), info, parse); x == nil {
+		t.Errorf("a.log: expected its index to remain")
+	}
+	if n, err := Invalidate(dir, "/"); err != nil || n != 1 {
+		t.Errorf("all: expected 1, got %d %v", n, err)
+	}
+}
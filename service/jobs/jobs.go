@@ -0,0 +1,344 @@
+// Package jobs runs operations too long for one HTTP request in the
+// background, such as /export or a /read of a large tree, so that proxy
+// timeouts and dropped connections do not lose them.  Starting a job
+// gives its ID at once; the client follows it at /jobs/ID:
+//
+//	GET    /jobs              the client's jobs, newest first
+//	GET    /jobs/ID           the job's status and progress
+//	DELETE /jobs/ID           cancels the job
+//	GET    /jobs/ID/result    the job's response, for jobs keeping one
+//
+// Jobs are held in memory: the most recent finished jobs are kept, with
+// their responses, and Stop or a restart forgets them all.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"varlog/service/app"
+)
+
+// Job states.
+const (
+	StatusRunning  = "running"
+	StatusDone     = "done"
+	StatusFailed   = "failed"
+	StatusCanceled = "canceled"
+)
+
+const (
+	// Finished jobs kept for their results.  Older ones are forgotten.
+	maxFinished = 100
+
+	// Jobs one principal may have running at once.  Each may hold a
+	// read slot and fill a temporary file, so more are refused.
+	maxRunning = 4
+)
+
+// A Job is the state of a background operation, as reported.
+type Job struct {
+	ID        string     `json:"id"`
+	Kind      string     `json:"kind"`             // The operation, as "export"
+	Principal string     `json:"principal"`        // Who started it, empty without authentication
+	Status    string     `json:"status"`           // StatusRunning, StatusDone, StatusFailed, or StatusCanceled
+	Done      int64      `json:"done"`             // Bytes processed so far
+	Total     int64      `json:"total,omitempty"`  // Bytes to process, when known
+	Result    string     `json:"result,omitempty"` // What a done job made, as a URL
+	Error     string     `json:"error,omitempty"`  // Why a job failed
+	Started   time.Time  `json:"started"`
+	Ended     *time.Time `json:"ended,omitempty"` // Nil while running
+}
+
+// A Func performs a job, giving its result.  It should return promptly
+// when the context is canceled.
+type Func func(ctx context.Context, id string) (result string, err error)
+
+// entry holds a job and what the package keeps for it.
+type entry struct {
+	job         Job
+	cancel      context.CancelFunc
+	canceled    bool   // Cancel was called
+	output      string // The response kept, or empty
+	contentType string // The response's Content-Type
+}
+
+var (
+	mutex    sync.Mutex
+	jobs     = map[string]*entry{}
+	finished []string // IDs of finished jobs, oldest first
+	wait     sync.WaitGroup
+	ctx      context.Context
+	cancel   context.CancelFunc
+)
+
+func init() {
+	ctx, cancel = context.WithCancel(context.Background())
+}
+
+// Start runs the function as a job, giving the job as it starts.
+// A principal with maxRunning jobs running gets a 429 error instead.
+func Start(kind string, principal string, run Func) (Job, error) {
+	b := make([]byte, 8)
+	rand.Read(b)
+	e := &entry{job: Job{ID: hex.EncodeToString(b), Kind: kind, Principal: principal,
+		Status: StatusRunning, Started: time.Now().UTC()}}
+	mutex.Lock()
+	running := 0
+	for _, other := range jobs {
+		if other.job.Principal == principal && other.job.Ended == nil {
+			running++
+		}
+	}
+	if running >= maxRunning {
+		mutex.Unlock()
+		app.Log(app.LogWarning, "Job (%s) refused, %q has %d running", kind, principal, running)
+		return Job{}, app.NewHTTPError(http.StatusTooManyRequests, app.CodeTooManyRequests,
+			fmt.Sprintf("%d jobs already running, the most allowed", running))
+	}
+	var jobCtx context.Context
+	jobCtx, e.cancel = context.WithCancel(ctx)
+	jobs[e.job.ID] = e
+	started := e.job
+	wait.Add(1)
+	mutex.Unlock()
+	app.Log(app.LogInfo, "Job %s (%s) started by %q", started.ID, kind, principal)
+
+	go func() {
+		defer wait.Done()
+		result, err := run(jobCtx, started.ID)
+		mutex.Lock()
+		defer mutex.Unlock()
+		e.cancel()
+		job := &e.job
+		ended := time.Now().UTC()
+		job.Ended = &ended
+		switch {
+		case e.canceled:
+			job.Status = StatusCanceled
+			app.Log(app.LogInfo, "Job %s (%s) canceled", job.ID, kind)
+		case err != nil:
+			job.Status, job.Error = StatusFailed, err.Error()
+			app.Log(app.LogWarning, "Job %s (%s) failed, %s", job.ID, kind, err)
+		default:
+			job.Status, job.Result = StatusDone, result
+			app.Log(app.LogInfo, "Job %s (%s) done, %s", job.ID, kind, result)
+		}
+		if job.Status != StatusDone && e.output != "" {
+			os.Remove(e.output)
+			e.output = ""
+		}
+		finished = append(finished, job.ID)
+		if len(finished) > maxFinished {
+			forget(finished[0])
+			finished = finished[1:]
+		}
+	}()
+	return started, nil
+}
+
+// AcquireRead reserves a read slot for a job that scans logs, waiting
+// for one, as -max-concurrent-reads allows.  Jobs fail in maintenance
+// mode, as requests are rejected.  On nil, the caller must call
+// app.ReleaseRead when the scan finishes.
+func AcquireRead(ctx context.Context) error {
+	if err := app.WaitRead(ctx); err != nil {
+		return err
+	}
+	if app.Maintenance() {
+		app.ReleaseRead()
+		return errors.New("service in maintenance mode")
+	}
+	return nil
+}
+
+// forget drops the job, and its response.  The mutex must be held.
+func forget(id string) {
+	if e, ok := jobs[id]; ok && e.output != "" {
+		os.Remove(e.output)
+	}
+	delete(jobs, id)
+}
+
+// Get gives the job of the ID.
+func Get(id string) (Job, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	e, ok := jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return e.job, true
+}
+
+// List gives the principal's jobs, newest first.
+func List(principal string) []Job {
+	mutex.Lock()
+	defer mutex.Unlock()
+	list := []Job{}
+	for _, e := range jobs {
+		if e.job.Principal == principal {
+			list = append(list, e.job)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Started.After(list[j].Started) })
+	return list
+}
+
+// Progress records the bytes a job has processed, of the total when
+// known, or zero.
+func Progress(id string, done int64, total int64) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if e, ok := jobs[id]; ok && e.job.Ended == nil {
+		e.job.Done, e.job.Total = done, total
+	}
+}
+
+// Cancel cancels the job if it is running.  The job ends as canceled
+// when its function returns.
+func Cancel(id string) (Job, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	e, ok := jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	if e.job.Ended == nil {
+		e.canceled = true
+		e.cancel()
+	}
+	return e.job, true
+}
+
+// Keep records the named file as the job's response, served by
+// /jobs/ID/result.  The package removes the file when the job is
+// forgotten, or fails.
+func Keep(id string, name string, contentType string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if e, ok := jobs[id]; ok {
+		e.output, e.contentType = name, contentType
+	} else {
+		os.Remove(name)
+	}
+}
+
+// Stop cancels the running jobs and waits for them, or for the context
+// to expire, then forgets the finished jobs.  For the server's Stop.
+// Jobs started later run as usual.
+func Stop(stopCtx context.Context) error {
+	mutex.Lock()
+	cancel()
+	ctx, cancel = context.WithCancel(context.Background())
+	mutex.Unlock()
+	done := make(chan struct{})
+	go func() {
+		wait.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-stopCtx.Done():
+		return stopCtx.Err()
+	}
+	mutex.Lock()
+	for _, id := range finished {
+		forget(id)
+	}
+	finished = nil
+	mutex.Unlock()
+	return nil
+}
+
+// Handler serves /jobs and /jobs/ID.  Clients see only the jobs they
+// started; others are not found, as are unknown IDs.
+func Handler(writer http.ResponseWriter, request *http.Request) {
+	principal := app.PrincipalFrom(request.Context())
+	id, sub, _ := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(request.URL.Path, "/jobs"), "/"), "/")
+	allow := "GET, HEAD"
+	switch {
+	case id == "":
+	case sub == "":
+		allow = "GET, HEAD, DELETE"
+	case sub != "result":
+		app.Error(writer, request, "Not found", http.StatusNotFound)
+		return
+	}
+	if !strings.Contains(allow, request.Method) {
+		writer.Header().Set("Allow", allow)
+		app.Error(writer, request, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if id == "" {
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(List(principal))
+		return
+	}
+	job, ok := Get(id)
+	if !ok || job.Principal != principal {
+		app.Error(writer, request, "Not found", http.StatusNotFound)
+		return
+	}
+	switch {
+	case sub == "result":
+		serveResult(writer, request, job)
+	case request.Method == http.MethodDelete:
+		job, _ = Cancel(id)
+		Write(writer, http.StatusAccepted, job)
+	default:
+		Write(writer, http.StatusOK, job)
+	}
+}
+
+// serveResult serves the job's response.
+func serveResult(writer http.ResponseWriter, request *http.Request, job Job) {
+	var name, contentType string
+	mutex.Lock()
+	if e, ok := jobs[job.ID]; ok {
+		name, contentType = e.output, e.contentType
+	}
+	mutex.Unlock()
+	if job.Status == StatusRunning {
+		app.Error(writer, request, fmt.Sprintf("Job %s still running", job.ID), http.StatusConflict)
+		return
+	}
+	if name == "" {
+		app.Error(writer, request, fmt.Sprintf("Job %s has no result to retrieve", job.ID), http.StatusNotFound)
+		return
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		app.Error(writer, request, "Job result no longer available", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		app.WriteError(writer, request, err)
+		return
+	}
+	writer.Header().Set("Content-Type", contentType)
+	writer.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	if request.Method != http.MethodHead {
+		io.Copy(writer, file)
+	}
+}
+
+// Write writes the job as JSON, with the status code.
+func Write(writer http.ResponseWriter, code int, job Job) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(code)
+	json.NewEncoder(writer).Encode(job)
+}
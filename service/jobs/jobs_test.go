@@ -0,0 +1,197 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"varlog/service/app"
+	"varlog/service/apptest"
+)
+
+// await polls the job until it ends.
+func await(t *testing.T, id string) Job {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if job, _ := Get(id); job.Status != StatusRunning {
+			return job
+		}
+	}
+	t.Fatalf("job %s still running", id)
+	return Job{}
+}
+
+// serve runs the handler for a request by the principal.
+func serve(principal string, method string, target string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, nil)
+	request = request.WithContext(app.WithPrincipal(request.Context(), principal))
+	return apptest.Serve(Handler, app.DefaultProperties(), request)
+}
+
+func TestJobs(t *testing.T) {
+	release := make(chan struct{})
+	job, _ := Start("test", "alice", func(ctx context.Context, id string) (string, error) {
+		Progress(id, 10, 100)
+		<-release
+		return "done " + id, nil
+	})
+	if job.Status != StatusRunning || job.Ended != nil {
+		t.Fatalf("expected running, got %+v", job)
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if got, _ := Get(job.ID); got.Done == 10 {
+			break
+		}
+	}
+	if got, _ := Get(job.ID); got.Done != 10 || got.Total != 100 {
+		t.Errorf("expected progress 10 of 100, got %d of %d", got.Done, got.Total)
+	}
+	if recorder := serve("alice", http.MethodGet, "/jobs/"+job.ID+"/result"); recorder.Code != http.StatusConflict {
+		t.Errorf("result while running: expected 409, got %d", recorder.Code)
+	}
+	close(release)
+	failed, _ := Start("test", "alice", func(ctx context.Context, id string) (string, error) {
+		return "", errors.New("broken")
+	})
+	canceled, _ := Start("test", "bob", func(ctx context.Context, id string) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	if recorder := serve("alice", http.MethodDelete, "/jobs/"+canceled.ID); recorder.Code != http.StatusNotFound {
+		t.Errorf("cancel by another principal: expected 404, got %d", recorder.Code)
+	}
+	if recorder := serve("bob", http.MethodDelete, "/jobs/"+canceled.ID); recorder.Code != http.StatusAccepted {
+		t.Errorf("cancel: expected 202, got %d", recorder.Code)
+	}
+
+	for _, expected := range []Job{
+		{ID: job.ID, Status: StatusDone, Result: "done " + job.ID},
+		{ID: failed.ID, Status: StatusFailed, Error: "broken"},
+		{ID: canceled.ID, Status: StatusCanceled},
+	} {
+		got := await(t, expected.ID)
+		if got.Status != expected.Status || got.Result != expected.Result || got.Error != expected.Error || got.Ended == nil {
+			t.Errorf("expected %+v, got %+v", expected, got)
+		}
+	}
+
+	recorder := serve("alice", http.MethodGet, "/jobs")
+	var list []Job
+	if err := json.Unmarshal(recorder.Body.Bytes(), &list); err != nil || len(list) != 2 ||
+		list[0].ID != failed.ID || list[1].ID != job.ID {
+		t.Errorf("expected alice's jobs, newest first, got %s", recorder.Body)
+	}
+	recorder = serve("alice", http.MethodGet, "/jobs/"+job.ID)
+	var got Job
+	if recorder.Code != http.StatusOK || json.Unmarshal(recorder.Body.Bytes(), &got) != nil || got.ID != job.ID {
+		t.Errorf("expected the job, got %d %s", recorder.Code, recorder.Body)
+	}
+	for _, test := range []struct {
+		method, target string
+		code           int
+	}{
+		{http.MethodGet, "/jobs/" + canceled.ID, http.StatusNotFound},
+		{http.MethodGet, "/jobs/missing", http.StatusNotFound},
+		{http.MethodGet, "/jobs/" + job.ID + "/result", http.StatusNotFound},
+		{http.MethodGet, "/jobs/" + job.ID + "/other", http.StatusNotFound},
+		{http.MethodPost, "/jobs/" + job.ID, http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/jobs", http.StatusMethodNotAllowed},
+	} {
+		if recorder := serve("alice", test.method, test.target); recorder.Code != test.code {
+			t.Errorf("%s %s: expected %d, got %d", test.method, test.target, test.code, recorder.Code)
+		}
+	}
+
+	if err := Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %s", err)
+	}
+	if _, ok := Get(job.ID); ok {
+		t.Errorf("expected finished jobs forgotten after Stop")
+	}
+}
+
+func TestServe(t *testing.T) {
+	handler := func(writer http.ResponseWriter, request *http.Request) {
+		if name := request.URL.Query().Get("name"); name != "app.log" {
+			app.Error(writer, request, fmt.Sprintf("No %s", name), http.StatusNotFound)
+			return
+		}
+		writer.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(writer, "hello %s\n", app.PrincipalFrom(request.Context()))
+	}
+	client := httptest.NewRequest(http.MethodPost, "/jobs", nil)
+	client = client.WithContext(app.WithPrincipal(client.Context(), "alice"))
+	run := func(target string) Job {
+		job, _ := Start("read", "alice", func(ctx context.Context, id string) (string, error) {
+			request, err := Request(ctx, client, target)
+			if err != nil {
+				return "", err
+			}
+			return "/jobs/" + id + "/result", Serve(id, handler, request)
+		})
+		return await(t, job.ID)
+	}
+
+	job := run("/read?name=app.log")
+	if job.Status != StatusDone || job.Done != int64(len("hello alice\n")) {
+		t.Fatalf("expected done, got %+v", job)
+	}
+	recorder := serve("alice", http.MethodGet, job.Result)
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "hello alice\n" ||
+		recorder.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("expected the response, got %d %q", recorder.Code, recorder.Body)
+	}
+
+	job = run("/read?name=other.log")
+	if job.Status != StatusFailed || job.Error != `status 404, {"error":{"code":"not_found","message":"No other.log"}}` {
+		t.Errorf("expected the handler's error, got %+v", job)
+	}
+	Stop(context.Background())
+}
+
+func TestStart_maxRunning(t *testing.T) {
+	release := make(chan struct{})
+	block := func(ctx context.Context, id string) (string, error) {
+		<-release
+		return "", nil
+	}
+	var started []Job
+	for i := 0; i < maxRunning; i++ {
+		job, err := Start("test", "alice", block)
+		if err != nil {
+			t.Fatalf("job %d: expected nil error, got %v", i, err)
+		}
+		started = append(started, job)
+	}
+	var e *app.HTTPError
+	if _, err := Start("test", "alice", block); !errors.As(err, &e) || e.Status != http.StatusTooManyRequests {
+		t.Errorf("expected 429 beyond %d running jobs, got %v", maxRunning, err)
+	}
+	if _, err := Start("test", "bob", block); err != nil {
+		t.Errorf("another principal: expected nil error, got %v", err)
+	}
+	close(release)
+	for _, job := range started {
+		await(t, job.ID)
+	}
+	if _, err := Start("test", "alice", block); err != nil {
+		t.Errorf("after the jobs end: expected nil error, got %v", err)
+	}
+	Stop(context.Background())
+}
+
+func TestAcquireRead(t *testing.T) {
+	if err := AcquireRead(context.Background()); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	app.ReleaseRead()
+	app.SetMaintenance(true)
+	defer app.SetMaintenance(false)
+	if err := AcquireRead(context.Background()); err == nil {
+		t.Errorf("maintenance mode: expected error")
+	}
+}
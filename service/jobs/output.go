@@ -0,0 +1,93 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"varlog/service/app"
+)
+
+// Request builds a GET of the target, as /read?name=app.log, for a
+// job to serve in the background.  It carries what the handlers take
+// from the client's request: the server's properties, the principal
+// and groups, for authorization, and the request ID, for the logs.
+func Request(ctx context.Context, client *http.Request, target string) (*http.Request, error) {
+	from := client.Context()
+	ctx = app.WithProperties(ctx, app.RequestProperties(client))
+	ctx = app.WithPrincipal(ctx, app.PrincipalFrom(from))
+	ctx = app.WithGroups(ctx, app.GroupsFrom(from))
+	ctx = app.WithRequestID(ctx, app.RequestID(from))
+	return http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+}
+
+// An Output is a ResponseWriter writing a job's response to a file,
+// recording the bytes written as the job's progress.
+type Output struct {
+	id     string
+	file   *os.File
+	header http.Header
+	status int
+	size   int64
+	err    error // The first write error
+}
+
+// NewOutput gives an Output writing to the file for the job.
+func NewOutput(id string, file *os.File) *Output {
+	return &Output{id: id, file: file, header: http.Header{}, status: http.StatusOK}
+}
+
+func (o *Output) Header() http.Header    { return o.header }
+func (o *Output) WriteHeader(status int) { o.status = status }
+
+func (o *Output) Write(b []byte) (int, error) {
+	if o.err != nil {
+		return 0, o.err
+	}
+	n, err := o.file.Write(b)
+	o.size += int64(n)
+	o.err = err
+	Progress(o.id, o.size, 0)
+	return n, err
+}
+
+// Size gives the bytes written.
+func (o *Output) Size() int64 { return o.size }
+
+// Check reports a write error, or a response other than 200 OK, with
+// the start of the response, which holds the handler's error.
+func (o *Output) Check() error {
+	if o.err != nil {
+		return o.err
+	}
+	if o.status != http.StatusOK {
+		message, _ := io.ReadAll(io.NewSectionReader(o.file, 0, 1024))
+		return errors.New(fmt.Sprintf("status %d, %s", o.status, strings.TrimSpace(string(message))))
+	}
+	return nil
+}
+
+// Serve runs the handler for the request as the job, keeping its
+// response as the job's result.
+func Serve(id string, handler http.HandlerFunc, request *http.Request) error {
+	file, err := os.CreateTemp("", "varlog-job-")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	output := NewOutput(id, file)
+	handler(output, request)
+	if err := output.Check(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	if err := request.Context().Err(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	Keep(id, file.Name(), output.Header().Get("Content-Type"))
+	return nil
+}
@@ -0,0 +1,166 @@
+// The journal package implements the /journal endpoint, which serves
+// the systemd journal newest first, as /read serves flat files.
+// On modern hosts many services log only to the journal.
+//
+// Entries come from journalctl --reverse, one line each in the
+// short-iso format, so the endpoint needs no systemd libraries.
+// The unit, priority, and boot parameters become journalctl options;
+// filter, count, sanitize, and ts apply to the lines as for /read,
+// as do the server's -max-response-bytes and -max-response-lines caps.
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+	"varlog/service/app"
+	"varlog/service/read"
+	"varlog/service/scan"
+)
+
+const (
+	// Longest journal line.  journald itself splits lines at 48KB
+	// by default, so a longer line ends the response.
+	maxLineBytes = 1024 * 1024
+
+	// The authorization path for the journal.  With a unit, the path
+	// is journal/UNIT, so -authz and -deny patterns can select units.
+	journalPath = "journal"
+)
+
+// The journalctl executable.  Tests substitute a script.
+var journalctl = "journalctl"
+
+// Handler serves /journal.
+func Handler(writer http.ResponseWriter, request *http.Request) {
+	var t0 = time.Now()
+	var totalLines int
+	defer func() {
+		app.Log(app.LogDebug, "/journal %d lines, %v", totalLines, time.Since(t0))
+	}()
+	props := app.RequestProperties(request)
+
+	if err := props.ExtractParams(request); err != nil {
+		app.WriteError(writer, request, err)
+		return
+	}
+	name := journalPath
+	if props.ParamUnit() != "" {
+		name += "/" + props.ParamUnit()
+	}
+	if app.Denied(name) {
+		app.Log(app.LogWarning, "Denied path %q requested", name)
+		app.Error(writer, request, "Not found", http.StatusNotFound)
+		return
+	}
+	if !props.Authorized(name) {
+		app.Log(app.LogWarning, "Principal %q not authorized for %q", props.Principal(), name)
+		app.Error(writer, request, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	// Stop journalctl when the client goes away or count is reached.
+	ctx, cancel := context.WithCancel(request.Context())
+	defer cancel()
+	cmd := exec.CommandContext(ctx, journalctl, journalArgs(props)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		app.Log(app.LogError, "Cannot run %s: %s", journalctl, err)
+		app.Error(writer, request, "Journal unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	totalLines, err = writeLines(props, writer, stdout)
+	cancel()
+	waitErr := cmd.Wait()
+	if err != nil || totalLines > 0 || waitErr == nil || request.Context().Err() != nil {
+		return
+	}
+	// Nothing was written, so a failure can still be reported,
+	// such as a boot that is not in the journal.
+	message := strings.TrimSpace(stderr.String())
+	if message == "" {
+		return
+	}
+	app.Log(app.LogWarning, "%s: %s", journalctl, message)
+	if strings.Contains(message, "not available") {
+		app.Error(writer, request, message, http.StatusNotFound)
+	} else {
+		app.Error(writer, request, message, http.StatusInternalServerError)
+	}
+}
+
+// journalArgs gives the journalctl arguments for the request.
+// Values use the --option=value form, so none can be taken as
+// another option, and were validated by ExtractParams.
+func journalArgs(props *app.Properties) []string {
+	args := []string{"--no-pager", "--quiet", "--reverse", "--output=short-iso"}
+	if props.ParamUnit() != "" {
+		args = append(args, "--unit="+props.ParamUnit())
+	}
+	if props.ParamPriority() != "" {
+		args = append(args, "--priority="+props.ParamPriority())
+	}
+	if props.ParamBoot() != "" {
+		args = append(args, "--boot="+props.ParamBoot())
+	}
+	if props.ParamCount() > 0 && props.Filters().Empty() {
+		// Without a filter, journalctl can stop early itself.
+		args = append(args, "--lines="+strconv.Itoa(props.ParamCount()))
+	}
+	return args
+}
+
+// writeLines copies the filtered journal lines to the response,
+// up to the count.  It stops early, with no error, at the count
+// or at the server's response caps.
+func writeLines(props *app.Properties, writer http.ResponseWriter, journal io.Reader) (totalLines int, err error) {
+	limit := read.NewResponseCap(props, writer)
+	defer limit.Signal(writer)
+	scanner := bufio.NewScanner(journal)
+	scanner.Buffer(make([]byte, 64*1024), maxLineBytes)
+	var out *bufio.Writer
+	for scanner.Scan() {
+		line := scanner.Text()
+		if props.ParamSanitize() {
+			line = scan.Sanitize(line)
+		}
+		line = props.Redact(props.NormalizeTimestamp(line))
+		if !props.FilterAllowsRecord([]string{line}) {
+			continue
+		}
+		if !limit.Allow(line) {
+			break
+		}
+		if out == nil {
+			writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			out = bufio.NewWriter(writer)
+		}
+		if _, err = out.WriteString(line + "\n"); err != nil {
+			return totalLines, err
+		}
+		totalLines++
+		if props.ParamCount() > 0 && totalLines >= props.ParamCount() {
+			break
+		}
+	}
+	if out != nil {
+		err = out.Flush()
+	}
+	if err == nil && !errors.Is(scanner.Err(), bufio.ErrTooLong) {
+		err = scanner.Err()
+	}
+	return totalLines, err
+}
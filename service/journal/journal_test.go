@@ -0,0 +1,112 @@
+package journal
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"varlog/service/app"
+	"varlog/service/apptest"
+)
+
+func TestJournalArgs(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"", nil},
+		{"unit=nginx.service&priority=err&boot=-1",
+			[]string{"--unit=nginx.service", "--priority=err", "--boot=-1"}},
+		{"count=5", []string{"--lines=5"}},
+		{"count=5&filter=GET", nil},
+	}
+	for _, test := range tests {
+		props := app.DefaultProperties()
+		if err := props.ExtractParams(httptest.NewRequest("GET", "/journal?"+test.query, nil)); err != nil {
+			t.Fatalf("%s: expected nil error, got %v", test.query, err)
+		}
+		args := journalArgs(props)[4:]
+		if len(args) == 0 {
+			args = nil
+		}
+		if !reflect.DeepEqual(args, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.query, test.expected, args)
+		}
+	}
+}
+
+func TestExtractParams_journal(t *testing.T) {
+	for _, query := range []string{"unit=-x", "unit=a;b", "priority=9", "priority=loud", "boot=abc"} {
+		props := app.DefaultProperties()
+		if err := props.ExtractParams(httptest.NewRequest("GET", "/journal?"+query, nil)); err == nil {
+			t.Errorf("%s: expected error, got nil", query)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell")
+	}
+	journalctl = filepath.Join(t.TempDir(), "journalctl")
+	defer func() { journalctl = "journalctl" }()
+	script := "#!/bin/sh\nprintf '\\033[1mc\\033[0m GET /b\\nb POST /a\\na GET /a\\n'\n"
+	if err := os.WriteFile(journalctl, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query string
+		body  string
+	}{
+		{"", "c GET /b\nb POST /a\na GET /a\n"},
+		{"filter=GET", "c GET /b\na GET /a\n"},
+		{"filter=GET&count=1", "c GET /b\n"},
+		{"filter=c%20GET&sanitize=false", ""},
+		{"sanitize=false&count=1", "\x1b[1mc\x1b[0m GET /b\n"},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		Handler(recorder, httptest.NewRequest("GET", "/journal?"+test.query, nil))
+		if recorder.Code != http.StatusOK || recorder.Body.String() != test.body {
+			t.Errorf("%s: expected 200 %q, got %d %q", test.query, test.body, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+func TestHandler_caps(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell")
+	}
+	journalctl = filepath.Join(t.TempDir(), "journalctl")
+	defer func() { journalctl = "journalctl" }()
+	script := "#!/bin/sh\nprintf 'c GET /b\\nb POST /a\\na GET /a\\n'\n"
+	if err := os.WriteFile(journalctl, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		maxBytes  int64
+		maxLines  int
+		body      string
+		truncated string
+	}{
+		{0, 0, "c GET /b\nb POST /a\na GET /a\n", ""},
+		{0, 2, "c GET /b\nb POST /a\n", "lines"},
+		{20, 0, "c GET /b\nb POST /a\n", "bytes"},
+		{100, 3, "c GET /b\nb POST /a\na GET /a\n", ""},
+	}
+	for _, test := range tests {
+		props := app.DefaultProperties()
+		props.SetMaxResponseBytes(test.maxBytes)
+		props.SetMaxResponseLines(test.maxLines)
+		response := apptest.Serve(Handler, props, apptest.Request("/journal")).Result()
+		body, _ := io.ReadAll(response.Body)
+		if string(body) != test.body || response.Trailer.Get(app.HdrTruncated) != test.truncated {
+			t.Errorf("caps (%d bytes, %d lines): expected %q, truncated %q; got %q, %q",
+				test.maxBytes, test.maxLines, test.body, test.truncated, body, response.Trailer.Get(app.HdrTruncated))
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package list
+
+import (
+	"context"
+	"io/fs"
+	"strings"
+	"sync"
+	"time"
+	"varlog/service/app"
+	"varlog/service/stats"
+	"varlog/service/tracing"
+)
+
+// Directory listing cache.  Polling clients list the same large
+// directories over and over; reading tens of thousands of entries each
+// time dominates CPU.  The cache keeps the raw directory entries, keyed
+// by directory path.  An entry is used while it is younger than the TTL
+// and the directory's modification time is unchanged, so additions and
+// removals show up at once on file systems with fine-grained times, and
+// within the TTL otherwise.  Filtering, the deny-list, and authorization
+// apply to the cached entries per request, as for a fresh read.
+// Followers of a file (/read with follow=true) watch its directory
+// with inotify on Linux, and call Invalidate when entries are created,
+// removed, or renamed there.  Other directories, and other platforms,
+// rely on the modification time and the TTL.
+// Only the host file system is cached: other file systems need not
+// keep directory modification times that track their contents.
+
+const (
+	// Most directories cached.  When full, the oldest entry is dropped.
+	listCacheSize = 256
+)
+
+type cacheEntry struct {
+	entries []fs.DirEntry
+	modTime time.Time
+	loaded  time.Time
+}
+
+var (
+	cacheMutex  sync.Mutex
+	cache       = map[string]*cacheEntry{}
+	cacheHits   int64 // Lookups since start, for /admin/cache
+	cacheMisses int64
+)
+
+// readDirCached gives the entries of the directory, from the cache if
+// fresh.  Stats records the cache hit or miss for the request.
+// A TTL of zero disables the cache.
+func readDirCached(ctx context.Context, fsys fs.FS, dir string, modTime time.Time, ttl time.Duration) ([]fs.DirEntry, error) {
+	_, span := tracing.Start(ctx, "readdir")
+	defer span.End()
+	span.SetAttribute("varlog.dir", dir)
+	if ttl <= 0 || fsys != app.OSFileSystem {
+		return app.ReadDir(fsys, dir)
+	}
+	cacheMutex.Lock()
+	e, ok := cache[dir]
+	hit := ok && e.modTime.Equal(modTime) && time.Since(e.loaded) < ttl
+	if hit {
+		cacheHits++
+	} else {
+		cacheMisses++
+	}
+	cacheMutex.Unlock()
+	stats.CacheHit(ctx, hit)
+	span.SetAttribute("varlog.cache_hit", hit)
+	if hit {
+		return e.entries, nil
+	}
+
+	entries, err := app.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	if _, ok := cache[dir]; !ok && len(cache) >= listCacheSize {
+		evictOldest()
+	}
+	cache[dir] = &cacheEntry{entries: entries, modTime: modTime, loaded: time.Now()}
+	return entries, nil
+}
+
+// evictOldest drops the least recently loaded entry.
+// The caller holds the mutex.
+func evictOldest() {
+	var oldest string
+	var oldestTime time.Time
+	for dir, e := range cache {
+		if oldest == "" || e.loaded.Before(oldestTime) {
+			oldest, oldestTime = dir, e.loaded
+		}
+	}
+	delete(cache, oldest)
+}
+
+// Invalidate drops the cached listing of a directory, given by its
+// full path, so the next /list reads it afresh.
+func Invalidate(dir string) {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	delete(cache, dir)
+}
+
+// InvalidateAll empties the listing cache.
+func InvalidateAll() {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	cache = map[string]*cacheEntry{}
+}
+
+// InvalidateTree drops the cached listings of a directory, given by
+// its full path, and of the directories below it, giving how many.
+func InvalidateTree(dir string) int {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	n := 0
+	for d := range cache {
+		if d == dir || strings.HasPrefix(d, strings.TrimSuffix(dir, "/")+"/") {
+			delete(cache, d)
+			n++
+		}
+	}
+	return n
+}
+
+// CacheUsage gives the number of directories cached, and the lookups
+// that found them fresh (hits) or not (misses) since the service
+// started.
+func CacheUsage() (entries int, hits int64, misses int64) {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	return len(cache), cacheHits, cacheMisses
+}
@@ -0,0 +1,87 @@
+package list
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"varlog/service/app"
+)
+
+// listFormat gives the format of the response, the JSON array by
+// default, from the 'format' parameter or the Accept header.
+func listFormat(props *app.Properties, writer http.ResponseWriter, request *http.Request) (string, error) {
+	format := props.ResponseFormat(writer, request, app.FormatJSON, app.FormatNDJSON, app.FormatText)
+	if format == app.FormatHexdump {
+		return "", app.ParamError(app.ParamFormat, "format=hexdump applies to /read only")
+	}
+	return format, nil
+}
+
+// writeEntries writes the entries a line each: as NDJSON objects, or
+// as text, their names, a directory's ending with '/' as ls -p writes.
+// A page of entries carries its tokens in the Link header only.
+func writeEntries(writer http.ResponseWriter, format string, data []*metadata) {
+	writer.Header().Set("Content-Type", app.MediaType(format))
+	out := bufio.NewWriter(writer)
+	for _, m := range data {
+		if format == app.FormatText {
+			out.WriteString(m.Name)
+			if m.Type == app.TypeDir {
+				out.WriteString("/")
+			}
+			out.WriteString("\n")
+			continue
+		}
+		b, _ := json.Marshal(m)
+		out.Write(append(b, '\n'))
+	}
+	out.Flush()
+}
+
+// writeJSON writes the entries as a JSON array, indented if pretty.
+// Entries are encoded one at a time, so a large listing is not held
+// again, encoded, in memory.
+func writeJSON(writer http.ResponseWriter, pretty bool, data []*metadata) {
+	writer.Header().Set("Content-Type", app.MediaType(app.FormatJSON))
+	out := bufio.NewWriter(writer)
+	separator, end := ",", "]\n"
+	if pretty && len(data) > 0 {
+		separator, end = ",\n  ", "\n]\n"
+		out.WriteString("[\n  ")
+	} else {
+		out.WriteString("[")
+	}
+	for j, m := range data {
+		if j > 0 {
+			out.WriteString(separator)
+		}
+		var b []byte
+		var err error
+		if pretty {
+			b, err = json.MarshalIndent(m, "  ", "  ")
+		} else {
+			b, err = json.Marshal(m)
+		}
+		if err != nil {
+			// The status is sent; the client sees a short array.
+			app.Log(app.LogError, "JSON marshal failed: %s", err.Error())
+			break
+		}
+		out.Write(b)
+	}
+	out.WriteString(end)
+	out.Flush()
+}
+
+// writePage writes a page of entries in its envelope, indented if
+// pretty.
+func writePage(writer http.ResponseWriter, pretty bool, pr pageResponse) {
+	writer.Header().Set("Content-Type", app.MediaType(app.FormatJSON))
+	encoder := json.NewEncoder(writer)
+	if pretty {
+		encoder.SetIndent("", "  ")
+	}
+	if err := encoder.Encode(pr); err != nil {
+		app.Log(app.LogError, "JSON encode failed: %s", err.Error())
+	}
+}
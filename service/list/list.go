@@ -13,16 +13,34 @@
 // or a negative (filter=-value) filter on the entries.  Entries
 // must match (or not match) the filter to be included in the
 // response.  An empty/missing filter passes all entries.
+// A leading backslash escapes a literal '-' (filter=\-value).
+//
+// Parameter 'filter-anchor=start|end|whole' requires the filter
+// text to match at the start, the end, or the whole of the name.
+//
+// Parameter 'format=json|ndjson|text' gives the entries as a JSON
+// array (the default), as a JSON object a line, or as names a line.
+// Without it, the Accept header chooses among application/json,
+// application/x-ndjson, and text/plain.
+//
+// Files with a -retention policy give it, with when the policy
+// expires the file; see app.RetentionPolicy.
+//
+// Parameter 'pretty=true' indents the JSON for people to read;
+// by default it is compact.
+//
+// Parameter 'page-size=N' gives the entries in pages of N, in an
+// envelope with the page's tokens, linking the neighboring pages in a
+// Link header; see app.Page.
 package list
 
 import (
-	"bytes"
-	"encoding/json"
-	"errors"
+	"context"
 	"fmt"
+	"io/fs"
 	"net/http"
-	"os"
 	"path"
+	"sort"
 	"strings"
 	"time"
 	"varlog/service/app"
@@ -31,8 +49,10 @@ import (
 // Metadata for the response.  Note the json package only exports
 // public fields.  This uses struct tags to set the key names.
 type metadata struct {
-	Name string `json:"name"` // Item's name, relative to the root
-	Type string `json:"type"` // Item's type: file or directory
+	Name      string     `json:"name"`                // Item's name, relative to the root
+	Type      string     `json:"type"`                // Item's type: file or directory
+	Retention *retention `json:"retention,omitempty"` // A file's -retention policy, if any
+	ExpiresAt string     `json:"expiresAt,omitempty"` // When the policy removes the file, RFC 3339
 }
 
 // Provides the top-level handler, as called by the HTTP listener.
@@ -41,44 +61,56 @@ type metadata struct {
 func Handler(writer http.ResponseWriter, request *http.Request) {
 	var t0 = time.Now()
 	defer func () {
-		app.Log(app.LogInfo, "/list %v", time.Since(t0))
+		app.Log(app.LogDebug, "/list %v", time.Since(t0))
 	}()
-	var props *app.Properties = app.NewProperties()
+	var props *app.Properties = app.RequestProperties(request)
 
-	app.Log(app.LogInfo, "%q", request.URL)
+	app.Log(app.LogDebug, "%q", request.URL)
 
 	// All parameter handling and validation should be done before
 	// starting to write the response body (through writer).
 	// Otherwise a prelimary write on the response will set the
 	// status, and later error handling will not work properly.
-	// The response should be unchanged if http.Error() is used at all.
+	// The response should be unchanged if app.Error() is used at all.
 
 	err := props.ExtractParams(request)
 	if err != nil {
-		http.Error(writer, err.Error(), http.StatusBadRequest)
+		app.WriteError(writer, request, err)
 		return
 	}
-	data, err := collectMetadata(props)
+	format, err := listFormat(props, writer, request)
 	if err != nil {
-		http.Error(writer, err.Error(), http.StatusNotFound)
+		app.WriteError(writer, request, err)
 		return
 	}
-	b, err := json.Marshal(data)
-	if err != nil {
-		app.Log(app.LogError, "JSON marshal failed: %s", err.Error())
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	if app.Denied(props.RelativePath()) {
+		app.Log(app.LogWarning, "Denied path %q requested", props.RelativePath())
+		app.Error(writer, request, "Not found", http.StatusNotFound)
+		return
+	}
+	if !props.AuthorizedToTraverse(props.RelativePath()) {
+		app.Log(app.LogWarning, "Principal %q not authorized for %q", props.Principal(), props.RelativePath())
+		app.Error(writer, request, "Access denied", http.StatusForbidden)
 		return
 	}
-	// For demonstration purposes, expand and indent the JSON.
-	// In production mode, one probably would use the default.
-	var out bytes.Buffer
-	err = json.Indent(&out, b, "", "  ")
+	data, err := collectMetadata(request.Context(), props)
 	if err != nil {
-		app.Log(app.LogError, "JSON indent failed: %s", err.Error())
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		app.WriteError(writer, request, err)
 		return
 	}
-	out.WriteTo(writer)
+	if page := props.ParamPage(); page.Paginated() {
+		pr := paginate(writer, request, props, page, data)
+		if format == app.FormatJSON {
+			writePage(writer, props.ParamPretty(), pr)
+			return
+		}
+		data = pr.Entries
+	}
+	if format != app.FormatJSON {
+		writeEntries(writer, format, data)
+		return
+	}
+	writeJSON(writer, props.ParamPretty(), data)
 }
 
 // Generates the response metadata for this request.
@@ -88,17 +120,20 @@ func Handler(writer http.ResponseWriter, request *http.Request) {
 // of the children.  Any other type of name is an error.
 // This function also applies the filter parameter, possibly
 // dropping an entry that otherwise would appear in the output.
-func collectMetadata(props *app.Properties) (data []*metadata, err error) {
-	fileInfo, err := os.Stat(props.RootedPath())
+func collectMetadata(ctx context.Context, props *app.Properties) (data []*metadata, err error) {
+	if props.MountTop() {
+		return listMounts(props), nil
+	}
+	fileInfo, err := app.Stat(props.FileSystem(), props.RootedPath())
 	if err != nil {
 		app.Log(app.LogWarning, "Path %q invalid, %s", props.RootedPath(), err.Error())
-		return nil, err
+		return nil, app.FileError(props.RelativePath(), err)
 	}
 	mode := fileInfo.Mode()
 	switch {
 	case mode.IsDir():
 		app.Log(app.LogDebug, "List directory %q", props.RootedPath())
-		data, err = listDir(props)
+		data, err = listDir(ctx, props, fileInfo.ModTime())
 
 	case mode.IsRegular():
 		app.Log(app.LogDebug, "List file %q", props.RootedPath())
@@ -107,7 +142,7 @@ func collectMetadata(props *app.Properties) (data []*metadata, err error) {
 	default:
 		s := fmt.Sprintf("Special file %q not allowed", props.RootedPath())
 		app.Log(app.LogWarning, "%s", s)
-		err = errors.New(s)
+		err = app.NewHTTPError(http.StatusNotFound, app.CodeNotFound, s)
 		return nil, err
 	}
 
@@ -116,30 +151,83 @@ func collectMetadata(props *app.Properties) (data []*metadata, err error) {
 	// We want to remove that root prefix.  The client does not have access
 	// to the file system except through the service, and the service should
 	// hide anything private.
-	root := app.Root() + "/"
+	// With mounts, names start with the mount name instead.
+	root := strings.TrimSuffix(props.Root(), "/") + "/"
 	for _, m := range data {
 		m.stripRootPrefix(root)
+		if props.Mount() != "" {
+			m.Name = path.Join(props.Mount(), m.Name)
+		}
+	}
+	if err == nil && props.Mount() == "" && mode.IsDir() && props.RelativePath() == "" {
+		data = addOverlays(props, data)
 	}
 	return data, err
 }
 
+// Add the overlay mounts, such as Docker's containers, to the
+// listing of a single root.  They hide root entries of the same name.
+func addOverlays(props *app.Properties, data []*metadata) []*metadata {
+	if len(props.Overlays()) == 0 {
+		return data
+	}
+	for _, o := range props.Overlays() {
+		for i, m := range data {
+			if m.Name == o.Name {
+				data = append(data[:i], data[i+1:]...)
+				break
+			}
+		}
+		if !props.FilterAllowsEntry(o.Name) || app.Denied(o.Name) || !props.AuthorizedToTraverse(o.Name) {
+			continue
+		}
+		data = append(data, &metadata{Name: o.Name, Type: app.TypeDir})
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].Name < data[j].Name })
+	return data
+}
+
+// Generate the return metadata for the top level with mounts:
+// each mount appears as a directory.
+func listMounts(props *app.Properties) (data []*metadata) {
+	data = []*metadata{}
+	for _, mount := range props.Mounts() {
+		if !props.FilterAllowsEntry(mount.Name) ||
+			app.Denied(mount.Name) || !props.AuthorizedToTraverse(mount.Name) {
+			continue
+		}
+		m := new(metadata)
+		m.Name = mount.Name
+		m.Type = app.TypeDir
+		data = append(data, m)
+	}
+	return data
+}
+
 // Generate the return metadata for a directory.
-func listDir(props *app.Properties) (data []*metadata, err error) {
+// The directory's modification time validates the listing cache.
+func listDir(ctx context.Context, props *app.Properties, modTime time.Time) (data []*metadata, err error) {
 	// Need to initialize data away from nil
 	data = []*metadata{}
-	files, err := os.ReadDir(props.RootedPath())
+	files, err := readDirCached(ctx, props.FileSystem(), props.RootedPath(), modTime, props.ListCacheTTL())
 	if err != nil {
-		// This should not happen.  The code already checked the entry
-		// is a directory.
-		app.Log(app.LogError, "Unable to read directory, %s", err.Error())
-		return nil, err
+		// The code already checked the entry is a directory,
+		// but it may not be readable by the service.
+		app.Log(app.LogWarning, "Unable to read directory, %s", err.Error())
+		return nil, app.FileError(props.RelativePath(), err)
 	}
-	// Note that os.ReadDir returns a sorted list.  Sorting the resulting
+	// Note that app.ReadDir returns a sorted list.  Sorting the resulting
 	// metadata array is thus unnecessary.
 	for _, file := range files {
 		if !props.FilterAllowsEntry(file.Name()) {
 			continue
 		}
+		// Show only entries the principal may access, or directories
+		// leading to them.
+		relativePath := path.Join(props.RelativePath(), file.Name())
+		if app.Denied(relativePath) || !props.AuthorizedToTraverse(relativePath) {
+			continue
+		}
 		fullPath := path.Join(props.RootedPath(), file.Name())
 		switch {
 		case file.IsDir():
@@ -152,6 +240,7 @@ func listDir(props *app.Properties) (data []*metadata, err error) {
 			m := new(metadata)
 			m.Name = fullPath
 			m.Type = app.TypeFile
+			m.setRetention(props, relativePath, file.Info)
 			data = append(data, m)
 
 		default:
@@ -168,12 +257,15 @@ func listDir(props *app.Properties) (data []*metadata, err error) {
 func listFile(props *app.Properties) (data []*metadata, err error) {
 	// Need to initialize data away from nil
 	data = []*metadata{}
-	if !props.FilterAllowsEntry(props.ParamName()) {
+	if !props.FilterAllowsEntry(props.ParamName()) || !props.Authorized(props.RelativePath()) {
 		return data, nil
 	}
 	m := new(metadata)
 	m.Name = props.RootedPath()
 	m.Type = app.TypeFile
+	m.setRetention(props, props.RelativePath(), func() (fs.FileInfo, error) {
+		return app.Stat(props.FileSystem(), props.RootedPath())
+	})
 	return append(data, m), nil
 }
 
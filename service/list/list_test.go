@@ -1,8 +1,18 @@
 package list
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"testing/fstest"
+	"time"
 	"varlog/service/app"
+	"varlog/service/apptest"
 )
 
 const (
@@ -10,33 +20,89 @@ const (
 )
 
 func buildProperties(name string) *app.Properties {
-	app.SetRoot(Root)
-	props := app.NewProperties()
+	props := app.DefaultProperties()
+	props.SetRoot(Root)
 	props.SetParamName(name)
 	return props
 }
 
 func TestExtractParams(t *testing.T) {
-	// TODO:
-	// Need to construct/mock the HTTP request.
+	tests := []struct {
+		params []string
+		valid  bool
+		rooted string
+		filter string
+		omit   bool
+	}{
+		{nil, true, Root, "", false},
+		{[]string{"name", "sub"}, true, Root + "/sub", "", false},
+		{[]string{"name", "sub/../a.log", "filter", "log"}, true, Root + "/a.log", "log", false},
+		{[]string{"filter", "-log"}, true, Root, "log", true},
+		{[]string{"name", "../etc"}, false, "", "", false},
+	}
+	for _, test := range tests {
+		props := app.DefaultProperties()
+		props.SetRoot(Root)
+		err := props.ExtractParams(apptest.Request("/list", test.params...))
+		if (err == nil) != test.valid {
+			t.Errorf("%v: expected valid %v, got %v", test.params, test.valid, err)
+			continue
+		}
+		if !test.valid {
+			continue
+		}
+		if props.RootedPath() != test.rooted {
+			t.Errorf("%v: expected path %q, got %q", test.params, test.rooted, props.RootedPath())
+		}
+		if props.Filter().Text != test.filter || props.Filter().Omit != test.omit {
+			t.Errorf("%v: expected filter %q omit %v, got %+v", test.params, test.filter, test.omit, props.Filter())
+		}
+	}
+}
+
+// listTree is the directory for the listDir tests.
+var listTree = apptest.NewTree().
+	Log("app.log", 10).
+	File("boot.txt", "booted").
+	Dir("nginx").
+	Special("pipe.log")
+
+// listDirNames lists the root with the filter, giving names and types.
+func listDirNames(t *testing.T, text string, omit bool) []string {
+	props := apptest.Properties(listTree.MapFS(Root), Root)
+	props.SetParamName("")
+	props.SetFilterText(text)
+	props.SetFilterOmit(omit)
+	data, err := listDir(context.Background(), props, time.Time{})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	var names []string
+	for _, m := range data {
+		names = append(names, m.Name+":"+m.Type)
+	}
+	return names
 }
 
 func TestListDir_nilFilter(t *testing.T) {
-	// TODO:
-	// This test should exercise listDir().
-	// Need to mock file system.
+	expected := []string{Root + "/app.log:file", Root + "/boot.txt:file", Root + "/nginx:dir"}
+	if names := listDirNames(t, "", false); !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
 }
 
 func TestListDir_negFilter(t *testing.T) {
-	// TODO:
-	// This test should exercise listDir().
-	// Need to mock file system.
+	expected := []string{Root + "/boot.txt:file", Root + "/nginx:dir"}
+	if names := listDirNames(t, ".log", true); !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
 }
 
 func TestListDir_posFilter(t *testing.T) {
-	// TODO:
-	// This test should exercise listDir().
-	// Need to mock file system.
+	expected := []string{Root + "/app.log:file"}
+	if names := listDirNames(t, ".log", false); !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
 }
 
 func TestListFile_nilFilter(t *testing.T) {
@@ -138,3 +204,181 @@ func TestValidateParams(t *testing.T) {
 		t.Errorf("Expected error but got nil, path %q", props.RootedPath())
 	}
 }
+
+func TestReadDirCached(t *testing.T) {
+	defer InvalidateAll()
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.log"), nil, 0600)
+	ctx := context.Background()
+	modTime := time.Unix(1000, 0)
+
+	entries, _ := readDirCached(ctx, app.OSFileSystem, dir, modTime, time.Minute)
+	os.WriteFile(filepath.Join(dir, "b.log"), nil, 0600)
+	if entries, _ = readDirCached(ctx, app.OSFileSystem, dir, modTime, time.Minute); len(entries) != 1 {
+		t.Errorf("same modification time: expected 1 cached entry, got %d", len(entries))
+	}
+	if entries, _ = readDirCached(ctx, app.OSFileSystem, dir, modTime.Add(time.Second), time.Minute); len(entries) != 2 {
+		t.Errorf("new modification time: expected 2 entries, got %d", len(entries))
+	}
+
+	os.WriteFile(filepath.Join(dir, "c.log"), nil, 0600)
+	Invalidate(dir)
+	if entries, _ = readDirCached(ctx, app.OSFileSystem, dir, modTime.Add(time.Second), time.Minute); len(entries) != 3 {
+		t.Errorf("after Invalidate: expected 3 entries, got %d", len(entries))
+	}
+	os.WriteFile(filepath.Join(dir, "d.log"), nil, 0600)
+	if entries, _ = readDirCached(ctx, app.OSFileSystem, dir, modTime.Add(time.Second), 0); len(entries) != 4 {
+		t.Errorf("cache disabled: expected 4 entries, got %d", len(entries))
+	}
+}
+
+func TestCollectMetadata_fileSystem(t *testing.T) {
+	props := app.DefaultProperties()
+	props.SetFileSystem(fstest.MapFS{
+		"var/log/a.log":     {Data: []byte("a\n")},
+		"var/log/b.txt":     {Data: []byte("b\n")},
+		"var/log/sub/c.log": {Data: []byte("c\n")},
+	})
+	props.SetRoot(Root)
+	props.SetParamName("")
+	data, err := collectMetadata(context.Background(), props)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	var names []string
+	for _, m := range data {
+		names = append(names, m.Name+":"+m.Type)
+	}
+	expected := []string{"a.log:file", "b.txt:file", "sub:dir"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+
+	props.SetParamName("missing")
+	if _, err = collectMetadata(context.Background(), props); err == nil {
+		t.Errorf("expected error for missing entry, got nil")
+	}
+}
+
+func TestHandler_pages(t *testing.T) {
+	fsys := apptest.NewTree().File("a.log").File("b.log").File("c.log").File("d.log").File("e.log").MapFS(Root)
+	props := apptest.Properties(fsys, Root)
+	get := func(params ...string) (int, pageResponse, string) {
+		recorder := apptest.Serve(Handler, props, apptest.Request("/list", params...))
+		var r pageResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &r); err != nil {
+			t.Fatalf("%s: %s", recorder.Body, err)
+		}
+		return recorder.Code, r, recorder.Header().Get("Link")
+	}
+	names := func(r pageResponse) (result []string) {
+		for _, m := range r.Entries {
+			result = append(result, m.Name)
+		}
+		return result
+	}
+
+	code, r, link := get("page-size", "2")
+	if code != http.StatusOK || !reflect.DeepEqual(names(r), []string{"a.log", "b.log"}) ||
+		r.Page.Size != 2 || r.Page.Next == "" || r.Page.Prev != "" {
+		t.Fatalf("first page: got %d %+v", code, r)
+	}
+	if link != `</list?page=`+r.Page.Next+`&page-size=2>; rel="next", </list?page-size=2>; rel="first"` {
+		t.Errorf("unexpected Link %q", link)
+	}
+	_, r, _ = get("page", r.Page.Next)
+	if !reflect.DeepEqual(names(r), []string{"c.log", "d.log"}) || r.Page.Prev == "" {
+		t.Errorf("second page: got %+v", r)
+	}
+	_, r, _ = get("page", r.Page.Next)
+	if !reflect.DeepEqual(names(r), []string{"e.log"}) || r.Page.Next != "" {
+		t.Errorf("last page: got %+v", r)
+	}
+	_, r, _ = get("page", r.Page.Prev)
+	if !reflect.DeepEqual(names(r), []string{"c.log", "d.log"}) {
+		t.Errorf("prev page: got %+v", r)
+	}
+}
+
+func TestHandler_formats(t *testing.T) {
+	fsys := apptest.NewTree().File("a.log").Dir("nginx").MapFS(Root)
+	props := apptest.Properties(fsys, Root)
+	tests := []struct {
+		params []string
+		accept string
+		body   string
+		typ    string
+	}{
+		{nil, "text/plain", "a.log\nnginx/\n", "text/plain; charset=utf-8"},
+		{[]string{"format", "ndjson"}, "", `{"name":"a.log","type":"file"}` + "\n" + `{"name":"nginx","type":"dir"}` + "\n",
+			"application/x-ndjson"},
+		{[]string{"format", "text", "page-size", "1"}, "", "a.log\n", "text/plain; charset=utf-8"},
+	}
+	for _, test := range tests {
+		request := apptest.Request("/list", test.params...)
+		request.Header.Set("Accept", test.accept)
+		recorder := apptest.Serve(Handler, props, request)
+		if recorder.Code != http.StatusOK || recorder.Body.String() != test.body ||
+			recorder.Header().Get("Content-Type") != test.typ {
+			t.Errorf("%v, Accept %q: expected %q %s, got %d %q %s", test.params, test.accept, test.body, test.typ,
+				recorder.Code, recorder.Body, recorder.Header().Get("Content-Type"))
+		}
+	}
+	if recorder := apptest.Serve(Handler, props, apptest.Request("/list", "format", "hexdump")); recorder.Code != http.StatusBadRequest {
+		t.Errorf("format=hexdump: expected 400, got %d", recorder.Code)
+	}
+}
+
+func TestHandler_pretty(t *testing.T) {
+	fsys := apptest.NewTree().File("a.log").Dir("nginx").MapFS(Root)
+	props := apptest.Properties(fsys, Root)
+	compact := `[{"name":"a.log","type":"file"},{"name":"nginx","type":"dir"}]`
+	var pretty bytes.Buffer
+	json.Indent(&pretty, []byte(compact), "", "  ")
+	tests := []struct {
+		params []string
+		body   string
+	}{
+		{nil, compact + "\n"},
+		{[]string{"pretty", "true"}, pretty.String() + "\n"},
+		{[]string{"filter", "none"}, "[]\n"},
+		{[]string{"filter", "none", "pretty", "true"}, "[]\n"},
+		{[]string{"page-size", "1", "pretty", "true"},
+			"{\n  \"entries\": [\n    {\n      \"name\": \"a.log\",\n      \"type\": \"file\"\n    }\n  ],\n" +
+				"  \"page\": {\n    \"size\": 1,\n    \"next\": \"MS4xLjAuMS4w\"\n  }\n}\n"},
+	}
+	for _, test := range tests {
+		recorder := apptest.Serve(Handler, props, apptest.Request("/list", test.params...))
+		if recorder.Code != http.StatusOK || recorder.Body.String() != test.body {
+			t.Errorf("%v: expected %q, got %d %q", test.params, test.body, recorder.Code, recorder.Body)
+		}
+	}
+}
+
+func TestHandler_retention(t *testing.T) {
+	modTime := time.Date(2023, 2, 16, 10, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"var/log/nginx/access.log": {Data: []byte("GET /"), ModTime: modTime},
+		"var/log/app.log":          {Data: []byte("ok"), ModTime: modTime},
+	}
+	props := apptest.Properties(fsys, Root)
+	if err := props.SetRetention([]string{"nginx/*=30d"}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"", `[{"name":"app.log","type":"file"},{"name":"nginx","type":"dir"}]`},
+		{"nginx", `[{"name":"nginx/access.log","type":"file",` +
+			`"retention":{"pattern":"nginx/*","age":"30d"},"expiresAt":"2023-03-18T10:00:00Z"}]`},
+		{"nginx/access.log", `[{"name":"nginx/access.log","type":"file",` +
+			`"retention":{"pattern":"nginx/*","age":"30d"},"expiresAt":"2023-03-18T10:00:00Z"}]`},
+	}
+	for _, test := range tests {
+		recorder := apptest.Serve(Handler, props, apptest.Request("/list", "name", test.name))
+		if recorder.Code != http.StatusOK || recorder.Body.String() != test.body+"\n" {
+			t.Errorf("%q: expected %s, got %d %s", test.name, test.body, recorder.Code, recorder.Body)
+		}
+	}
+}
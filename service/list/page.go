@@ -0,0 +1,31 @@
+package list
+
+import (
+	"net/http"
+	"varlog/service/app"
+)
+
+// The response of a paginated /list: a page of the entries, in an
+// envelope with the page's tokens.
+type pageResponse struct {
+	Entries []*metadata  `json:"entries"`
+	Page    app.PageInfo `json:"page"`
+}
+
+// paginate selects the page of the entries, setting the Link header.
+func paginate(writer http.ResponseWriter, request *http.Request, props *app.Properties, page app.Page, data []*metadata) pageResponse {
+	start := page.Skip
+	if start > int64(len(data)) {
+		start = int64(len(data))
+	}
+	end := start + int64(page.Size)
+	var next *app.Page
+	if end < int64(len(data)) {
+		n := page.Next(end, 0)
+		next = &n
+	} else {
+		end = int64(len(data))
+	}
+	info := app.WritePageLinks(writer, request, props, page, next)
+	return pageResponse{Entries: append([]*metadata{}, data[start:end]...), Page: info}
+}
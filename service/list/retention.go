@@ -0,0 +1,26 @@
+package list
+
+import (
+	"io/fs"
+	"time"
+	"varlog/service/app"
+)
+
+// A file's retention policy in the response.
+type retention struct {
+	Pattern string `json:"pattern"` // The -retention pattern matching the file
+	Age     string `json:"age"`     // How long it keeps files, as given, such as 30d
+}
+
+// setRetention gives a file's entry its retention policy, if any, and
+// the file's expiry, when its modification time can be had.
+func (m *metadata) setRetention(props *app.Properties, name string, info func() (fs.FileInfo, error)) {
+	policy, ok := props.Retention(name)
+	if !ok {
+		return
+	}
+	m.Retention = &retention{Pattern: policy.Pattern, Age: policy.Text}
+	if fileInfo, err := info(); err == nil {
+		m.ExpiresAt = policy.Expires(fileInfo.ModTime()).UTC().Format(time.RFC3339)
+	}
+}
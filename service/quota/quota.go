@@ -0,0 +1,350 @@
+// Package quota counts the requests and response bytes served to each
+// authenticated principal, and enforces hourly and daily quotas from
+// a -quota-file:
+//
+//	# principal: limit ...
+//	alice:   requests/hour=1000 requests/day=20000 bytes/day=10GiB
+//	batch:   bytes/hour=1GiB
+//	*:       requests/hour=100
+//
+// A limit names the resource (requests or bytes), the window (hour or
+// day), and the most allowed in one window.  Byte sizes may end in K,
+// M, G, or T (or KiB, MiB, GiB, TiB), each a power of 1024.  The "*"
+// principal applies to principals without their own line.  Windows are
+// calendar hours and days, UTC.
+//
+// A request arriving after a quota is used up gets 429 Too Many
+// Requests, with a message naming the quota and when it resets, and
+// Retry-After giving the seconds until then.  Bytes are counted as
+// they are written, so a request in progress is not cut short; the
+// next request is refused instead.
+//
+// Requests without a principal, as when authentication is disabled,
+// are neither counted nor limited.  Usage is exposed in /metrics.
+package quota
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"varlog/service/app"
+	"varlog/service/stats"
+)
+
+// Resources and windows, as named in the quota file and /metrics.
+const (
+	requests = iota
+	bytes
+	resourceCount
+)
+
+const (
+	hour = iota
+	day
+	windowCount
+)
+
+var (
+	resourceNames = [resourceCount]string{"requests", "bytes"}
+	windowNames   = [windowCount]string{"hour", "day"}
+)
+
+// Limits for one principal, by resource and window.  Zero is no limit.
+type limits [resourceCount][windowCount]int64
+
+// Usage by one principal.  The mutex covers all fields.
+type usage struct {
+	mutex    sync.Mutex
+	start    [windowCount]time.Time // Start of the current windows
+	used     [resourceCount][windowCount]int64
+	total    [resourceCount]int64 // Since the service started
+	rejected int64
+}
+
+var (
+	mutex  sync.Mutex
+	rules  map[string]limits // Principal => limits, nil for no quotas
+	usages = map[string]*usage{}
+)
+
+func init() {
+	stats.AddMetrics(metrics)
+}
+
+// Setup loads the quotas named by the properties, replacing any loaded
+// before.  Usage counted so far is kept, so quotas can be reloaded.
+func Setup(props *app.Properties) error {
+	var loaded map[string]limits
+	if name := props.QuotaFile(); name != "" {
+		var err error
+		if loaded, err = load(name); err != nil {
+			return err
+		}
+		app.Log(app.LogInfo, "Quotas loaded for %d principals from %s", len(loaded), name)
+	}
+	mutex.Lock()
+	rules = loaded
+	mutex.Unlock()
+	return nil
+}
+
+// load reads a quota file.
+func load(name string) (map[string]limits, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	loaded := make(map[string]limits)
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		principal, specs, found := strings.Cut(line, ":")
+		principal = strings.TrimSpace(principal)
+		if !found || principal == "" {
+			return nil, errors.New(fmt.Sprintf("%s:%d: expected principal: limits", name, lineNumber))
+		}
+		l := loaded[principal]
+		for _, spec := range strings.Fields(specs) {
+			if err := l.parse(spec); err != nil {
+				return nil, errors.New(fmt.Sprintf("%s:%d: %s", name, lineNumber, err))
+			}
+		}
+		loaded[principal] = l
+	}
+	return loaded, scanner.Err()
+}
+
+// parse sets one limit from its resource/window=value form.
+func (l *limits) parse(spec string) error {
+	key, value, found := strings.Cut(spec, "=")
+	resourceName, windowName, _ := strings.Cut(key, "/")
+	resource := indexOf(resourceNames[:], resourceName)
+	window := indexOf(windowNames[:], windowName)
+	if !found || resource < 0 || window < 0 {
+		return errors.New(fmt.Sprintf("bad limit %q, expected requests/hour=N or bytes/day=SIZE, etc.", spec))
+	}
+	var n int64
+	var err error
+	if resource == bytes {
+		n, err = parseSize(value)
+	} else {
+		n, err = strconv.ParseInt(value, 10, 64)
+	}
+	if err != nil || n <= 0 {
+		return errors.New(fmt.Sprintf("bad limit %q, expected a positive number", spec))
+	}
+	l[resource][window] = n
+	return nil
+}
+
+func indexOf(names []string, name string) int {
+	for j, n := range names {
+		if n == name {
+			return j
+		}
+	}
+	return -1
+}
+
+// parseSize parses a byte count with an optional binary unit suffix.
+func parseSize(value string) (int64, error) {
+	number := strings.TrimRightFunc(value, func(r rune) bool { return r < '0' || r > '9' })
+	unit := strings.ToUpper(strings.TrimSuffix(strings.TrimSuffix(value[len(number):], "iB"), "B"))
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	shift := strings.Index(" KMGT", unit)
+	if unit == "" {
+		shift = 0
+	}
+	if shift < 0 || len(unit) > 1 {
+		return 0, errors.New(fmt.Sprintf("unknown unit %q", unit))
+	}
+	if n > (1<<63-1)>>(10*shift) {
+		return 0, errors.New("size too large")
+	}
+	return n << (10 * shift), nil
+}
+
+// limitsFor gives the limits for the principal, and whether any apply.
+func limitsFor(principal string) (limits, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if l, ok := rules[principal]; ok {
+		return l, true
+	}
+	l, ok := rules["*"]
+	return l, ok
+}
+
+// lookup finds (or creates) the usage of a principal.
+func lookup(principal string) *usage {
+	mutex.Lock()
+	defer mutex.Unlock()
+	u, ok := usages[principal]
+	if !ok {
+		u = new(usage)
+		usages[principal] = u
+	}
+	return u
+}
+
+// windowStart gives the start of the window containing the time.
+func windowStart(window int, t time.Time) time.Time {
+	t = t.UTC()
+	if window == hour {
+		return t.Truncate(time.Hour)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// windowEnd gives the end of the window starting at the time.
+func windowEnd(window int, start time.Time) time.Time {
+	if window == hour {
+		return start.Add(time.Hour)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// roll starts new windows once the current ones end.
+// The mutex must be held.
+func (u *usage) roll(now time.Time) {
+	for window := range u.start {
+		if start := windowStart(window, now); !start.Equal(u.start[window]) {
+			u.start[window] = start
+			for resource := range u.used {
+				u.used[resource][window] = 0
+			}
+		}
+	}
+}
+
+// add counts n of the resource.
+func (u *usage) add(resource int, n int64, now time.Time) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.roll(now)
+	for window := range u.used[resource] {
+		u.used[resource][window] += n
+	}
+	u.total[resource] += n
+}
+
+// admit counts a request by the principal arriving now, or returns an
+// error if a quota is used up, with the time the quota resets.
+func admit(principal string, now time.Time) (u *usage, reset time.Time, err *app.HTTPError) {
+	u = lookup(principal)
+	l, limited := limitsFor(principal)
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.roll(now)
+	if limited {
+		// Report the quota that resets last, since it decides when
+		// the client may return.
+		for resource := range l {
+			for window, limit := range l[resource] {
+				end := windowEnd(window, u.start[window])
+				if limit > 0 && u.used[resource][window] >= limit && end.After(reset) {
+					reset = end
+					err = app.NewHTTPError(http.StatusTooManyRequests, app.CodeTooManyRequests,
+						fmt.Sprintf("Quota of %d %s per %s for %q used up (%d); resets at %s",
+							limit, resourceNames[resource], windowNames[window], principal,
+							u.used[resource][window], end.Format(time.RFC3339)))
+				}
+			}
+		}
+	}
+	if err != nil {
+		u.rejected++
+		return u, reset, err
+	}
+	for window := range u.used[requests] {
+		u.used[requests][window]++
+	}
+	u.total[requests]++
+	return u, reset, nil
+}
+
+// Wrap returns a handler that enforces the quotas of the request's
+// principal and counts its usage.  It goes after authentication.
+func Wrap(handler http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		principal := app.PrincipalFrom(request.Context())
+		if principal == "" {
+			handler(writer, request)
+			return
+		}
+		now := time.Now()
+		u, reset, err := admit(principal, now)
+		if err != nil {
+			app.Log(app.LogWarning, "%s, rejecting %q", err.Message, request.URL)
+			seconds := int(reset.Sub(now)/time.Second) + 1
+			writer.Header().Set(app.HdrRetryAfter, strconv.Itoa(seconds))
+			app.WriteError(writer, request, err)
+			return
+		}
+		// Bytes count as they are written, so a long stream uses
+		// up the quota while it runs.
+		recorder := app.NewResponseRecorder(writer)
+		recorder.OnWrite = func(n int) { u.add(bytes, int64(n), time.Now()) }
+		handler(recorder, request)
+	}
+}
+
+// metrics gives the usage of each principal, and the limits
+// that apply, for /metrics.
+func metrics() []stats.Metric {
+	mutex.Lock()
+	principals := make([]string, 0, len(usages))
+	for principal := range usages {
+		principals = append(principals, principal)
+	}
+	mutex.Unlock()
+	sort.Strings(principals)
+
+	requestsTotal := stats.Metric{Name: "varlog_quota_requests_total", Type: "counter",
+		Help: "Requests served, by principal."}
+	bytesTotal := stats.Metric{Name: "varlog_quota_bytes_total", Type: "counter",
+		Help: "Response bytes served, by principal."}
+	rejected := stats.Metric{Name: "varlog_quota_rejected_total", Type: "counter",
+		Help: "Requests refused for a used-up quota, by principal."}
+	used := stats.Metric{Name: "varlog_quota_used", Type: "gauge",
+		Help: "Usage in the current window, by principal, resource, and window."}
+	limit := stats.Metric{Name: "varlog_quota_limit", Type: "gauge",
+		Help: "Quota per window, by principal, resource, and window."}
+	now := time.Now()
+	for _, principal := range principals {
+		l, _ := limitsFor(principal)
+		u := lookup(principal)
+		u.mutex.Lock()
+		u.roll(now)
+		labels := []string{"principal", principal}
+		requestsTotal.Samples = append(requestsTotal.Samples, stats.Sample{Labels: labels, Value: float64(u.total[requests])})
+		bytesTotal.Samples = append(bytesTotal.Samples, stats.Sample{Labels: labels, Value: float64(u.total[bytes])})
+		rejected.Samples = append(rejected.Samples, stats.Sample{Labels: labels, Value: float64(u.rejected)})
+		for resource := range u.used {
+			for window := range u.used[resource] {
+				labels := []string{"principal", principal,
+					"resource", resourceNames[resource], "window", windowNames[window]}
+				used.Samples = append(used.Samples, stats.Sample{Labels: labels, Value: float64(u.used[resource][window])})
+				if l[resource][window] > 0 {
+					limit.Samples = append(limit.Samples, stats.Sample{Labels: labels, Value: float64(l[resource][window])})
+				}
+			}
+		}
+		u.mutex.Unlock()
+	}
+	return []stats.Metric{requestsTotal, bytesTotal, rejected, used, limit}
+}
@@ -0,0 +1,173 @@
+package quota
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"varlog/service/app"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected int64
+		ok       bool
+	}{
+		{"1024", 1024, true},
+		{"512B", 512, true},
+		{"10K", 10 << 10, true},
+		{"10KiB", 10 << 10, true},
+		{"3MB", 3 << 20, true},
+		{"10GiB", 10 << 30, true},
+		{"2t", 2 << 40, true},
+		{"1.5G", 0, false},
+		{"10X", 0, false},
+		{"GiB", 0, false},
+		{"99999999999T", 0, false},
+	}
+	for _, test := range tests {
+		n, err := parseSize(test.value)
+		if (err == nil) != test.ok || n != test.expected {
+			t.Errorf("%q: expected %d %v, got %d %v", test.value, test.expected, test.ok, n, err)
+		}
+	}
+}
+
+// setup loads quotas from the lines, with no usage counted.
+func setup(t *testing.T, lines ...string) {
+	name := filepath.Join(t.TempDir(), "quotas")
+	if err := os.WriteFile(name, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	props := app.DefaultProperties()
+	props.SetQuotaFile(name)
+	if err := Setup(props); err != nil {
+		t.Fatal(err)
+	}
+	mutex.Lock()
+	usages = map[string]*usage{}
+	mutex.Unlock()
+	t.Cleanup(func() { Setup(app.DefaultProperties()) })
+}
+
+func TestSetup_errors(t *testing.T) {
+	for _, line := range []string{
+		"requests/hour=10",
+		"alice: requests/week=10",
+		"alice: lines/hour=10",
+		"alice: requests/hour=0",
+		"alice: requests/hour=10K",
+		"alice: bytes/day",
+	} {
+		name := filepath.Join(t.TempDir(), "quotas")
+		os.WriteFile(name, []byte(line+"\n"), 0644)
+		props := app.DefaultProperties()
+		props.SetQuotaFile(name)
+		if err := Setup(props); err == nil {
+			t.Errorf("%q: expected an error", line)
+		}
+	}
+	Setup(app.DefaultProperties())
+}
+
+func TestAdmit(t *testing.T) {
+	setup(t,
+		"# Quotas",
+		"alice: requests/hour=2 requests/day=3",
+		"*:     bytes/hour=100",
+	)
+	now := time.Date(2026, 10, 15, 13, 30, 0, 0, time.UTC)
+	for j := 0; j < 2; j++ {
+		if _, _, err := admit("alice", now); err != nil {
+			t.Fatalf("request %d: expected admission, got %s", j, err)
+		}
+	}
+	_, reset, err := admit("alice", now)
+	if err == nil || err.Status != http.StatusTooManyRequests || !reset.Equal(now.Add(30*time.Minute)) {
+		t.Errorf("expected 429 until 14:00, got %v %s", err, reset)
+	}
+	if err != nil && !strings.Contains(err.Message, "2 requests per hour") {
+		t.Errorf("expected the hourly quota in the message, got %q", err.Message)
+	}
+	// The next hour allows one more, then the daily quota decides.
+	if _, _, err := admit("alice", now.Add(time.Hour)); err != nil {
+		t.Errorf("next hour: expected admission, got %s", err)
+	}
+	_, reset, err = admit("alice", now.Add(time.Hour))
+	if err == nil || !reset.Equal(time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("next hour: expected 429 until midnight, got %v %s", err, reset)
+	}
+	if _, _, err := admit("alice", now.Add(11*time.Hour)); err != nil {
+		t.Errorf("next day: expected admission, got %s", err)
+	}
+
+	// Others get the "*" quota.
+	u, _, err := admit("bob", now)
+	if err != nil {
+		t.Fatalf("bob: expected admission, got %s", err)
+	}
+	u.add(bytes, 100, now)
+	if _, _, err := admit("bob", now); err == nil || !strings.Contains(err.Message, "100 bytes per hour") {
+		t.Errorf("bob: expected the byte quota, got %v", err)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	setup(t, "alice: requests/day=1")
+	handler := Wrap(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte("twelve bytes"))
+	})
+	serve := func(principal string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("GET", "/read?name=app.log", nil)
+		request = request.WithContext(app.WithPrincipal(request.Context(), principal))
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		return recorder
+	}
+	if r := serve("alice"); r.Code != http.StatusOK {
+		t.Errorf("first request: expected 200, got %d", r.Code)
+	}
+	r := serve("alice")
+	if r.Code != http.StatusTooManyRequests || r.Header().Get(app.HdrRetryAfter) == "" ||
+		!strings.Contains(r.Body.String(), app.CodeTooManyRequests) {
+		t.Errorf("second request: expected 429 with Retry-After, got %d %v %q", r.Code, r.Header(), r.Body)
+	}
+	// Without a principal, nothing is counted.
+	for j := 0; j < 3; j++ {
+		if r := serve(""); r.Code != http.StatusOK {
+			t.Errorf("anonymous request: expected 200, got %d", r.Code)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		labels   []string
+		expected float64
+	}{
+		{"varlog_quota_requests_total", []string{"principal", "alice"}, 1},
+		{"varlog_quota_bytes_total", []string{"principal", "alice"}, 12},
+		{"varlog_quota_rejected_total", []string{"principal", "alice"}, 1},
+		{"varlog_quota_used", []string{"principal", "alice", "resource", "requests", "window", "day"}, 1},
+		{"varlog_quota_limit", []string{"principal", "alice", "resource", "requests", "window", "day"}, 1},
+		{"varlog_quota_requests_total", []string{"principal", ""}, -1},
+	}
+	all := metrics()
+	for _, test := range tests {
+		value := -1.0
+		for _, m := range all {
+			for _, s := range m.Samples {
+				if m.Name == test.name && reflect.DeepEqual(s.Labels, test.labels) {
+					value = s.Value
+				}
+			}
+		}
+		if value != test.expected {
+			t.Errorf("%s%v: expected %g, got %g", test.name, test.labels, test.expected, value)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package read
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"time"
+	"varlog/service/app"
+	"varlog/service/scan"
+	"varlog/service/stats"
+	"varlog/service/tracing"
+)
+
+// The /aggregate endpoint counts the lines of a file matching the
+// filter in time buckets, by their leading timestamps, for charting
+// when errors spiked without reading the lines themselves.
+//
+// Parameter 'name=path' names a file, or a directory, whose regular
+// files are counted together.  Parameter 'bucket=duration' gives the
+// bucket width, as 1m or 1h, default one minute.  The filter, multiline,
+// encoding, sanitize, since, and until parameters apply as for /read.
+//
+// The response is a JSON object with the count in each bucket, oldest
+// first, from the bucket holding the first match (or since) to the one
+// holding the last (or until), including the empty ones between.
+// Matching lines without a timestamp are counted apart, as untimed.
+
+// The most buckets in one response.  A narrow bucket over a long
+// time span is refused rather than filling memory.
+const maxBuckets = 10000
+
+// Totals of a scan for /aggregate or /top, common to their responses.
+type scanTotals struct {
+	Name         string `json:"name"`          // File or directory, relative to the root
+	Files        int    `json:"files"`         // Files counted
+	Matches      int    `json:"matches"`       // Lines, or records with multiline
+	LinesScanned int    `json:"lines_scanned"` // Physical lines examined
+	BytesScanned int64  `json:"bytes_scanned"` // File bytes read
+}
+
+// Response for /aggregate.
+type aggregateResult struct {
+	scanTotals
+	Bucket  string `json:"bucket"`          // Bucket width, as a Go duration
+	Start   string `json:"start,omitempty"` // Start of the first bucket, RFC 3339
+	Counts  []int  `json:"counts"`          // Matches in each bucket, oldest first
+	Untimed int    `json:"untimed"`         // Matches without a timestamp
+}
+
+// AggregateHandler serves /aggregate.
+func AggregateHandler(writer http.ResponseWriter, request *http.Request) {
+	var t0 = time.Now()
+	props, files, ok := requestFiles(writer, request)
+	if !ok {
+		return
+	}
+	result := aggregateResult{scanTotals: scanTotals{Name: props.RelativePath()}, Bucket: props.ParamBucket().String()}
+	bucket := props.ParamBucket()
+	counts := map[int64]int{}
+	add := func(file *app.Properties, record []string) {
+		t, ok := file.LineTime(record[0])
+		if !ok {
+			result.Untimed++
+			return
+		}
+		counts[t.Truncate(bucket).Unix()]++
+	}
+	if !scanFiles(writer, request, files, &result.scanTotals, add) {
+		return
+	}
+	if err := setBuckets(props, &result, counts); err != nil {
+		app.WriteError(writer, request, err)
+		return
+	}
+	writeJSON(writer, request, result)
+	app.Log(app.LogDebug, "/aggregate %d files, %d matches, %v", result.Files, result.Matches, time.Since(t0))
+}
+
+// requestFiles checks the request's parameters and access to the
+// named path, giving the request's properties and those of the files
+// to scan.  On error, the error response is written, giving false.
+func requestFiles(writer http.ResponseWriter, request *http.Request) (*app.Properties, []*app.Properties, bool) {
+	var props *app.Properties = app.RequestProperties(request)
+	app.Log(app.LogDebug, "%q", request.URL)
+
+	if err := props.ExtractParams(request); err != nil {
+		app.WriteError(writer, request, err)
+		return nil, nil, false
+	}
+	if app.Denied(props.RelativePath()) {
+		app.Log(app.LogWarning, "Denied path %q requested", props.RelativePath())
+		app.Error(writer, request, "Not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	if !props.Authorized(props.RelativePath()) {
+		app.Log(app.LogWarning, "Principal %q not authorized for %q", props.Principal(), props.RelativePath())
+		app.Error(writer, request, "Access denied", http.StatusForbidden)
+		return nil, nil, false
+	}
+	files, err := matchFiles(props)
+	if err != nil {
+		app.WriteError(writer, request, err)
+		return nil, nil, false
+	}
+	return props, files, true
+}
+
+// scanFiles scans each file, calling add for each matching line
+// (record, with multiline).  On error, the error response is written,
+// giving false.
+func scanFiles(writer http.ResponseWriter, request *http.Request, files []*app.Properties,
+	totals *scanTotals, add func(file *app.Properties, record []string)) bool {
+	for _, file := range files {
+		file := file
+		err := scanMatches(request.Context(), file, totals, func(record []string) { add(file, record) })
+		if err != nil {
+			if !canceled(err) {
+				app.WriteError(writer, request, endChanged(file, writer, nil, err))
+			}
+			return false
+		}
+		totals.Files++
+	}
+	return true
+}
+
+// writeJSON writes the response as JSON.  Nothing is written until
+// the scan ends, so an error still gets a proper error response.
+func writeJSON(writer http.ResponseWriter, request *http.Request, response any) {
+	b, err := json.Marshal(response)
+	if err != nil {
+		app.WriteError(writer, request, err)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(append(b, '\n'))
+}
+
+// matchFiles gives the properties for each file to scan: the named
+// file, or the regular files of the named directory, skipping those
+// denied, not authorized, or linked from outside the root.
+func matchFiles(props *app.Properties) ([]*app.Properties, error) {
+	info, err := app.Stat(props.FileSystem(), props.RootedPath())
+	if err != nil {
+		return nil, app.FileError(props.RelativePath(), err)
+	}
+	if info.Mode().IsRegular() {
+		return []*app.Properties{props}, nil
+	}
+	if !info.IsDir() {
+		return nil, app.ParamError(app.ParamName,
+			fmt.Sprintf("Special file %q not allowed", props.RelativePath()))
+	}
+	entries, err := app.ReadDir(props.FileSystem(), props.RootedPath())
+	if err != nil {
+		return nil, app.FileError(props.RelativePath(), err)
+	}
+	var files []*app.Properties
+	for _, entry := range entries {
+		file := props.Copy()
+		file.SetRootedPath(path.Join(props.RootedPath(), entry.Name()))
+		if app.Denied(file.RelativePath()) || !file.Authorized(file.RelativePath()) {
+			continue
+		}
+		// Stat, rather than the entry's type, follows symbolic links,
+		// which must stay under the root, as for the name parameter.
+		if file.CheckContained() != nil {
+			continue
+		}
+		info, err := app.Stat(file.FileSystem(), file.RootedPath())
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// scanMatches scans the file, within any time range, calling add for
+// each line (record, with multiline) the filter allows.
+func scanMatches(ctx context.Context, props *app.Properties, totals *scanTotals, add func(record []string)) error {
+	file, err := app.Open(props.FileSystem(), props.RootedPath())
+	if err != nil {
+		app.Log(app.LogWarning, "Cannot open %s: %s", props.RootedPath(), err.Error())
+		return app.FileError(props.RelativePath(), err)
+	}
+	file = tracing.File(ctx, file)
+	defer file.Close()
+	var info fs.FileInfo
+	if info, err = file.Stat(); err != nil {
+		return err
+	}
+	x := fileIndex(ctx, props, info)
+	if file, info, err = decodeFile(props, file, info); err != nil {
+		return err
+	}
+	if utf16Encoding(props) {
+		x = nil
+	}
+	start, end, err := timeRange(ctx, props, file, info.Size(), x)
+	if err != nil {
+		return err
+	}
+	var section io.ReaderAt = file
+	if start > 0 || end < info.Size() {
+		section = io.NewSectionReader(file, start, end-start)
+	}
+	r := scan.NewReverser(ctx, section, end-start, props.ChunkSize())
+	r.SetMaxLine(props.MaxLineBytes())
+	defer func() {
+		stats.AddBytesScanned(ctx, r.BytesRead())
+		totals.BytesScanned += r.BytesRead()
+	}()
+	match := func(record []string) {
+		if props.FilterAllowsRecord(record) {
+			totals.Matches++
+			add(record)
+		}
+	}
+
+	var grouper scan.RecordGrouper
+	for r.Scan() {
+		for _, s := range r.Lines() {
+			totals.LinesScanned++
+			s = decodeLine(props, s)
+			if !props.ParamMultiline() {
+				match([]string{s})
+				continue
+			}
+			if record, ok := grouper.Add(s); ok {
+				match(record)
+			}
+		}
+	}
+	if err := r.Err(); err != nil {
+		return err
+	}
+	if record, ok := grouper.Flush(); ok {
+		match(record)
+	}
+	return nil
+}
+
+// setBuckets fills in the result's buckets from the counts: from
+// since, or the first count, to until, or the last.  Refuses a range
+// of more than maxBuckets.
+func setBuckets(props *app.Properties, result *aggregateResult, counts map[int64]int) error {
+	bucket := props.ParamBucket()
+	var first, last time.Time
+	for key := range counts {
+		t := time.Unix(key, 0)
+		if first.IsZero() || t.Before(first) {
+			first = t
+		}
+		if last.IsZero() || t.After(last) {
+			last = t
+		}
+	}
+	if since := props.ParamSince(); !since.IsZero() {
+		first = since.Truncate(bucket)
+	}
+	if until := props.ParamUntil(); !until.IsZero() {
+		last = until.Truncate(bucket)
+	}
+	result.Counts = []int{}
+	if first.IsZero() || last.IsZero() || last.Before(first) {
+		return nil
+	}
+	n := int64(last.Sub(first)/bucket) + 1
+	if n > maxBuckets {
+		return app.ParamError(app.ParamBucket,
+			fmt.Sprintf("Bucket %s gives %d buckets over the time range, more than %d", bucket, n, maxBuckets))
+	}
+	result.Start = first.UTC().Format(time.RFC3339)
+	result.Counts = make([]int, n)
+	for j := range result.Counts {
+		result.Counts[j] = counts[first.Add(time.Duration(j)*bucket).Unix()]
+	}
+	return nil
+}
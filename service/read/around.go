@@ -0,0 +1,185 @@
+package read
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"varlog/service/app"
+	"varlog/service/index"
+	"varlog/service/stats"
+)
+
+// Lines around a line number.  'around-line=N&context=M' writes lines
+// N-M through N+M, counted from 1, in file order, as for the line a
+// stack trace names.  With a fresh index (-index-dir), the read starts
+// at the last checkpoint before line N-M, so it scans at most the
+// checkpoint interval's lines that are not written; otherwise it scans
+// forward from the start of the file.  The X-Varlog-First-Line header
+// gives the number of the first line of the range.  Filters and
+// extraction apply to the lines of the range.
+
+// checkAround refuses the parameters that do not apply to a range of
+// lines in file order.
+func checkAround(props *app.Properties, mode fs.FileMode) error {
+	if props.ParamAroundLine() == 0 {
+		return nil
+	}
+	if props.ParamFollow() || props.ParamMultiline() || props.ParamDedupe() || props.ParamMode() != app.ModeLines ||
+		!props.ParamSince().IsZero() || !props.ParamUntil().IsZero() || props.ParamFormat() == app.FormatHexdump ||
+		props.ParamPage().Paginated() || props.ParamCount() != 0 || mode&fs.ModeNamedPipe != 0 {
+		return app.ParamError(app.ParamAroundLine,
+			"around-line cannot be used with follow, multiline, dedupe, mode, since, until, format=hexdump, page-size, count, or a named pipe")
+	}
+	return nil
+}
+
+// writeAround writes the lines around the 'around-line'.  A file
+// without the range's first line gets an error, since nothing could
+// be written.
+func writeAround(props *app.Properties, writer http.ResponseWriter, request *http.Request) (totalLines int, err error) {
+	file, err := app.Open(props.FileSystem(), props.RootedPath())
+	if err != nil {
+		app.Log(app.LogWarning, "Cannot open %s: %s", props.RootedPath(), err.Error())
+		return 0, app.FileError(props.RelativePath(), err)
+	}
+	defer file.Close()
+	long := newLineLimit(props)
+	defer func() {
+		err = long.end(props, writer, totalLines > 0, err)
+	}()
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return 0, app.FileError(props.RelativePath(), err)
+	}
+	setFileHeaders(writer, fileInfo)
+	size := fileInfo.Size()
+	x := fileIndex(request.Context(), props, fileInfo)
+	if file, fileInfo, err = decodeFile(props, file, fileInfo); err != nil {
+		return 0, err
+	}
+	if utf16Encoding(props) {
+		// Offsets in the index are of the file, not the conversion.
+		x = nil
+	}
+	first := props.ParamAroundLine() - int64(props.ParamContext())
+	if first < 1 {
+		first = 1
+	}
+	last := props.ParamAroundLine() + int64(props.ParamContext())
+	var start index.Checkpoint
+	if x != nil {
+		start = x.Checkpoint(first - 1)
+	}
+	r := &lineReader{
+		reader:  bufio.NewReaderSize(io.NewSectionReader(file, start.Offset, fileInfo.Size()-start.Offset), props.ChunkSize()),
+		maxLine: props.MaxLineBytes(),
+	}
+	defer func() {
+		stats.AddBytesScanned(request.Context(), r.scanned)
+	}()
+
+	// Skip to the first line before writing, so the status can
+	// still tell of a file without it.
+	n := start.Line + 1 // Number of the next line
+	for ; n < first; n++ {
+		if err := request.Context().Err(); err != nil {
+			return 0, err
+		}
+		if _, ok, err := r.next(false); err != nil || !ok {
+			return 0, r.missing(props, n-1, size, err)
+		}
+	}
+	if _, err := r.reader.Peek(1); err != nil {
+		return 0, r.missing(props, n-1, size, err)
+	}
+
+	selectContentDisposition(props, writer, file)
+	header := writer.Header()
+	header.Set("Accept-Ranges", "none")
+	header.Set("Content-Type", app.MediaType(props.ParamFormat()))
+	header.Set(app.HdrFirstLine, strconv.FormatInt(first, 10))
+	limit := NewResponseCap(props, writer)
+	defer limit.Signal(writer)
+	long.declare(writer)
+	defer long.signal(writer)
+	enc := newLineEncoder(props, writer)
+	defer func() {
+		if err == nil || totalLines > 0 {
+			enc.close(nil)
+		}
+	}()
+	for ; n <= last; n++ {
+		s, ok, err := r.next(true)
+		if err != nil || !ok {
+			return totalLines, err
+		}
+		if s, ok, err = selectLine(props, long, s); err != nil {
+			return totalLines, err
+		}
+		if !ok {
+			continue
+		}
+		if !limit.Allow(s) {
+			break
+		}
+		enc.write(s)
+		totalLines++
+	}
+	return totalLines, nil
+}
+
+// A lineReader reads a file's lines forward.
+type lineReader struct {
+	reader  *bufio.Reader
+	maxLine int   // Bytes kept of a line, 0 for whole lines
+	scanned int64 // Bytes read
+}
+
+// next reads a line, giving it without its newline, or a "\r" before
+// one, as scan.Reverser does; or false at the end of the file.  A line
+// longer than maxLine is cut to maxLine+1 bytes, so the line limit
+// can tell it was long.  Unless keep, the line is skipped, not given.
+func (r *lineReader) next(keep bool) (string, bool, error) {
+	var b []byte
+	read := false
+	for {
+		chunk, err := r.reader.ReadSlice('\n')
+		r.scanned += int64(len(chunk))
+		read = read || len(chunk) > 0
+		if keep && (r.maxLine <= 0 || len(b) <= r.maxLine) {
+			b = append(b, chunk...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF {
+			if !read {
+				return "", false, nil
+			}
+			break
+		}
+		if err != nil {
+			return "", false, err
+		}
+		break
+	}
+	b = bytes.TrimSuffix(bytes.TrimSuffix(b, []byte("\n")), []byte("\r"))
+	if r.maxLine > 0 && len(b) > r.maxLine+1 {
+		b = b[:r.maxLine+1]
+	}
+	return string(b), true, nil
+}
+
+// missing gives the error for a file of the given size ending before
+// the range, having the number of lines given, or the error reading it.
+func (r *lineReader) missing(props *app.Properties, lines int64, size int64, err error) error {
+	if err != nil && err != io.EOF {
+		return err
+	}
+	return app.RangeError(app.ParamAroundLine,
+		fmt.Sprintf("File %q has %d lines, fewer than the range around line %d", props.RelativePath(), lines, props.ParamAroundLine()), size)
+}
@@ -0,0 +1,141 @@
+package read
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+	"varlog/service/app"
+	"varlog/service/scan"
+)
+
+const (
+	// Maximum bytes read from the sample file for each chunk size.
+	// Enough to smooth out timer noise without a long startup delay.
+	calibrateBudget = 64 * 1024 * 1024
+
+	// Maximum directory entries examined when choosing a sample file.
+	calibrateMaxEntries = 10000
+
+	// A chunk size within this fraction of the best throughput is
+	// considered equally good.  The smallest such size is suggested,
+	// since smaller chunks use less memory per request.
+	calibrateTolerance = 0.05
+)
+
+// Chunk sizes measured by Calibrate, all powers of 2.
+var CalibrateSizes = []int{4 * 1024, 16 * 1024, 64 * 1024, 256 * 1024, 1024 * 1024}
+
+// CalibrationResult reports the measured throughput for one chunk size.
+type CalibrationResult struct {
+	ChunkSize int           // Bytes per ReadAt
+	Bytes     int64         // Total bytes read
+	Elapsed   time.Duration // Time spent reading
+}
+
+// MBPerSecond gives the throughput in megabytes (2^20) per second.
+func (c CalibrationResult) MBPerSecond() float64 {
+	if c.Elapsed <= 0 {
+		return 0
+	}
+	return float64(c.Bytes) / (1024 * 1024) / c.Elapsed.Seconds()
+}
+
+// Calibrate measures backward ReadAt throughput on the root volume
+// for each of the given chunk sizes, using the largest regular file
+// found under the root as sample data.  The file is read once first
+// to warm the page cache, so each size sees the same conditions.
+// Returns the results (in the order of sizes) and the suggested size.
+func Calibrate(root string, sizes []int) (results []CalibrationResult, best int, err error) {
+	sample, err := calibrationSample(root)
+	if err != nil {
+		return nil, 0, err
+	}
+	app.Log(app.LogInfo, "Calibrating chunk size with %q", sample)
+	file, err := os.Open(sample)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	if _, err = readBackward(file, app.DefaultChunkSize()); err != nil {
+		return nil, 0, err
+	}
+	var bestRate float64
+	for _, size := range sizes {
+		t0 := time.Now()
+		n, err := readBackward(file, size)
+		if err != nil {
+			return nil, 0, err
+		}
+		result := CalibrationResult{ChunkSize: size, Bytes: n, Elapsed: time.Since(t0)}
+		results = append(results, result)
+		if rate := result.MBPerSecond(); rate > bestRate {
+			bestRate = rate
+		}
+	}
+	for _, result := range results {
+		if result.MBPerSecond() >= bestRate*(1-calibrateTolerance) {
+			return results, result.ChunkSize, nil
+		}
+	}
+	return results, app.DefaultChunkSize(), nil
+}
+
+// readBackward reads the file from the end using a reverser with the
+// given chunk size, stopping at EOF or the calibration budget.  Lines
+// are not parsed, so this measures ReadAt throughput.
+// Returns the number of bytes read.
+func readBackward(file *os.File, chunkSize int) (total int64, err error) {
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	r := scan.NewReverser(context.Background(), file, fileInfo.Size(), chunkSize)
+	for total < calibrateBudget && r.Scan() {
+		total += int64(chunkSize)
+	}
+	if total > fileInfo.Size() {
+		total = fileInfo.Size()
+	}
+	return total, r.Err()
+}
+
+// calibrationSample finds the largest regular file under the root,
+// examining a bounded number of entries.
+func calibrationSample(root string) (string, error) {
+	var sample string
+	var sampleSize int64
+	var entries int
+	errDone := errors.New("done")
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Skip unreadable directories; they are not needed.
+			return nil
+		}
+		if entries++; entries > calibrateMaxEntries {
+			return errDone
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		fileInfo, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if fileInfo.Size() > sampleSize {
+			sample, sampleSize = p, fileInfo.Size()
+		}
+		return nil
+	})
+	if err != nil && err != errDone {
+		return "", err
+	}
+	if sample == "" {
+		return "", errors.New(fmt.Sprintf("No readable file under %q to calibrate", root))
+	}
+	return sample, nil
+}
@@ -0,0 +1,157 @@
+package read
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"varlog/service/app"
+)
+
+const (
+	// Chunks captured on each side of the failing chunk.
+	captureContextChunks = 1
+
+	// Upper bound on the captured file excerpt, whatever the chunk size.
+	captureMaxBytes = 4 * 1024 * 1024
+)
+
+// Headers never written to a capture bundle.
+var captureSensitiveHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+// Parts of header and parameter names that suggest credentials, as
+// X-Api-Key or access_token.  Their values are replaced in a bundle.
+var captureSensitiveNames = []string{"auth", "key", "password", "secret", "session", "signature", "token"}
+
+// The value written in place of a credential.
+const captureRedacted = "[REDACTED]"
+
+// The request.json file of a capture bundle.
+type captureRequest struct {
+	Method    string              `json:"method"`
+	Path      string              `json:"path"`
+	Query     map[string][]string `json:"query"`
+	Headers   map[string][]string `json:"headers"`
+	Principal string              `json:"principal,omitempty"`
+	Error     string              `json:"error"`
+}
+
+// The config.json file of a capture bundle.  The root is omitted;
+// the name relative to the root is enough to reproduce the problem.
+type captureConfig struct {
+	ChunkSize     int    `json:"chunkSize"`
+	Name          string `json:"name"`
+	FileSize      int64  `json:"fileSize"`
+	FailedOffset  int64  `json:"failedOffset"`
+	ExcerptOffset int64  `json:"excerptOffset"`
+	ExcerptLength int    `json:"excerptLength"`
+	Time          string `json:"time"`
+}
+
+// captureFailure writes a diagnostic bundle for a failed /read, if
+// enabled with -capture-dir.  The bundle, a new directory, holds:
+//
+//   - request.json: the request, without credentials or cookies.
+//     Parameters and headers whose names suggest credentials have
+//     their values replaced.
+//   - config.json: chunk size, file size, and the failing offset.
+//   - excerpt.bin: the file bytes around the failing chunk, so
+//     reverse-reading edge cases can be reproduced with -chunk.
+//   - log.txt: recent server log entries for this request, only.
+//
+// Errors writing the bundle are logged and otherwise ignored.
+func captureFailure(props *app.Properties, request *http.Request,
+	file io.ReaderAt, fileSize int64, failedOffset int64, failure error) {
+	if props.CaptureDir() == "" {
+		return
+	}
+	dir, err := os.MkdirTemp(props.CaptureDir(),
+		"varlog-capture-"+time.Now().UTC().Format("20060102T150405Z")+"-")
+	if err != nil {
+		app.Log(app.LogError, "Capture failed: %s", err)
+		return
+	}
+
+	// The excerpt covers the failing chunk and its neighbors.
+	span := int64(props.ChunkSize()) * captureContextChunks
+	start := failedOffset - span
+	if start < 0 {
+		start = 0
+	}
+	end := failedOffset + int64(props.ChunkSize()) + span
+	if end > fileSize {
+		end = fileSize
+	}
+	if end-start > captureMaxBytes {
+		end = start + captureMaxBytes
+	}
+	excerpt := make([]byte, end-start)
+	n, err := file.ReadAt(excerpt, start)
+	if err != nil && err != io.EOF {
+		app.Log(app.LogWarning, "Capture excerpt incomplete: %s", err)
+	}
+	excerpt = excerpt[:n]
+
+	headers := request.Header.Clone()
+	for _, h := range captureSensitiveHeaders {
+		headers.Del(h)
+	}
+	scrubCredentials(headers)
+	query := request.URL.Query()
+	scrubCredentials(query)
+	files := map[string]interface{}{
+		"request.json": captureRequest{
+			Method:    request.Method,
+			Path:      request.URL.Path,
+			Query:     query,
+			Headers:   headers,
+			Principal: props.Principal(),
+			Error:     failure.Error(),
+		},
+		"config.json": captureConfig{
+			ChunkSize:     props.ChunkSize(),
+			Name:          props.RelativePath(),
+			FileSize:      fileSize,
+			FailedOffset:  failedOffset,
+			ExcerptOffset: start,
+			ExcerptLength: len(excerpt),
+			Time:          time.Now().UTC().Format(time.RFC3339),
+		},
+	}
+	for name, v := range files {
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err == nil {
+			err = os.WriteFile(filepath.Join(dir, name), b, 0o600)
+		}
+		if err != nil {
+			app.Log(app.LogError, "Capture of %s failed: %s", name, err)
+		}
+	}
+	if err = os.WriteFile(filepath.Join(dir, "excerpt.bin"), excerpt, 0o600); err != nil {
+		app.Log(app.LogError, "Capture of excerpt failed: %s", err)
+	}
+	logText := strings.Join(app.RecentLogs(app.RequestID(request.Context())), "\n") + "\n"
+	if err = os.WriteFile(filepath.Join(dir, "log.txt"), []byte(logText), 0o600); err != nil {
+		app.Log(app.LogError, "Capture of log failed: %s", err)
+	}
+	app.Log(app.LogInfo, "Captured failing request in %q", dir)
+}
+
+// scrubCredentials replaces the values of headers or parameters whose
+// names suggest credentials.
+func scrubCredentials(values map[string][]string) {
+	for name, v := range values {
+		lower := strings.ToLower(name)
+		for _, part := range captureSensitiveNames {
+			if strings.Contains(lower, part) {
+				for i := range v {
+					v[i] = captureRedacted
+				}
+				break
+			}
+		}
+	}
+}
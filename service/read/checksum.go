@@ -0,0 +1,68 @@
+package read
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"varlog/service/app"
+	"varlog/service/scan"
+	"varlog/service/stats"
+)
+
+// Response for 'mode=checksum'.
+type checksumResult struct {
+	Name   string `json:"name"`   // File, relative to the root
+	Size   int64  `json:"size"`   // Bytes hashed
+	SHA256 string `json:"sha256"` // Hex digest of the file's bytes
+}
+
+// contextReader stops reading when the context ends, so an abandoned
+// checksum of a large file does not run to the end.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// writeChecksum hashes the file's bytes, front to back, as they were
+// at the start of the request, writing the digest and size as JSON.
+// Bytes appended during the hash are left out, so the size tells
+// which prefix of a growing log the digest covers.  Filters and other
+// line parameters do not apply.
+func writeChecksum(props *app.Properties, writer http.ResponseWriter, request *http.Request, file app.File, size int64) error {
+	hash := sha256.New()
+	buffer := make([]byte, props.ChunkSize())
+	section := &contextReader{request.Context(), io.NewSectionReader(file, 0, size)}
+	n, err := io.CopyBuffer(hash, section, buffer)
+	stats.AddBytesScanned(request.Context(), n)
+	if err != nil {
+		if canceled(err) {
+			app.Log(app.LogInfo, "Checksum of %q canceled after %d bytes, %s", props.RelativePath(), n, err)
+		}
+		return err
+	}
+	if n < size {
+		return &scan.ChangedError{Offset: n, Reason: fmt.Sprintf("read %d of %d bytes, file truncated", n, size)}
+	}
+	b, err := json.Marshal(checksumResult{
+		Name:   props.RelativePath(),
+		Size:   n,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	})
+	if err != nil {
+		return err
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(append(b, '\n'))
+	return nil
+}
@@ -0,0 +1,108 @@
+package read
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"varlog/service/app"
+	"varlog/service/scan"
+)
+
+// Encodings.  Lines are written as UTF-8.  A file in another encoding,
+// named by the 'encoding' parameter or detected from its first bytes,
+// is converted: line by line for single-byte encodings, whose newlines
+// are the same byte, and as a whole for UTF-16, whose newlines are two
+// bytes and cannot be found reading backwards.  Detection is part of
+// sanitizing: with sanitize=false, only a named encoding is converted.
+
+const (
+	// Bytes at the start of a file examined to detect its encoding.
+	encodingSample = 4096
+
+	// The largest UTF-16 file converted, which is done in memory.
+	maxUTF16Bytes = 64 * 1024 * 1024
+)
+
+// decodeFile settles the file's encoding, recording it in the
+// properties for decodeLine.  A UTF-16 file is converted, giving the
+// converted file and its description in place of the file's own.
+// On error, the file and description are returned as they are.
+func decodeFile(props *app.Properties, file app.File, info fs.FileInfo) (app.File, fs.FileInfo, error) {
+	if props.ParamEncoding() == "" && props.ParamSanitize() {
+		encoding, err := detectEncoding(file, info.Size())
+		if err != nil {
+			return file, info, err
+		}
+		props.SetParamEncoding(encoding)
+	}
+	if !utf16Encoding(props) {
+		return file, info, nil
+	}
+	if info.Size() > maxUTF16Bytes {
+		return file, info, app.NewHTTPError(http.StatusUnprocessableEntity, app.CodeUnsupported,
+			fmt.Sprintf("UTF-16 file %q is larger than %d bytes, the most converted", props.RelativePath(), maxUTF16Bytes))
+	}
+	data := make([]byte, info.Size())
+	if _, err := file.ReadAt(data, 0); err != nil && err != io.EOF {
+		return file, info, err
+	}
+	converted := scan.DecodeUTF16(data, props.ParamEncoding() == app.EncodingUTF16BE)
+	app.Log(app.LogDebug, "Converted %q from %s, %d bytes to %d", props.RelativePath(),
+		props.ParamEncoding(), len(data), len(converted))
+	return &convertedFile{file, bytes.NewReader(converted)}, convertedInfo{info, int64(len(converted))}, nil
+}
+
+// detectEncoding detects the encoding from the start of the file.
+func detectEncoding(file io.ReaderAt, size int64) (string, error) {
+	sample := make([]byte, encodingSample)
+	if size < encodingSample {
+		sample = sample[:size]
+	}
+	n, err := file.ReadAt(sample, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return scan.DetectEncoding(sample[:n]), nil
+}
+
+// utf16Encoding reports whether the file is UTF-16.
+func utf16Encoding(props *app.Properties) bool {
+	encoding := props.ParamEncoding()
+	return encoding == app.EncodingUTF16LE || encoding == app.EncodingUTF16BE
+}
+
+// convertedFile is a file converted to UTF-8, read from memory.
+// Closing it closes the file.
+type convertedFile struct {
+	app.File
+	data *bytes.Reader
+}
+
+func (f *convertedFile) Read(p []byte) (int, error) {
+	return f.data.Read(p)
+}
+
+func (f *convertedFile) ReadAt(p []byte, offset int64) (int, error) {
+	return f.data.ReadAt(p, offset)
+}
+
+func (f *convertedFile) Stat() (fs.FileInfo, error) {
+	info, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return convertedInfo{info, f.data.Size()}, nil
+}
+
+// convertedInfo describes a converted file: the file's, with the
+// converted size.
+type convertedInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (info convertedInfo) Size() int64 {
+	return info.size
+}
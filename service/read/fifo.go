@@ -0,0 +1,102 @@
+package read
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"varlog/service/app"
+)
+
+// writeFIFO writes lines from a named pipe, in order, as a daemon
+// writes them, with -read-fifos.  A pipe has no end, so the read is
+// bounded by -fifo-timeout and -fifo-max-bytes; the X-Varlog-Truncated
+// trailer tells which ended it.  Only follow=true applies, since a
+// pipe cannot be read backwards.  Lines read are taken from the pipe,
+// so another reader of the same pipe does not see them.
+func writeFIFO(props *app.Properties, writer http.ResponseWriter, request *http.Request) (totalLines int, err error) {
+	if !props.ParamFollow() {
+		return 0, app.ParamError(app.ParamName,
+			fmt.Sprintf("Read named pipe %q requires follow=true", props.RelativePath()))
+	}
+	if err := checkFollow(props); err != nil {
+		return 0, err
+	}
+	header := writer.Header()
+	header.Set("Content-Type", app.MediaType(props.ParamFormat()))
+	header.Set("Cache-Control", "no-cache")
+	if request.Method == http.MethodHead {
+		writer.WriteHeader(http.StatusOK)
+		return 0, nil
+	}
+	file, err := openFIFO(app.OSPath(props.RootedPath()))
+	if err != nil {
+		app.Log(app.LogWarning, "Cannot open %s: %s", props.RootedPath(), err.Error())
+		return 0, app.FileError(props.RelativePath(), err)
+	}
+	defer file.Close()
+
+	// The deadline bounds the read; the client going away ends it early.
+	if err = file.SetReadDeadline(time.Now().Add(props.FIFOTimeout())); err != nil {
+		return 0, err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-request.Context().Done():
+			file.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	limit := NewResponseCap(props, writer)
+	defer limit.Signal(writer)
+	header.Set(app.HdrTrailer, app.HdrTruncated)
+	long := newLineLimit(props)
+	long.declare(writer)
+	defer long.signal(writer)
+	defer func() { err = long.end(props, writer, true, err) }()
+	writer.WriteHeader(http.StatusOK)
+	flusher, _ := writer.(http.Flusher)
+	enc := newLineEncoder(props, writer)
+	input := &io.LimitedReader{R: file, N: props.FIFOMaxBytes()}
+	reader := bufio.NewReaderSize(input, props.ChunkSize())
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			s, ok, err := selectLine(props, long, strings.TrimSuffix(line, "\n"))
+			if err != nil {
+				return totalLines, err
+			}
+			if ok {
+				if !limit.Allow(s) {
+					return totalLines, nil
+				}
+				enc.write(s)
+				totalLines++
+			}
+		}
+		if err != nil {
+			switch {
+			case request.Context().Err() != nil:
+				return totalLines, request.Context().Err()
+			case err == io.EOF && input.N <= 0:
+				limit.truncated = truncatedBytes
+			case errors.Is(err, os.ErrDeadlineExceeded):
+				limit.truncated = truncatedTime
+			default:
+				return totalLines, err
+			}
+			app.Log(app.LogInfo, "Read of pipe %q ended at its %s limit", props.RelativePath(), limit.truncated)
+			return totalLines, nil
+		}
+		if flusher != nil && reader.Buffered() == 0 {
+			flusher.Flush()
+		}
+	}
+}
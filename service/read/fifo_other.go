@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package read
+
+import (
+	"errors"
+	"os"
+)
+
+// Without Unix named pipes, there are none to read.
+func openFIFO(path string) (*os.File, error) {
+	return nil, &os.PathError{Op: "open", Path: path, Err: errors.New("named pipes not supported")}
+}
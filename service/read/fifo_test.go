@@ -0,0 +1,69 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package read
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+	"varlog/service/app"
+	"varlog/service/apptest"
+)
+
+func TestHandler_fifo(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "daemon.pipe")
+	if err := syscall.Mkfifo(name, 0644); err != nil {
+		t.Skipf("mkfifo: %s", err)
+	}
+	props := app.DefaultProperties()
+	props.SetRoot(dir)
+	props.SetFIFOLimits(20, 200*time.Millisecond)
+	ts := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		Handler(writer, apptest.WithProperties(request, props))
+	}))
+	defer ts.Close()
+	fetch := func(query string) (*http.Response, string) {
+		response, err := ts.Client().Get(ts.URL + "/read?name=daemon.pipe" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		b, _ := io.ReadAll(response.Body)
+		return response, string(b)
+	}
+
+	// Refused unless enabled, and then only when followed.
+	if response, _ := fetch("&follow=true"); response.StatusCode != http.StatusBadRequest {
+		t.Errorf("disabled: expected 400, got %d", response.StatusCode)
+	}
+	props.SetReadFIFOs(true)
+	if response, _ := fetch(""); response.StatusCode != http.StatusBadRequest {
+		t.Errorf("without follow: expected 400, got %d", response.StatusCode)
+	}
+
+	// The time limit ends a quiet pipe.
+	response, body := fetch("&follow=true")
+	if response.StatusCode != http.StatusOK || body != "" || response.Trailer.Get(app.HdrTruncated) != truncatedTime {
+		t.Errorf("quiet: expected 200, no lines, and the time trailer, got %d %q %v", response.StatusCode, body, response.Trailer)
+	}
+
+	// The byte limit ends a busy one.
+	go func() {
+		f, err := os.OpenFile(name, os.O_WRONLY, 0)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		f.WriteString("one\nskip\nthree\nfour\nfive\nsix\n")
+	}()
+	response, body = fetch("&follow=true&filter=-skip")
+	if expected := "one\nthree\nfour\n"; body != expected || response.Trailer.Get(app.HdrTruncated) != truncatedBytes {
+		t.Errorf("busy: expected %q and the bytes trailer, got %q %v", expected, body, response.Trailer)
+	}
+}
@@ -0,0 +1,27 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package read
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// openFIFO opens a named pipe for reading without waiting for a
+// writer.  Opening it for writing too keeps reads from ending when
+// the daemon writing it closes and reopens it; nothing is written.
+// The descriptor is nonblocking, so reads wait in the runtime's
+// poller and honor deadlines.
+func openFIFO(path string) (*os.File, error) {
+	fd, err := syscall.Open(path, syscall.O_RDWR|syscall.O_NONBLOCK|syscall.O_CLOEXEC|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	var stat syscall.Stat_t
+	if err = syscall.Fstat(fd, &stat); err != nil || stat.Mode&syscall.S_IFMT != syscall.S_IFIFO {
+		syscall.Close(fd)
+		return nil, &os.PathError{Op: "open", Path: path, Err: errors.New("not a named pipe")}
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
@@ -0,0 +1,273 @@
+package read
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"time"
+	"varlog/service/app"
+	"varlog/service/scan"
+)
+
+// How often followers check files without change notifications.
+var followPollInterval = time.Second
+
+// A follower reads lines as they are appended to a file, in file
+// order, as tail -F does.  It survives rotation: a file truncated in
+// place is read again from the start, and a file renamed away is read
+// to its end before the new file at the path is opened.
+type follower struct {
+	props   *app.Properties
+	file    app.File
+	info    fs.FileInfo // Of the open file, to recognize replacement
+	offset  int64       // Next byte to read
+	partial []byte      // An unterminated last line, awaiting its newline
+	buffer  []byte
+	long    *lineLimit
+}
+
+// writeFollow writes the latest count lines, in file order, then
+// lines as they are appended, until the client goes away or a
+// response cap is reached.
+func writeFollow(props *app.Properties, writer http.ResponseWriter, request *http.Request) (totalLines int, err error) {
+	if err := checkFollow(props); err != nil {
+		return 0, err
+	}
+	file, err := app.Open(props.FileSystem(), props.RootedPath())
+	if err != nil {
+		app.Log(app.LogWarning, "Cannot open %s: %s", props.RootedPath(), err.Error())
+		return 0, app.FileError(props.RelativePath(), err)
+	}
+	f := &follower{props: props, file: file, buffer: make([]byte, props.ChunkSize()), long: newLineLimit(props)}
+	defer func() { f.file.Close() }()
+	if f.info, err = file.Stat(); err != nil {
+		return 0, err
+	}
+	f.offset = f.info.Size()
+	if props.ParamEncoding() == "" && props.ParamSanitize() {
+		encoding, err := detectEncoding(file, f.offset)
+		if err != nil {
+			return 0, err
+		}
+		if props.SetParamEncoding(encoding); utf16Encoding(props) {
+			return 0, app.NewHTTPError(http.StatusUnprocessableEntity, app.CodeUnsupported,
+				fmt.Sprintf("UTF-16 file %q cannot be followed", props.RelativePath()))
+		}
+	}
+	lines, err := latestLines(props, request, file, f.offset, props.ParamCount(), f.long)
+	if err != nil {
+		return 0, f.long.end(props, writer, false, err)
+	}
+
+	// The path for change notifications, for files on the local disk.
+	path := ""
+	if osFile, ok := file.(*os.File); ok {
+		path = osFile.Name()
+	}
+	w := newWatcher(path, followPollInterval)
+	defer w.close()
+
+	limit := NewResponseCap(props, writer)
+	defer limit.Signal(writer)
+	f.long.declare(writer)
+	defer f.long.signal(writer)
+	defer func() { err = f.long.end(props, writer, true, err) }()
+	header := writer.Header()
+	header.Set("Content-Type", app.MediaType(props.ParamFormat()))
+	header.Set("Cache-Control", "no-cache")
+	writer.WriteHeader(http.StatusOK)
+	flusher, _ := writer.(http.Flusher)
+	enc := newLineEncoder(props, writer)
+	// Writes a line, returning false when the response is full.
+	write := func(s string) bool {
+		if !limit.Allow(s) {
+			return false
+		}
+		enc.write(s)
+		totalLines++
+		return true
+	}
+	for _, s := range lines {
+		if !write(s) {
+			return totalLines, nil
+		}
+	}
+	for {
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case <-request.Context().Done():
+			return totalLines, request.Context().Err()
+		case <-w.changes:
+		}
+		ok, err := f.reopen(write)
+		if err != nil || !ok {
+			return totalLines, err
+		}
+		if ok, err = f.readNew(write); err != nil || !ok {
+			return totalLines, err
+		}
+	}
+}
+
+// checkFollow rejects parameters that need the whole file, which a
+// followed file or pipe does not have.
+func checkFollow(props *app.Properties) error {
+	if props.ParamMode() != app.ModeLines || props.ParamMultiline() || props.ParamDedupe() ||
+		!props.ParamSince().IsZero() || !props.ParamUntil().IsZero() || props.ParamFormat() == app.FormatHexdump || props.ParamFormat() == app.FormatJSON ||
+		utf16Encoding(props) {
+		return app.ParamError(app.ParamFollow,
+			"follow=true cannot be used with mode, multiline, dedupe, since, until, format=hexdump or json, or UTF-16 encodings")
+	}
+	return nil
+}
+
+// latestLines gives up to count of the last lines the request selects,
+// in file order.
+func latestLines(props *app.Properties, request *http.Request, file app.File, size int64, count int, long *lineLimit) ([]string, error) {
+	if count <= 0 {
+		return nil, nil
+	}
+	r := scan.NewReverser(request.Context(), file, size, props.ChunkSize())
+	r.SetMaxLine(props.MaxLineBytes())
+	var lines []string
+	for len(lines) < count && r.Scan() {
+		for _, s := range r.Lines() {
+			s, ok, err := selectLine(props, long, s)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				if lines = append(lines, s); len(lines) == count {
+					break
+				}
+			}
+		}
+	}
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines, r.Err()
+}
+
+// selectLine applies the line limit, decodes a line, and applies the
+// filter and extract parameters, giving the line to write, if any.
+func selectLine(props *app.Properties, long *lineLimit, s string) (string, bool, error) {
+	s, ok, err := long.apply(s)
+	if !ok {
+		return "", false, err
+	}
+	s = decodeLine(props, s)
+	if !props.FilterAllowsEntry(s) {
+		return "", false, nil
+	}
+	s, ok = props.Extract(s)
+	return s, ok, nil
+}
+
+// readNew writes the lines appended since the last read, returning
+// false if write stops.  A file shorter than the last read was
+// truncated, so it is read again from the start.
+func (f *follower) readNew(write func(string) bool) (bool, error) {
+	info, err := f.file.Stat()
+	if err != nil {
+		return false, err
+	}
+	size := info.Size()
+	if size < f.offset {
+		app.Log(app.LogInfo, "Followed file %q truncated, reading from the start", f.props.RelativePath())
+		f.offset, f.partial = 0, nil
+	}
+	for f.offset < size {
+		b := f.buffer
+		if remaining := size - f.offset; remaining < int64(len(b)) {
+			b = b[:remaining]
+		}
+		n, err := f.file.ReadAt(b, f.offset)
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		if n == 0 {
+			break // Truncated since the Stat; the next change tells.
+		}
+		f.offset += int64(n)
+		data := append(f.partial, b[:n]...)
+		for {
+			i := bytes.IndexByte(data, '\n')
+			if i < 0 {
+				break
+			}
+			s, ok, err := selectLine(f.props, f.long, string(data[:i]))
+			if err != nil {
+				return false, err
+			}
+			if ok && !write(s) {
+				return false, nil
+			}
+			data = data[i+1:]
+		}
+		// Keep an unterminated line for its newline, unless it grows
+		// beyond reason, as in a file that is not text.
+		f.partial = append(f.partial[:0:0], data...)
+		if len(f.partial) > 16*len(f.buffer) {
+			if ok, err := f.flushPartial(write); err != nil || !ok {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// flushPartial writes any unterminated line as it is.
+func (f *follower) flushPartial(write func(string) bool) (bool, error) {
+	if len(f.partial) == 0 {
+		return true, nil
+	}
+	s := string(f.partial)
+	f.partial = nil
+	s, ok, err := selectLine(f.props, f.long, s)
+	if err != nil || !ok {
+		return err == nil, err
+	}
+	return write(s), nil
+}
+
+// reopen checks whether the path names a new file, as after logrotate
+// renames the old one away.  If so, it finishes the old file, then
+// opens the new one to read from the start.  Until the new file
+// appears, the old one is followed.
+func (f *follower) reopen(write func(string) bool) (bool, error) {
+	info, err := app.Stat(f.props.FileSystem(), f.props.RootedPath())
+	if err != nil || sameFile(info, f.info) {
+		return true, nil
+	}
+	if ok, err := f.readNew(write); err != nil || !ok {
+		return ok, err
+	}
+	if ok, err := f.flushPartial(write); err != nil || !ok {
+		return false, err
+	}
+	file, err := app.Open(f.props.FileSystem(), f.props.RootedPath())
+	if err != nil {
+		return true, nil // Replaced again; try at the next change.
+	}
+	if info, err = file.Stat(); err != nil {
+		file.Close()
+		return true, nil
+	}
+	app.Log(app.LogInfo, "Followed file %q replaced, reading the new file", f.props.RelativePath())
+	f.file.Close()
+	f.file, f.info, f.offset = file, info, 0
+	return true, nil
+}
+
+// sameFile reports whether two descriptions are of the same file.
+// File systems without device and inode numbers, such as remote
+// ones, cannot tell, so their files are taken to be the same.
+func sameFile(a fs.FileInfo, b fs.FileInfo) bool {
+	return os.SameFile(a, b) || (a.Sys() == nil && b.Sys() == nil)
+}
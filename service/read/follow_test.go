@@ -0,0 +1,105 @@
+package read
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"varlog/service/app"
+	"varlog/service/apptest"
+)
+
+func TestHandler_follow(t *testing.T) {
+	defer func(interval time.Duration) { followPollInterval = interval }(followPollInterval)
+	followPollInterval = 10 * time.Millisecond
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	writeFile := func(flag int, s string) {
+		f, err := os.OpenFile(name, flag|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err = f.WriteString(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(os.O_CREATE, "one\ntwo\nthree\n")
+
+	props := app.DefaultProperties()
+	props.SetRoot(dir)
+	ts := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		Handler(writer, apptest.WithProperties(request, props))
+	}))
+	defer ts.Close()
+	response, err := ts.Client().Get(ts.URL + "/read?name=app.log&follow=true&count=2&filter=-skip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", response.StatusCode)
+	}
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(response.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	expect := func(step string, expected ...string) {
+		for _, s := range expected {
+			select {
+			case line := <-lines:
+				if line != s {
+					t.Fatalf("%s: expected %q, got %q", step, s, line)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: expected %q, got nothing", step, s)
+			}
+		}
+	}
+
+	expect("start", "two", "three")
+	writeFile(os.O_APPEND, "four\nskip me\nfi")
+	writeFile(os.O_APPEND, "ve\n")
+	expect("append", "four", "five")
+
+	// Renamed away, written once more, then replaced.
+	if err = os.Rename(name, name+".1"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(name+".1", os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("six\n")
+	f.Close()
+	writeFile(os.O_CREATE, "seven\n")
+	expect("rotate", "six", "seven")
+
+	// Truncated in place, as by copytruncate.
+	writeFile(os.O_TRUNC, "")
+	time.Sleep(100 * time.Millisecond)
+	writeFile(os.O_APPEND, "eight\n")
+	expect("truncate", "eight")
+}
+
+func TestHandler_followInvalid(t *testing.T) {
+	props := apptest.Properties(apptest.NewTree().Log("app.log", 20).MapFS("/var/log"), "/var/log")
+	for _, params := range [][]string{
+		{"name", "app.log", "follow", "yes"},
+		{"name", "app.log", "follow", "true", "mode", "count"},
+		{"name", "app.log", "follow", "true", "multiline", "true"},
+		{"name", "app.log", "follow", "true", "dedupe", "true"},
+	} {
+		recorder := apptest.Serve(Handler, props, apptest.Request("/read", params...))
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("%v: expected 400, got %d", params, recorder.Code)
+		}
+	}
+}
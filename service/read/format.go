@@ -0,0 +1,93 @@
+package read
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"varlog/service/app"
+)
+
+// Formats of /read's lines: text, a line a line; NDJSON, an object a
+// line, {"line":"..."}; or a JSON document, {"lines":["...", ...]},
+// with the page's tokens in "page" when paginated.  The 'format'
+// parameter chooses, or else the Accept header.  Following offers
+// text and NDJSON only, since its document would never end.
+
+// lineFormat settles the format of the lines, from the parameter or
+// the Accept header, so later checks see the one in use.
+func lineFormat(props *app.Properties, writer http.ResponseWriter, request *http.Request) {
+	if props.ParamMode() != app.ModeLines {
+		return
+	}
+	if props.ParamFollow() {
+		props.SetParamFormat(props.ResponseFormat(writer, request, app.FormatText, app.FormatNDJSON))
+		return
+	}
+	props.SetParamFormat(props.ResponseFormat(writer, request, app.FormatText, app.FormatJSON, app.FormatNDJSON))
+}
+
+// An ndjsonLine is a line of an NDJSON response.
+type ndjsonLine struct {
+	Line string `json:"line"`
+}
+
+// A lineEncoder writes lines in the request's format.
+type lineEncoder struct {
+	format  string
+	out     io.Writer
+	started bool // The JSON document is begun
+}
+
+func newLineEncoder(props *app.Properties, out io.Writer) *lineEncoder {
+	return &lineEncoder{format: props.ParamFormat(), out: out}
+}
+
+// write writes a line.
+func (e *lineEncoder) write(s string) {
+	switch e.format {
+	case app.FormatJSON:
+		e.begin()
+		if e.started {
+			io.WriteString(e.out, ",")
+		}
+		e.started = true
+		e.out.Write(marshal(s))
+	case app.FormatNDJSON:
+		e.out.Write(append(marshal(ndjsonLine{Line: s}), '\n'))
+	default:
+		io.WriteString(e.out, s+"\n")
+	}
+}
+
+// begin opens the JSON document before its first line.
+func (e *lineEncoder) begin() {
+	if !e.started {
+		io.WriteString(e.out, `{"lines":[`)
+	}
+}
+
+// close ends a JSON document, with the page if given.  Other formats
+// need no end.
+func (e *lineEncoder) close(page *app.PageInfo) {
+	if e.format != app.FormatJSON {
+		return
+	}
+	e.begin()
+	io.WriteString(e.out, "]")
+	if page != nil {
+		io.WriteString(e.out, `,"page":`)
+		e.out.Write(marshal(page))
+	}
+	io.WriteString(e.out, "}\n")
+}
+
+// marshal encodes the value as JSON, leaving the '<', '>', and '&'
+// of log lines as they are.
+func marshal(v interface{}) []byte {
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(v)
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n"))
+}
@@ -0,0 +1,123 @@
+package read
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"varlog/service/app"
+	"varlog/service/stats"
+)
+
+const (
+	// Bytes of a hexdump without a 'length' parameter.
+	hexdumpDefaultLength = 64 * 1024
+
+	// Most bytes of one hexdump, some 70 MiB of text.
+	hexdumpMaxLength = 16 * 1024 * 1024
+
+	// Bytes per hexdump line.
+	hexdumpWidth = 16
+)
+
+// writeHexdump writes a byte range of the file, from the 'offset' and
+// 'length' parameters, as xxd does: each line gives the offset of 16
+// bytes, the bytes in hex, in pairs, and as ASCII, with dots for bytes
+// that are not printable.  Lines are in file order.  The filter and
+// count apply to the lines, so filter=ERROR finds the lines with that
+// text in their ASCII column.
+//
+//	00000000: 7f45 4c46 0201 0100 0000 0000 0000 0000  .ELF............
+func writeHexdump(props *app.Properties, writer http.ResponseWriter, request *http.Request, file app.File, size int64) (totalLines int, err error) {
+	if props.ParamMode() != app.ModeLines || props.ParamMultiline() || props.ParamDedupe() ||
+		!props.ParamSince().IsZero() || !props.ParamUntil().IsZero() {
+		return 0, app.ParamError(app.ParamFormat,
+			"format=hexdump cannot be used with mode, multiline, dedupe, since, or until")
+	}
+	if props.Redacted() {
+		return 0, app.ParamError(app.ParamFormat,
+			fmt.Sprintf("format=hexdump of %q not allowed, its lines are redacted", props.RelativePath()))
+	}
+	length := props.ParamLength()
+	switch {
+	case length == 0:
+		length = hexdumpDefaultLength
+	case length > hexdumpMaxLength:
+		return 0, app.ParamError(app.ParamLength,
+			fmt.Sprintf("Invalid value %s=%d, at most %d", app.ParamLength, length, hexdumpMaxLength))
+	}
+	offset := props.ParamOffset()
+	if offset < 0 {
+		offset += size
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > size {
+		return 0, app.RangeError(app.ParamOffset,
+			fmt.Sprintf("Invalid value %s=%d, beyond the end of %q, %d bytes", app.ParamOffset, offset, props.RelativePath(), size), size)
+	}
+	if offset+length > size {
+		length = size - offset
+	}
+
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	limit := NewResponseCap(props, writer)
+	defer limit.Signal(writer)
+	section := &contextReader{request.Context(), io.NewSectionReader(file, offset, length)}
+	reader := bufio.NewReaderSize(section, props.ChunkSize())
+	var scanned int64
+	defer func() { stats.AddBytesScanned(request.Context(), scanned) }()
+	var b strings.Builder
+	buffer := make([]byte, hexdumpWidth)
+	for {
+		n, err := io.ReadFull(reader, buffer)
+		if n > 0 {
+			b.Reset()
+			hexdumpLine(&b, offset+scanned, buffer[:n])
+			scanned += int64(n)
+			if s := b.String(); props.FilterAllowsEntry(s) {
+				if !limit.Allow(s) {
+					return totalLines, nil
+				}
+				writer.Write([]byte(s + "\n"))
+				totalLines++
+				if props.ParamCount() > 0 && totalLines >= props.ParamCount() {
+					return totalLines, nil
+				}
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return totalLines, nil
+		}
+		if err != nil {
+			return totalLines, err
+		}
+	}
+}
+
+// hexdumpLine formats up to hexdumpWidth bytes at the offset, padding
+// a short last line so its ASCII column lines up.
+func hexdumpLine(b *strings.Builder, offset int64, data []byte) {
+	const digits = "0123456789abcdef"
+	fmt.Fprintf(b, "%08x:", offset)
+	for j := 0; j < hexdumpWidth; j++ {
+		if j%2 == 0 {
+			b.WriteByte(' ')
+		}
+		if j < len(data) {
+			b.WriteByte(digits[data[j]>>4])
+			b.WriteByte(digits[data[j]&0xf])
+		} else {
+			b.WriteString("  ")
+		}
+	}
+	b.WriteString("  ")
+	for _, c := range data {
+		if c < 0x20 || c > 0x7e {
+			c = '.'
+		}
+		b.WriteByte(c)
+	}
+}
@@ -0,0 +1,45 @@
+package read
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"varlog/service/app"
+	"varlog/service/index"
+	"varlog/service/stats"
+)
+
+// fileIndex gives the line-offset index of the file, if indexing is
+// enabled with -index-dir and the index is fresh.  A stale index is
+// refreshed in the background while this request scans.  Only plain
+// text files on the local disk are indexed.
+func fileIndex(ctx context.Context, props *app.Properties, info fs.FileInfo) *index.Index {
+	if props.IndexDir() == "" || props.FileSystem() != app.OSFileSystem || props.Format() != "" {
+		return nil
+	}
+	x := index.Lookup(props.IndexDir(), app.OSPath(props.RootedPath()), info, props.LineTime)
+	stats.CacheHit(ctx, x != nil)
+	return x
+}
+
+// writeIndexedCount answers 'mode=count' from the index, when it
+// needs no scan: no filter, no records, and the whole file.  Nothing
+// is scanned, so the result says so.  It reports false if the request
+// needs a scan.
+func writeIndexedCount(props *app.Properties, writer http.ResponseWriter, x *index.Index, start int64, end int64) (bool, error) {
+	if x == nil || !props.Filters().Empty() || props.ParamMultiline() || start != 0 || end != x.Size {
+		return false, nil
+	}
+	lines := x.Lines
+	if count := int64(props.ParamCount()); count > 0 && lines > count {
+		lines = count
+	}
+	b, err := json.Marshal(countResult{Name: props.RelativePath(), Matches: int(lines)})
+	if err != nil {
+		return true, err
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(append(b, '\n'))
+	return true, nil
+}
@@ -0,0 +1,69 @@
+package read
+
+import (
+	"net/http"
+	"varlog/service/app"
+)
+
+// Values of the X-Varlog-Truncated trailer: which cap ended the response.
+const (
+	truncatedBytes   = "bytes"
+	truncatedChanged = "file-changed" // Not a cap: the file changed, see endChanged
+	truncatedLines   = "lines"
+	truncatedTime    = "time" // Not a cap: a named pipe's time limit, see writeFIFO
+)
+
+// ResponseCap enforces the server's caps on a /read or /journal response,
+// independent of the client's count.  Without caps, it allows everything
+// and the response is unchanged.  With caps, the response declares the
+// X-Varlog-Truncated trailer, which is set if a cap ends the response,
+// so clients know the output is incomplete.
+type ResponseCap struct {
+	maxBytes  int64
+	maxLines  int
+	bytes     int64
+	lines     int
+	truncated string // Empty, or which cap was reached
+}
+
+// NewResponseCap prepares the caps for a response.
+// Must be called before the response body is written.
+func NewResponseCap(props *app.Properties, writer http.ResponseWriter) *ResponseCap {
+	c := &ResponseCap{maxBytes: props.MaxResponseBytes(), maxLines: props.MaxResponseLines()}
+	if c.enabled() {
+		writer.Header().Set(app.HdrTrailer, app.HdrTruncated)
+	}
+	return c
+}
+
+func (c *ResponseCap) enabled() bool {
+	return c.maxBytes > 0 || c.maxLines > 0
+}
+
+// Allow reports whether the line may be written, counting it if so.
+// Once a cap is reached, no further lines are allowed.
+func (c *ResponseCap) Allow(line string) bool {
+	if c.truncated != "" {
+		return false
+	}
+	size := int64(len(line)) + 1 // With the newline
+	switch {
+	case c.maxLines > 0 && c.lines >= c.maxLines:
+		c.truncated = truncatedLines
+		return false
+	case c.maxBytes > 0 && c.bytes+size > c.maxBytes:
+		c.truncated = truncatedBytes
+		return false
+	}
+	c.lines++
+	c.bytes += size
+	return true
+}
+
+// Signal sets the trailer if the response was truncated.
+func (c *ResponseCap) Signal(writer http.ResponseWriter) {
+	if c.truncated != "" {
+		app.Log(app.LogInfo, "Response truncated at %d lines, %d bytes", c.lines, c.bytes)
+		writer.Header().Set(app.HdrTruncated, c.truncated)
+	}
+}
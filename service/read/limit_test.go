@@ -0,0 +1,34 @@
+package read
+
+import (
+	"testing"
+)
+
+func TestResponseCap(t *testing.T) {
+	tests := []struct {
+		maxBytes  int64
+		maxLines  int
+		allowed   int
+		truncated string
+	}{
+		{0, 0, 5, ""},
+		{0, 3, 3, truncatedLines},
+		{0, 5, 5, ""},
+		{10, 0, 2, truncatedBytes}, // Each line is 4 bytes with newline
+		{12, 0, 3, truncatedBytes},
+		{100, 2, 2, truncatedLines},
+	}
+	for _, test := range tests {
+		c := &ResponseCap{maxBytes: test.maxBytes, maxLines: test.maxLines}
+		allowed := 0
+		for i := 0; i < 5; i++ {
+			if c.Allow("abc") {
+				allowed++
+			}
+		}
+		if allowed != test.allowed || c.truncated != test.truncated {
+			t.Errorf("caps (%d bytes, %d lines): expected %d allowed, truncated %q; got %d, %q",
+				test.maxBytes, test.maxLines, test.allowed, test.truncated, allowed, c.truncated)
+		}
+	}
+}
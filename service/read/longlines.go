@@ -0,0 +1,88 @@
+package read
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+	"varlog/service/app"
+)
+
+// Value of the X-Varlog-Truncated trailer when a long line ends the
+// response, with 'truncate=error'.
+const truncatedLongLine = "long-line"
+
+// Ends a line cut to -max-line-bytes, with 'truncate=marker'.
+const longLineMarker = " [...]"
+
+// lineLimit applies -max-line-bytes and the 'truncate' parameter to
+// the lines of a response.  A long line is cut, ending with a marker,
+// dropped, or refused, ending the response.  The number of long lines
+// goes in the X-Varlog-Long-Lines trailer.
+type lineLimit struct {
+	max    int    // Bytes kept of a line, 0 for no limit
+	policy string // The 'truncate' parameter
+	count  int    // Lines longer than max
+}
+
+// errLongLine ends a response with 'truncate=error'.
+var errLongLine = errors.New("line too long")
+
+// newLineLimit prepares the limit for a response.
+func newLineLimit(props *app.Properties) *lineLimit {
+	return &lineLimit{max: props.MaxLineBytes(), policy: props.ParamTruncate()}
+}
+
+// declare announces the trailer counting long lines.  Must be called
+// before the response body is written, after NewResponseCap.
+func (l *lineLimit) declare(writer http.ResponseWriter) {
+	if l.max > 0 {
+		writer.Header().Add(app.HdrTrailer, app.HdrLongLines)
+	}
+}
+
+// apply gives the line to use, or false to drop it.  With
+// 'truncate=error', a long line gives errLongLine.
+func (l *lineLimit) apply(s string) (string, bool, error) {
+	if l.max <= 0 || len(s) <= l.max {
+		return s, true, nil
+	}
+	l.count++
+	switch l.policy {
+	case app.TruncateDrop:
+		return "", false, nil
+	case app.TruncateError:
+		return "", false, errLongLine
+	}
+	// Cut at the start of a character, so the line stays UTF-8.
+	n := l.max
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + longLineMarker, true, nil
+}
+
+// signal sets the trailer counting the long lines, if there were any.
+func (l *lineLimit) signal(writer http.ResponseWriter) {
+	if l.count > 0 {
+		writer.Header().Set(app.HdrLongLines, strconv.Itoa(l.count))
+	}
+}
+
+// end ends a response refused for a long line.  With lines written,
+// the response ends cleanly with the X-Varlog-Truncated trailer
+// "long-line".  Otherwise the error becomes a 422 response.
+// Other errors pass through.
+func (l *lineLimit) end(props *app.Properties, writer http.ResponseWriter, written bool, err error) error {
+	if !errors.Is(err, errLongLine) {
+		return err
+	}
+	app.Log(app.LogInfo, "Read of %q ended at a line longer than %d bytes", props.RelativePath(), l.max)
+	if written {
+		writer.Header().Set(http.TrailerPrefix+app.HdrTruncated, truncatedLongLine)
+		return nil
+	}
+	return app.NewHTTPError(http.StatusUnprocessableEntity, app.CodeLineTooLong,
+		fmt.Sprintf("File %q has a line longer than %d bytes", props.RelativePath(), l.max))
+}
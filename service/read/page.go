@@ -0,0 +1,85 @@
+package read
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"varlog/service/app"
+	"varlog/service/scan"
+)
+
+// Pages of /read's lines.  A page is located by the lines scanned
+// before it, counted from the end of the file before filtering, and
+// bounded by the end of the first page's read, so lines appended
+// since do not shift the pages.  A page is buffered, so its Link
+// header, which needs the next page's start, goes before the lines.
+// The last page may be empty: a full page links the next one without
+// looking for more lines.
+
+// checkPage refuses pages with the parameters that do not give one
+// line for each line written.
+func checkPage(props *app.Properties) error {
+	if !props.ParamPage().Paginated() {
+		return nil
+	}
+	if props.ParamFollow() || props.ParamMultiline() || props.ParamDedupe() ||
+		props.ParamMode() != app.ModeLines || props.ParamFormat() == app.FormatHexdump || props.ParamCount() != 0 {
+		return app.ParamError(app.ParamPageSize,
+			"page-size cannot be used with follow, multiline, dedupe, mode, format=hexdump, or count")
+	}
+	return nil
+}
+
+// A pager selects and buffers a page of lines.
+type pager struct {
+	page    app.Page
+	bound   int64 // End of the read, for the next page
+	scanned int64 // Lines scanned, including those skipped
+	buffer  bytes.Buffer
+}
+
+// newPager gives the pager of the request, and the end of its read,
+// or nil and the end unchanged without pages.  A file shorter than
+// the first page's bound has been replaced or truncated, so the
+// pages no longer hold.
+func newPager(props *app.Properties, end int64, size int64) (*pager, int64, error) {
+	page := props.ParamPage()
+	if !page.Paginated() {
+		return nil, end, nil
+	}
+	if page.Bound > 0 {
+		if page.Bound > size {
+			return nil, end, &scan.ChangedError{Offset: size,
+				Reason: fmt.Sprintf("size %d, less than the first page's %d", size, page.Bound)}
+		}
+		if page.Bound < end {
+			end = page.Bound
+		}
+	}
+	return &pager{page: page, bound: end}, end, nil
+}
+
+// skip counts a scanned line, reporting whether it precedes the page.
+func (pg *pager) skip() bool {
+	pg.scanned++
+	return pg.scanned <= pg.page.Skip
+}
+
+// flush sets the Link header and writes the page, ending a JSON page
+// with its tokens.  A full page links the next, starting after the
+// last line written.
+func (pg *pager) flush(writer http.ResponseWriter, request *http.Request, props *app.Properties, enc *lineEncoder, full bool, written int) {
+	var next *app.Page
+	if full {
+		skip := pg.scanned
+		if written < pg.page.Size {
+			// The response cap refused the last line scanned.
+			skip--
+		}
+		n := pg.page.Next(skip, pg.bound)
+		next = &n
+	}
+	info := app.WritePageLinks(writer, request, props, pg.page, next)
+	enc.close(&info)
+	writer.Write(pg.buffer.Bytes())
+}
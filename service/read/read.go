@@ -11,25 +11,102 @@
 // or a negative (filter=-value) filter on the lines.  Entries
 // must match (or not match) the filter to be included in the
 // response.  An empty/missing filter passes all lines.
+// A leading backslash escapes a literal '-' (filter=\-value).
+//
+// Parameter 'filter-anchor=start|end|whole' requires the filter
+// text to match at the start, the end, or the whole of the line.
 //
 // Parameter 'count=number' caps the number of lines to include
 // in the response.  A missing/empty/non-positive value returns
 // all lines in the given file.
 //
+// Parameter 'multiline=true' groups continuation lines (those starting
+// with a space or tab) with the line before, forming records such as
+// stack traces.  The filter then decides on each record's first line,
+// keeping or omitting the record whole, and the count caps records.  Parameter 'count-unit=line' makes the count cap
+// physical lines instead.
+//
+// Parameter 'extract=fields:LIST[:DELIMITER]' or 'extract=regex:PATTERN'
+// writes only the selected fields of each line, after filtering.
+// See scan.Extractor.
+//
+// Parameter 'dedupe=true' collapses runs of identical lines (records,
+// with multiline) into the most recent, prefixed with the run's length
+// as by uniq -c.  Parameter 'dedupe-ignore-time=true' also ignores
+// leading timestamps when comparing.
+//
+// Parameter 'sanitize=false' writes lines as they are in the file.
+// By default, ANSI escapes are stripped and invalid UTF-8 is replaced,
+// before filtering.
+//
+// Parameter 'encoding=name' converts lines from the file's encoding
+// to UTF-8: utf-8, utf-16le, utf-16be, iso-8859-1, or windows-1252.
+// By default, when sanitizing, the encoding is detected from a byte
+// order mark or the bytes at the start of the file; see encoding.go.
+//
+// Parameter 'ts=utc|local|unix' rewrites the timestamp starting each
+// line in one form and zone, before filtering.
+//
+// Parameters 'since=time' and 'until=time' limit the lines to those
+// with leading timestamps in the range, found by binary search; see
+// timerange.go.  A time is RFC 3339 or a duration ago, as in 'since=15m'.
+//
+// Parameter 'mode=count' writes, instead of the lines, a JSON object
+// with the number of matching lines (records, with multiline) and the
+// bytes scanned.  The count, if any, stops counting at that number.
+// Parameter 'mode=checksum' writes the SHA-256 digest and size of the
+// whole file as JSON, for checking copies; line parameters do not apply.
+//
+// Parameter 'follow=true' writes the last count lines (none without a
+// count) in file order, then lines as they are appended, until the
+// client goes away, following the file through rotation as tail -F
+// does.  See follow.go.
+//
+// Parameter 'format=text|json|ndjson' writes the lines as text (the
+// default), as a JSON document, or as a JSON object a line; without
+// it, the Accept header chooses.  Parameter 'format=hexdump' writes
+// the bytes as xxd does.  See format.go and hexdump.go.
+//
+// Parameter 'around-line=N' writes, instead, the lines from N-M to
+// N+M in file order, where 'context=M' (0 by default), seeking with
+// the line index if there is one.  See around.go.
+//
+// Parameter 'page-size=N' writes the lines in pages of N, linking the
+// neighboring pages in a Link header.  See page.go.
+//
 // Parameter 'content-disposition=value' tells whether to include
 // a "Content-Disposition" header in the response.  A missing,
 // empty, or 'inline' value uses no explicit header, thus streaming
 // the result in a browser.  An explicit 'attachment' includes a
 // header, which browsers interpret as saving the response in a file.
+//
+// Parameter 'filename=name' names the saved file, instead of the
+// file's base name, and makes the response an attachment unless
+// 'content-disposition=inline'.
+//
+// A HEAD request gets the headers a GET would, without reading the
+// file: Content-Length gives the file's size, since the response's is
+// not known until the lines are filtered, along with Last-Modified,
+// an ETag, and any Content-Disposition.
 package read
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"net/http"
-	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
 	"time"
 	"varlog/service/app"
+	"varlog/service/dockerfs"
+	"varlog/service/scan"
+	"varlog/service/stats"
+	"varlog/service/tracing"
 )
 
 const (
@@ -47,53 +124,110 @@ func Handler(writer http.ResponseWriter, request *http.Request) {
 	var t0 = time.Now()
 	var totalLines int
 	defer func () {
-		app.Log(app.LogInfo, "/read %d lines, %v", totalLines, time.Since(t0))
+		app.Log(app.LogDebug, "/read %d lines, %v", totalLines, time.Since(t0))
 	}()
-	var props *app.Properties = app.NewProperties()
+	var props *app.Properties = app.RequestProperties(request)
 
-	app.Log(app.LogInfo, "%q", request.URL)
+	app.Log(app.LogDebug, "%q", request.URL)
 
 	// All parameter handling and validation should be done before
 	// starting to write the response body (through writer).
 	// Otherwise a prelimary write on the response will set the
 	// status, and later error handling will not work properly.
-	// The response should be "clean" if http.Error() is used at all.
+	// The response should be "clean" if app.Error() is used at all.
 
 	err := props.ExtractParams(request)
 	if err != nil {
-		http.Error(writer, err.Error(), http.StatusBadRequest)
+		app.WriteError(writer, request, err)
+		return
+	}
+	if app.Denied(props.RelativePath()) {
+		app.Log(app.LogWarning, "Denied path %q requested", props.RelativePath())
+		app.Error(writer, request, "Not found", http.StatusNotFound)
 		return
 	}
-	err = checkRegularFile(props)
+	if !props.Authorized(props.RelativePath()) {
+		app.Log(app.LogWarning, "Principal %q not authorized for %q", props.Principal(), props.RelativePath())
+		app.Error(writer, request, "Access denied", http.StatusForbidden)
+		return
+	}
+	lineFormat(props, writer, request)
+	mode, err := checkFile(props)
+	if err == nil {
+		err = checkPage(props)
+	}
+	if err == nil {
+		err = checkAround(props, mode)
+	}
 	if err != nil {
 		app.Log(app.LogWarning, "%s", err.Error())
-		http.Error(writer, err.Error(), http.StatusBadRequest)
+		app.WriteError(writer, request, err)
 		return
 	}
 
-	totalLines, err = writeLines(props, writer)
-	if err != nil {
-		http.Error(writer, err.Error(), http.StatusBadRequest)
+	if mode&fs.ModeNamedPipe != 0 {
+		totalLines, err = writeFIFO(props, writer, request)
+		if err != nil && !canceled(err) {
+			app.WriteError(writer, request, err)
+		}
+		return
+	}
+
+	if props.ParamFollow() && request.Method != http.MethodHead {
+		totalLines, err = writeFollow(props, writer, request)
+		if err != nil && !canceled(err) {
+			app.WriteError(writer, request, err)
+		}
+		return
+	}
+	if request.Method == http.MethodHead {
+		err = writeHead(props, writer)
+		if err != nil {
+			app.WriteError(writer, request, err)
+		}
+		return
+	}
+	if props.ParamAroundLine() > 0 {
+		totalLines, err = writeAround(props, writer, request)
+	} else {
+		totalLines, err = writeLines(props, writer, request)
 	}
+	if err != nil && !canceled(err) {
+		app.WriteError(writer, request, err)
+	}
+}
+
+// canceled indicates the error means the request was abandoned:
+// the client disconnected, a deadline passed, or the server stopped.
+// There is no one to tell, and nothing to diagnose.
+func canceled(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
 }
 
-func checkRegularFile(props *app.Properties) error {
-	fileInfo, err := os.Stat(props.RootedPath())
+// checkFile checks the file can be read, giving its mode: a regular
+// file, or a named pipe on the local disk if -read-fifos allows.
+func checkFile(props *app.Properties) (fs.FileMode, error) {
+	fileInfo, err := app.Stat(props.FileSystem(), props.RootedPath())
 	if err != nil {
-		return errors.New(fmt.Sprintf("Path %q invalid, %s", props.RootedPath(), err.Error()))
+		return 0, app.FileError(props.RelativePath(), err)
 	}
 	mode := fileInfo.Mode()
 	switch {
 	case mode.IsDir():
-		return errors.New(fmt.Sprintf("Read directory %q not allowed", props.RootedPath()))
+		return mode, app.ParamError(app.ParamName,
+			fmt.Sprintf("Read directory %q not allowed", props.RelativePath()))
 
 	case mode.IsRegular():
 		break
 
+	case mode&fs.ModeNamedPipe != 0 && props.ReadFIFOs() && props.FileSystem() == app.OSFileSystem:
+		break
+
 	default:
-		return errors.New(fmt.Sprintf("Read special file %q not allowed", props.RootedPath()))
+		return mode, app.ParamError(app.ParamName,
+			fmt.Sprintf("Read special file %q not allowed", props.RelativePath()))
 	}
-	return nil
+	return mode, nil
 }
 
 // selectContentDisposition optionally adds a "Content-Disposition" header to the response.
@@ -106,7 +240,7 @@ func checkRegularFile(props *app.Properties) error {
 // The header to be added:
 //
 //	Content-Disposition: attachment; filename="name"
-func selectContentDisposition(props *app.Properties, writer http.ResponseWriter, file *os.File) {
+func selectContentDisposition(props *app.Properties, writer http.ResponseWriter, file app.File) {
 	switch props.ParamContentDisposition() {
 	case app.HdrInline:
 		return
@@ -115,6 +249,9 @@ func selectContentDisposition(props *app.Properties, writer http.ResponseWriter,
 		break
 
 	default:
+		if props.ParamFilenameSet() {
+			break
+		}
 		if props.ParamCount() > 0 && props.ParamCount() < attachLineCount {
 			return
 		}
@@ -127,40 +264,456 @@ func selectContentDisposition(props *app.Properties, writer http.ResponseWriter,
 			return
 		}
 	}
-	s := fmt.Sprintf("%s; %s=%q", app.HdrAttachment, app.HdrFilename, props.BasePath())
 	header := writer.Header()
-	header.Add(app.HdrContentDisposition, s)
+	header.Set(app.HdrContentDisposition, app.ContentDisposition(app.HdrAttachment, props.ParamFilename()))
+}
+
+// setFileHeaders adds the file's Last-Modified time and an ETag.  The
+// ETag is weak, made from the size and time, since the response is
+// derived from the file rather than being its bytes.
+func setFileHeaders(writer http.ResponseWriter, fileInfo fs.FileInfo) {
+	header := writer.Header()
+	header.Set("Last-Modified", fileInfo.ModTime().UTC().Format(http.TimeFormat))
+	header.Set("ETag", fmt.Sprintf("W/\"%x-%x\"", fileInfo.Size(), fileInfo.ModTime().UnixNano()))
+}
+
+// writeHead answers a HEAD request from the file's metadata, with the
+// headers writeLines would send.
+func writeHead(props *app.Properties, writer http.ResponseWriter) error {
+	file, err := app.Open(props.FileSystem(), props.RootedPath())
+	if err != nil {
+		app.Log(app.LogWarning, "Cannot open %s: %s", props.RootedPath(), err.Error())
+		return app.FileError(props.RelativePath(), err)
+	}
+	defer file.Close()
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return app.FileError(props.RelativePath(), err)
+	}
+	setFileHeaders(writer, fileInfo)
+	header := writer.Header()
+	switch {
+	case props.ParamMode() != app.ModeLines:
+		header.Set("Content-Type", "application/json")
+	case props.ParamFormat() == app.FormatHexdump:
+		header.Set("Content-Type", app.MediaType(app.FormatHexdump))
+	default:
+		selectContentDisposition(props, writer, file)
+		header.Set("Accept-Ranges", "none")
+		header.Set("Content-Type", app.MediaType(props.ParamFormat()))
+		header.Set("Content-Length", strconv.FormatInt(fileInfo.Size(), 10))
+	}
+	writer.WriteHeader(http.StatusOK)
+	return nil
 }
 
-func writeLines(props *app.Properties, writer http.ResponseWriter) (totalLines int, err error) {
-	var r *reverser
-	file, err := os.Open(props.RootedPath())
+func writeLines(props *app.Properties, writer http.ResponseWriter, request *http.Request) (totalLines int, err error) {
+	_, open := tracing.Start(request.Context(), "open")
+	file, err := app.Open(props.FileSystem(), props.RootedPath())
+	open.SetError(err)
+	open.End()
 	if err != nil {
 		app.Log(app.LogWarning, "Cannot open %s: %s", props.RootedPath(), err.Error())
-		return 0, err
+		return 0, app.FileError(props.RelativePath(), err)
+	}
+	file = app.Map(file, props.MmapThreshold())
+	if _, mapped := file.(scan.Slicer); !mapped {
+		file = tracing.File(request.Context(), file)
 	}
 	defer file.Close()
+	var r *scan.Reverser
+	var limit *ResponseCap
+	long := newLineLimit(props)
+	defer func() {
+		err = long.end(props, writer, totalLines > 0, endChanged(props, writer, limit, err))
+	}()
+	if _, mapped := file.(scan.Slicer); mapped {
+		// A mapped file truncated during the read faults, rather than
+		// giving a read error.  Turn the fault into the error.
+		defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+		defer func() {
+			if e := recover(); e != nil {
+				fault, ok := e.(runtime.Error)
+				if !ok {
+					panic(e)
+				}
+				changed := &scan.ChangedError{Offset: -1, Reason: fmt.Sprintf("mapped file fault: %s", fault)}
+				if r != nil {
+					changed.Offset = r.Offset()
+				}
+				err = changed
+			}
+		}()
+	}
 
-	selectContentDisposition(props, writer, file)
+	if props.ParamMode() == app.ModeLines {
+		selectContentDisposition(props, writer, file)
+		// Lines are reversed and filtered, so byte ranges of the file
+		// do not correspond to the response.  Range requests get it all.
+		writer.Header().Set("Accept-Ranges", "none")
+		writer.Header().Set("Content-Type", app.MediaType(props.ParamFormat()))
+	}
 
-	r, err = newReverser(props, file)
+	fileInfo, err := file.Stat()
 	if err != nil {
 		app.Log(app.LogError, "Create reverser error for %s: %s", props.RootedPath(), err.Error())
 		return 0, err
 	}
+	setFileHeaders(writer, fileInfo)
+	if props.ParamMode() == app.ModeChecksum {
+		return 0, writeChecksum(props, writer, request, file, fileInfo.Size())
+	}
+	if props.ParamFormat() == app.FormatHexdump {
+		return writeHexdump(props, writer, request, file, fileInfo.Size())
+	}
+	x := fileIndex(request.Context(), props, fileInfo)
+	if file, fileInfo, err = decodeFile(props, file, fileInfo); err != nil {
+		return 0, err
+	}
+	if utf16Encoding(props) {
+		// Offsets in the index are of the file, not the conversion.
+		x = nil
+	}
+	start, end, err := timeRange(request.Context(), props, file, fileInfo.Size(), x)
+	if err != nil {
+		return 0, err
+	}
+	paging, end, err := newPager(props, end, fileInfo.Size())
+	if err != nil {
+		return 0, err
+	}
+	if props.ParamMode() == app.ModeCount {
+		if ok, err := writeIndexedCount(props, writer, x, start, end); ok {
+			return 0, err
+		}
+	}
+	var section io.ReaderAt = file
+	if start > 0 || end < fileInfo.Size() {
+		section = io.NewSectionReader(file, start, end-start)
+	}
+	r = scan.NewReverser(request.Context(), section, end-start, props.ChunkSize())
+	r.SetMaxLine(props.MaxLineBytes())
+	_, reverse := tracing.Start(request.Context(), "reverse")
+	reverse.SetAttribute("varlog.chunk_size", props.ChunkSize())
+	defer func() {
+		reverse.SetAttribute("varlog.bytes_read", r.BytesRead())
+		reverse.SetAttribute("varlog.lines", totalLines)
+		reverse.SetError(err)
+		reverse.End()
+	}()
+	defer func() {
+		stats.AddBytesScanned(request.Context(), r.BytesRead())
+		if canceled(err) {
+			app.Log(app.LogInfo, "Read of %q canceled after %d bytes, %s", props.RelativePath(), r.BytesRead(), err)
+			return
+		}
+		if err == nil {
+			// Truncated and grown again, the file can read without
+			// error but give lines from both versions.  A mapped
+			// file's Stat is from when it was mapped.
+			if info, e := app.Restat(file); e == nil && info.Size() < fileInfo.Size() {
+				err = &scan.ChangedError{Offset: info.Size(),
+					Reason: fmt.Sprintf("size %d, was %d", info.Size(), fileInfo.Size())}
+			}
+			return
+		}
+		var changed *scan.ChangedError
+		if errors.As(err, &changed) || errors.Is(err, errLongLine) {
+			return
+		}
+		if err != nil {
+			app.Logf(app.LogError, "Scanner error (probably reading non-text)",
+				"id", app.RequestID(request.Context()), "error", err)
+			captureFailure(props, request, file, fileInfo.Size(), start+r.Offset(), err)
+		}
+	}()
+	// Stop any read-ahead before the deferred functions above use
+	// the file.
+	r.ReadAhead(props.ReadAhead())
+	defer r.Close()
+	if props.ParamMode() == app.ModeCount {
+		return 0, writeCount(props, writer, r, long)
+	}
+	limit = NewResponseCap(props, writer)
+	defer limit.Signal(writer)
+	long.declare(writer)
+	defer long.signal(writer)
+	full := false
+	enc := newLineEncoder(props, writer)
+	if paging != nil {
+		enc.out = &paging.buffer
+	}
+	defer func() {
+		switch {
+		case err != nil && totalLines == 0:
+		case paging != nil:
+			paging.flush(writer, request, props, enc, full && err == nil, totalLines)
+		default:
+			enc.close(nil)
+		}
+	}()
+	if props.ParamMultiline() {
+		return writeRecords(props, enc, r, limit, long)
+	}
+	dedupe := props.ParamDedupe()
+	deduper := scan.Deduper{IgnoreTimestamp: props.ParamDedupeIgnoreTime()}
+	count := props.ParamCount()
+	if paging != nil {
+		count = paging.page.Size
+	}
+
+	// Writes a line, returning false when the response is full.
+	write := func(s string) bool {
+		if !limit.Allow(s) {
+			return false
+		}
+		enc.write(s)
+		totalLines++
+		return count <= 0 || totalLines < count
+	}
+
 countLabel:
-	for r.scan() {
-		lines := r.lines()
+	for r.Scan() {
+		lines := r.Lines()
 		for _, s := range lines {
+			if paging != nil && paging.skip() {
+				continue
+			}
+			s, keep, err := long.apply(s)
+			if err != nil {
+				return totalLines, err
+			}
+			if !keep {
+				continue
+			}
+			s = decodeLine(props, s)
 			if !props.FilterAllowsEntry(s) {
 				continue
 			}
-			fmt.Fprintln(writer, s)
-			totalLines++
-			if props.ParamCount() > 0 && totalLines >= props.ParamCount() {
+			var ok bool
+			if s, ok = props.Extract(s); !ok {
+				continue
+			}
+			if dedupe {
+				run, n, ok := deduper.Add([]string{s})
+				if !ok {
+					continue
+				}
+				s = countedLine(run[0], n)
+			}
+			if full = !write(s); full {
+				break countLabel
+			}
+		}
+	}
+	if err = r.Err(); err != nil || full {
+		return totalLines, err
+	}
+	if run, n, ok := deduper.Flush(); ok {
+		write(countedLine(run[0], n))
+	}
+	return totalLines, nil
+}
+
+// endChanged ends a read of a file that changed under it, as when
+// logrotate truncates it.  With lines written, the response ends
+// cleanly with the X-Varlog-Truncated trailer "file-changed", since
+// the lines written are sound.  Otherwise the error becomes a 409
+// response, and the client may retry.  Other errors pass through.
+func endChanged(props *app.Properties, writer http.ResponseWriter, limit *ResponseCap, err error) error {
+	var changed *scan.ChangedError
+	if !errors.As(err, &changed) {
+		return err
+	}
+	if limit != nil && limit.lines > 0 {
+		app.Log(app.LogWarning, "Read of %q ended after %d lines, %s", props.RelativePath(), limit.lines, err)
+		writer.Header().Set(http.TrailerPrefix+app.HdrTruncated, truncatedChanged)
+		return nil
+	}
+	app.Log(app.LogWarning, "Read of %q failed, %s", props.RelativePath(), err)
+	return app.NewHTTPError(http.StatusConflict, app.CodeFileChanged,
+		fmt.Sprintf("File %q changed during read; retry", props.RelativePath()))
+}
+
+// extractRecord applies the 'extract' parameter to each line of
+// a record, dropping lines without the selected fields.
+func extractRecord(props *app.Properties, record []string) []string {
+	extracted := record[:0:0]
+	for _, s := range record {
+		if s, ok := props.Extract(s); ok {
+			extracted = append(extracted, s)
+		}
+	}
+	return extracted
+}
+
+// countedLine prefixes a deduplicated line with its repeat count,
+// as uniq -c does.
+func countedLine(s string, count int) string {
+	return fmt.Sprintf("%7d %s", count, s)
+}
+
+// Response for 'mode=count'.
+type countResult struct {
+	Name         string `json:"name"`                 // File, relative to the root
+	Matches      int    `json:"matches"`              // Lines, or records with multiline
+	LinesScanned int    `json:"lines_scanned"`        // Physical lines examined
+	BytesScanned int64  `json:"bytes_scanned"`        // File bytes read
+	LongLines    int    `json:"long_lines,omitempty"` // Lines longer than -max-line-bytes
+}
+
+// writeCount counts the lines (or records) the filter allows, writing
+// the result as JSON.  Nothing is written until the scan ends, so an
+// error still gets a proper error response.
+func writeCount(props *app.Properties, writer http.ResponseWriter, r *scan.Reverser, long *lineLimit) error {
+	var grouper scan.RecordGrouper
+	result := countResult{Name: props.RelativePath()}
+	count := props.ParamCount()
+
+	// Counts a record, returning false when the count is reached.
+	add := func(record []string) bool {
+		if props.FilterAllowsRecord(record) {
+			result.Matches++
+		}
+		return count <= 0 || result.Matches < count
+	}
+
+countLabel:
+	for r.Scan() {
+		for _, s := range r.Lines() {
+			result.LinesScanned++
+			s, keep, err := long.apply(s)
+			if err != nil {
+				return err
+			}
+			if !keep {
+				continue
+			}
+			s = decodeLine(props, s)
+			if !props.ParamMultiline() {
+				if !add([]string{s}) {
+					break countLabel
+				}
+				continue
+			}
+			if record, ok := grouper.Add(s); ok && !add(record) {
 				break countLabel
 			}
 		}
 	}
-	return totalLines, r.err()
+	if err := r.Err(); err != nil {
+		return err
+	}
+	if record, ok := grouper.Flush(); ok && (count <= 0 || result.Matches < count) {
+		add(record)
+	}
+	result.BytesScanned = r.BytesRead()
+	result.LongLines = long.count
+	b, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(append(b, '\n'))
+	return nil
+}
+
+// decodeLine converts a line of a mount with a line format,
+// such as Docker's JSON lines, to text, sanitizes the text unless
+// the request asks for it raw, normalizes any timestamp, and redacts
+// it.  Redacting before the filter keeps clients from finding what
+// was redacted by filtering for it.
+func decodeLine(props *app.Properties, s string) string {
+	s = scan.DecodeLine(s, props.ParamEncoding())
+	if props.Format() == dockerfs.Format {
+		s = dockerfs.DecodeLine(s)
+	}
+	if props.ParamSanitize() {
+		s = scan.Sanitize(s)
+	}
+	return props.Redact(props.NormalizeTimestamp(s))
+}
+
+// writeRecords writes multi-line records: newest record first, with
+// the lines of each record in file order.  The filter applies to each
+// record as a whole.  The count caps records or physical lines,
+// according to the 'count-unit' parameter.  With line units, the last
+// record is cut short if needed to honor the cap.
+// The server's response caps apply to lines, which can cut a record short.
+// Returns the number of lines written.
+func writeRecords(props *app.Properties, enc *lineEncoder, r *scan.Reverser, limit *ResponseCap, long *lineLimit) (totalLines int, err error) {
+	var grouper scan.RecordGrouper
+	var totalRecords int
+	count := props.ParamCount()
+	byLine := props.ParamCountUnit() == app.CountUnitLine
+
+	dedupe := props.ParamDedupe()
+	deduper := scan.Deduper{IgnoreTimestamp: props.ParamDedupeIgnoreTime()}
+
+	// Writes a record, returning false when the count cap is reached.
+	write := func(record []string) bool {
+		for _, s := range record {
+			if byLine && count > 0 && totalLines >= count {
+				return false
+			}
+			if !limit.Allow(s) {
+				return false
+			}
+			enc.write(s)
+			totalLines++
+		}
+		totalRecords++
+		if count <= 0 {
+			return true
+		}
+		if byLine {
+			return totalLines < count
+		}
+		return totalRecords < count
+	}
+
+	// Filters, extracts from, and deduplicates a record, writing any
+	// that results.
+	emit := func(record []string) bool {
+		if !props.FilterAllowsRecord(record) {
+			return true
+		}
+		record = extractRecord(props, record)
+		if len(record) == 0 {
+			return true
+		}
+		if dedupe {
+			run, n, ok := deduper.Add(record)
+			if !ok {
+				return true
+			}
+			record = append([]string{countedLine(run[0], n)}, run[1:]...)
+		}
+		return write(record)
+	}
+
+	for r.Scan() {
+		for _, s := range r.Lines() {
+			s, keep, err := long.apply(s)
+			if err != nil {
+				return totalLines, err
+			}
+			if !keep {
+				continue
+			}
+			if record, ok := grouper.Add(decodeLine(props, s)); ok && !emit(record) {
+				return totalLines, r.Err()
+			}
+		}
+	}
+	if err = r.Err(); err != nil {
+		return totalLines, err
+	}
+	if record, ok := grouper.Flush(); ok && !emit(record) {
+		return totalLines, nil
+	}
+	if run, n, ok := deduper.Flush(); ok {
+		write(append([]string{countedLine(run[0], n)}, run[1:]...))
+	}
+	return totalLines, nil
 }
@@ -0,0 +1,1030 @@
+package read
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"varlog/service/app"
+	"varlog/service/apptest"
+	"varlog/service/index"
+)
+
+func TestHandler_fileSystem(t *testing.T) {
+	props := app.DefaultProperties()
+	props.SetFileSystem(fstest.MapFS{
+		"logs/app.log": {Data: []byte("one\ntwo\nthree\n")},
+		"logs/dir/x":   {Data: []byte("x\n")},
+	})
+	props.SetRoot("/logs")
+	tests := []struct {
+		query  string
+		status int
+		body   string
+	}{
+		{"name=app.log", http.StatusOK, "three\ntwo\none\n"},
+		{"name=app.log&count=2", http.StatusOK, "three\ntwo\n"},
+		{"name=missing.log", http.StatusNotFound, ""},
+		{"name=dir", http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest("GET", "/read?"+test.query, nil)
+		request = request.WithContext(app.WithProperties(request.Context(), props))
+		recorder := httptest.NewRecorder()
+		Handler(recorder, request)
+		if recorder.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.query, test.status, recorder.Code)
+		}
+		if test.status == http.StatusOK && recorder.Body.String() != test.body {
+			t.Errorf("%s: expected %q, got %q", test.query, test.body, recorder.Body.String())
+		}
+	}
+}
+
+func TestHandler_longLines(t *testing.T) {
+	props := app.DefaultProperties()
+	props.SetFileSystem(fstest.MapFS{
+		"logs/app.log":  {Data: []byte("short\n0123456789abcdef\ntail\n")},
+		"logs/long.log": {Data: []byte("0123456789abcdef\n")},
+	})
+	props.SetRoot("/logs")
+	props.SetMaxLineBytes(8)
+	tests := []struct {
+		query     string
+		status    int
+		body      string
+		longLines string
+		truncated string
+	}{
+		{"name=app.log", http.StatusOK, "tail\n01234567 [...]\nshort\n", "1", ""},
+		{"name=app.log&truncate=marker&filter=567", http.StatusOK, "01234567 [...]\n", "1", ""},
+		{"name=app.log&truncate=drop", http.StatusOK, "tail\nshort\n", "1", ""},
+		{"name=app.log&truncate=drop&multiline=true", http.StatusOK, "tail\nshort\n", "1", ""},
+		{"name=app.log&truncate=error", http.StatusOK, "tail\n", "1", "long-line"},
+		{"name=app.log&count=1&truncate=error", http.StatusOK, "tail\n", "", ""},
+		{"name=long.log&truncate=error", http.StatusUnprocessableEntity, "", "", ""},
+		{"name=app.log&truncate=drop&mode=count", http.StatusOK,
+			`{"name":"app.log","matches":2,"lines_scanned":3,"bytes_scanned":28,"long_lines":1}` + "\n", "", ""},
+		{"name=app.log&truncate=cut", http.StatusBadRequest, "", "", ""},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest("GET", "/read?"+test.query, nil)
+		request = request.WithContext(app.WithProperties(request.Context(), props))
+		recorder := httptest.NewRecorder()
+		Handler(recorder, request)
+		response := recorder.Result()
+		if response.StatusCode != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.query, test.status, response.StatusCode)
+			continue
+		}
+		if test.status == http.StatusUnprocessableEntity && !strings.Contains(recorder.Body.String(), app.CodeLineTooLong) {
+			t.Errorf("%s: expected %s, got %s", test.query, app.CodeLineTooLong, recorder.Body.String())
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		if recorder.Body.String() != test.body {
+			t.Errorf("%s: expected %q, got %q", test.query, test.body, recorder.Body.String())
+		}
+		if got := response.Trailer.Get(app.HdrLongLines); got != test.longLines {
+			t.Errorf("%s: expected long lines %q, got %q", test.query, test.longLines, got)
+		}
+		if got := response.Trailer.Get(app.HdrTruncated); got != test.truncated {
+			t.Errorf("%s: expected truncated %q, got %q", test.query, test.truncated, got)
+		}
+	}
+}
+
+func TestHandler_encoding(t *testing.T) {
+	utf16 := func(text string) string {
+		b := []byte{0xff, 0xfe}
+		for _, r := range text {
+			b = append(b, byte(r), byte(r>>8))
+		}
+		return string(b)
+	}
+	props := app.DefaultProperties()
+	props.SetFileSystem(fstest.MapFS{
+		"logs/windows.log": {Data: []byte(utf16("first café\r\nsecond\r\n"))},
+		"logs/latin.log":   {Data: []byte("caf\xe9 cr\xe8me\nna\xefve \x93quoted\x94\n")},
+		"logs/bom.log":     {Data: []byte("\xef\xbb\xbfone\ntwo\n")},
+		"logs/stray.log":   {Data: []byte("caf\xe9\n")},
+	})
+	props.SetRoot("/logs")
+	tests := []struct {
+		query  string
+		status int
+		body   string
+	}{
+		{"name=windows.log", http.StatusOK, "second\nfirst café\n"},
+		{"name=windows.log&filter=caf%C3%A9", http.StatusOK, "first café\n"},
+		{"name=windows.log&mode=count", http.StatusOK,
+			`{"name":"windows.log","matches":2,"lines_scanned":2,"bytes_scanned":21}` + "\n"},
+		{"name=latin.log", http.StatusOK, "naïve “quoted”\ncafé crème\n"},
+		{"name=latin.log&encoding=iso-8859-1&sanitize=false", http.StatusOK, "naïve \u0093quoted\u0094\ncafé crème\n"},
+		{"name=latin.log&sanitize=false", http.StatusOK, "na\xefve \x93quoted\x94\ncaf\xe9 cr\xe8me\n"},
+		{"name=bom.log", http.StatusOK, "two\none\n"},
+		{"name=stray.log", http.StatusOK, "caf\uFFFD\n"},
+		{"name=stray.log&encoding=windows-1252", http.StatusOK, "café\n"},
+		{"name=windows.log&follow=true", http.StatusUnprocessableEntity, ""},
+		{"name=latin.log&follow=true&encoding=utf-16le", http.StatusBadRequest, ""},
+		{"name=latin.log&encoding=ebcdic", http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest("GET", "/read?"+test.query, nil)
+		request = request.WithContext(app.WithProperties(request.Context(), props))
+		recorder := httptest.NewRecorder()
+		Handler(recorder, request)
+		if recorder.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.query, test.status, recorder.Code)
+		}
+		if test.status == http.StatusOK && recorder.Body.String() != test.body {
+			t.Errorf("%s: expected %q, got %q", test.query, test.body, recorder.Body.String())
+		}
+	}
+}
+
+func TestHandler_filters(t *testing.T) {
+	tree := apptest.NewTree().File("app.log", "ERROR db down", "GET /healthcheck ERROR", "INFO up", "ERROR disk full")
+	props := apptest.Properties(tree.MapFS("/var/log"), "/var/log")
+	tests := []struct {
+		params   []string
+		expected string
+	}{
+		{[]string{"filter", "ERROR", "filter", "-healthcheck"}, "ERROR disk full\nERROR db down\n"},
+		{[]string{"filter", "ERROR", "filter", "db"}, "ERROR db down\n"},
+		{[]string{"filter", "-ERROR", "filter", "-INFO"}, ""},
+		{[]string{"filter", "ERROR", "filter", "-healthcheck", "filter-anchor", "start"}, "ERROR disk full\nERROR db down\n"},
+		{[]string{"filter", "ERROR", "filter", "-healthcheck", "mode", "count"},
+			`{"name":"app.log","matches":2,"lines_scanned":4,"bytes_scanned":61}` + "\n"},
+	}
+	for _, test := range tests {
+		request := apptest.Request("/read", append(test.params, "name", "app.log")...)
+		recorder := apptest.Serve(Handler, props, request)
+		if recorder.Code != http.StatusOK || recorder.Body.String() != test.expected {
+			t.Errorf("%v: expected 200 %q, got %d %q", test.params, test.expected, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+func TestHandler_tree(t *testing.T) {
+	tree := apptest.NewTree().Log("app.log", 20).Dir("old")
+	mapped := apptest.Properties(app.OSFileSystem, tree.WriteDir(t))
+	mapped.SetMmapThreshold(1)
+	mapped.SetChunkSize(100)
+	for _, props := range []*app.Properties{
+		apptest.Properties(tree.MapFS("/var/log"), "/var/log"),
+		apptest.Properties(app.OSFileSystem, tree.WriteDir(t)),
+		mapped,
+	} {
+		recorder := apptest.Serve(Handler, props, apptest.Request("/read", "name", "app.log", "count", "2"))
+		expected := apptest.LogLine(19) + "\n" + apptest.LogLine(18) + "\n"
+		if recorder.Code != http.StatusOK || recorder.Body.String() != expected {
+			t.Errorf("%s: expected 200 %q, got %d %q", props.Root(), expected, recorder.Code, recorder.Body.String())
+		}
+		recorder = apptest.Serve(Handler, props, apptest.Request("/read", "name", "app.log", "filter", "ERROR"))
+		if lines := strings.Count(recorder.Body.String(), "\n"); lines != 5 {
+			t.Errorf("%s: expected 5 ERROR lines, got %d", props.Root(), lines)
+		}
+		recorder = apptest.Serve(Handler, props, apptest.Request("/read", "name", "old"))
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400 for a directory, got %d", props.Root(), recorder.Code)
+		}
+	}
+}
+
+func TestHandler_dedupe(t *testing.T) {
+	tree := apptest.NewTree().
+		File("flap.log",
+			"2023/02/16 07:40:40 up",
+			"2023/02/16 07:40:41 down",
+			"2023/02/16 07:40:42 down",
+			"2023/02/16 07:40:42 down",
+			"2023/02/16 07:40:43 retry",
+			"\tat x",
+			"2023/02/16 07:40:44 retry",
+			"\tat x")
+	props := apptest.Properties(tree.MapFS("/var/log"), "/var/log")
+	tests := []struct {
+		params   []string
+		expected []string
+	}{
+		{[]string{"dedupe", "true"}, []string{
+			"      1 \tat x", "      1 2023/02/16 07:40:44 retry", "      1 \tat x", "      1 2023/02/16 07:40:43 retry",
+			"      2 2023/02/16 07:40:42 down", "      1 2023/02/16 07:40:41 down", "      1 2023/02/16 07:40:40 up"}},
+		{[]string{"dedupe-ignore-time", "true"}, []string{
+			"      1 \tat x", "      1 2023/02/16 07:40:44 retry", "      1 \tat x", "      1 2023/02/16 07:40:43 retry",
+			"      3 2023/02/16 07:40:42 down", "      1 2023/02/16 07:40:40 up"}},
+		{[]string{"dedupe-ignore-time", "true", "filter", "down"}, []string{"      3 2023/02/16 07:40:42 down"}},
+		{[]string{"dedupe-ignore-time", "true", "count", "5"}, []string{
+			"      1 \tat x", "      1 2023/02/16 07:40:44 retry", "      1 \tat x", "      1 2023/02/16 07:40:43 retry",
+			"      3 2023/02/16 07:40:42 down"}},
+		{[]string{"dedupe-ignore-time", "true", "multiline", "true"}, []string{
+			"      2 2023/02/16 07:40:44 retry", "\tat x",
+			"      3 2023/02/16 07:40:42 down", "      1 2023/02/16 07:40:40 up"}},
+		{[]string{"dedupe", "true", "multiline", "true", "count", "1"}, []string{
+			"      1 2023/02/16 07:40:44 retry", "\tat x"}},
+	}
+	for _, test := range tests {
+		request := apptest.Request("/read", append(test.params, "name", "flap.log")...)
+		recorder := apptest.Serve(Handler, props, request)
+		expected := strings.Join(test.expected, "\n") + "\n"
+		if recorder.Code != http.StatusOK || recorder.Body.String() != expected {
+			t.Errorf("%v: expected 200 %q, got %d %q", test.params, expected, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	recorder := apptest.Serve(Handler, props, apptest.Request("/read", "name", "flap.log", "dedupe", "yes"))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("dedupe=yes: expected 400, got %d", recorder.Code)
+	}
+}
+
+func TestHandler_extract(t *testing.T) {
+	tree := apptest.NewTree().File("access.log",
+		`10.0.0.1 - - [16/Feb/2023:07:40:46 +0000] "GET /a HTTP/1.1" 200 512 0.042`,
+		`10.0.0.2 - - [16/Feb/2023:07:40:47 +0000] "GET /b HTTP/1.1" 404 0 0.001`,
+		`10.0.0.1 - - [16/Feb/2023:07:40:48 +0000] "POST /c HTTP/1.1" 200 64 1.250`)
+	props := apptest.Properties(tree.MapFS("/var/log"), "/var/log")
+	tests := []struct {
+		params   []string
+		expected string
+	}{
+		{[]string{"extract", "fields:11"}, "1.250\n0.001\n0.042\n"},
+		{[]string{"extract", "fields:1,9", "filter", "GET"}, "10.0.0.2 404\n10.0.0.1 200\n"},
+		{[]string{"extract", `regex:"(\w+) (\S+)`, "count", "2"}, "POST /c\nGET /b\n"},
+		{[]string{"extract", "regex: 404 "}, " 404 \n"},
+		{[]string{"extract", "fields:1", "dedupe", "true"}, "      1 10.0.0.1\n      1 10.0.0.2\n      1 10.0.0.1\n"},
+		{[]string{"extract", "fields:9", "dedupe", "true", "filter", "-404"}, "      2 200\n"},
+		{[]string{"extract", "fields:9", "multiline", "true"}, "200\n404\n200\n"},
+	}
+	for _, test := range tests {
+		request := apptest.Request("/read", append(test.params, "name", "access.log")...)
+		recorder := apptest.Serve(Handler, props, request)
+		if recorder.Code != http.StatusOK || recorder.Body.String() != test.expected {
+			t.Errorf("%v: expected 200 %q, got %d %q", test.params, test.expected, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	recorder := apptest.Serve(Handler, props, apptest.Request("/read", "name", "access.log", "extract", "fields:0"))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("extract=fields:0: expected 400, got %d", recorder.Code)
+	}
+}
+
+func TestHandler_sanitize(t *testing.T) {
+	tree := apptest.NewTree().File("color.log", "\x1b[32mINFO\x1b[0m ok", "\x1b[31mERROR\x1b[0m bad \xff byte")
+	props := apptest.Properties(tree.MapFS("/var/log"), "/var/log")
+	tests := []struct {
+		params   []string
+		expected string
+	}{
+		{nil, "ERROR bad \uFFFD byte\nINFO ok\n"},
+		{[]string{"filter", "ERROR bad", "filter-anchor", "start"}, "ERROR bad \uFFFD byte\n"},
+		{[]string{"sanitize", "true", "count", "1"}, "ERROR bad \uFFFD byte\n"},
+		{[]string{"sanitize", "false"}, "\x1b[31mERROR\x1b[0m bad \xff byte\n\x1b[32mINFO\x1b[0m ok\n"},
+	}
+	for _, test := range tests {
+		request := apptest.Request("/read", append(test.params, "name", "color.log")...)
+		recorder := apptest.Serve(Handler, props, request)
+		if recorder.Code != http.StatusOK || recorder.Body.String() != test.expected {
+			t.Errorf("%v: expected 200 %q, got %d %q", test.params, test.expected, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+func TestHandler_timestamp(t *testing.T) {
+	tree := apptest.NewTree().File("zones.log",
+		"2023-02-16T08:40:46+01:00 berlin",
+		"2023-02-16T02:40:47.250-05:00 new york",
+		"no timestamp")
+	props := apptest.Properties(tree.MapFS("/var/log"), "/var/log")
+	tests := []struct {
+		params   []string
+		expected string
+	}{
+		{nil, "no timestamp\n2023-02-16T02:40:47.250-05:00 new york\n2023-02-16T08:40:46+01:00 berlin\n"},
+		{[]string{"ts", "utc"}, "no timestamp\n2023-02-16T07:40:47.250Z new york\n2023-02-16T07:40:46Z berlin\n"},
+		{[]string{"ts", "unix", "filter", "16765332"}, "1676533247.250 new york\n1676533246 berlin\n"},
+		{[]string{"ts", "utc", "filter", "2023-02-16T07:40:46", "filter-anchor", "start"}, "2023-02-16T07:40:46Z berlin\n"},
+	}
+	for _, test := range tests {
+		request := apptest.Request("/read", append(test.params, "name", "zones.log")...)
+		recorder := apptest.Serve(Handler, props, request)
+		if recorder.Code != http.StatusOK || recorder.Body.String() != test.expected {
+			t.Errorf("%v: expected 200 %q, got %d %q", test.params, test.expected, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	recorder := apptest.Serve(Handler, props, apptest.Request("/read", "name", "zones.log", "ts", "est"))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("ts=est: expected 400, got %d", recorder.Code)
+	}
+}
+
+func TestHandler_timeRange(t *testing.T) {
+	tree := apptest.NewTree().File("app.log",
+		"2023-02-16T07:00:00Z INFO one",
+		"2023-02-16T07:01:00Z ERROR two",
+		"\tat trace",
+		"2023-02-16T07:02:00Z INFO three",
+		"2023-02-16T07:03:00Z INFO four")
+	props := apptest.Properties(tree.MapFS("/var/log"), "/var/log")
+	tests := []struct {
+		params   []string
+		status   int
+		expected string
+	}{
+		{[]string{"since", "2023-02-16T07:01:00Z"}, http.StatusOK, "four,three,trace,two"},
+		{[]string{"since", "2023-02-16T07:00:30Z", "until", "2023-02-16T07:02:00Z"}, http.StatusOK, "three,trace,two"},
+		{[]string{"until", "2023-02-16T06:00:00Z"}, http.StatusOK, ""},
+		{[]string{"since", "2023-02-16T08:00:00+01:00", "filter", "INFO"}, http.StatusOK, "four,three,one"},
+		{[]string{"since", "2023-02-16T07:02:00Z", "until", "2023-02-16T07:01:00Z"}, http.StatusBadRequest, ""},
+		{[]string{"since", "yesterday"}, http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		recorder := apptest.Serve(Handler, props, apptest.Request("/read", append([]string{"name", "app.log"}, test.params...)...))
+		if recorder.Code != test.status {
+			t.Errorf("%v: expected %d, got %d", test.params, test.status, recorder.Code)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		// The last word of each line.
+		var words []string
+		for _, line := range strings.Split(strings.TrimSuffix(recorder.Body.String(), "\n"), "\n") {
+			if fields := strings.Fields(line); len(fields) > 0 {
+				words = append(words, fields[len(fields)-1])
+			}
+		}
+		if body := strings.Join(words, ","); body != test.expected {
+			t.Errorf("%v: expected %q, got %q", test.params, test.expected, body)
+		}
+	}
+
+	// A duration ago: all the lines are older.
+	recorder := apptest.Serve(Handler, props, apptest.Request("/read", "name", "app.log", "since", "1h"))
+	if recorder.Code != http.StatusOK || recorder.Body.Len() != 0 {
+		t.Errorf("since=1h: expected 200 and no lines, got %d %q", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestHandler_indexedCount(t *testing.T) {
+	tree := apptest.NewTree().Log("app.log", 20)
+	props := apptest.Properties(app.OSFileSystem, tree.WriteDir(t))
+	props.SetIndexDir(t.TempDir())
+	request := func(params ...string) string {
+		recorder := apptest.Serve(Handler, props, apptest.Request("/read", append([]string{"name", "app.log", "mode", "count"}, params...)...))
+		return strings.TrimSuffix(recorder.Body.String(), "\n")
+	}
+
+	// Before the index, the file is scanned.
+	scanned := `{"name":"app.log","matches":20,"lines_scanned":20,"bytes_scanned":1500}`
+	if body := request(); body != scanned {
+		t.Errorf("unindexed: expected %s, got %s", scanned, body)
+	}
+	if _, err := index.Refresh(context.Background(), props.IndexDir(), path.Join(props.Root(), "app.log"), props.LineTime); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		params   []string
+		expected string
+	}{
+		{nil, `{"name":"app.log","matches":20,"lines_scanned":0,"bytes_scanned":0}`},
+		{[]string{"count", "5"}, `{"name":"app.log","matches":5,"lines_scanned":0,"bytes_scanned":0}`},
+		{[]string{"filter", "ERROR"}, `{"name":"app.log","matches":5,"lines_scanned":20,"bytes_scanned":1500}`},
+	}
+	for _, test := range tests {
+		if body := request(test.params...); body != test.expected {
+			t.Errorf("%v: expected %s, got %s", test.params, test.expected, body)
+		}
+	}
+}
+
+func TestHandler_around(t *testing.T) {
+	fsys := apptest.NewTree().File("app.log", "one", "two", "three", "four", "five", "six", "seven", "eight", "nine").MapFS("/logs")
+	props := apptest.Properties(fsys, "/logs")
+	tests := []struct {
+		params []string
+		code   int
+		first  string
+		body   string
+	}{
+		{[]string{"around-line", "5"}, http.StatusOK, "5", "five\n"},
+		{[]string{"around-line", "5", "context", "2"}, http.StatusOK, "3", "three\nfour\nfive\nsix\nseven\n"},
+		{[]string{"around-line", "1", "context", "2"}, http.StatusOK, "1", "one\ntwo\nthree\n"},
+		{[]string{"around-line", "10", "context", "2"}, http.StatusOK, "8", "eight\nnine\n"},
+		{[]string{"around-line", "5", "context", "2", "filter", "e"}, http.StatusOK, "3", "three\nfive\nseven\n"},
+		{[]string{"around-line", "5", "format", "ndjson"}, http.StatusOK, "5", "{\"line\":\"five\"}\n"},
+		{[]string{"around-line", "12", "context", "2"}, http.StatusRequestedRangeNotSatisfiable, "", ""},
+		{[]string{"around-line", "0"}, http.StatusBadRequest, "", ""},
+		{[]string{"around-line", "5", "count", "2"}, http.StatusBadRequest, "", ""},
+		{[]string{"around-line", "5", "follow", "true"}, http.StatusBadRequest, "", ""},
+	}
+	for _, test := range tests {
+		recorder := apptest.Serve(Handler, props, apptest.Request("/read", append([]string{"name", "app.log"}, test.params...)...))
+		if recorder.Code != test.code {
+			t.Errorf("%v: expected %d, got %d %q", test.params, test.code, recorder.Code, recorder.Body)
+			continue
+		}
+		if test.code == http.StatusRequestedRangeNotSatisfiable {
+			if contentRange := recorder.Header().Get("Content-Range"); contentRange != "bytes */45" {
+				t.Errorf("%v: expected Content-Range bytes */45, got %q", test.params, contentRange)
+			}
+		}
+		if test.code != http.StatusOK {
+			continue
+		}
+		if first := recorder.Header().Get(app.HdrFirstLine); first != test.first || recorder.Body.String() != test.body {
+			t.Errorf("%v: expected line %s %q, got %s %q", test.params, test.first, test.body, first, recorder.Body)
+		}
+	}
+}
+
+func TestHandler_aroundIndexed(t *testing.T) {
+	tree := apptest.NewTree().Log("app.log", 25000)
+	props := apptest.Properties(app.OSFileSystem, tree.WriteDir(t))
+	props.SetIndexDir(t.TempDir())
+	var expected []string
+	for j := 20002; j <= 20006; j++ {
+		expected = append(expected, apptest.LogLine(j)+"\n")
+	}
+	request := apptest.Request("/read", "name", "app.log", "around-line", "20005", "context", "2")
+
+	// The same lines, scanned from the start and from a checkpoint.
+	for _, indexed := range []bool{false, true} {
+		if indexed {
+			if _, err := index.Refresh(context.Background(), props.IndexDir(), path.Join(props.Root(), "app.log"), props.LineTime); err != nil {
+				t.Fatal(err)
+			}
+		}
+		recorder := apptest.Serve(Handler, props, request)
+		if body := recorder.Body.String(); recorder.Code != http.StatusOK || body != strings.Join(expected, "") {
+			t.Errorf("indexed %v: expected 200 %q, got %d %q", indexed, strings.Join(expected, ""), recorder.Code, body)
+		}
+	}
+}
+
+func TestHandler_count(t *testing.T) {
+	tree := apptest.NewTree().
+		Log("app.log", 20).
+		File("trace.log", "ERROR one", "  at a", "INFO two", "ERROR three", "  at b", "  at c")
+	props := apptest.Properties(tree.MapFS("/var/log"), "/var/log")
+	tests := []struct {
+		params   []string
+		expected string
+	}{
+		{[]string{"name", "app.log"}, `{"name":"app.log","matches":20,"lines_scanned":20,"bytes_scanned":1500}`},
+		{[]string{"name", "app.log", "filter", "ERROR"}, `{"name":"app.log","matches":5,"lines_scanned":20,"bytes_scanned":1500}`},
+		{[]string{"name", "app.log", "filter", "ERROR", "count", "2"}, `{"name":"app.log","matches":2,"lines_scanned":5,"bytes_scanned":1500}`},
+		{[]string{"name", "trace.log", "filter", "ERROR"}, `{"name":"trace.log","matches":2,"lines_scanned":6,"bytes_scanned":52}`},
+		{[]string{"name", "trace.log", "filter", "ERROR", "multiline", "true"}, `{"name":"trace.log","matches":2,"lines_scanned":6,"bytes_scanned":52}`},
+		{[]string{"name", "trace.log", "filter", "at", "multiline", "true"}, `{"name":"trace.log","matches":0,"lines_scanned":6,"bytes_scanned":52}`},
+	}
+	for _, test := range tests {
+		request := apptest.Request("/read", append(test.params, "mode", "count")...)
+		recorder := apptest.Serve(Handler, props, request)
+		if body := strings.TrimSuffix(recorder.Body.String(), "\n"); recorder.Code != http.StatusOK || body != test.expected {
+			t.Errorf("%v: expected 200 %s, got %d %s", test.params, test.expected, recorder.Code, body)
+		}
+		if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("%v: expected JSON, got %q", test.params, contentType)
+		}
+	}
+
+	recorder := apptest.Serve(Handler, props, apptest.Request("/read", "name", "app.log", "mode", "bytes"))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("mode=bytes: expected 400, got %d", recorder.Code)
+	}
+}
+
+func TestHandler_head(t *testing.T) {
+	tree := apptest.NewTree().Log("app.log", 20).Log("big.log", 2000).Dir("nginx")
+	props := apptest.Properties(tree.MapFS("/var/log"), "/var/log")
+	tests := []struct {
+		params      []string
+		status      int
+		length      string
+		disposition string
+	}{
+		{[]string{"name", "app.log"}, http.StatusOK, "1500", ""},
+		{[]string{"name", "big.log"}, http.StatusOK, "150000", `attachment; filename="big.log"`},
+		{[]string{"name", "big.log", "count", "10"}, http.StatusOK, "150000", ""},
+		{[]string{"name", "app.log", "mode", "count"}, http.StatusOK, "", ""},
+		{[]string{"name", "missing.log"}, http.StatusNotFound, "", ""},
+		{[]string{"name", "nginx"}, http.StatusBadRequest, "", ""},
+	}
+	for _, test := range tests {
+		request := apptest.Request("/read", test.params...)
+		request.Method = http.MethodHead
+		recorder := apptest.Serve(Handler, props, request)
+		header := recorder.Header()
+		if recorder.Code != test.status {
+			t.Errorf("%v: expected %d, got %d", test.params, test.status, recorder.Code)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		if recorder.Body.Len() != 0 {
+			t.Errorf("%v: expected no body, got %q", test.params, recorder.Body.String())
+		}
+		if length := header.Get("Content-Length"); length != test.length {
+			t.Errorf("%v: expected Content-Length %q, got %q", test.params, test.length, length)
+		}
+		if disposition := header.Get(app.HdrContentDisposition); disposition != test.disposition {
+			t.Errorf("%v: expected Content-Disposition %q, got %q", test.params, test.disposition, disposition)
+		}
+		if header.Get("Last-Modified") == "" || !strings.HasPrefix(header.Get("ETag"), `W/"`) {
+			t.Errorf("%v: expected Last-Modified and a weak ETag, got %v", test.params, header)
+		}
+	}
+
+	// GET sends the same metadata.
+	head := apptest.Request("/read", "name", "app.log")
+	head.Method = http.MethodHead
+	headHeader := apptest.Serve(Handler, props, head).Header()
+	getHeader := apptest.Serve(Handler, props, apptest.Request("/read", "name", "app.log")).Header()
+	if getHeader.Get("ETag") != headHeader.Get("ETag") || getHeader.Get("Last-Modified") != headHeader.Get("Last-Modified") {
+		t.Errorf("expected GET and HEAD to agree, got %v and %v", getHeader, headHeader)
+	}
+}
+
+func TestHandler_filename(t *testing.T) {
+	tree := apptest.NewTree().Log("app.log", 20).Log("big.log", 2000).Log("my café.log", 20)
+	props := apptest.Properties(tree.MapFS("/var/log"), "/var/log")
+	tests := []struct {
+		params      []string
+		disposition string
+	}{
+		{[]string{"name", "app.log", "filename", "errors.txt"}, `attachment; filename="errors.txt"`},
+		{[]string{"name", "app.log", "filename", "errors.txt", "content-disposition", "inline"}, ""},
+		{[]string{"name", "big.log", "filename", "große.log"}, `attachment; filename="gro_e.log"; filename*=UTF-8''gro%C3%9Fe.log`},
+		{[]string{"name", "my café.log", "content-disposition", "attachment"}, `attachment; filename="my caf_.log"; filename*=UTF-8''my%20caf%C3%A9.log`},
+	}
+	for _, test := range tests {
+		recorder := apptest.Serve(Handler, props, apptest.Request("/read", test.params...))
+		if disposition := recorder.Header().Get(app.HdrContentDisposition); recorder.Code != http.StatusOK || disposition != test.disposition {
+			t.Errorf("%v: expected 200 %s, got %d %s", test.params, test.disposition, recorder.Code, disposition)
+		}
+	}
+
+	recorder := apptest.Serve(Handler, props, apptest.Request("/read", "name", "app.log", "filename", "../app.log"))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("filename=../app.log: expected 400, got %d", recorder.Code)
+	}
+}
+
+func TestHandler_hexdump(t *testing.T) {
+	props := apptest.Properties(fstest.MapFS{
+		"var/log/wtmp": {Data: []byte("\x7fELF\x02\x01\x01\x00hello, world\n")},
+	}, "/var/log")
+	line0 := "00000000: 7f45 4c46 0201 0100 6865 6c6c 6f2c 2077  .ELF....hello, w"
+	line1 := "00000010: 6f72 6c64 0a                             orld."
+	tests := []struct {
+		params   []string
+		status   int
+		expected string
+	}{
+		{nil, http.StatusOK, line0 + "\n" + line1 + "\n"},
+		{[]string{"offset", "16", "length", "4"}, http.StatusOK, "00000010: 6f72 6c64                                orld\n"},
+		{[]string{"offset", "-5"}, http.StatusOK, line1 + "\n"},
+		{[]string{"offset", "21"}, http.StatusOK, ""},
+		{[]string{"offset", "100"}, http.StatusRequestedRangeNotSatisfiable, ""},
+		{[]string{"filter", "orld."}, http.StatusOK, line1 + "\n"},
+		{[]string{"count", "1"}, http.StatusOK, line0 + "\n"},
+		{[]string{"length", "-1"}, http.StatusBadRequest, ""},
+		{[]string{"length", "100000000"}, http.StatusBadRequest, ""},
+		{[]string{"mode", "count"}, http.StatusBadRequest, ""},
+		{[]string{"follow", "true"}, http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		params := append([]string{"name", "wtmp", "format", "hexdump"}, test.params...)
+		recorder := apptest.Serve(Handler, props, apptest.Request("/read", params...))
+		if recorder.Code != test.status || (test.status == http.StatusOK && recorder.Body.String() != test.expected) {
+			t.Errorf("%v: expected %d %q, got %d %q", test.params, test.status, test.expected, recorder.Code, recorder.Body.String())
+		}
+		if test.status == http.StatusRequestedRangeNotSatisfiable {
+			if contentRange := recorder.Header().Get("Content-Range"); contentRange != "bytes */21" {
+				t.Errorf("%v: expected Content-Range bytes */21, got %q", test.params, contentRange)
+			}
+		}
+	}
+
+	recorder := apptest.Serve(Handler, props, apptest.Request("/read", "name", "wtmp", "format", "xxd"))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("format=xxd: expected 400, got %d", recorder.Code)
+	}
+}
+
+func TestHandler_checksum(t *testing.T) {
+	tree := apptest.NewTree().File("app.log", "ERROR one", "INFO two").File("empty.log")
+	props := apptest.Properties(tree.MapFS("/var/log"), "/var/log")
+	tests := []struct {
+		params   []string
+		expected string
+	}{
+		// Filters and counts do not apply.
+		{[]string{"name", "app.log", "filter", "ERROR", "count", "1"},
+			`{"name":"app.log","size":19,"sha256":"` + sha256Hex("ERROR one\nINFO two\n") + `"}`},
+		{[]string{"name", "empty.log"},
+			`{"name":"empty.log","size":0,"sha256":"` + sha256Hex("") + `"}`},
+	}
+	for _, test := range tests {
+		request := apptest.Request("/read", append(test.params, "mode", "checksum")...)
+		recorder := apptest.Serve(Handler, props, request)
+		if body := strings.TrimSuffix(recorder.Body.String(), "\n"); recorder.Code != http.StatusOK || body != test.expected {
+			t.Errorf("%v: expected 200 %s, got %d %s", test.params, test.expected, recorder.Code, body)
+		}
+		if disposition := recorder.Header().Get(app.HdrContentDisposition); disposition != "" {
+			t.Errorf("%v: expected no Content-Disposition, got %q", test.params, disposition)
+		}
+	}
+}
+
+// shrinkingFS gives files truncated to size after their first two
+// reads, the encoding sample and the first chunk, as by logrotate's
+// copytruncate during a read.
+type shrinkingFS struct {
+	fs.FS
+	size int64
+}
+
+func (fsys shrinkingFS) Open(name string) (fs.File, error) {
+	f, err := fsys.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &shrinkingFile{File: f.(app.File), size: fsys.size}, nil
+}
+
+type shrinkingFile struct {
+	app.File
+	size  int64
+	reads int
+}
+
+func (f *shrinkingFile) ReadAt(p []byte, offset int64) (int, error) {
+	if f.reads++; f.reads <= 2 || offset+int64(len(p)) <= f.size {
+		return f.File.ReadAt(p, offset)
+	}
+	if offset >= f.size {
+		return 0, io.EOF
+	}
+	n, _ := f.File.ReadAt(p[:f.size-offset], offset)
+	return n, io.EOF
+}
+
+func TestHandler_changed(t *testing.T) {
+	fsys := shrinkingFS{apptest.LogFS("/var/log/big.log", 200000), 1000}
+	props := apptest.Properties(fsys, "/var/log")
+	tests := []struct {
+		params  []string
+		status  int
+		trailer string
+	}{
+		// The first chunk's lines are sound, and end with the trailer.
+		{[]string{"name", "big.log"}, http.StatusOK, "file-changed"},
+		{[]string{"name", "big.log", "count", "10"}, http.StatusOK, ""},
+		// Nothing written yet: a conflict, to retry.
+		{[]string{"name", "big.log", "mode", "count"}, http.StatusConflict, ""},
+		{[]string{"name", "big.log", "mode", "checksum"}, http.StatusConflict, ""},
+	}
+	for _, test := range tests {
+		recorder := apptest.Serve(Handler, props, apptest.Request("/read", test.params...))
+		response := recorder.Result()
+		if response.StatusCode != test.status {
+			t.Errorf("%v: expected %d, got %d %s", test.params, test.status, response.StatusCode, recorder.Body.String())
+			continue
+		}
+		if trailer := response.Trailer.Get(app.HdrTruncated); trailer != test.trailer {
+			t.Errorf("%v: expected trailer %q, got %q", test.params, test.trailer, trailer)
+		}
+		if test.status == http.StatusConflict && !strings.Contains(recorder.Body.String(), app.CodeFileChanged) {
+			t.Errorf("%v: expected %s, got %s", test.params, app.CodeFileChanged, recorder.Body.String())
+		}
+		if test.status == http.StatusOK && strings.Contains(recorder.Body.String(), "error") {
+			t.Errorf("%v: expected only lines, got an error in the body", test.params)
+		}
+	}
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// discardWriter is a response writer that drops the body,
+// so benchmarks measure the read pipeline alone.
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+
+func BenchmarkWriteLines(b *testing.B) {
+	for _, size := range []int64{1 << 10, 1 << 20, 64 << 20, 1 << 30} {
+		if testing.Short() && size > 1<<20 {
+			continue
+		}
+		for _, chunkSize := range []int{4 << 10, 64 << 10, 1 << 20} {
+			b.Run(fmt.Sprintf("file=%s/chunk=%s", byteSize(size), byteSize(int64(chunkSize))), func(b *testing.B) {
+				props := apptest.Properties(apptest.LogFS("/var/log/big.log", size), "/var/log")
+				props.SetParamName("big.log")
+				props.SetChunkSize(chunkSize)
+				request := apptest.Request("/read", "name", "big.log")
+				b.SetBytes(size)
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := writeLines(props.Copy(), &discardWriter{header: http.Header{}}, request); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func byteSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%dGB", n>>30)
+	case n >= 1<<20:
+		return fmt.Sprintf("%dMB", n>>20)
+	}
+	return fmt.Sprintf("%dKB", n>>10)
+}
+
+func TestAggregateHandler(t *testing.T) {
+	tree := apptest.NewTree().
+		File("app.log", "2023-02-17T10:00:05Z ERROR db down", "2023-02-17T10:00:30Z INFO up",
+			"2023-02-17T10:02:10Z ERROR disk full", "ERROR no time").
+		File("web/a.log", "2023-02-17T10:00:01Z ERROR a", "2023-02-17T10:01:01Z ERROR b").
+		File("web/b.log", "2023-02-17T10:01:59Z ERROR c").
+		Dir("web/old")
+	props := apptest.Properties(tree.MapFS("/var/log"), "/var/log")
+	tests := []struct {
+		params   []string
+		expected string
+	}{
+		{[]string{"name", "app.log", "filter", "ERROR"},
+			`{"name":"app.log","files":1,"matches":3,"lines_scanned":4,"bytes_scanned":115,` +
+				`"bucket":"1m0s","start":"2023-02-17T10:00:00Z","counts":[1,0,1],"untimed":1}`},
+		{[]string{"name", "app.log", "bucket", "30s"},
+			`{"name":"app.log","files":1,"matches":4,"lines_scanned":4,"bytes_scanned":115,` +
+				`"bucket":"30s","start":"2023-02-17T10:00:00Z","counts":[1,1,0,0,1],"untimed":1}`},
+		{[]string{"name", "web", "filter", "ERROR"},
+			`{"name":"web","files":2,"matches":3,"lines_scanned":3,"bytes_scanned":87,` +
+				`"bucket":"1m0s","start":"2023-02-17T10:00:00Z","counts":[1,2],"untimed":0}`},
+		{[]string{"name", "app.log", "filter", "WARN"},
+			`{"name":"app.log","files":1,"matches":0,"lines_scanned":4,"bytes_scanned":115,` +
+				`"bucket":"1m0s","counts":[],"untimed":0}`},
+	}
+	for _, test := range tests {
+		recorder := apptest.Serve(AggregateHandler, props, apptest.Request("/aggregate", test.params...))
+		if recorder.Code != http.StatusOK || recorder.Body.String() != test.expected+"\n" {
+			t.Errorf("%v: expected 200 %s, got %d %s", test.params, test.expected, recorder.Code, recorder.Body.String())
+		}
+	}
+	for _, params := range [][]string{
+		{"name", "app.log", "bucket", "10ms"},
+		{"name", "app.log", "bucket", "1s", "since", "2023-01-01T00:00:00Z", "until", "2023-02-01T00:00:00Z"},
+	} {
+		recorder := apptest.Serve(AggregateHandler, props, apptest.Request("/aggregate", params...))
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("%v: expected 400, got %d %s", params, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+func TestTopHandler(t *testing.T) {
+	tree := apptest.NewTree().
+		File("access.log", "10.0.0.1 GET /a 200", "10.0.0.2 GET /b 404", "10.0.0.1 GET /c 200",
+			"10.0.0.3 GET /a 500", "10.0.0.1 POST /a 200", "10.0.0.2 GET /a 200").
+		File("app.log", "2023-02-17T10:00:05Z disk full", "2023-02-17T10:00:30Z up",
+			"2023-02-17T10:02:10Z disk full")
+	props := apptest.Properties(tree.MapFS("/var/log"), "/var/log")
+	tests := []struct {
+		params   []string
+		expected string
+	}{
+		{[]string{"name", "access.log", "extract", "fields:1", "count", "2"},
+			`"values":[{"value":"10.0.0.1","count":3},{"value":"10.0.0.2","count":2}],"distinct":3,"other":0,"missing":0}`},
+		{[]string{"name", "access.log", "extract", "regex: (\\d+)$", "filter", "-200"},
+			`"values":[{"value":"404","count":1},{"value":"500","count":1}],"distinct":2,"other":0,"missing":0}`},
+		{[]string{"name", "access.log", "extract", "regex:POST (\\S+)"},
+			`"values":[{"value":"/a","count":1}],"distinct":1,"other":0,"missing":5}`},
+		{[]string{"name", "app.log"},
+			`"values":[{"value":"disk full","count":2},{"value":"up","count":1}],"distinct":2,"other":0,"missing":0}`},
+	}
+	for _, test := range tests {
+		recorder := apptest.Serve(TopHandler, props, apptest.Request("/top", test.params...))
+		if recorder.Code != http.StatusOK || !strings.HasSuffix(recorder.Body.String(), test.expected+"\n") {
+			t.Errorf("%v: expected 200 ...%s, got %d %s", test.params, test.expected, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+func TestTopHandler_symlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links need privileges")
+	}
+	root, outside := apptest.NewTree().File("dir/app.log", "ok").WriteDir(t), t.TempDir()
+	os.WriteFile(filepath.Join(outside, "shadow"), []byte("root:SECRETHASH\n"), 0644)
+	os.Symlink(filepath.Join(outside, "shadow"), filepath.Join(root, "dir", "evil.log"))
+	os.Symlink("app.log", filepath.Join(root, "dir", "current.log"))
+	props := apptest.Properties(app.OSFileSystem, root)
+
+	// Both scan the link within the root, and only it.
+	recorder := apptest.Serve(TopHandler, props, apptest.Request("/top", "name", "dir"))
+	if body := recorder.Body.String(); recorder.Code != http.StatusOK ||
+		strings.Contains(body, "SECRET") || !strings.Contains(body, `{"value":"ok","count":2}`) {
+		t.Errorf("/top: expected 200 without the outside file, got %d %s", recorder.Code, body)
+	}
+	recorder = apptest.Serve(AggregateHandler, props, apptest.Request("/aggregate", "name", "dir"))
+	if body := recorder.Body.String(); recorder.Code != http.StatusOK || !strings.Contains(body, `"files":2,"matches":2,`) {
+		t.Errorf("/aggregate: expected 200 for 2 files, got %d %s", recorder.Code, body)
+	}
+}
+
+// linkPattern finds a Link header's URL of a relation.
+var linkPattern = regexp.MustCompile(`<([^>]*)>; rel="([a-z]+)"`)
+
+// pageLinks gives the Link header's URLs by relation.
+func pageLinks(header http.Header) map[string]string {
+	links := map[string]string{}
+	for _, m := range linkPattern.FindAllStringSubmatch(header.Get("Link"), -1) {
+		links[m[2]] = m[1]
+	}
+	return links
+}
+
+func TestHandler_pages(t *testing.T) {
+	fsys := fstest.MapFS{"logs/app.log": {Data: []byte("1\n2\n3\n4\n5\n6\n7\n")}}
+	props := apptest.Properties(fsys, "/logs")
+	get := func(target string) (int, string, map[string]string) {
+		recorder := apptest.Serve(Handler, props, httptest.NewRequest(http.MethodGet, target, nil))
+		return recorder.Code, recorder.Body.String(), pageLinks(recorder.Header())
+	}
+
+	code, body, links := get("/read?name=app.log&page-size=3")
+	if code != http.StatusOK || body != "7\n6\n5\n" || links["next"] == "" || links["prev"] != "" ||
+		links["first"] != "/read?name=app.log&page-size=3" {
+		t.Fatalf("first page: got %d %q %v", code, body, links)
+	}
+	// Lines appended after the first page do not shift the pages.
+	fsys["logs/app.log"].Data = []byte("1\n2\n3\n4\n5\n6\n7\n8\n")
+	code, body, links = get(links["next"])
+	if code != http.StatusOK || body != "4\n3\n2\n" || links["next"] == "" || links["prev"] == "" {
+		t.Fatalf("second page: got %d %q %v", code, body, links)
+	}
+	second := links["next"]
+	code, body, links = get(second)
+	if code != http.StatusOK || body != "1\n" || links["next"] != "" {
+		t.Errorf("last page: got %d %q %v", code, body, links)
+	}
+	if code, body, _ = get(links["prev"]); body != "4\n3\n2\n" {
+		t.Errorf("prev: got %d %q", code, body)
+	}
+	if code, body, _ := get("/read?name=app.log&page-size=2&filter=-1"); body != "8\n7\n" {
+		t.Errorf("filtered: got %d %q", code, body)
+	}
+
+	fsys["logs/app.log"].Data = []byte("1\n")
+	if code, _, _ := get(second); code != http.StatusConflict {
+		t.Errorf("truncated: expected 409, got %d", code)
+	}
+	for _, target := range []string{
+		"/read?name=app.log&page=bogus",
+		"/read?name=app.log&page-size=2&count=5",
+		"/read?name=app.log&page-size=2&follow=true",
+	} {
+		if code, _, _ := get(target); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", target, code)
+		}
+	}
+}
+
+func TestHandler_formats(t *testing.T) {
+	fsys := fstest.MapFS{"logs/app.log": {Data: []byte("a <b>\n\"c\"\n")}}
+	props := apptest.Properties(fsys, "/logs")
+	tests := []struct {
+		target string
+		accept string
+		body   string
+		typ    string
+		vary   bool
+	}{
+		{"/read?name=app.log", "", "\"c\"\na <b>\n", "text/plain; charset=utf-8", true},
+		{"/read?name=app.log", "*/*", "\"c\"\na <b>\n", "text/plain; charset=utf-8", true},
+		{"/read?name=app.log", "application/json", `{"lines":["\"c\"","a <b>"]}` + "\n", "application/json", true},
+		{"/read?name=app.log", "text/plain;q=0.5, application/x-ndjson", `{"line":"\"c\""}` + "\n" + `{"line":"a <b>"}` + "\n",
+			"application/x-ndjson", true},
+		{"/read?name=app.log&format=json", "text/plain", `{"lines":["\"c\"","a <b>"]}` + "\n", "application/json", false},
+		{"/read?name=app.log&format=json&filter=none", "", `{"lines":[]}` + "\n", "application/json", false},
+		{"/read?name=app.log&format=json&multiline=true", "", `{"lines":["\"c\"","a <b>"]}` + "\n", "application/json", false},
+		{"/read?name=app.log&format=json&page-size=1", "", `{"lines":["\"c\""],"page":{"size":1,"next":"MS4xLjEwLjEuMA"}}` + "\n",
+			"application/json", false},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, test.target, nil)
+		if test.accept != "" {
+			request.Header.Set("Accept", test.accept)
+		}
+		recorder := apptest.Serve(Handler, props, request)
+		if recorder.Code != http.StatusOK || recorder.Body.String() != test.body {
+			t.Errorf("%s, Accept %q: expected %q, got %d %q", test.target, test.accept, test.body, recorder.Code, recorder.Body)
+		}
+		header := recorder.Header()
+		if header.Get("Content-Type") != test.typ || (header.Get("Vary") == "Accept") != test.vary {
+			t.Errorf("%s, Accept %q: got Content-Type %q, Vary %q", test.target, test.accept, header.Get("Content-Type"), header.Get("Vary"))
+		}
+	}
+	for _, target := range []string{
+		"/read?name=app.log&format=json&follow=true",
+		"/read?name=app.log&format=hexdump&page-size=1",
+	} {
+		if recorder := apptest.Serve(Handler, props, httptest.NewRequest(http.MethodGet, target, nil)); recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", target, recorder.Code)
+		}
+	}
+}
+
+func TestHandler_redact(t *testing.T) {
+	fsys := apptest.NewTree().
+		File("pay/api.log", "paid 4111 1111 1111 1111 by ann@example.com\nrefund by bob@example.com").
+		File("app.log", "mail ann@example.com").MapFS("/logs")
+	props := apptest.Properties(fsys, "/logs")
+	if err := props.SetRedact([]string{"pay=card", "pay=email"}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		params []string
+		code   int
+		body   string
+	}{
+		{[]string{"name", "pay/api.log"}, http.StatusOK,
+			"refund by [redacted:email]\npaid [redacted:card] by [redacted:email]\n"},
+		{[]string{"name", "pay/api.log", "filter", "ann@"}, http.StatusOK, ""},
+		{[]string{"name", "app.log"}, http.StatusOK, "mail ann@example.com\n"},
+		{[]string{"name", "pay/api.log", "format", "hexdump"}, http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		recorder := apptest.Serve(Handler, props, apptest.Request("/read", test.params...))
+		if recorder.Code != test.code || (test.code == http.StatusOK && recorder.Body.String() != test.body) {
+			t.Errorf("%v: expected %d %q, got %d %q", test.params, test.code, test.body, recorder.Code, recorder.Body)
+		}
+	}
+}
+
+// A bundle keeps only the failing request's log entries, and no
+// credentials from its parameters or headers.
+func TestCaptureFailure(t *testing.T) {
+	props := app.DefaultProperties()
+	dir := t.TempDir()
+	props.SetCaptureDir(dir)
+	app.Logf(app.LogInfo, "other request", "id", "other-id", "path", "secret/path.log")
+	app.Logf(app.LogInfo, "this request", "id", "this-id")
+
+	request := httptest.NewRequest(http.MethodGet, "/read?name=app.log&access_token=abc123", nil)
+	request.Header.Set("Authorization", "Bearer abc123")
+	request.Header.Set("X-Api-Key", "abc123")
+	request = request.WithContext(app.WithRequestID(request.Context(), "this-id"))
+	captureFailure(props, request, strings.NewReader("one\ntwo\n"), 8, 0, errors.New("bad bytes"))
+
+	bundles, err := os.ReadDir(dir)
+	if err != nil || len(bundles) != 1 {
+		t.Fatalf("expected one bundle, got %v, %v", bundles, err)
+	}
+	read := func(name string) string {
+		b, err := os.ReadFile(filepath.Join(dir, bundles[0].Name(), name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	if logText := read("log.txt"); !strings.Contains(logText, "this request") || strings.Contains(logText, "other") {
+		t.Errorf("log.txt: expected only this request's entries, got %q", logText)
+	}
+	requestText := read("request.json")
+	if strings.Contains(requestText, "abc123") || !strings.Contains(requestText, "app.log") {
+		t.Errorf("request.json: expected the name without credentials, got %s", requestText)
+	}
+}
@@ -0,0 +1,61 @@
+package read
+
+import (
+	"context"
+	"io"
+	"time"
+	"varlog/service/app"
+	"varlog/service/dockerfs"
+	"varlog/service/index"
+	"varlog/service/scan"
+)
+
+// timeRange gives the byte range of the file holding the lines the
+// 'since' and 'until' parameters select: from the first line not
+// before since, to the first line after until.  Lines are found by
+// binary search over their leading timestamps, so the range costs a
+// few dozen small reads rather than a scan; with an index, its samples
+// narrow the search first.  Without the parameters, the range is the
+// whole file.
+func timeRange(ctx context.Context, props *app.Properties, file app.File, size int64, x *index.Index) (start int64, end int64, err error) {
+	since, until := props.ParamSince(), props.ParamUntil()
+	if since.IsZero() && until.IsZero() {
+		return 0, size, nil
+	}
+	if !since.IsZero() && !until.IsZero() && until.Before(since) {
+		return 0, 0, app.ParamError(app.ParamUntil, "until is before since")
+	}
+	parse := func(s string) (time.Time, bool) {
+		if props.Format() == dockerfs.Format {
+			s = dockerfs.DecodeLine(s)
+		}
+		return props.LineTime(s)
+	}
+	// Finds the first line not before, within the index's bracket.
+	seek := func(before func(time.Time) bool) (int64, error) {
+		lo, hi := int64(0), size
+		if x != nil && x.Size == size {
+			lo, hi = x.Bracket(before)
+		}
+		offset, err := scan.SeekTime(ctx, io.NewSectionReader(file, lo, hi-lo), hi-lo, parse, before)
+		return lo + offset, err
+	}
+	end = size
+	if !since.IsZero() {
+		before := func(t time.Time) bool { return t.Before(since) }
+		if start, err = seek(before); err != nil {
+			return 0, 0, err
+		}
+	}
+	if !until.IsZero() {
+		notAfter := func(t time.Time) bool { return !t.After(until) }
+		if end, err = seek(notAfter); err != nil {
+			return 0, 0, err
+		}
+	}
+	if end < start {
+		end = start
+	}
+	app.Log(app.LogDebug, "Time range of %q: bytes %d to %d of %d", props.RelativePath(), start, end, size)
+	return start, end, nil
+}
@@ -0,0 +1,101 @@
+package read
+
+import (
+	"net/http"
+	"sort"
+	"time"
+	"varlog/service/app"
+	"varlog/service/scan"
+)
+
+// The /top endpoint counts the values of matching lines and gives the
+// most frequent, as awk | sort | uniq -c | sort -rn does: the top
+// client addresses of an access log, say, with 'extract=fields:1'.
+//
+// A line's value is what the 'extract' parameter selects, a field or
+// a capture group, or without it the line less its leading timestamp.
+// Lines without the selected fields are not counted.  With multiline,
+// a record's value is its first line's.  Parameter 'count=number'
+// gives how many values, default 10.  The name, filter, multiline,
+// encoding, sanitize, since, and until parameters apply as for
+// /aggregate.
+
+const (
+	// Values in a /top response, without a count.
+	defaultTopValues = 10
+
+	// Distinct values counted.  Values first seen after this many
+	// are counted together, as other, rather than filling memory.
+	maxTopValues = 100000
+)
+
+// A value and the number of times it was seen.
+type topValue struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Response for /top.
+type topResult struct {
+	scanTotals
+	Values   []topValue `json:"values"`   // The most frequent, most first
+	Distinct int        `json:"distinct"` // Values counted
+	Other    int        `json:"other"`    // Matches past maxTopValues, not counted
+	Missing  int        `json:"missing"`  // Matches without the extracted fields
+}
+
+// TopHandler serves /top.
+func TopHandler(writer http.ResponseWriter, request *http.Request) {
+	var t0 = time.Now()
+	props, files, ok := requestFiles(writer, request)
+	if !ok {
+		return
+	}
+	result := topResult{scanTotals: scanTotals{Name: props.RelativePath()}}
+	counts := map[string]int{}
+	add := func(file *app.Properties, record []string) {
+		value := scan.StripTimestamp(record[0])
+		if file.Extracting() {
+			var found bool
+			if value, found = file.Extract(record[0]); !found {
+				result.Missing++
+				return
+			}
+		}
+		if _, seen := counts[value]; !seen && len(counts) >= maxTopValues {
+			result.Other++
+			return
+		}
+		counts[value]++
+	}
+	if !scanFiles(writer, request, files, &result.scanTotals, add) {
+		return
+	}
+	n := props.ParamCount()
+	if n <= 0 {
+		n = defaultTopValues
+	}
+	result.Values = topValues(counts, n)
+	result.Distinct = len(counts)
+	writeJSON(writer, request, result)
+	app.Log(app.LogDebug, "/top %d files, %d values, %v", result.Files, result.Distinct, time.Since(t0))
+}
+
+// topValues gives the n most frequent values, most first; values
+// seen as often are in order.
+func topValues(counts map[string]int, n int) []topValue {
+	values := make([]topValue, 0, len(counts))
+	for value, count := range counts {
+		values = append(values, topValue{value, count})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Count != values[j].Count {
+			return values[i].Count > values[j].Count
+		}
+		return values[i].Value < values[j].Value
+	})
+	if len(values) > n {
+		values = values[:n]
+	}
+	return values
+}
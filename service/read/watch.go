@@ -0,0 +1,51 @@
+package read
+
+import (
+	"time"
+)
+
+// A watcher signals when a followed file may have changed: data
+// appended, truncated, or replaced by rotation.  Signals coalesce, so
+// a burst of writes wakes the follower once.  Where the platform has
+// file notifications (inotify on Linux), changes arrive as they
+// happen, and an idle file costs nothing; otherwise, and for files
+// not on the local disk, the watcher polls.
+type watcher struct {
+	changes chan struct{}
+	stop    func()
+}
+
+// notify signals a change, unless one is already pending.
+func (w *watcher) notify() {
+	select {
+	case w.changes <- struct{}{}:
+	default:
+	}
+}
+
+// close stops the watcher.
+func (w *watcher) close() {
+	w.stop()
+}
+
+// newPollWatcher signals every interval.
+func newPollWatcher(interval time.Duration) *watcher {
+	w := &watcher{changes: make(chan struct{}, 1)}
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	w.stop = func() {
+		ticker.Stop()
+		close(done)
+	}
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				w.notify()
+			}
+		}
+	}()
+	return w
+}
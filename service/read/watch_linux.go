@@ -0,0 +1,71 @@
+package read
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+	"unsafe"
+	"varlog/service/app"
+	"varlog/service/list"
+)
+
+// Events in the file's directory that concern the file: writes, and
+// the renames, creations, and removals of rotation.
+const watchEvents = syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE |
+	syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// Events that change the directory's entries, and so its listing.
+const listingEvents = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_Q_OVERFLOW
+
+// newWatcher watches the file at the path with inotify.  It watches
+// the directory rather than the file, so it sees a new file created
+// at the path after the old one is rotated away.  Without a path, as
+// for files not on the local disk, or if inotify fails, it polls.
+// Seeing entries of the directory created, removed, or renamed, it
+// drops the directory's cached listing, so /list shows them at once.
+func newWatcher(path string, interval time.Duration) *watcher {
+	if path == "" {
+		return newPollWatcher(interval)
+	}
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		app.Log(app.LogDebug, "Cannot watch %s, polling: %s", path, err)
+		return newPollWatcher(interval)
+	}
+	if _, err = syscall.InotifyAddWatch(fd, filepath.Dir(path), watchEvents); err != nil {
+		syscall.Close(fd)
+		app.Log(app.LogDebug, "Cannot watch %s, polling: %s", path, err)
+		return newPollWatcher(interval)
+	}
+	// The descriptor is nonblocking, so reads wait in the runtime's
+	// poller, and closing the file ends a read in progress.
+	events := os.NewFile(uintptr(fd), "inotify")
+	w := &watcher{changes: make(chan struct{}, 1), stop: func() { events.Close() }}
+	dir := filepath.Dir(path)
+	base := []byte(filepath.Base(path))
+	go func() {
+		buffer := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := events.Read(buffer)
+			if err != nil {
+				return
+			}
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+				start := offset + syscall.SizeofInotifyEvent
+				offset = start + int(event.Len)
+				name := bytes.TrimRight(buffer[start:offset], "\x00")
+				if event.Mask&listingEvents != 0 {
+					list.Invalidate(dir)
+				}
+				if event.Mask&syscall.IN_Q_OVERFLOW != 0 || bytes.Equal(name, base) {
+					w.notify()
+				}
+			}
+		}
+	}()
+	return w
+}
@@ -0,0 +1,36 @@
+package read
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"varlog/service/app"
+	"varlog/service/apptest"
+	"varlog/service/list"
+)
+
+// A follower's watcher drops the cached listing of the file's directory
+// when an entry appears there.
+func TestWatcher_invalidatesListing(t *testing.T) {
+	dir := apptest.NewTree().Log("app.log", 2).WriteDir(t)
+	props := apptest.Properties(app.OSFileSystem, dir)
+	list.InvalidateAll()
+	defer list.InvalidateAll()
+	apptest.Serve(list.Handler, props, apptest.Request("/list"))
+	if entries, _, _ := list.CacheUsage(); entries != 1 {
+		t.Fatalf("expected the listing cached, got %d entries", entries)
+	}
+
+	w := newWatcher(filepath.Join(dir, "app.log"), time.Hour)
+	defer w.close()
+	if err := os.WriteFile(filepath.Join(dir, "new.log"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if entries, _, _ := list.CacheUsage(); entries == 0 {
+			return
+		}
+	}
+	t.Errorf("expected the listing dropped after a new entry")
+}
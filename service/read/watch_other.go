@@ -0,0 +1,12 @@
+//go:build !linux
+
+package read
+
+import (
+	"time"
+)
+
+// Without inotify, followers poll.
+func newWatcher(path string, interval time.Duration) *watcher {
+	return newPollWatcher(interval)
+}
@@ -0,0 +1,107 @@
+// Package reclaim provides code for the endpoints that change log
+// files, so operators can reclaim space through the API they diagnose
+// with, rather than over ssh:
+//
+//	DELETE /file?name=path       removes the file
+//	POST   /truncate?name=path   empties the file, as for a log still open
+//
+// Both are served only with a -write-authz file, and only for the
+// paths it allows the principal; see app.AuthorizedToWrite.  Denied
+// paths are not found, as for /read.  Only regular files under the
+// host's own file system are changed, and symbolic links are refused,
+// so a change cannot reach outside the root.  Parameter 'dry-run=true'
+// reports what would be done without doing it.  Each change is logged,
+// with the principal, as well as being audited.
+package reclaim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"varlog/service/app"
+)
+
+// Actions, as reported.
+const (
+	actionDelete   = "delete"
+	actionTruncate = "truncate"
+)
+
+// The response.
+type result struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	Bytes  int64  `json:"bytes"` // Bytes reclaimed, or that would be
+	DryRun bool   `json:"dry_run"`
+}
+
+// FileHandler serves DELETE /file.
+func FileHandler(writer http.ResponseWriter, request *http.Request) {
+	change(writer, request, http.MethodDelete, actionDelete)
+}
+
+// TruncateHandler serves POST /truncate.
+func TruncateHandler(writer http.ResponseWriter, request *http.Request) {
+	change(writer, request, http.MethodPost, actionTruncate)
+}
+
+// change checks the request, then deletes or truncates the file.
+func change(writer http.ResponseWriter, request *http.Request, method string, action string) {
+	if request.Method != method {
+		writer.Header().Set("Allow", method)
+		app.Error(writer, request, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	props := app.RequestProperties(request)
+	if err := props.ExtractParams(request); err != nil {
+		app.WriteError(writer, request, err)
+		return
+	}
+	name := props.RelativePath()
+	if app.Denied(name) {
+		app.Log(app.LogWarning, "Denied path %q requested", name)
+		app.Error(writer, request, "Not found", http.StatusNotFound)
+		return
+	}
+	if !props.AuthorizedToWrite(name) {
+		app.Log(app.LogWarning, "Principal %q not authorized to %s %q", props.Principal(), action, name)
+		app.Error(writer, request, "Access denied", http.StatusForbidden)
+		return
+	}
+	if props.FileSystem() != app.OSFileSystem {
+		app.WriteError(writer, request, app.ParamError(app.ParamName,
+			fmt.Sprintf("File %q not on this host's file system, not changed", name)))
+		return
+	}
+	osPath := app.OSPath(props.RootedPath())
+	info, err := os.Lstat(osPath)
+	if err != nil {
+		app.WriteError(writer, request, app.FileError(name, err))
+		return
+	}
+	if !info.Mode().IsRegular() {
+		app.WriteError(writer, request, app.ParamError(app.ParamName,
+			fmt.Sprintf("File %q not a regular file, not changed", name)))
+		return
+	}
+
+	r := result{Name: name, Action: action, Bytes: info.Size(), DryRun: props.ParamDryRun()}
+	if !r.DryRun {
+		done := "deleted"
+		if action == actionDelete {
+			err = os.Remove(osPath)
+		} else {
+			done = "truncated"
+			err = os.Truncate(osPath, 0)
+		}
+		if err != nil {
+			app.Log(app.LogWarning, "Principal %q failed to %s %q, %s", props.Principal(), action, name, err)
+			app.WriteError(writer, request, app.FileError(name, err))
+			return
+		}
+		app.Log(app.LogWarning, "Principal %q %s %q, reclaiming %d bytes", props.Principal(), done, name, r.Bytes)
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(r)
+}
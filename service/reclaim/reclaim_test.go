@@ -0,0 +1,136 @@
+package reclaim
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"varlog/service/app"
+	"varlog/service/apptest"
+)
+
+func TestChange(t *testing.T) {
+	root := apptest.NewTree().
+		File("app.log", "0123456789").
+		File("old.log", "0123456789", "0123456789").
+		File("nginx/access.log", "GET /a 200").
+		File("nginx/error.log", "timed out").
+		WriteDir(t)
+	if err := os.Symlink(filepath.Join(root, "app.log"), filepath.Join(root, "link.log")); err != nil {
+		t.Fatal(err)
+	}
+	rules := filepath.Join(t.TempDir(), "write-authz")
+	if err := os.WriteFile(rules, []byte("ops: *\nweb: nginx\n*: nginx/error.log\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.LoadWriteAuthz(rules); err != nil {
+		t.Fatal(err)
+	}
+	defer app.LoadWriteAuthz("")
+	props := app.DefaultProperties()
+	props.SetRoot(root)
+
+	serve := func(handler http.HandlerFunc, principal string, method string, target string) (int, result) {
+		request := httptest.NewRequest(method, target, nil)
+		request = request.WithContext(app.WithPrincipal(request.Context(), principal))
+		recorder := apptest.Serve(handler, props, request)
+		var r result
+		if recorder.Code == http.StatusOK {
+			json.Unmarshal(recorder.Body.Bytes(), &r)
+		}
+		return recorder.Code, r
+	}
+	size := func(name string) int64 {
+		info, err := os.Stat(filepath.Join(root, name))
+		if err != nil {
+			return -1
+		}
+		return info.Size()
+	}
+
+	code, r := serve(FileHandler, "ops", http.MethodDelete, "/file?name=old.log&dry-run=true")
+	if code != http.StatusOK || r != (result{Name: "old.log", Action: "delete", Bytes: 22, DryRun: true}) || size("old.log") != 22 {
+		t.Errorf("dry run: expected the file kept, got %d %+v", code, r)
+	}
+	code, r = serve(FileHandler, "ops", http.MethodDelete, "/file?name=old.log")
+	if code != http.StatusOK || r.Bytes != 22 || size("old.log") != -1 {
+		t.Errorf("delete: expected the file removed, got %d %+v", code, r)
+	}
+	code, r = serve(TruncateHandler, "ops", http.MethodPost, "/truncate?name=app.log")
+	if code != http.StatusOK || r.Action != "truncate" || r.Bytes != 11 || size("app.log") != 0 {
+		t.Errorf("truncate: expected the file emptied, got %d %+v", code, r)
+	}
+	if code, _ := serve(TruncateHandler, "web", http.MethodPost, "/truncate?name=nginx/access.log"); code != http.StatusOK || size("nginx/access.log") != 0 {
+		t.Errorf("web truncate: expected the file emptied, got %d", code)
+	}
+
+	for _, test := range []struct {
+		handler   http.HandlerFunc
+		principal string
+		method    string
+		target    string
+		code      int
+	}{
+		{FileHandler, "web", http.MethodDelete, "/file?name=app.log", http.StatusForbidden},
+		{FileHandler, "", http.MethodDelete, "/file?name=app.log", http.StatusForbidden},
+		{FileHandler, "", http.MethodDelete, "/file?name=nginx/error.log", http.StatusForbidden},
+		{FileHandler, "ops", http.MethodDelete, "/file?name=link.log", http.StatusBadRequest},
+		{FileHandler, "ops", http.MethodDelete, "/file?name=nginx", http.StatusBadRequest},
+		{FileHandler, "ops", http.MethodDelete, "/file?name=missing.log", http.StatusNotFound},
+		{FileHandler, "ops", http.MethodGet, "/file?name=app.log", http.StatusMethodNotAllowed},
+		{TruncateHandler, "ops", http.MethodDelete, "/truncate?name=app.log", http.StatusMethodNotAllowed},
+	} {
+		if code, _ := serve(test.handler, test.principal, test.method, test.target); code != test.code {
+			t.Errorf("%s %s by %q: expected %d, got %d", test.method, test.target, test.principal, test.code, code)
+		}
+	}
+	if size("link.log") != 0 {
+		t.Errorf("expected the link kept")
+	}
+
+	app.LoadWriteAuthz("")
+	if code, _ := serve(TruncateHandler, "ops", http.MethodPost, "/truncate?name=nginx/access.log"); code != http.StatusForbidden {
+		t.Errorf("without -write-authz: expected 403, got %d", code)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	root := apptest.NewTree().
+		File("app.log", "new").
+		File("nginx/access.log.1", "old").
+		File("nginx/access.log", "new").
+		File("keep/old.log", "old").
+		WriteDir(t)
+	old := time.Now().Add(-48 * time.Hour)
+	for _, name := range []string{"nginx/access.log.1", "keep/old.log"} {
+		if err := os.Chtimes(filepath.Join(root, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(root, "keep/old.log"), filepath.Join(root, "nginx/link.log")); err != nil {
+		t.Fatal(err)
+	}
+	props := app.DefaultProperties()
+	props.SetRoot(root)
+	if err := props.SetRetention([]string{"nginx=1d", "*.log=1w"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sweep(context.Background(), props, sweepRoots(props)[0], time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	for name, exists := range map[string]bool{
+		"app.log":            true,
+		"nginx/access.log.1": false,
+		"nginx/access.log":   true,
+		"nginx/link.log":     true,
+		"keep/old.log":       true,
+	} {
+		if _, err := os.Lstat(filepath.Join(root, name)); (err == nil) != exists {
+			t.Errorf("%s: expected exists %v, got %v", name, exists, err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package reclaim
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+	"varlog/service/app"
+)
+
+// Retention sweeps.  With -retention-sweep, a background task removes
+// the files past their -retention age (see app.RetentionPolicy) on
+// that interval.  It walks the root, or each -mount, on the host's own
+// file system; remote storage is left to its own lifecycle rules.  As
+// for DELETE /file, only regular files are removed, and symbolic links
+// are neither removed nor followed.  Each removal is logged.
+
+// A directory swept, and the prefix of its files' names.
+type sweepRoot struct {
+	dir    string
+	prefix string
+}
+
+// Sweep removes expired files every -retention-sweep, until the
+// context is canceled.  For the server's background tasks.
+func Sweep(ctx context.Context, props *app.Properties) error {
+	roots := sweepRoots(props)
+	if len(roots) == 0 {
+		app.Log(app.LogWarning, "Retention sweep has no local files to sweep")
+		return nil
+	}
+	ticker := time.NewTicker(props.RetentionSweep())
+	defer ticker.Stop()
+	for {
+		for _, root := range roots {
+			if err := sweep(ctx, props, root, time.Now()); err != nil && ctx.Err() == nil {
+				app.Log(app.LogWarning, "Retention sweep of %s failed, %s", root.dir, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweepRoots gives the directories on the host's file system.
+func sweepRoots(props *app.Properties) []sweepRoot {
+	if props.FileSystem() != app.OSFileSystem {
+		return nil
+	}
+	if len(props.Mounts()) == 0 {
+		return []sweepRoot{{dir: props.Root()}}
+	}
+	var roots []sweepRoot
+	for _, m := range props.Mounts() {
+		if m.FS == nil {
+			roots = append(roots, sweepRoot{dir: m.Path, prefix: m.Name})
+		}
+	}
+	return roots
+}
+
+// sweep removes the files under the root expired at the time.
+// Files that cannot be examined or removed are logged and skipped.
+func sweep(ctx context.Context, props *app.Properties, root sweepRoot, now time.Time) error {
+	dir := app.OSPath(root.dir)
+	return filepath.WalkDir(dir, func(osPath string, entry fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			if osPath == dir {
+				return err
+			}
+			app.Log(app.LogDebug, "Retention sweep skipped %s, %s", osPath, err)
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		relative, err := filepath.Rel(dir, osPath)
+		if err != nil {
+			return nil
+		}
+		name := path.Join(root.prefix, filepath.ToSlash(relative))
+		policy, ok := props.Retention(name)
+		if !ok {
+			return nil
+		}
+		// Examine the file itself, just before removing it, in case
+		// it was written or replaced during the walk.
+		info, err := os.Lstat(osPath)
+		if err != nil || !info.Mode().IsRegular() || now.Before(policy.Expires(info.ModTime())) {
+			return nil
+		}
+		if err := os.Remove(osPath); err != nil {
+			app.Log(app.LogWarning, "Retention sweep failed to delete %q, %s", name, err)
+			return nil
+		}
+		app.Log(app.LogWarning, "Retention sweep deleted %q, modified %s, past %s=%s, reclaiming %d bytes",
+			name, info.ModTime().UTC().Format(time.RFC3339), policy.Pattern, policy.Text, info.Size())
+		return nil
+	})
+}
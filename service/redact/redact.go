@@ -0,0 +1,156 @@
+// Package redact provides the processors applied to each line the
+// service sends, so personal data and secrets are masked before log
+// content leaves the host.  -redact options choose processors by path
+// prefix; see app.Properties.Redact.
+//
+// Built-in processors, by name:
+//
+//	card    Payment card numbers, 13 to 19 digits passing the Luhn check
+//	email   Email addresses
+//	bearer  Bearer tokens, as in "Authorization: Bearer ..."
+//
+// Each replaces what it finds with a marker naming it, as
+// "[redacted:email]".  A processor given as regex:PATTERN replaces
+// the pattern's matches with "[redacted]".  Programs building the
+// service add their own with Register, from an init function:
+//
+//	func init() {
+//		redact.Register("employee-id", redact.Func(func(line string) string {
+//			return employeeID.ReplaceAllString(line, "[redacted:employee-id]")
+//		}))
+//	}
+package redact
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Prefix of a processor given as a pattern.
+const regexPrefix = "regex:"
+
+// A Processor transforms a line on its way to the client.  It must be
+// safe for concurrent use, and fast: it runs on every line sent.
+type Processor interface {
+	Process(line string) string
+}
+
+// Func adapts a function to a Processor.
+type Func func(line string) string
+
+// Process calls the function.
+func (f Func) Process(line string) string {
+	return f(line)
+}
+
+// The processors by name.
+var (
+	mutex      sync.RWMutex
+	processors = map[string]Processor{}
+)
+
+// Register makes a processor available to -redact by name.  Names are
+// unique; registering one twice panics, as it is a programming error.
+func Register(name string, p Processor) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if p == nil || name == "" || strings.HasPrefix(name, regexPrefix) {
+		panic(fmt.Sprintf("redact: Register of invalid processor %q", name))
+	}
+	if _, found := processors[name]; found {
+		panic(fmt.Sprintf("redact: Register called twice for %q", name))
+	}
+	processors[name] = p
+}
+
+// Names gives the names of the registered processors, sorted.
+func Names() []string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return namesLocked()
+}
+
+// Lookup gives a processor by name, or regex:PATTERN.
+func Lookup(spec string) (Processor, error) {
+	if strings.HasPrefix(spec, regexPrefix) {
+		re, err := regexp.Compile(strings.TrimPrefix(spec, regexPrefix))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("processor %q: %s", spec, err))
+		}
+		return Func(func(line string) string {
+			return re.ReplaceAllLiteralString(line, "[redacted]")
+		}), nil
+	}
+	mutex.RLock()
+	defer mutex.RUnlock()
+	p, found := processors[spec]
+	if !found {
+		return nil, errors.New(fmt.Sprintf("processor %q unknown; known are %s, or regex:PATTERN",
+			spec, strings.Join(namesLocked(), ", ")))
+	}
+	return p, nil
+}
+
+// namesLocked gives the sorted names with the mutex held.
+func namesLocked() []string {
+	names := make([]string, 0, len(processors))
+	for name := range processors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Patterns of the built-in processors.  A card number is digits with
+// optional single spaces or dashes between them; the Luhn check then
+// tells it from other numbers.
+var (
+	cardPattern   = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+	emailPattern  = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)
+	bearerPattern = regexp.MustCompile(`(?i)(\bbearer\s+)[A-Za-z0-9._~+/-]+=*`)
+)
+
+func init() {
+	Register("card", Func(redactCards))
+	Register("email", Func(func(line string) string {
+		return emailPattern.ReplaceAllLiteralString(line, "[redacted:email]")
+	}))
+	Register("bearer", Func(func(line string) string {
+		return bearerPattern.ReplaceAllString(line, "${1}[redacted:token]")
+	}))
+}
+
+// redactCards replaces the card numbers in the line.
+func redactCards(line string) string {
+	return cardPattern.ReplaceAllStringFunc(line, func(s string) string {
+		if !luhn(s) {
+			return s
+		}
+		return "[redacted:card]"
+	})
+}
+
+// luhn reports whether the digits of s, ignoring separators, pass the
+// Luhn check of payment card numbers.
+func luhn(s string) bool {
+	sum, double := 0, false
+	for j := len(s) - 1; j >= 0; j-- {
+		c := s[j]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
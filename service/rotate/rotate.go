@@ -0,0 +1,222 @@
+// Package rotate provides a file writer that rotates the files the
+// service itself writes, the audit log and the -log-file, so they do
+// not fill the partition the service monitors.  A file is rotated
+// when a write would take it past a size, or when it has been open
+// for an age, whichever comes first:
+//
+//	audit.log     current
+//	audit.log.1   most recent rotation, or audit.log.1.gz compressed
+//	audit.log.2   ...
+//
+// At most Keep rotated files are kept; older ones are removed.
+// Compression runs in the background, after the rename, so writers
+// wait only for the rename.  Reopen supports external rotation, as by
+// logrotate, instead.
+package rotate
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Suffix of compressed rotated files.
+const gzSuffix = ".gz"
+
+// Policy says when files are rotated and what is kept.
+type Policy struct {
+	Size     int64         // Rotate before a write would pass this many bytes, 0 for no limit
+	Age      time.Duration // Rotate a file open this long, 0 for no limit
+	Keep     int           // Rotated files kept; at least 1
+	Compress bool          // Gzip rotated files
+}
+
+// Writer appends to a file, rotating it by its policy.  Safe for
+// concurrent use.
+type Writer struct {
+	mutex    sync.Mutex
+	name     string
+	perm     os.FileMode
+	policy   Policy
+	file     *os.File
+	size     int64          // Bytes in the file
+	opened   time.Time      // When the file was opened, for Age
+	compress sync.WaitGroup // Compression in progress
+}
+
+// Open opens the file for appending, creating it with the permissions
+// if needed.
+func Open(name string, perm os.FileMode, policy Policy) (*Writer, error) {
+	if policy.Keep < 1 {
+		policy.Keep = 1
+	}
+	w := &Writer{name: name, perm: perm, policy: policy}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Name gives the file's name.
+func (w *Writer) Name() string {
+	return w.name
+}
+
+// Write appends the bytes, rotating first if the policy says so.
+// A failed rotation is logged, and the bytes go to the current file.
+func (w *Writer) Write(b []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.file == nil {
+		return 0, errors.New(fmt.Sprintf("%s closed", w.name))
+	}
+	if w.due(len(b)) {
+		if err := w.rotate(); err != nil {
+			// Not app.Log: the service log may be this writer, and
+			// rotation waits on compression with the mutex held.
+			fmt.Fprintf(os.Stderr, "%s rotation failed, %s\n", w.name, err)
+		}
+		if w.file == nil {
+			return 0, errors.New(fmt.Sprintf("%s not reopened after rotation", w.name))
+		}
+	}
+	n, err := w.file.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+// Rotate rotates the file now.
+func (w *Writer) Rotate() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.rotate()
+}
+
+// Reopen closes and reopens the file, so logrotate can move it.
+func (w *Writer) Reopen() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+	return w.open()
+}
+
+// Close closes the file, after any compression finishes.
+func (w *Writer) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.compress.Wait()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// due reports whether the file must be rotated before writing the
+// bytes.  An empty file is not rotated, so a single large write still
+// goes somewhere.  The mutex must be held.
+func (w *Writer) due(n int) bool {
+	if w.size == 0 {
+		return false
+	}
+	return w.policy.Size > 0 && w.size+int64(n) > w.policy.Size ||
+		w.policy.Age > 0 && time.Since(w.opened) >= w.policy.Age
+}
+
+// open opens the file.  The mutex must be held.
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, w.perm)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file, w.size, w.opened = f, info.Size(), time.Now()
+	return nil
+}
+
+// rotate shifts the rotated files up one, removing the oldest, and
+// moves the file to .1.  The mutex must be held.
+func (w *Writer) rotate() error {
+	w.compress.Wait()
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+	for n := w.policy.Keep; n >= 1; n-- {
+		for _, suffix := range []string{"", gzSuffix} {
+			from := w.rotated(n) + suffix
+			if _, err := os.Lstat(from); err != nil {
+				continue
+			}
+			if n == w.policy.Keep {
+				os.Remove(from)
+			} else if err := os.Rename(from, w.rotated(n+1)+suffix); err != nil {
+				w.open()
+				return err
+			}
+		}
+	}
+	first := w.rotated(1)
+	if err := os.Rename(w.name, first); err != nil {
+		w.open()
+		return err
+	}
+	if w.policy.Compress {
+		w.compress.Add(1)
+		go func() {
+			defer w.compress.Done()
+			if err := compress(first); err != nil {
+				fmt.Fprintf(os.Stderr, "Compressing %s failed, %s\n", first, err)
+			}
+		}()
+	}
+	return w.open()
+}
+
+// rotated gives the name of rotated file n, without any .gz.
+func (w *Writer) rotated(n int) string {
+	return fmt.Sprintf("%s.%d", w.name, n)
+}
+
+// compress gzips the file to name.gz, then removes it.  A partial
+// .gz is removed on failure, leaving the file.
+func compress(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(name+gzSuffix, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name + gzSuffix)
+		return err
+	}
+	return os.Remove(name)
+}
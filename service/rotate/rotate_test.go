@@ -0,0 +1,101 @@
+package rotate
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// files gives the names in the directory, sorted.
+func files(t *testing.T, dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return strings.Join(names, " ")
+}
+
+func TestSize(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "audit.log")
+	w, err := Open(name, 0600, Policy{Size: 10, Keep: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "five\n", "a much longer line\n"} {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := files(t, dir); got != "audit.log audit.log.1 audit.log.2" {
+		t.Errorf("expected two rotated files, got %q", got)
+	}
+	for suffix, want := range map[string]string{"": "a much longer line\n", ".1": "four\nfive\n", ".2": "three\n"} {
+		if b, _ := os.ReadFile(name + suffix); string(b) != want {
+			t.Errorf("audit.log%s: expected %q, got %q", suffix, want, b)
+		}
+	}
+}
+
+func TestAgeCompress(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "varlog.log")
+	if err := os.WriteFile(name, []byte("before\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := Open(name, 0644, Policy{Age: time.Hour, Keep: 3, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.Write([]byte("kept\n"))
+	if got := files(t, dir); got != "varlog.log" {
+		t.Errorf("young file: expected no rotation, got %q", got)
+	}
+	w.mutex.Lock()
+	w.opened = time.Now().Add(-2 * time.Hour)
+	w.mutex.Unlock()
+	w.Write([]byte("after\n"))
+	w.Close()
+	if got := files(t, dir); got != "varlog.log varlog.log.1.gz" {
+		t.Fatalf("expected a compressed rotation, got %q", got)
+	}
+	f, err := os.Open(name + ".1.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(zr); string(b) != "before\nkept\n" {
+		t.Errorf("expected the old lines compressed, got %q", b)
+	}
+
+	// Reopen follows a move, as by logrotate.
+	w, err = Open(name, 0644, Policy{Keep: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	os.Rename(name, name+".moved")
+	if err := w.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("new\n"))
+	if b, _ := os.ReadFile(name); string(b) != "new\n" {
+		t.Errorf("reopen: expected a new file, got %q", b)
+	}
+}
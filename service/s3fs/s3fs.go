@@ -0,0 +1,428 @@
+// Package s3fs serves S3-compatible object storage as an io/fs file
+// system, so /list and /read work over archived logs as over local
+// files.  The file system's top-level directories are buckets:
+// the name "archive/web/app.log" is the key "web/app.log" in the
+// bucket "archive".  Directories are key prefixes ending in "/",
+// as the S3 console shows them.
+//
+// Files support ReadAt through ranged GETs, so the reverser reads
+// backward one chunk per request without downloading whole objects.
+// Only the standard library is used: requests are signed with AWS
+// Signature Version 4 (see sign.go), and listings are ListObjectsV2.
+package s3fs
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Region used when none is configured.
+	defaultRegion = "us-east-1"
+
+	// Limit on any one request to the storage service.
+	requestTimeout = 30 * time.Second
+)
+
+// Config describes the storage service and credentials.
+// Empty credentials are taken from the standard AWS environment
+// variables; with none there, requests are anonymous, as for
+// public buckets.
+type Config struct {
+	// Base URL of an S3-compatible service, e.g., http://minio:9000.
+	// Buckets are addressed by path under it.  Empty uses AWS,
+	// with buckets addressed by host name.
+	Endpoint string
+
+	Region       string // Signing region, default us-east-1
+	AccessKey    string // AWS_ACCESS_KEY_ID
+	SecretKey    string // AWS_SECRET_ACCESS_KEY
+	SessionToken string // AWS_SESSION_TOKEN, for temporary credentials
+}
+
+// FS is the file system of an S3-compatible service.
+type FS struct {
+	config   Config
+	endpoint *url.URL // Nil for AWS host-name addressing
+	client   *http.Client
+}
+
+// New creates the file system for a storage service.
+func New(config Config) (*FS, error) {
+	if config.Region == "" {
+		config.Region = defaultRegion
+	}
+	if config.AccessKey == "" && config.SecretKey == "" {
+		config.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		config.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		config.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	fsys := &FS{
+		config: config,
+		client: &http.Client{Timeout: requestTimeout},
+	}
+	if config.Endpoint != "" {
+		u, err := url.Parse(config.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, errors.New(fmt.Sprintf("endpoint %q not an http(s) URL", config.Endpoint))
+		}
+		fsys.endpoint = u
+	}
+	return fsys, nil
+}
+
+// ParseURL splits an s3://bucket/prefix URL into the bucket and the
+// key prefix, which may be empty.
+func ParseURL(s string) (bucket string, prefix string, err error) {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return "", "", errors.New(fmt.Sprintf("%q not an s3://bucket/prefix URL", s))
+	}
+	return u.Host, strings.Trim(u.Path, "/"), nil
+}
+
+// split divides a file system name into the bucket and the key.
+func split(name string) (bucket string, key string) {
+	bucket, key, _ = strings.Cut(name, "/")
+	return bucket, key
+}
+
+// objectURL gives the URL for a key in a bucket, with a query.
+func (fsys *FS) objectURL(bucket string, key string, query url.Values) *url.URL {
+	u := &url.URL{Scheme: "https", Host: bucket + ".s3." + fsys.config.Region + ".amazonaws.com"}
+	p := "/" + key
+	if fsys.endpoint != nil {
+		u.Scheme, u.Host = fsys.endpoint.Scheme, fsys.endpoint.Host
+		p = strings.TrimSuffix(fsys.endpoint.Path, "/") + "/" + bucket + "/" + key
+	}
+	u.Path = p
+	u.RawPath = uriEncode(p, true)
+	u.RawQuery = canonicalQuery(query)
+	return u
+}
+
+// do sends a signed request.  Status 404 and 403 become
+// fs.ErrNotExist and fs.ErrPermission, and other failures errors
+// naming the status.  On success the caller closes the body.
+func (fsys *FS) do(method string, u *url.URL, header http.Header) (*http.Response, error) {
+	request, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	if fsys.config.AccessKey != "" {
+		fsys.config.sign(request, time.Now())
+	}
+	response, err := fsys.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	switch response.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return response, nil
+	}
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusNotFound:
+		return nil, fs.ErrNotExist
+	case http.StatusForbidden:
+		return nil, fs.ErrPermission
+	}
+	return nil, errors.New(fmt.Sprintf("%s %s: %s", method, u.Redacted(), response.Status))
+}
+
+// Put uploads size bytes from the body as the named object, as
+// bucket/key.  The payload is not signed, so it need not be read
+// twice.  Unlike reads, an upload has no time limit but the context's.
+func (fsys *FS) Put(ctx context.Context, name string, body io.Reader, size int64) error {
+	bucket, key := split(name)
+	if bucket == "" || key == "" {
+		return &fs.PathError{Op: "put", Path: name, Err: fs.ErrInvalid}
+	}
+	u := fsys.objectURL(bucket, key, nil)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), body)
+	if err != nil {
+		return err
+	}
+	request.ContentLength = size
+	request.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	if fsys.config.AccessKey != "" {
+		fsys.config.sign(request, time.Now())
+	}
+	client := &http.Client{Transport: fsys.client.Transport}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprintf("PUT %s: %s", u.Redacted(), response.Status))
+	}
+	return nil
+}
+
+// Open opens the named file or directory.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	info, err := fsys.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return &file{fsys: fsys, name: name, info: info}, nil
+}
+
+// Stat describes the named file or directory.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	return fsys.stat("stat", name)
+}
+
+func (fsys *FS) stat(op string, name string) (*fileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	bucket, key := split(name)
+	if name == "." || key == "" {
+		// The top and the buckets are always directories.
+		return &fileInfo{name: path.Base(name), dir: true}, nil
+	}
+
+	response, err := fsys.do(http.MethodHead, fsys.objectURL(bucket, key, nil), nil)
+	if err == nil {
+		response.Body.Close()
+		size, _ := strconv.ParseInt(response.Header.Get("Content-Length"), 10, 64)
+		modTime, _ := http.ParseTime(response.Header.Get("Last-Modified"))
+		return &fileInfo{name: path.Base(key), size: size, modTime: modTime}, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	// No such object; a directory if any key has it as a prefix.
+	result, err := fsys.list(bucket, key+"/", "", 1)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if len(result.Contents) == 0 && len(result.CommonPrefixes) == 0 {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return &fileInfo{name: path.Base(key), dir: true}, nil
+}
+
+// The ListObjectsV2 response, the parts used here.
+type listResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+}
+
+// list requests one page of keys under a prefix, grouped by "/".
+func (fsys *FS) list(bucket string, prefix string, token string, maxKeys int) (*listResult, error) {
+	query := url.Values{
+		"list-type": {"2"},
+		"delimiter": {"/"},
+		"prefix":    {prefix},
+	}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+	if maxKeys > 0 {
+		query.Set("max-keys", strconv.Itoa(maxKeys))
+	}
+	response, err := fsys.do(http.MethodGet, fsys.objectURL(bucket, "", query), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	result := new(listResult)
+	if err = xml.NewDecoder(response.Body).Decode(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ReadDir reads the named directory, sorted by name.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	bucket, key := split(name)
+	if name == "." {
+		// Listing buckets needs account-wide permission; not supported.
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrPermission}
+	}
+	prefix := ""
+	if key != "" {
+		prefix = key + "/"
+	}
+
+	var entries []fs.DirEntry
+	token := ""
+	for {
+		result, err := fsys.list(bucket, prefix, token, 0)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+		}
+		for _, p := range result.CommonPrefixes {
+			entries = append(entries, &fileInfo{
+				name: strings.TrimSuffix(strings.TrimPrefix(p.Prefix, prefix), "/"),
+				dir:  true,
+			})
+		}
+		for _, c := range result.Contents {
+			if c.Key == prefix {
+				continue // A placeholder for the directory itself
+			}
+			entries = append(entries, &fileInfo{
+				name:    strings.TrimPrefix(c.Key, prefix),
+				size:    c.Size,
+				modTime: c.LastModified,
+			})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	if len(entries) == 0 && key != "" {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// fileInfo describes an object or prefix.  It serves as both
+// fs.FileInfo and fs.DirEntry.
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi *fileInfo) Name() string               { return fi.name }
+func (fi *fileInfo) Size() int64                { return fi.size }
+func (fi *fileInfo) ModTime() time.Time         { return fi.modTime }
+func (fi *fileInfo) IsDir() bool                { return fi.dir }
+func (fi *fileInfo) Sys() any                   { return nil }
+func (fi *fileInfo) Type() fs.FileMode          { return fi.Mode().Type() }
+func (fi *fileInfo) Info() (fs.FileInfo, error) { return fi, nil }
+
+func (fi *fileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// file is an open object, read with ranged GETs, or directory.
+type file struct {
+	fsys    *FS
+	name    string
+	info    *fileInfo
+	offset  int64         // For Read
+	entries []fs.DirEntry // Directory entries not yet returned
+	listed  bool          // Whether entries has been loaded
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *file) Close() error {
+	return nil
+}
+
+// ReadDir returns the next n entries of a directory, or all the
+// rest if n <= 0, as fs.ReadDirFile requires.
+func (f *file) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !f.info.dir {
+		return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: errors.New("not a directory")}
+	}
+	if !f.listed {
+		entries, err := f.fsys.ReadDir(f.name)
+		if err != nil {
+			return nil, err
+		}
+		f.entries, f.listed = entries, true
+	}
+	if n <= 0 || n > len(f.entries) {
+		if n > 0 && len(f.entries) == 0 {
+			return nil, io.EOF
+		}
+		n = len(f.entries)
+	}
+	entries := f.entries[:n]
+	f.entries = f.entries[n:]
+	return entries, nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+// ReadAt reads len(p) bytes at the offset in one ranged GET.
+// As io.ReaderAt requires, a short read gives an error: io.EOF
+// at the end of the object.
+func (f *file) ReadAt(p []byte, offset int64) (int, error) {
+	if f.info.dir {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: errors.New("is a directory")}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if offset >= f.info.size {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	end := offset + int64(len(p))
+	if end > f.info.size {
+		end = f.info.size
+	}
+	bucket, key := split(f.name)
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", offset, end-1)}}
+	response, err := f.fsys.do(http.MethodGet, f.fsys.objectURL(bucket, key, nil), header)
+	if err != nil {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusOK {
+		// The service ignored the range and sent the whole object.
+		if _, err = io.CopyN(io.Discard, response.Body, offset); err != nil {
+			return 0, &fs.PathError{Op: "read", Path: f.name, Err: err}
+		}
+	}
+	n, err := io.ReadFull(response.Body, p[:end-offset])
+	if err != nil {
+		return n, &fs.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	if end-offset < int64(len(p)) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -1,9 +1,9 @@
-package read
+package scan
 
 import (
+	"context"
+	"fmt"
 	"io"
-	"os"
-	"varlog/service/app"
 )
 
 // This code read a file backwards.  As a log file
@@ -29,32 +29,53 @@ import (
 //  3. Files can be any size, including zero. The code handles any
 //     size file, large or small.
 type chunkReader struct {
-	file       *os.File
+	ctx        context.Context
+	file       io.ReaderAt
 	fileLength int64
 	nextOffset int64
+	lastOffset int64
+	bytesRead  int64
 	chunkSize  int
 	lastError  error
 }
 
+// A ChangedError reports that the file changed under a read: it
+// held fewer bytes than its size when the read began, as after a
+// truncation by logrotate's copytruncate.  Lines already scanned are
+// sound, but no more can be trusted.
+type ChangedError struct {
+	Offset int64  // Where the read found the change
+	Reason string // What was found
+}
+
+func (e *ChangedError) Error() string {
+	return fmt.Sprintf("file changed during read, at offset %d: %s", e.Offset, e.Reason)
+}
+
+// Slicer gives a file's contents without copying, as a memory-mapped
+// file does.  The reverser slices chunks from files supporting it,
+// rather than reading them with ReadAt.  The slice is valid until the
+// file is closed and must not be modified.
+type Slicer interface {
+	Slice(offset int64, n int) ([]byte, error)
+}
+
 // Allocates a new chunkReader and initializes it for use.
 // The supplied file will be used for reading, one chunk
 // at a time, in reverse order through the file.  The caller
 // remains responsible for closing the file.
-// The properties give the chunk size the client plans to use.
+// The size gives the file length, and the chunk size gives the
+// read size the client plans to use.
 // Note the caller of the chunk reader
 // needs to supply a read buffer to hold chunk data.  That
 // buffer should conform to the actual size being used.
-// Returns the new chunkReader and an error.
-// Returns a nil chunkReader if an error occurs.
-func newChunkReader(p *app.Properties, file *os.File) (*chunkReader, error) {
+// Canceling the context stops reading before the next chunk.
+func newChunkReader(ctx context.Context, file io.ReaderAt, size int64, chunkSize int) *chunkReader {
 	c := new(chunkReader)
+	c.ctx = ctx
 	c.file = file
-	c.chunkSize = p.ChunkSize()
-	fileInfo, err := file.Stat()
-	if err != nil {
-		return nil, err
-	}
-	c.fileLength = fileInfo.Size()
+	c.chunkSize = chunkSize
+	c.fileLength = size
 
 	// Compute the offset of the first chunk to read.
 	switch {
@@ -72,7 +93,7 @@ func newChunkReader(p *app.Properties, file *os.File) (*chunkReader, error) {
 		// Let the first read get the last partial chunk.
 		c.nextOffset = c.fileLength - c.fileLength%int64(c.chunkSize)
 	}
-	return c, nil
+	return c
 }
 
 // peekEOF indicates whether the chunker has read the entire file,
@@ -89,6 +110,7 @@ func (c *chunkReader) peekEOF() bool {
 // be extended.
 // The return count is the number of bytes actually read.
 // A count of zero and error of EOF indicate end of file.
+// A canceled context gives the context's error.
 func (c *chunkReader) read(b []byte) (count int, err error) {
 	// Handle special cases first: Nothing to read or EOF.
 	// Note the code below sets nextOffset negative after
@@ -100,14 +122,30 @@ func (c *chunkReader) read(b []byte) (count int, err error) {
 	if c.lastError != nil {
 		return 0, c.lastError
 	}
+	// Stop if the caller has given up, as when a client disconnects.
+	if err = c.ctx.Err(); err != nil {
+		c.lastError = err
+		return 0, err
+	}
 	// Rely on the caller to set len(b) appropriately.
 	// When using ReadAt, we can request a full chunk and get
 	// the actual number of available bytes at the file's tail.
 	// No need to adjust the supplied slice length.
 	// When reading the tail chunk, ReadAt can return data and EOF.
 	// That EOF needs to be ignored, or the reader stops prematurely.
+	c.lastOffset = c.nextOffset
 	count, err = c.file.ReadAt(b, c.nextOffset)
-	if count > 0 && err == io.EOF {
+	c.bytesRead += int64(count)
+	// A chunk is short only at the end of the file.  Short elsewhere,
+	// or shorter than the tail, the file has shrunk since the start.
+	expected := len(b)
+	if remaining := c.fileLength - c.nextOffset; remaining < int64(expected) {
+		expected = int(remaining)
+	}
+	if (err == nil || err == io.EOF) && count < expected {
+		err = &ChangedError{c.nextOffset + int64(count),
+			fmt.Sprintf("read %d of %d bytes, file truncated", count, expected)}
+	} else if err == io.EOF {
 		err = nil
 	}
 	// Subtlety: Always back up the offset by the chunk size.
@@ -119,3 +157,29 @@ func (c *chunkReader) read(b []byte) (count int, err error) {
 	c.lastError = err
 	return count, c.lastError
 }
+
+// slice gives the next chunk, as read does, but sliced from the file
+// without copying.  n is the chunk size, constant for the reader.
+func (c *chunkReader) slice(s Slicer, n int) (b []byte, err error) {
+	if c.fileLength == 0 || c.nextOffset < 0 {
+		c.lastError = io.EOF
+		return nil, io.EOF
+	}
+	if c.lastError != nil {
+		return nil, c.lastError
+	}
+	if err = c.ctx.Err(); err != nil {
+		c.lastError = err
+		return nil, err
+	}
+	c.lastOffset = c.nextOffset
+	length := n
+	if remaining := c.fileLength - c.nextOffset; remaining < int64(n) {
+		length = int(remaining)
+	}
+	b, err = s.Slice(c.nextOffset, length)
+	c.bytesRead += int64(len(b))
+	c.nextOffset -= int64(n)
+	c.lastError = err
+	return b, c.lastError
+}
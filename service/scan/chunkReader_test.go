@@ -0,0 +1,87 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// Benchmark file and chunk sizes.  The larger files are skipped
+// with -short.
+var (
+	benchFileSizes  = []int64{1 << 10, 1 << 20, 64 << 20, 1 << 30}
+	benchChunkSizes = []int{4 << 10, 64 << 10, 1 << 20}
+)
+
+// repeatReader is a file of the size repeating the text, computed as
+// read so benchmarks need not hold large files in memory.
+type repeatReader struct {
+	text []byte
+	size int64
+}
+
+func newRepeatReader(size int64) *repeatReader {
+	line := "2023/02/17 10:28:24 aaaaa          1    INFO abcde fghij klmno pqrst uvwxy\n"
+	return &repeatReader{text: []byte(strings.Repeat(line, 64)), size: size}
+}
+
+func (r *repeatReader) ReadAt(p []byte, offset int64) (int, error) {
+	if offset >= r.size {
+		return 0, io.EOF
+	}
+	if remaining := r.size - offset; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n := 0
+	for n < len(p) {
+		n += copy(p[n:], r.text[(offset+int64(n))%int64(len(r.text)):])
+	}
+	return n, nil
+}
+
+// benchSizes runs the benchmark for each file and chunk size,
+// reporting throughput in file bytes.
+func benchSizes(b *testing.B, run func(b *testing.B, file *repeatReader, chunkSize int)) {
+	for _, size := range benchFileSizes {
+		if testing.Short() && size > 1<<20 {
+			continue
+		}
+		for _, chunkSize := range benchChunkSizes {
+			b.Run(fmt.Sprintf("file=%s/chunk=%s", byteSize(size), byteSize(int64(chunkSize))), func(b *testing.B) {
+				file := newRepeatReader(size)
+				b.SetBytes(size)
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					run(b, file, chunkSize)
+				}
+			})
+		}
+	}
+}
+
+func byteSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%dGB", n>>30)
+	case n >= 1<<20:
+		return fmt.Sprintf("%dMB", n>>20)
+	}
+	return fmt.Sprintf("%dKB", n>>10)
+}
+
+func BenchmarkChunkReader(b *testing.B) {
+	benchSizes(b, func(b *testing.B, file *repeatReader, chunkSize int) {
+		c := newChunkReader(context.Background(), file, file.size, chunkSize)
+		chunk := make([]byte, chunkSize)
+		for {
+			if _, err := c.read(chunk); err == io.EOF {
+				break
+			} else if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
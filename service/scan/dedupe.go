@@ -0,0 +1,58 @@
+package scan
+
+import (
+	"strings"
+)
+
+// A Deduper collapses runs of identical records, as uniq -c does for
+// lines.  A line alone is a one-line record.  Records arrive newest
+// first, so each run presents its most recent record.
+//
+// With IgnoreTimestamp, records differing only in the timestamp
+// starting their first line are identical, which collapses a service
+// repeating the same message every second.
+type Deduper struct {
+	IgnoreTimestamp bool
+
+	record []string // Most recent record of the current run
+	key    string   // Comparison key of the current run
+	count  int      // Records in the current run, 0 before the first
+}
+
+// Add accepts the next record (newest first).  When the record starts
+// a new run, Add returns the run just ended: its record and count.
+func (d *Deduper) Add(record []string) (run []string, count int, ok bool) {
+	key := d.keyOf(record)
+	if d.count > 0 && key == d.key {
+		d.count++
+		return nil, 0, false
+	}
+	run, count, ok = d.Flush()
+	d.record, d.key, d.count = record, key, 1
+	return run, count, ok
+}
+
+// Flush returns the current run, if any, and starts over.
+func (d *Deduper) Flush() (run []string, count int, ok bool) {
+	if d.count == 0 {
+		return nil, 0, false
+	}
+	run, count = d.record, d.count
+	d.record, d.key, d.count = nil, "", 0
+	return run, count, true
+}
+
+func (d *Deduper) keyOf(record []string) string {
+	if len(record) == 0 {
+		return ""
+	}
+	first := record[0]
+	if d.IgnoreTimestamp {
+		first = StripTimestamp(first)
+	}
+	if len(record) == 1 {
+		return first
+	}
+	// Lines hold no newlines, so joining keeps records distinct.
+	return first + "\n" + strings.Join(record[1:], "\n")
+}
@@ -0,0 +1,67 @@
+package scan
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestDeduper(t *testing.T) {
+	// Records as the reverser presents them: newest first.
+	records := [][]string{
+		{"12:00:03 down"},
+		{"12:00:02 down"},
+		{"12:00:01 down"},
+		{"12:00:00 up"},
+		{"11:59:59 down", "\tat x"},
+		{"11:59:58 down", "\tat x"},
+		{"11:59:57 down", "\tat y"},
+	}
+	strip := func(s string) string { return s[len("12:00:00 "):] }
+	var keys [][]string
+	for _, record := range records {
+		key := []string{strip(record[0])}
+		keys = append(keys, append(key, record[1:]...))
+	}
+
+	tests := []struct {
+		input    [][]string
+		expected []string
+	}{
+		{records, []string{"1 [12:00:03 down]", "1 [12:00:02 down]", "1 [12:00:01 down]", "1 [12:00:00 up]",
+			"1 [11:59:59 down \tat x]", "1 [11:59:58 down \tat x]", "1 [11:59:57 down \tat y]"}},
+		{keys, []string{"3 [down]", "1 [up]", "2 [down \tat x]", "1 [down \tat y]"}},
+		{nil, nil},
+	}
+	for _, test := range tests {
+		var d Deduper
+		var runs []string
+		for _, record := range test.input {
+			if run, count, ok := d.Add(record); ok {
+				runs = append(runs, fmt.Sprintf("%d %v", count, run))
+			}
+		}
+		if run, count, ok := d.Flush(); ok {
+			runs = append(runs, fmt.Sprintf("%d %v", count, run))
+		}
+		if !reflect.DeepEqual(runs, test.expected) {
+			t.Errorf("expected %q, got %q", test.expected, runs)
+		}
+	}
+
+	// Timestamps ignored, the newest record of each run is kept.
+	d := Deduper{IgnoreTimestamp: true}
+	var runs []string
+	for _, line := range []string{"2023/02/16 07:40:47 retry", "2023/02/16 07:40:46 retry", "2023/02/16 07:40:45 ok"} {
+		if run, count, ok := d.Add([]string{line}); ok {
+			runs = append(runs, fmt.Sprintf("%d %v", count, run))
+		}
+	}
+	if run, count, ok := d.Flush(); ok {
+		runs = append(runs, fmt.Sprintf("%d %v", count, run))
+	}
+	expected := []string{"2 [2023/02/16 07:40:47 retry]", "1 [2023/02/16 07:40:45 ok]"}
+	if !reflect.DeepEqual(runs, expected) {
+		t.Errorf("expected %q, got %q", expected, runs)
+	}
+}
@@ -0,0 +1,17 @@
+// Package scan provides the parsing and filtering core shared by the
+// service endpoints: reading a file backwards as lines, grouping
+// multi-line records, and matching filters.
+//
+// The package is pure Go.  It depends only on io, bufio, and similar
+// packages---not os, net, or the app package---so it compiles for
+// browsers and other sandboxes:
+//
+//	$ GOOS=js GOARCH=wasm go build ./service/scan
+//	$ GOOS=wasip1 GOARCH=wasm go build ./service/scan
+//
+// A web page can then preview local files with exactly the filter
+// semantics the service applies.  Files are supplied as io.ReaderAt
+// plus a size, which a browser-side caller can implement over a Blob.
+// Keep it that way: code needing the file system or the request's
+// properties belongs in the endpoint packages.
+package scan
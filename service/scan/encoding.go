@@ -0,0 +1,155 @@
+package scan
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Encodings of files, as named by the 'encoding' parameter.
+// Lines are converted to UTF-8.
+const (
+	EncodingLatin1      = "iso-8859-1"
+	EncodingUTF16BE     = "utf-16be"
+	EncodingUTF16LE     = "utf-16le"
+	EncodingUTF8        = "utf-8"
+	EncodingWindows1252 = "windows-1252"
+)
+
+// Byte order marks, which start files in some encodings, mostly
+// those written on Windows.
+var (
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16BE = []byte{0xfe, 0xff}
+	bomUTF16LE = []byte{0xff, 0xfe}
+)
+
+// Bytes outside UTF-8 needed before a file is taken to be in a
+// single-byte encoding.  One stray byte is damage, not an encoding.
+const minSingleByteEvidence = 4
+
+// DetectEncoding guesses the encoding of a file from its first bytes:
+// by the byte order mark, if any, or else UTF-8, unless the bytes
+// are not UTF-8 and read as Windows-1252 text.  Windows-1252 is
+// ISO 8859-1 with printable characters in place of most C1 controls,
+// so it covers both.  Gives the empty string if nothing suggests an
+// encoding other than UTF-8.
+func DetectEncoding(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, bomUTF8):
+		return EncodingUTF8
+	case bytes.HasPrefix(data, bomUTF16LE):
+		return EncodingUTF16LE
+	case bytes.HasPrefix(data, bomUTF16BE):
+		return EncodingUTF16BE
+	}
+	// The sample may end within a character.
+	if n := len(data); n > 0 {
+		i := n - 1
+		for i > 0 && i > n-utf8.UTFMax && !utf8.RuneStart(data[i]) {
+			i--
+		}
+		if !utf8.FullRune(data[i:]) {
+			data = data[:i]
+		}
+	}
+	if utf8.Valid(data) {
+		return ""
+	}
+	evidence := 0
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size == 1 {
+			if data[0] < 0xa0 && windows1252[data[0]-0x80] == utf8.RuneError {
+				return "" // Undefined in Windows-1252 too
+			}
+			evidence++
+		}
+		data = data[size:]
+	}
+	if evidence < minSingleByteEvidence {
+		return ""
+	}
+	return EncodingWindows1252
+}
+
+// DecodeLine converts a line in the encoding to UTF-8.  A UTF-8 line
+// loses any byte order mark; invalid bytes are left for Sanitize.
+// UTF-16 is converted by file, with DecodeUTF16, since its newlines
+// are two bytes, so lines are returned as they are.
+func DecodeLine(s string, encoding string) string {
+	switch encoding {
+	case EncodingLatin1:
+		return decodeSingleByte(s, func(b byte) rune { return rune(b) })
+	case EncodingWindows1252:
+		return decodeSingleByte(s, func(b byte) rune {
+			if b >= 0x80 && b < 0xa0 {
+				return windows1252[b-0x80]
+			}
+			return rune(b)
+		})
+	case EncodingUTF8:
+		return strings.TrimPrefix(s, "\ufeff")
+	}
+	return s
+}
+
+// decodeSingleByte converts each byte of s to the character given.
+func decodeSingleByte(s string, decode func(byte) rune) string {
+	ascii := true
+	for j := 0; j < len(s) && ascii; j++ {
+		ascii = s[j] < utf8.RuneSelf
+	}
+	if ascii {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s) + len(s)/2)
+	for j := 0; j < len(s); j++ {
+		b.WriteRune(decode(s[j]))
+	}
+	return b.String()
+}
+
+// DecodeUTF16 converts UTF-16 text, big- or little-endian, to UTF-8,
+// dropping any byte order mark.  Unpaired surrogates become U+FFFD,
+// as does an odd last byte.
+func DecodeUTF16(data []byte, bigEndian bool) []byte {
+	if bytes.HasPrefix(data, bomUTF16BE) && bigEndian || bytes.HasPrefix(data, bomUTF16LE) && !bigEndian {
+		data = data[2:]
+	}
+	unit := func(j int) rune {
+		if bigEndian {
+			return rune(data[j])<<8 | rune(data[j+1])
+		}
+		return rune(data[j+1])<<8 | rune(data[j])
+	}
+	out := make([]byte, 0, len(data)+len(data)/2)
+	j := 0
+	for ; j+1 < len(data); j += 2 {
+		r := unit(j)
+		if utf16.IsSurrogate(r) {
+			pair := utf8.RuneError
+			if j+3 < len(data) {
+				pair = utf16.DecodeRune(r, unit(j+2))
+			}
+			if pair != utf8.RuneError {
+				j += 2
+			}
+			r = pair
+		}
+		out = utf8.AppendRune(out, r)
+	}
+	if j < len(data) {
+		out = utf8.AppendRune(out, utf8.RuneError)
+	}
+	return out
+}
+
+// Windows-1252 characters for bytes 0x80 through 0x9f, U+FFFD for
+// the five undefined.  Other bytes are ISO 8859-1: the byte's value.
+var windows1252 = [32]rune{
+	'€', utf8.RuneError, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', utf8.RuneError, 'Ž', utf8.RuneError,
+	utf8.RuneError, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', utf8.RuneError, 'ž', 'Ÿ',
+}
@@ -0,0 +1,71 @@
+package scan
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetectEncoding(t *testing.T) {
+	tests := []struct {
+		data     string
+		expected string
+	}{
+		{"", ""},
+		{"plain ascii\n", ""},
+		{"café naïve résumé\n", ""},
+		{"\xef\xbb\xbfwith a bom\n", EncodingUTF8},
+		{"\xff\xfea\x00\n\x00", EncodingUTF16LE},
+		{"\xfe\xff\x00a\x00\n", EncodingUTF16BE},
+		{"caf\xe9 na\xefve r\xe9sum\xe9\n", EncodingWindows1252},
+		{"\x93quoted\x94 \x96 dash \x85\n", EncodingWindows1252},
+		{"one stray \xff byte\n", ""},
+		{"undefined \x81 \xe9\xe9\xe9\xe9\n", ""},
+		// A sample cut within a character is still UTF-8.
+		{strings.Repeat("é", 10)[:19], ""},
+	}
+	for _, test := range tests {
+		if got := DetectEncoding([]byte(test.data)); got != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.data, test.expected, got)
+		}
+	}
+}
+
+func TestDecodeLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		encoding string
+		expected string
+	}{
+		{"caf\xe9", EncodingLatin1, "café"},
+		{"\x80 \x93hi\x94", EncodingWindows1252, "€ “hi”"},
+		{"\x80", EncodingLatin1, "\u0080"},
+		{"\ufeffstart", EncodingUTF8, "start"},
+		{"ascii", EncodingWindows1252, "ascii"},
+		{"caf\xe9", "", "caf\xe9"},
+	}
+	for _, test := range tests {
+		if got := DecodeLine(test.line, test.encoding); got != test.expected {
+			t.Errorf("%q as %s: expected %q, got %q", test.line, test.encoding, test.expected, got)
+		}
+	}
+}
+
+func TestDecodeUTF16(t *testing.T) {
+	tests := []struct {
+		data      string
+		bigEndian bool
+		expected  string
+	}{
+		{"\xff\xfea\x00\xe9\x00\n\x00", false, "aé\n"},
+		{"\xfe\xff\x00a\x00\xe9\x00\n", true, "aé\n"},
+		{"=\xd8\x00\xde", false, "\U0001f600"}, // A surrogate pair
+		{"=\xd8a\x00", false, "\ufffda"},       // Unpaired
+		{"a\x00b", false, "a\ufffd"},           // Odd length
+		{"", false, ""},
+	}
+	for _, test := range tests {
+		if got := string(DecodeUTF16([]byte(test.data), test.bigEndian)); got != test.expected {
+			t.Errorf("%q, big-endian %v: expected %q, got %q", test.data, test.bigEndian, test.expected, got)
+		}
+	}
+}
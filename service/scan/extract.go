@@ -0,0 +1,138 @@
+package scan
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// An Extractor selects parts of each line, so a response can carry
+// just the column of interest, such as an access log's latency.
+// The zero Extractor passes lines whole.
+//
+// Fields select by position, as cut and awk do:
+//
+//	fields:4          4th whitespace-separated field
+//	fields:1,4-5      1st, 4th, and 5th fields, in that order
+//	fields:3-:,       3rd and later comma-separated fields
+//
+// A regular expression selects its capture groups, or the whole match
+// without groups:
+//
+//	regex:took (\d+)ms
+type Extractor struct {
+	fields    []fieldRange   // Fields in output order, nil for a regex
+	delimiter string         // Field delimiter, empty for whitespace runs
+	regex     *regexp.Regexp // Pattern, nil for fields
+}
+
+// fieldRange selects fields first through last, 1-based.
+// A last of 0 runs through the end of the line.
+type fieldRange struct {
+	first, last int
+}
+
+// ParseExtract parses an 'extract' parameter value, "fields:LIST" or
+// "fields:LIST:DELIMITER" or "regex:PATTERN".  An empty value gives
+// the zero Extractor.
+func ParseExtract(value string) (Extractor, error) {
+	var e Extractor
+	kind, spec, _ := strings.Cut(value, ":")
+	switch kind {
+	case "":
+		return e, nil
+
+	case "fields":
+		list, delimiter, _ := strings.Cut(spec, ":")
+		e.delimiter = delimiter
+		for _, item := range strings.Split(list, ",") {
+			r, err := parseFieldRange(item)
+			if err != nil {
+				return Extractor{}, err
+			}
+			e.fields = append(e.fields, r)
+		}
+		return e, nil
+
+	case "regex":
+		regex, err := regexp.Compile(spec)
+		if err != nil {
+			return Extractor{}, err
+		}
+		e.regex = regex
+		return e, nil
+	}
+	return Extractor{}, errors.New(fmt.Sprintf("expected fields:LIST or regex:PATTERN, got %q", value))
+}
+
+// parseFieldRange parses "N", "N-M", or "N-".
+func parseFieldRange(item string) (fieldRange, error) {
+	first, last, isRange := strings.Cut(item, "-")
+	var r fieldRange
+	var err error
+	if r.first, err = strconv.Atoi(first); err != nil || r.first < 1 {
+		return r, errors.New(fmt.Sprintf("invalid field %q", item))
+	}
+	switch {
+	case !isRange:
+		r.last = r.first
+	case last == "":
+		r.last = 0
+	default:
+		if r.last, err = strconv.Atoi(last); err != nil || r.last < r.first {
+			return r, errors.New(fmt.Sprintf("invalid field range %q", item))
+		}
+	}
+	return r, nil
+}
+
+// Active reports whether the extractor selects anything less than
+// whole lines.
+func (e *Extractor) Active() bool {
+	return e.fields != nil || e.regex != nil
+}
+
+// Extract gives the selected parts of the line, joined by the field
+// delimiter, or by a space for whitespace fields and regex groups.
+// Returns false when the line has none of the selected fields, or the
+// regex does not match, so the caller can omit the line.
+func (e *Extractor) Extract(s string) (string, bool) {
+	switch {
+	case e.regex != nil:
+		match := e.regex.FindStringSubmatch(s)
+		if match == nil {
+			return "", false
+		}
+		if len(match) == 1 {
+			return match[0], true
+		}
+		return strings.Join(match[1:], " "), true
+
+	case e.fields != nil:
+		var fields []string
+		separator := e.delimiter
+		if separator == "" {
+			fields = strings.Fields(s)
+			separator = " "
+		} else {
+			fields = strings.Split(s, separator)
+		}
+		var selected []string
+		for _, r := range e.fields {
+			last := r.last
+			if last == 0 || last > len(fields) {
+				last = len(fields)
+			}
+			for i := r.first; i <= last; i++ {
+				selected = append(selected, fields[i-1])
+			}
+		}
+		if selected == nil {
+			return "", false
+		}
+		return strings.Join(selected, separator), true
+	}
+	return s, true
+}
@@ -0,0 +1,50 @@
+package scan
+
+import (
+	"testing"
+)
+
+func TestExtractor(t *testing.T) {
+	const access = `10.0.0.1 - - [16/Feb/2023:07:40:46 +0000] "GET /a HTTP/1.1" 200 512 0.042`
+	tests := []struct {
+		value    string
+		line     string
+		expected string
+		ok       bool
+	}{
+		{"", access, access, true},
+		{"fields:9", access, "200", true},
+		{"fields:11,9", access, "0.042 200", true},
+		{"fields:9-10", access, "200 512", true},
+		{"fields:11-", access, "0.042", true},
+		{"fields:12", "a b", "", false},
+		{"fields:2,5", "a b", "b", true},
+		{"fields:2:,", "a,b,,c", "b", true},
+		{"fields:3-:,", "a,b,,c", ",c", true},
+		{"fields:2::", "k:v:w", "v", true},
+		{"fields:2:, ", "a, b, c", "b", true},
+		{"regex:took (\\d+)ms", "request took 42ms", "42", true},
+		{"regex:(\\w+)=(\\d+)", "retries=3", "retries 3", true},
+		{"regex:\\d+ms", "request took 42ms", "42ms", true},
+		{"regex:took (\\d+)ms", "request failed", "", false},
+		{"regex:a:b", "xa:b", "a:b", true},
+	}
+	for _, test := range tests {
+		e, err := ParseExtract(test.value)
+		if err != nil {
+			t.Errorf("ParseExtract(%q): unexpected error %v", test.value, err)
+			continue
+		}
+		got, ok := e.Extract(test.line)
+		if got != test.expected || ok != test.ok {
+			t.Errorf("%q on %q: expected (%q, %v), got (%q, %v)",
+				test.value, test.line, test.expected, test.ok, got, ok)
+		}
+	}
+
+	for _, value := range []string{"fields", "fields:", "fields:0", "fields:x", "fields:3-2", "fields:1,,2", "regex:(", "cut:1"} {
+		if _, err := ParseExtract(value); err == nil {
+			t.Errorf("ParseExtract(%q): expected error", value)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package scan
+
+import (
+	"strings"
+)
+
+const (
+	// Values for Filter.Anchor.  The empty string (the default)
+	// matches the filter text anywhere in the line.
+	AnchorEnd   = "end"   // Filter text must end the line
+	AnchorStart = "start" // Filter text must start the line
+	AnchorWhole = "whole" // Filter text must be the whole line
+)
+
+// Filter selects lines (or entry names) by exact text matching.
+// No regular expressions are involved.  The zero Filter allows everything.
+type Filter struct {
+	Text   string // Text to match.  Empty allows all lines.
+	Omit   bool   // True to drop matching lines rather than keep them
+	Anchor string // Where the text must match: AnchorStart, etc.
+}
+
+// ParseFilter splits a 'filter' parameter value into its text and
+// omit flag.  A leading '-' makes a negative filter: "-text" omits lines
+// with "text".  A leading backslash before '-' or another backslash
+// escapes it, allowing searches for text that itself begins with one.
+// Any other backslash is literal text:
+//
+//	filter=\-foo    matches lines containing "-foo"
+//	filter=\\foo    matches lines containing "\foo"
+//	filter=-\-foo   omits lines containing "-foo"
+//	filter=\foo     matches lines containing "\foo"
+func ParseFilter(value string) (text string, omit bool) {
+	if len(value) > 0 && value[0] == '-' {
+		omit = true
+		value = value[1:]
+	}
+	if len(value) > 1 && value[0] == '\\' && (value[1] == '-' || value[1] == '\\') {
+		value = value[1:]
+	}
+	return value, omit
+}
+
+// Allows reports whether the filter passes the given line.
+func (f *Filter) Allows(s string) bool {
+	// An empty filter allows all entries
+	if f.Text == "" {
+		return true
+	}
+	if f.Matches(s) {
+		return !f.Omit
+	}
+	// Filter text is non-empty and did not match.
+	return f.Omit
+}
+
+// AllowsRecord applies the filter to a multi-line record as a whole,
+// deciding on its first line: a stack trace is kept when its first
+// line matches, whatever its frames contain, and a negative filter
+// drops the record only for text in its first line.
+func (f *Filter) AllowsRecord(lines []string) bool {
+	if len(lines) == 0 {
+		return f.Allows("")
+	}
+	return f.Allows(lines[0])
+}
+
+// Matches reports whether the filter text appears in the given string,
+// honoring the anchor.  The omit flag is not considered.
+func (f *Filter) Matches(s string) bool {
+	switch f.Anchor {
+	case AnchorStart:
+		return strings.HasPrefix(s, f.Text)
+	case AnchorEnd:
+		return strings.HasSuffix(s, f.Text)
+	case AnchorWhole:
+		return s == f.Text
+	default:
+		return strings.Contains(s, f.Text)
+	}
+}
+
+// Filters combines filters, as repeated 'filter' parameters do, like
+// grep X | grep -v Y.  A line passes if it matches every positive
+// filter and no negative one, so a negative filter wins when both
+// match.  The empty Filters allows everything.
+type Filters []Filter
+
+// Allows reports whether the filters pass the given line.
+func (fs Filters) Allows(s string) bool {
+	for j := range fs {
+		if !fs[j].Allows(s) {
+			return false
+		}
+	}
+	return true
+}
+
+// AllowsRecord applies the filters to a multi-line record as a whole,
+// deciding on its first line.  See Filter.AllowsRecord.
+func (fs Filters) AllowsRecord(lines []string) bool {
+	for j := range fs {
+		if !fs[j].AllowsRecord(lines) {
+			return false
+		}
+	}
+	return true
+}
+
+// Empty reports whether the filters allow everything, having no text.
+func (fs Filters) Empty() bool {
+	for _, f := range fs {
+		if f.Text != "" {
+			return false
+		}
+	}
+	return true
+}
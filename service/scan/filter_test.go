@@ -0,0 +1,90 @@
+package scan
+
+import (
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		value string
+		text  string
+		omit  bool
+	}{
+		{"", "", false},
+		{"abc", "abc", false},
+		{"-abc", "abc", true},
+		{"-", "", true},
+		{`\-abc`, "-abc", false},
+		{`-\-abc`, "-abc", true},
+		{`\\abc`, `\abc`, false},
+		{`\abc`, `\abc`, false},
+		{`-\abc`, `\abc`, true},
+		{`-\\abc`, `\abc`, true},
+		{`\`, `\`, false},
+		{`\\`, `\`, false},
+		{`\-`, "-", false},
+		{`a\-b`, `a\-b`, false},
+	}
+	for _, test := range tests {
+		text, omit := ParseFilter(test.value)
+		if text != test.text || omit != test.omit {
+			t.Errorf("ParseFilter(%q): expected (%q, %v), got (%q, %v)",
+				test.value, test.text, test.omit, text, omit)
+		}
+	}
+}
+
+func TestFilters(t *testing.T) {
+	filters := Filters{{Text: "ERROR"}, {Text: "db"}, {Text: "healthcheck", Omit: true}}
+	tests := []struct {
+		line    string
+		allowed bool
+	}{
+		{"ERROR db timeout", true},
+		{"ERROR cache miss", false},
+		{"INFO db ok", false},
+		{"ERROR db healthcheck failed", false}, // The negative filter wins
+	}
+	for _, test := range tests {
+		if allowed := filters.Allows(test.line); allowed != test.allowed {
+			t.Errorf("%q: expected %v, got %v", test.line, test.allowed, allowed)
+		}
+	}
+	// A record's first line decides, whatever the continuation lines hold.
+	records := []struct {
+		lines   []string
+		allowed bool
+	}{
+		{[]string{"ERROR db failed", "  at db.Query"}, true},
+		{[]string{"ERROR db failed", "  at healthcheck.Run"}, true},
+		{[]string{"ERROR query failed", "  at db.Query"}, false},
+		{[]string{"ERROR db healthcheck failed", "  at db.Query"}, false},
+	}
+	for _, test := range records {
+		if allowed := filters.AllowsRecord(test.lines); allowed != test.allowed {
+			t.Errorf("%q: expected %v, got %v", test.lines, test.allowed, allowed)
+		}
+	}
+	if !(Filters{}).Allows("x") || !(Filters{}).Empty() || !(Filters{{Omit: true}}).Empty() || filters.Empty() {
+		t.Errorf("expected empty filters to allow everything")
+	}
+}
+
+func TestFilter_allowsRecord(t *testing.T) {
+	trace := []string{"ERROR request failed", "  at db.Query", "  at main.run"}
+	tests := []struct {
+		filter  Filter
+		allowed bool
+	}{
+		{Filter{Text: "ERROR"}, true},
+		{Filter{Text: "db.Query"}, false}, // A frame alone does not keep the trace
+		{Filter{Text: "ERROR", Omit: true}, false},
+		{Filter{Text: "db.Query", Omit: true}, true}, // Nor does it drop it
+		{Filter{}, true},
+	}
+	for _, test := range tests {
+		if allowed := test.filter.AllowsRecord(trace); allowed != test.allowed {
+			t.Errorf("%+v: expected %v, got %v", test.filter, test.allowed, allowed)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Read-ahead overlaps reading with the caller's work.  Without it,
+// the reverser reads a chunk and splits it into lines, and the caller
+// filters and writes them, before the next read begins.  With it, a
+// goroutine reads and splits chunks in the background, a few chunks
+// ahead, so disk reads overlap filtering and network writes.
+//
+// The goroutine owns the reverser's reading state.  Scan receives
+// the goroutine's batches, one per chunk, and Lines, Err, BytesRead,
+// and Offset describe the batch received.
+
+// A batch holds one chunk's lines, newest first, or the error that
+// ended the scan.
+type batch struct {
+	lines     []string
+	offset    int64 // Of the chunk
+	bytesRead int64 // Through the chunk
+	err       error
+}
+
+// readAhead is the consumer's side of the goroutine.
+type readAhead struct {
+	depth   int
+	started bool
+	batches chan batch
+	cancel  context.CancelFunc
+	current batch
+}
+
+// ReadAhead makes the reverser read and split up to depth chunks
+// ahead, in a background goroutine.  Call it before the first Scan.
+// A depth below 1 leaves the reverser reading as Scan is called.
+// The caller must Close the reverser, or cancel its context, to stop
+// the goroutine when not scanning to the end.
+func (r *Reverser) ReadAhead(depth int) {
+	if depth < 1 || r.started || r.ahead != nil {
+		return
+	}
+	r.ahead = &readAhead{depth: depth}
+}
+
+// Close stops any read-ahead, waiting for the goroutine to finish with
+// the file.  Call it before closing the file.  Without read-ahead,
+// Close does nothing.
+func (r *Reverser) Close() {
+	a := r.ahead
+	if a == nil || !a.started {
+		return
+	}
+	a.cancel()
+	for range a.batches {
+		// Drain until the goroutine closes the channel.
+	}
+}
+
+// scan receives the next batch, starting the goroutine at first.
+func (a *readAhead) scan(r *Reverser) bool {
+	if !a.started {
+		a.started = true
+		var ctx context.Context
+		ctx, a.cancel = context.WithCancel(r.chunker.ctx)
+		r.chunker.ctx = ctx
+		a.batches = make(chan batch, a.depth)
+		go a.run(ctx, r)
+	}
+	if a.current.err != nil {
+		return false
+	}
+	b, ok := <-a.batches
+	if !ok {
+		// Closed without an error batch only when canceled.
+		if b.err = r.chunker.ctx.Err(); b.err == nil {
+			b.err = context.Canceled
+		}
+	}
+	a.current = b
+	return b.err == nil
+}
+
+// run reads the chunks, sending a batch for each, and an error batch
+// at the end (io.EOF when the file is done).
+func (a *readAhead) run(ctx context.Context, r *Reverser) {
+	defer close(a.batches)
+	send := func(b batch) bool {
+		select {
+		case a.batches <- b:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	err := a.readChunks(r, send)
+	send(batch{offset: r.chunker.lastOffset, bytesRead: r.chunker.bytesRead, err: err})
+}
+
+// readChunks sends the batches, giving the error that ended reading.
+// A fault reading a mapped file (Slicer), as when the file is truncated
+// during the read, becomes the error rather than crashing the process.
+func (a *readAhead) readChunks(r *Reverser, send func(batch) bool) (err error) {
+	if _, ok := r.chunker.file.(Slicer); ok {
+		defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+		defer func() {
+			if e := recover(); e != nil {
+				fault, ok := e.(runtime.Error)
+				if !ok {
+					panic(e)
+				}
+				err = &ChangedError{r.chunker.lastOffset, fmt.Sprintf("mapped file fault: %s", fault)}
+			}
+		}()
+	}
+	for r.scanChunk() {
+		b := batch{lines: r.parseLines(), offset: r.chunker.lastOffset, bytesRead: r.chunker.bytesRead}
+		if r.lastError != nil {
+			// A line too long for the scanner: the lines parsed
+			// come first, then the error.
+			send(b)
+			return r.lastError
+		}
+		if !send(b) {
+			return context.Canceled
+		}
+	}
+	return r.lastError
+}
@@ -0,0 +1,50 @@
+package scan
+
+// A record is a line plus any continuation lines that follow it.
+// Continuation lines start with a space or a tab, as is typical for
+// stack traces and other multi-line log messages:
+//
+//	2023/02/16 07:40:46 ERROR request failed
+//		at com.example.Handler.run(Handler.java:42)
+//		at java.lang.Thread.run(Thread.java:750)
+//
+// The reverser presents lines newest first, so continuation lines arrive
+// before the line that starts their record.  The grouper holds them
+// until that first line appears, then presents the record in file order.
+type RecordGrouper struct {
+	pending []string // Continuation lines, newest first
+}
+
+// IsContinuation reports whether a line continues the record before it.
+func IsContinuation(s string) bool {
+	return len(s) > 0 && (s[0] == ' ' || s[0] == '\t')
+}
+
+// Add accepts the next line (newest first).  Returns a complete record,
+// lines in file order, and true when the line completes one.
+func (g *RecordGrouper) Add(line string) (record []string, ok bool) {
+	if IsContinuation(line) {
+		g.pending = append(g.pending, line)
+		return nil, false
+	}
+	record = make([]string, 0, len(g.pending)+1)
+	record = append(record, line)
+	for i := len(g.pending) - 1; i >= 0; i-- {
+		record = append(record, g.pending[i])
+	}
+	g.pending = g.pending[:0]
+	return record, true
+}
+
+// Flush returns continuation lines at the start of the file, which have
+// no first line of their own, as a final record.
+func (g *RecordGrouper) Flush() (record []string, ok bool) {
+	if len(g.pending) == 0 {
+		return nil, false
+	}
+	for i := len(g.pending) - 1; i >= 0; i-- {
+		record = append(record, g.pending[i])
+	}
+	g.pending = g.pending[:0]
+	return record, true
+}
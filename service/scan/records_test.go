@@ -0,0 +1,41 @@
+package scan
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRecordGrouper(t *testing.T) {
+	// Lines as the reverser presents them: newest first.
+	lines := []string{
+		"\tat three",
+		"l3 ERROR y",
+		"l2 INFO",
+		"\tat two",
+		"\tat one",
+		"l1 ERROR x",
+		" orphan",
+	}
+	expected := [][]string{
+		{"l3 ERROR y", "\tat three"},
+		{"l2 INFO"},
+		{"l1 ERROR x", "\tat one", "\tat two"},
+		{" orphan"},
+	}
+	var g RecordGrouper
+	var records [][]string
+	for _, s := range lines {
+		if record, ok := g.Add(s); ok {
+			records = append(records, record)
+		}
+	}
+	if record, ok := g.Flush(); ok {
+		records = append(records, record)
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("expected records %q, got %q", expected, records)
+	}
+	if _, ok := g.Flush(); ok {
+		t.Errorf("expected empty flush after flush")
+	}
+}
@@ -0,0 +1,317 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+)
+
+const (
+	// A "guess" at a sensible capacity for the slice used to hold
+	// lines from a single file chunk.  This should be adjusted if
+	// the value causes considerable internal slice reallocation.
+	// Related to the chunk size: bigger chunks would have more lines
+	// and warrant a larger initial value here.
+	initialLineCapacity = 1000
+)
+
+// Reverser presents a file backwards, parsed as lines.
+// As a log file accumulates lines, the most recent appear at the end.
+// When viewing lines for diagnostics, the desire is to
+// see the most recent lines first.  This code reads
+// a log file "backwards", splits each chunk into lines,
+// and then reverses the lines for presentation.
+//
+// This process has three coordinating activites: reading
+// the file in reverse chunks, splitting those chunks into
+// lines, reversing the order of the lines, one chunk
+// at a time.  By reading the file backwards, each set of
+// extracted lines can be reversed, giving the desired order.
+//
+// Some edge cases and other considerations.
+//
+// File reading:  See chunkReader.go.
+//
+// Line scanning.
+//  1. We assume only two conditions about files. A) The first
+//     line in a file starts at position 0. B) The last line
+//     in a file ends at the last position (terminal newline
+//     is optional).
+//  2. Lines cannot be assumed to align on chunk boundaries.
+//     Consequently, the first text in each chunk might have
+//     a prefix in the preceding chunk, which will not have been
+//     read yet.
+//  3. The possibility of a continuation condition for a chunk's
+//     first line itself has some edge cases. Details below.
+//  4. File formats are not constrained. Lines might be short or
+//     long; the code should present what it finds. This uses
+//     bufio for scanning, which imposes bufio.MaxTokenScanSize
+//     as the maximum token (line) size.  With SetMaxLine, lines
+//     are split directly instead, and cut to size, so no line is
+//     too long.
+type Reverser struct {
+	chunkSize  int          // Bytes per file read
+	maxLine    int          // Bytes kept of a line, 0 for all, see SetMaxLine
+	chunker    *chunkReader // Reads file chunks in reverse order
+	chunk      []byte       // Bytes read for processing
+	lastError  error        // The last error encountered
+	lineSuffix []byte       // Handles cross-chunk line splits.  Details below
+	started    bool         // Whether Scan has read a chunk
+
+	// With read-ahead, see readahead.go.  The fields above then
+	// belong to the background goroutine.
+	ahead *readAhead
+}
+
+/* Notes about cross-chunk line handling.
+ * Chunks are read in reverse order.  This uses numbering for clarity,
+ * where chunks appear in natural increasing order: n-1, n, n+1, etc.
+ * The chunk reader presents the chunks in order n, n-1, n-2, etc.
+ * The first line of chunk n might be a continuation of the last
+ * line of chunk n-1.  That potential suffix text from chunk n has
+ * edge cases that must be handled.
+ * a) The last line in n-1 has a newline in the last byte.  Thus the
+ *		last line and the suffix lines represent two lines, not one.
+ * b) The first byte of chunk n is a newline. The bufio scanner presents
+ *		this as an empty line. This causes ambiguity when reading n-1.
+ *		If n-1's last byte is a newline, this condition should give
+ *		two lines, not one.
+ *		Long story short, an empty suffix must append a newline, not the
+ *		empty string from bufio.
+ * c) A line may end in "\r\n", with the "\r" in chunk n-1 or n.  Lines
+ *		are scanned with their carriage returns, and one is dropped only
+ *		once a line is complete, so the suffix is never trimmed twice.
+ *
+ * Summary for handling block n.
+ * - If the first line is empty, use "\n" as the suffix.
+ * - If the first line is not empty, use it unmodifed as the suffix.
+ *
+ * Save the resulting suffix for processing chunk n-1. Append the suffix to
+ * the n-1 chunk and hand that to bufio for line scanning.
+ */
+
+// NewReverser allocates a new object and initializes it to read
+// the supplied file, whose length is size bytes, in chunks of
+// chunkSize bytes. Note the reverser uses a chunkReader for low-level
+// input. This reads the file backwards with io.ReaderAt, which is not
+// available from a simple Reader interface.  The caller remains
+// responsible for closing the file.
+// Canceling the context stops the scan: Scan returns false and
+// Err gives the context's error.  Cancellation takes effect between
+// chunks, so a scan stops within one chunk read.
+func NewReverser(ctx context.Context, file io.ReaderAt, size int64, chunkSize int) *Reverser {
+	r := new(Reverser)
+	r.chunkSize = chunkSize
+	r.chunker = newChunkReader(ctx, file, size, chunkSize)
+	return r
+}
+
+// SetMaxLine bounds the bytes kept of each line, so a long line, or
+// a file without newlines, neither ends the scan nor fills memory.
+// Lines longer than n bytes are cut to n+1 bytes: enough for the
+// caller to tell they were long, and to mark, drop, or refuse them.
+// Zero, the default, keeps whole lines.  Call it before the first Scan.
+func (r *Reverser) SetMaxLine(n int) {
+	r.maxLine = n
+}
+
+// Returns the most recent error for the reverser,
+// nil if no error has occurred.  Note that internal io.EOF is a
+// normal condition and presents as nil externally.  The scanner
+// simply stops in that situation.
+func (r *Reverser) Err() error {
+	var err error
+	if r.ahead != nil {
+		err = r.ahead.current.err
+	} else {
+		err = r.lastError
+	}
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// BytesRead gives the number of file bytes read so far.
+// With read-ahead, this counts the chunks Scan has presented.
+func (r *Reverser) BytesRead() int64 {
+	if r.ahead != nil {
+		return r.ahead.current.bytesRead
+	}
+	return r.chunker.bytesRead
+}
+
+// Offset gives the file offset of the chunk most recently read,
+// which locates the data behind any error.  Before the first read,
+// this is the offset of the first chunk to be read.
+func (r *Reverser) Offset() int64 {
+	if r.ahead != nil && r.ahead.started {
+		return r.ahead.current.offset
+	}
+	if !r.started {
+		return r.chunker.nextOffset
+	}
+	return r.chunker.lastOffset
+}
+
+// Lines extracts lines from the last chunk read from the file,
+// presenting them newest first.
+func (r *Reverser) Lines() []string {
+	if r.ahead != nil {
+		return r.ahead.current.lines
+	}
+	return r.parseLines()
+}
+
+// parseLines splits the chunk into lines, newest first, saving the
+// first line as the suffix for the next chunk.
+func (r *Reverser) parseLines() []string {
+	var lines []string
+	var buffer *bytes.Buffer
+
+	// Allocate a slice for the lines in the chunk.  It starts
+	// with length zero but capacity to grow with low startup cost.
+	lines = make([]string, 0, initialLineCapacity)
+
+	// Create a bytes.Buffer to hold the chunk data read from
+	// the file.  This gives a convenient way to let bufio
+	// parse the lines from the chunk.
+	if r.maxLine > 0 {
+		// Keep a carriage return past the limit, so a line cut
+		// in the suffix is still long once the return is dropped.
+		lines = splitLines(r.chunk, r.maxLine+2, lines)
+	} else {
+		buffer = bytes.NewBuffer(r.chunk)
+		scanner := bufio.NewScanner(buffer)
+		scanner.Split(scanRawLines)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		// The scanner "should" not raise an error, but it does if the data
+		// are not lines (token too long).  For purposes here, just take the
+		// lines provided (maybe nothing) and record the error to stop this file.
+		// The caller sees the error through Err().
+		if err := scanner.Err(); err != nil {
+			r.lastError = err
+		}
+	}
+	r.saveLineSuffix(&lines)
+
+	// The remaining lines are complete, so drop a carriage return
+	// ending any of them, as bufio.ScanLines does.  The suffix keeps
+	// its own until its line is complete; dropping one there too
+	// would lose a second carriage return in "text\r\r\n".
+	for i, s := range lines {
+		lines[i] = strings.TrimSuffix(s, "\r")
+		if r.maxLine > 0 && len(lines[i]) > r.maxLine+1 {
+			lines[i] = lines[i][:r.maxLine+1]
+		}
+	}
+
+	// Reverse the lines
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines
+}
+
+func (r *Reverser) saveLineSuffix(lines *[]string) {
+	// If the chunker is done, leave lines[0] alone.
+	if r.chunker.peekEOF() {
+		r.lineSuffix = []byte{}
+		return
+	}
+	// Save the first line as the suffix for the next chunk.
+	// The entries in lines are new strings from scanner.Text()
+	// and safe to use later.  Copy unnecessary.
+	if len(*lines) == 0 {
+		r.lineSuffix = []byte{}
+	} else {
+		s := (*lines)[0]
+		if s == "" {
+			s = "\n"
+		}
+		r.lineSuffix = []byte(s)
+		(*lines) = (*lines)[1:]
+	}
+}
+
+// scanRawLines splits lines as bufio.ScanLines does, except that
+// carriage returns are kept: a line split across chunks may continue
+// in the next chunk scanned.
+func scanRawLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, data[0:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// splitLines splits lines as scanRawLines does, appending them to
+// lines, with each cut to at most keep bytes.  The bytes kept are the
+// start of the line, the part read last when reading backwards, so a
+// line's suffix across chunks stays within keep bytes.
+func splitLines(data []byte, keep int, lines []string) []string {
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			data = nil
+		}
+		if len(line) > keep {
+			line = line[:keep]
+		}
+		lines = append(lines, string(line))
+	}
+	return lines
+}
+
+// Scan advances the reverser to the next chunk of the file being read,
+// which will then be available through Lines().
+// Returns false when the scan should stop, either exhausting the data
+// or finding an error.  When Scan() returns false, the caller can use
+// Err() to retrieve the final error (nil on io.EOF).
+// Returns true if the reverser has data for the caller
+// to process---and by implication should continue calling Scan().
+func (r *Reverser) Scan() bool {
+	if r.ahead != nil {
+		return r.ahead.scan(r)
+	}
+	return r.scanChunk()
+}
+
+// scanChunk reads the next chunk, appending the line suffix.
+func (r *Reverser) scanChunk() bool {
+	var n int
+	if r.lastError != nil {
+		return false
+	}
+
+	// Make a chunk buffer for the low-level chunker to use.
+	// After the file has been read into the buffer, we append
+	// the reserved line suffix for split-line handling.  That
+	// aggregate buffer is then used for parsing into lines.
+	r.started = true
+	if slicer, ok := r.chunker.file.(Slicer); ok {
+		// The chunk is the file's own memory.  Capping its capacity
+		// makes the append copy it rather than write into the file.
+		r.chunk, r.lastError = r.chunker.slice(slicer, r.chunkSize)
+		r.chunk = r.chunk[:len(r.chunk):len(r.chunk)]
+	} else {
+		r.chunk = make([]byte, r.chunkSize, r.chunkSize+len(r.lineSuffix))
+		n, r.lastError = r.chunker.read(r.chunk)
+		r.chunk = r.chunk[0:n]
+	}
+	if len(r.lineSuffix) > 0 {
+		r.chunk = append(r.chunk, r.lineSuffix...)
+	}
+	return r.lastError == nil
+}
@@ -0,0 +1,351 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReverser_canceled(t *testing.T) {
+	text := strings.Repeat("line\n", 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	r := NewReverser(ctx, strings.NewReader(text), int64(len(text)), 16)
+	if !r.Scan() {
+		t.Fatalf("expected first chunk, got error %v", r.Err())
+	}
+	cancel()
+	if r.Scan() {
+		t.Errorf("expected Scan to stop after cancel")
+	}
+	if r.Err() != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, r.Err())
+	}
+}
+
+// shrinkingReader is a file truncated to size after its first read,
+// as by logrotate's copytruncate during a read.
+type shrinkingReader struct {
+	data  []byte
+	size  int
+	reads int
+}
+
+func (s *shrinkingReader) ReadAt(p []byte, offset int64) (int, error) {
+	s.reads++
+	if s.reads > 1 {
+		s.data = s.data[:s.size]
+	}
+	return bytes.NewReader(s.data).ReadAt(p, offset)
+}
+
+func TestReverser_changed(t *testing.T) {
+	text := strings.Repeat("line\n", 100)
+	tests := []struct {
+		size   int // Size after the first read, of the last 4 bytes
+		ahead  int
+		offset int64
+	}{
+		{0, 0, 480},   // Truncated to nothing: the next chunk, 480 to 496, is gone
+		{0, 2, 480},   // The same, reading ahead
+		{488, 0, 488}, // Shrunk into the next chunk
+		{200, 0, 480}, // Shrunk below the next chunk
+		{500, 0, -1},  // Unchanged: no error
+	}
+	for _, test := range tests {
+		file := &shrinkingReader{data: []byte(text), size: test.size}
+		r := NewReverser(context.Background(), file, int64(len(text)), 16)
+		r.ReadAhead(test.ahead)
+		lines := 0
+		for r.Scan() {
+			lines += len(r.Lines())
+		}
+		r.Close()
+		var changed *ChangedError
+		if test.offset < 0 {
+			if r.Err() != nil || lines != 100 {
+				t.Errorf("size %d: expected 100 lines, got %d, %v", test.size, lines, r.Err())
+			}
+			continue
+		}
+		if !errors.As(r.Err(), &changed) || changed.Offset != test.offset {
+			t.Errorf("size %d: expected a change at %d, got %v", test.size, test.offset, r.Err())
+		}
+		if lines > 4 {
+			t.Errorf("size %d: expected at most the first chunk's lines, got %d", test.size, lines)
+		}
+	}
+}
+
+func TestReverser_readAhead(t *testing.T) {
+	text := strings.Repeat("line\n", 100)
+
+	// Offsets and counts match reading without read-ahead.
+	sync := NewReverser(context.Background(), strings.NewReader(text), int64(len(text)), 16)
+	ahead := NewReverser(context.Background(), strings.NewReader(text), int64(len(text)), 16)
+	ahead.ReadAhead(3)
+	for sync.Scan() {
+		if !ahead.Scan() {
+			t.Fatalf("expected a chunk with read-ahead, got error %v", ahead.Err())
+		}
+		if !equalLines(ahead.Lines(), sync.Lines()) ||
+			ahead.Offset() != sync.Offset() || ahead.BytesRead() != sync.BytesRead() {
+			t.Errorf("expected %q at %d (%d read), got %q at %d (%d read)",
+				sync.Lines(), sync.Offset(), sync.BytesRead(),
+				ahead.Lines(), ahead.Offset(), ahead.BytesRead())
+		}
+	}
+	if ahead.Scan() || ahead.Err() != sync.Err() {
+		t.Errorf("expected end with %v, got %v", sync.Err(), ahead.Err())
+	}
+	ahead.Close()
+
+	// Close stops the goroutine when the caller stops early.
+	r := NewReverser(context.Background(), strings.NewReader(text), int64(len(text)), 16)
+	r.ReadAhead(2)
+	if !r.Scan() {
+		t.Fatalf("expected first chunk, got error %v", r.Err())
+	}
+	r.Close()
+
+	// Canceling the context stops the scan.
+	ctx, cancel := context.WithCancel(context.Background())
+	r = NewReverser(ctx, strings.NewReader(text), int64(len(text)), 16)
+	r.ReadAhead(2)
+	if !r.Scan() {
+		t.Fatalf("expected first chunk, got error %v", r.Err())
+	}
+	cancel()
+	for r.Scan() {
+		// Batches read before the cancel may still arrive.
+	}
+	if r.Err() != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, r.Err())
+	}
+	r.Close()
+
+	// A line too long for the scanner ends the scan with its error.
+	long := strings.Repeat("x", bufio.MaxScanTokenSize+1) + "\nend\n"
+	for _, depth := range []int{0, 2} {
+		r = NewReverser(context.Background(), strings.NewReader(long), int64(len(long)), 4096)
+		r.ReadAhead(depth)
+		for r.Scan() {
+			r.Lines()
+		}
+		if r.Err() != bufio.ErrTooLong {
+			t.Errorf("ahead %d: expected %v, got %v", depth, bufio.ErrTooLong, r.Err())
+		}
+		r.Close()
+	}
+}
+
+// reverseLines is the reference for the reverser: read everything,
+// split it into lines as bufio.ScanLines does, and reverse them.
+func reverseLines(data []byte) []string {
+	lines := strings.Split(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	var reversed []string
+	for i := len(lines) - 1; i >= 0; i-- {
+		reversed = append(reversed, strings.TrimSuffix(lines[i], "\r"))
+	}
+	return reversed
+}
+
+// sliceReader is a file in memory supporting Slicer, as a mapped file does.
+type sliceReader struct {
+	*bytes.Reader
+	data []byte
+}
+
+func (s sliceReader) Slice(offset int64, n int) ([]byte, error) {
+	return s.data[offset : offset+int64(n)], nil
+}
+
+// scanAll reverses the data with the reverser, in chunks of the size.
+// With slice, the reverser slices chunks rather than reading them.
+func scanAll(data []byte, chunkSize int, slice bool, ahead int) ([]string, error) {
+	var file io.ReaderAt = bytes.NewReader(data)
+	if slice {
+		file = sliceReader{bytes.NewReader(data), data}
+	}
+	r := NewReverser(context.Background(), file, int64(len(data)), chunkSize)
+	r.ReadAhead(ahead)
+	defer r.Close()
+	var lines []string
+	for r.Scan() {
+		lines = append(lines, r.Lines()...)
+	}
+	return lines, r.Err()
+}
+
+func TestReverser_chunkBoundaries(t *testing.T) {
+	tests := []string{
+		"",
+		"a",
+		"\n",
+		"\n\n",
+		"abc\n",
+		"abc\ndef",
+		"ab\n\ncd\n\n",
+		"ab\r\ncd\r\n",
+		"\r\n\r\n",
+		"a\rb\n",
+		strings.Repeat("x", 50) + "\n" + strings.Repeat("y", 3),
+	}
+	for _, text := range tests {
+		expected := reverseLines([]byte(text))
+		for chunkSize := 1; chunkSize <= len(text)+2; chunkSize++ {
+			for _, slice := range []bool{false, true} {
+				for _, ahead := range []int{0, 2} {
+					lines, err := scanAll([]byte(text), chunkSize, slice, ahead)
+					if err != nil || !equalLines(lines, expected) {
+						t.Errorf("%q, chunk %d, slice %v, ahead %d: expected %q, got %q, %v",
+							text, chunkSize, slice, ahead, expected, lines, err)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestReverser_maxLine(t *testing.T) {
+	tests := []string{
+		"abc\ndef",
+		"abcdef\nxy\n",
+		"abcd\r\nab\r\n",
+		"ab\rcdef\r\n\n",
+		strings.Repeat("x", 50) + "\n" + strings.Repeat("y", 3),
+	}
+	for _, max := range []int{1, 3, 10} {
+		for _, text := range tests {
+			// Lines longer than max are cut to max+1 bytes.
+			expected := reverseLines([]byte(text))
+			for i, s := range expected {
+				if len(s) > max {
+					expected[i] = s[:max+1]
+				}
+			}
+			for chunkSize := 1; chunkSize <= len(text)+2; chunkSize++ {
+				for _, ahead := range []int{0, 2} {
+					r := NewReverser(context.Background(), strings.NewReader(text), int64(len(text)), chunkSize)
+					r.SetMaxLine(max)
+					r.ReadAhead(ahead)
+					var lines []string
+					for r.Scan() {
+						lines = append(lines, r.Lines()...)
+					}
+					r.Close()
+					if r.Err() != nil || !equalLines(lines, expected) {
+						t.Errorf("%q, max %d, chunk %d, ahead %d: expected %q, got %q, %v",
+							text, max, chunkSize, ahead, expected, lines, r.Err())
+					}
+				}
+			}
+		}
+	}
+
+	// A line too long for bufio is cut rather than ending the scan.
+	long := strings.Repeat("x", bufio.MaxScanTokenSize+1) + "\nend\n"
+	r := NewReverser(context.Background(), strings.NewReader(long), int64(len(long)), 4096)
+	r.SetMaxLine(100)
+	var lines []string
+	for r.Scan() {
+		lines = append(lines, r.Lines()...)
+	}
+	if r.Err() != nil || len(lines) != 2 || len(lines[1]) != 101 {
+		t.Errorf("expected end and a 101-byte line, got %d lines, %v", len(lines), r.Err())
+	}
+}
+
+func FuzzReverser(f *testing.F) {
+	f.Add([]byte("one\ntwo\nthree\n"), uint16(4))
+	f.Add([]byte("\n\nab\n\ncd"), uint16(1))
+	f.Add([]byte("ab\r\ncd\r\n\r\n"), uint16(3))
+	f.Add([]byte(strings.Repeat("long line without a break ", 20)), uint16(16))
+	f.Fuzz(func(t *testing.T, data []byte, size uint16) {
+		// Lines stay within bufio's token limit, which the reverser
+		// reports as an error rather than presenting.
+		if len(data) > bufio.MaxScanTokenSize/2 {
+			return
+		}
+		chunkSize := int(size)%4096 + 1
+		// Slicing must not modify the data, as it would a mapped file.
+		original := string(data)
+		expected := reverseLines(data)
+		for _, slice := range []bool{false, true} {
+			for _, ahead := range []int{0, 1} {
+				lines, err := scanAll(data, chunkSize, slice, ahead)
+				if err != nil {
+					t.Fatalf("chunk %d, slice %v, ahead %d: unexpected error %v", chunkSize, slice, ahead, err)
+				}
+				if !equalLines(lines, expected) {
+					t.Errorf("chunk %d, slice %v, ahead %d: expected %q, got %q",
+						chunkSize, slice, ahead, expected, lines)
+				}
+			}
+		}
+		if string(data) != original {
+			t.Errorf("chunk %d: data modified by the reverser", chunkSize)
+		}
+	})
+}
+
+func FuzzChunkReader(f *testing.F) {
+	f.Add([]byte("0123456789"), uint16(3))
+	f.Add([]byte{}, uint16(1))
+	f.Fuzz(func(t *testing.T, data []byte, size uint16) {
+		chunkSize := int(size)%4096 + 1
+		c := newChunkReader(context.Background(), bytes.NewReader(data), int64(len(data)), chunkSize)
+		// Chunks arrive last first; prepending them rebuilds the data.
+		var rebuilt []byte
+		for {
+			b := make([]byte, chunkSize)
+			n, err := c.read(b)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("chunk %d: unexpected error %v", chunkSize, err)
+			}
+			if n == 0 || (len(rebuilt) > 0 && n != chunkSize) {
+				t.Fatalf("chunk %d: unexpected read of %d bytes", chunkSize, n)
+			}
+			rebuilt = append(b[:n:n], rebuilt...)
+		}
+		if !bytes.Equal(rebuilt, data) {
+			t.Errorf("chunk %d: expected %q, got %q", chunkSize, data, rebuilt)
+		}
+		if !c.peekEOF() {
+			t.Errorf("chunk %d: expected EOF", chunkSize)
+		}
+	})
+}
+
+func equalLines(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func BenchmarkReverser(b *testing.B) {
+	benchSizes(b, func(b *testing.B, file *repeatReader, chunkSize int) {
+		r := NewReverser(context.Background(), file, file.size, chunkSize)
+		for r.Scan() {
+			r.Lines()
+		}
+		if r.Err() != nil {
+			b.Fatal(r.Err())
+		}
+	})
+}
@@ -0,0 +1,70 @@
+package scan
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+const escape = '\x1b'
+
+// Sanitize makes a line safe to show as text: it strips ANSI escape
+// sequences, as colorizing programs write, and replaces invalid UTF-8
+// with U+FFFD.  Browsers otherwise show the escapes as garbage, and
+// invalid UTF-8 breaks JSON encodings of the line.
+func Sanitize(s string) string {
+	if strings.IndexByte(s, escape) < 0 {
+		if utf8.ValidString(s) {
+			return s
+		}
+		return strings.ToValidUTF8(s, "\uFFFD")
+	}
+	return strings.ToValidUTF8(stripEscapes(s), "\uFFFD")
+}
+
+// stripEscapes removes ANSI (ECMA-48) escape sequences:
+// control sequences such as colors (ESC [ ... final byte),
+// operating system commands such as window titles (ESC ] ... BEL or
+// ESC \), and other escapes (ESC, any intermediate bytes, and a
+// final byte).
+// An unfinished sequence at the end of the line is removed.
+func stripEscapes(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); {
+		if s[i] != escape {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+		i++
+		if i == len(s) {
+			break
+		}
+		switch s[i] {
+		case '[':
+			// Parameter and intermediate bytes, then a final byte.
+			for i++; i < len(s) && (s[i] < 0x40 || s[i] > 0x7e); i++ {
+			}
+			i++
+
+		case ']':
+			for i++; i < len(s); i++ {
+				if s[i] == '\a' {
+					i++
+					break
+				}
+				if s[i] == escape && i+1 < len(s) && s[i+1] == '\\' {
+					i += 2
+					break
+				}
+			}
+
+		default:
+			// Intermediate bytes, as in ESC ( B, then a final byte.
+			for ; i < len(s) && s[i] >= 0x20 && s[i] <= 0x2f; i++ {
+			}
+			i++
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,31 @@
+package scan
+
+import (
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{"plain text", "plain text"},
+		{"café ✓", "café ✓"},
+		{"\x1b[31mERROR\x1b[0m failed", "ERROR failed"},
+		{"\x1b[1;38;5;208mbold\x1b[m", "bold"},
+		{"\x1b[2K\x1b[1Gprogress", "progress"},
+		{"\x1b]0;title\atext", "text"},
+		{"\x1b]8;;http://x\x1b\\link\x1b]8;;\x1b\\", "link"},
+		{"a\x1b(Bb", "ab"},
+		{"cut off \x1b[3", "cut off "},
+		{"trailing \x1b", "trailing "},
+		{"bad \xff\xfe bytes", "bad � bytes"},
+		{"\x1b[31m\xc3(\x1b[0m", "�("},
+		{"\ttab kept", "\ttab kept"},
+	}
+	for _, test := range tests {
+		if got := Sanitize(test.line); got != test.expected {
+			t.Errorf("Sanitize(%q): expected %q, got %q", test.line, test.expected, got)
+		}
+	}
+}
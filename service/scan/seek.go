@@ -0,0 +1,114 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"time"
+)
+
+// Below this many bytes, SeekTime scans lines rather than probing.
+const seekScanBytes = 64 * 1024
+
+// SeekTime finds, by binary search, the offset of the first line
+// whose leading timestamp is not before the target, as the before
+// function tells.  Only lines with timestamps, as the parse function
+// gives them, count; lines without, such as stack traces, belong with
+// the line before.  The file's timestamps must not decrease, as in
+// logs written as events happen; otherwise the offset is somewhere
+// plausible but not certain.  With no such line, the offset is size.
+//
+// Each probe reads from a point in the file to the next line with a
+// timestamp, so a multi-gigabyte file takes a few dozen small reads.
+func SeekTime(ctx context.Context, file io.ReaderAt, size int64,
+	parse func(string) (time.Time, bool), before func(time.Time) bool) (int64, error) {
+	lo, hi := int64(0), size // The offset is a line start in [lo, hi]
+	for hi-lo > seekScanBytes {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		mid := lo + (hi-lo)/2
+		line, err := nextTimed(file, size, mid, hi, parse)
+		if err != nil {
+			return 0, err
+		}
+		if !line.found {
+			// No timestamps from mid on; scan the whole range.
+			break
+		}
+		if before(line.time) {
+			lo = line.next
+		} else {
+			hi = line.start
+		}
+	}
+	// Scan the remaining range for the first line not before.
+	for lo < hi {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		line, err := nextTimed(file, size, lo, hi, parse)
+		if err != nil {
+			return 0, err
+		}
+		if !line.found {
+			break
+		}
+		if !before(line.time) {
+			return line.start, nil
+		}
+		lo = line.next
+	}
+	return hi, nil
+}
+
+// A timedLine is a line with a timestamp found by nextTimed.
+type timedLine struct {
+	found bool
+	start int64 // Offset of the line
+	next  int64 // Offset of the line after
+	time  time.Time
+}
+
+// nextTimed finds the first line with a timestamp starting at or
+// after from, and before limit.
+func nextTimed(file io.ReaderAt, size int64, from int64, limit int64,
+	parse func(string) (time.Time, bool)) (timedLine, error) {
+	start := from
+	if from > 0 {
+		// Back up a byte: if it is a newline, from starts a line.
+		start = from - 1
+	}
+	reader := bufio.NewReaderSize(io.NewSectionReader(file, start, size-start), 4096)
+	offset := start
+	if from > 0 {
+		// Skip the rest of the line containing from-1.
+		skipped, err := reader.ReadSlice('\n')
+		for err == bufio.ErrBufferFull {
+			offset += int64(len(skipped))
+			skipped, err = reader.ReadSlice('\n')
+		}
+		offset += int64(len(skipped))
+		if err == io.EOF {
+			return timedLine{}, nil
+		}
+		if err != nil {
+			return timedLine{}, err
+		}
+	}
+	for offset < limit {
+		line, err := reader.ReadString('\n')
+		if len(line) == 0 && err == io.EOF {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return timedLine{}, err
+		}
+		next := offset + int64(len(line))
+		if t, ok := parse(line); ok {
+			return timedLine{found: true, start: offset, next: next, time: t}, nil
+		}
+		offset = next
+	}
+	return timedLine{}, nil
+}
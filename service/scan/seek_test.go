@@ -0,0 +1,57 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSeekTime(t *testing.T) {
+	t0 := time.Date(2023, 2, 16, 7, 0, 0, 0, time.UTC)
+	// 20,000 lines a second apart, about 1MB, with a stack trace
+	// every 100 lines and a long line every 1000.
+	var b strings.Builder
+	for j := 0; j < 20000; j++ {
+		fmt.Fprintf(&b, "%s INFO line %d\n", t0.Add(time.Duration(j)*time.Second).Format("2006/01/02 15:04:05"), j)
+		if j%100 == 0 {
+			b.WriteString("\tat trace\n\tat trace\n")
+		}
+		if j%1000 == 0 {
+			b.WriteString(strings.Repeat("x", 10000) + "\n")
+		}
+	}
+	data := b.String()
+	n := TimestampNormalizer{}
+	tests := []struct {
+		target   time.Time
+		expected string // The line at the offset, or "" for the end
+	}{
+		{t0.Add(-time.Hour), "2023/02/16 07:00:00 INFO line 0"},
+		{t0, "2023/02/16 07:00:00 INFO line 0"},
+		{t0.Add(time.Second), "2023/02/16 07:00:01 INFO line 1"},
+		{t0.Add(1500 * time.Millisecond), "2023/02/16 07:00:02 INFO line 2"},
+		{t0.Add(10000 * time.Second), "2023/02/16 09:46:40 INFO line 10000"},
+		{t0.Add(19999 * time.Second), "2023/02/16 12:33:19 INFO line 19999"},
+		{t0.Add(20000 * time.Second), ""},
+	}
+	for _, test := range tests {
+		before := func(line time.Time) bool { return line.Before(test.target) }
+		offset, err := SeekTime(context.Background(), strings.NewReader(data), int64(len(data)), n.Time, before)
+		if err != nil {
+			t.Fatalf("%s: %s", test.target, err)
+		}
+		line, _, _ := strings.Cut(data[offset:], "\n")
+		if line != test.expected || (offset > 0 && data[offset-1] != '\n') {
+			t.Errorf("%s: expected %q, got %q at %d", test.target, test.expected, line, offset)
+		}
+	}
+
+	// Without timestamps, there is no line to find.
+	plain := strings.Repeat("no time here\n", 10000)
+	before := func(line time.Time) bool { return line.Before(t0) }
+	if offset, err := SeekTime(context.Background(), strings.NewReader(plain), int64(len(plain)), n.Time, before); err != nil || offset != int64(len(plain)) {
+		t.Errorf("plain: expected %d, got %d, %v", len(plain), offset, err)
+	}
+}
@@ -0,0 +1,146 @@
+package scan
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Values for TimestampNormalizer.Format
+const (
+	TimestampLocal = "local" // RFC 3339 in the normalizer's location
+	TimestampUnix  = "unix"  // Seconds since 1970, as a decimal
+	TimestampUTC   = "utc"   // RFC 3339 in UTC
+)
+
+// Leading timestamps in common log formats: ISO 8601 and Go's log
+// package (2023-02-16T07:40:46.123Z, 2023/02/16 07:40:46) and
+// syslog (Feb 16 07:40:46).
+var leadingTimestamp = regexp.MustCompile(
+	`^(\d{4}[-/]\d\d[-/]\d\d[T ]\d\d:\d\d:\d\d([.,]\d+)?(Z|[+-]\d\d:?\d\d)?` +
+		`|[A-Z][a-z][a-z] [ \d]\d \d\d:\d\d:\d\d)`)
+
+// StripTimestamp removes a leading timestamp, if any, from a line,
+// with the spaces after it.
+func StripTimestamp(s string) string {
+	if loc := leadingTimestamp.FindStringIndex(s); loc != nil {
+		return strings.TrimLeft(s[loc[1]:], " ")
+	}
+	return s
+}
+
+// A TimestampNormalizer rewrites the timestamp starting a line, so
+// lines from files written in different zones or formats compare
+// directly.  Precision is kept: a timestamp with milliseconds
+// stays in milliseconds.  Lines without a recognized timestamp are
+// unchanged.
+type TimestampNormalizer struct {
+	Format string // TimestampUTC, TimestampLocal, or TimestampUnix
+
+	// Location is the zone for TimestampLocal output, and the zone of
+	// timestamps without one, as written by the host's own logs.
+	Location *time.Location
+
+	// Now places syslog timestamps, which have no year, in the most
+	// recent year not after Now.  The zero Now uses the current time.
+	Now time.Time
+}
+
+// Normalize gives the line with its leading timestamp rewritten.
+func (n *TimestampNormalizer) Normalize(s string) string {
+	loc := leadingTimestamp.FindStringIndex(s)
+	if loc == nil {
+		return s
+	}
+	t, digits, ok := n.parse(s[:loc[1]])
+	if !ok {
+		return s
+	}
+	return n.format(t, digits) + s[loc[1]:]
+}
+
+// Time gives the time of the line's leading timestamp, if it has one.
+func (n *TimestampNormalizer) Time(s string) (time.Time, bool) {
+	loc := leadingTimestamp.FindStringIndex(s)
+	if loc == nil {
+		return time.Time{}, false
+	}
+	t, _, ok := n.parse(s[:loc[1]])
+	return t, ok
+}
+
+// parse gives the time of a timestamp leadingTimestamp matched,
+// and the number of fractional second digits.
+func (n *TimestampNormalizer) parse(ts string) (t time.Time, digits int, ok bool) {
+	location := n.Location
+	if location == nil {
+		location = time.UTC
+	}
+	if ts[0] < '0' || ts[0] > '9' {
+		// Syslog: the year is missing, so try this year, then last.
+		stamp, err := time.Parse(time.Stamp, ts)
+		if err != nil {
+			return t, 0, false
+		}
+		now := n.Now
+		if now.IsZero() {
+			now = time.Now()
+		}
+		for year := now.Year(); year >= now.Year()-1; year-- {
+			t = time.Date(year, stamp.Month(), stamp.Day(),
+				stamp.Hour(), stamp.Minute(), stamp.Second(), 0, location)
+			// A day's leeway allows for clocks and zones.
+			if !t.After(now.Add(24 * time.Hour)) {
+				break
+			}
+		}
+		return t, 0, true
+	}
+
+	// ISO 8601 or Go's log package: make it RFC 3339.
+	b := []byte(ts)
+	b[4], b[7], b[10] = '-', '-', 'T'
+	ts = string(b)
+	rest := ts[len("2006-01-02T15:04:05"):]
+	if len(rest) > 0 && (rest[0] == '.' || rest[0] == ',') {
+		digits = len(rest) - len(strings.TrimLeft(rest[1:], "0123456789")) - 1
+		rest = rest[digits+1:]
+		if digits > 9 {
+			digits = 9 // Nanoseconds
+		}
+	}
+	var err error
+	switch {
+	case rest == "":
+		t, err = time.ParseInLocation("2006-01-02T15:04:05", ts, location)
+	case strings.Contains(rest, ":") || rest == "Z":
+		t, err = time.Parse(time.RFC3339, ts)
+	default:
+		t, err = time.Parse("2006-01-02T15:04:05Z0700", ts)
+	}
+	return t, digits, err == nil
+}
+
+func (n *TimestampNormalizer) format(t time.Time, digits int) string {
+	switch n.Format {
+	case TimestampUnix:
+		s := strconv.FormatInt(t.Unix(), 10)
+		if digits > 0 {
+			fraction := strconv.Itoa(t.Nanosecond() + 1e9)[1:]
+			s += "." + fraction[:digits]
+		}
+		return s
+	case TimestampLocal:
+		if n.Location != nil {
+			t = t.In(n.Location)
+		}
+	default:
+		t = t.UTC()
+	}
+	layout := "2006-01-02T15:04:05"
+	if digits > 0 {
+		layout += "." + strings.Repeat("0", digits)
+	}
+	return t.Format(layout + "Z07:00")
+}
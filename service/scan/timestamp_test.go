@@ -0,0 +1,64 @@
+package scan
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStripTimestamp(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{"2023/02/16 07:40:46 ERROR x", "ERROR x"},
+		{"2023-02-16T07:40:46Z ERROR x", "ERROR x"},
+		{"2023-02-16T07:40:46.123456+01:00 ERROR x", "ERROR x"},
+		{"2023-02-16 07:40:46,123 ERROR x", "ERROR x"},
+		{"Feb 16 07:40:46 host sshd[42]: x", "host sshd[42]: x"},
+		{"Feb  6 07:40:46 host x", "host x"},
+		{"ERROR 2023/02/16 07:40:46", "ERROR 2023/02/16 07:40:46"},
+		{"2023/02/16 ERROR", "2023/02/16 ERROR"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := StripTimestamp(test.line); got != test.expected {
+			t.Errorf("StripTimestamp(%q): expected %q, got %q", test.line, test.expected, got)
+		}
+	}
+}
+
+func TestTimestampNormalizer(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no zone database:", err)
+	}
+	now := time.Date(2023, 2, 17, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		format   string
+		line     string
+		expected string
+	}{
+		{TimestampUTC, "2023-02-16T07:40:46Z x", "2023-02-16T07:40:46Z x"},
+		{TimestampUTC, "2023-02-16T08:40:46+01:00 x", "2023-02-16T07:40:46Z x"},
+		{TimestampUTC, "2023-02-16T02:40:46.123-0500 x", "2023-02-16T07:40:46.123Z x"},
+		{TimestampUTC, "2023/02/16 08:40:46 x", "2023-02-16T07:40:46Z x"},
+		{TimestampUTC, "2023-02-16 08:40:46,5 x", "2023-02-16T07:40:46.5Z x"},
+		{TimestampUTC, "2023-07-16 09:40:46 summer", "2023-07-16T07:40:46Z summer"},
+		{TimestampUTC, "Feb 16 08:40:46 host x", "2023-02-16T07:40:46Z host x"},
+		{TimestampUTC, "Dec 31 23:00:00 host x", "2022-12-31T22:00:00Z host x"},
+		{TimestampUTC, "Feb 18 01:00:00 host x", "2023-02-18T00:00:00Z host x"},
+		{TimestampLocal, "2023-02-16T07:40:46Z x", "2023-02-16T08:40:46+01:00 x"},
+		{TimestampLocal, "2023/02/16 08:40:46 x", "2023-02-16T08:40:46+01:00 x"},
+		{TimestampUnix, "2023-02-16T07:40:46Z x", "1676533246 x"},
+		{TimestampUnix, "2023-02-16T07:40:46.000123456789Z x", "1676533246.000123456 x"},
+		{TimestampUnix, "2023-02-16T07:40:46.05Z x", "1676533246.05 x"},
+		{TimestampUTC, "no timestamp", "no timestamp"},
+		{TimestampUTC, "2023-13-45T07:40:46Z bad date", "2023-13-45T07:40:46Z bad date"},
+	}
+	for _, test := range tests {
+		n := TimestampNormalizer{Format: test.format, Location: berlin, Now: now}
+		if got := n.Normalize(test.line); got != test.expected {
+			t.Errorf("%s %q: expected %q, got %q", test.format, test.line, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+	"varlog/service/app"
+)
+
+// accessLog wraps a handler to assign each request an ID and log
+// one access line when the request completes:
+//
+//	varlog INFO access id=3f2a... method=GET path=/read status=200 bytes=5120 duration=1.2ms remote=... proto=http
+//
+// Behind a reverse proxy, X-Forwarded-Proto gives the proto, and
+// X-Forwarded-For adds the original client address.
+//
+// The ID is the incoming X-Request-ID, if any, or a new one.
+// It is returned in the X-Request-ID response header, recorded in the
+// request context for app.Error, and logged, so client reports can be
+// matched to log lines.
+func accessLog(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		t0 := time.Now()
+		id := app.NewRequestID(request)
+		writer.Header().Set(app.HdrRequestID, id)
+		recorder := app.NewResponseRecorder(writer)
+		handler.ServeHTTP(recorder, request.WithContext(app.WithRequestID(request.Context(), id)))
+		fields := []interface{}{"id", id, "method", request.Method,
+			"path", request.URL.Path, "status", recorder.Status, "bytes", recorder.Bytes,
+			"duration", time.Since(t0).Round(time.Microsecond), "remote", request.RemoteAddr,
+			"proto", forwardedProto(request)}
+		if client := forwardedFor(request); client != "" {
+			fields = append(fields, "client", client)
+		}
+		app.Logf(app.LogInfo, "access", fields...)
+	})
+}
+
+// forwardedFor gives the original client address from X-Forwarded-For,
+// as set by a reverse proxy, or the empty string.  With several proxies,
+// the first address is the client's.  The header is not verified,
+// so the log keeps the connection's remote address as well.
+func forwardedFor(request *http.Request) string {
+	value := request.Header.Get("X-Forwarded-For")
+	first, _, _ := strings.Cut(value, ",")
+	return strings.TrimSpace(first)
+}
+
+// forwardedProto gives the scheme the client used: X-Forwarded-Proto
+// from a reverse proxy that terminates TLS, or the connection's own.
+func forwardedProto(request *http.Request) string {
+	proto := request.Header.Get("X-Forwarded-Proto")
+	proto, _, _ = strings.Cut(proto, ",")
+	if proto = strings.ToLower(strings.TrimSpace(proto)); proto == "http" || proto == "https" {
+		return proto
+	}
+	if request.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"varlog/service/app"
+)
+
+func TestNetACL_decide(t *testing.T) {
+	props := app.DefaultProperties()
+	props.SetNetACL([]string{"10.0.0.0/8, 192.168.1.7", "fd00::/8"}, []string{"10.9.0.0/16"})
+	acl, err := newNetACL(props)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		remoteAddr string
+		allowed    bool
+	}{
+		{"10.1.2.3:5000", true},
+		{"10.9.2.3:5000", false},
+		{"192.168.1.7:5000", true},
+		{"192.168.1.8:5000", false},
+		{"[::ffff:10.1.2.3]:5000", true},
+		{"[fd12::1]:5000", true},
+		{"[fd12::1%eth0]:5000", true},
+		{"[2001:db8::1]:5000", false},
+		{"@", false},
+	}
+	for _, test := range tests {
+		if allowed, rule := acl.decide(test.remoteAddr); allowed != test.allowed {
+			t.Errorf("%s: expected allowed %v, got %v (%s)", test.remoteAddr, test.allowed, allowed, rule)
+		}
+	}
+
+	props.SetNetACL(nil, []string{"::ffff:10.0.0.0/104"})
+	if acl, err = newNetACL(props); err != nil {
+		t.Fatal(err)
+	}
+	if allowed, _ := acl.decide("10.1.2.3:5000"); allowed {
+		t.Errorf("expected a mapped IPv4 deny to match IPv4 clients")
+	}
+	if allowed, _ := acl.decide("@"); !allowed {
+		t.Errorf("expected an unknown address allowed with no -allow-net")
+	}
+}
+
+func TestNetACL_errors(t *testing.T) {
+	props := app.DefaultProperties()
+	if acl, err := newNetACL(props); acl != nil || err != nil {
+		t.Errorf("expected no access lists, got %v %v", acl, err)
+	}
+	for _, value := range []string{"10.0.0.0/33", "example.com", "10.0.0.1/8/8"} {
+		props.SetNetACL([]string{value}, nil)
+		if _, err := newNetACL(props); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
+
+// The lists apply before authentication, to every endpoint.
+func TestNetACL_server(t *testing.T) {
+	for _, test := range []struct {
+		allow  string
+		status int
+	}{
+		{"127.0.0.0/8", http.StatusOK},
+		{"10.0.0.0/8", http.StatusForbidden},
+	} {
+		props := app.DefaultProperties()
+		props.SetRoot(endpointTree.WriteDir(t))
+		props.SetNetACL([]string{test.allow, "::1"}, nil)
+		srv, err := New(props)
+		if err != nil {
+			t.Fatalf("New: %s", err)
+		}
+		ts := httptest.NewServer(srv.Handler())
+		response, body := fetch(t, ts, http.MethodGet, "/health")
+		ts.Close()
+		if response.StatusCode != test.status {
+			t.Errorf("-allow-net %s: expected status %d, got %d", test.allow, test.status, response.StatusCode)
+		}
+		if test.status == http.StatusForbidden {
+			checkErrorEnvelope(t, "/health", response, body, http.StatusForbidden, app.CodeAccessDenied)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"varlog/service/alert"
+	"varlog/service/app"
+	"varlog/service/read"
+)
+
+// Alerts run saved queries in the background, one task for each rule
+// of the -alert-file.  See package alert.  Queries run as the server,
+// without a principal, so authorization and quotas do not apply.
+
+// The endpoints whose responses count matches, and their handlers.
+// Background reads (POST /jobs) serve these too.
+var countHandlers = map[string]http.HandlerFunc{
+	"/aggregate": read.AggregateHandler,
+	"/read":      read.Handler,
+	"/top":       read.TopHandler,
+}
+
+// setupAlerts loads the alert rules and starts a task for each.
+func (s *Server) setupAlerts(props *app.Properties) error {
+	if props.AlertFile() == "" {
+		return nil
+	}
+	rules, err := alert.Load(props.AlertFile())
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		q, ok := props.Query(rule.Query)
+		if !ok {
+			return errors.New(fmt.Sprintf("query %q not saved, see -query", rule.Query))
+		}
+		if countHandlers[q.Endpoint()] == nil {
+			return errors.New(fmt.Sprintf("query %q of %s does not count matches", q.Name, q.Endpoint()))
+		}
+		rule := rule
+		s.Go("alert "+rule.Query, func(ctx context.Context) error { return rule.Watch(ctx, s.countMatches) })
+	}
+	app.Log(app.LogInfo, "Alerts loaded for %d queries from %s", len(rules), props.AlertFile())
+	return nil
+}
+
+// countMatches runs the saved query over the lines since the time
+// given, ago, giving the number of matches.  Implements alert.Counter.
+func (s *Server) countMatches(ctx context.Context, name string, since time.Duration) (int, error) {
+	props := s.props.Load()
+	q, ok := props.Query(name)
+	if !ok {
+		return 0, errors.New(fmt.Sprintf("query %q no longer saved", name))
+	}
+	params := q.Params(url.Values{app.ParamSince: {since.String()}})
+	if q.Endpoint() == "/read" {
+		params.Set(app.ParamMode, app.ModeCount)
+	}
+	request, err := http.NewRequestWithContext(app.WithProperties(ctx, props), http.MethodGet,
+		q.Endpoint()+"?"+params.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	recorder := &countRecorder{header: http.Header{}, status: http.StatusOK}
+	countHandlers[q.Endpoint()](recorder, request)
+	if recorder.status != http.StatusOK {
+		return 0, errors.New(fmt.Sprintf("status %d, %s", recorder.status, strings.TrimSpace(recorder.body.String())))
+	}
+	var result struct {
+		Matches int `json:"matches"`
+	}
+	if err := json.Unmarshal(recorder.body.Bytes(), &result); err != nil {
+		return 0, err
+	}
+	return result.Matches, nil
+}
+
+// countRecorder keeps a response in memory.
+type countRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *countRecorder) Header() http.Header         { return r.header }
+func (r *countRecorder) Write(b []byte) (int, error) { return r.body.Write(b) }
+func (r *countRecorder) WriteHeader(status int)      { r.status = status }
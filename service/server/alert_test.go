@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"varlog/service/app"
+	"varlog/service/apptest"
+)
+
+func TestCountMatches(t *testing.T) {
+	now := time.Now().UTC()
+	line := func(ago time.Duration, s string) string {
+		return now.Add(-ago).Format(time.RFC3339) + " " + s
+	}
+	tree := apptest.NewTree().File("app.log",
+		line(2*time.Hour, "ERROR old"), line(3*time.Minute, "ERROR disk"), line(2*time.Minute, "INFO up"),
+		line(time.Minute, "ERROR disk"))
+	props := app.DefaultProperties()
+	props.SetRoot(tree.WriteDir(t))
+	for _, spec := range [][2]string{
+		{"errors", "/read?name=app.log&filter=ERROR"},
+		{"top", "/top?name=app.log&filter=ERROR"},
+		{"listing", "/list"},
+	} {
+		q, err := app.NewQuery(spec[0], spec[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		props.SetQuery(q)
+	}
+	srv, err := New(props)
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	tests := []struct {
+		query    string
+		since    time.Duration
+		expected int
+	}{
+		{"errors", 5 * time.Minute, 2},
+		{"errors", 3 * time.Hour, 3},
+		{"top", 5 * time.Minute, 2},
+	}
+	for _, test := range tests {
+		n, err := srv.countMatches(context.Background(), test.query, test.since)
+		if err != nil || n != test.expected {
+			t.Errorf("%s since %s: expected %d, got %d %v", test.query, test.since, test.expected, n, err)
+		}
+	}
+	if _, err := srv.countMatches(context.Background(), "missing", time.Minute); err == nil {
+		t.Errorf("missing: expected an error")
+	}
+
+	for rules, expected := range map[string]string{
+		"missing: threshold=1 webhook=http://localhost/": "not saved",
+		"listing: threshold=1 webhook=http://localhost/": "does not count",
+	} {
+		name := filepath.Join(t.TempDir(), "alerts")
+		os.WriteFile(name, []byte(rules+"\n"), 0o644)
+		props.SetAlertFile(name)
+		if _, err := New(props); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%q: expected an error with %q, got %v", rules, expected, err)
+		}
+	}
+}
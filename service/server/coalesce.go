@@ -0,0 +1,387 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"varlog/service/app"
+	"varlog/service/jobs"
+)
+
+// Coalesced reads.  With -coalesce-reads, identical /read requests in
+// progress at once, as when everyone in an incident opens the same log
+// with the same filter, share one scan.  The first request starts the
+// scan in the background, as a job's request, and its response is kept
+// as it is written; each client, the first included, is sent the
+// response from the start, at the client's own pace.
+//
+// Requests are identical when the client (principal and groups), the
+// path, the parameters in any order, and the Accept header are the
+// same.  HEAD requests and 'follow=true' are not coalesced.  A request
+// joins a scan only while its whole response is kept, up to
+// coalesceBytes; beyond that, the response is kept only as far as the
+// slowest client has been sent it, and the scan waits for a client
+// falling coalesceBytes behind.  The scan stops when every client has
+// gone away.
+
+// Bytes of a response kept for clients joining, and the most a client
+// may fall behind the scan.
+const coalesceBytes = 4 * 1024 * 1024
+
+// coalesced shares scans among identical requests, with
+// -coalesce-reads.  It goes after metered, so each client's quota
+// counts the response, and before limitReads, so a client joining a
+// scan takes no read slot.
+func coalesced(props *app.Properties) Middleware {
+	if !props.CoalesceReads() {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return newFlightGroup().wrap
+}
+
+// A flightGroup holds the scans in progress, by request.
+type flightGroup struct {
+	mutex   sync.Mutex
+	flights map[string]*flight
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{flights: map[string]*flight{}}
+}
+
+// wrap coalesces the handler's requests.
+func (g *flightGroup) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		key, ok := coalesceKey(request)
+		if !ok {
+			next.ServeHTTP(writer, request)
+			return
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		background, err := jobs.Request(ctx, request, request.URL.RequestURI())
+		if err != nil {
+			cancel()
+			next.ServeHTTP(writer, request)
+			return
+		}
+		if accept := request.Header.Get("Accept"); accept != "" {
+			background.Header.Set("Accept", accept)
+		}
+		f, c := g.join(key, next, background, cancel)
+		f.send(writer, request, c)
+	})
+}
+
+// coalesceKey gives the key of the requests identical to this one,
+// or false if it is not coalesced.
+func coalesceKey(request *http.Request) (string, bool) {
+	if request.Method != http.MethodGet {
+		return "", false
+	}
+	query, err := url.ParseQuery(request.URL.RawQuery)
+	if err != nil {
+		return "", false
+	}
+	if follow, _ := strconv.ParseBool(query.Get(app.ParamFollow)); follow {
+		return "", false
+	}
+	ctx := request.Context()
+	return strings.Join([]string{app.PrincipalFrom(ctx), strings.Join(app.GroupsFrom(ctx), ","),
+		request.URL.Path, query.Encode(), request.Header.Get("Accept")}, "\x00"), true
+}
+
+// join adds a client to the scan of the key, starting the scan with
+// the background request if there is none to join.  Joining, the
+// background request is not needed, and is canceled.
+func (g *flightGroup) join(key string, next http.Handler, background *http.Request, cancel context.CancelFunc) (*flight, *flightClient) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	c := &flightClient{}
+	if f, found := g.flights[key]; found && f.add(c) {
+		cancel()
+		app.Log(app.LogDebug, "Coalesced %q with a scan in progress", background.URL)
+		return f, c
+	}
+	f := &flight{group: g, key: key, cancel: cancel, changed: make(chan struct{}),
+		joinable: true, clients: map[*flightClient]bool{c: true}}
+	g.flights[key] = f
+	go f.run(next, background)
+	return f, c
+}
+
+// remove forgets the scan, so later requests start their own.
+func (g *flightGroup) remove(f *flight) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.flights[f.key] == f {
+		delete(g.flights, f.key)
+	}
+}
+
+// A flight is one scan, and the clients sent its response.
+type flight struct {
+	group  *flightGroup
+	key    string
+	cancel context.CancelFunc // Stops the scan
+
+	mutex    sync.Mutex
+	changed  chan struct{} // Closed when the state changes, then replaced
+	status   int           // 0 until the handler writes the header
+	header   http.Header   // As the handler wrote it, with the status
+	body     []byte        // The response from offset base
+	base     int64
+	joinable bool        // Whether clients may join: the whole response is kept
+	blocked  bool        // Whether the scan waits for clients
+	done     bool        // Whether the handler has returned
+	aborted  bool        // Whether the handler aborted the response
+	final    http.Header // The header when the handler returned, for trailers
+	clients  map[*flightClient]bool
+}
+
+// A flightClient is a client of a flight.
+type flightClient struct {
+	offset int64 // Bytes of the response sent
+}
+
+// run runs the handler, keeping its response.  A panic ends the
+// response as the recovery middleware would, for each client.
+func (f *flight) run(next http.Handler, request *http.Request) {
+	defer f.cancel()
+	w := &flightWriter{f: f, header: http.Header{}}
+	defer func() {
+		p := recover()
+		aborted := p == http.ErrAbortHandler
+		if p != nil && !aborted {
+			app.Log(app.LogError, "panic serving %q: %v\n%s", request.URL, p, debug.Stack())
+			if w.wroteHeader {
+				aborted = true
+			} else {
+				app.Error(w, request, "Internal server error", http.StatusInternalServerError)
+			}
+		}
+		f.end(w.header, aborted)
+	}()
+	next.ServeHTTP(w, request)
+}
+
+// add adds a client, if the whole response is still kept.
+func (f *flight) add(c *flightClient) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if !f.joinable {
+		return false
+	}
+	f.clients[c] = true
+	return true
+}
+
+// notify wakes the clients, and the scan, waiting for a change.
+// The mutex must be held.
+func (f *flight) notify() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// start records the status and header the handler wrote.
+func (f *flight) start(status int, header http.Header) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.status, f.header = status, header
+	f.notify()
+}
+
+// write keeps the bytes for the clients, first waiting for any client
+// coalesceBytes behind.  Once the response outgrows coalesceBytes, no
+// more clients join.  Without clients, the scan is over.
+func (f *flight) write(b []byte) (int, error) {
+	f.mutex.Lock()
+	for len(f.clients) > 0 && f.base+int64(len(f.body))-f.slowest() >= coalesceBytes {
+		f.blocked = true
+		changed := f.changed
+		f.mutex.Unlock()
+		<-changed
+		f.mutex.Lock()
+	}
+	f.blocked = false
+	if len(f.clients) == 0 {
+		f.mutex.Unlock()
+		return 0, context.Canceled
+	}
+	f.body = append(f.body, b...)
+	closed := false
+	if f.joinable && f.base+int64(len(f.body)) > coalesceBytes {
+		f.joinable, closed = false, true
+	}
+	f.notify()
+	f.mutex.Unlock()
+	if closed {
+		f.group.remove(f)
+	}
+	return len(b), nil
+}
+
+// end records the end of the response.
+func (f *flight) end(header http.Header, aborted bool) {
+	f.mutex.Lock()
+	if f.status == 0 {
+		f.status, f.header = http.StatusOK, header.Clone()
+	}
+	f.done, f.aborted, f.final = true, aborted, header
+	f.joinable = false
+	f.notify()
+	f.mutex.Unlock()
+	f.group.remove(f)
+}
+
+// slowest gives the offset of the client furthest behind.  The mutex
+// must be held.
+func (f *flight) slowest() int64 {
+	offset := f.base + int64(len(f.body))
+	for c := range f.clients {
+		if c.offset < offset {
+			offset = c.offset
+		}
+	}
+	return offset
+}
+
+// advance records bytes sent to a client, dropping those every client
+// has been sent, once no more clients join.
+func (f *flight) advance(c *flightClient, n int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	c.offset += int64(n)
+	f.trim()
+	if f.blocked {
+		f.notify()
+	}
+}
+
+// trim drops the bytes every client has been sent, unless clients may
+// still join.  The mutex must be held.
+func (f *flight) trim() {
+	if f.joinable {
+		return
+	}
+	// Copy the rest when it is at most half, so the memory of the
+	// bytes sent is released, at a cost proportional to those sent.
+	if n := int(f.slowest() - f.base); n > 0 && n >= len(f.body)-n {
+		f.body = append([]byte(nil), f.body[n:]...)
+		f.base += int64(n)
+	}
+}
+
+// leave removes a client.  Without clients, the scan stops.
+func (f *flight) leave(c *flightClient) {
+	f.mutex.Lock()
+	delete(f.clients, c)
+	last := len(f.clients) == 0
+	if last {
+		f.joinable = false
+		f.cancel()
+	}
+	f.trim()
+	f.notify()
+	f.mutex.Unlock()
+	if last {
+		f.group.remove(f)
+	}
+}
+
+// send writes the response to a client, as it is kept, until it ends
+// or the client goes away.
+func (f *flight) send(writer http.ResponseWriter, request *http.Request, c *flightClient) {
+	defer f.leave(c)
+	flusher, _ := writer.(http.Flusher)
+	wroteHeader := false
+	for {
+		f.mutex.Lock()
+		status, header, done, aborted, final, changed := f.status, f.header, f.done, f.aborted, f.final, f.changed
+		var b []byte
+		if status != 0 {
+			b = f.body[c.offset-f.base:]
+		}
+		f.mutex.Unlock()
+
+		if status != 0 && !wroteHeader {
+			for name, values := range header {
+				writer.Header()[name] = append(writer.Header()[name], values...)
+			}
+			writer.WriteHeader(status)
+			wroteHeader = true
+		}
+		if len(b) > 0 {
+			if _, err := writer.Write(b); err != nil || request.Context().Err() != nil {
+				return
+			}
+			f.advance(c, len(b))
+			continue
+		}
+		if done {
+			if aborted {
+				panic(http.ErrAbortHandler)
+			}
+			setTrailers(writer.Header(), header, final)
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case <-changed:
+		case <-request.Context().Done():
+			return
+		}
+	}
+}
+
+// setTrailers copies the trailers from the handler's final header:
+// those declared in the header written, and those named with
+// http.TrailerPrefix.
+func setTrailers(dst http.Header, header http.Header, final http.Header) {
+	declared := map[string]bool{}
+	for _, value := range header.Values(app.HdrTrailer) {
+		for _, name := range strings.Split(value, ",") {
+			declared[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+		}
+	}
+	for name, values := range final {
+		if declared[name] || strings.HasPrefix(name, http.TrailerPrefix) {
+			dst[name] = values
+		}
+	}
+}
+
+// A flightWriter is the ResponseWriter of a flight's handler.
+type flightWriter struct {
+	f           *flight
+	header      http.Header
+	wroteHeader bool
+}
+
+func (w *flightWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *flightWriter) WriteHeader(status int) {
+	if w.wroteHeader || status < http.StatusOK {
+		return
+	}
+	w.wroteHeader = true
+	w.f.start(status, w.header.Clone())
+}
+
+func (w *flightWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.f.write(b)
+}
+
+// Flush does nothing: clients are flushed as they are sent the
+// response.
+func (w *flightWriter) Flush() {}
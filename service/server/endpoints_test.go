@@ -0,0 +1,355 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"varlog/service/app"
+	"varlog/service/apptest"
+)
+
+// The tree served by the endpoint tests.  big.log is large enough
+// that /read makes it an attachment by default.
+var endpointTree = apptest.NewTree().
+	Log("app.log", 20).
+	Log("big.log", 2000).
+	File("empty.log").
+	File("nginx/access.log", "GET /a 200", "GET /b 404", "POST /c 200").
+	File("nginx/error.log", "upstream timed out").
+	Dir("old")
+
+// newTestServer serves the tree from a temporary root over real HTTP,
+// with the full handler stack.
+func newTestServer(t *testing.T) *httptest.Server {
+	props := app.DefaultProperties()
+	props.SetRoot(endpointTree.WriteDir(t))
+	return newServer(t, props)
+}
+
+// newServer serves the properties over real HTTP, with the full
+// handler stack.
+func newServer(t *testing.T, props *app.Properties) *httptest.Server {
+	srv, err := New(props)
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// The bearer token fetch presents.  It matters only to servers
+// given it with withToken, as the /admin endpoints require.
+const testToken = "test-secret"
+
+// withToken configures the bearer token fetch presents.
+func withToken(props *app.Properties) *app.Properties {
+	props.SetAuthTokens([]string{"tester:" + testToken})
+	return props
+}
+
+// fetch sends a request, giving the response and its body.
+func fetch(t *testing.T, ts *httptest.Server, method string, target string) (*http.Response, string) {
+	request, err := http.NewRequest(method, ts.URL+target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Authorization", "Bearer "+testToken)
+	response, err := ts.Client().Do(request)
+	if err != nil {
+		t.Fatalf("%s %s: %s", method, target, err)
+	}
+	defer response.Body.Close()
+	b, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("%s %s: %s", method, target, err)
+	}
+	return response, string(b)
+}
+
+// checkErrorEnvelope checks an error response's status and JSON shape.
+func checkErrorEnvelope(t *testing.T, target string, response *http.Response, body string, status int, code string) {
+	if response.StatusCode != status {
+		t.Errorf("%s: expected status %d, got %d", target, status, response.StatusCode)
+	}
+	if contentType := response.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+		t.Errorf("%s: expected JSON error, got %q", target, contentType)
+	}
+	var envelope struct {
+		Error map[string]string `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(body), &envelope); err != nil {
+		t.Errorf("%s: expected error envelope, got %q", target, body)
+		return
+	}
+	if envelope.Error["code"] != code || envelope.Error["message"] == "" || envelope.Error["request_id"] == "" {
+		t.Errorf("%s: expected code %q with message and request_id, got %v", target, code, envelope.Error)
+	}
+}
+
+func TestEndpoints_list(t *testing.T) {
+	ts := newTestServer(t)
+	tests := []struct {
+		target   string
+		expected string
+	}{
+		{"/list",
+			`[{"name":"app.log","type":"file"},{"name":"big.log","type":"file"},{"name":"empty.log","type":"file"},` +
+				`{"name":"nginx","type":"dir"},{"name":"old","type":"dir"}]`},
+		{"/list?filter=big", `[{"name":"big.log","type":"file"}]`},
+		{"/list?filter=-.log", `[{"name":"nginx","type":"dir"},{"name":"old","type":"dir"}]`},
+		{"/list?name=nginx", `[{"name":"nginx/access.log","type":"file"},{"name":"nginx/error.log","type":"file"}]`},
+		{"/list?name=nginx/error.log", `[{"name":"nginx/error.log","type":"file"}]`},
+		{"/list?name=old", `[]`},
+	}
+	for _, test := range tests {
+		response, body := fetch(t, ts, http.MethodGet, test.target)
+		if response.StatusCode != http.StatusOK {
+			t.Errorf("%s: expected status 200, got %d %s", test.target, response.StatusCode, body)
+			continue
+		}
+		if contentType := response.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+			t.Errorf("%s: expected JSON, got %q", test.target, contentType)
+		}
+		var got, expected interface{}
+		json.Unmarshal([]byte(body), &got)
+		json.Unmarshal([]byte(test.expected), &expected)
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: expected %s, got %s", test.target, test.expected, body)
+		}
+	}
+}
+
+func TestEndpoints_read(t *testing.T) {
+	ts := newTestServer(t)
+	tests := []struct {
+		target      string
+		expected    string
+		disposition string
+	}{
+		{"/read?name=nginx/access.log", "POST /c 200\nGET /b 404\nGET /a 200\n", ""},
+		{"/read?name=nginx/access.log&count=1", "POST /c 200\n", ""},
+		{"/read?name=nginx/access.log&filter=GET", "GET /b 404\nGET /a 200\n", ""},
+		{"/read?name=nginx/access.log&filter=-GET", "POST /c 200\n", ""},
+		{"/read?name=nginx/access.log&filter=200&count=1", "POST /c 200\n", ""},
+		{"/read?name=empty.log", "", ""},
+		{"/read?name=app.log&count=1", apptest.LogLine(19) + "\n", ""},
+		{"/read?name=nginx/error.log&content-disposition=attachment", "upstream timed out\n",
+			`attachment; filename="error.log"`},
+		{"/read?name=big.log&count=1", apptest.LogLine(1999) + "\n", ""},
+		{"/read?name=big.log&content-disposition=inline&count=20000", "", ""},
+		{"/read?name=big.log", "", `attachment; filename="big.log"`},
+	}
+	for _, test := range tests {
+		response, body := fetch(t, ts, http.MethodGet, test.target)
+		if response.StatusCode != http.StatusOK {
+			t.Errorf("%s: expected status 200, got %d %s", test.target, response.StatusCode, body)
+			continue
+		}
+		if test.expected != "" && body != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.target, test.expected, body)
+		}
+		if disposition := response.Header.Get("Content-Disposition"); disposition != test.disposition {
+			t.Errorf("%s: expected Content-Disposition %q, got %q", test.target, test.disposition, disposition)
+		}
+	}
+
+	// The whole file arrives, most recent first.
+	_, body := fetch(t, ts, http.MethodGet, "/read?name=big.log")
+	lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+	if len(lines) != 2000 || lines[0] != apptest.LogLine(1999) || lines[1999] != apptest.LogLine(0) {
+		t.Errorf("big.log: expected 2000 lines in reverse, got %d", len(lines))
+	}
+}
+
+func TestEndpoints_head(t *testing.T) {
+	ts := newTestServer(t)
+	request, err := http.NewRequest(http.MethodHead, ts.URL+"/read?name=big.log", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Accept-Encoding", "gzip")
+	response, err := ts.Client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK || response.ContentLength != 150000 {
+		t.Errorf("HEAD big.log: expected 200 with the file size, got %d %d", response.StatusCode, response.ContentLength)
+	}
+	if disposition := response.Header.Get(app.HdrContentDisposition); disposition != `attachment; filename="big.log"` {
+		t.Errorf("HEAD big.log: expected an attachment, got %q", disposition)
+	}
+	if response.Header.Get("Last-Modified") == "" || response.Header.Get("ETag") == "" {
+		t.Errorf("HEAD big.log: expected Last-Modified and ETag, got %v", response.Header)
+	}
+}
+
+func TestEndpoints_cache(t *testing.T) {
+	props := app.DefaultProperties()
+	props.SetRoot(endpointTree.WriteDir(t))
+	ts := newServer(t, withToken(props))
+	fetch(t, ts, http.MethodGet, "/list")
+	fetch(t, ts, http.MethodGet, "/list")
+
+	// Gives the list cache's status.
+	listStatus := func(body string) map[string]interface{} {
+		var status struct {
+			Caches map[string]map[string]interface{} `json:"caches"`
+		}
+		if err := json.Unmarshal([]byte(body), &status); err != nil {
+			t.Fatalf("bad JSON %q: %s", body, err)
+		}
+		return status.Caches["list"]
+	}
+	response, body := fetch(t, ts, http.MethodGet, "/admin/cache")
+	if status := listStatus(body); response.StatusCode != http.StatusOK || status["entries"].(float64) < 1 || status["hits"].(float64) < 1 {
+		t.Errorf("GET /admin/cache: expected a cached listing and a hit, got %d %s", response.StatusCode, body)
+	}
+	response, body = fetch(t, ts, http.MethodPost, "/admin/cache?cache=list")
+	if status := listStatus(body); response.StatusCode != http.StatusOK || status["flushed"].(float64) < 1 || status["entries"].(float64) != 0 {
+		t.Errorf("POST /admin/cache: expected a flushed listing, got %d %s", response.StatusCode, body)
+	}
+
+	for _, target := range []string{"/admin/cache?cache=results", "/admin/cache?path=var/log"} {
+		response, body = fetch(t, ts, http.MethodPost, target)
+		checkErrorEnvelope(t, "POST "+target, response, body, http.StatusBadRequest, app.CodeInvalidParam)
+	}
+}
+
+// Without credentials configured, the /admin endpoints refuse everyone.
+func TestEndpoints_adminUnauthenticated(t *testing.T) {
+	ts := newTestServer(t)
+	for _, request := range []struct{ method, target string }{
+		{http.MethodPost, "/admin/maintenance?enable=true"},
+		{http.MethodPost, "/admin/log-level?level=DEBUG"},
+		{http.MethodPost, "/admin/cache"},
+		{http.MethodGet, "/admin/stats"},
+		{http.MethodGet, "/admin/queries"},
+		{http.MethodGet, "/audit"},
+		{http.MethodGet, "/metrics"},
+	} {
+		response, body := fetch(t, ts, request.method, request.target)
+		checkErrorEnvelope(t, request.method+" "+request.target, response, body, http.StatusForbidden, app.CodeAccessDenied)
+	}
+	if app.Maintenance() {
+		t.Errorf("expected maintenance mode unchanged")
+	}
+	if response, _ := fetch(t, ts, http.MethodGet, "/read?name=app.log"); response.StatusCode != http.StatusOK {
+		t.Errorf("/read: expected 200, got %d", response.StatusCode)
+	}
+}
+
+func TestEndpoints_writeUnauthenticated(t *testing.T) {
+	rules := filepath.Join(t.TempDir(), "write-authz")
+	if err := os.WriteFile(rules, []byte("*: *\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.LoadWriteAuthz(rules); err != nil {
+		t.Fatal(err)
+	}
+	defer app.LoadWriteAuthz("")
+	root := endpointTree.WriteDir(t)
+	props := app.DefaultProperties()
+	props.SetRoot(root)
+
+	// Without credentials configured, no one may change files.
+	ts := newServer(t, props)
+	for _, request := range []struct{ method, target string }{
+		{http.MethodDelete, "/file?name=nginx/access.log"},
+		{http.MethodPost, "/truncate?name=nginx/access.log"},
+	} {
+		response, body := fetch(t, ts, request.method, request.target)
+		checkErrorEnvelope(t, request.method+" "+request.target, response, body, http.StatusForbidden, app.CodeAccessDenied)
+	}
+	if _, err := os.Stat(filepath.Join(root, "nginx", "access.log")); err != nil {
+		t.Errorf("expected the file kept, got %v", err)
+	}
+
+	// With them, an authenticated principal may.
+	ts = newServer(t, withToken(props))
+	if response, body := fetch(t, ts, http.MethodDelete, "/file?name=nginx/access.log"); response.StatusCode != http.StatusOK {
+		t.Errorf("authenticated DELETE: expected 200, got %d %s", response.StatusCode, body)
+	}
+}
+
+func TestEndpoints_audit(t *testing.T) {
+	props := app.DefaultProperties()
+	props.SetRoot(endpointTree.WriteDir(t))
+	ts := newServer(t, withToken(props))
+	fetch(t, ts, http.MethodGet, "/read?name=nginx/error.log")
+	fetch(t, ts, http.MethodGet, "/read?name=nginx/missing.log")
+	response, body := fetch(t, ts, http.MethodGet, "/audit?path=nginx&since=1m")
+	var r struct {
+		Entries []struct {
+			Endpoint string `json:"endpoint"`
+			Path     string `json:"path"`
+			Status   int    `json:"status"`
+		} `json:"entries"`
+	}
+	if err := json.Unmarshal([]byte(body), &r); err != nil {
+		t.Fatalf("bad JSON %q: %s", body, err)
+	}
+	if response.StatusCode != http.StatusOK || len(r.Entries) < 2 ||
+		r.Entries[0].Path != "nginx/missing.log" || r.Entries[0].Status != http.StatusNotFound ||
+		r.Entries[1].Path != "nginx/error.log" || r.Entries[1].Endpoint != "/read" {
+		t.Errorf("GET /audit: expected both reads, newest first, got %d %s", response.StatusCode, body)
+	}
+
+	response, body = fetch(t, ts, http.MethodGet, "/audit?since=tomorrow")
+	checkErrorEnvelope(t, "GET /audit", response, body, http.StatusBadRequest, app.CodeInvalidParam)
+}
+
+func TestEndpoints_metrics(t *testing.T) {
+	props := app.DefaultProperties()
+	props.SetRoot(endpointTree.WriteDir(t))
+	ts := newServer(t, withToken(props))
+	fetch(t, ts, http.MethodGet, "/list")
+	response, body := fetch(t, ts, http.MethodGet, "/metrics")
+	if response.StatusCode != http.StatusOK || !strings.HasPrefix(response.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("GET /metrics: expected 200 text, got %d %q", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+	for _, want := range []string{
+		"# TYPE varlog_requests_total counter\n",
+		`varlog_requests_total{endpoint="/list",outcome="2xx"} `,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("GET /metrics: expected %q, got %q", want, body)
+		}
+	}
+}
+
+func TestEndpoints_errors(t *testing.T) {
+	ts := newTestServer(t)
+	tests := []struct {
+		method string
+		target string
+		status int
+		code   string
+	}{
+		{http.MethodGet, "/read?name=missing.log", http.StatusNotFound, app.CodeNotFound},
+		{http.MethodGet, "/read?name=nginx/missing.log/x", http.StatusNotFound, app.CodeNotFound},
+		{http.MethodGet, "/read?name=old", http.StatusBadRequest, app.CodeInvalidParam},
+		{http.MethodGet, "/read?name=../etc/passwd", http.StatusBadRequest, app.CodeInvalidParam},
+		{http.MethodGet, "/read?name=app.log&count=x", http.StatusBadRequest, app.CodeInvalidParam},
+		{http.MethodGet, "/read?name=app.log&count-unit=page", http.StatusBadRequest, app.CodeInvalidParam},
+		{http.MethodGet, "/list?name=missing", http.StatusNotFound, app.CodeNotFound},
+		{http.MethodPost, "/read?name=app.log", http.StatusMethodNotAllowed, app.CodeMethodNotAllowed},
+		{http.MethodDelete, "/list", http.StatusMethodNotAllowed, app.CodeMethodNotAllowed},
+	}
+	for _, test := range tests {
+		response, body := fetch(t, ts, test.method, test.target)
+		checkErrorEnvelope(t, test.method+" "+test.target, response, body, test.status, test.code)
+	}
+
+	response, body := fetch(t, ts, http.MethodHead, "/read?name=app.log")
+	if response.StatusCode != http.StatusOK || body != "" {
+		t.Errorf("HEAD /read: expected 200 without a body, got %d %q", response.StatusCode, body)
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"varlog/service/app"
+	"varlog/service/jobs"
+)
+
+// Background reads, see package jobs.  POST /jobs with a target, as
+// /read?name=nginx&filter=%20500, runs the target's endpoint as a job,
+// keeping its response for /jobs/ID/result, so a scan of a large tree
+// outlives proxy timeouts.  The job runs as the client, so
+// authorization applies as for the target itself, and holds a read
+// slot while it scans.  Results are metered as they are downloaded.
+
+const paramJobTarget = "target" // Name of the POST /jobs 'target' parameter
+
+// jobsHandler starts a background read (POST), or lists the client's
+// jobs, as jobs.Handler does.
+func (s *Server) jobsHandler(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		jobs.Handler(writer, request)
+		return
+	}
+	if err := request.ParseForm(); err != nil {
+		app.Error(writer, request, err.Error(), http.StatusBadRequest)
+		return
+	}
+	target := request.Form.Get(paramJobTarget)
+	u, err := url.Parse(target)
+	if err != nil || u.IsAbs() || countHandlers[u.Path] == nil {
+		app.WriteError(writer, request, app.ParamError(paramJobTarget,
+			fmt.Sprintf("Target %q not /read, /aggregate, or /top, with parameters", target)))
+		return
+	}
+	if app.Maintenance() {
+		app.Log(app.LogWarning, "Maintenance mode, rejecting job %q", target)
+		writer.Header().Set(app.HdrRetryAfter, app.RetryAfterSeconds())
+		app.Error(writer, request, "Service in maintenance mode", http.StatusServiceUnavailable)
+		return
+	}
+	handler := countHandlers[u.Path]
+	props := app.RequestProperties(request)
+	principal := app.PrincipalFrom(request.Context())
+	job, err := jobs.Start(strings.TrimPrefix(u.Path, "/"), principal, func(ctx context.Context, id string) (string, error) {
+		// The job scans as the target's request would, so it holds
+		// a read slot, as limitReads gives requests.
+		if err := jobs.AcquireRead(ctx); err != nil {
+			return "", err
+		}
+		defer app.ReleaseRead()
+		background, err := jobs.Request(ctx, request, u.RequestURI())
+		if err != nil {
+			return "", err
+		}
+		if err := jobs.Serve(id, handler, background); err != nil {
+			return "", err
+		}
+		return props.BaseURLPath() + "/jobs/" + id + "/result", nil
+	})
+	if err != nil {
+		writer.Header().Set(app.HdrRetryAfter, app.RetryAfterSeconds())
+		app.WriteError(writer, request, err)
+		return
+	}
+	writer.Header().Set("Location", props.BaseURLPath()+"/jobs/"+job.ID)
+	jobs.Write(writer, http.StatusAccepted, job)
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"varlog/service/app"
+	"varlog/service/jobs"
+)
+
+func TestJobs(t *testing.T) {
+	props := app.DefaultProperties()
+	props.SetRoot(endpointTree.WriteDir(t))
+	ts := newServer(t, props)
+
+	start := "/jobs?" + url.Values{"target": {"/read?name=nginx/access.log&filter=404"}}.Encode()
+	response, body := fetch(t, ts, http.MethodPost, start)
+	var job jobs.Job
+	if response.StatusCode != http.StatusAccepted || json.Unmarshal([]byte(body), &job) != nil || job.Kind != "read" {
+		t.Fatalf("start: expected 202 with a read job, got %d %q", response.StatusCode, body)
+	}
+	if location := response.Header.Get("Location"); location != "/jobs/"+job.ID {
+		t.Errorf("expected Location /jobs/%s, got %q", job.ID, location)
+	}
+	for deadline := time.Now().Add(5 * time.Second); job.Status == jobs.StatusRunning && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+		_, body = fetch(t, ts, http.MethodGet, "/jobs/"+job.ID)
+		json.Unmarshal([]byte(body), &job)
+	}
+	if job.Status != jobs.StatusDone || job.Result != "/jobs/"+job.ID+"/result" {
+		t.Fatalf("expected done, got %+v", job)
+	}
+	if response, body := fetch(t, ts, http.MethodGet, job.Result); response.StatusCode != http.StatusOK || body != "GET /b 404\n" {
+		t.Errorf("result: expected the matching line, got %d %q", response.StatusCode, body)
+	}
+	if _, body := fetch(t, ts, http.MethodGet, "/jobs"); !strings.Contains(body, job.ID) {
+		t.Errorf("list: expected the job, got %q", body)
+	}
+
+	for _, target := range []string{"/list", "https://example.com/read", ""} {
+		start := "/jobs?" + url.Values{"target": {target}}.Encode()
+		if response, _ := fetch(t, ts, http.MethodPost, start); response.StatusCode != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d", target, response.StatusCode)
+		}
+	}
+}
+
+func TestJobs_limits(t *testing.T) {
+	quotas := filepath.Join(t.TempDir(), "quotas")
+	if err := os.WriteFile(quotas, []byte("jobber: requests/hour=2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	props := app.DefaultProperties()
+	props.SetRoot(endpointTree.WriteDir(t))
+	props.SetQuotaFile(quotas)
+	props.SetAuthTokens([]string{"jobber:" + testToken}) // Its own usage
+	ts := newServer(t, props)
+	start := "/jobs?" + url.Values{"target": {"/read?name=nginx/access.log"}}.Encode()
+
+	// Maintenance mode refuses new jobs, as it does reads.
+	app.SetMaintenance(true)
+	response, body := fetch(t, ts, http.MethodPost, start)
+	app.SetMaintenance(false)
+	if response.StatusCode != http.StatusServiceUnavailable || response.Header.Get(app.HdrRetryAfter) == "" {
+		t.Errorf("maintenance: expected 503 with Retry-After, got %d %q", response.StatusCode, body)
+	}
+
+	// Starting jobs and fetching results count against the quota.
+	response, body = fetch(t, ts, http.MethodPost, start)
+	var job jobs.Job
+	if response.StatusCode != http.StatusAccepted || json.Unmarshal([]byte(body), &job) != nil {
+		t.Fatalf("start: expected 202, got %d %q", response.StatusCode, body)
+	}
+	if response, body := fetch(t, ts, http.MethodGet, "/jobs/"+job.ID+"/result"); response.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("result beyond the quota: expected 429, got %d %q", response.StatusCode, body)
+	}
+	jobs.Stop(context.Background())
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"varlog/service/app"
+	"varlog/service/audit"
+	"varlog/service/auth"
+	"varlog/service/quota"
+	"varlog/service/stats"
+	"varlog/service/tracing"
+)
+
+// Middleware wraps a handler with a cross-cutting concern, such as
+// logging or authentication.  Requests pass through middleware in the
+// order given to Chain, outermost first.
+//
+// The server applies middleware at two levels.  Every request passes
+// through the server-wide chain: access logging, the network access
+// lists, panic recovery, any middleware added with Use, and compression.  Each endpoint then
+// has its own chain, given when it is registered with Handle; for
+// example, /read adds method restriction, statistics, authentication,
+// and read limits.
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps the handler in the middleware, the first outermost.
+func Chain(handler http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// recovery turns a panic in a handler into a 500 response and an
+// error log entry with the stack, rather than a dropped connection.
+// http.ErrAbortHandler passes through, as the http package intends.
+func recovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			app.Log(app.LogError, "panic serving %q: %v\n%s", request.URL, p, debug.Stack())
+			app.Error(writer, request, "Internal server error", http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// methods restricts an endpoint to the given HTTP methods.
+// Others get 405 with an Allow header listing the methods accepted.
+func methods(allowed ...string) Middleware {
+	allow := strings.Join(allowed, ", ")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			for _, method := range allowed {
+				if request.Method == method {
+					next.ServeHTTP(writer, request)
+					return
+				}
+			}
+			writer.Header().Set("Allow", allow)
+			app.Error(writer, request, "Method not allowed", http.StatusMethodNotAllowed)
+		})
+	}
+}
+
+// authenticated requires credentials, when authentication is enabled.
+// See auth.Wrap.
+func authenticated(next http.Handler) http.Handler {
+	return auth.Wrap(next.ServeHTTP)
+}
+
+// administrative guards the /admin endpoints, which can stop reads,
+// flush caches, and change logging, /file and /truncate, which change
+// logs, and /audit and /metrics, which name clients.  They need some
+// way to identify the caller: with no credentials configured and no
+// client certificate, they get 403 rather than serving anyone who
+// reaches the port.
+// It goes before authenticated.
+func administrative(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if !auth.Enabled() && auth.ClientCN(request) == "" {
+			app.Log(app.LogWarning, "No credentials configured, refusing %s %q", request.Method, request.URL)
+			app.Error(writer, request, "Administration requires authentication to be configured", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// audited records requests in the audit trail.  It goes before
+// authenticated, so failed authentication is recorded.  See audit.Wrap.
+func audited(next http.Handler) http.Handler {
+	return audit.Wrap(next.ServeHTTP)
+}
+
+// metered enforces the quotas of the authenticated principal and
+// counts its usage.  It goes after authenticated.  See quota.Wrap.
+func metered(next http.Handler) http.Handler {
+	return quota.Wrap(next.ServeHTTP)
+}
+
+// traced records a span for each request to an endpoint, continuing
+// the caller's trace.  See tracing.Wrap.
+func traced(endpoint string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return tracing.Wrap(endpoint, next.ServeHTTP)
+	}
+}
+
+// counted records statistics for an endpoint.  See stats.Wrap.
+func counted(endpoint string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return stats.Wrap(endpoint, next.ServeHTTP)
+	}
+}
+
+// limitReads protects the host from expensive reads: in maintenance
+// mode new reads get 503, and beyond -max-concurrent-reads they get 429.
+// Both carry Retry-After.  A slot is held until the handler returns.
+func limitReads(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if app.Maintenance() {
+			app.Log(app.LogWarning, "Maintenance mode, rejecting %q", request.URL)
+			writer.Header().Set(app.HdrRetryAfter, app.RetryAfterSeconds())
+			app.Error(writer, request, "Service in maintenance mode", http.StatusServiceUnavailable)
+			return
+		}
+		if !app.AcquireRead() {
+			app.Log(app.LogWarning, "Too many concurrent reads, rejecting %q", request.URL)
+			writer.Header().Set(app.HdrRetryAfter, app.RetryAfterSeconds())
+			app.Error(writer, request, "Too many concurrent reads", http.StatusTooManyRequests)
+			return
+		}
+		defer app.ReleaseRead()
+		next.ServeHTTP(writer, request)
+	})
+}
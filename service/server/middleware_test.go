@@ -0,0 +1,259 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"varlog/service/app"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	tag := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	handler := Chain(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		order = append(order, "handler")
+	}), tag("outer"), tag("inner"))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if strings.Join(order, ",") != "outer,inner,handler" {
+		t.Errorf("expected outer,inner,handler, got %v", order)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	handler := recovery(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	}))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/read", nil))
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", recorder.Code)
+	}
+}
+
+func TestMethods(t *testing.T) {
+	handler := methods(http.MethodGet, http.MethodHead)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	tests := []struct {
+		method string
+		status int
+	}{
+		{http.MethodGet, http.StatusOK},
+		{http.MethodHead, http.StatusOK},
+		{http.MethodPost, http.StatusMethodNotAllowed},
+		{http.MethodDelete, http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(test.method, "/read", nil))
+		if recorder.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.method, test.status, recorder.Code)
+		}
+		if test.status == http.StatusMethodNotAllowed && recorder.Header().Get("Allow") != "GET, HEAD" {
+			t.Errorf("%s: expected Allow \"GET, HEAD\", got %q", test.method, recorder.Header().Get("Allow"))
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	text := strings.Repeat("2023/02/17 10:28:24 INFO abcde\n", 100)
+	handler := compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, text)
+	}))
+	tests := []struct {
+		acceptEncoding string
+		gzipped        bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip;q=0.5", true},
+		{"gzip;q=0", false},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest("GET", "/read", nil)
+		request.Header.Set("Accept-Encoding", test.acceptEncoding)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		gzipped := recorder.Header().Get("Content-Encoding") == "gzip"
+		if gzipped != test.gzipped {
+			t.Errorf("Accept-Encoding %q: expected gzip %v, got %v", test.acceptEncoding, test.gzipped, gzipped)
+			continue
+		}
+		body := recorder.Body.String()
+		if gzipped {
+			reader, err := gzip.NewReader(recorder.Body)
+			if err != nil {
+				t.Fatalf("gzip.NewReader: %s", err)
+			}
+			b, _ := io.ReadAll(reader)
+			body = string(b)
+		}
+		if body != text {
+			t.Errorf("Accept-Encoding %q: body changed", test.acceptEncoding)
+		}
+	}
+}
+
+func TestForwarded(t *testing.T) {
+	tests := []struct {
+		forwardedFor   string
+		forwardedProto string
+		client         string
+		proto          string
+	}{
+		{"", "", "", "http"},
+		{"10.1.2.3", "https", "10.1.2.3", "https"},
+		{" 10.1.2.3 , 10.0.0.1", "HTTPS, http", "10.1.2.3", "https"},
+		{"", "gopher", "", "http"},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest("GET", "/read", nil)
+		request.Header.Set("X-Forwarded-For", test.forwardedFor)
+		request.Header.Set("X-Forwarded-Proto", test.forwardedProto)
+		if client := forwardedFor(request); client != test.client {
+			t.Errorf("X-Forwarded-For %q: expected %q, got %q", test.forwardedFor, test.client, client)
+		}
+		if proto := forwardedProto(request); proto != test.proto {
+			t.Errorf("X-Forwarded-Proto %q: expected %q, got %q", test.forwardedProto, test.proto, proto)
+		}
+	}
+}
+
+// waitClients waits for the clients of the group's scans to number n.
+func waitClients(t *testing.T, g *flightGroup, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		count := 0
+		g.mutex.Lock()
+		for _, f := range g.flights {
+			f.mutex.Lock()
+			count += len(f.clients)
+			f.mutex.Unlock()
+		}
+		g.mutex.Unlock()
+		if count == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d clients, got %d", n, count)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCoalesced(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	g := newFlightGroup()
+	handler := g.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set(app.HdrTrailer, app.HdrTruncated)
+		io.WriteString(w, r.URL.Query().Get("name")+"\n")
+		w.Header().Set(app.HdrTruncated, "time")
+	}))
+
+	// Identical requests, with their parameters in any order, share
+	// one scan.
+	targets := []string{"/read?name=app.log&filter=x", "/read?filter=x&name=app.log", "/read?name=app.log&filter=x"}
+	recorders := make([]*httptest.ResponseRecorder, len(targets))
+	var wg sync.WaitGroup
+	for j, target := range targets {
+		recorders[j] = httptest.NewRecorder()
+		wg.Add(1)
+		go func(recorder *httptest.ResponseRecorder, target string) {
+			defer wg.Done()
+			handler.ServeHTTP(recorder, httptest.NewRequest("GET", target, nil))
+		}(recorders[j], target)
+		waitClients(t, g, j+1)
+	}
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("expected 1 scan, got %d", n)
+	}
+	for j, recorder := range recorders {
+		if recorder.Code != http.StatusOK || recorder.Body.String() != "app.log\n" ||
+			recorder.Header().Get("Content-Type") != "text/plain" || recorder.Header().Get(app.HdrTruncated) != "time" {
+			t.Errorf("%s: expected 200 %q with trailer, got %d %q %v", targets[j], "app.log\n", recorder.Code, recorder.Body, recorder.Header())
+		}
+	}
+
+	// Later requests, and those not coalesced, scan for themselves.
+	for _, target := range []string{"/read?name=app.log&filter=x", "/read?name=app.log&follow=true"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("expected 3 scans, got %d", n)
+	}
+}
+
+func TestCoalesced_large(t *testing.T) {
+	// A response beyond what is kept reaches each client whole.
+	chunk := bytes.Repeat([]byte("0123456789abcdef"), 1<<15)
+	release := make(chan struct{})
+	g := newFlightGroup()
+	handler := g.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		for j := 0; j < 3*coalesceBytes/len(chunk); j++ {
+			w.Write(chunk)
+		}
+	}))
+	recorders := []*httptest.ResponseRecorder{httptest.NewRecorder(), httptest.NewRecorder()}
+	var wg sync.WaitGroup
+	for j, recorder := range recorders {
+		wg.Add(1)
+		go func(recorder *httptest.ResponseRecorder) {
+			defer wg.Done()
+			handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/read?name=app.log", nil))
+		}(recorder)
+		waitClients(t, g, j+1)
+	}
+	close(release)
+	wg.Wait()
+	for j, recorder := range recorders {
+		if body := recorder.Body.Bytes(); len(body) != 3*coalesceBytes || !bytes.Equal(body[len(body)-len(chunk):], chunk) {
+			t.Errorf("client %d: expected %d bytes, got %d", j, 3*coalesceBytes, len(body))
+		}
+	}
+	if len(g.flights) != 0 {
+		t.Errorf("expected no scans left, got %d", len(g.flights))
+	}
+}
+
+func TestCoalesced_canceled(t *testing.T) {
+	// The scan stops when its only client goes away.
+	stopped := make(chan struct{})
+	g := newFlightGroup()
+	handler := g.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(stopped)
+		for r.Context().Err() == nil {
+			w.Write([]byte("line\n"))
+		}
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/read?name=app.log", nil).WithContext(ctx))
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the scan to stop")
+	}
+}
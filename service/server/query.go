@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"varlog/service/app"
+)
+
+// Saved queries, see app.Query.  /query/NAME serves the query's
+// target through the target's own endpoint, so its authentication,
+// authorization, audit, and quotas apply as for the target itself.
+// /admin/queries lists (GET), saves (POST), or deletes (DELETE) them.
+// Queries saved through the API last until the server stops.
+
+const (
+	paramQueryName   = "name"   // Name of the /admin/queries 'name' parameter
+	paramQueryTarget = "target" // Name of the /admin/queries 'target' parameter
+)
+
+// queryHandler serves a saved query.
+func (s *Server) queryHandler(writer http.ResponseWriter, request *http.Request) {
+	name := strings.TrimPrefix(request.URL.Path, "/query/")
+	q, ok := app.RequestProperties(request).Query(name)
+	if !ok {
+		app.Error(writer, request, "Not found", http.StatusNotFound)
+		return
+	}
+	target := request.Clone(request.Context())
+	target.URL.Path = q.Endpoint()
+	target.URL.RawPath = ""
+	target.URL.RawQuery = q.Params(request.URL.Query()).Encode()
+	target.RequestURI = target.URL.RequestURI()
+	target.Form, target.PostForm = nil, nil
+	app.Log(app.LogDebug, "Query %q is %s", name, target.RequestURI)
+	s.mux.ServeHTTP(writer, target)
+}
+
+// queriesHandler lists, saves, or deletes saved queries.
+// As with maintenance mode, changes require POST or DELETE.
+func (s *Server) queriesHandler(writer http.ResponseWriter, request *http.Request) {
+	if err := request.ParseForm(); err != nil {
+		app.Error(writer, request, err.Error(), http.StatusBadRequest)
+		return
+	}
+	name := request.Form.Get(paramQueryName)
+	switch request.Method {
+	case http.MethodGet, http.MethodHead:
+
+	case http.MethodPost:
+		q, err := app.NewQuery(name, request.Form.Get(paramQueryTarget))
+		if err != nil {
+			app.Log(app.LogWarning, "%s", err)
+			app.WriteError(writer, request, app.ParamError(paramQueryTarget, err.Error()))
+			return
+		}
+		s.UpdateProperties(func(p *app.Properties) { p.SetQuery(q) })
+		app.Log(app.LogInfo, "Principal %q saved query %q as %s", app.RequestProperties(request).Principal(), q.Name, q.Target)
+
+	case http.MethodDelete:
+		deleted := false
+		s.UpdateProperties(func(p *app.Properties) { deleted = p.DeleteQuery(name) })
+		if !deleted {
+			app.Error(writer, request, "Not found", http.StatusNotFound)
+			return
+		}
+		app.Log(app.LogInfo, "Principal %q deleted query %q", app.RequestProperties(request).Principal(), name)
+
+	default:
+		writer.Header().Set("Allow", "GET, HEAD, POST, DELETE")
+		app.Error(writer, request, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	queries := s.props.Load().Queries()
+	if queries == nil {
+		queries = []app.Query{}
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(writer)
+	encoder.SetEscapeHTML(false) // Targets are URLs, with '&'
+	encoder.Encode(queries)
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"varlog/service/app"
+)
+
+func TestQueries(t *testing.T) {
+	props := app.DefaultProperties()
+	props.SetRoot(endpointTree.WriteDir(t))
+	q, err := app.NewQuery("errors", "/read?name=nginx/access.log&filter=404")
+	if err != nil {
+		t.Fatal(err)
+	}
+	props.SetQuery(q)
+	ts := newServer(t, withToken(props))
+
+	tests := []struct {
+		target   string
+		status   int
+		expected string
+	}{
+		{"/query/errors", http.StatusOK, "GET /b 404\n"},
+		{"/query/errors?filter=200", http.StatusOK, "POST /c 200\nGET /a 200\n"},
+		{"/query/missing", http.StatusNotFound, ""},
+		{"/admin/queries", http.StatusOK, `[{"name":"errors","target":"/read?name=nginx/access.log&filter=404"}]` + "\n"},
+	}
+	for _, test := range tests {
+		response, body := fetch(t, ts, http.MethodGet, test.target)
+		if response.StatusCode != test.status || test.expected != "" && body != test.expected {
+			t.Errorf("%s: expected %d %q, got %d %q", test.target, test.status, test.expected, response.StatusCode, body)
+		}
+	}
+
+	save := "/admin/queries?" + url.Values{"name": {"top"}, "target": {"/top?name=nginx/access.log&extract=fields:3"}}.Encode()
+	if response, body := fetch(t, ts, http.MethodPost, save); response.StatusCode != http.StatusOK || !strings.Contains(body, `"top"`) {
+		t.Errorf("save: expected 200 listing top, got %d %q", response.StatusCode, body)
+	}
+	if response, body := fetch(t, ts, http.MethodGet, "/query/top"); response.StatusCode != http.StatusOK ||
+		!strings.Contains(body, `{"value":"200","count":2}`) {
+		t.Errorf("saved query: expected 200 with the top values, got %d %q", response.StatusCode, body)
+	}
+	for _, bad := range []url.Values{
+		{"name": {"bad name"}, "target": {"/read?name=app.log"}},
+		{"name": {"admin"}, "target": {"/admin/maintenance?enable=true"}},
+		{"name": {"remote"}, "target": {"http://example.com/read"}},
+	} {
+		response, body := fetch(t, ts, http.MethodPost, "/admin/queries?"+bad.Encode())
+		checkErrorEnvelope(t, bad.Encode(), response, body, http.StatusBadRequest, app.CodeInvalidParam)
+	}
+	if response, _ := fetch(t, ts, http.MethodDelete, "/admin/queries?name=errors"); response.StatusCode != http.StatusOK {
+		t.Errorf("delete: expected 200, got %d", response.StatusCode)
+	}
+	if response, _ := fetch(t, ts, http.MethodDelete, "/admin/queries?name=errors"); response.StatusCode != http.StatusNotFound {
+		t.Errorf("delete again: expected 404, got %d", response.StatusCode)
+	}
+	if response, _ := fetch(t, ts, http.MethodGet, "/query/errors"); response.StatusCode != http.StatusNotFound {
+		t.Errorf("deleted query: expected 404, got %d", response.StatusCode)
+	}
+}
@@ -0,0 +1,509 @@
+// Package server provides the varlog HTTP service in a form that
+// host applications can embed.  The varlog-srv command is one such host.
+//
+// A Server moves through a simple lifecycle:
+//
+//	New -> Listen -> Serve -> [Reload ...] -> Drain -> Stop
+//
+// or, to hand the socket to a new binary, Serve -> Upgrade -> Stop;
+// or, more simply, New -> Start -> Shutdown.
+//
+// Host applications attach their own work at each stage with hooks
+// (OnStart, OnReload, OnDrain, OnStop), and run background work, such as
+// watchers, exporters, and cache refreshers, as managed tasks (Go).
+// Managed tasks start when serving starts and are canceled on Stop.
+//
+// Each server owns its configuration, given to New as app.Properties:
+// the command line's (app.NewProperties after app.DoCli) or explicit
+// ones (app.DefaultProperties and its Set methods).  Several servers
+// with different roots can run in one process.  Authentication,
+// authorization, the deny-list, maintenance mode, quotas, and read
+// limits remain process-wide.
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"varlog/service/admin"
+	"varlog/service/app"
+	"varlog/service/audit"
+	"varlog/service/auth"
+	"varlog/service/diff"
+	"varlog/service/export"
+	"varlog/service/federate"
+	"varlog/service/jobs"
+	"varlog/service/journal"
+	"varlog/service/list"
+	"varlog/service/quota"
+	"varlog/service/read"
+	"varlog/service/reclaim"
+	"varlog/service/s3fs"
+	"varlog/service/sshfs"
+	"varlog/service/stats"
+	"varlog/service/tracing"
+	"varlog/service/ui"
+	"varlog/service/usage"
+)
+
+// A lifecycle hook.  Hooks for a stage run in the order registered.
+// OnStart hooks that fail prevent serving; failures at other stages
+// are logged and reported, but the remaining hooks still run.
+type Hook func(ctx context.Context) error
+
+// Server is an embeddable varlog service.
+type Server struct {
+	props      atomic.Pointer[app.Properties] // Snapshot for new requests, see UpdateProperties
+	propsMutex sync.Mutex                     // Serializes UpdateProperties
+	mux        *http.ServeMux
+	middleware []Middleware // Server-wide middleware from Use
+	acl        *netACL      // Network access lists, nil for none
+	http       *http.Server
+	certs      *certReloader
+	cancel     context.CancelFunc // Cancels requests in progress
+	tasks      *taskGroup
+	mutex      sync.Mutex
+	hooks      map[stage][]Hook
+	served     bool
+	addr       net.Addr     // Listening address, once serving
+	listener   net.Listener // Listening socket, once serving, for Upgrade
+	ready      *os.File     // Pipe to report serving to an upgrading process
+	upgraded   bool         // Another process took the socket, see Upgrade
+}
+
+// Lifecycle stages with hooks.
+type stage int
+
+const (
+	stageStart stage = iota
+	stageReload
+	stageDrain
+	stageStop
+)
+
+// New creates a server from the properties: it loads credentials,
+// registers the endpoints, and prepares TLS if configured.
+// The server keeps a copy of the properties; later changes to
+// the caller's value do not affect it.  See UpdateProperties.
+func New(props *app.Properties) (*Server, error) {
+	props = props.Copy()
+	// Load credentials.  Without any, requests are not authenticated.
+	if err := auth.Setup(props); err != nil {
+		return nil, errors.New("authentication setup failed, " + err.Error())
+	}
+	if err := audit.Setup(props); err != nil {
+		return nil, errors.New("audit log setup failed, " + err.Error())
+	}
+	if err := tracing.Setup(props); err != nil {
+		return nil, errors.New("tracing setup failed, " + err.Error())
+	}
+	if err := quota.Setup(props); err != nil {
+		return nil, errors.New("quota setup failed, " + err.Error())
+	}
+	if err := export.Setup(props); err != nil {
+		return nil, errors.New("export setup failed, " + err.Error())
+	}
+	if err := federate.Setup(props); err != nil {
+		return nil, errors.New("peer setup failed, " + err.Error())
+	}
+
+	acl, err := newNetACL(props)
+	if err != nil {
+		return nil, errors.New("network access list setup failed, " + err.Error())
+	}
+
+	s := &Server{
+		mux:   http.NewServeMux(),
+		acl:   acl,
+		tasks: newTaskGroup(),
+		hooks: map[stage][]Hook{},
+	}
+	s.props.Store(props)
+	get := methods(http.MethodGet, http.MethodHead)
+	s.HandleFunc("/list", list.Handler, get, traced("/list"), counted("/list"), audited, authenticated, metered)
+	s.HandleFunc("/read", read.Handler, get, traced("/read"), counted("/read"), audited, authenticated, metered, coalesced(props), limitReads)
+	s.HandleFunc("/aggregate", read.AggregateHandler, get, traced("/aggregate"), counted("/aggregate"), audited, authenticated, metered, limitReads)
+	s.HandleFunc("/top", read.TopHandler, get, traced("/top"), counted("/top"), audited, authenticated, metered, limitReads)
+	if federate.Enabled() {
+		s.HandleFunc("/fleet/list", federate.ListHandler, get, traced("/fleet/list"), counted("/fleet/list"), audited, authenticated, metered)
+		s.HandleFunc("/fleet/read", federate.ReadHandler, get, traced("/fleet/read"), counted("/fleet/read"), audited, authenticated, metered, limitReads)
+	}
+	s.HandleFunc("/diff", diff.Handler, get, traced("/diff"), counted("/diff"), audited, authenticated, metered, limitReads)
+	s.HandleFunc("/usage", usage.Handler, get, traced("/usage"), counted("/usage"), audited, authenticated, metered, limitReads)
+	s.HandleFunc("/export", export.Handler, traced("/export"), counted("/export"), audited, authenticated, metered, limitReads)
+	s.HandleFunc("/jobs", s.jobsHandler, traced("/jobs"), audited, authenticated, metered)
+	s.HandleFunc("/jobs/", jobs.Handler, traced("/jobs"), audited, authenticated, metered)
+	if props.Journal() {
+		s.HandleFunc("/journal", journal.Handler, get, traced("/journal"), counted("/journal"), audited, authenticated, metered, limitReads)
+	}
+	if app.WritesEnabled() {
+		s.HandleFunc("/file", reclaim.FileHandler, traced("/file"), counted("/file"), audited, administrative, authenticated)
+		s.HandleFunc("/truncate", reclaim.TruncateHandler, traced("/truncate"), counted("/truncate"), audited, administrative, authenticated)
+	}
+	s.HandleFunc("/health", admin.HealthHandler, get)
+	s.HandleFunc("/audit", audit.Handler, get, traced("/audit"), audited, administrative, authenticated)
+	s.HandleFunc("/admin/maintenance", admin.MaintenanceHandler, traced("/admin/maintenance"), audited, administrative, authenticated)
+	s.HandleFunc("/admin/stats", stats.Handler, get, traced("/admin/stats"), audited, administrative, authenticated)
+	s.HandleFunc("/metrics", stats.MetricsHandler, get, audited, administrative, authenticated)
+	s.HandleFunc("/admin/cache", admin.CacheHandler, traced("/admin/cache"), audited, administrative, authenticated)
+	s.HandleFunc("/admin/log-level", admin.LogLevelHandler, traced("/admin/log-level"), audited, administrative, authenticated)
+	s.HandleFunc("/admin/queries", s.queriesHandler, traced("/admin/queries"), audited, administrative, authenticated)
+	s.HandleFunc("/query/", s.queryHandler, get)
+	if props.UI() {
+		s.HandleFunc("/", ui.Handler, get)
+	}
+
+	// Requests derive their contexts from this one, so Stop can
+	// cancel long scans that outlast its deadline.
+	base, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.http = &http.Server{
+		Addr:        props.Addr(),
+		BaseContext: func(net.Listener) context.Context { return base },
+	}
+	if props.S3() {
+		fsys, err := s3fs.New(s3fs.Config{Endpoint: props.S3Endpoint(), Region: props.S3Region()})
+		if err != nil {
+			return nil, errors.New("S3 setup failed, " + err.Error())
+		}
+		props.SetFileSystem(fsys)
+	}
+	if hosts := props.SSHHosts(); len(hosts) > 0 {
+		props.SetFileSystem(sshfs.New(hosts))
+	}
+	if props.TLSCert() != "" {
+		config, certs, err := tlsConfig(props)
+		if err != nil {
+			return nil, errors.New("TLS setup failed, " + err.Error())
+		}
+		s.http.TLSConfig = config
+		s.certs = certs
+		s.OnReload(func(context.Context) error { return s.certs.reloadNow() })
+	}
+	s.OnReload(func(context.Context) error { return audit.Reopen() })
+	s.OnReload(func(context.Context) error { return app.ReopenLogFile() })
+	s.OnReload(func(context.Context) error { return quota.Setup(s.props.Load()) })
+	if federate.Enabled() {
+		s.Go("peers", federate.Watch)
+		s.OnReload(federate.Reload)
+	}
+	if props.RetentionSweep() > 0 {
+		s.Go("retention", func(ctx context.Context) error { return reclaim.Sweep(ctx, props) })
+	}
+	if err := s.setupAlerts(props); err != nil {
+		return nil, errors.New("alert setup failed, " + err.Error())
+	}
+	return s, nil
+}
+
+// Handle registers an endpoint with its own middleware, applied
+// inside the server-wide chain.  Hosts can add endpoints before Serve.
+func (s *Server) Handle(pattern string, handler http.Handler, middleware ...Middleware) {
+	s.mux.Handle(pattern, Chain(handler, middleware...))
+}
+
+// HandleFunc registers an endpoint function, as Handle does.
+func (s *Server) HandleFunc(pattern string, handler http.HandlerFunc, middleware ...Middleware) {
+	s.Handle(pattern, handler, middleware...)
+}
+
+// Use adds middleware to the server-wide chain, inside the built-in
+// logging and recovery and outside compression.  Call before Serve.
+func (s *Server) Use(middleware ...Middleware) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.middleware = append(s.middleware, middleware...)
+}
+
+// Handler gives the server's endpoints with the server-wide middleware,
+// for hosts that serve them from their own http.Server or mount them
+// in their own mux.
+func (s *Server) Handler() http.Handler {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	chain := []Middleware{s.withProperties, accessLog}
+	if s.acl != nil {
+		chain = append(chain, s.acl.wrap)
+	}
+	chain = append(chain, recovery)
+	chain = append(chain, s.middleware...)
+	chain = append(chain, compress)
+	return Chain(s.routes(), chain...)
+}
+
+// routes gives the endpoints, under the base URL path if any.
+// Requests outside the base path get 404.
+func (s *Server) routes() http.Handler {
+	prefix := s.props.Load().BaseURLPath()
+	if prefix == "" {
+		return s.mux
+	}
+	mux := http.NewServeMux()
+	mux.Handle(prefix+"/", http.StripPrefix(prefix, s.mux))
+	return mux
+}
+
+// OnStart registers a hook run by Serve, before accepting requests.
+func (s *Server) OnStart(hook Hook) { s.addHook(stageStart, hook) }
+
+// OnReload registers a hook run by Reload, as on SIGHUP.
+func (s *Server) OnReload(hook Hook) { s.addHook(stageReload, hook) }
+
+// OnDrain registers a hook run by Drain, after entering maintenance mode.
+func (s *Server) OnDrain(hook Hook) { s.addHook(stageDrain, hook) }
+
+// OnStop registers a hook run by Stop, before the listener closes.
+func (s *Server) OnStop(hook Hook) { s.addHook(stageStop, hook) }
+
+func (s *Server) addHook(st stage, hook Hook) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.hooks[st] = append(s.hooks[st], hook)
+}
+
+// runHooks runs the hooks for a stage.  With stopOnError, the first
+// failure ends the stage; otherwise all hooks run and the first
+// failure is returned.
+func (s *Server) runHooks(ctx context.Context, st stage, stopOnError bool) error {
+	s.mutex.Lock()
+	hooks := append([]Hook(nil), s.hooks[st]...)
+	s.mutex.Unlock()
+	var first error
+	for _, hook := range hooks {
+		if err := hook(ctx); err != nil {
+			if stopOnError {
+				return err
+			}
+			app.Log(app.LogWarning, "lifecycle hook failed, %s", err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// withProperties gives requests this server's properties.
+// See app.RequestProperties.
+func (s *Server) withProperties(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		next.ServeHTTP(writer, request.WithContext(app.WithProperties(request.Context(), s.props.Load())))
+	})
+}
+
+// Properties gives a copy of the server's properties.
+func (s *Server) Properties() *app.Properties {
+	return s.props.Load().Copy()
+}
+
+// UpdateProperties changes the server's properties while it serves.
+// The update function changes a copy of the current properties, which
+// then replaces them for requests that start afterwards; requests in
+// progress keep the properties they started with.  Updates are
+// applied one at a time.
+//
+// Settings used when the server is created, such as the address,
+// TLS, credentials, and network access lists, keep their values;
+// per-request settings, such as the root and the chunk size, change.
+func (s *Server) UpdateProperties(update func(p *app.Properties)) {
+	s.propsMutex.Lock()
+	defer s.propsMutex.Unlock()
+	props := s.props.Load().Copy()
+	update(props)
+	s.props.Store(props)
+}
+
+// Go registers a managed background task.  Tasks registered before
+// Serve start with it; later tasks start at once.  Stop cancels
+// the task's context and waits for it to return.
+func (s *Server) Go(name string, task Task) {
+	s.tasks.add(name, task)
+}
+
+// Listen gives the listening socket: the one passed by an upgrading
+// process (see Upgrade) or inherited from systemd socket activation,
+// if any, otherwise a new one on the configured address.
+func (s *Server) Listen() (net.Listener, error) {
+	listener, err := s.inheritedListener()
+	if err != nil {
+		return nil, errors.New("upgrade handoff failed, " + err.Error())
+	}
+	if listener != nil {
+		return listener, nil
+	}
+	listener, err = systemdListener()
+	if err != nil {
+		return nil, errors.New("socket activation failed, " + err.Error())
+	}
+	if listener != nil {
+		return listener, nil
+	}
+	return net.Listen("tcp", s.props.Load().Addr())
+}
+
+// Serve runs the OnStart hooks, starts the managed tasks, and serves
+// requests on the listener until Stop.  Returns nil after Stop;
+// otherwise returns the reason serving failed.
+func (s *Server) Serve(listener net.Listener) error {
+	s.mutex.Lock()
+	if s.served {
+		s.mutex.Unlock()
+		return errors.New("server already started")
+	}
+	s.served = true
+	s.mutex.Unlock()
+
+	if err := s.start(listener); err != nil {
+		return err
+	}
+	return s.serve(listener)
+}
+
+// Start listens and serves in the background, returning once the
+// server accepts requests.  Serving errors after that are logged.
+// Use Addr for the listening address and Shutdown to stop.
+func (s *Server) Start() error {
+	listener, err := s.Listen()
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	if s.served {
+		s.mutex.Unlock()
+		listener.Close()
+		return errors.New("server already started")
+	}
+	s.served = true
+	s.mutex.Unlock()
+
+	if err := s.start(listener); err != nil {
+		return err
+	}
+	go func() {
+		if err := s.serve(listener); err != nil {
+			app.Log(app.LogError, "serving failed, %s", err)
+		}
+	}()
+	return nil
+}
+
+// Addr gives the address the server listens on, or nil before serving.
+// With a port of zero in the configured address, this gives
+// the actual port.
+func (s *Server) Addr() net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.addr
+}
+
+// Shutdown drains and stops the server.  See Drain and Stop.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.Drain(ctx)
+	return s.Stop(ctx)
+}
+
+// start runs the OnStart hooks and the managed tasks, preparing
+// to serve on the listener.
+func (s *Server) start(listener net.Listener) error {
+	if err := s.runHooks(context.Background(), stageStart, true); err != nil {
+		listener.Close()
+		return errors.New("start hook failed, " + err.Error())
+	}
+	s.mutex.Lock()
+	s.addr = listener.Addr()
+	s.listener = listener
+	s.mutex.Unlock()
+	s.http.Handler = s.Handler()
+	s.tasks.start()
+	return nil
+}
+
+// serve accepts requests until Stop.
+func (s *Server) serve(listener net.Listener) error {
+	// The handlers are registered and the socket is listening,
+	// so the service is ready for requests.
+	if err := sdNotify("READY=1"); err != nil {
+		app.Log(app.LogWarning, "sd_notify failed, %s", err)
+	}
+	s.reportReady()
+	var err error
+	if s.http.TLSConfig != nil {
+		app.Log(app.LogInfo, "starting HTTPS on %s, root %s", listener.Addr(), describeRoots(s.props.Load()))
+		err = s.http.ServeTLS(listener, "", "")
+	} else {
+		app.Log(app.LogInfo, "starting on %s, root %s", listener.Addr(), describeRoots(s.props.Load()))
+		err = s.http.Serve(listener)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// describeRoots gives the served directories for the startup log.
+func describeRoots(props *app.Properties) string {
+	if props.S3() {
+		return strconv.Quote("s3:/" + props.Root())
+	}
+	if len(props.Mounts()) == 0 {
+		return strconv.Quote(props.Root())
+	}
+	var mounts []string
+	for _, m := range props.Mounts() {
+		mounts = append(mounts, m.Name+"="+strconv.Quote(m.Path))
+	}
+	return strings.Join(mounts, ", ")
+}
+
+// Reload runs the OnReload hooks.  With TLS, the first hook rereads
+// the certificate and key; the next reopens the audit log, for logrotate,
+// and the last rereads the -quota-file.
+func (s *Server) Reload(ctx context.Context) error {
+	sdNotify("RELOADING=1")
+	defer sdNotify("READY=1")
+	app.Log(app.LogInfo, "reloading")
+	return s.runHooks(ctx, stageReload, false)
+}
+
+// Drain enters maintenance mode, so /health reports draining and
+// new reads are refused, then runs the OnDrain hooks.
+// Requests in progress continue.
+func (s *Server) Drain(ctx context.Context) error {
+	app.Log(app.LogInfo, "draining")
+	app.SetMaintenance(true)
+	return s.runHooks(ctx, stageDrain, false)
+}
+
+// Stop runs the OnStop hooks, stops accepting requests, waits for
+// requests in progress, and cancels the managed tasks and jobs, waiting
+// for them to return.  Then it exports the spans not yet sent.  The context
+// bounds the wait; requests still in progress when it expires are
+// canceled.
+func (s *Server) Stop(ctx context.Context) error {
+	s.mutex.Lock()
+	upgraded := s.upgraded
+	s.mutex.Unlock()
+	if !upgraded {
+		// After an upgrade, the unit's main process is the new one.
+		sdNotify("STOPPING=1")
+	}
+	app.Log(app.LogInfo, "stopping")
+	hookErr := s.runHooks(ctx, stageStop, false)
+	shutdownErr := s.http.Shutdown(ctx)
+	s.cancel()
+	taskErr := s.tasks.stop(ctx)
+	jobErr := jobs.Stop(ctx)
+	traceErr := tracing.Shutdown(ctx)
+	for _, err := range []error{shutdownErr, taskErr, jobErr, hookErr, traceErr} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+	"varlog/service/app"
+)
+
+func TestLifecycle(t *testing.T) {
+	srv, err := New(app.NewProperties())
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	var mutex sync.Mutex
+	var stages []string
+	record := func(name string) Hook {
+		return func(context.Context) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			stages = append(stages, name)
+			return nil
+		}
+	}
+	srv.OnStart(record("start"))
+	srv.OnReload(record("reload"))
+	srv.OnDrain(record("drain"))
+	srv.OnStop(record("stop"))
+	taskDone := make(chan struct{})
+	srv.Go("test", func(ctx context.Context) error {
+		<-ctx.Done()
+		close(taskDone)
+		return ctx.Err()
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	served := make(chan error)
+	go func() { served <- srv.Serve(listener) }()
+
+	response, err := http.Get("http://" + listener.Addr().String() + "/health")
+	if err != nil {
+		t.Fatalf("GET /health: %s", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200, got %d", response.StatusCode)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Reload(ctx)
+	srv.Drain(ctx)
+	defer app.SetMaintenance(false)
+	if err := srv.Stop(ctx); err != nil {
+		t.Errorf("Stop: %s", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("expected Serve to return nil, got %s", err)
+	}
+	select {
+	case <-taskDone:
+	default:
+		t.Errorf("expected task canceled by Stop")
+	}
+	expected := []string{"start", "reload", "drain", "stop"}
+	if !reflect.DeepEqual(stages, expected) {
+		t.Errorf("expected stages %v, got %v", expected, stages)
+	}
+}
+
+func TestStart_explicitProperties(t *testing.T) {
+	defer app.SetMaintenance(false)
+	var servers []*Server
+	for _, text := range []string{"first root\n", "second root\n"} {
+		root := t.TempDir()
+		if err := os.WriteFile(filepath.Join(root, "test.log"), []byte(text), 0600); err != nil {
+			t.Fatal(err)
+		}
+		props := app.DefaultProperties()
+		props.SetRoot(root)
+		props.SetAddr("127.0.0.1:0")
+		srv, err := New(props)
+		if err != nil {
+			t.Fatalf("New: %s", err)
+		}
+		if err := srv.Start(); err != nil {
+			t.Fatalf("Start: %s", err)
+		}
+		servers = append(servers, srv)
+	}
+
+	for i, expected := range []string{"first root\n", "second root\n"} {
+		response, err := http.Get("http://" + servers[i].Addr().String() + "/read?name=test.log")
+		if err != nil {
+			t.Fatalf("GET /read: %s", err)
+		}
+		body, _ := io.ReadAll(response.Body)
+		response.Body.Close()
+		if string(body) != expected {
+			t.Errorf("server %d: expected %q, got %q", i, expected, body)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			t.Errorf("Shutdown: %s", err)
+		}
+	}
+}
+
+func TestBaseURLPath(t *testing.T) {
+	props := app.DefaultProperties()
+	props.SetBaseURLPath("/varlog")
+	srv, err := New(props)
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	handler := srv.Handler()
+	for path, expected := range map[string]int{
+		"/varlog/health": http.StatusOK,
+		"/health":        http.StatusNotFound,
+		"/varlogx":       http.StatusNotFound,
+	} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		if recorder.Code != expected {
+			t.Errorf("%s: expected status %d, got %d", path, expected, recorder.Code)
+		}
+	}
+}
+
+func TestUI(t *testing.T) {
+	props := app.DefaultProperties()
+	props.SetBaseURLPath("/varlog")
+	props.SetUI(true)
+	srv, err := New(props)
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	handler := srv.Handler()
+	for path, expected := range map[string]int{
+		"/varlog/":       http.StatusOK,
+		"/varlog/ui.js":  http.StatusOK,
+		"/varlog/health": http.StatusOK,
+		"/varlog/nope":   http.StatusNotFound,
+		"/":              http.StatusNotFound,
+	} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		if recorder.Code != expected {
+			t.Errorf("%s: expected status %d, got %d", path, expected, recorder.Code)
+		}
+	}
+}
+
+// Updates apply to requests that start afterwards, while requests
+// copy the properties (go test -race).
+func TestUpdateProperties(t *testing.T) {
+	props := app.DefaultProperties()
+	props.SetRoot(t.TempDir())
+	srv, err := New(props)
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	if response, _ := fetch(t, ts, http.MethodGet, "/read?name=app.log"); response.StatusCode != http.StatusNotFound {
+		t.Errorf("before the update: expected status 404, got %d", response.StatusCode)
+	}
+
+	root := endpointTree.WriteDir(t)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 20; j++ {
+			if response, err := http.Get(ts.URL + "/list"); err == nil {
+				response.Body.Close()
+			}
+		}
+	}()
+	srv.UpdateProperties(func(p *app.Properties) { p.SetChunkSize(512) })
+	srv.UpdateProperties(func(p *app.Properties) { p.SetRoot(root) })
+	wg.Wait()
+	if response, _ := fetch(t, ts, http.MethodGet, "/read?name=app.log"); response.StatusCode != http.StatusOK {
+		t.Errorf("after the update: expected status 200, got %d", response.StatusCode)
+	}
+	if got := srv.Properties().ChunkSize(); got != 512 {
+		t.Errorf("expected chunk size 512, got %d", got)
+	}
+	if got := props.Root(); got == srv.Properties().Root() {
+		t.Errorf("expected the caller's properties unchanged, got root %s", got)
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+const (
+	// The first file descriptor passed by systemd socket activation.
+	// Descriptors 0, 1, and 2 are stdin, stdout, and stderr.
+	sdListenFdsStart = 3
+)
+
+// systemdListener returns the listening socket inherited from systemd
+// socket activation, if any.  Systemd sets LISTEN_PID to the service's
+// process ID and LISTEN_FDS to the number of sockets passed, starting at
+// file descriptor 3.  Only the first socket is used.
+// Returns a nil listener (and nil error) if the process was not
+// socket activated.
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, nil
+	}
+	// Child processes should not believe they were socket activated.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	file := os.NewFile(uintptr(sdListenFdsStart), "systemd-socket")
+	if file == nil {
+		return nil, errors.New("systemd socket descriptor not valid")
+	}
+	defer file.Close()
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("systemd socket not a listener, %s", err))
+	}
+	return listener, nil
+}
+
+// sdNotify sends a state string, such as "READY=1", to the systemd
+// notification socket named by NOTIFY_SOCKET.  This supports units
+// with Type=notify.  Does nothing if the variable is not set,
+// as when the service is not run by systemd.
+func sdNotify(state string) error {
+	socketName := os.Getenv("NOTIFY_SOCKET")
+	if socketName == "" {
+		return nil
+	}
+	// A leading '@' names a socket in the abstract namespace.
+	if socketName[0] == '@' {
+		socketName = "\x00" + socketName[1:]
+	}
+	addr := &net.UnixAddr{Name: socketName, Net: "unixgram"}
+	conn, err := net.DialUnix(addr.Net, nil, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"varlog/service/app"
+)
+
+// A background task, such as a file watcher, exporter, or cache
+// refresher.  A task runs until it finishes or its context is canceled.
+// It should return promptly after cancellation.
+type Task func(ctx context.Context) error
+
+// taskGroup manages the server's background tasks.  Tasks added before
+// the group starts wait until it does; tasks added later start at once.
+// Stopping the group cancels every task and waits for all to return.
+type taskGroup struct {
+	mutex   sync.Mutex
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wait    sync.WaitGroup
+	pending map[string]Task
+	order   []string
+	started bool
+	stopped bool
+}
+
+func newTaskGroup() *taskGroup {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &taskGroup{ctx: ctx, cancel: cancel, pending: map[string]Task{}}
+}
+
+// add registers a task, running it now if the group has started.
+// Tasks added after the group stops are ignored.
+func (g *taskGroup) add(name string, task Task) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	switch {
+	case g.stopped:
+		app.Log(app.LogWarning, "task %q not started, server stopped", name)
+	case g.started:
+		g.run(name, task)
+	default:
+		if _, ok := g.pending[name]; !ok {
+			g.order = append(g.order, name)
+		}
+		g.pending[name] = task
+	}
+}
+
+// start runs the pending tasks, in the order added.
+func (g *taskGroup) start() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.started || g.stopped {
+		return
+	}
+	g.started = true
+	for _, name := range g.order {
+		g.run(name, g.pending[name])
+	}
+	g.pending = nil
+	g.order = nil
+}
+
+// run starts one task.  The caller holds the mutex.
+func (g *taskGroup) run(name string, task Task) {
+	g.wait.Add(1)
+	go func() {
+		defer g.wait.Done()
+		app.Log(app.LogDebug, "task %q started", name)
+		err := task(g.ctx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			app.Log(app.LogError, "task %q failed, %s", name, err)
+			return
+		}
+		app.Log(app.LogDebug, "task %q finished", name)
+	}()
+}
+
+// stop cancels all tasks and waits for them, or for ctx to expire.
+func (g *taskGroup) stop(ctx context.Context) error {
+	g.mutex.Lock()
+	g.stopped = true
+	g.mutex.Unlock()
+	g.cancel()
+	done := make(chan struct{})
+	go func() {
+		g.wait.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+	"varlog/service/app"
+)
+
+const (
+	// Minimum time between checks of the certificate files.
+	// Handshakes can be frequent; a stat per handshake is not needed.
+	tlsCheckInterval = 5 * time.Second
+)
+
+// certReloader supplies the server certificate for TLS handshakes.
+// If reloading is enabled, the certificate and key files are checked
+// periodically and reloaded when either modification time changes.
+// A failed reload keeps the previous certificate, so a partially
+// written file (cert updated, key not yet) does not take the service down.
+type certReloader struct {
+	certFile  string
+	keyFile   string
+	reload    bool
+	mutex     sync.Mutex
+	cert      *tls.Certificate
+	certMod   time.Time
+	keyMod    time.Time
+	lastCheck time.Time
+}
+
+// newCertReloader loads the initial certificate.  An error here
+// is fatal to the caller: the service has no certificate to present.
+func newCertReloader(certFile string, keyFile string, reload bool) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile, reload: reload}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load reads the certificate and key files, replacing the
+// current certificate on success.
+func (c *certReloader) load() error {
+	certMod, keyMod := c.modTimes()
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert = &cert
+	c.certMod = certMod
+	c.keyMod = keyMod
+	return nil
+}
+
+// modTimes gives the modification times of the certificate and key
+// files.  A file that cannot be examined gives the zero time.
+func (c *certReloader) modTimes() (certMod time.Time, keyMod time.Time) {
+	if fileInfo, err := os.Stat(c.certFile); err == nil {
+		certMod = fileInfo.ModTime()
+	}
+	if fileInfo, err := os.Stat(c.keyFile); err == nil {
+		keyMod = fileInfo.ModTime()
+	}
+	return certMod, keyMod
+}
+
+// getCertificate implements tls.Config.GetCertificate.
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.reload || time.Since(c.lastCheck) < tlsCheckInterval {
+		return c.cert, nil
+	}
+	c.lastCheck = time.Now()
+	certMod, keyMod := c.modTimes()
+	if certMod.Equal(c.certMod) && keyMod.Equal(c.keyMod) {
+		return c.cert, nil
+	}
+	if err := c.load(); err != nil {
+		app.Log(app.LogWarning, "TLS reload failed, keeping previous certificate: %s", err)
+		return c.cert, nil
+	}
+	app.Log(app.LogInfo, "TLS certificate reloaded from %q", c.certFile)
+	return c.cert, nil
+}
+
+// reloadNow rereads the certificate and key files regardless of
+// modification times, as on SIGHUP.  On failure the previous
+// certificate stays in use.
+func (c *certReloader) reloadNow() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lastCheck = time.Now()
+	if err := c.load(); err != nil {
+		return err
+	}
+	app.Log(app.LogInfo, "TLS certificate reloaded from %q", c.certFile)
+	return nil
+}
+
+// tlsConfig builds the server's TLS configuration.
+// The reloader is returned so the server can force a reload.
+func tlsConfig(props *app.Properties) (*tls.Config, *certReloader, error) {
+	reloader, err := newCertReloader(props.TLSCert(), props.TLSKey(), props.TLSReload())
+	if err != nil {
+		return nil, nil, err
+	}
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+	}
+	if props.TLSClientCA() != "" {
+		pool, err := loadCertPool(props.TLSClientCA())
+		if err != nil {
+			return nil, nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, reloader, nil
+}
+
+// loadCertPool reads the PEM certificates for verifying clients.
+func loadCertPool(name string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New(fmt.Sprintf("No PEM certificates in %q", name))
+	}
+	return pool, nil
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"varlog/service/app"
+	"varlog/service/jobs"
+)
+
+// A certificate and its key.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// issue makes a certificate for the common name, signed by the parent,
+// or self-signed as a CA when the parent is nil.
+func issue(t *testing.T, cn string, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+// write stores the certificate, and its key, as PEM files in the
+// directory, giving their names.
+func (c *testCert) write(t *testing.T, dir string, name string) (certFile string, keyFile string) {
+	t.Helper()
+	certFile, keyFile = filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".key")
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// tlsCertificate gives the certificate for presenting in a handshake.
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+func TestTLS_clientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	ca, other := issue(t, "Test CA", nil), issue(t, "Other CA", nil)
+	caFile, _ := ca.write(t, dir, "ca")
+	server := issue(t, "127.0.0.1", ca)
+	certFile, keyFile := server.write(t, dir, "server")
+
+	// No -auth-... options: the client certificate alone identifies the caller.
+	props := app.DefaultProperties()
+	props.SetRoot(endpointTree.WriteDir(t))
+	props.SetTLSCert(certFile)
+	props.SetTLSKey(keyFile)
+	props.SetTLSClientCA(caFile)
+	srv, err := New(props)
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	ts := httptest.NewUnstartedServer(srv.Handler())
+	// The server's configuration, but its certificate fixed: httptest
+	// would otherwise present its own, as no name is sent for 127.0.0.1.
+	ts.TLS = srv.http.TLSConfig.Clone()
+	ts.TLS.Certificates = []tls.Certificate{server.tlsCertificate()}
+	ts.StartTLS()
+	defer ts.Close()
+	defer jobs.Stop(context.Background())
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	// The client presents its certificate, if any, whatever CAs the
+	// server asks for, so the server, not the client, decides.
+	client := func(certs ...tls.Certificate) *http.Client {
+		present := func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if len(certs) == 0 {
+				return &tls.Certificate{}, nil
+			}
+			return &certs[0], nil
+		}
+		return &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, GetClientCertificate: present},
+		}}
+	}
+	get := func(c *http.Client, method string, target string) (*http.Response, string, error) {
+		request, err := http.NewRequest(method, ts.URL+target, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := c.Do(request)
+		if err != nil {
+			return nil, "", err
+		}
+		defer response.Body.Close()
+		body, err := io.ReadAll(response.Body)
+		return response, string(body), err
+	}
+
+	if response, _, err := get(client(), http.MethodGet, "/list"); err == nil {
+		t.Errorf("no client certificate: expected the handshake refused, got %d", response.StatusCode)
+	}
+	stranger := issue(t, "stranger", other).tlsCertificate()
+	if response, _, err := get(client(stranger), http.MethodGet, "/list"); err == nil {
+		t.Errorf("another CA's certificate: expected the handshake refused, got %d", response.StatusCode)
+	}
+
+	ops := client(issue(t, "ops", ca).tlsCertificate())
+	response, body, err := get(ops, http.MethodGet, "/admin/stats")
+	if err != nil {
+		t.Fatalf("GET /admin/stats: %s", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("GET /admin/stats: expected the certificate to pass as administration, got %d %s", response.StatusCode, body)
+	}
+	target := "/jobs?" + url.Values{"target": {"/read?name=nginx/access.log"}}.Encode()
+	response, body, err = get(ops, http.MethodPost, target)
+	if err != nil {
+		t.Fatalf("POST /jobs: %s", err)
+	}
+	if response.StatusCode != http.StatusAccepted || !strings.Contains(body, `"principal":"ops"`) {
+		t.Errorf("POST /jobs: expected a job of principal \"ops\", got %d %s", response.StatusCode, body)
+	}
+}
+
+func TestLoadCertPool(t *testing.T) {
+	dir := t.TempDir()
+	caFile, keyFile := issue(t, "Test CA", nil).write(t, dir, "ca")
+	if _, err := loadCertPool(caFile); err != nil {
+		t.Errorf("%s: %s", caFile, err)
+	}
+	if _, err := loadCertPool(keyFile); err == nil || !strings.Contains(err.Error(), "No PEM certificates") {
+		t.Errorf("a key, not certificates: expected an error, got %v", err)
+	}
+	if _, err := loadCertPool(filepath.Join(dir, "missing.pem")); err == nil {
+		t.Errorf("missing file: expected an error")
+	}
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"varlog/service/app"
+)
+
+// Environment variables naming the descriptors an upgrading process
+// passes to its replacement: the listening socket, and a pipe the new
+// process writes upgradeReady to once it serves.
+const (
+	envListenFD = "VARLOG_LISTEN_FD"
+	envReadyFD  = "VARLOG_READY_FD"
+
+	upgradeReady = "ready\n"
+)
+
+// Upgrade starts a new copy of the executable, with the same arguments
+// and environment, and passes it the listening socket, so a new binary
+// takes over without refusing a connection.  It returns the new
+// process ID once the new process is serving; the caller then stops
+// this server with Stop, which lets requests in progress, such as
+// follow=true reads, finish while new connections go to the new
+// process.  If the new process fails to start, or exits or the context
+// ends before it serves, this server keeps serving and the error is
+// returned.
+//
+// Under systemd, the new process becomes the unit's main process; the
+// unit needs NotifyAccess=all so its notifications are accepted.
+func (s *Server) Upgrade(ctx context.Context) (pid int, err error) {
+	s.mutex.Lock()
+	listener := s.listener
+	s.mutex.Unlock()
+	if listener == nil {
+		return 0, errors.New("server is not serving")
+	}
+	filer, ok := listener.(interface{ File() (*os.File, error) })
+	if !ok {
+		return 0, errors.New(fmt.Sprintf("cannot pass a %T listener", listener))
+	}
+	socket, err := filer.File()
+	if err != nil {
+		return 0, err
+	}
+	defer socket.Close()
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer readyReader.Close()
+
+	// The new process gets the socket as descriptor 3, the pipe as 4.
+	var env []string
+	for _, v := range os.Environ() {
+		if !strings.HasPrefix(v, envListenFD+"=") && !strings.HasPrefix(v, envReadyFD+"=") {
+			env = append(env, v)
+		}
+	}
+	env = append(env, envListenFD+"=3", envReadyFD+"=4")
+	process, err := os.StartProcess(executable, os.Args, &os.ProcAttr{
+		Env:   env,
+		Files: []*os.File{os.Stdin, os.Stdout, os.Stderr, socket, readyWriter},
+	})
+	readyWriter.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	// The pipe gives upgradeReady, or ends when the new process exits.
+	result := make(chan error, 1)
+	go func() {
+		b, _ := io.ReadAll(io.LimitReader(readyReader, int64(len(upgradeReady))))
+		if string(b) != upgradeReady {
+			result <- errors.New("new process exited before serving")
+			return
+		}
+		result <- nil
+	}()
+	select {
+	case err = <-result:
+	case <-ctx.Done():
+		err = errors.New("new process did not serve in time, " + ctx.Err().Error())
+		process.Kill()
+	}
+	if err != nil {
+		go process.Wait()
+		return 0, err
+	}
+	s.mutex.Lock()
+	s.upgraded = true
+	s.mutex.Unlock()
+	if err := sdNotify("MAINPID=" + strconv.Itoa(process.Pid)); err != nil {
+		app.Log(app.LogWarning, "sd_notify failed, %s", err)
+	}
+	pid = process.Pid
+	process.Release()
+	return pid, nil
+}
+
+// inheritedListener returns the listening socket passed by a process
+// upgrading to this one, if any, and keeps the pipe on which to report
+// serving.  Returns a nil listener (and nil error) otherwise.
+func (s *Server) inheritedListener() (net.Listener, error) {
+	value, ok := os.LookupEnv(envListenFD)
+	if !ok {
+		return nil, nil
+	}
+	readyValue := os.Getenv(envReadyFD)
+	// A later upgrade sets them afresh.
+	os.Unsetenv(envListenFD)
+	os.Unsetenv(envReadyFD)
+
+	fd, err := strconv.Atoi(value)
+	if err != nil || fd < 0 {
+		return nil, errors.New(fmt.Sprintf("invalid %s=%q", envListenFD, value))
+	}
+	file := os.NewFile(uintptr(fd), "inherited-socket")
+	if file == nil {
+		return nil, errors.New("inherited socket descriptor not valid")
+	}
+	defer file.Close()
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("inherited socket not a listener, %s", err))
+	}
+	if fd, err := strconv.Atoi(readyValue); err == nil && fd >= 0 {
+		s.ready = os.NewFile(uintptr(fd), "upgrade-ready")
+	}
+	return listener, nil
+}
+
+// reportReady tells the process that upgraded to this one that this
+// one serves, so it can stop accepting.
+func (s *Server) reportReady() {
+	if s.ready == nil {
+		return
+	}
+	if _, err := io.WriteString(s.ready, upgradeReady); err != nil {
+		app.Log(app.LogWarning, "upgrade readiness report failed, %s", err)
+	}
+	s.ready.Close()
+	s.ready = nil
+}
@@ -0,0 +1,80 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"varlog/service/app"
+)
+
+// The new process of an upgrade serves on the socket it is passed,
+// and reports so on the pipe.
+func TestListen_inherited(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	socket, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socket.Close()
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readyReader.Close()
+	// The server takes the descriptors, so give it copies.
+	socketFD, _ := syscall.Dup(int(socket.Fd()))
+	readyFD, _ := syscall.Dup(int(readyWriter.Fd()))
+	readyWriter.Close()
+	t.Setenv(envListenFD, strconv.Itoa(socketFD))
+	t.Setenv(envReadyFD, strconv.Itoa(readyFD))
+
+	props := app.DefaultProperties()
+	props.SetRoot(endpointTree.WriteDir(t))
+	srv, err := New(props)
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start: %s", err)
+	}
+	defer srv.Shutdown(context.Background())
+
+	if b, _ := io.ReadAll(readyReader); string(b) != upgradeReady {
+		t.Errorf("expected %q on the pipe, got %q", upgradeReady, b)
+	}
+	if srv.Addr().String() != listener.Addr().String() {
+		t.Errorf("expected address %s, got %s", listener.Addr(), srv.Addr())
+	}
+	if _, ok := os.LookupEnv(envListenFD); ok {
+		t.Errorf("expected %s to be unset", envListenFD)
+	}
+	response, err := http.Get("http://" + listener.Addr().String() + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200, got %d", response.StatusCode)
+	}
+}
+
+func TestUpgrade_notServing(t *testing.T) {
+	srv, err := New(app.DefaultProperties())
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	if _, err := srv.Upgrade(context.Background()); err == nil {
+		t.Errorf("expected an error before serving")
+	}
+}
@@ -0,0 +1,344 @@
+// Package sshfs serves files on remote hosts over SSH as an io/fs
+// file system, so one varlog-srv can act as a gateway for a small
+// fleet without installing the service on every machine.
+// The file system's top-level directories are the configured hosts:
+// the name "web1/var/log/syslog" is /var/log/syslog on host web1.
+//
+// The package runs the system ssh client, so host keys, identities,
+// jump hosts, and connection sharing come from the service user's
+// ssh configuration.  Remote hosts need only a POSIX shell with GNU
+// readlink, stat, find, and dd, as on common Linux distributions.
+// Connection sharing (ControlMaster and ControlPersist in ssh_config)
+// is strongly recommended: each operation runs one remote command.
+//
+// As on the host's file system (see app/symlink.go), a symbolic link
+// under a host's root must not lead outside it.  Each command that
+// follows links first resolves the root and the path remotely, with
+// readlink, and the path must stay under the root.  Directory listings
+// do not follow links; links appear as such, not as their targets.
+package sshfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Limit on any one remote command.
+	commandTimeout = 30 * time.Second
+)
+
+// Host is a remote host, by the name clients use, the ssh
+// destination ([user@]host or ssh://[user@]host[:port]), and the
+// directory served there.
+type Host struct {
+	Name        string
+	Destination string
+	Root        string
+}
+
+// ParseHost parses name=destination:/root, as in
+// web1=admin@web1.example.com:/var/log.
+func ParseHost(value string) (Host, error) {
+	name, rest, found := strings.Cut(value, "=")
+	i := strings.LastIndex(rest, ":/")
+	if !found || name == "" || i <= 0 || strings.HasPrefix(rest[i+1:], "//") {
+		return Host{}, errors.New(fmt.Sprintf("host %q not name=destination:/root", value))
+	}
+	if strings.ContainsAny(name, "/:") || name == "." || name == ".." {
+		return Host{}, errors.New(fmt.Sprintf("host name %q invalid", name))
+	}
+	destination, root := rest[:i], path.Clean(rest[i+1:])
+	if strings.HasPrefix(destination, "-") {
+		// Would be taken as an ssh option.
+		return Host{}, errors.New(fmt.Sprintf("host %q destination invalid", name))
+	}
+	return Host{Name: name, Destination: destination, Root: root}, nil
+}
+
+// FS is the file system of the configured hosts.
+type FS struct {
+	// The ssh client, "ssh" by default.  Tests substitute a script.
+	Command string
+
+	hosts map[string]Host
+}
+
+// New creates the file system for the hosts.
+func New(hosts []Host) *FS {
+	fsys := &FS{Command: "ssh", hosts: map[string]Host{}}
+	for _, h := range hosts {
+		fsys.hosts[h.Name] = h
+	}
+	return fsys
+}
+
+// resolve divides a file system name into the host and the remote path.
+// Only paths under the host's root are served.
+func (fsys *FS) resolve(op string, name string) (Host, string, error) {
+	if !fs.ValidPath(name) {
+		return Host{}, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	hostName, rest, found := strings.Cut(name, "/")
+	h, ok := fsys.hosts[hostName]
+	p := "/" + rest
+	if !found {
+		p = h.Root
+	}
+	if !ok || (p != h.Root && !strings.HasPrefix(p, h.Root+"/") && h.Root != "/") {
+		return Host{}, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return h, p, nil
+}
+
+// run executes a command on the host, giving its standard output.
+// Remote failures map to fs errors by the message on standard error.
+func (fsys *FS) run(h Host, op string, name string, command string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, fsys.Command, "-o", "BatchMode=yes", h.Destination, "--", command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err == nil {
+		return stdout.Bytes(), nil
+	}
+	message := strings.TrimSpace(stderr.String())
+	switch {
+	case strings.Contains(message, "No such file"), strings.Contains(message, "Not a directory"):
+		err = fs.ErrNotExist
+	case strings.Contains(message, "Permission denied"):
+		err = fs.ErrPermission
+	case message != "":
+		err = errors.New(fmt.Sprintf("%s: %s", h.Name, message))
+	}
+	return stdout.Bytes(), &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// resolving prefixes a command with the remote resolution, links
+// followed, of the host's root and the path, for contained.
+func resolving(h Host, p string, command string) string {
+	return "readlink -evz -- " + quote(h.Root) + " " + quote(p) + " && " + command
+}
+
+// contained verifies the path resolved by resolving stays under the
+// resolved root, giving the rest of the command's output.  A path
+// leading outside the root does not exist, as for resolve.
+func contained(op string, name string, out []byte) ([]byte, error) {
+	fields := bytes.SplitN(out, []byte{0}, 3)
+	if len(fields) != 3 {
+		return nil, &fs.PathError{Op: op, Path: name, Err: errors.New("unexpected readlink output")}
+	}
+	root, real := string(fields[0]), string(fields[1])
+	if real != root && !strings.HasPrefix(real, strings.TrimSuffix(root, "/")+"/") {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return fields[2], nil
+}
+
+// quote makes a string one word for the remote shell.
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Open opens the named file or directory.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	info, err := fsys.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return &file{fsys: fsys, name: name, info: info}, nil
+}
+
+// Stat describes the named file or directory, following links.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	return fsys.stat("stat", name)
+}
+
+func (fsys *FS) stat(op string, name string) (*fileInfo, error) {
+	if name == "." {
+		return &fileInfo{name: ".", mode: fs.ModeDir | 0555}, nil
+	}
+	h, p, err := fsys.resolve(op, name)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(name, "/") {
+		// A host's top is its root, always a directory.
+		return &fileInfo{name: name, mode: fs.ModeDir | 0555}, nil
+	}
+	out, err := fsys.run(h, op, name, resolving(h, p, "stat -L -c '%f %s %Y' -- "+quote(p)))
+	if err == nil {
+		out, err = contained(op, name, out)
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := parseStat(path.Base(name), strings.TrimSpace(string(out)))
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return info, nil
+}
+
+// parseStat parses "mode size mtime": the raw mode in hex, as
+// stat's %f gives it, the size, and seconds since the epoch.
+func parseStat(name string, line string) (*fileInfo, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return nil, errors.New(fmt.Sprintf("unexpected stat output %q", line))
+	}
+	raw, err1 := strconv.ParseUint(fields[0], 16, 32)
+	size, err2 := strconv.ParseInt(fields[1], 10, 64)
+	seconds, err3 := strconv.ParseInt(fields[2], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return nil, errors.New(fmt.Sprintf("unexpected stat output %q", line))
+	}
+	mode := fs.FileMode(raw & 0777)
+	switch raw & 0170000 {
+	case 0040000:
+		mode |= fs.ModeDir
+	case 0100000:
+	default:
+		mode |= fs.ModeIrregular
+	}
+	return &fileInfo{name: name, size: size, modTime: time.Unix(seconds, 0), mode: mode}, nil
+}
+
+// ReadDir reads the named directory, sorted by name.
+// At the top, the entries are the hosts.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	var entries []fs.DirEntry
+	if name == "." {
+		for hostName := range fsys.hosts {
+			entries = append(entries, &fileInfo{name: hostName, mode: fs.ModeDir | 0555})
+		}
+		sortEntries(entries)
+		return entries, nil
+	}
+	h, p, err := fsys.resolve("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	out, err := fsys.run(h, "readdir", name,
+		resolving(h, p, "find "+quote(p)+"/"+` -mindepth 1 -maxdepth 1 -printf '%y %s %T@ %f\0'`))
+	if err == nil {
+		out, err = contained("readdir", name, out)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range bytes.Split(out, []byte{0}) {
+		fields := strings.SplitN(string(record), " ", 4)
+		if len(fields) != 4 {
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[1], 10, 64)
+		seconds, _ := strconv.ParseFloat(fields[2], 64)
+		mode := fs.FileMode(0444)
+		switch fields[0] {
+		case "d":
+			mode = fs.ModeDir | 0555
+		case "f":
+		case "l":
+			mode = fs.ModeSymlink | 0777
+		default:
+			mode |= fs.ModeIrregular
+		}
+		entries = append(entries, &fileInfo{
+			name:    fields[3],
+			size:    size,
+			modTime: time.Unix(int64(seconds), 0),
+			mode:    mode,
+		})
+	}
+	sortEntries(entries)
+	return entries, nil
+}
+
+func sortEntries(entries []fs.DirEntry) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+}
+
+// fileInfo describes a remote file.  It serves as both fs.FileInfo
+// and fs.DirEntry.
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	mode    fs.FileMode
+}
+
+func (fi *fileInfo) Name() string               { return fi.name }
+func (fi *fileInfo) Size() int64                { return fi.size }
+func (fi *fileInfo) ModTime() time.Time         { return fi.modTime }
+func (fi *fileInfo) Mode() fs.FileMode          { return fi.mode }
+func (fi *fileInfo) IsDir() bool                { return fi.mode.IsDir() }
+func (fi *fileInfo) Sys() any                   { return nil }
+func (fi *fileInfo) Type() fs.FileMode          { return fi.mode.Type() }
+func (fi *fileInfo) Info() (fs.FileInfo, error) { return fi, nil }
+
+// file is an open remote file, read with one dd per ReadAt.
+type file struct {
+	fsys   *FS
+	name   string
+	info   *fileInfo
+	offset int64 // For Read
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *file) Close() error {
+	return nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+// ReadAt reads len(p) bytes at the offset.  As io.ReaderAt
+// requires, a short read gives an error: io.EOF at the end.
+func (f *file) ReadAt(p []byte, offset int64) (int, error) {
+	if f.info.IsDir() {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: errors.New("is a directory")}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	h, remote, err := f.fsys.resolve("read", f.name)
+	if err != nil {
+		return 0, err
+	}
+	out, err := f.fsys.run(h, "read", f.name, resolving(h, remote, fmt.Sprintf(
+		"dd if=%s bs=65536 iflag=skip_bytes,count_bytes skip=%d count=%d status=none",
+		quote(remote), offset, len(p))))
+	if err == nil {
+		out, err = contained("read", f.name, out)
+	}
+	if err != nil {
+		return 0, err
+	}
+	n := copy(p, out)
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
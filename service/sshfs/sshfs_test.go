@@ -0,0 +1,142 @@
+package sshfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseHost(t *testing.T) {
+	tests := []struct {
+		value string
+		host  Host
+		ok    bool
+	}{
+		{"web1=admin@web1:/var/log", Host{"web1", "admin@web1", "/var/log"}, true},
+		{"db=ssh://db.example.com:2222:/srv/logs/", Host{"db", "ssh://db.example.com:2222", "/srv/logs"}, true},
+		{"web1=web1", Host{}, false},
+		{"web1=ssh://web1", Host{}, false},
+		{"a/b=web1:/var/log", Host{}, false},
+		{"x=-oProxyCommand=evil:/var/log", Host{}, false},
+	}
+	for _, test := range tests {
+		host, err := ParseHost(test.value)
+		if (err == nil) != test.ok || host != test.host {
+			t.Errorf("%s: expected %+v, %v, got %+v, %v", test.value, test.host, test.ok, host, err)
+		}
+	}
+}
+
+// localFS gives a file system whose "ssh" runs commands locally,
+// serving dir as host "local".
+func localFS(t *testing.T, dir string) *FS {
+	if runtime.GOOS != "linux" {
+		t.Skip("needs GNU readlink, stat, find, and dd")
+	}
+	script := filepath.Join(t.TempDir(), "ssh")
+	// Arguments: -o BatchMode=yes destination -- command
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nshift 4\nexec sh -c \"$1\"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	fsys := New([]Host{{Name: "local", Destination: "localhost", Root: dir}})
+	fsys.Command = script
+	return fsys
+}
+
+func TestFS(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "app.log"), []byte("one\ntwo\nthree\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "it's.log"), []byte("x\n"), 0o644)
+	os.Mkdir(filepath.Join(dir, "old"), 0o755)
+	fsys := localFS(t, dir)
+	top := "local" + dir
+
+	entries, err := fs.ReadDir(fsys, top)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if strings.Join(names, ",") != "app.log,it's.log,old" {
+		t.Errorf("expected app.log, it's.log, old, got %v", names)
+	}
+	if !entries[2].IsDir() {
+		t.Errorf("expected old to be a directory")
+	}
+
+	if _, err := fs.Stat(fsys, top+"/missing.log"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+	if _, err := fs.Stat(fsys, "local/etc/passwd"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("outside root: expected fs.ErrNotExist, got %v", err)
+	}
+
+	f, err := fsys.Open(top + "/app.log")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if info, _ := f.Stat(); info.Size() != 14 || !info.Mode().IsRegular() {
+		t.Errorf("expected regular file of 14 bytes, got %v", info)
+	}
+	r := f.(io.ReaderAt)
+	p := make([]byte, 5)
+	if n, err := r.ReadAt(p, 4); n != 5 || err != nil || string(p) != "two\nt" {
+		t.Errorf("expected \"two\\nt\", got %q, %v", p[:n], err)
+	}
+	if n, err := r.ReadAt(p, 12); n != 2 || err != io.EOF || string(p[:n]) != "e\n" {
+		t.Errorf("expected \"e\\n\" and EOF, got %q, %v", p[:n], err)
+	}
+}
+
+func TestFS_symlinks(t *testing.T) {
+	dir, outside := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(dir, "app.log"), []byte("one\n"), 0o644)
+	os.WriteFile(filepath.Join(outside, "secret"), []byte("key\n"), 0o644)
+	os.Symlink("app.log", filepath.Join(dir, "current.log"))
+	os.Symlink(filepath.Join(outside, "secret"), filepath.Join(dir, "escape.log"))
+	os.Symlink(outside, filepath.Join(dir, "escape"))
+	fsys := localFS(t, dir)
+	top := "local" + dir
+
+	// Links within the root work; links leading outside do not exist.
+	if b, err := fs.ReadFile(fsys, top+"/current.log"); err != nil || string(b) != "one\n" {
+		t.Errorf("current.log: expected \"one\\n\", got %q, %v", b, err)
+	}
+	for _, name := range []string{"escape.log", "escape", "escape/secret"} {
+		if _, err := fs.Stat(fsys, top+"/"+name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: expected fs.ErrNotExist, got %v", name, err)
+		}
+	}
+	if _, err := fs.ReadDir(fsys, top+"/escape"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("escape: expected fs.ErrNotExist listing, got %v", err)
+	}
+
+	// Listings show links as links, not their targets.
+	entries, err := fs.ReadDir(fsys, top)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	for _, e := range entries {
+		if regular := e.Type().IsRegular(); regular != (e.Name() == "app.log") {
+			t.Errorf("%s: expected only app.log regular, got type %v", e.Name(), e.Type())
+		}
+	}
+
+	// A file replaced by a link after opening is checked again on reading.
+	f, err := fsys.Open(top + "/app.log")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	os.Remove(filepath.Join(dir, "app.log"))
+	os.Symlink(filepath.Join(outside, "secret"), filepath.Join(dir, "app.log"))
+	if _, err := f.(io.ReaderAt).ReadAt(make([]byte, 4), 0); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("replaced app.log: expected fs.ErrNotExist, got %v", err)
+	}
+}
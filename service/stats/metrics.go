@@ -0,0 +1,130 @@
+package stats
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// /metrics serves the statistics in the Prometheus text format,
+// so a metrics system can track them over time.  Other packages add
+// their own metrics with AddMetrics.
+
+// A Sample is one value of a metric, with its labels as name, value
+// pairs.
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// A Metric is one metric family: its name, help text, type ("counter"
+// or "gauge"), and samples.
+type Metric struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []Sample
+}
+
+var (
+	sourcesMutex sync.Mutex
+	sources      []func() []Metric
+)
+
+// AddMetrics registers a function giving more metrics for /metrics.
+func AddMetrics(source func() []Metric) {
+	sourcesMutex.Lock()
+	defer sourcesMutex.Unlock()
+	sources = append(sources, source)
+}
+
+// endpointMetrics gives the per-endpoint statistics as metrics.
+func endpointMetrics() []Metric {
+	mutex.Lock()
+	names := make([]string, 0, len(endpoints))
+	for name := range endpoints {
+		names = append(names, name)
+	}
+	mutex.Unlock()
+	sort.Strings(names)
+
+	requests := Metric{Name: "varlog_requests_total", Type: "counter",
+		Help: "Requests by endpoint and outcome: status class, or canceled."}
+	scanned := Metric{Name: "varlog_bytes_scanned_total", Type: "counter",
+		Help: "Bytes of log files read, by endpoint."}
+	hits := Metric{Name: "varlog_cache_hits_total", Type: "counter",
+		Help: "Cache lookups that hit, by endpoint."}
+	misses := Metric{Name: "varlog_cache_misses_total", Type: "counter",
+		Help: "Cache lookups that missed, by endpoint."}
+	for _, name := range names {
+		e := lookup(name)
+		e.mutex.Lock()
+		outcomes := make([]string, 0, len(e.outcomes))
+		for outcome := range e.outcomes {
+			outcomes = append(outcomes, outcome)
+		}
+		sort.Strings(outcomes)
+		for _, outcome := range outcomes {
+			requests.Samples = append(requests.Samples, Sample{
+				Labels: []string{"endpoint", name, "outcome", outcome},
+				Value:  float64(e.outcomes[outcome]),
+			})
+		}
+		labels := []string{"endpoint", name}
+		scanned.Samples = append(scanned.Samples, Sample{labels, float64(e.bytesScanned)})
+		if e.cacheHits+e.cacheMisses > 0 {
+			hits.Samples = append(hits.Samples, Sample{labels, float64(e.cacheHits)})
+			misses.Samples = append(misses.Samples, Sample{labels, float64(e.cacheMisses)})
+		}
+		e.mutex.Unlock()
+	}
+	return []Metric{requests, scanned, hits, misses}
+}
+
+// MetricsHandler serves /metrics.
+func MetricsHandler(writer http.ResponseWriter, request *http.Request) {
+	metrics := endpointMetrics()
+	sourcesMutex.Lock()
+	more := append([]func() []Metric(nil), sources...)
+	sourcesMutex.Unlock()
+	for _, source := range more {
+		metrics = append(metrics, source()...)
+	}
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w := bufio.NewWriter(writer)
+	for _, m := range metrics {
+		writeMetric(w, m)
+	}
+	w.Flush()
+}
+
+// writeMetric writes one metric family in the text format.
+// A family without samples is left out.
+func writeMetric(w io.Writer, m Metric) {
+	if len(m.Samples) == 0 {
+		return
+	}
+	io.WriteString(w, "# HELP "+m.Name+" "+m.Help+"\n")
+	io.WriteString(w, "# TYPE "+m.Name+" "+m.Type+"\n")
+	for _, s := range m.Samples {
+		io.WriteString(w, m.Name)
+		if len(s.Labels) > 0 {
+			io.WriteString(w, "{")
+			for j := 0; j+1 < len(s.Labels); j += 2 {
+				if j > 0 {
+					io.WriteString(w, ",")
+				}
+				io.WriteString(w, s.Labels[j]+`="`+labelEscaper.Replace(s.Labels[j+1])+`"`)
+			}
+			io.WriteString(w, "}")
+		}
+		io.WriteString(w, " "+strconv.FormatFloat(s.Value, 'g', -1, 64)+"\n")
+	}
+}
+
+// Escapes for label values, as the text format requires.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,22 @@
+package stats
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteMetric(t *testing.T) {
+	var b strings.Builder
+	writeMetric(&b, Metric{Name: "varlog_x_total", Help: "Things.", Type: "counter", Samples: []Sample{
+		{Labels: []string{"endpoint", "/read", "principal", "a\"b\nc\\"}, Value: 12},
+		{Value: 1.5},
+	}})
+	writeMetric(&b, Metric{Name: "varlog_empty", Help: "None.", Type: "gauge"})
+	expected := "# HELP varlog_x_total Things.\n" +
+		"# TYPE varlog_x_total counter\n" +
+		`varlog_x_total{endpoint="/read",principal="a\"b\nc\\"} 12` + "\n" +
+		"varlog_x_total 1.5\n"
+	if b.String() != expected {
+		t.Errorf("expected %q, got %q", expected, b.String())
+	}
+}
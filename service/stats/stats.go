@@ -0,0 +1,218 @@
+// Package stats gathers per-endpoint request statistics for capacity
+// planning, served as JSON by /admin/stats.  For each endpoint:
+//
+//   - Request counts by outcome: 2xx, 3xx, 4xx, 5xx, and canceled
+//     (the client went away before the response finished).
+//   - Duration percentiles (p50, p95, p99) over recent requests.
+//   - Bytes scanned from log files.
+//   - Cache hits and misses, for endpoints that use a cache.
+//
+// Handlers report bytes scanned and cache use through the request
+// context; see AddBytesScanned and CacheHit.  /metrics serves the
+// counts to metrics systems; see metrics.go.
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+	"varlog/service/app"
+)
+
+const (
+	// Number of recent durations kept per endpoint for percentiles.
+	durationSamples = 1024
+)
+
+type contextKey int
+
+// The context key of a request's *requestStats, which Wrap records
+// so the handler can add to them.
+const requestKey contextKey = 0
+
+// Statistics for one endpoint.  The mutex covers all fields.
+type endpointStats struct {
+	mutex        sync.Mutex
+	outcomes     map[string]int64
+	durations    []time.Duration // Ring of recent durations
+	next         int             // Next ring index once full
+	bytesScanned int64
+	cacheHits    int64
+	cacheMisses  int64
+}
+
+// Per-request counters, accumulated while the handler runs.
+type requestStats struct {
+	mutex        sync.Mutex
+	bytesScanned int64
+	cacheHits    int64
+	cacheMisses  int64
+}
+
+var (
+	mutex     sync.Mutex
+	endpoints = map[string]*endpointStats{}
+)
+
+// Outcome names in the report.
+const (
+	outcomeCanceled = "canceled"
+)
+
+// Wrap returns a handler that records statistics for the endpoint.
+func Wrap(endpoint string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		t0 := time.Now()
+		rs := new(requestStats)
+		recorder := app.NewResponseRecorder(writer)
+		ctx := context.WithValue(request.Context(), requestKey, rs)
+		handler(recorder, request.WithContext(ctx))
+
+		outcome := outcomeClass(recorder.Status)
+		if request.Context().Err() != nil {
+			outcome = outcomeCanceled
+		}
+		lookup(endpoint).record(outcome, time.Since(t0), rs)
+	}
+}
+
+// AddBytesScanned adds to the bytes of log data scanned for the request.
+func AddBytesScanned(ctx context.Context, n int64) {
+	if rs, ok := ctx.Value(requestKey).(*requestStats); ok {
+		rs.mutex.Lock()
+		rs.bytesScanned += n
+		rs.mutex.Unlock()
+	}
+}
+
+// CacheHit records a cache lookup for the request: hit or miss.
+func CacheHit(ctx context.Context, hit bool) {
+	if rs, ok := ctx.Value(requestKey).(*requestStats); ok {
+		rs.mutex.Lock()
+		if hit {
+			rs.cacheHits++
+		} else {
+			rs.cacheMisses++
+		}
+		rs.mutex.Unlock()
+	}
+}
+
+// lookup finds (or creates) the statistics for an endpoint.
+func lookup(endpoint string) *endpointStats {
+	mutex.Lock()
+	defer mutex.Unlock()
+	e, ok := endpoints[endpoint]
+	if !ok {
+		e = &endpointStats{outcomes: map[string]int64{}}
+		endpoints[endpoint] = e
+	}
+	return e
+}
+
+// record adds one completed request.
+func (e *endpointStats) record(outcome string, d time.Duration, rs *requestStats) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.outcomes[outcome]++
+	if len(e.durations) < durationSamples {
+		e.durations = append(e.durations, d)
+	} else {
+		e.durations[e.next] = d
+		e.next = (e.next + 1) % durationSamples
+	}
+	e.bytesScanned += rs.bytesScanned
+	e.cacheHits += rs.cacheHits
+	e.cacheMisses += rs.cacheMisses
+}
+
+// outcomeClass names the status class: "2xx", "4xx", etc.
+func outcomeClass(status int) string {
+	return string(rune('0'+status/100)) + "xx"
+}
+
+// The JSON report for one endpoint.
+type endpointReport struct {
+	Requests     int64            `json:"requests"`
+	Outcomes     map[string]int64 `json:"outcomes"`
+	DurationMs   map[string]int64 `json:"durationMs"`
+	BytesScanned int64            `json:"bytesScanned"`
+	Cache        *cacheReport     `json:"cache,omitempty"`
+}
+
+type cacheReport struct {
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	HitRate float64 `json:"hitRate"`
+}
+
+// report summarizes the endpoint's statistics.
+func (e *endpointStats) report() endpointReport {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	r := endpointReport{
+		Outcomes:     map[string]int64{},
+		DurationMs:   map[string]int64{},
+		BytesScanned: e.bytesScanned,
+	}
+	for k, v := range e.outcomes {
+		r.Outcomes[k] = v
+		r.Requests += v
+	}
+	sorted := append([]time.Duration(nil), e.durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for name, p := range map[string]float64{"p50": 0.50, "p95": 0.95, "p99": 0.99} {
+		r.DurationMs[name] = percentile(sorted, p).Milliseconds()
+	}
+	if lookups := e.cacheHits + e.cacheMisses; lookups > 0 {
+		r.Cache = &cacheReport{
+			Hits:    e.cacheHits,
+			Misses:  e.cacheMisses,
+			HitRate: float64(e.cacheHits) / float64(lookups),
+		}
+	}
+	return r
+}
+
+// percentile gives the p-th percentile (0 < p <= 1) of sorted durations,
+// using the nearest-rank method.  Zero for no samples.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p*float64(len(sorted))+0.999999) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// Handler serves /admin/stats: a JSON object keyed by endpoint.
+func Handler(writer http.ResponseWriter, request *http.Request) {
+	mutex.Lock()
+	names := make([]string, 0, len(endpoints))
+	for name := range endpoints {
+		names = append(names, name)
+	}
+	mutex.Unlock()
+	report := map[string]endpointReport{}
+	for _, name := range names {
+		report[name] = lookup(name).report()
+	}
+	b, err := json.MarshalIndent(map[string]interface{}{"endpoints": report}, "", "  ")
+	if err != nil {
+		app.Log(app.LogError, "JSON marshal failed: %s", err.Error())
+		app.Error(writer, request, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(b)
+}
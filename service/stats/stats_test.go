@@ -0,0 +1,50 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i))
+	}
+	tests := []struct {
+		samples  []time.Duration
+		p        float64
+		expected time.Duration
+	}{
+		{nil, 0.5, 0},
+		{sorted[:1], 0.99, 1},
+		{sorted, 0.50, 50},
+		{sorted, 0.95, 95},
+		{sorted, 0.99, 99},
+		{sorted[:10], 0.95, 10},
+	}
+	for _, test := range tests {
+		got := percentile(test.samples, test.p)
+		if got != test.expected {
+			t.Errorf("percentile(%d samples, %v): expected %v, got %v",
+				len(test.samples), test.p, test.expected, got)
+		}
+	}
+}
+
+func TestOutcomeClass(t *testing.T) {
+	tests := []struct {
+		status   int
+		expected string
+	}{
+		{200, "2xx"},
+		{206, "2xx"},
+		{304, "3xx"},
+		{404, "4xx"},
+		{503, "5xx"},
+	}
+	for _, test := range tests {
+		if got := outcomeClass(test.status); got != test.expected {
+			t.Errorf("outcomeClass(%d): expected %q, got %q", test.status, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"varlog/service/app"
+)
+
+const (
+	// Spans queued for export; more are dropped until the queue drains.
+	queueSize = 2048
+
+	// Spans sent in one request, and the longest a span waits.
+	batchSize     = 512
+	batchInterval = 5 * time.Second
+
+	// Limit on one export request.
+	exportTimeout = 10 * time.Second
+
+	// Path of the OTLP/HTTP trace service under the collector's URL.
+	tracesPath = "/v1/traces"
+)
+
+// Sends spans to an OTLP/HTTP collector in the background.
+type exporter struct {
+	url      string
+	header   http.Header
+	client   *http.Client
+	resource []attribute
+
+	spans   chan *Span
+	flush   chan chan error
+	dropped atomic.Int64 // Spans dropped since the last export, for the log
+}
+
+// newExporter starts an exporter for the collector at the base URL,
+// sending the headers, each name=value.
+func newExporter(endpoint string, headers []string) (*exporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New(fmt.Sprintf("invalid OTLP endpoint %q", endpoint))
+	}
+	if !strings.HasSuffix(u.Path, tracesPath) {
+		u.Path = strings.TrimSuffix(u.Path, "/") + tracesPath
+	}
+	e := &exporter{
+		url:    u.String(),
+		header: http.Header{},
+		client: &http.Client{Timeout: exportTimeout},
+		spans:  make(chan *Span, queueSize),
+		flush:  make(chan chan error),
+	}
+	for _, h := range headers {
+		name, value, ok := strings.Cut(h, "=")
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("invalid OTLP header %q, expected name=value", h))
+		}
+		e.header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	e.header.Set("Content-Type", "application/json")
+	e.resource = []attribute{{"service.name", "varlog"}}
+	if host, err := os.Hostname(); err == nil {
+		e.resource = append(e.resource, attribute{"host.name", host})
+	}
+	go e.run()
+	return e, nil
+}
+
+// add queues an ended span, dropping it if the queue is full.
+func (e *exporter) add(s *Span) {
+	select {
+	case e.spans <- s:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// run batches spans and sends them, until shutdown.
+func (e *exporter) run() {
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, batchSize)
+	send := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := e.send(batch)
+		if err != nil {
+			app.Log(app.LogWarning, "Export of %d spans failed: %s", len(batch), err.Error())
+		}
+		batch = batch[:0]
+		return err
+	}
+	for {
+		select {
+		case s := <-e.spans:
+			if batch = append(batch, s); len(batch) >= batchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case reply := <-e.flush:
+			// Drain the queue, then send all, ending the exporter.
+			for more := true; more; {
+				select {
+				case s := <-e.spans:
+					if batch = append(batch, s); len(batch) >= batchSize {
+						send()
+					}
+				default:
+					more = false
+				}
+			}
+			reply <- send()
+			return
+		}
+	}
+}
+
+// shutdown sends the queued spans and stops the exporter.
+func (e *exporter) shutdown(ctx context.Context) error {
+	reply := make(chan error, 1)
+	select {
+	case e.flush <- reply:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-reply:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// send posts one batch to the collector.
+func (e *exporter) send(batch []*Span) error {
+	if n := e.dropped.Swap(0); n > 0 {
+		app.Log(app.LogWarning, "Dropped %d spans: the export queue was full", n)
+	}
+	b, err := json.Marshal(e.request(batch))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	request.Header = e.header.Clone()
+	response, err := e.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+	if response.StatusCode/100 != 2 {
+		return errors.New(fmt.Sprintf("collector %s: %s", e.url, response.Status))
+	}
+	return nil
+}
+
+// OTLP JSON encoding of an ExportTraceServiceRequest.  IDs are hex,
+// and 64-bit integers are strings, as the OTLP JSON mapping requires.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            *otlpStatus    `json:"status,omitempty"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"` // 2 is an error
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string                 `json:"key"`
+		Value map[string]interface{} `json:"value"`
+	}
+)
+
+// request encodes a batch of spans.
+func (e *exporter) request(batch []*Span) otlpRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		s.mutex.Lock()
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        keyValues(s.attributes),
+		}
+		if s.parent != ([8]byte{}) {
+			span.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		if s.err != "" {
+			span.Status = &otlpStatus{Code: 2, Message: s.err}
+		}
+		s.mutex.Unlock()
+		spans = append(spans, span)
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: keyValues(e.resource)},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "varlog"}, Spans: spans}},
+	}}}
+}
+
+// keyValues encodes attributes as OTLP AnyValues.
+func keyValues(attributes []attribute) []otlpKeyValue {
+	result := make([]otlpKeyValue, 0, len(attributes))
+	for _, a := range attributes {
+		var value map[string]interface{}
+		switch v := a.value.(type) {
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		case string:
+			value = map[string]interface{}{"stringValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		result = append(result, otlpKeyValue{Key: a.key, Value: value})
+	}
+	return result
+}
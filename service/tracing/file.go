@@ -0,0 +1,77 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"sync"
+	"varlog/service/app"
+)
+
+// Most I/O spans recorded for one file; the rest are counted in the
+// span of the request, so a long scan does not flood the trace.
+const maxIOSpans = 100
+
+// A file whose reads are traced.
+type tracedFile struct {
+	app.File
+	ctx     context.Context
+	mutex   sync.Mutex
+	spans   int   // I/O spans recorded
+	reads   int64 // ReadAt calls
+	bytes   int64 // Bytes read
+	dropped int   // ReadAt calls not given spans
+}
+
+// File wraps a file so each read from the backend, local disk or
+// remote storage, is a span in the request's trace, up to a limit.
+// Close adds the totals to the request's span.  Outside a sampled
+// trace, the file is returned unchanged.
+func File(ctx context.Context, file app.File) app.File {
+	parent, ok := ctx.Value(spanKey).(*Span)
+	if !ok || parent == nil || !parent.sampled || !Enabled() {
+		return file
+	}
+	return &tracedFile{File: file, ctx: ctx}
+}
+
+func (f *tracedFile) ReadAt(p []byte, offset int64) (int, error) {
+	f.mutex.Lock()
+	traced := f.spans < maxIOSpans
+	if traced {
+		f.spans++
+	} else {
+		f.dropped++
+	}
+	f.mutex.Unlock()
+
+	var span *Span
+	if traced {
+		_, span = Start(f.ctx, "file.read")
+		span.SetAttribute("varlog.offset", offset)
+		span.SetAttribute("varlog.length", len(p))
+	}
+	n, err := f.File.ReadAt(p, offset)
+	span.SetAttribute("varlog.bytes_read", n)
+	if err != nil && err != io.EOF {
+		span.SetError(err)
+	}
+	span.End()
+
+	f.mutex.Lock()
+	f.reads++
+	f.bytes += int64(n)
+	f.mutex.Unlock()
+	return n, err
+}
+
+func (f *tracedFile) Close() error {
+	f.mutex.Lock()
+	parent, _ := f.ctx.Value(spanKey).(*Span)
+	parent.SetAttribute("varlog.file.reads", f.reads)
+	parent.SetAttribute("varlog.file.bytes", f.bytes)
+	if f.dropped > 0 {
+		parent.SetAttribute("varlog.file.untraced_reads", f.dropped)
+	}
+	f.mutex.Unlock()
+	return f.File.Close()
+}
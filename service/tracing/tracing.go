@@ -0,0 +1,291 @@
+// Package tracing records OpenTelemetry spans for requests, so a slow
+// read shows up in the distributed trace of the gateway that called
+// varlog.  With -otlp-endpoint, spans are exported to a collector as
+// OTLP/HTTP JSON; without it, nothing is recorded.
+//
+// A request's span continues the caller's trace, given by the W3C
+// traceparent header; otherwise it starts a new one.  Handlers and the
+// code they call add child spans with Start:
+//
+//	ctx, span := tracing.Start(ctx, "reverse")
+//	defer span.End()
+//	span.SetAttribute("varlog.bytes_read", n)
+//
+// Span methods do nothing on a nil span, as Start gives when tracing
+// is off, so callers need not check.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"varlog/service/app"
+)
+
+// Span kinds, as OTLP numbers them.
+const (
+	kindInternal = 1
+	kindServer   = 2
+)
+
+// HdrTraceparent is the W3C trace context header.
+const HdrTraceparent = "traceparent"
+
+type contextKey int
+
+// The context key of the current *Span, the parent of spans started
+// under it.
+const spanKey contextKey = 0
+
+// Identifies a span within its trace.
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+// A key and value describing a span.  Values are strings, bools,
+// integers, or floats.
+type attribute struct {
+	key   string
+	value interface{}
+}
+
+// Span is one timed operation in a trace.
+type Span struct {
+	spanContext
+	parent [8]byte // Zero for a root span
+	name   string
+	kind   int
+	start  time.Time
+
+	mutex      sync.Mutex
+	end        time.Time
+	attributes []attribute
+	err        string // Status message, empty unless the operation failed
+	ended      bool
+}
+
+var (
+	mutex   sync.Mutex
+	current *exporter // Nil when tracing is off
+	ratio   float64   // Fraction of new traces sampled
+)
+
+// Setup starts exporting spans to the collector named by the
+// properties, if any, and stops an exporter started before.
+func Setup(props *app.Properties) error {
+	var e *exporter
+	if props.OTLPEndpoint() != "" {
+		var err error
+		if e, err = newExporter(props.OTLPEndpoint(), props.OTLPHeaders()); err != nil {
+			return err
+		}
+	}
+	mutex.Lock()
+	old := current
+	current = e
+	ratio = props.TraceRatio()
+	mutex.Unlock()
+	if old != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		defer cancel()
+		old.shutdown(ctx)
+	}
+	return nil
+}
+
+// Enabled reports whether spans are exported.
+func Enabled() bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return current != nil
+}
+
+// Shutdown exports the spans not yet sent, as the service stops.
+func Shutdown(ctx context.Context) error {
+	mutex.Lock()
+	e := current
+	current = nil
+	mutex.Unlock()
+	if e == nil {
+		return nil
+	}
+	return e.shutdown(ctx)
+}
+
+// Start begins a span as a child of the span in the context, if any,
+// giving a context holding the new span.  With tracing off, or
+// outside a sampled trace, it gives the context unchanged and a nil
+// span.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	parent, ok := ctx.Value(spanKey).(*Span)
+	if !ok || parent == nil || !parent.sampled || !Enabled() {
+		return ctx, nil
+	}
+	span := newSpan(parent.spanContext, name, kindInternal)
+	return context.WithValue(ctx, spanKey, span), span
+}
+
+// newSpan creates a span in the parent's trace, or in a new trace
+// if the parent has no trace ID.
+func newSpan(parent spanContext, name string, kind int) *Span {
+	span := &Span{name: name, kind: kind, start: time.Now()}
+	span.spanContext = parent
+	if parent.traceID == ([16]byte{}) {
+		rand.Read(span.traceID[:])
+		span.sampled = sample(span.traceID)
+	} else {
+		span.parent = parent.spanID
+	}
+	rand.Read(span.spanID[:])
+	return span
+}
+
+// sample decides whether a new trace is recorded, from the low bytes
+// of its ID, so every service sampling at a ratio agrees.
+func sample(traceID [16]byte) bool {
+	mutex.Lock()
+	r := ratio
+	mutex.Unlock()
+	if r >= 1 {
+		return true
+	}
+	return float64(binary.BigEndian.Uint64(traceID[8:])>>11) < r*(1<<53)
+}
+
+// SetAttribute adds a key and value describing the span.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.attributes = append(s.attributes, attribute{key, value})
+}
+
+// SetError marks the span failed, with the error as its status.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.err = err.Error()
+}
+
+// End ends the span and queues it for export.  Later calls do nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mutex.Unlock()
+	mutex.Lock()
+	e := current
+	mutex.Unlock()
+	if e != nil {
+		e.add(s)
+	}
+}
+
+// TraceID gives the span's trace ID in hex, or the empty string for
+// a nil span.
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.traceID[:])
+}
+
+// traceparent formats the span's context as a traceparent header.
+func (s *Span) traceparent() string {
+	flags := "00"
+	if s.sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%x-%x-%s", s.traceID, s.spanID, flags)
+}
+
+// parseTraceparent parses a traceparent header, version 00 or a later
+// version with the same leading fields, as the W3C recommendation says.
+func parseTraceparent(value string) (spanContext, bool) {
+	var sc spanContext
+	fields := strings.Split(strings.TrimSpace(value), "-")
+	if len(fields) < 4 || len(fields[0]) != 2 || fields[0] == "ff" ||
+		(fields[0] == "00" && len(fields) != 4) {
+		return sc, false
+	}
+	version, err1 := hex.DecodeString(fields[0])
+	traceID, err2 := hex.DecodeString(fields[1])
+	spanID, err3 := hex.DecodeString(fields[2])
+	flags, err4 := hex.DecodeString(fields[3])
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil ||
+		len(version) != 1 || len(traceID) != 16 || len(spanID) != 8 || len(flags) != 1 {
+		return sc, false
+	}
+	copy(sc.traceID[:], traceID)
+	copy(sc.spanID[:], spanID)
+	if sc.traceID == ([16]byte{}) || sc.spanID == ([8]byte{}) {
+		return spanContext{}, false
+	}
+	// Hex digits must be lower case.
+	if strings.ToLower(value) != value {
+		return spanContext{}, false
+	}
+	sc.sampled = flags[0]&1 != 0
+	return sc, true
+}
+
+// Wrap returns a handler that records a server span for each request,
+// named for the method and endpoint, as "GET /read".  The span
+// continues the trace in the request's traceparent header, and is
+// sampled if the caller's is; without one, -trace-ratio decides.
+// The response's traceparent header names the span, so a client can
+// find the trace.
+func Wrap(endpoint string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if !Enabled() {
+			handler(writer, request)
+			return
+		}
+		parent, _ := parseTraceparent(request.Header.Get(HdrTraceparent))
+		span := newSpan(parent, request.Method+" "+endpoint, kindServer)
+		writer.Header().Set(HdrTraceparent, span.traceparent())
+		if !span.sampled {
+			handler(writer, request)
+			return
+		}
+		span.SetAttribute("http.request.method", request.Method)
+		span.SetAttribute("http.route", endpoint)
+		span.SetAttribute("url.path", request.URL.Path)
+		span.SetAttribute("url.query", request.URL.RawQuery)
+		span.SetAttribute("client.address", request.RemoteAddr)
+		if id := app.RequestID(request.Context()); id != "" {
+			span.SetAttribute("varlog.request_id", id)
+		}
+		recorder := app.NewResponseRecorder(writer)
+		handler(recorder, request.WithContext(context.WithValue(request.Context(), spanKey, span)))
+		span.SetAttribute("http.response.status_code", recorder.Status)
+		if recorder.Status >= http.StatusInternalServerError {
+			span.SetError(errors.New(fmt.Sprintf("status %d", recorder.Status)))
+		}
+		if request.Context().Err() != nil {
+			span.SetAttribute("varlog.canceled", true)
+		}
+		span.End()
+	}
+}
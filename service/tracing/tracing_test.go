@@ -0,0 +1,178 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"varlog/service/app"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		value   string
+		ok      bool
+		sampled bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01", false, false},
+		{"", false, false},
+	}
+	for _, test := range tests {
+		sc, ok := parseTraceparent(test.value)
+		if ok != test.ok || sc.sampled != test.sampled {
+			t.Errorf("%q: expected %v %v, got %v %v", test.value, test.ok, test.sampled, ok, sc.sampled)
+		}
+	}
+}
+
+// A collector that keeps the spans it receives, by name.
+type testCollector struct {
+	server *httptest.Server
+	mutex  sync.Mutex
+	spans  map[string]otlpSpan
+	header http.Header
+}
+
+func newTestCollector(t *testing.T) *testCollector {
+	c := &testCollector{spans: map[string]otlpSpan{}}
+	c.server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var body otlpRequest
+		if request.URL.Path != tracesPath || json.NewDecoder(request.Body).Decode(&body) != nil {
+			http.Error(writer, "bad request", http.StatusBadRequest)
+			return
+		}
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		c.header = request.Header
+		for _, rs := range body.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					c.spans[span.Name] = span
+				}
+			}
+		}
+	}))
+	t.Cleanup(c.server.Close)
+	return c
+}
+
+// setup starts tracing to the collector, sampling at the ratio.
+func setup(t *testing.T, c *testCollector, ratio float64) {
+	props := app.DefaultProperties()
+	props.SetOTLP(c.server.URL, []string{"x-api-key=s3cret"})
+	props.SetTraceRatio(ratio)
+	if err := Setup(props); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Setup(app.DefaultProperties()) })
+}
+
+// A file whose reads always succeed.
+type testFile struct{ app.File }
+
+func (testFile) ReadAt(p []byte, offset int64) (int, error) { return len(p), nil }
+func (testFile) Close() error                               { return nil }
+
+func TestWrap(t *testing.T) {
+	c := newTestCollector(t)
+	setup(t, c, 1)
+	handler := Wrap("/read", func(writer http.ResponseWriter, request *http.Request) {
+		ctx, span := Start(request.Context(), "reverse")
+		file := File(ctx, testFile{})
+		for j := 0; j < maxIOSpans+5; j++ {
+			file.ReadAt(make([]byte, 10), int64(10*j))
+		}
+		file.Close()
+		span.End()
+		writer.WriteHeader(http.StatusInternalServerError)
+	})
+	request := httptest.NewRequest("GET", "/read?name=app.log", nil)
+	request.Header.Set(HdrTraceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+	if err := Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if h := recorder.Header().Get(HdrTraceparent); !strings.HasPrefix(h, "00-4bf92f3577b34da6a3ce929d0e0e4736-") || !strings.HasSuffix(h, "-01") {
+		t.Errorf("expected the caller's trace in the response header, got %q", h)
+	}
+	server, reverse, read := c.spans["GET /read"], c.spans["reverse"], c.spans["file.read"]
+	if server.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || server.ParentSpanID != "00f067aa0ba902b7" || server.Kind != kindServer {
+		t.Errorf("server span: expected the caller's trace and parent, got %+v", server)
+	}
+	if server.Status == nil || server.Status.Code != 2 {
+		t.Errorf("server span: expected an error status, got %+v", server.Status)
+	}
+	if reverse.TraceID != server.TraceID || reverse.ParentSpanID != server.SpanID {
+		t.Errorf("reverse span: expected the server span as parent, got %+v", reverse)
+	}
+	if read.ParentSpanID != reverse.SpanID {
+		t.Errorf("read span: expected the reverse span as parent, got %+v", read)
+	}
+	attributes := map[string]interface{}{}
+	for _, kv := range reverse.Attributes {
+		for _, v := range kv.Value {
+			attributes[kv.Key] = v
+		}
+	}
+	if attributes["varlog.file.reads"] != "105" || attributes["varlog.file.untraced_reads"] != "5" {
+		t.Errorf("reverse span: expected 105 reads, 5 untraced, got %v", attributes)
+	}
+	if c.header.Get("X-Api-Key") != "s3cret" {
+		t.Errorf("expected the -otlp-header, got %v", c.header)
+	}
+}
+
+func TestWrap_sampling(t *testing.T) {
+	tests := []struct {
+		traceparent string
+		exported    bool
+	}{
+		{"", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", false},
+	}
+	for _, test := range tests {
+		c := newTestCollector(t)
+		setup(t, c, 0)
+		var child *Span
+		handler := Wrap("/list", func(writer http.ResponseWriter, request *http.Request) {
+			_, child = Start(request.Context(), "readdir")
+			child.End()
+		})
+		request := httptest.NewRequest("GET", "/list", nil)
+		request.Header.Set(HdrTraceparent, test.traceparent)
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		Shutdown(context.Background())
+		if _, ok := c.spans["GET /list"]; ok != test.exported || (child != nil) != test.exported {
+			t.Errorf("%q: expected exported %v, got %v", test.traceparent, test.exported, ok)
+		}
+		if recorder.Header().Get(HdrTraceparent) == "" {
+			t.Errorf("%q: expected a traceparent response header", test.traceparent)
+		}
+	}
+}
+
+func TestStart_disabled(t *testing.T) {
+	Setup(app.DefaultProperties())
+	ctx := context.Background()
+	if got, span := Start(ctx, "reverse"); got != ctx || span != nil {
+		t.Errorf("expected no span with tracing off")
+	}
+	var span *Span
+	span.SetAttribute("key", 1)
+	span.End()
+}
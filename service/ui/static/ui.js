@@ -0,0 +1,294 @@
+// The varlog web interface.  The location hash holds the view:
+// "#list=DIR" browses a directory, "#read=FILE" reads a file, and an
+// empty hash lists the top.  Requests use relative URLs, so the page
+// works under a -base-path prefix.
+"use strict";
+
+const tokenKey = "varlog-token";
+
+// Lines each live-tail poll reads, and lines kept on the page.
+const tailWindow = 1000;
+const maxShownLines = 5000;
+const tailInterval = 2000;
+
+// Lines remembered to find the tail position again.  /read has no
+// offsets, so each poll finds where these lines occur.
+const tailMemory = 10;
+
+const levelClasses = [
+  [/\b(emerg|alert|crit|critical|fatal|panic|err|error)\b/i, "error"],
+  [/\b(warn|warning)\b/i, "warning"],
+  [/\b(debug|trace)\b/i, "debug"],
+];
+
+const $ = (id) => document.getElementById(id);
+
+let current = "";     // Name of the listed directory or read file
+let tailTimer = null;
+let seen = [];        // Newest lines shown, most recent first
+
+function parseHash() {
+  const hash = new URLSearchParams(location.hash.slice(1));
+  if (hash.has("read")) {
+    return { view: "read", name: hash.get("read") };
+  }
+  return { view: "list", name: hash.get("list") || "" };
+}
+
+function showError(message) {
+  $("error").textContent = message;
+  $("error").hidden = !message;
+}
+
+function authHeaders() {
+  const token = sessionStorage.getItem(tokenKey);
+  return token ? { Authorization: "Bearer " + token } : {};
+}
+
+// get fetches an endpoint, throwing the message of the service's
+// error envelope on failure.
+async function get(endpoint, params) {
+  const response = await fetch(endpoint + "?" + new URLSearchParams(params), {
+    headers: authHeaders(),
+    credentials: "same-origin",
+  });
+  if (response.ok) {
+    return response;
+  }
+  if (response.status === 401) {
+    $("login").hidden = false;
+  }
+  let message = response.status + " " + response.statusText;
+  try {
+    const body = await response.json();
+    if (body.error && body.error.message) {
+      message = body.error.message;
+    }
+  } catch (e) {
+    // Not an error envelope; keep the status.
+  }
+  throw new Error(message);
+}
+
+function showCrumbs(name, isFile) {
+  const crumbs = $("crumbs");
+  crumbs.replaceChildren();
+  const parts = name ? name.split("/") : [];
+  parts.forEach((part, i) => {
+    const a = document.createElement("a");
+    const prefix = parts.slice(0, i + 1).join("/");
+    const last = i === parts.length - 1;
+    a.href = "#" + new URLSearchParams(last && isFile ? { read: prefix } : { list: prefix });
+    a.textContent = part;
+    crumbs.append(a);
+  });
+}
+
+async function showList(name) {
+  $("browser").hidden = false;
+  $("viewer").hidden = true;
+  showCrumbs(name, false);
+  const params = {};
+  if (name) {
+    params.name = name;
+  }
+  const filter = $("list-filter").value;
+  if (filter) {
+    params.filter = filter;
+  }
+  const entries = await (await get("list", params)).json();
+  const rows = entries.map((entry) => {
+    const tr = document.createElement("tr");
+    const a = document.createElement("a");
+    const isDir = entry.type === "dir";
+    a.href = "#" + new URLSearchParams(isDir ? { list: entry.name } : { read: entry.name });
+    a.textContent = entry.name.split("/").pop() + (isDir ? "/" : "");
+    const name = document.createElement("td");
+    name.append(a);
+    const type = document.createElement("td");
+    type.textContent = entry.type;
+    tr.append(name, type);
+    return tr;
+  });
+  $("entries").replaceChildren(...rows);
+}
+
+function readParams(name, withCount) {
+  const params = { name: name };
+  const filter = $("read-filter").value;
+  if (filter) {
+    params.filter = filter;
+  }
+  if (withCount && $("read-count").value) {
+    params.count = $("read-count").value;
+  }
+  if ($("read-multiline").checked && !$("tail").checked) {
+    params.multiline = "true";
+  }
+  return params;
+}
+
+// readLines gives the lines of /read, most recent first.
+async function readLines(params) {
+  const text = await (await get("read", params)).text();
+  const lines = text.split("\n");
+  if (lines[lines.length - 1] === "") {
+    lines.pop();
+  }
+  return lines;
+}
+
+function lineElement(line, isNew) {
+  const span = document.createElement("span");
+  span.textContent = line + "\n";
+  for (const [pattern, cls] of levelClasses) {
+    if (pattern.test(line)) {
+      span.classList.add(cls);
+      break;
+    }
+  }
+  if (isNew) {
+    span.classList.add("new");
+  }
+  return span;
+}
+
+async function showRead(name) {
+  $("browser").hidden = true;
+  $("viewer").hidden = false;
+  showCrumbs(name, true);
+  updateDownload(name);
+  $("status").textContent = "Reading…";
+  const lines = await readLines(readParams(name, true));
+  seen = lines.slice(0, tailMemory);
+  $("lines").replaceChildren(...lines.map((line) => lineElement(line, false)));
+  $("status").textContent = lines.length + " lines, most recent first";
+}
+
+// newLines gives the lines of batch, most recent first, that are newer
+// than the remembered lines, or null when they are not found, as after
+// a rotation.  The most recent occurrence is the position, so a poll
+// without new lines gives none.
+function newLines(batch) {
+  if (seen.length === 0) {
+    return batch;
+  }
+  for (let i = 0; i + seen.length <= batch.length; i++) {
+    if (seen.every((line, j) => batch[i + j] === line)) {
+      return batch.slice(0, i);
+    }
+  }
+  return null;
+}
+
+async function pollTail() {
+  const params = readParams(current, false);
+  params.count = tailWindow;
+  let batch;
+  try {
+    batch = await readLines(params);
+  } catch (e) {
+    $("status").textContent = "Tail: " + e.message;
+    return;
+  }
+  let fresh = newLines(batch);
+  if (fresh === null) {
+    $("status").textContent = "Tail: position lost (rotated, or many new lines)";
+    fresh = batch;
+  } else {
+    $("status").textContent = "Tailing, most recent first";
+  }
+  seen = batch.slice(0, tailMemory);
+  const pre = $("lines");
+  for (const old of pre.querySelectorAll(".new")) {
+    old.classList.remove("new");
+  }
+  pre.prepend(...fresh.map((line) => lineElement(line, true)));
+  while (pre.childElementCount > maxShownLines) {
+    pre.lastElementChild.remove();
+  }
+}
+
+function setTail(enabled) {
+  clearInterval(tailTimer);
+  tailTimer = enabled ? setInterval(pollTail, tailInterval) : null;
+}
+
+// updateDownload links to the whole filtered file.  With a token, the
+// link fetches the file itself, since a plain link cannot send it.
+function updateDownload(name) {
+  const params = readParams(name, false);
+  params["content-disposition"] = "attachment";
+  $("download").href = "read?" + new URLSearchParams(params);
+  $("download").download = name.split("/").pop();
+}
+
+async function download(event) {
+  if (!sessionStorage.getItem(tokenKey)) {
+    return;
+  }
+  event.preventDefault();
+  try {
+    const params = readParams(current, false);
+    params["content-disposition"] = "attachment";
+    const blob = await (await get("read", params)).blob();
+    const a = document.createElement("a");
+    a.href = URL.createObjectURL(blob);
+    a.download = current.split("/").pop();
+    a.click();
+    URL.revokeObjectURL(a.href);
+  } catch (e) {
+    showError(e.message);
+  }
+}
+
+async function route() {
+  setTail(false);
+  $("tail").checked = false;
+  showError("");
+  const { view, name } = parseHash();
+  current = name;
+  try {
+    if (view === "read") {
+      await showRead(name);
+    } else {
+      await showList(name);
+    }
+  } catch (e) {
+    $("status").textContent = "";
+    showError(e.message);
+  }
+}
+
+async function reread(event) {
+  event.preventDefault();
+  const tailing = $("tail").checked;
+  setTail(false);
+  showError("");
+  try {
+    await showRead(current);
+    setTail(tailing);
+  } catch (e) {
+    showError(e.message);
+  }
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+  window.addEventListener("hashchange", route);
+  $("list-form").addEventListener("submit", (event) => {
+    event.preventDefault();
+    route();
+  });
+  $("read-form").addEventListener("submit", reread);
+  $("tail").addEventListener("change", () => setTail($("tail").checked));
+  $("read-filter").addEventListener("change", () => updateDownload(current));
+  $("download").addEventListener("click", download);
+  $("login").addEventListener("submit", (event) => {
+    event.preventDefault();
+    sessionStorage.setItem(tokenKey, $("token").value);
+    $("token").value = "";
+    $("login").hidden = true;
+    route();
+  });
+  route();
+});
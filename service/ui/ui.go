@@ -0,0 +1,52 @@
+// Package ui serves the web interface: a small page that browses
+// directories with /list and reads files with /read, with filtering,
+// live tail, and downloads.  A browser works against the raw
+// endpoints too, but JSON and walls of text suit programs better
+// than support staff.
+//
+// The page holds no data itself, so it is served without
+// authentication; its requests to the endpoints carry the user's
+// credentials.  Links are relative, so the page works under -base-path.
+package ui
+
+import (
+	"bytes"
+	"embed"
+	"net/http"
+	"path"
+	"time"
+	"varlog/service/app"
+)
+
+//go:embed static
+var static embed.FS
+
+// The page's files, by URL path.
+var files = map[string]string{
+	"/":       "static/index.html",
+	"/ui.js":  "static/ui.js",
+	"/ui.css": "static/ui.css",
+}
+
+// The scripts and styles come only from the service itself.
+const contentSecurityPolicy = "default-src 'self'; frame-ancestors 'none'"
+
+// Handler serves the interface's files.  It is registered at /, so
+// other paths without an endpoint get 404, as without the interface.
+func Handler(writer http.ResponseWriter, request *http.Request) {
+	name, ok := files[request.URL.Path]
+	if !ok {
+		app.Error(writer, request, "Not found", http.StatusNotFound)
+		return
+	}
+	b, err := static.ReadFile(name)
+	if err != nil {
+		app.WriteError(writer, request, err)
+		return
+	}
+	header := writer.Header()
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Content-Security-Policy", contentSecurityPolicy)
+	header.Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(writer, request, path.Base(name), time.Time{}, bytes.NewReader(b))
+}
@@ -0,0 +1,30 @@
+package ui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		path        string
+		status      int
+		contentType string
+	}{
+		{"/", http.StatusOK, "text/html"},
+		{"/ui.js", http.StatusOK, "text/javascript"},
+		{"/ui.css", http.StatusOK, "text/css"},
+		{"/static/index.html", http.StatusNotFound, "application/json"},
+		{"/read.html", http.StatusNotFound, "application/json"},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		Handler(recorder, httptest.NewRequest("GET", test.path, nil))
+		contentType := recorder.Header().Get("Content-Type")
+		if recorder.Code != test.status || !strings.HasPrefix(contentType, test.contentType) {
+			t.Errorf("%s: expected %d %s, got %d %s", test.path, test.status, test.contentType, recorder.Code, contentType)
+		}
+	}
+}
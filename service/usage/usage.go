@@ -0,0 +1,260 @@
+// Package usage provides code for the /usage service endpoint, a disk
+// usage report for capacity alerts, without shell access to du.
+//
+// Parameter 'name=path' selects a directory, as for /list; an empty or
+// missing value reports the root, or every mount.  The response gives
+// the directory's totals and those of each directory below it: bytes,
+// file counts, the oldest and newest modification times, and an
+// estimate of growth.  Each directory's totals include those below it.
+// Only entries the principal may read are counted, and denied ones are
+// skipped, so the report shows no more than /list does.
+//
+// Growth is estimated from the totals seen by earlier requests: the
+// service keeps a sample of each directory's bytes for up to a day, so
+// the first request after a restart has no estimate.  Rotation that
+// removes old files makes an estimate low, or negative.
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"sync"
+	"time"
+	"varlog/service/app"
+)
+
+const (
+	// Entries counted before the walk stops, reporting truncated.
+	maxEntries = 100000
+
+	// Growth estimates need samples at least this old, and samples
+	// are replaced once this old.
+	minGrowthInterval = time.Minute
+	maxGrowthInterval = 24 * time.Hour
+
+	// Directories sampled for growth.  Beyond this, samples are dropped.
+	maxSamples = 10000
+)
+
+// Totals of a directory and those below it.
+type totals struct {
+	Name   string     `json:"name"` // Relative to the root, "" for the root itself
+	Bytes  int64      `json:"bytes"`
+	Files  int        `json:"files"`
+	Oldest *time.Time `json:"oldest,omitempty"` // Modification times, nil without files
+	Newest *time.Time `json:"newest,omitempty"`
+
+	// Bytes added per hour, estimated from an earlier sample; nil
+	// without one.
+	Growth *float64 `json:"growth_bytes_per_hour,omitempty"`
+}
+
+// The response.
+type report struct {
+	Name        string    `json:"name"`
+	Total       *totals   `json:"total"`
+	Directories []*totals `json:"directories"` // Below the named one, sorted by name
+	Truncated   bool      `json:"truncated"`   // The walk stopped at maxEntries
+}
+
+// A sample of a directory's bytes.
+type sample struct {
+	time  time.Time
+	bytes int64
+}
+
+var (
+	mutex   sync.Mutex
+	samples = map[string]sample{} // Principal and relative name => earliest sample
+)
+
+// Handler serves /usage.
+func Handler(writer http.ResponseWriter, request *http.Request) {
+	props := app.RequestProperties(request)
+	if err := props.ExtractParams(request); err != nil {
+		app.WriteError(writer, request, err)
+		return
+	}
+	if app.Denied(props.RelativePath()) {
+		app.Log(app.LogWarning, "Denied path %q requested", props.RelativePath())
+		app.Error(writer, request, "Not found", http.StatusNotFound)
+		return
+	}
+	if !props.AuthorizedToTraverse(props.RelativePath()) {
+		app.Log(app.LogWarning, "Principal %q not authorized for %q", props.Principal(), props.RelativePath())
+		app.Error(writer, request, "Access denied", http.StatusForbidden)
+		return
+	}
+	w := &walker{ctx: request.Context(), now: time.Now().UTC()}
+	var top *totals
+	var err error
+	if props.MountTop() {
+		top = &totals{}
+		for _, mount := range props.Mounts() {
+			w.addMount(top, props, mount.Name)
+		}
+	} else {
+		top, err = w.walkTop(props)
+	}
+	if err != nil {
+		app.WriteError(writer, request, err)
+		return
+	}
+	if err := request.Context().Err(); err != nil {
+		return
+	}
+	w.estimateGrowth(props.Principal(), append(w.dirs, top))
+	sort.Slice(w.dirs, func(i, j int) bool { return w.dirs[i].Name < w.dirs[j].Name })
+	b, err := json.Marshal(report{Name: props.RelativePath(), Total: top, Directories: w.dirs, Truncated: w.truncated})
+	if err != nil {
+		app.Error(writer, request, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(append(b, '\n'))
+}
+
+// A walker gathers the totals of a tree.
+type walker struct {
+	ctx       context.Context
+	now       time.Time
+	dirs      []*totals // Directories below the top
+	entries   int
+	truncated bool
+}
+
+// walkTop gives the totals of the named directory, with the overlays
+// at the top of a single root.
+func (w *walker) walkTop(props *app.Properties) (*totals, error) {
+	info, err := app.Stat(props.FileSystem(), props.RootedPath())
+	if err != nil {
+		return nil, app.FileError(props.RelativePath(), err)
+	}
+	if !info.IsDir() {
+		return nil, app.ParamError(app.ParamName,
+			fmt.Sprintf("Usage of %q, not a directory, not reported", props.RelativePath()))
+	}
+	top := &totals{Name: props.RelativePath()}
+	if err := w.walk(props, top); err != nil {
+		return nil, err
+	}
+	if props.Mount() == "" && props.RelativePath() == "" {
+		for _, o := range props.Overlays() {
+			w.addMount(top, props, o.Name)
+		}
+	}
+	return top, nil
+}
+
+// addMount adds the totals of the mount, or overlay, to the top.
+// Mounts the principal may not traverse, or that cannot be read, are
+// skipped, as /list skips them.
+func (w *walker) addMount(top *totals, props *app.Properties, name string) {
+	if app.Denied(name) || !props.AuthorizedToTraverse(name) {
+		return
+	}
+	mount := props.Copy()
+	if err := mount.SetParamName(name); err != nil {
+		return
+	}
+	dir := &totals{Name: name}
+	if err := w.walk(mount, dir); err != nil {
+		app.Log(app.LogWarning, "Usage of %q skipped, %s", name, err)
+		return
+	}
+	w.dirs = append(w.dirs, dir)
+	top.add(dir)
+}
+
+// walk adds the directory's entries to its totals, recording those
+// of the directories below it.  Symbolic links are not followed.
+func (w *walker) walk(props *app.Properties, dir *totals) error {
+	entries, err := app.ReadDir(props.FileSystem(), props.RootedPath())
+	if err != nil {
+		return app.FileError(props.RelativePath(), err)
+	}
+	for _, entry := range entries {
+		if w.ctx.Err() != nil {
+			return nil
+		}
+		if w.entries >= maxEntries {
+			w.truncated = true
+			return nil
+		}
+		w.entries++
+		child := props.Copy()
+		child.SetRootedPath(path.Join(props.RootedPath(), entry.Name()))
+		name := child.RelativePath()
+		if app.Denied(name) {
+			continue
+		}
+		switch {
+		case entry.IsDir():
+			if !child.AuthorizedToTraverse(name) {
+				continue
+			}
+			sub := &totals{Name: name}
+			if err := w.walk(child, sub); err != nil {
+				// An unreadable directory counts as empty.
+				app.Log(app.LogDebug, "Usage of %q skipped, %s", name, err)
+			}
+			w.dirs = append(w.dirs, sub)
+			dir.add(sub)
+
+		case entry.Type().IsRegular():
+			if !child.Authorized(name) {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			modTime := info.ModTime().UTC()
+			dir.add(&totals{Bytes: info.Size(), Files: 1, Oldest: &modTime, Newest: &modTime})
+		}
+	}
+	return nil
+}
+
+// add adds the other totals to these.
+func (t *totals) add(other *totals) {
+	t.Bytes += other.Bytes
+	t.Files += other.Files
+	if other.Oldest != nil && (t.Oldest == nil || other.Oldest.Before(*t.Oldest)) {
+		t.Oldest = other.Oldest
+	}
+	if other.Newest != nil && (t.Newest == nil || other.Newest.After(*t.Newest)) {
+		t.Newest = other.Newest
+	}
+}
+
+// estimateGrowth sets the directories' growth from their samples, and
+// samples those without one, or with one too old.  Principals see
+// different totals, so each has its own samples.  A truncated walk
+// undercounts, so it neither estimates nor samples.
+func (w *walker) estimateGrowth(principal string, dirs []*totals) {
+	if w.truncated {
+		return
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, dir := range dirs {
+		key := principal + "\x00" + dir.Name
+		s, ok := samples[key]
+		age := w.now.Sub(s.time)
+		if ok && age >= minGrowthInterval {
+			growth := float64(dir.Bytes-s.bytes) / age.Hours()
+			dir.Growth = &growth
+		}
+		if !ok || age > maxGrowthInterval {
+			if len(samples) >= maxSamples {
+				samples = map[string]sample{}
+			}
+			samples[key] = sample{time: w.now, bytes: dir.Bytes}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package usage
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+	"varlog/service/apptest"
+)
+
+func TestHandler(t *testing.T) {
+	tree := apptest.NewTree().
+		File("syslog", "0123456789").
+		File("nginx/access.log", "GET /a 200", "GET /b 404").
+		File("nginx/old/access.log.1", "GET /c 200").
+		Dir("empty")
+	fsys := tree.MapFS("/var/log")
+	modTime := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	fsys["var/log/nginx/old/access.log.1"].ModTime = modTime
+	fsys["var/log/nginx/access.log"].ModTime = modTime.Add(time.Hour)
+	fsys["var/log/syslog"].ModTime = modTime.Add(time.Hour)
+	props := apptest.Properties(fsys, "/var/log")
+
+	get := func(params ...string) (int, report) {
+		recorder := apptest.Serve(Handler, props, apptest.Request("/usage", params...))
+		var r report
+		if recorder.Code == http.StatusOK {
+			if err := json.Unmarshal(recorder.Body.Bytes(), &r); err != nil {
+				t.Fatalf("%s: %s", recorder.Body, err)
+			}
+		}
+		return recorder.Code, r
+	}
+	code, r := get()
+	if code != http.StatusOK || r.Total.Bytes != 11+22+11 || r.Total.Files != 3 || r.Truncated {
+		t.Fatalf("expected 44 bytes in 3 files, got %d %+v", code, r.Total)
+	}
+	var names []string
+	for _, dir := range r.Directories {
+		names = append(names, dir.Name)
+		if dir.Growth != nil {
+			t.Errorf("%s: expected no growth on the first request", dir.Name)
+		}
+	}
+	if len(names) != 3 || names[0] != "empty" || names[1] != "nginx" || names[2] != "nginx/old" {
+		t.Errorf("unexpected directories %q", names)
+	}
+	if nginx := r.Directories[1]; nginx.Bytes != 33 || nginx.Files != 2 ||
+		!nginx.Oldest.Equal(modTime) || !nginx.Newest.Equal(modTime.Add(time.Hour)) {
+		t.Errorf("nginx: unexpected totals %+v", nginx)
+	}
+	if empty := r.Directories[0]; empty.Files != 0 || empty.Oldest != nil {
+		t.Errorf("empty: unexpected totals %+v", empty)
+	}
+
+	// Growth is estimated from a sample an hour old.
+	mutex.Lock()
+	samples["\x00nginx"] = sample{time: time.Now().Add(-time.Hour), bytes: 13}
+	mutex.Unlock()
+	code, r = get("name", "nginx")
+	if code != http.StatusOK || r.Name != "nginx" || r.Total.Growth == nil ||
+		*r.Total.Growth < 19 || *r.Total.Growth > 20 {
+		t.Errorf("expected about 20 bytes per hour, got %d %+v", code, r.Total)
+	}
+
+	if code, _ := get("name", "syslog"); code != http.StatusBadRequest {
+		t.Errorf("file: expected 400, got %d", code)
+	}
+	if code, _ := get("name", "missing"); code != http.StatusNotFound {
+		t.Errorf("missing: expected 404, got %d", code)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package main
+
+import "os"
+
+// Without SIGUSR1 and SIGUSR2, as on Windows, debug logging is set
+// with -log-level or /admin/log-level, and there are no upgrades.
+var (
+	sigDebug   os.Signal
+	sigUpgrade os.Signal
+)
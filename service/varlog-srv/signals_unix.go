@@ -0,0 +1,14 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Signals that toggle debug logging and start an upgrade.
+var (
+	sigDebug   os.Signal = syscall.SIGUSR1
+	sigUpgrade os.Signal = syscall.SIGUSR2
+)
@@ -5,7 +5,8 @@
 // The primary points of interest:
 //   - It serves files from /var/log.  Under the /read endpoint,
 //     files are read backwards, presenting the newest lines first.
-//   - Communicates HTTP, so it can be exercised with a browser.
+//   - Communicates HTTP (or HTTPS with -tls-cert and -tls-key),
+//     so it can be exercised with a browser.
 //   - It provides two endpoints: /list and /read.  List generates a
 //     list of files and directories under a given path.  Read
 //     opens a file (only), reads lines in reverse order, and
@@ -15,33 +16,163 @@
 //     The filter also can be negative, filter=-text, to omit lines
 //     that contain the given text.
 //
-// See the README for full details.
+// The service itself lives in the server package, so other
+// applications can embed it.  See the README for full details.
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
-	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 	"varlog/service/app"
-	"varlog/service/list"
 	"varlog/service/read"
+	"varlog/service/server"
+)
+
+const (
+	// Time allowed for requests in progress at SIGTERM.
+	stopTimeout = 30 * time.Second
+
+	// Time allowed for the new process to serve at SIGUSR2.
+	upgradeTimeout = 30 * time.Second
 )
 
 func main() {
 	// Process command line flags and arguments.
 	app.DoCli()
+	props := app.NewProperties()
+	calibrate(props)
+
+	srv, err := server.New(props)
+	if err != nil {
+		app.Log(app.LogError, "%s", err)
+		os.Exit(1)
+	}
+	listener, err := srv.Listen()
+	if err != nil {
+		app.Log(app.LogError, "listen failed, %s", err)
+		os.Exit(1)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		handleSignals(srv)
+		close(stopped)
+	}()
+	if err = srv.Serve(listener); err != nil {
+		app.Log(app.LogError, "terminating, %s", err)
+		os.Exit(1)
+	}
+	// Serve returns as stopping begins; wait for requests in progress.
+	<-stopped
+	app.Log(app.LogInfo, "terminated")
+}
 
-	// Specify the handler functions for the endpoints.
-	http.HandleFunc("/list", list.Handler)
-	http.HandleFunc("/read", read.Handler)
+// Handles process signals: SIGHUP reloads, SIGUSR1 toggles debug
+// logging, SIGUSR2 hands the socket to a new process running the
+// executable, perhaps a new version, and stops once it serves, and
+// SIGINT or SIGTERM drains and stops the server, allowing requests in
+// progress up to stopTimeout to finish.  Returns once stopped.
+func handleSignals(srv *server.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	for _, sig := range []os.Signal{sigDebug, sigUpgrade} {
+		if sig != nil {
+			signal.Notify(signals, sig)
+		}
+	}
+	level := app.LogLevel() // The level to restore after debugging
+	for sig := range signals {
+		switch sig {
+		case syscall.SIGHUP:
+			srv.Reload(context.Background())
+			continue
+		case sigDebug:
+			if app.LogLevel() == app.LogDebug {
+				app.SetLogLevel(level)
+			} else {
+				level = app.LogLevel()
+				app.SetLogLevel(app.LogDebug)
+			}
+			continue
+		case sigUpgrade:
+			if !upgrade(srv) {
+				continue
+			}
+			return
+		}
+		app.Log(app.LogInfo, "received %s", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+		srv.Drain(ctx)
+		if err := srv.Stop(ctx); err != nil {
+			app.Log(app.LogWarning, "stop incomplete, %s", err)
+		}
+		cancel()
+		return
+	}
+}
 
-	// The listener "never" returns.  The documentation says
-	// it returns a non-nil error but does not say under what conditions.
-	props := app.NewProperties()
-	s := fmt.Sprintf("localhost:%d", props.Port())
-	app.Log(app.LogInfo, "starting on %s, root %q", s, props.Root())
+// Hands the listening socket to a new process and, once it serves,
+// stops this one.  Requests in progress, such as follow=true reads,
+// have -upgrade-grace to finish.  Returns false if the new process
+// did not start serving, so this one continues.
+func upgrade(srv *server.Server) bool {
+	app.Log(app.LogInfo, "upgrading")
+	ctx, cancel := context.WithTimeout(context.Background(), upgradeTimeout)
+	pid, err := srv.Upgrade(ctx)
+	cancel()
+	if err != nil {
+		app.Log(app.LogError, "upgrade failed, continuing, %s", err)
+		return false
+	}
+	grace := srv.Properties().UpgradeGrace()
+	app.Log(app.LogInfo, "process %d now serving; finishing requests in progress, up to %s", pid, grace)
+	ctx, cancel = context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if err := srv.Stop(ctx); err != nil {
+		app.Log(app.LogWarning, "stop incomplete, %s", err)
+	}
+	return true
+}
+
+// Runs the chunk size calibration requested by -bench-io or -chunk-auto.
+// With -bench-io, reports the results and exits.  With -chunk-auto,
+// sets the chunk size in the properties, unless -chunk was given.
+func calibrate(props *app.Properties) {
+	if !app.Cli.BenchIO && !app.Cli.ChunkAuto {
+		return
+	}
+	chunkExplicit := false
+	flag.Visit(func(f *flag.Flag) {
+		chunkExplicit = chunkExplicit || f.Name == "chunk"
+	})
+	if app.Cli.ChunkAuto && !app.Cli.BenchIO && chunkExplicit {
+		return
+	}
 
-	err := http.ListenAndServe(s, nil)
-	app.Log(app.LogError, "terminating, %s", err)
-	os.Exit(1)
+	root := props.Root()
+	if mounts := props.Mounts(); len(mounts) > 0 {
+		root = mounts[0].Path
+	}
+	results, best, err := read.Calibrate(app.OSPath(root), read.CalibrateSizes)
+	if err != nil {
+		app.Log(app.LogError, "Calibration failed, %s", err)
+		if app.Cli.BenchIO {
+			os.Exit(1)
+		}
+		return
+	}
+	if app.Cli.BenchIO {
+		fmt.Printf("%10s %12s %10s\n", "chunk", "bytes", "MB/s")
+		for _, r := range results {
+			fmt.Printf("%10d %12d %10.1f\n", r.ChunkSize, r.Bytes, r.MBPerSecond())
+		}
+		fmt.Printf("suggested: -chunk=%d\n", best)
+		os.Exit(0)
+	}
+	app.Log(app.LogInfo, "Calibrated chunk size %d", best)
+	props.SetChunkSize(best)
 }